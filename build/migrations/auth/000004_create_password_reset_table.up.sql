@@ -0,0 +1,13 @@
+create table if not exists password_reset
+(
+    id         serial                              not null
+        constraint password_reset_pk
+            primary key,
+    auth_id    int                                 not null,
+    token_hash varchar(64)                         not null,
+    expires_at timestamp                           not null,
+    used_at    timestamp,
+    created_at timestamp default current_timestamp not null
+);
+create unique index if not exists password_reset_token_hash_uindex on password_reset (token_hash);
+create index if not exists password_reset_auth_id_index on password_reset (auth_id);
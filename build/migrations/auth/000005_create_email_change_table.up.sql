@@ -0,0 +1,13 @@
+create table if not exists email_change
+(
+    id         serial                              not null
+        constraint email_change_pk
+            primary key,
+    auth_id    int                                 not null,
+    email      varchar(256)                        not null,
+    code_hash  varchar(64)                         not null,
+    attempts   int       default 0                 not null,
+    expires_at timestamp                           not null,
+    created_at timestamp default current_timestamp not null
+);
+create unique index if not exists email_change_auth_id_uindex on email_change (auth_id);
@@ -0,0 +1,2 @@
+drop table if exists mfa_recovery_code;
+drop table if exists mfa;
@@ -0,0 +1,25 @@
+create table if not exists mfa
+(
+    id             serial                              not null
+        constraint mfa_pk
+            primary key,
+    auth_id        int                                 not null,
+    secret         varchar(64)                         not null,
+    enabled_at     timestamp,
+    last_used_step bigint    default 0                 not null,
+    attempts       int       default 0                 not null,
+    created_at     timestamp default current_timestamp not null
+);
+create unique index if not exists mfa_auth_id_uindex on mfa (auth_id);
+
+create table if not exists mfa_recovery_code
+(
+    id         serial                              not null
+        constraint mfa_recovery_code_pk
+            primary key,
+    auth_id    int                                 not null,
+    code_hash  varchar(64)                         not null,
+    used_at    timestamp,
+    created_at timestamp default current_timestamp not null
+);
+create index if not exists mfa_recovery_code_auth_id_index on mfa_recovery_code (auth_id);
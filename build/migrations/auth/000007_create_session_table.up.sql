@@ -0,0 +1,13 @@
+create table if not exists session
+(
+    id         varchar(32)                         not null
+        constraint session_pk
+            primary key,
+    user_id    int                                 not null,
+    user_agent varchar(512)                        not null,
+    ip         varchar(45)                         not null,
+    expires_at timestamp                           not null,
+    revoked_at timestamp,
+    created_at timestamp default current_timestamp not null
+);
+create index if not exists session_user_id_index on session (user_id);
@@ -0,0 +1,4 @@
+alter table session drop column if exists client_id;
+drop table if exists oauth_code;
+drop table if exists oauth_consent;
+drop table if exists oauth_client;
@@ -0,0 +1,45 @@
+create table if not exists oauth_client
+(
+    id            serial                              not null
+        constraint oauth_client_pk
+            primary key,
+    client_id     varchar(32)                         not null,
+    secret_hash   varchar(64)                         not null,
+    name          varchar(100)                        not null,
+    redirect_uris text[]                              not null,
+    user_id       int                                 not null,
+    created_at    timestamp default current_timestamp not null
+);
+create unique index if not exists oauth_client_client_id_uindex on oauth_client (client_id);
+
+create table if not exists oauth_consent
+(
+    id         serial                              not null
+        constraint oauth_consent_pk
+            primary key,
+    user_id    int                                 not null,
+    client_id  varchar(32)                         not null,
+    scopes     text[]                              not null,
+    created_at timestamp default current_timestamp not null,
+    updated_at timestamp default current_timestamp not null
+);
+create unique index if not exists oauth_consent_user_id_client_id_uindex on oauth_consent (user_id, client_id);
+
+create table if not exists oauth_code
+(
+    id             serial                              not null
+        constraint oauth_code_pk
+            primary key,
+    code_hash      varchar(64)                         not null,
+    client_id      varchar(32)                         not null,
+    user_id        int                                 not null,
+    redirect_uri   text                                not null,
+    scopes         text[]                              not null,
+    code_challenge varchar(128)                        not null,
+    expires_at     timestamp                           not null,
+    used_at        timestamp,
+    created_at     timestamp default current_timestamp not null
+);
+create unique index if not exists oauth_code_code_hash_uindex on oauth_code (code_hash);
+
+alter table session add column if not exists client_id varchar(32) default '' not null;
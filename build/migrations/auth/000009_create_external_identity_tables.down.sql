@@ -0,0 +1,2 @@
+drop table if exists oidc_login;
+drop table if exists external_identity;
@@ -0,0 +1,26 @@
+create table if not exists external_identity
+(
+    id         serial                              not null
+        constraint external_identity_pk
+            primary key,
+    auth_id    int                                 not null,
+    issuer     varchar(256)                        not null,
+    subject    varchar(256)                        not null,
+    created_at timestamp default current_timestamp not null
+);
+create unique index if not exists external_identity_issuer_subject_uindex on external_identity (issuer, subject);
+create index if not exists external_identity_auth_id_index on external_identity (auth_id);
+
+create table if not exists oidc_login
+(
+    id            serial                              not null
+        constraint oidc_login_pk
+            primary key,
+    state_hash    varchar(64)                         not null,
+    nonce         varchar(64)                         not null,
+    code_verifier varchar(128)                        not null,
+    expires_at    timestamp                           not null,
+    used_at       timestamp,
+    created_at    timestamp default current_timestamp not null
+);
+create unique index if not exists oidc_login_state_hash_uindex on oidc_login (state_hash);
@@ -0,0 +1,7 @@
+update "auth" set role = 'user' where role in ('moderator', 'support');
+alter type auth_role rename to auth_role_old;
+create type auth_role as enum ('user', 'admin');
+alter table "auth" alter column role drop default;
+alter table "auth" alter column role type auth_role using role::text::auth_role;
+alter table "auth" alter column role set default 'user';
+drop type auth_role_old;
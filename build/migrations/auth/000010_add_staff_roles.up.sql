@@ -0,0 +1,2 @@
+alter type auth_role add value 'moderator';
+alter type auth_role add value 'support';
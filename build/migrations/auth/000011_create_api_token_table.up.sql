@@ -0,0 +1,14 @@
+create table if not exists api_token
+(
+    id         serial                              not null
+        constraint api_token_pk
+            primary key,
+    user_id    int                                 not null,
+    name       varchar(100)                        not null,
+    token_hash varchar(64)                         not null,
+    scopes     text[]                              not null,
+    expires_at timestamp                           not null,
+    created_at timestamp default current_timestamp not null
+);
+create unique index if not exists api_token_token_hash_uindex on api_token (token_hash);
+create index if not exists api_token_user_id_index on api_token (user_id);
@@ -0,0 +1 @@
+drop table if exists external_registration;
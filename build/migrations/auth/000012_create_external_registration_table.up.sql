@@ -0,0 +1,10 @@
+create table if not exists external_registration
+(
+    id         serial                              not null
+        constraint external_registration_pk
+            primary key,
+    issuer     varchar(256)                        not null,
+    subject    varchar(256)                        not null,
+    created_at timestamp default current_timestamp not null
+);
+create unique index if not exists external_registration_issuer_subject_uindex on external_registration (issuer, subject);
@@ -0,0 +1 @@
+drop index if exists auth_lower_email_index;
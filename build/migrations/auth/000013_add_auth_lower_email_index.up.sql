@@ -0,0 +1 @@
+create index if not exists auth_lower_email_index on auth (lower(email));
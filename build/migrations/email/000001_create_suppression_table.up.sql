@@ -0,0 +1,9 @@
+create table if not exists suppression
+(
+    email      varchar(256)                        not null
+        constraint suppression_pk
+            primary key,
+    reason     varchar(32)                         not null,
+    details    text      default ''                not null,
+    created_at timestamp default current_timestamp not null
+);
@@ -0,0 +1,5 @@
+alter table registration drop column if exists code_sent_at;
+alter table registration drop column if exists resends;
+alter table registration drop column if exists attempts;
+alter table registration drop column if exists code_hash;
+alter table registration add code int default 0 not null;
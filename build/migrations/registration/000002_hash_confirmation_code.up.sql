@@ -0,0 +1,5 @@
+alter table registration drop column if exists code;
+alter table registration add code_hash varchar(64) default '' not null;
+alter table registration add attempts int default 0 not null;
+alter table registration add resends int default 0 not null;
+alter table registration add code_sent_at timestamp default current_timestamp not null;
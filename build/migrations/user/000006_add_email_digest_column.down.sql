@@ -0,0 +1,2 @@
+drop index if exists user_email_digest_index;
+alter table "user" drop column email_digest;
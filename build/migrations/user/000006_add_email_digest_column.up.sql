@@ -0,0 +1,2 @@
+alter table "user" add email_digest varchar(16) default 'weekly' not null;
+create index if not exists user_email_digest_index on "user" (email_digest);
@@ -0,0 +1 @@
+alter table follower drop column created_at;
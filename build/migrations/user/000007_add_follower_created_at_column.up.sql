@@ -0,0 +1 @@
+alter table follower add created_at timestamp default current_timestamp not null;
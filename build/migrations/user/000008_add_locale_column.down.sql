@@ -0,0 +1 @@
+alter table "user" drop column locale;
@@ -0,0 +1 @@
+alter table "user" add locale varchar(16) default 'en' not null;
@@ -10,15 +10,29 @@ import (
 	"github.com/pkg/errors"
 
 	"gitlab.com/slirx/newproj/internal/auth"
+	"gitlab.com/slirx/newproj/pkg/mtls"
+	"gitlab.com/slirx/newproj/pkg/oidc"
+	"gitlab.com/slirx/newproj/pkg/queue"
 	"gitlab.com/slirx/newproj/pkg/rabbitmq"
+	"gitlab.com/slirx/newproj/pkg/redis"
 )
 
+// defaultReservedLogins are used in case reserved logins are not configured. They are the same as the ones of
+// registration service.
+var defaultReservedLogins = []string{"admin", "edit", "settings", "profile", "user"}
+
 // Config represents combined configuration.
 type Config struct {
-	Server        Server
-	RabbitMQ      rabbitmq.Config
+	Server   Server
+	RabbitMQ rabbitmq.Config
+	// QueueBackend is queue.BackendRabbitMQ or queue.BackendMemory.
+	QueueBackend  string
+	Redis         redis.Config
 	Database      Database
 	ServiceConfig auth.Config
+	Throttle      auth.ThrottleConfig
+	// OIDC is configuration of OpenID Connect provider. Sign in through it is disabled in case Issuer is empty.
+	OIDC oidc.Config
 }
 
 type Database struct {
@@ -40,6 +54,14 @@ type Server struct {
 	CORSAllowedOrigins []string
 	// AdminCORSAllowedOrigins is a list of origins a cross-domain request can be executed from (for admin panel).
 	AdminCORSAllowedOrigins []string
+	// InternalSecrets are JWT secrets of microservices which call internal endpoints of auth service. They are the
+	// same as the ones internal tokens are signed with.
+	InternalSecrets map[string][]byte
+	// TLS enables mutual TLS, internal endpoints authenticate services by client certificates in this case.
+	TLS mtls.Config
+	// TrustedProxies are IP addresses or CIDRs of proxies in front of the server, for example envoy. IP address of
+	// the client is taken from headers set by them. Address of the peer is used in case it's empty.
+	TrustedProxies []string
 }
 
 // NewConfig returns initialized instance of configuration. It reads configuration from environment variables.
@@ -64,10 +86,92 @@ func NewConfig(prefix string) (*Config, error) {
 		return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"DB_PORT"))
 	}
 
+	var redisDB int
+	if redisDB, err = strconv.Atoi(os.Getenv(prefix + "REDIS_DB")); err != nil {
+		return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"REDIS_DB"))
+	}
+
+	var passwordResetTTLMinutes int
+
+	passwordResetTTLMinutes, err = strconv.Atoi(os.Getenv(prefix + "SERVICE_PASSWORD_RESET_TTL_MINUTES"))
+	if err != nil || passwordResetTTLMinutes <= 0 {
+		return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"SERVICE_PASSWORD_RESET_TTL_MINUTES"))
+	}
+
+	passwordResetURL := os.Getenv(prefix + "SERVICE_PASSWORD_RESET_URL")
+	if passwordResetURL == "" {
+		return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"SERVICE_PASSWORD_RESET_URL"))
+	}
+
+	mfaIssuer := os.Getenv(prefix + "SERVICE_MFA_ISSUER")
+	if mfaIssuer == "" {
+		mfaIssuer = "MicroBlog"
+	}
+
+	adminMFARequired := false
+	if v := os.Getenv(prefix + "SERVICE_ADMIN_MFA_REQUIRED"); v != "" {
+		if adminMFARequired, err = strconv.ParseBool(v); err != nil {
+			return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"SERVICE_ADMIN_MFA_REQUIRED"))
+		}
+	}
+
+	throttle := auth.DefaultThrottleConfig
+
+	if v := os.Getenv(prefix + "SERVICE_LOGIN_LOCKOUT_ATTEMPTS"); v != "" {
+		if throttle.LockoutAttempts, err = strconv.Atoi(v); err != nil || throttle.LockoutAttempts <= 0 {
+			return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"SERVICE_LOGIN_LOCKOUT_ATTEMPTS"))
+		}
+	}
+
+	if v := os.Getenv(prefix + "SERVICE_LOGIN_LOCKOUT_MINUTES"); v != "" {
+		var lockoutMinutes int
+		if lockoutMinutes, err = strconv.Atoi(v); err != nil || lockoutMinutes <= 0 {
+			return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"SERVICE_LOGIN_LOCKOUT_MINUTES"))
+		}
+
+		throttle.LockoutDuration = time.Duration(int64(lockoutMinutes)) * time.Minute
+	}
+
+	if v := os.Getenv(prefix + "SERVICE_LOGIN_IP_ATTEMPTS"); v != "" {
+		if throttle.IPAttempts, err = strconv.Atoi(v); err != nil || throttle.IPAttempts <= 0 {
+			return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"SERVICE_LOGIN_IP_ATTEMPTS"))
+		}
+	}
+
+	reservedLogins := make([]string, 0)
+	for _, login := range strings.Split(os.Getenv(prefix+"SERVICE_RESERVED_LOGINS"), ",") {
+		login = strings.TrimSpace(login)
+		if login == "" {
+			continue
+		}
+
+		reservedLogins = append(reservedLogins, login)
+	}
+
+	if len(reservedLogins) == 0 {
+		reservedLogins = defaultReservedLogins
+	}
+
+	oidcConfig := oidc.Config{
+		Issuer:       os.Getenv(prefix + "SERVICE_OIDC_ISSUER"),
+		ClientID:     os.Getenv(prefix + "SERVICE_OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv(prefix + "SERVICE_OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv(prefix + "SERVICE_OIDC_REDIRECT_URL"),
+		Scopes:       []string{"email", "profile"},
+	}
+
+	if oidcConfig.Issuer != "" && (oidcConfig.ClientID == "" || oidcConfig.RedirectURL == "") {
+		return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"SERVICE_OIDC_CLIENT_ID"))
+	}
+
 	internalSecrets := make(map[string]string)
 	internalSecrets["post"] = os.Getenv(prefix + "SERVICE_INTERNAL_POST_SECRET")
 	internalSecrets["user"] = os.Getenv(prefix + "SERVICE_INTERNAL_USER_SECRET")
 	internalSecrets["graphql"] = os.Getenv(prefix + "SERVICE_INTERNAL_GRAPHQL_SECRET")
+	internalSecrets["registration"] = os.Getenv(prefix + "SERVICE_INTERNAL_REGISTRATION_SECRET")
+
+	serverInternalSecrets := make(map[string][]byte)
+	serverInternalSecrets["registration"] = []byte(internalSecrets["registration"])
 
 	corsAllowedOrigins := make([]string, 0)
 	tmpAllowedOrigins := strings.Split(os.Getenv(prefix+"SERVER_CORS_ALLOWED_ORIGINS"), ",")
@@ -91,18 +195,50 @@ func NewConfig(prefix string) (*Config, error) {
 		adminCORSAllowedOrigins = append(adminCORSAllowedOrigins, origin)
 	}
 
+	trustedProxies := make([]string, 0)
+	for _, proxy := range strings.Split(os.Getenv(prefix+"SERVER_TRUSTED_PROXIES"), ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+
+		trustedProxies = append(trustedProxies, proxy)
+	}
+
+	queueBackend := queue.BackendRabbitMQ
+	if v := os.Getenv(prefix + "QUEUE_BACKEND"); v != "" {
+		if v != queue.BackendRabbitMQ && v != queue.BackendMemory {
+			return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"QUEUE_BACKEND"))
+		}
+
+		queueBackend = v
+	}
+
 	config := Config{
+		QueueBackend: queueBackend,
 		Server: Server{
 			Addr:                    os.Getenv(prefix + "SERVER_ADDR"),
 			AdminAddr:               os.Getenv(prefix + "SERVER_ADMIN_ADDR"),
 			CORSAllowedOrigins:      corsAllowedOrigins,
 			AdminCORSAllowedOrigins: adminCORSAllowedOrigins,
+			InternalSecrets:         serverInternalSecrets,
+			TrustedProxies:          trustedProxies,
+			TLS: mtls.Config{
+				CertFile: os.Getenv(prefix + "SERVER_TLS_CERT_FILE"),
+				KeyFile:  os.Getenv(prefix + "SERVER_TLS_KEY_FILE"),
+				CAFile:   os.Getenv(prefix + "SERVER_TLS_CA_FILE"),
+			},
 		},
 		RabbitMQ: rabbitmq.Config{
 			URI:                     os.Getenv(prefix + "RABBITMQ_AMQP_URI"),
 			MaxReconnections:        rabbitmqMaxReconnections,
 			ReconnectTimeoutSeconds: time.Duration(int64(rabbitmqReconnectTimeoutSeconds)) * time.Second,
 		},
+		Redis: redis.Config{
+			Addr:     os.Getenv(prefix + "REDIS_ADDR"),
+			Password: os.Getenv(prefix + "REDIS_PASSWORD"),
+			DB:       redisDB,
+		},
 		Database: Database{
 			Host:     os.Getenv(prefix + "DB_HOST"),
 			Port:     databasePort,
@@ -111,9 +247,16 @@ func NewConfig(prefix string) (*Config, error) {
 			Name:     os.Getenv(prefix + "DB_NAME"),
 		},
 		ServiceConfig: auth.Config{
-			Secret:          os.Getenv(prefix + "SERVICE_SECRET"),
-			InternalSecrets: internalSecrets,
+			Secret:           os.Getenv(prefix + "SERVICE_SECRET"),
+			InternalSecrets:  internalSecrets,
+			PasswordResetURL: passwordResetURL,
+			PasswordResetTTL: time.Duration(int64(passwordResetTTLMinutes)) * time.Minute,
+			MFAIssuer:        mfaIssuer,
+			AdminMFARequired: adminMFARequired,
+			ReservedLogins:   reservedLogins,
 		},
+		Throttle: throttle,
+		OIDC:     oidcConfig,
 	}
 
 	return &config, nil
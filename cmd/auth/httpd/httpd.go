@@ -7,6 +7,8 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/cors"
@@ -16,8 +18,21 @@ import (
 
 	"gitlab.com/slirx/newproj/internal/auth"
 	"gitlab.com/slirx/newproj/pkg/api"
+	"gitlab.com/slirx/newproj/pkg/apitoken"
 	"gitlab.com/slirx/newproj/pkg/http/apmmiddleware"
+	"gitlab.com/slirx/newproj/pkg/http/clientip"
+	"gitlab.com/slirx/newproj/pkg/http/jwtmiddleware"
 	"gitlab.com/slirx/newproj/pkg/logger"
+	"gitlab.com/slirx/newproj/pkg/mtls"
+	"gitlab.com/slirx/newproj/pkg/oidc"
+	"gitlab.com/slirx/newproj/pkg/queue"
+	"gitlab.com/slirx/newproj/pkg/queue/manager"
+	"gitlab.com/slirx/newproj/pkg/queue/memory"
+	"gitlab.com/slirx/newproj/pkg/queue/worker"
+	"gitlab.com/slirx/newproj/pkg/rbac"
+	"gitlab.com/slirx/newproj/pkg/redis"
+	"gitlab.com/slirx/newproj/pkg/revocation"
+	"gitlab.com/slirx/newproj/pkg/template"
 	"gitlab.com/slirx/newproj/pkg/tracer"
 	"gitlab.com/slirx/newproj/pkg/utils"
 )
@@ -41,6 +56,22 @@ func main() {
 		zapLogger.Fatal(err)
 	}
 
+	var m manager.Manager
+	var broker *memory.Broker
+
+	if conf.QueueBackend == queue.BackendMemory {
+		broker = memory.NewBroker()
+		m = manager.NewMemoryManager(broker)
+	} else {
+		m = manager.NewManager(ctx, zapLogger, conf.RabbitMQ)
+	}
+
+	defer func() {
+		if err := m.Close(); err != nil {
+			zapLogger.Error(err)
+		}
+	}()
+
 	dbDSN := fmt.Sprintf(
 		"postgres://%s:%s@%s:%d/%s?sslmode=disable",
 		conf.Database.User, conf.Database.Password, conf.Database.Host, conf.Database.Port, conf.Database.Name,
@@ -56,11 +87,98 @@ func main() {
 		}
 	}()
 
+	redisClient, err := redis.New(ctx, conf.Redis)
+	if err != nil {
+		zapLogger.Fatal(err)
+	}
+
+	redisClient = redis.NewClientWithApm(redisClient)
+
+	defer func() {
+		if err := redisClient.Close(); err != nil {
+			zapLogger.Error(err)
+		}
+	}()
+
 	t := tracer.NewAPMTracer()
 	responseBuilder := api.NewResponseBuilder(t)
 
-	service := auth.NewService(auth.NewRepository(db), conf.ServiceConfig)
-	handler := auth.NewHandler(service, zapLogger, responseBuilder)
+	tg := template.New()
+
+	catalog, err := template.NewCatalog("template/messages")
+	if err != nil {
+		zapLogger.Fatal(err)
+	}
+
+	// workers of the service are run in the same process in case of in-memory queue
+	if broker != nil {
+		// jobs of other services are kept in memory, because their workers are run by other processes
+		broker.DeclareQueue(queue.JobEmailSend)
+		broker.DeclareQueue(queue.JobUserCreate)
+		broker.DeclareQueue(queue.JobUserUpdateEmail)
+		broker.DeclareQueue(queue.JobRegistrationExternal)
+
+		workers := []worker.Worker{
+			worker.NewMemoryWorker(
+				"auth/worker/create",
+				broker,
+				zapLogger,
+				apm.DefaultTracer,
+				auth.NewCreateHandler(zapLogger, auth.NewRepository(db), m),
+				queue.JobAuthCreate,
+			),
+			worker.NewMemoryWorker(
+				"auth/worker/update-user-id",
+				broker,
+				zapLogger,
+				apm.DefaultTracer,
+				auth.NewUpdateUserIDHandler(zapLogger, auth.NewRepository(db)),
+				queue.JobAuthUpdateUserIDAuth,
+			),
+		}
+
+		var wg sync.WaitGroup
+		// in-flight jobs are finished before database is closed
+		defer wg.Wait()
+
+		for _, w := range workers {
+			wg.Add(1)
+
+			go func(w worker.Worker) {
+				defer wg.Done()
+				w.Run(ctx)
+			}(w)
+		}
+	}
+
+	revocationStore := revocation.NewStore(redisClient, auth.AccessTokenTTL)
+	apiTokenStore := apitoken.NewStore(redisClient, auth.NewAPITokenSource(auth.NewRepository(db)))
+
+	throttle := auth.NewThrottle(redisClient, conf.Throttle)
+
+	var oidcProvider oidc.Provider
+	if conf.OIDC.Issuer != "" {
+		oidcProvider = oidc.New(conf.OIDC, &http.Client{Timeout: 5 * time.Second})
+	}
+
+	service := auth.NewService(
+		t,
+		auth.NewRepository(db),
+		m,
+		tg,
+		catalog,
+		revocationStore,
+		apiTokenStore,
+		throttle,
+		oidcProvider,
+		conf.ServiceConfig,
+	)
+	clientIP, err := clientip.NewResolver(conf.Server.TrustedProxies)
+	if err != nil {
+		zapLogger.Fatal(err)
+	}
+
+	handler := auth.NewHandler(service, zapLogger, responseBuilder, clientIP)
 
 	apmTracer := apm.DefaultTracer
 
@@ -86,6 +204,324 @@ func main() {
 			apmmiddleware.WithRecovery(recoveryFunc),
 		),
 	)
+	router.Post(
+		"/auth/password/forgot",
+		apmmiddleware.Wrap(
+			handler.ForgotPassword,
+			"/auth/password/forgot",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+	router.Post(
+		"/auth/password/reset",
+		apmmiddleware.Wrap(
+			handler.ResetPassword,
+			"/auth/password/reset",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+	router.Post(
+		"/auth/password/change",
+		apmmiddleware.Wrap(
+			jwtmiddleware.Wrap(
+				handler.ChangePassword,
+				responseBuilder,
+				zapLogger,
+				[]byte(conf.ServiceConfig.Secret),
+				jwtmiddleware.WithRevocation(revocationStore),
+			),
+			"/auth/password/change",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+	router.Post(
+		"/auth/email/change",
+		apmmiddleware.Wrap(
+			jwtmiddleware.Wrap(
+				handler.ChangeEmail,
+				responseBuilder,
+				zapLogger,
+				[]byte(conf.ServiceConfig.Secret),
+				jwtmiddleware.WithRevocation(revocationStore),
+			),
+			"/auth/email/change",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+	router.Post(
+		"/auth/email/confirm",
+		apmmiddleware.Wrap(
+			jwtmiddleware.Wrap(
+				handler.ConfirmEmailChange,
+				responseBuilder,
+				zapLogger,
+				[]byte(conf.ServiceConfig.Secret),
+				jwtmiddleware.WithRevocation(revocationStore),
+			),
+			"/auth/email/confirm",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+	router.Post(
+		"/auth/mfa/verify",
+		apmmiddleware.Wrap(
+			handler.VerifyMFA,
+			"/auth/mfa/verify",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+	router.Post(
+		"/auth/mfa/enroll",
+		apmmiddleware.Wrap(
+			jwtmiddleware.Wrap(
+				handler.EnrollMFA,
+				responseBuilder,
+				zapLogger,
+				[]byte(conf.ServiceConfig.Secret),
+				jwtmiddleware.WithRevocation(revocationStore),
+			),
+			"/auth/mfa/enroll",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+	router.Post(
+		"/auth/mfa/enable",
+		apmmiddleware.Wrap(
+			jwtmiddleware.Wrap(
+				handler.EnableMFA,
+				responseBuilder,
+				zapLogger,
+				[]byte(conf.ServiceConfig.Secret),
+				jwtmiddleware.WithRevocation(revocationStore),
+			),
+			"/auth/mfa/enable",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+	router.Post(
+		"/auth/mfa/disable",
+		apmmiddleware.Wrap(
+			jwtmiddleware.Wrap(
+				handler.DisableMFA,
+				responseBuilder,
+				zapLogger,
+				[]byte(conf.ServiceConfig.Secret),
+				jwtmiddleware.WithRevocation(revocationStore),
+			),
+			"/auth/mfa/disable",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+	router.Post(
+		"/auth/mfa/recovery-codes",
+		apmmiddleware.Wrap(
+			jwtmiddleware.Wrap(
+				handler.RegenerateRecoveryCodes,
+				responseBuilder,
+				zapLogger,
+				[]byte(conf.ServiceConfig.Secret),
+				jwtmiddleware.WithRevocation(revocationStore),
+			),
+			"/auth/mfa/recovery-codes",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+	router.Get(
+		"/auth/sessions",
+		apmmiddleware.Wrap(
+			jwtmiddleware.Wrap(
+				handler.Sessions,
+				responseBuilder,
+				zapLogger,
+				[]byte(conf.ServiceConfig.Secret),
+				jwtmiddleware.WithRevocation(revocationStore),
+			),
+			"/auth/sessions",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+	router.Delete(
+		"/auth/sessions/{id}",
+		apmmiddleware.Wrap(
+			jwtmiddleware.Wrap(
+				handler.RevokeSession,
+				responseBuilder,
+				zapLogger,
+				[]byte(conf.ServiceConfig.Secret),
+				jwtmiddleware.WithRevocation(revocationStore),
+			),
+			"/auth/sessions/{id}",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+	router.Post(
+		"/auth/tokens",
+		apmmiddleware.Wrap(
+			jwtmiddleware.Wrap(
+				handler.CreateAPIToken,
+				responseBuilder,
+				zapLogger,
+				[]byte(conf.ServiceConfig.Secret),
+				jwtmiddleware.WithRevocation(revocationStore),
+			),
+			"/auth/tokens",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+	router.Get(
+		"/auth/tokens",
+		apmmiddleware.Wrap(
+			jwtmiddleware.Wrap(
+				handler.APITokens,
+				responseBuilder,
+				zapLogger,
+				[]byte(conf.ServiceConfig.Secret),
+				jwtmiddleware.WithRevocation(revocationStore),
+			),
+			"/auth/tokens",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+	router.Delete(
+		"/auth/tokens/{id}",
+		apmmiddleware.Wrap(
+			jwtmiddleware.Wrap(
+				handler.RevokeAPIToken,
+				responseBuilder,
+				zapLogger,
+				[]byte(conf.ServiceConfig.Secret),
+				jwtmiddleware.WithRevocation(revocationStore),
+			),
+			"/auth/tokens/{id}",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+	router.Post(
+		"/auth/oauth/clients",
+		apmmiddleware.Wrap(
+			jwtmiddleware.Wrap(
+				handler.RegisterClient,
+				responseBuilder,
+				zapLogger,
+				[]byte(conf.ServiceConfig.Secret),
+				jwtmiddleware.WithRevocation(revocationStore),
+			),
+			"/auth/oauth/clients",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+	router.Get(
+		"/auth/oauth/authorize",
+		apmmiddleware.Wrap(
+			jwtmiddleware.Wrap(
+				handler.Authorize,
+				responseBuilder,
+				zapLogger,
+				[]byte(conf.ServiceConfig.Secret),
+				jwtmiddleware.WithRevocation(revocationStore),
+			),
+			"/auth/oauth/authorize",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+	router.Post(
+		"/auth/oauth/authorize",
+		apmmiddleware.Wrap(
+			jwtmiddleware.Wrap(
+				handler.Consent,
+				responseBuilder,
+				zapLogger,
+				[]byte(conf.ServiceConfig.Secret),
+				jwtmiddleware.WithRevocation(revocationStore),
+			),
+			"/auth/oauth/authorize",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+	router.Get(
+		"/auth/oauth/consents",
+		apmmiddleware.Wrap(
+			jwtmiddleware.Wrap(
+				handler.Consents,
+				responseBuilder,
+				zapLogger,
+				[]byte(conf.ServiceConfig.Secret),
+				jwtmiddleware.WithRevocation(revocationStore),
+			),
+			"/auth/oauth/consents",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+	router.Delete(
+		"/auth/oauth/consents/{clientID}",
+		apmmiddleware.Wrap(
+			jwtmiddleware.Wrap(
+				handler.RevokeConsent,
+				responseBuilder,
+				zapLogger,
+				[]byte(conf.ServiceConfig.Secret),
+				jwtmiddleware.WithRevocation(revocationStore),
+			),
+			"/auth/oauth/consents/{clientID}",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+	router.Post(
+		"/auth/oauth/token",
+		apmmiddleware.Wrap(
+			handler.Token,
+			"/auth/oauth/token",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+	router.Post(
+		"/auth/oauth/introspect",
+		apmmiddleware.Wrap(
+			handler.Introspect,
+			"/auth/oauth/introspect",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+	router.Get(
+		"/auth/oidc/login",
+		apmmiddleware.Wrap(
+			handler.OIDCLogin,
+			"/auth/oidc/login",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+	router.Post(
+		"/auth/oidc/callback",
+		apmmiddleware.Wrap(
+			handler.OIDCCallback,
+			"/auth/oidc/callback",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
 	router.Post(
 		"/internal/auth/login",
 		apmmiddleware.Wrap(
@@ -95,13 +531,55 @@ func main() {
 			apmmiddleware.WithRecovery(recoveryFunc),
 		),
 	)
+	router.Get(
+		"/internal/auth/availability",
+		apmmiddleware.Wrap(
+			jwtmiddleware.WrapInternal(
+				handler.InternalAvailability,
+				responseBuilder,
+				zapLogger,
+				conf.Server.InternalSecrets,
+				jwtmiddleware.WithClientCertificates(),
+			),
+			"/internal/auth/availability",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+	router.Get(
+		"/internal/auth/api-tokens/{hash}",
+		apmmiddleware.Wrap(
+			jwtmiddleware.WrapInternal(
+				handler.InternalAPIToken,
+				responseBuilder,
+				zapLogger,
+				conf.Server.InternalSecrets,
+				jwtmiddleware.WithClientCertificates(),
+			),
+			"/internal/auth/api-tokens/{hash}",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
 
 	server := http.Server{
 		Addr:    conf.Server.Addr,
 		Handler: router,
 	}
 
+	// internal endpoints authenticate services by client certificates in case mutual TLS is enabled
+	if conf.Server.TLS.Enabled() {
+		if server.TLSConfig, err = mtls.ServerConfig(conf.Server.TLS); err != nil {
+			zapLogger.Fatal(err)
+		}
+	}
+
 	go func() {
+		if conf.Server.TLS.Enabled() {
+			_ = server.ListenAndServeTLS("", "")
+			return
+		}
+
 		_ = server.ListenAndServe()
 	}()
 
@@ -123,6 +601,96 @@ func main() {
 			apmmiddleware.WithRecovery(recoveryFunc),
 		),
 	)
+	routerAdmin.Post(
+		"/auth/admin/mfa/verify",
+		apmmiddleware.Wrap(
+			handler.AdminVerifyMFA,
+			"/auth/admin/mfa/verify",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+	routerAdmin.Put(
+		"/auth/admin/roles",
+		apmmiddleware.Wrap(
+			jwtmiddleware.Wrap(
+				handler.SetRole,
+				responseBuilder,
+				zapLogger,
+				[]byte(conf.ServiceConfig.Secret),
+				jwtmiddleware.WithRevocation(revocationStore),
+				jwtmiddleware.WithPermissions(rbac.PermissionRolesManage),
+			),
+			"/auth/admin/roles",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+	// staff manage their own sessions of admin panel the same way as users, any staff role is allowed
+	routerAdmin.Get(
+		"/auth/admin/sessions",
+		apmmiddleware.Wrap(
+			jwtmiddleware.Wrap(
+				handler.Sessions,
+				responseBuilder,
+				zapLogger,
+				[]byte(conf.ServiceConfig.Secret),
+				jwtmiddleware.WithRevocation(revocationStore),
+				jwtmiddleware.WithPermissions(),
+			),
+			"/auth/admin/sessions",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+	routerAdmin.Delete(
+		"/auth/admin/sessions/{id}",
+		apmmiddleware.Wrap(
+			jwtmiddleware.Wrap(
+				handler.RevokeSession,
+				responseBuilder,
+				zapLogger,
+				[]byte(conf.ServiceConfig.Secret),
+				jwtmiddleware.WithRevocation(revocationStore),
+				jwtmiddleware.WithPermissions(),
+			),
+			"/auth/admin/sessions/{id}",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+	routerAdmin.Get(
+		"/auth/admin/users/{login}/sessions",
+		apmmiddleware.Wrap(
+			jwtmiddleware.Wrap(
+				handler.UserSessions,
+				responseBuilder,
+				zapLogger,
+				[]byte(conf.ServiceConfig.Secret),
+				jwtmiddleware.WithRevocation(revocationStore),
+				jwtmiddleware.WithPermissions(rbac.PermissionUsersRead),
+			),
+			"/auth/admin/users/{login}/sessions",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+	routerAdmin.Delete(
+		"/auth/admin/users/{login}/sessions",
+		apmmiddleware.Wrap(
+			jwtmiddleware.Wrap(
+				handler.RevokeUserSessions,
+				responseBuilder,
+				zapLogger,
+				[]byte(conf.ServiceConfig.Secret),
+				jwtmiddleware.WithRevocation(revocationStore),
+				jwtmiddleware.WithPermissions(rbac.PermissionUsersWrite),
+			),
+			"/auth/admin/users/{login}/sessions",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
 
 	serverAdmin := http.Server{
 		Addr:    conf.Server.AdminAddr,
@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"syscall"
 
 	"go.elastic.co/apm"
 	"go.elastic.co/apm/module/apmsql"
@@ -22,7 +23,7 @@ import (
 func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
 		cancel()
@@ -38,6 +39,12 @@ func main() {
 		zapLogger.Fatal(err)
 	}
 
+	// workers are run by httpd of the service in case of in-memory queue, because memory isn't shared by processes
+	if conf.QueueBackend == queue.BackendMemory {
+		zapLogger.Info("in-memory queue is consumed by httpd of the service, worker is not started")
+		return
+	}
+
 	dbDSN := fmt.Sprintf(
 		"postgres://%s:%s@%s:%d/%s?sslmode=disable",
 		conf.Database.User, conf.Database.Password, conf.Database.Host, conf.Database.Port, conf.Database.Name,
@@ -64,6 +71,8 @@ func main() {
 		apmTrace,
 		auth.NewCreateHandler(zapLogger, auth.NewRepository(db), m),
 		queue.JobAuthCreate,
+		worker.WithConcurrency(conf.Worker.Concurrency),
+		worker.WithShutdownTimeout(conf.Worker.ShutdownTimeout),
 	)
 	w.Run(ctx)
 }
@@ -7,13 +7,17 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"gitlab.com/slirx/newproj/pkg/queue"
 	"gitlab.com/slirx/newproj/pkg/rabbitmq"
 )
 
 // Config represents combined configuration.
 type Config struct {
 	RabbitMQ rabbitmq.Config
-	Database Database
+	// QueueBackend is queue.BackendRabbitMQ or queue.BackendMemory.
+	QueueBackend string
+	Worker       Worker
+	Database     Database
 }
 
 type Database struct {
@@ -24,6 +28,14 @@ type Database struct {
 	Name     string
 }
 
+// Worker represents configuration of queue worker.
+type Worker struct {
+	// Concurrency is the number of messages handled at the same time.
+	Concurrency int
+	// ShutdownTimeout is how long worker waits for in-flight messages on shutdown.
+	ShutdownTimeout time.Duration
+}
+
 // NewConfig returns initialized instance of configuration. It reads configuration from environment variables.
 // prefix represents prefix of environment variables' names.
 func NewConfig(prefix string) (*Config, error) {
@@ -46,11 +58,49 @@ func NewConfig(prefix string) (*Config, error) {
 		return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"DB_PORT"))
 	}
 
+	workerConcurrency := 1
+	if v := os.Getenv(prefix + "WORKER_CONCURRENCY"); v != "" {
+		if workerConcurrency, err = strconv.Atoi(v); err != nil || workerConcurrency <= 0 {
+			return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"WORKER_CONCURRENCY"))
+		}
+	}
+
+	workerShutdownTimeoutSeconds := 30
+	if v := os.Getenv(prefix + "WORKER_SHUTDOWN_TIMEOUT_SECONDS"); v != "" {
+		workerShutdownTimeoutSeconds, err = strconv.Atoi(v)
+		if err != nil || workerShutdownTimeoutSeconds < 0 {
+			return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"WORKER_SHUTDOWN_TIMEOUT_SECONDS"))
+		}
+	}
+
+	// by default prefetch as many messages as worker can handle at the same time
+	rabbitmqPrefetchCount := workerConcurrency
+	if v := os.Getenv(prefix + "RABBITMQ_PREFETCH_COUNT"); v != "" {
+		if rabbitmqPrefetchCount, err = strconv.Atoi(v); err != nil || rabbitmqPrefetchCount <= 0 {
+			return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"RABBITMQ_PREFETCH_COUNT"))
+		}
+	}
+
+	queueBackend := queue.BackendRabbitMQ
+	if v := os.Getenv(prefix + "QUEUE_BACKEND"); v != "" {
+		if v != queue.BackendRabbitMQ && v != queue.BackendMemory {
+			return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"QUEUE_BACKEND"))
+		}
+
+		queueBackend = v
+	}
+
 	config := Config{
+		QueueBackend: queueBackend,
 		RabbitMQ: rabbitmq.Config{
 			URI:                     os.Getenv(prefix + "RABBITMQ_AMQP_URI"),
 			MaxReconnections:        rabbitmqMaxReconnections,
 			ReconnectTimeoutSeconds: time.Duration(int64(rabbitmqReconnectTimeoutSeconds)) * time.Second,
+			PrefetchCount:           rabbitmqPrefetchCount,
+		},
+		Worker: Worker{
+			Concurrency:     workerConcurrency,
+			ShutdownTimeout: time.Duration(int64(workerShutdownTimeoutSeconds)) * time.Second,
 		},
 		Database: Database{
 			Host:     os.Getenv(prefix + "DB_HOST"),
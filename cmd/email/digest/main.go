@@ -0,0 +1,79 @@
+// main package represents command which schedules sending of email digests. it sends one message to the digest
+// worker and exits, so it's supposed to be run by cron, for example:
+//
+//	0 8 * * *   digest -frequency daily
+//	0 8 * * MON digest -frequency weekly
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.elastic.co/apm"
+
+	"gitlab.com/slirx/newproj/internal/email"
+	"gitlab.com/slirx/newproj/pkg/logger"
+	"gitlab.com/slirx/newproj/pkg/queue"
+	"gitlab.com/slirx/newproj/pkg/queue/manager"
+)
+
+func main() {
+	frequency := flag.String("frequency", email.DigestDaily, "frequency of digest: daily or weekly")
+	flag.Parse()
+
+	zapLogger, err := logger.NewZapLogger()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if *frequency != email.DigestDaily && *frequency != email.DigestWeekly {
+		zapLogger.Fatal(errors.Errorf("invalid frequency: %q", *frequency))
+	}
+
+	conf, err := NewConfig("EMAIL_DIGEST_")
+	if err != nil {
+		zapLogger.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), conf.Timeout)
+	defer cancel()
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		cancel()
+	}()
+
+	apmTracer := apm.DefaultTracer
+	apmTracer.Service.Name = "email-digest"
+
+	tx := apmTracer.StartTransaction("digest "+*frequency, "scheduled")
+	ctx = apm.ContextWithTransaction(ctx, tx)
+
+	m := manager.NewManager(ctx, zapLogger, conf.RabbitMQ)
+
+	err = m.Send(ctx, queue.JobEmailDigest, queue.EmailDigest{
+		Frequency:   *frequency,
+		ScheduledAt: time.Now().Unix(),
+	})
+
+	if closeErr := m.Close(); closeErr != nil {
+		zapLogger.Error(closeErr)
+	}
+
+	tx.End()
+	apmTracer.Flush(nil)
+
+	if err != nil {
+		zapLogger.Fatal(err)
+	}
+
+	zapLogger.Info(*frequency + " digest is scheduled")
+}
@@ -0,0 +1,66 @@
+// main package represents executable for handling bounce and complaint notifications. it's rabbitmq worker which
+// adds addresses to suppression list, so emails are not sent to them anymore.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/module/apmsql"
+	_ "go.elastic.co/apm/module/apmsql/pq"
+
+	"gitlab.com/slirx/newproj/internal/email"
+	"gitlab.com/slirx/newproj/pkg/logger"
+	"gitlab.com/slirx/newproj/pkg/queue"
+	"gitlab.com/slirx/newproj/pkg/queue/worker"
+	"gitlab.com/slirx/newproj/pkg/rabbitmq"
+)
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		cancel()
+	}()
+
+	zapLogger, err := logger.NewZapLogger()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	conf, err := NewConfig("EMAIL_BOUNCE_")
+	if err != nil {
+		zapLogger.Fatal(err)
+	}
+
+	dbDSN := fmt.Sprintf(
+		"postgres://%s:%s@%s:%d/%s?sslmode=disable",
+		conf.Database.User, conf.Database.Password, conf.Database.Host, conf.Database.Port, conf.Database.Name,
+	)
+	db, err := apmsql.Open("postgres", dbDSN)
+	if err != nil {
+		zapLogger.Fatal(err)
+	}
+
+	apmTrace := apm.DefaultTracer
+	apmTrace.Service.Name = "email-worker-bounce"
+
+	w := worker.NewWorker(
+		"email/bounce",
+		rabbitmq.NewClient(conf.RabbitMQ, zapLogger, queue.JobEmailBounce),
+		zapLogger,
+		apmTrace,
+		email.NewBounceHandler(zapLogger, email.NewRepository(db)),
+		queue.JobEmailBounce,
+		worker.WithConcurrency(conf.Worker.Concurrency),
+		worker.WithShutdownTimeout(conf.Worker.ShutdownTimeout),
+	)
+	w.Run(ctx)
+}
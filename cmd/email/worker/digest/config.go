@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gitlab.com/slirx/newproj/internal/api"
+	"gitlab.com/slirx/newproj/internal/email"
+	"gitlab.com/slirx/newproj/pkg/mtls"
+	"gitlab.com/slirx/newproj/pkg/rabbitmq"
+	"gitlab.com/slirx/newproj/pkg/redis"
+)
+
+// Config represents combined configuration.
+type Config struct {
+	RabbitMQ             rabbitmq.Config
+	Redis                redis.Config
+	Worker               Worker
+	Digest               email.DigestConfig
+	InternalAPIConfig    api.ServiceConfig
+	InternalAPIEndpoints map[string]string
+}
+
+// Worker represents configuration of queue worker.
+type Worker struct {
+	// Concurrency is the number of messages handled at the same time.
+	Concurrency int
+	// ShutdownTimeout is how long worker waits for in-flight messages on shutdown.
+	ShutdownTimeout time.Duration
+}
+
+// NewConfig returns initialized instance of configuration. It reads configuration from environment variables.
+// prefix represents prefix of environment variables' names.
+func NewConfig(prefix string) (*Config, error) {
+	var err error
+
+	var rabbitmqMaxReconnections int
+	if rabbitmqMaxReconnections, err = strconv.Atoi(os.Getenv(prefix + "RABBITMQ_MAX_RECONNECTIONS")); err != nil {
+		return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"RABBITMQ_MAX_RECONNECTIONS"))
+	}
+
+	var rabbitmqReconnectTimeoutSeconds int
+
+	rabbitmqReconnectTimeoutSeconds, err = strconv.Atoi(os.Getenv(prefix + "RABBITMQ_RECONNECT_TIMEOUT_SECONDS"))
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"RABBITMQ_RECONNECT_TIMEOUT_SECONDS"))
+	}
+
+	var redisDB int
+	if redisDB, err = strconv.Atoi(os.Getenv(prefix + "REDIS_DB")); err != nil {
+		return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"REDIS_DB"))
+	}
+
+	// digest of one frequency is sent to all users by one message, so messages are handled one by one by default
+	workerConcurrency := 1
+	if v := os.Getenv(prefix + "WORKER_CONCURRENCY"); v != "" {
+		if workerConcurrency, err = strconv.Atoi(v); err != nil || workerConcurrency <= 0 {
+			return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"WORKER_CONCURRENCY"))
+		}
+	}
+
+	workerShutdownTimeoutSeconds := 30
+	if v := os.Getenv(prefix + "WORKER_SHUTDOWN_TIMEOUT_SECONDS"); v != "" {
+		workerShutdownTimeoutSeconds, err = strconv.Atoi(v)
+		if err != nil || workerShutdownTimeoutSeconds < 0 {
+			return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"WORKER_SHUTDOWN_TIMEOUT_SECONDS"))
+		}
+	}
+
+	// by default prefetch as many messages as worker can handle at the same time
+	rabbitmqPrefetchCount := workerConcurrency
+	if v := os.Getenv(prefix + "RABBITMQ_PREFETCH_COUNT"); v != "" {
+		if rabbitmqPrefetchCount, err = strconv.Atoi(v); err != nil || rabbitmqPrefetchCount <= 0 {
+			return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"RABBITMQ_PREFETCH_COUNT"))
+		}
+	}
+
+	unsubscribeURL := os.Getenv(prefix + "UNSUBSCRIBE_URL")
+	if unsubscribeURL == "" {
+		return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"UNSUBSCRIBE_URL"))
+	}
+
+	unsubscribeSecret := os.Getenv(prefix + "UNSUBSCRIBE_SECRET")
+	if unsubscribeSecret == "" {
+		return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"UNSUBSCRIBE_SECRET"))
+	}
+
+	endpoints := make(map[string]string)
+	endpoints["auth"] = os.Getenv(prefix + "ENDPOINT_AUTH")
+	endpoints["user"] = os.Getenv(prefix + "ENDPOINT_USER")
+	endpoints["post"] = os.Getenv(prefix + "ENDPOINT_POST")
+
+	config := Config{
+		RabbitMQ: rabbitmq.Config{
+			URI:                     os.Getenv(prefix + "RABBITMQ_AMQP_URI"),
+			MaxReconnections:        rabbitmqMaxReconnections,
+			ReconnectTimeoutSeconds: time.Duration(int64(rabbitmqReconnectTimeoutSeconds)) * time.Second,
+			PrefetchCount:           rabbitmqPrefetchCount,
+		},
+		Redis: redis.Config{
+			Addr:     os.Getenv(prefix + "REDIS_ADDR"),
+			Password: os.Getenv(prefix + "REDIS_PASSWORD"),
+			DB:       redisDB,
+		},
+		Worker: Worker{
+			Concurrency:     workerConcurrency,
+			ShutdownTimeout: time.Duration(int64(workerShutdownTimeoutSeconds)) * time.Second,
+		},
+		Digest: email.DigestConfig{
+			UnsubscribeURL:    unsubscribeURL,
+			UnsubscribeSecret: []byte(unsubscribeSecret),
+		},
+		InternalAPIConfig: api.ServiceConfig{
+			InternalJWT: api.InternalJWT{
+				Endpoint: os.Getenv(prefix + "SERVER_JWT_INTERNAL_ENDPOINT"),
+				Login:    os.Getenv(prefix + "SERVER_JWT_INTERNAL_LOGIN"),
+				Password: os.Getenv(prefix + "SERVER_JWT_INTERNAL_PASSWORD"),
+			},
+			TLS: mtls.Config{
+				CertFile: os.Getenv(prefix + "INTERNAL_TLS_CERT_FILE"),
+				KeyFile:  os.Getenv(prefix + "INTERNAL_TLS_KEY_FILE"),
+				CAFile:   os.Getenv(prefix + "INTERNAL_TLS_CA_FILE"),
+			},
+		},
+		InternalAPIEndpoints: endpoints,
+	}
+
+	return &config, nil
+}
@@ -0,0 +1,103 @@
+// main package represents executable for sending email digests. it's rabbitmq worker which collects missed activity
+// of users from user and post services and sends digest emails to the email queue.
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"go.elastic.co/apm"
+
+	"gitlab.com/slirx/newproj/internal/api/post"
+	"gitlab.com/slirx/newproj/internal/api/user"
+	"gitlab.com/slirx/newproj/internal/email"
+	"gitlab.com/slirx/newproj/pkg/logger"
+	"gitlab.com/slirx/newproj/pkg/queue"
+	"gitlab.com/slirx/newproj/pkg/queue/manager"
+	"gitlab.com/slirx/newproj/pkg/queue/worker"
+	"gitlab.com/slirx/newproj/pkg/rabbitmq"
+	"gitlab.com/slirx/newproj/pkg/redis"
+	"gitlab.com/slirx/newproj/pkg/template"
+)
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		cancel()
+	}()
+
+	zapLogger, err := logger.NewZapLogger()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	conf, err := NewConfig("EMAIL_DIGEST_")
+	if err != nil {
+		zapLogger.Fatal(err)
+	}
+
+	internalUserAPI, err := user.NewAPI(conf.InternalAPIEndpoints, &conf.InternalAPIConfig)
+	if err != nil {
+		zapLogger.Fatal(err)
+	}
+
+	internalPostAPI, err := post.NewAPI(conf.InternalAPIEndpoints, &conf.InternalAPIConfig)
+	if err != nil {
+		zapLogger.Fatal(err)
+	}
+
+	catalog, err := template.NewCatalog("template/messages")
+	if err != nil {
+		zapLogger.Fatal(err)
+	}
+
+	redisClient, err := redis.New(ctx, conf.Redis)
+	if err != nil {
+		zapLogger.Fatal(err)
+	}
+
+	redisClient = redis.NewClientWithApm(redisClient)
+
+	defer func() {
+		if err := redisClient.Close(); err != nil {
+			zapLogger.Error(err)
+		}
+	}()
+
+	m := manager.NewManager(ctx, zapLogger, conf.RabbitMQ)
+	defer func() {
+		if err := m.Close(); err != nil {
+			zapLogger.Error(err)
+		}
+	}()
+
+	apmTracer := apm.DefaultTracer
+	apmTracer.Service.Name = "email-worker-digest"
+
+	w := worker.NewWorker(
+		"email/digest",
+		rabbitmq.NewClient(conf.RabbitMQ, zapLogger, queue.JobEmailDigest),
+		zapLogger,
+		apmTracer,
+		email.NewDigestHandler(
+			zapLogger,
+			m,
+			template.New(),
+			catalog,
+			internalUserAPI,
+			internalPostAPI,
+			redisClient,
+			conf.Digest,
+		),
+		queue.JobEmailDigest,
+		worker.WithConcurrency(conf.Worker.Concurrency),
+		worker.WithShutdownTimeout(conf.Worker.ShutdownTimeout),
+	)
+	w.Run(ctx)
+}
@@ -8,12 +8,50 @@ import (
 
 	"github.com/pkg/errors"
 
+	"gitlab.com/slirx/newproj/internal/email"
 	"gitlab.com/slirx/newproj/pkg/rabbitmq"
+	"gitlab.com/slirx/newproj/pkg/redis"
 )
 
 // Config represents combined configuration.
 type Config struct {
 	RabbitMQ rabbitmq.Config
+	Worker   Worker
+	Email    Email
+	Database Database
+	Redis    redis.Config
+}
+
+type Database struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	Name     string
+}
+
+// Worker represents configuration of queue worker.
+type Worker struct {
+	// Concurrency is the number of messages handled at the same time.
+	Concurrency int
+	// ShutdownTimeout is how long worker waits for in-flight messages on shutdown.
+	ShutdownTimeout time.Duration
+}
+
+// Transports of email delivery.
+const (
+	TransportSMTP    = "smtp"
+	TransportMaildir = "maildir"
+)
+
+// Email represents configuration of email delivery.
+type Email struct {
+	// Transport is TransportSMTP or TransportMaildir. Maildir is used in development instead of real delivery.
+	Transport   string
+	From        string
+	MaildirPath string
+	SMTP        email.SMTPConfig
+	RateLimit   email.RateLimit
 }
 
 // NewConfig returns initialized instance of configuration. It reads configuration from environment variables.
@@ -33,11 +71,178 @@ func NewConfig(prefix string) (*Config, error) {
 		return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"RABBITMQ_RECONNECT_TIMEOUT_SECONDS"))
 	}
 
+	workerConcurrency := 1
+	if v := os.Getenv(prefix + "WORKER_CONCURRENCY"); v != "" {
+		if workerConcurrency, err = strconv.Atoi(v); err != nil || workerConcurrency <= 0 {
+			return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"WORKER_CONCURRENCY"))
+		}
+	}
+
+	workerShutdownTimeoutSeconds := 30
+	if v := os.Getenv(prefix + "WORKER_SHUTDOWN_TIMEOUT_SECONDS"); v != "" {
+		workerShutdownTimeoutSeconds, err = strconv.Atoi(v)
+		if err != nil || workerShutdownTimeoutSeconds < 0 {
+			return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"WORKER_SHUTDOWN_TIMEOUT_SECONDS"))
+		}
+	}
+
+	// by default prefetch as many messages as worker can handle at the same time
+	rabbitmqPrefetchCount := workerConcurrency
+	if v := os.Getenv(prefix + "RABBITMQ_PREFETCH_COUNT"); v != "" {
+		if rabbitmqPrefetchCount, err = strconv.Atoi(v); err != nil || rabbitmqPrefetchCount <= 0 {
+			return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"RABBITMQ_PREFETCH_COUNT"))
+		}
+	}
+
+	var databasePort int
+	if databasePort, err = strconv.Atoi(os.Getenv(prefix + "DB_PORT")); err != nil {
+		return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"DB_PORT"))
+	}
+
+	var redisDB int
+	if redisDB, err = strconv.Atoi(os.Getenv(prefix + "REDIS_DB")); err != nil {
+		return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"REDIS_DB"))
+	}
+
+	// by default one address gets at most 3 non-transactional and 10 transactional emails in 10 minutes, global
+	// rate is not limited
+	rateLimit := email.RateLimit{
+		PerRecipient:                    3,
+		PerRecipientPeriod:              10 * time.Minute,
+		TransactionalPerRecipient:       10,
+		TransactionalPerRecipientPeriod: 10 * time.Minute,
+		GlobalPeriod:                    time.Second,
+	}
+
+	if v := os.Getenv(prefix + "RATE_LIMIT_PER_RECIPIENT"); v != "" {
+		if rateLimit.PerRecipient, err = strconv.Atoi(v); err != nil || rateLimit.PerRecipient < 0 {
+			return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"RATE_LIMIT_PER_RECIPIENT"))
+		}
+	}
+
+	if v := os.Getenv(prefix + "RATE_LIMIT_PER_RECIPIENT_PERIOD_SECONDS"); v != "" {
+		var seconds int
+		if seconds, err = strconv.Atoi(v); err != nil || seconds <= 0 {
+			return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"RATE_LIMIT_PER_RECIPIENT_PERIOD_SECONDS"))
+		}
+
+		rateLimit.PerRecipientPeriod = time.Duration(int64(seconds)) * time.Second
+	}
+
+	if v := os.Getenv(prefix + "RATE_LIMIT_TRANSACTIONAL_PER_RECIPIENT"); v != "" {
+		rateLimit.TransactionalPerRecipient, err = strconv.Atoi(v)
+		if err != nil || rateLimit.TransactionalPerRecipient < 0 {
+			return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"RATE_LIMIT_TRANSACTIONAL_PER_RECIPIENT"))
+		}
+	}
+
+	if v := os.Getenv(prefix + "RATE_LIMIT_TRANSACTIONAL_PER_RECIPIENT_PERIOD_SECONDS"); v != "" {
+		var seconds int
+		if seconds, err = strconv.Atoi(v); err != nil || seconds <= 0 {
+			return nil, errors.WithStack(
+				fmt.Errorf("invalid %s value", prefix+"RATE_LIMIT_TRANSACTIONAL_PER_RECIPIENT_PERIOD_SECONDS"),
+			)
+		}
+
+		rateLimit.TransactionalPerRecipientPeriod = time.Duration(int64(seconds)) * time.Second
+	}
+
+	if v := os.Getenv(prefix + "RATE_LIMIT_GLOBAL"); v != "" {
+		if rateLimit.Global, err = strconv.Atoi(v); err != nil || rateLimit.Global < 0 {
+			return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"RATE_LIMIT_GLOBAL"))
+		}
+	}
+
+	if v := os.Getenv(prefix + "RATE_LIMIT_GLOBAL_PERIOD_SECONDS"); v != "" {
+		var seconds int
+		if seconds, err = strconv.Atoi(v); err != nil || seconds <= 0 {
+			return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"RATE_LIMIT_GLOBAL_PERIOD_SECONDS"))
+		}
+
+		rateLimit.GlobalPeriod = time.Duration(int64(seconds)) * time.Second
+	}
+
+	emailTransport := TransportSMTP
+	if v := os.Getenv(prefix + "TRANSPORT"); v != "" {
+		emailTransport = v
+	}
+
+	emailFrom := os.Getenv(prefix + "FROM")
+	if emailFrom == "" {
+		return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"FROM"))
+	}
+
+	emailConfig := Email{
+		Transport: emailTransport,
+		From:      emailFrom,
+		RateLimit: rateLimit,
+	}
+
+	switch emailTransport {
+	case TransportMaildir:
+		if emailConfig.MaildirPath = os.Getenv(prefix + "MAILDIR_PATH"); emailConfig.MaildirPath == "" {
+			return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"MAILDIR_PATH"))
+		}
+	case TransportSMTP:
+		smtpPort := 587
+		if v := os.Getenv(prefix + "SMTP_PORT"); v != "" {
+			if smtpPort, err = strconv.Atoi(v); err != nil || smtpPort <= 0 {
+				return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"SMTP_PORT"))
+			}
+		}
+
+		smtpTimeoutSeconds := 30
+		if v := os.Getenv(prefix + "SMTP_TIMEOUT_SECONDS"); v != "" {
+			if smtpTimeoutSeconds, err = strconv.Atoi(v); err != nil || smtpTimeoutSeconds <= 0 {
+				return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"SMTP_TIMEOUT_SECONDS"))
+			}
+		}
+
+		smtpSecurity := email.SecuritySTARTTLS
+		if v := os.Getenv(prefix + "SMTP_SECURITY"); v != "" {
+			smtpSecurity = v
+		}
+
+		emailConfig.SMTP = email.SMTPConfig{
+			Host:     os.Getenv(prefix + "SMTP_HOST"),
+			Port:     smtpPort,
+			Username: os.Getenv(prefix + "SMTP_USERNAME"),
+			Password: os.Getenv(prefix + "SMTP_PASSWORD"),
+			From:     emailFrom,
+			Security: smtpSecurity,
+			Timeout:  time.Duration(int64(smtpTimeoutSeconds)) * time.Second,
+		}
+
+		if emailConfig.SMTP.Host == "" {
+			return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"SMTP_HOST"))
+		}
+	default:
+		return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"TRANSPORT"))
+	}
+
 	config := Config{
 		RabbitMQ: rabbitmq.Config{
 			URI:                     os.Getenv(prefix + "RABBITMQ_AMQP_URI"),
 			MaxReconnections:        rabbitmqMaxReconnections,
 			ReconnectTimeoutSeconds: time.Duration(int64(rabbitmqReconnectTimeoutSeconds)) * time.Second,
+			PrefetchCount:           rabbitmqPrefetchCount,
+		},
+		Worker: Worker{
+			Concurrency:     workerConcurrency,
+			ShutdownTimeout: time.Duration(int64(workerShutdownTimeoutSeconds)) * time.Second,
+		},
+		Email: emailConfig,
+		Database: Database{
+			Host:     os.Getenv(prefix + "DB_HOST"),
+			Port:     databasePort,
+			User:     os.Getenv(prefix + "DB_USER"),
+			Password: os.Getenv(prefix + "DB_PASSWORD"),
+			Name:     os.Getenv(prefix + "DB_NAME"),
+		},
+		Redis: redis.Config{
+			Addr:     os.Getenv(prefix + "REDIS_ADDR"),
+			Password: os.Getenv(prefix + "REDIS_PASSWORD"),
+			DB:       redisDB,
 		},
 	}
 
@@ -4,11 +4,15 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"syscall"
 
 	"go.elastic.co/apm"
+	"go.elastic.co/apm/module/apmsql"
+	_ "go.elastic.co/apm/module/apmsql/pq"
 
 	"gitlab.com/slirx/newproj/pkg/queue"
 	"gitlab.com/slirx/newproj/pkg/rabbitmq"
@@ -16,12 +20,13 @@ import (
 	"gitlab.com/slirx/newproj/internal/email"
 	"gitlab.com/slirx/newproj/pkg/logger"
 	"gitlab.com/slirx/newproj/pkg/queue/worker"
+	"gitlab.com/slirx/newproj/pkg/redis"
 )
 
 func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
 		cancel()
@@ -37,6 +42,52 @@ func main() {
 		zapLogger.Fatal(err)
 	}
 
+	var sender email.Sender
+
+	switch conf.Email.Transport {
+	case TransportMaildir:
+		sender, err = email.NewMaildirSender(conf.Email.MaildirPath, conf.Email.From)
+	default:
+		sender, err = email.NewSMTPSender(conf.Email.SMTP)
+	}
+
+	if err != nil {
+		zapLogger.Fatal(err)
+	}
+
+	dbDSN := fmt.Sprintf(
+		"postgres://%s:%s@%s:%d/%s?sslmode=disable",
+		conf.Database.User, conf.Database.Password, conf.Database.Host, conf.Database.Port, conf.Database.Name,
+	)
+	db, err := apmsql.Open("postgres", dbDSN)
+	if err != nil {
+		zapLogger.Fatal(err)
+	}
+
+	defer func() {
+		if err := db.Close(); err != nil {
+			zapLogger.Error(err)
+		}
+	}()
+
+	redisClient, err := redis.New(ctx, conf.Redis)
+	if err != nil {
+		zapLogger.Fatal(err)
+	}
+
+	redisClient = redis.NewClientWithApm(redisClient)
+
+	defer func() {
+		if err := redisClient.Close(); err != nil {
+			zapLogger.Error(err)
+		}
+	}()
+
+	limiter, err := email.NewLimiter(redisClient, conf.Email.RateLimit)
+	if err != nil {
+		zapLogger.Fatal(err)
+	}
+
 	tracer := apm.DefaultTracer
 	tracer.Service.Name = "email-worker"
 
@@ -45,8 +96,10 @@ func main() {
 		rabbitmq.NewClient(conf.RabbitMQ, zapLogger, queue.JobEmailSend),
 		zapLogger,
 		tracer,
-		email.NewHandler(zapLogger),
+		email.NewHandler(zapLogger, sender, email.NewRepository(db), limiter),
 		queue.JobEmailSend,
+		worker.WithConcurrency(conf.Worker.Concurrency),
+		worker.WithShutdownTimeout(conf.Worker.ShutdownTimeout),
 	)
 	w.Run(ctx)
 }
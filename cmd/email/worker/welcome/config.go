@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gitlab.com/slirx/newproj/pkg/rabbitmq"
+)
+
+// Config represents combined configuration.
+type Config struct {
+	RabbitMQ rabbitmq.Config
+	Worker   Worker
+}
+
+// Worker represents configuration of queue worker.
+type Worker struct {
+	// Concurrency is the number of messages handled at the same time.
+	Concurrency int
+	// ShutdownTimeout is how long worker waits for in-flight messages on shutdown.
+	ShutdownTimeout time.Duration
+}
+
+// NewConfig returns initialized instance of configuration. It reads configuration from environment variables.
+// prefix represents prefix of environment variables' names.
+func NewConfig(prefix string) (*Config, error) {
+	var err error
+
+	var rabbitmqMaxReconnections int
+	if rabbitmqMaxReconnections, err = strconv.Atoi(os.Getenv(prefix + "RABBITMQ_MAX_RECONNECTIONS")); err != nil {
+		return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"RABBITMQ_MAX_RECONNECTIONS"))
+	}
+
+	var rabbitmqReconnectTimeoutSeconds int
+
+	rabbitmqReconnectTimeoutSeconds, err = strconv.Atoi(os.Getenv(prefix + "RABBITMQ_RECONNECT_TIMEOUT_SECONDS"))
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"RABBITMQ_RECONNECT_TIMEOUT_SECONDS"))
+	}
+
+	workerConcurrency := 1
+	if v := os.Getenv(prefix + "WORKER_CONCURRENCY"); v != "" {
+		if workerConcurrency, err = strconv.Atoi(v); err != nil || workerConcurrency <= 0 {
+			return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"WORKER_CONCURRENCY"))
+		}
+	}
+
+	workerShutdownTimeoutSeconds := 30
+	if v := os.Getenv(prefix + "WORKER_SHUTDOWN_TIMEOUT_SECONDS"); v != "" {
+		workerShutdownTimeoutSeconds, err = strconv.Atoi(v)
+		if err != nil || workerShutdownTimeoutSeconds < 0 {
+			return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"WORKER_SHUTDOWN_TIMEOUT_SECONDS"))
+		}
+	}
+
+	// by default prefetch as many messages as worker can handle at the same time
+	rabbitmqPrefetchCount := workerConcurrency
+	if v := os.Getenv(prefix + "RABBITMQ_PREFETCH_COUNT"); v != "" {
+		if rabbitmqPrefetchCount, err = strconv.Atoi(v); err != nil || rabbitmqPrefetchCount <= 0 {
+			return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"RABBITMQ_PREFETCH_COUNT"))
+		}
+	}
+
+	config := Config{
+		RabbitMQ: rabbitmq.Config{
+			URI:                     os.Getenv(prefix + "RABBITMQ_AMQP_URI"),
+			MaxReconnections:        rabbitmqMaxReconnections,
+			ReconnectTimeoutSeconds: time.Duration(int64(rabbitmqReconnectTimeoutSeconds)) * time.Second,
+			PrefetchCount:           rabbitmqPrefetchCount,
+		},
+		Worker: Worker{
+			Concurrency:     workerConcurrency,
+			ShutdownTimeout: time.Duration(int64(workerShutdownTimeoutSeconds)) * time.Second,
+		},
+	}
+
+	return &config, nil
+}
@@ -0,0 +1,72 @@
+// main package represents executable for sending welcome emails. it subscribes to user.registered domain events
+// and sends welcome email to the email queue for every registered user.
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"go.elastic.co/apm"
+
+	"gitlab.com/slirx/newproj/internal/email"
+	"gitlab.com/slirx/newproj/pkg/event"
+	"gitlab.com/slirx/newproj/pkg/event/subscriber"
+	"gitlab.com/slirx/newproj/pkg/logger"
+	"gitlab.com/slirx/newproj/pkg/queue/manager"
+	"gitlab.com/slirx/newproj/pkg/queue/worker"
+	"gitlab.com/slirx/newproj/pkg/rabbitmq"
+	"gitlab.com/slirx/newproj/pkg/template"
+)
+
+// queueName is the name of durable queue of the subscriber, so events are kept while the worker is offline.
+const queueName = "event:email/welcome"
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		cancel()
+	}()
+
+	zapLogger, err := logger.NewZapLogger()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	conf, err := NewConfig("EMAIL_WELCOME_")
+	if err != nil {
+		zapLogger.Fatal(err)
+	}
+
+	catalog, err := template.NewCatalog("template/messages")
+	if err != nil {
+		zapLogger.Fatal(err)
+	}
+
+	m := manager.NewManager(ctx, zapLogger, conf.RabbitMQ)
+	defer func() {
+		if err := m.Close(); err != nil {
+			zapLogger.Error(err)
+		}
+	}()
+
+	apmTracer := apm.DefaultTracer
+	apmTracer.Service.Name = "email-worker-welcome"
+
+	s := subscriber.NewSubscriber(
+		"email/welcome",
+		rabbitmq.NewClient(conf.RabbitMQ, zapLogger, queueName),
+		zapLogger,
+		apmTracer,
+		email.NewWelcomeHandler(zapLogger, m, template.New(), catalog),
+		[]string{event.KeyUserRegistered},
+		worker.WithConcurrency(conf.Worker.Concurrency),
+		worker.WithShutdownTimeout(conf.Worker.ShutdownTimeout),
+	)
+	s.Run(ctx)
+}
@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"gitlab.com/slirx/newproj/internal/api"
+	"gitlab.com/slirx/newproj/pkg/mtls"
 	"gitlab.com/slirx/newproj/pkg/rabbitmq"
 )
 
@@ -29,6 +30,8 @@ type Server struct {
 	ServiceConfig api.ServiceConfig
 	// CORSAllowedOrigins is a list of origins a cross-domain request can be executed from.
 	CORSAllowedOrigins []string
+	// TLS enables mutual TLS, internal endpoints authenticate services by client certificates in this case.
+	TLS mtls.Config
 }
 
 // NewConfig returns initialized instance of configuration. It reads configuration from environment variables.
@@ -68,6 +71,11 @@ func NewConfig(prefix string) (*Config, error) {
 		EnvoyURL: os.Getenv(prefix + "ENVOY_URL"),
 		Server: Server{
 			Addr: os.Getenv(prefix + "SERVER_ADDR"),
+			TLS: mtls.Config{
+				CertFile: os.Getenv(prefix + "SERVER_TLS_CERT_FILE"),
+				KeyFile:  os.Getenv(prefix + "SERVER_TLS_KEY_FILE"),
+				CAFile:   os.Getenv(prefix + "SERVER_TLS_CA_FILE"),
+			},
 			JWT: JWT{
 				Secret:          []byte(os.Getenv(prefix + "SERVER_JWT_SECRET")),
 				InternalSecrets: internalSecrets,
@@ -78,6 +86,11 @@ func NewConfig(prefix string) (*Config, error) {
 					Login:    os.Getenv(prefix + "SERVER_JWT_INTERNAL_LOGIN"),
 					Password: os.Getenv(prefix + "SERVER_JWT_INTERNAL_PASSWORD"),
 				},
+				TLS: mtls.Config{
+					CertFile: os.Getenv(prefix + "INTERNAL_TLS_CERT_FILE"),
+					KeyFile:  os.Getenv(prefix + "INTERNAL_TLS_KEY_FILE"),
+					CAFile:   os.Getenv(prefix + "INTERNAL_TLS_CA_FILE"),
+				},
 			},
 			CORSAllowedOrigins: corsAllowedOrigins,
 		},
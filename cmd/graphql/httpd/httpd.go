@@ -16,6 +16,7 @@ import (
 	"gitlab.com/slirx/newproj/pkg/http/apmmiddleware"
 	"gitlab.com/slirx/newproj/pkg/http/jwtmiddleware"
 	"gitlab.com/slirx/newproj/pkg/logger"
+	"gitlab.com/slirx/newproj/pkg/mtls"
 	"gitlab.com/slirx/newproj/pkg/tracer"
 	"gitlab.com/slirx/newproj/pkg/utils"
 )
@@ -72,6 +73,7 @@ func main() {
 				responseBuilder,
 				zapLogger,
 				conf.Server.JWT.InternalSecrets,
+				jwtmiddleware.WithClientCertificates(),
 			),
 			"/graphql",
 			apmmiddleware.WithTracer(apmTracer),
@@ -84,7 +86,19 @@ func main() {
 		Handler: router,
 	}
 
+	// internal endpoints authenticate services by client certificates in case mutual TLS is enabled
+	if conf.Server.TLS.Enabled() {
+		if server.TLSConfig, err = mtls.ServerConfig(conf.Server.TLS); err != nil {
+			zapLogger.Fatal(err)
+		}
+	}
+
 	go func() {
+		if conf.Server.TLS.Enabled() {
+			_ = server.ListenAndServeTLS("", "")
+			return
+		}
+
 		_ = server.ListenAndServe()
 	}()
 
@@ -1,13 +1,21 @@
 package main
 
 import (
+	"fmt"
 	"os"
+	"strconv"
 	"strings"
+
+	"github.com/pkg/errors"
+
+	"gitlab.com/slirx/newproj/pkg/mtls"
+	"gitlab.com/slirx/newproj/pkg/redis"
 )
 
 // Config represents combined configuration.
 type Config struct {
 	Server Server
+	Redis  redis.Config
 }
 
 type JWT struct {
@@ -22,11 +30,18 @@ type Server struct {
 	JWT  JWT
 	// CORSAllowedOrigins is a list of origins a cross-domain request can be executed from.
 	CORSAllowedOrigins []string
+	// TLS enables mutual TLS, internal endpoints authenticate services by client certificates in this case.
+	TLS mtls.Config
 }
 
 // NewConfig returns initialized instance of configuration. It reads configuration from environment variables.
 // prefix represents prefix of environment variables' names.
 func NewConfig(prefix string) (*Config, error) {
+	redisDB, err := strconv.Atoi(os.Getenv(prefix + "REDIS_DB"))
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"REDIS_DB"))
+	}
+
 	internalSecrets := make(map[string][]byte)
 	internalSecrets["user"] = []byte(os.Getenv(prefix + "SERVER_JWT_INTERNAL_USER_SECRET"))
 	//internalSecrets["graphql"] = []byte(os.Getenv(prefix + "SERVER_JWT_INTERNAL_GRAPHQL_SECRET"))
@@ -45,12 +60,22 @@ func NewConfig(prefix string) (*Config, error) {
 	config := Config{
 		Server: Server{
 			Addr: os.Getenv(prefix + "SERVER_ADDR"),
+			TLS: mtls.Config{
+				CertFile: os.Getenv(prefix + "SERVER_TLS_CERT_FILE"),
+				KeyFile:  os.Getenv(prefix + "SERVER_TLS_KEY_FILE"),
+				CAFile:   os.Getenv(prefix + "SERVER_TLS_CA_FILE"),
+			},
 			JWT: JWT{
 				Secret:          []byte(os.Getenv(prefix + "SERVER_JWT_SECRET")),
 				InternalSecrets: internalSecrets,
 			},
 			CORSAllowedOrigins: corsAllowedOrigins,
 		},
+		Redis: redis.Config{
+			Addr:     os.Getenv(prefix + "REDIS_ADDR"),
+			Password: os.Getenv(prefix + "REDIS_PASSWORD"),
+			DB:       redisDB,
+		},
 	}
 
 	return &config, nil
@@ -13,11 +13,15 @@ import (
 	"github.com/go-chi/cors"
 	"go.elastic.co/apm"
 
+	"gitlab.com/slirx/newproj/internal/auth"
 	"gitlab.com/slirx/newproj/internal/media"
 	"gitlab.com/slirx/newproj/pkg/api"
 	"gitlab.com/slirx/newproj/pkg/http/apmmiddleware"
 	"gitlab.com/slirx/newproj/pkg/http/jwtmiddleware"
 	"gitlab.com/slirx/newproj/pkg/logger"
+	"gitlab.com/slirx/newproj/pkg/mtls"
+	"gitlab.com/slirx/newproj/pkg/redis"
+	"gitlab.com/slirx/newproj/pkg/revocation"
 	"gitlab.com/slirx/newproj/pkg/tracer"
 	"gitlab.com/slirx/newproj/pkg/utils"
 )
@@ -41,6 +45,21 @@ func main() {
 		zapLogger.Fatal(err)
 	}
 
+	redisClient, err := redis.New(ctx, conf.Redis)
+	if err != nil {
+		zapLogger.Fatal(err)
+	}
+
+	redisClient = redis.NewClientWithApm(redisClient)
+
+	defer func() {
+		if err := redisClient.Close(); err != nil {
+			zapLogger.Error(err)
+		}
+	}()
+
+	revocationStore := revocation.NewStore(redisClient, auth.AccessTokenTTL)
+
 	t := tracer.NewAPMTracer()
 	responseBuilder := api.NewResponseBuilder(t)
 	handler := media.NewHandler(zapLogger, responseBuilder)
@@ -66,6 +85,7 @@ func main() {
 				responseBuilder,
 				zapLogger,
 				conf.Server.JWT.Secret,
+				jwtmiddleware.WithRevocation(revocationStore),
 			),
 			"/media",
 			apmmiddleware.WithTracer(apmTracer),
@@ -80,6 +100,7 @@ func main() {
 				responseBuilder,
 				zapLogger,
 				conf.Server.JWT.Secret,
+				jwtmiddleware.WithRevocation(revocationStore),
 			),
 			"/media",
 			apmmiddleware.WithTracer(apmTracer),
@@ -94,6 +115,7 @@ func main() {
 				responseBuilder,
 				zapLogger,
 				conf.Server.JWT.InternalSecrets,
+				jwtmiddleware.WithClientCertificates(),
 			),
 			"/internal/media",
 			apmmiddleware.WithTracer(apmTracer),
@@ -110,7 +132,19 @@ func main() {
 		Handler: router,
 	}
 
+	// internal endpoints authenticate services by client certificates in case mutual TLS is enabled
+	if conf.Server.TLS.Enabled() {
+		if server.TLSConfig, err = mtls.ServerConfig(conf.Server.TLS); err != nil {
+			zapLogger.Fatal(err)
+		}
+	}
+
 	go func() {
+		if conf.Server.TLS.Enabled() {
+			_ = server.ListenAndServeTLS("", "")
+			return
+		}
+
 		_ = server.ListenAndServe()
 	}()
 
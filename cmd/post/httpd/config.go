@@ -5,18 +5,23 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
 	"gitlab.com/slirx/newproj/internal/api"
+	"gitlab.com/slirx/newproj/pkg/mtls"
+	"gitlab.com/slirx/newproj/pkg/queue"
 	"gitlab.com/slirx/newproj/pkg/rabbitmq"
 	"gitlab.com/slirx/newproj/pkg/redis"
 )
 
 // Config represents combined configuration.
 type Config struct {
-	Server               Server
-	RabbitMQ             rabbitmq.Config
+	Server   Server
+	RabbitMQ rabbitmq.Config
+	// QueueBackend is queue.BackendRabbitMQ or queue.BackendMemory.
+	QueueBackend         string
 	Redis                redis.Config
 	Database             Database
 	InternalAPIConfig    api.ServiceConfig
@@ -32,7 +37,8 @@ type Database struct {
 }
 
 type JWT struct {
-	Secret []byte
+	Secret          []byte
+	InternalSecrets map[string][]byte // JWT secrets for microservices (service-to-service communication)
 }
 
 // Server represents web server configuration.
@@ -42,6 +48,8 @@ type Server struct {
 	JWT  JWT
 	// CORSAllowedOrigins is a list of origins a cross-domain request can be executed from.
 	CORSAllowedOrigins []string
+	// TLS enables mutual TLS, internal endpoints authenticate services by client certificates in this case.
+	TLS mtls.Config
 }
 
 // NewConfig returns initialized instance of configuration. It reads configuration from environment variables.
@@ -49,17 +57,17 @@ type Server struct {
 func NewConfig(prefix string) (*Config, error) {
 	var err error
 
-	//var rabbitmqMaxReconnections int
-	//if rabbitmqMaxReconnections, err = strconv.Atoi(os.Getenv(prefix + "RABBITMQ_MAX_RECONNECTIONS")); err != nil {
-	//	return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"RABBITMQ_MAX_RECONNECTIONS"))
-	//}
+	var rabbitmqMaxReconnections int
+	if rabbitmqMaxReconnections, err = strconv.Atoi(os.Getenv(prefix + "RABBITMQ_MAX_RECONNECTIONS")); err != nil {
+		return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"RABBITMQ_MAX_RECONNECTIONS"))
+	}
 
-	//var rabbitmqReconnectTimeoutSeconds int
+	var rabbitmqReconnectTimeoutSeconds int
 
-	//rabbitmqReconnectTimeoutSeconds, err = strconv.Atoi(os.Getenv(prefix + "RABBITMQ_RECONNECT_TIMEOUT_SECONDS"))
-	//if err != nil {
-	//	return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"RABBITMQ_RECONNECT_TIMEOUT_SECONDS"))
-	//}
+	rabbitmqReconnectTimeoutSeconds, err = strconv.Atoi(os.Getenv(prefix + "RABBITMQ_RECONNECT_TIMEOUT_SECONDS"))
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"RABBITMQ_RECONNECT_TIMEOUT_SECONDS"))
+	}
 
 	var databasePort int
 	if databasePort, err = strconv.Atoi(os.Getenv(prefix + "DB_PORT")); err != nil {
@@ -71,6 +79,9 @@ func NewConfig(prefix string) (*Config, error) {
 		return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"REDIS_DB"))
 	}
 
+	internalSecrets := make(map[string][]byte)
+	internalSecrets["email"] = []byte(os.Getenv(prefix + "SERVER_JWT_INTERNAL_EMAIL_SECRET"))
+
 	endpoints := make(map[string]string)
 	endpoints["auth"] = os.Getenv(prefix + "ENDPOINT_AUTH")
 	endpoints["user"] = os.Getenv(prefix + "ENDPOINT_USER")
@@ -86,11 +97,27 @@ func NewConfig(prefix string) (*Config, error) {
 		corsAllowedOrigins = append(corsAllowedOrigins, origin)
 	}
 
+	queueBackend := queue.BackendRabbitMQ
+	if v := os.Getenv(prefix + "QUEUE_BACKEND"); v != "" {
+		if v != queue.BackendRabbitMQ && v != queue.BackendMemory {
+			return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"QUEUE_BACKEND"))
+		}
+
+		queueBackend = v
+	}
+
 	config := Config{
+		QueueBackend: queueBackend,
 		Server: Server{
 			Addr: os.Getenv(prefix + "SERVER_ADDR"),
+			TLS: mtls.Config{
+				CertFile: os.Getenv(prefix + "SERVER_TLS_CERT_FILE"),
+				KeyFile:  os.Getenv(prefix + "SERVER_TLS_KEY_FILE"),
+				CAFile:   os.Getenv(prefix + "SERVER_TLS_CA_FILE"),
+			},
 			JWT: JWT{
-				Secret: []byte(os.Getenv(prefix + "SERVER_JWT_SECRET")),
+				Secret:          []byte(os.Getenv(prefix + "SERVER_JWT_SECRET")),
+				InternalSecrets: internalSecrets,
 			},
 			CORSAllowedOrigins: corsAllowedOrigins,
 		},
@@ -100,13 +127,18 @@ func NewConfig(prefix string) (*Config, error) {
 				Login:    os.Getenv(prefix + "SERVER_JWT_INTERNAL_LOGIN"),
 				Password: os.Getenv(prefix + "SERVER_JWT_INTERNAL_PASSWORD"),
 			},
+			TLS: mtls.Config{
+				CertFile: os.Getenv(prefix + "INTERNAL_TLS_CERT_FILE"),
+				KeyFile:  os.Getenv(prefix + "INTERNAL_TLS_KEY_FILE"),
+				CAFile:   os.Getenv(prefix + "INTERNAL_TLS_CA_FILE"),
+			},
 		},
 		InternalAPIEndpoints: endpoints,
-		//RabbitMQ: rabbitmq.Config{
-		//	URI:                     os.Getenv(prefix + "RABBITMQ_AMQP_URI"),
-		//	MaxReconnections:        rabbitmqMaxReconnections,
-		//	ReconnectTimeoutSeconds: time.Duration(int64(rabbitmqReconnectTimeoutSeconds)) * time.Second,
-		//},
+		RabbitMQ: rabbitmq.Config{
+			URI:                     os.Getenv(prefix + "RABBITMQ_AMQP_URI"),
+			MaxReconnections:        rabbitmqMaxReconnections,
+			ReconnectTimeoutSeconds: time.Duration(int64(rabbitmqReconnectTimeoutSeconds)) * time.Second,
+		},
 		Database: Database{
 			Host:     os.Getenv(prefix + "DB_HOST"),
 			Port:     databasePort,
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/cors"
@@ -14,13 +15,23 @@ import (
 	"go.elastic.co/apm/module/apmsql"
 	_ "go.elastic.co/apm/module/apmsql/pq"
 
+	authapi "gitlab.com/slirx/newproj/internal/api/auth"
 	"gitlab.com/slirx/newproj/internal/api/user"
+	"gitlab.com/slirx/newproj/internal/auth"
 	"gitlab.com/slirx/newproj/internal/post"
 	"gitlab.com/slirx/newproj/pkg/api"
+	"gitlab.com/slirx/newproj/pkg/apitoken"
+	"gitlab.com/slirx/newproj/pkg/event/publisher"
 	"gitlab.com/slirx/newproj/pkg/http/apmmiddleware"
 	"gitlab.com/slirx/newproj/pkg/http/jwtmiddleware"
 	"gitlab.com/slirx/newproj/pkg/logger"
+	"gitlab.com/slirx/newproj/pkg/mtls"
+	"gitlab.com/slirx/newproj/pkg/queue"
+	"gitlab.com/slirx/newproj/pkg/queue/manager"
+	"gitlab.com/slirx/newproj/pkg/queue/memory"
+	"gitlab.com/slirx/newproj/pkg/queue/worker"
 	"gitlab.com/slirx/newproj/pkg/redis"
+	"gitlab.com/slirx/newproj/pkg/revocation"
 	"gitlab.com/slirx/newproj/pkg/tracer"
 	"gitlab.com/slirx/newproj/pkg/utils"
 )
@@ -44,12 +55,21 @@ func main() {
 		zapLogger.Fatal(err)
 	}
 
-	//m := manager.NewManager(ctx, zapLogger, conf.RabbitMQ)
-	//defer func() {
-	//	if err := m.Close(); err != nil {
-	//		zapLogger.Error(err)
-	//	}
-	//}()
+	var m manager.Manager
+	var broker *memory.Broker
+
+	if conf.QueueBackend == queue.BackendMemory {
+		broker = memory.NewBroker()
+		m = manager.NewMemoryManager(broker)
+	} else {
+		m = manager.NewManager(ctx, zapLogger, conf.RabbitMQ)
+	}
+
+	defer func() {
+		if err := m.Close(); err != nil {
+			zapLogger.Error(err)
+		}
+	}()
 
 	dbDSN := fmt.Sprintf(
 		"postgres://%s:%s@%s:%d/%s?sslmode=disable",
@@ -79,6 +99,50 @@ func main() {
 		}
 	}()
 
+	// workers of the service are run in the same process in case of in-memory queue
+	if broker != nil {
+		workers := []worker.Worker{
+			worker.NewMemoryWorker(
+				"post/worker/follow",
+				broker,
+				zapLogger,
+				apm.DefaultTracer,
+				post.NewFollowHandler(zapLogger, post.NewRepository(db)),
+				queue.JobPostFollow,
+			),
+			worker.NewMemoryWorker(
+				"post/worker/unfollow",
+				broker,
+				zapLogger,
+				apm.DefaultTracer,
+				post.NewUnfollowHandler(zapLogger, post.NewRepository(db)),
+				queue.JobPostUnfollow,
+			),
+		}
+
+		var wg sync.WaitGroup
+		// in-flight jobs are finished before database is closed
+		defer wg.Wait()
+
+		for _, w := range workers {
+			wg.Add(1)
+
+			go func(w worker.Worker) {
+				defer wg.Done()
+				w.Run(ctx)
+			}(w)
+		}
+	}
+
+	revocationStore := revocation.NewStore(redisClient, auth.AccessTokenTTL)
+	// api tokens are kept by auth service, they are loaded through internal API in case they aren't cached
+	internalAuthAPI, err := authapi.NewAPI(conf.InternalAPIEndpoints, &conf.InternalAPIConfig)
+	if err != nil {
+		zapLogger.Fatal(err)
+	}
+
+	apiTokenStore := apitoken.NewStore(redisClient, internalAuthAPI)
+
 	t := tracer.NewAPMTracer()
 	responseBuilder := api.NewResponseBuilder(t)
 
@@ -87,7 +151,13 @@ func main() {
 		zapLogger.Fatal(err)
 	}
 
-	service := post.NewService(post.NewRepository(db), internalAPI, redisClient)
+	service := post.NewService(
+		post.NewRepository(db),
+		internalAPI,
+		redisClient,
+		publisher.NewPublisher(m),
+		zapLogger,
+	)
 	handler := post.NewHandler(service, zapLogger, responseBuilder)
 
 	apmTracer := apm.DefaultTracer
@@ -113,6 +183,7 @@ func main() {
 				responseBuilder,
 				zapLogger,
 				conf.Server.JWT.Secret,
+				jwtmiddleware.WithRevocation(revocationStore),
 			),
 			"/post/user/{uid}",
 			apmmiddleware.WithTracer(apmTracer),
@@ -127,6 +198,9 @@ func main() {
 				responseBuilder,
 				zapLogger,
 				conf.Server.JWT.Secret,
+				jwtmiddleware.WithRevocation(revocationStore),
+				jwtmiddleware.WithAPITokens(apiTokenStore),
+				jwtmiddleware.WithScopes(jwtmiddleware.ScopePostWrite),
 			),
 			"/post",
 			apmmiddleware.WithTracer(apmTracer),
@@ -141,6 +215,7 @@ func main() {
 				responseBuilder,
 				zapLogger,
 				conf.Server.JWT.Secret,
+				jwtmiddleware.WithRevocation(revocationStore),
 			),
 			"/post/feed/{userID}",
 			apmmiddleware.WithTracer(apmTracer),
@@ -155,19 +230,47 @@ func main() {
 				responseBuilder,
 				zapLogger,
 				conf.Server.JWT.Secret,
+				jwtmiddleware.WithRevocation(revocationStore),
 			),
 			"/post/search",
 			apmmiddleware.WithTracer(apmTracer),
 			apmmiddleware.WithRecovery(recoveryFunc),
 		),
 	)
+	router.Get(
+		"/internal/post/feed/{userID}",
+		apmmiddleware.Wrap(
+			jwtmiddleware.WrapInternal(
+				handler.InternalFeedSince,
+				responseBuilder,
+				zapLogger,
+				conf.Server.JWT.InternalSecrets,
+				jwtmiddleware.WithClientCertificates(),
+			),
+			"/internal/post/feed/{userID}",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
 
 	server := http.Server{
 		Addr:    conf.Server.Addr,
 		Handler: router,
 	}
 
+	// internal endpoints authenticate services by client certificates in case mutual TLS is enabled
+	if conf.Server.TLS.Enabled() {
+		if server.TLSConfig, err = mtls.ServerConfig(conf.Server.TLS); err != nil {
+			zapLogger.Fatal(err)
+		}
+	}
+
 	go func() {
+		if conf.Server.TLS.Enabled() {
+			_ = server.ListenAndServeTLS("", "")
+			return
+		}
+
 		_ = server.ListenAndServe()
 	}()
 
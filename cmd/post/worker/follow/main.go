@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"syscall"
 
 	"go.elastic.co/apm"
 	"go.elastic.co/apm/module/apmsql"
@@ -21,7 +22,7 @@ import (
 func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
 		cancel()
@@ -37,6 +38,12 @@ func main() {
 		zapLogger.Fatal(err)
 	}
 
+	// workers are run by httpd of the service in case of in-memory queue, because memory isn't shared by processes
+	if conf.QueueBackend == queue.BackendMemory {
+		zapLogger.Info("in-memory queue is consumed by httpd of the service, worker is not started")
+		return
+	}
+
 	dbDSN := fmt.Sprintf(
 		"postgres://%s:%s@%s:%d/%s?sslmode=disable",
 		conf.Database.User, conf.Database.Password, conf.Database.Host, conf.Database.Port, conf.Database.Name,
@@ -56,6 +63,9 @@ func main() {
 		apmTrace,
 		post.NewFollowHandler(zapLogger, post.NewRepository(db)),
 		queue.JobPostFollow,
+		worker.WithConcurrency(conf.Worker.Concurrency),
+		worker.WithShutdownTimeout(conf.Worker.ShutdownTimeout),
+		worker.WithKeyFunc(worker.OrderingKey),
 	)
 	w.Run(ctx)
 }
@@ -9,14 +9,26 @@ import (
 
 	"github.com/pkg/errors"
 
+	"gitlab.com/slirx/newproj/internal/api"
+	"gitlab.com/slirx/newproj/internal/registration"
+	"gitlab.com/slirx/newproj/pkg/mtls"
+	"gitlab.com/slirx/newproj/pkg/queue"
 	"gitlab.com/slirx/newproj/pkg/rabbitmq"
 )
 
+// defaultReservedLogins are used in case reserved logins are not configured.
+var defaultReservedLogins = []string{"admin", "edit", "settings", "profile", "user"}
+
 // Config represents combined configuration.
 type Config struct {
 	Server   Server
 	RabbitMQ rabbitmq.Config
-	Database Database
+	// QueueBackend is queue.BackendRabbitMQ or queue.BackendMemory.
+	QueueBackend         string
+	Database             Database
+	InternalAPIConfig    api.ServiceConfig
+	InternalAPIEndpoints map[string]string
+	ServiceConfig        registration.Config
 }
 
 type Database struct {
@@ -57,6 +69,23 @@ func NewConfig(prefix string) (*Config, error) {
 		return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"DB_PORT"))
 	}
 
+	endpoints := make(map[string]string)
+	endpoints["auth"] = os.Getenv(prefix + "ENDPOINT_AUTH")
+
+	reservedLogins := make([]string, 0)
+	for _, login := range strings.Split(os.Getenv(prefix+"SERVICE_RESERVED_LOGINS"), ",") {
+		login = strings.TrimSpace(login)
+		if login == "" {
+			continue
+		}
+
+		reservedLogins = append(reservedLogins, login)
+	}
+
+	if len(reservedLogins) == 0 {
+		reservedLogins = defaultReservedLogins
+	}
+
 	corsAllowedOrigins := make([]string, 0)
 	tmpAllowedOrigins := strings.Split(os.Getenv(prefix+"SERVER_CORS_ALLOWED_ORIGINS"), ",")
 	for _, origin := range tmpAllowedOrigins {
@@ -68,7 +97,17 @@ func NewConfig(prefix string) (*Config, error) {
 		corsAllowedOrigins = append(corsAllowedOrigins, origin)
 	}
 
+	queueBackend := queue.BackendRabbitMQ
+	if v := os.Getenv(prefix + "QUEUE_BACKEND"); v != "" {
+		if v != queue.BackendRabbitMQ && v != queue.BackendMemory {
+			return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"QUEUE_BACKEND"))
+		}
+
+		queueBackend = v
+	}
+
 	config := Config{
+		QueueBackend: queueBackend,
 		Server: Server{
 			Addr:               os.Getenv(prefix + "SERVER_ADDR"),
 			CORSAllowedOrigins: corsAllowedOrigins,
@@ -85,6 +124,22 @@ func NewConfig(prefix string) (*Config, error) {
 			Password: os.Getenv(prefix + "DB_PASSWORD"),
 			Name:     os.Getenv(prefix + "DB_NAME"),
 		},
+		InternalAPIConfig: api.ServiceConfig{
+			InternalJWT: api.InternalJWT{
+				Endpoint: os.Getenv(prefix + "SERVER_JWT_INTERNAL_ENDPOINT"),
+				Login:    os.Getenv(prefix + "SERVER_JWT_INTERNAL_LOGIN"),
+				Password: os.Getenv(prefix + "SERVER_JWT_INTERNAL_PASSWORD"),
+			},
+			TLS: mtls.Config{
+				CertFile: os.Getenv(prefix + "INTERNAL_TLS_CERT_FILE"),
+				KeyFile:  os.Getenv(prefix + "INTERNAL_TLS_KEY_FILE"),
+				CAFile:   os.Getenv(prefix + "INTERNAL_TLS_CA_FILE"),
+			},
+		},
+		InternalAPIEndpoints: endpoints,
+		ServiceConfig: registration.Config{
+			ReservedLogins: reservedLogins,
+		},
 	}
 
 	return &config, nil
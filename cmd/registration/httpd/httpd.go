@@ -14,11 +14,14 @@ import (
 	"go.elastic.co/apm/module/apmsql"
 	_ "go.elastic.co/apm/module/apmsql/pq"
 
+	"gitlab.com/slirx/newproj/internal/api/auth"
 	"gitlab.com/slirx/newproj/internal/registration"
 	"gitlab.com/slirx/newproj/pkg/api"
 	"gitlab.com/slirx/newproj/pkg/http/apmmiddleware"
 	"gitlab.com/slirx/newproj/pkg/logger"
+	"gitlab.com/slirx/newproj/pkg/queue"
 	"gitlab.com/slirx/newproj/pkg/queue/manager"
+	"gitlab.com/slirx/newproj/pkg/queue/memory"
 	"gitlab.com/slirx/newproj/pkg/template"
 	"gitlab.com/slirx/newproj/pkg/tracer"
 	"gitlab.com/slirx/newproj/pkg/utils"
@@ -45,7 +48,19 @@ func main() {
 		zapLogger.Fatal(err)
 	}
 
-	m := manager.NewManager(ctx, zapLogger, conf.RabbitMQ)
+	var m manager.Manager
+
+	if conf.QueueBackend == queue.BackendMemory {
+		broker := memory.NewBroker()
+		// the service has no workers, jobs of other services are kept in memory
+		broker.DeclareQueue(queue.JobEmailSend)
+		broker.DeclareQueue(queue.JobAuthCreate)
+
+		m = manager.NewMemoryManager(broker)
+	} else {
+		m = manager.NewManager(ctx, zapLogger, conf.RabbitMQ)
+	}
+
 	defer func() {
 		if err := m.Close(); err != nil {
 			zapLogger.Error(err)
@@ -72,7 +87,25 @@ func main() {
 
 	tg := template.New()
 
-	service := registration.NewService(t, registration.NewRepository(db), m, tg)
+	catalog, err := template.NewCatalog("template/messages")
+	if err != nil {
+		zapLogger.Fatal(err)
+	}
+
+	internalAuthAPI, err := auth.NewAPI(conf.InternalAPIEndpoints, &conf.InternalAPIConfig)
+	if err != nil {
+		zapLogger.Fatal(err)
+	}
+
+	service := registration.NewService(
+		t,
+		registration.NewRepository(db),
+		m,
+		tg,
+		catalog,
+		internalAuthAPI,
+		conf.ServiceConfig,
+	)
 	handler := registration.NewHandler(service, zapLogger, responseBuilder)
 
 	apmTracer := apm.DefaultTracer
@@ -108,6 +141,15 @@ func main() {
 		),
 	)
 
+	router.Post("/registration/resend",
+		apmmiddleware.Wrap(
+			handler.Resend,
+			"/registration/resend",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+
 	server := http.Server{
 		Addr:    conf.Server.Addr,
 		Handler: router,
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/module/apmsql"
+	_ "go.elastic.co/apm/module/apmsql/pq"
+
+	"gitlab.com/slirx/newproj/internal/registration"
+	"gitlab.com/slirx/newproj/pkg/logger"
+	"gitlab.com/slirx/newproj/pkg/queue"
+	"gitlab.com/slirx/newproj/pkg/queue/manager"
+	"gitlab.com/slirx/newproj/pkg/queue/worker"
+	"gitlab.com/slirx/newproj/pkg/rabbitmq"
+)
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		cancel()
+	}()
+
+	zapLogger, err := logger.NewZapLogger()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	conf, err := NewConfig("REGISTRATION_WORKER_EXTERNAL_")
+	if err != nil {
+		zapLogger.Fatal(err)
+	}
+
+	// jobs are sent by other processes, so there is nothing to consume in case of in-memory queue
+	if conf.QueueBackend == queue.BackendMemory {
+		zapLogger.Info("in-memory queue is not shared by processes, worker is not started")
+		return
+	}
+
+	dbDSN := fmt.Sprintf(
+		"postgres://%s:%s@%s:%d/%s?sslmode=disable",
+		conf.Database.User, conf.Database.Password, conf.Database.Host, conf.Database.Port, conf.Database.Name,
+	)
+	db, err := apmsql.Open("postgres", dbDSN)
+	if err != nil {
+		zapLogger.Fatal(err)
+	}
+
+	m := manager.NewManager(ctx, zapLogger, conf.RabbitMQ)
+	defer func() {
+		if err := m.Close(); err != nil {
+			zapLogger.Error(err)
+		}
+	}()
+
+	apmTrace := apm.DefaultTracer
+	apmTrace.Service.Name = "registration-worker-external"
+
+	w := worker.NewWorker(
+		"registration/worker/external",
+		rabbitmq.NewClient(conf.RabbitMQ, zapLogger, queue.JobRegistrationExternal),
+		zapLogger,
+		apmTrace,
+		registration.NewExternalHandler(zapLogger, registration.NewRepository(db), m),
+		queue.JobRegistrationExternal,
+		worker.WithConcurrency(conf.Worker.Concurrency),
+		worker.WithShutdownTimeout(conf.Worker.ShutdownTimeout),
+	)
+	w.Run(ctx)
+}
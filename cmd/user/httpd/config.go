@@ -10,16 +10,25 @@ import (
 	"github.com/pkg/errors"
 
 	"gitlab.com/slirx/newproj/internal/api"
+	"gitlab.com/slirx/newproj/pkg/mtls"
+	"gitlab.com/slirx/newproj/pkg/queue"
 	"gitlab.com/slirx/newproj/pkg/rabbitmq"
+	"gitlab.com/slirx/newproj/pkg/redis"
 )
 
 // Config represents combined configuration.
 type Config struct {
-	Server               Server
-	RabbitMQ             rabbitmq.Config
+	Server   Server
+	RabbitMQ rabbitmq.Config
+	// QueueBackend is queue.BackendRabbitMQ or queue.BackendMemory.
+	QueueBackend         string
+	Redis                redis.Config
 	Database             Database
 	InternalAPIConfig    api.ServiceConfig
 	InternalAPIEndpoints map[string]string
+	// UnsubscribeSecret is a secret which unsubscribe links of emails are signed with. It should be the same as
+	// the one of email digest.
+	UnsubscribeSecret []byte
 }
 
 type Database struct {
@@ -42,6 +51,8 @@ type Server struct {
 	JWT  JWT
 	// CORSAllowedOrigins is a list of origins a cross-domain request can be executed from.
 	CORSAllowedOrigins []string
+	// TLS enables mutual TLS, internal endpoints authenticate services by client certificates in this case.
+	TLS mtls.Config
 }
 
 // NewConfig returns initialized instance of configuration. It reads configuration from environment variables.
@@ -66,14 +77,25 @@ func NewConfig(prefix string) (*Config, error) {
 		return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"DB_PORT"))
 	}
 
+	var redisDB int
+	if redisDB, err = strconv.Atoi(os.Getenv(prefix + "REDIS_DB")); err != nil {
+		return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"REDIS_DB"))
+	}
+
 	internalSecrets := make(map[string][]byte)
 	internalSecrets["post"] = []byte(os.Getenv(prefix + "SERVER_JWT_INTERNAL_POST_SECRET"))
 	internalSecrets["graphql"] = []byte(os.Getenv(prefix + "SERVER_JWT_INTERNAL_GRAPHQL_SECRET"))
+	internalSecrets["email"] = []byte(os.Getenv(prefix + "SERVER_JWT_INTERNAL_EMAIL_SECRET"))
 
 	endpoints := make(map[string]string)
 	endpoints["auth"] = os.Getenv(prefix + "ENDPOINT_AUTH")
 	endpoints["media"] = os.Getenv(prefix + "ENDPOINT_MEDIA")
 
+	unsubscribeSecret := os.Getenv(prefix + "UNSUBSCRIBE_SECRET")
+	if unsubscribeSecret == "" {
+		return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"UNSUBSCRIBE_SECRET"))
+	}
+
 	corsAllowedOrigins := make([]string, 0)
 	tmpAllowedOrigins := strings.Split(os.Getenv(prefix+"SERVER_CORS_ALLOWED_ORIGINS"), ",")
 	for _, origin := range tmpAllowedOrigins {
@@ -85,9 +107,24 @@ func NewConfig(prefix string) (*Config, error) {
 		corsAllowedOrigins = append(corsAllowedOrigins, origin)
 	}
 
+	queueBackend := queue.BackendRabbitMQ
+	if v := os.Getenv(prefix + "QUEUE_BACKEND"); v != "" {
+		if v != queue.BackendRabbitMQ && v != queue.BackendMemory {
+			return nil, errors.WithStack(fmt.Errorf("invalid %s value", prefix+"QUEUE_BACKEND"))
+		}
+
+		queueBackend = v
+	}
+
 	config := Config{
+		QueueBackend: queueBackend,
 		Server: Server{
 			Addr: os.Getenv(prefix + "SERVER_ADDR"),
+			TLS: mtls.Config{
+				CertFile: os.Getenv(prefix + "SERVER_TLS_CERT_FILE"),
+				KeyFile:  os.Getenv(prefix + "SERVER_TLS_KEY_FILE"),
+				CAFile:   os.Getenv(prefix + "SERVER_TLS_CA_FILE"),
+			},
 			JWT: JWT{
 				Secret:          []byte(os.Getenv(prefix + "SERVER_JWT_SECRET")),
 				InternalSecrets: internalSecrets,
@@ -100,13 +137,24 @@ func NewConfig(prefix string) (*Config, error) {
 				Login:    os.Getenv(prefix + "SERVER_JWT_INTERNAL_LOGIN"),
 				Password: os.Getenv(prefix + "SERVER_JWT_INTERNAL_PASSWORD"),
 			},
+			TLS: mtls.Config{
+				CertFile: os.Getenv(prefix + "INTERNAL_TLS_CERT_FILE"),
+				KeyFile:  os.Getenv(prefix + "INTERNAL_TLS_KEY_FILE"),
+				CAFile:   os.Getenv(prefix + "INTERNAL_TLS_CA_FILE"),
+			},
 		},
 		InternalAPIEndpoints: endpoints,
+		UnsubscribeSecret:    []byte(unsubscribeSecret),
 		RabbitMQ: rabbitmq.Config{
 			URI:                     os.Getenv(prefix + "RABBITMQ_AMQP_URI"),
 			MaxReconnections:        rabbitmqMaxReconnections,
 			ReconnectTimeoutSeconds: time.Duration(int64(rabbitmqReconnectTimeoutSeconds)) * time.Second,
 		},
+		Redis: redis.Config{
+			Addr:     os.Getenv(prefix + "REDIS_ADDR"),
+			Password: os.Getenv(prefix + "REDIS_PASSWORD"),
+			DB:       redisDB,
+		},
 		Database: Database{
 			Host:     os.Getenv(prefix + "DB_HOST"),
 			Port:     databasePort,
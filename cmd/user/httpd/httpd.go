@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/cors"
@@ -14,13 +15,24 @@ import (
 	"go.elastic.co/apm/module/apmsql"
 	_ "go.elastic.co/apm/module/apmsql/pq"
 
+	authapi "gitlab.com/slirx/newproj/internal/api/auth"
 	"gitlab.com/slirx/newproj/internal/api/media"
+	"gitlab.com/slirx/newproj/internal/auth"
 	"gitlab.com/slirx/newproj/internal/user"
 	"gitlab.com/slirx/newproj/pkg/api"
+	"gitlab.com/slirx/newproj/pkg/apitoken"
+	"gitlab.com/slirx/newproj/pkg/event/publisher"
 	"gitlab.com/slirx/newproj/pkg/http/apmmiddleware"
 	"gitlab.com/slirx/newproj/pkg/http/jwtmiddleware"
 	"gitlab.com/slirx/newproj/pkg/logger"
+	"gitlab.com/slirx/newproj/pkg/mtls"
+	"gitlab.com/slirx/newproj/pkg/queue"
 	"gitlab.com/slirx/newproj/pkg/queue/manager"
+	"gitlab.com/slirx/newproj/pkg/queue/memory"
+	"gitlab.com/slirx/newproj/pkg/queue/worker"
+	"gitlab.com/slirx/newproj/pkg/redis"
+	"gitlab.com/slirx/newproj/pkg/revocation"
+	"gitlab.com/slirx/newproj/pkg/template"
 	"gitlab.com/slirx/newproj/pkg/tracer"
 	"gitlab.com/slirx/newproj/pkg/utils"
 )
@@ -44,7 +56,16 @@ func main() {
 		zapLogger.Fatal(err)
 	}
 
-	m := manager.NewManager(ctx, zapLogger, conf.RabbitMQ)
+	var m manager.Manager
+	var broker *memory.Broker
+
+	if conf.QueueBackend == queue.BackendMemory {
+		broker = memory.NewBroker()
+		m = manager.NewMemoryManager(broker)
+	} else {
+		m = manager.NewManager(ctx, zapLogger, conf.RabbitMQ)
+	}
+
 	defer func() {
 		if err := m.Close(); err != nil {
 			zapLogger.Error(err)
@@ -66,6 +87,68 @@ func main() {
 		}
 	}()
 
+	redisClient, err := redis.New(ctx, conf.Redis)
+	if err != nil {
+		zapLogger.Fatal(err)
+	}
+
+	redisClient = redis.NewClientWithApm(redisClient)
+
+	defer func() {
+		if err := redisClient.Close(); err != nil {
+			zapLogger.Error(err)
+		}
+	}()
+
+	// workers of the service are run in the same process in case of in-memory queue
+	if broker != nil {
+		// jobs of other services are kept in memory, because their workers are run by other processes
+		broker.DeclareQueue(queue.JobPostFollow)
+		broker.DeclareQueue(queue.JobPostUnfollow)
+		broker.DeclareQueue(queue.JobAuthUpdateUserIDAuth)
+
+		workers := []worker.Worker{
+			worker.NewMemoryWorker(
+				"user/worker/create",
+				broker,
+				zapLogger,
+				apm.DefaultTracer,
+				user.NewCreateHandler(zapLogger, user.NewRepository(db), m, publisher.NewPublisher(m)),
+				queue.JobUserCreate,
+			),
+			worker.NewMemoryWorker(
+				"user/worker/update-email",
+				broker,
+				zapLogger,
+				apm.DefaultTracer,
+				user.NewUpdateEmailHandler(zapLogger, user.NewRepository(db)),
+				queue.JobUserUpdateEmail,
+			),
+		}
+
+		var wg sync.WaitGroup
+		// in-flight jobs are finished before database is closed
+		defer wg.Wait()
+
+		for _, w := range workers {
+			wg.Add(1)
+
+			go func(w worker.Worker) {
+				defer wg.Done()
+				w.Run(ctx)
+			}(w)
+		}
+	}
+
+	revocationStore := revocation.NewStore(redisClient, auth.AccessTokenTTL)
+	// api tokens are kept by auth service, they are loaded through internal API in case they aren't cached
+	internalAuthAPI, err := authapi.NewAPI(conf.InternalAPIEndpoints, &conf.InternalAPIConfig)
+	if err != nil {
+		zapLogger.Fatal(err)
+	}
+
+	apiTokenStore := apitoken.NewStore(redisClient, internalAuthAPI)
+
 	t := tracer.NewAPMTracer()
 	responseBuilder := api.NewResponseBuilder(t)
 
@@ -74,8 +157,16 @@ func main() {
 		zapLogger.Fatal(err)
 	}
 
-	service := user.NewService(user.NewRepository(db), t, m, internalMediaAPI)
-	handler := user.NewHandler(service, zapLogger, responseBuilder)
+	service := user.NewService(
+		user.NewRepository(db),
+		t,
+		m,
+		publisher.NewPublisher(m),
+		internalMediaAPI,
+		conf.UnsubscribeSecret,
+		zapLogger,
+	)
+	handler := user.NewHandler(service, zapLogger, responseBuilder, template.New())
 
 	apmTracer := apm.DefaultTracer
 
@@ -100,6 +191,7 @@ func main() {
 				responseBuilder,
 				zapLogger,
 				conf.Server.JWT.Secret,
+				jwtmiddleware.WithRevocation(revocationStore),
 			),
 			"/user",
 			apmmiddleware.WithTracer(apmTracer),
@@ -114,6 +206,9 @@ func main() {
 				responseBuilder,
 				zapLogger,
 				conf.Server.JWT.Secret,
+				jwtmiddleware.WithRevocation(revocationStore),
+				jwtmiddleware.WithAPITokens(apiTokenStore),
+				jwtmiddleware.WithScopes(jwtmiddleware.ScopeUserRead),
 			),
 			"/user/me",
 			apmmiddleware.WithTracer(apmTracer),
@@ -128,6 +223,7 @@ func main() {
 				responseBuilder,
 				zapLogger,
 				conf.Server.JWT.Secret,
+				jwtmiddleware.WithRevocation(revocationStore),
 			),
 			"/user/follow",
 			apmmiddleware.WithTracer(apmTracer),
@@ -142,6 +238,7 @@ func main() {
 				responseBuilder,
 				zapLogger,
 				conf.Server.JWT.Secret,
+				jwtmiddleware.WithRevocation(revocationStore),
 			),
 			"/user/unfollow",
 			apmmiddleware.WithTracer(apmTracer),
@@ -156,6 +253,9 @@ func main() {
 				responseBuilder,
 				zapLogger,
 				conf.Server.JWT.Secret,
+				jwtmiddleware.WithRevocation(revocationStore),
+				jwtmiddleware.WithAPITokens(apiTokenStore),
+				jwtmiddleware.WithScopes(jwtmiddleware.ScopeUserRead),
 			),
 			"/user/{login}",
 			apmmiddleware.WithTracer(apmTracer),
@@ -170,6 +270,7 @@ func main() {
 				responseBuilder,
 				zapLogger,
 				conf.Server.JWT.InternalSecrets,
+				jwtmiddleware.WithClientCertificates(),
 			),
 			"/internal/user/{login}",
 			apmmiddleware.WithTracer(apmTracer),
@@ -184,6 +285,7 @@ func main() {
 				responseBuilder,
 				zapLogger,
 				conf.Server.JWT.InternalSecrets,
+				jwtmiddleware.WithClientCertificates(),
 			),
 			"/internal/user/{uid}/followers",
 			apmmiddleware.WithTracer(apmTracer),
@@ -198,6 +300,9 @@ func main() {
 				responseBuilder,
 				zapLogger,
 				conf.Server.JWT.Secret,
+				jwtmiddleware.WithRevocation(revocationStore),
+				jwtmiddleware.WithAPITokens(apiTokenStore),
+				jwtmiddleware.WithScopes(jwtmiddleware.ScopeUserRead),
 			),
 			"/user/{login}/followers",
 			apmmiddleware.WithTracer(apmTracer),
@@ -212,6 +317,9 @@ func main() {
 				responseBuilder,
 				zapLogger,
 				conf.Server.JWT.Secret,
+				jwtmiddleware.WithRevocation(revocationStore),
+				jwtmiddleware.WithAPITokens(apiTokenStore),
+				jwtmiddleware.WithScopes(jwtmiddleware.ScopeUserRead),
 			),
 			"/user/{login}/following",
 			apmmiddleware.WithTracer(apmTracer),
@@ -226,6 +334,9 @@ func main() {
 				responseBuilder,
 				zapLogger,
 				conf.Server.JWT.Secret,
+				jwtmiddleware.WithRevocation(revocationStore),
+				jwtmiddleware.WithAPITokens(apiTokenStore),
+				jwtmiddleware.WithScopes(jwtmiddleware.ScopeUserRead),
 			),
 			"/user/{login}/following",
 			apmmiddleware.WithTracer(apmTracer),
@@ -240,19 +351,95 @@ func main() {
 				responseBuilder,
 				zapLogger,
 				conf.Server.JWT.InternalSecrets,
+				jwtmiddleware.WithClientCertificates(),
 			),
 			"/internal/user",
 			apmmiddleware.WithTracer(apmTracer),
 			apmmiddleware.WithRecovery(recoveryFunc),
 		),
 	)
+	router.Put(
+		"/user/email-preferences",
+		apmmiddleware.Wrap(
+			jwtmiddleware.Wrap(
+				handler.UpdateEmailPreferences,
+				responseBuilder,
+				zapLogger,
+				conf.Server.JWT.Secret,
+				jwtmiddleware.WithRevocation(revocationStore),
+			),
+			"/user/email-preferences",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+	router.Get(
+		"/user/unsubscribe",
+		apmmiddleware.Wrap(
+			handler.UnsubscribePage,
+			"/user/unsubscribe",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+	router.Post(
+		"/user/unsubscribe",
+		apmmiddleware.Wrap(
+			handler.Unsubscribe,
+			"/user/unsubscribe",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+	router.Get(
+		"/internal/user/digest",
+		apmmiddleware.Wrap(
+			jwtmiddleware.WrapInternal(
+				handler.InternalDigestRecipients,
+				responseBuilder,
+				zapLogger,
+				conf.Server.JWT.InternalSecrets,
+				jwtmiddleware.WithClientCertificates(),
+			),
+			"/internal/user/digest",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
+	router.Get(
+		"/internal/user/{uid}/followers/new",
+		apmmiddleware.Wrap(
+			jwtmiddleware.WrapInternal(
+				handler.InternalNewFollowers,
+				responseBuilder,
+				zapLogger,
+				conf.Server.JWT.InternalSecrets,
+				jwtmiddleware.WithClientCertificates(),
+			),
+			"/internal/user/{uid}/followers/new",
+			apmmiddleware.WithTracer(apmTracer),
+			apmmiddleware.WithRecovery(recoveryFunc),
+		),
+	)
 
 	server := http.Server{
 		Addr:    conf.Server.Addr,
 		Handler: router,
 	}
 
+	// internal endpoints authenticate services by client certificates in case mutual TLS is enabled
+	if conf.Server.TLS.Enabled() {
+		if server.TLSConfig, err = mtls.ServerConfig(conf.Server.TLS); err != nil {
+			zapLogger.Fatal(err)
+		}
+	}
+
 	go func() {
+		if conf.Server.TLS.Enabled() {
+			_ = server.ListenAndServeTLS("", "")
+			return
+		}
+
 		_ = server.ListenAndServe()
 	}()
 
@@ -6,12 +6,14 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"syscall"
 
 	"go.elastic.co/apm"
 	"go.elastic.co/apm/module/apmsql"
 	_ "go.elastic.co/apm/module/apmsql/pq"
 
 	"gitlab.com/slirx/newproj/internal/user"
+	"gitlab.com/slirx/newproj/pkg/event/publisher"
 	"gitlab.com/slirx/newproj/pkg/logger"
 	"gitlab.com/slirx/newproj/pkg/queue"
 	"gitlab.com/slirx/newproj/pkg/queue/manager"
@@ -22,7 +24,7 @@ import (
 func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
 		cancel()
@@ -38,6 +40,12 @@ func main() {
 		zapLogger.Fatal(err)
 	}
 
+	// workers are run by httpd of the service in case of in-memory queue, because memory isn't shared by processes
+	if conf.QueueBackend == queue.BackendMemory {
+		zapLogger.Info("in-memory queue is consumed by httpd of the service, worker is not started")
+		return
+	}
+
 	dbDSN := fmt.Sprintf(
 		"postgres://%s:%s@%s:%d/%s?sslmode=disable",
 		conf.Database.User, conf.Database.Password, conf.Database.Host, conf.Database.Port, conf.Database.Name,
@@ -62,8 +70,10 @@ func main() {
 		rabbitmq.NewClient(conf.RabbitMQ, zapLogger, queue.JobUserCreate),
 		zapLogger,
 		apmTracer,
-		user.NewCreateHandler(zapLogger, user.NewRepository(db), m),
+		user.NewCreateHandler(zapLogger, user.NewRepository(db), m, publisher.NewPublisher(m)),
 		queue.JobUserCreate,
+		worker.WithConcurrency(conf.Worker.Concurrency),
+		worker.WithShutdownTimeout(conf.Worker.ShutdownTimeout),
 	)
 	w.Run(ctx)
 }
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/module/apmsql"
+	_ "go.elastic.co/apm/module/apmsql/pq"
+
+	"gitlab.com/slirx/newproj/internal/user"
+	"gitlab.com/slirx/newproj/pkg/logger"
+	"gitlab.com/slirx/newproj/pkg/queue"
+	"gitlab.com/slirx/newproj/pkg/queue/worker"
+	"gitlab.com/slirx/newproj/pkg/rabbitmq"
+)
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		cancel()
+	}()
+
+	zapLogger, err := logger.NewZapLogger()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	conf, err := NewConfig("USER_WORKER_UPDATE_EMAIL_")
+	if err != nil {
+		zapLogger.Fatal(err)
+	}
+
+	// workers are run by httpd of the service in case of in-memory queue, because memory isn't shared by processes
+	if conf.QueueBackend == queue.BackendMemory {
+		zapLogger.Info("in-memory queue is consumed by httpd of the service, worker is not started")
+		return
+	}
+
+	dbDSN := fmt.Sprintf(
+		"postgres://%s:%s@%s:%d/%s?sslmode=disable",
+		conf.Database.User, conf.Database.Password, conf.Database.Host, conf.Database.Port, conf.Database.Name,
+	)
+	db, err := apmsql.Open("postgres", dbDSN)
+	if err != nil {
+		zapLogger.Fatal(err)
+	}
+
+	apmTracer := apm.DefaultTracer
+	apmTracer.Service.Name = "user-worker-update-email"
+
+	w := worker.NewWorker(
+		"user/worker/update-email",
+		rabbitmq.NewClient(conf.RabbitMQ, zapLogger, queue.JobUserUpdateEmail),
+		zapLogger,
+		apmTracer,
+		user.NewUpdateEmailHandler(zapLogger, user.NewRepository(db)),
+		queue.JobUserUpdateEmail,
+		worker.WithConcurrency(conf.Worker.Concurrency),
+		worker.WithShutdownTimeout(conf.Worker.ShutdownTimeout),
+	)
+	w.Run(ctx)
+}
@@ -6,16 +6,48 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
+	"go.elastic.co/apm/module/apmhttp"
+
+	pkgapi "gitlab.com/slirx/newproj/pkg/api"
+	"gitlab.com/slirx/newproj/pkg/mtls"
+)
+
+// tokenRefreshBefore is the time before expiration of internal JWT when the service logs in again.
+const tokenRefreshBefore = 5 * time.Minute
+
+const (
+	// retryAttempts is the maximum number of attempts of idempotent request.
+	retryAttempts = 3
+	// retryBackoff is the delay before the second attempt. It's doubled for every next attempt.
+	retryBackoff = 100 * time.Millisecond
 )
 
 type GeneralAPI struct {
 	ServiceConfig *ServiceConfig
 	Client        http.Client
 	Endpoints     map[string]string
-	Token         string
+	// session is created by Login. It's a pointer, so token refreshed by one copy of GeneralAPI is used by others.
+	session *session
+	// breakers are circuit breakers of routes of services from Endpoints. They are created by Login and shared
+	// by copies of GeneralAPI as well.
+	breakers *breakers
+}
+
+// session keeps internal JWT of the service.
+type session struct {
+	mu sync.Mutex
+	// serviceName is name of the service which issues tokens.
+	serviceName string
+	token       string
+	// expiresAt is zero in case expiration of the token is unknown.
+	expiresAt time.Time
 }
 
 type InternalJWT struct {
@@ -26,6 +58,21 @@ type InternalJWT struct {
 
 type ServiceConfig struct {
 	InternalJWT InternalJWT
+	// TLS is used instead of internal JWT in case it's enabled. Callee identifies the service by its certificate.
+	TLS mtls.Config
+}
+
+// unavailableError is returned in case service is unreachable or the connection is broken.
+type unavailableError struct {
+	err error
+}
+
+func (e unavailableError) Error() string {
+	return e.err.Error()
+}
+
+func (e unavailableError) Unwrap() error {
+	return e.err
 }
 
 type LoginRequest struct {
@@ -36,17 +83,68 @@ type LoginRequest struct {
 type LoginResponse struct {
 	Data struct {
 		AccessToken string `json:"access_token"`
+		ExpiresAt   int64  `json:"expires_at"`
 	} `json:"data"`
 }
 
+// NewClient returns HTTP client for internal API. It presents certificate of the service in case mutual TLS is
+// enabled. Requests are traced as spans of the current transaction, its trace context is sent in W3C Trace Context
+// headers, so the called service continues the same trace.
+func NewClient(config *ServiceConfig, timeout time.Duration) (http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if config.TLS.Enabled() {
+		tlsConfig, err := mtls.ClientConfig(config.TLS)
+		if err != nil {
+			return http.Client{}, err
+		}
+
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	client := http.Client{
+		Transport: apmhttp.WrapRoundTripper(transport),
+		Timeout:   timeout,
+	}
+
+	return client, nil
+}
+
+// Login obtains internal JWT from serviceName. The token is refreshed by SendRequest before it's expired and
+// in case it's rejected. Nothing is requested in case mutual TLS is enabled.
 func (a *GeneralAPI) Login(serviceName string) error {
+	a.session = &session{serviceName: serviceName}
+
+	a.breakers = newBreakers(a.Endpoints)
+
+	if a.ServiceConfig.TLS.Enabled() {
+		return nil
+	}
+
+	a.session.mu.Lock()
+	defer a.session.mu.Unlock()
+
+	return a.login(context.Background())
+}
+
+// login requests new token. a.session.mu should be locked by caller.
+func (a *GeneralAPI) login(ctx context.Context) error {
 	request := LoginRequest{
 		Login:    a.ServiceConfig.InternalJWT.Login,
 		Password: a.ServiceConfig.InternalJWT.Password,
 	}
 
-	body, err := a.SendRequest(context.Background(), serviceName, "POST", "internal/auth/login", request)
+	b, err := json.Marshal(request)
 	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	resp, body, err := a.do(ctx, a.session.serviceName, "POST", "internal/auth/login", b, "")
+	if err != nil {
+		return err
+	}
+
+	if body, err = decode(a.session.serviceName, resp, body); err != nil {
 		return err
 	}
 
@@ -59,11 +157,55 @@ func (a *GeneralAPI) Login(serviceName string) error {
 		return errors.WithStack(errors.New("empty access token"))
 	}
 
-	a.Token = response.Data.AccessToken
+	a.session.token = response.Data.AccessToken
+	a.session.expiresAt = time.Time{}
+
+	if response.Data.ExpiresAt > 0 {
+		a.session.expiresAt = time.Unix(response.Data.ExpiresAt, 0)
+	}
 
 	return nil
 }
 
+// token returns internal JWT. The token is refreshed in case it's expired soon. Failed refresh is reported only
+// in case the current token is expired already, otherwise it's retried by the next request.
+func (a *GeneralAPI) token(ctx context.Context) (string, error) {
+	if a.session == nil || a.ServiceConfig.TLS.Enabled() {
+		return "", nil
+	}
+
+	a.session.mu.Lock()
+	defer a.session.mu.Unlock()
+
+	if a.session.expiresAt.IsZero() || time.Until(a.session.expiresAt) > tokenRefreshBefore {
+		return a.session.token, nil
+	}
+
+	if err := a.login(ctx); err != nil && !time.Now().Before(a.session.expiresAt) {
+		return "", err
+	}
+
+	return a.session.token, nil
+}
+
+// refresh obtains new token in case rejected one is still the current token. Otherwise, it has been refreshed
+// by concurrent request already.
+func (a *GeneralAPI) refresh(ctx context.Context, rejected string) (string, error) {
+	a.session.mu.Lock()
+	defer a.session.mu.Unlock()
+
+	if a.session.token == rejected {
+		if err := a.login(ctx); err != nil {
+			return "", err
+		}
+	}
+
+	return a.session.token, nil
+}
+
+// SendRequest sends request to serviceName and returns body of successful response. Error responses are returned
+// as errors of pkg/api, so they are reported to client the same way as serviceName reported them. Idempotent
+// requests are retried with backoff in case serviceName is unavailable or responds with 5xx status.
 func (a *GeneralAPI) SendRequest(
 	ctx context.Context,
 	serviceName string,
@@ -71,15 +213,7 @@ func (a *GeneralAPI) SendRequest(
 	endpoint string,
 	request interface{},
 ) ([]byte, error) {
-	var err error
-	var r *http.Request
-
-	baseURL, ok := a.Endpoints[serviceName]
-	if !ok || baseURL == "" {
-		return nil, errors.WithStack(fmt.Errorf("base URL is undefined for service %s", serviceName))
-	}
-
-	// todo track as APM request (child transaction)
+	var body []byte
 
 	if request != nil {
 		b, err := json.Marshal(request)
@@ -87,24 +221,121 @@ func (a *GeneralAPI) SendRequest(
 			return nil, errors.WithStack(err)
 		}
 
-		body := bytes.NewReader(b)
-		r, err = http.NewRequestWithContext(ctx, method, baseURL+endpoint, body)
+		body = b
+	}
+
+	attempts := 1
+	if isIdempotent(method) {
+		attempts = retryAttempts
+	}
+
+	var err error
+	var resp *http.Response
+	var response []byte
+
+	for attempt := 1; ; attempt++ {
+		resp, response, err = a.attempt(ctx, serviceName, method, endpoint, body)
+		if !isRetryable(resp, err) || attempt >= attempts || !backoff(ctx, attempt) {
+			break
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return decode(serviceName, resp, response)
+}
+
+// attempt sends request with internal JWT through circuit breaker of the endpoint of serviceName. Failures to obtain
+// the token are returned as they are, they are not failures of serviceName.
+func (a *GeneralAPI) attempt(
+	ctx context.Context,
+	serviceName string,
+	method string,
+	endpoint string,
+	body []byte,
+) (*http.Response, []byte, error) {
+	token, err := a.token(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b := a.breakers.get(serviceName, endpoint)
+	if !b.allow(time.Now()) {
+		return nil, nil, errors.WithStack(
+			fmt.Errorf("%w: service %s, endpoint %s", ErrCircuitOpen, serviceName, route(endpoint)),
+		)
+	}
+
+	resp, response, err := a.do(ctx, serviceName, method, endpoint, body, token)
+
+	// token can be rejected before its expiration, for example after restart of auth service with a new secret,
+	// so the request is sent again with a new token
+	if err == nil && token != "" && isTokenRejected(resp.StatusCode, response) {
+		if token, err = a.refresh(ctx, token); err != nil {
+			// the request isn't completed, so it's neither failure nor success of serviceName
+			b.done(time.Now(), false, true)
+			return nil, nil, err
+		}
+
+		resp, response, err = a.do(ctx, serviceName, method, endpoint, body, token)
+	}
+
+	failed := isRetryable(resp, err)
+	b.done(time.Now(), failed, failed && errors.Is(ctx.Err(), context.Canceled))
+
+	return resp, response, err
+}
+
+// do sends request to serviceName and returns the response with its body, which is read and closed already.
+func (a *GeneralAPI) do(
+	ctx context.Context,
+	serviceName string,
+	method string,
+	endpoint string,
+	body []byte,
+	token string,
+) (*http.Response, []byte, error) {
+	var err error
+	var r *http.Request
+
+	baseURL, ok := a.Endpoints[serviceName]
+	if !ok || baseURL == "" {
+		return nil, nil, errors.WithStack(fmt.Errorf("base URL is undefined for service %s", serviceName))
+	}
+
+	if body != nil {
+		r, err = http.NewRequestWithContext(ctx, method, baseURL+endpoint, bytes.NewReader(body))
 		if err != nil {
-			return nil, errors.WithStack(err)
+			return nil, nil, errors.WithStack(err)
 		}
 	} else {
 		r, err = http.NewRequestWithContext(ctx, method, baseURL+endpoint, nil)
 		if err != nil {
-			return nil, errors.WithStack(err)
+			return nil, nil, errors.WithStack(err)
 		}
 	}
 
-	r.Header.Set("Authorization", a.Token)
+	if token != "" {
+		r.Header.Set("Authorization", token)
+	}
+
+	// callee stops handling of the request when the caller doesn't wait for response anymore
+	deadline, ok := ctx.Deadline()
+	if a.Client.Timeout > 0 && (!ok || time.Until(deadline) > a.Client.Timeout) {
+		deadline, ok = time.Now().Add(a.Client.Timeout), true
+	}
+
+	if timeout := time.Until(deadline).Milliseconds(); ok && timeout > 0 {
+		r.Header.Set(pkgapi.HeaderRequestTimeout, strconv.FormatInt(timeout, 10))
+	}
+
 	var resp *http.Response
 
 	resp, err = a.Client.Do(r)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, nil, errors.WithStack(unavailableError{err: err})
 	}
 
 	defer resp.Body.Close()
@@ -113,11 +344,85 @@ func (a *GeneralAPI) SendRequest(
 
 	response, err = io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, nil, errors.WithStack(unavailableError{err: err})
+	}
+
+	return resp, response, nil
+}
+
+// decode returns body of successful response or error of error response. Errors of pkg/api are returned without
+// stack, otherwise they are not recognized by api.NewErrorResponse.
+func decode(serviceName string, resp *http.Response, body []byte) ([]byte, error) {
+	if resp.StatusCode < http.StatusBadRequest {
+		return body, nil
+	}
+
+	response := pkgapi.ErrorResponse{}
+	if resp.StatusCode >= http.StatusInternalServerError || json.Unmarshal(body, &response) != nil || response.Code == "" {
+		return nil, errors.WithStack(fmt.Errorf("service %s responded with status %d", serviceName, resp.StatusCode))
+	}
+
+	retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+
+	return nil, pkgapi.NewErrorFromResponse(resp.StatusCode, response, time.Duration(retryAfter)*time.Second)
+}
+
+// isRetryable reports whether request failed because service is unavailable: it's unreachable or it responded
+// with 5xx status.
+func isRetryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return errors.As(err, &unavailableError{})
 	}
 
-	// todo re-login in case token is expired
+	return resp.StatusCode >= http.StatusInternalServerError
+}
+
+// isIdempotent reports whether request with method can be sent again without side effects.
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// backoff waits before the next attempt of request. It returns false in case ctx is done before.
+func backoff(ctx context.Context, attempt int) bool {
+	delay := retryBackoff << (attempt - 1)
+	// jitter spreads retries of concurrent requests, so they don't hit recovering service at once
+	delay += time.Duration(rand.Int63n(int64(delay / 2)))
+
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+		return false
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// isTokenRejected reports whether response means that internal JWT is expired or invalid. jwtmiddleware responds
+// with 403 and code of the reason, 401 is checked as well in case the request is rejected by proxy.
+func isTokenRejected(status int, body []byte) bool {
+	if status == http.StatusUnauthorized {
+		return true
+	}
+
+	if status != http.StatusForbidden {
+		return false
+	}
+
+	response := pkgapi.ErrorResponse{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return false
+	}
 
-	// todo maybe I have to return *http.Response here
-	return response, nil
+	return response.Code == pkgapi.CodeInvalidToken || response.Code == pkgapi.CodeTokenExpired
 }
@@ -0,0 +1,262 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/module/apmhttp"
+	"go.elastic.co/apm/transport"
+
+	pkgapi "gitlab.com/slirx/newproj/pkg/api"
+)
+
+// authServer imitates auth service which issues tokens "token1", "token2" and so on, and internal endpoint
+// of another service which accepts only tokens from valid. Issued tokens are not valid in case reject is true.
+type authServer struct {
+	mu        sync.Mutex
+	logins    int
+	ttl       time.Duration
+	reject    bool
+	valid     map[string]bool
+	responses map[string]int
+}
+
+func (s *authServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.URL.Path == "/internal/auth/login" {
+		request := LoginRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Password != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"code":"access_denied"}`))
+			return
+		}
+
+		s.logins++
+		token := fmt.Sprintf("token%d", s.logins)
+		s.valid[token] = !s.reject
+
+		response := LoginResponse{}
+		response.Data.AccessToken = token
+		response.Data.ExpiresAt = time.Now().Add(s.ttl).Unix()
+		_ = json.NewEncoder(w).Encode(response)
+
+		return
+	}
+
+	token := r.Header.Get("Authorization")
+	s.responses[token]++
+
+	if !s.valid[token] {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"code":"token_expired"}`))
+		return
+	}
+
+	_, _ = w.Write([]byte(`{"data":"ok"}`))
+}
+
+func newAuthServer(ttl time.Duration) (*authServer, *httptest.Server, GeneralAPI) {
+	s := &authServer{ttl: ttl, valid: make(map[string]bool), responses: make(map[string]int)}
+	server := httptest.NewServer(s)
+
+	a := GeneralAPI{
+		ServiceConfig: &ServiceConfig{InternalJWT: InternalJWT{Login: "email", Password: "secret"}},
+		Client:        http.Client{Timeout: time.Second},
+		Endpoints:     map[string]string{"auth": server.URL + "/", "user": server.URL + "/"},
+	}
+
+	return s, server, a
+}
+
+func TestSendRequestRefreshesToken(t *testing.T) {
+	s, server, a := newAuthServer(time.Minute)
+	defer server.Close()
+
+	if err := a.Login("auth"); err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	// copy of GeneralAPI uses the same token, like clients of internal API do
+	c := a
+
+	response, err := c.SendRequest(context.Background(), "user", "GET", "internal/user", nil)
+	if err != nil || string(response) != `{"data":"ok"}` {
+		t.Fatalf("got: %s/%v, want: successful response", response, err)
+	}
+
+	// the token expires in less than tokenRefreshBefore, so it's refreshed before the request
+	if s.logins != 2 || s.responses["token1"] != 0 || s.responses["token2"] != 1 {
+		t.Fatalf("got: %d logins, %v, want: request with the second token", s.logins, s.responses)
+	}
+
+	if _, err = a.SendRequest(context.Background(), "user", "GET", "internal/user", nil); err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	if s.logins != 3 {
+		t.Fatalf("got: %d, want: 3 because token is shared by copies", s.logins)
+	}
+}
+
+func TestSendRequestRetriesRejectedToken(t *testing.T) {
+	s, server, a := newAuthServer(time.Hour)
+	defer server.Close()
+
+	if err := a.Login("auth"); err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	// the token is rejected before its expiration
+	s.valid["token1"] = false
+
+	response, err := a.SendRequest(context.Background(), "user", "GET", "internal/user", nil)
+	if err != nil || string(response) != `{"data":"ok"}` {
+		t.Fatalf("got: %s/%v, want: successful response", response, err)
+	}
+
+	if s.logins != 2 || s.responses["token1"] != 1 || s.responses["token2"] != 1 {
+		t.Fatalf("got: %d logins, %v, want: request retried with the second token", s.logins, s.responses)
+	}
+
+	// the request is retried only once
+	s.valid["token2"] = false
+	s.reject = true
+	s.ttl = -time.Hour
+
+	_, err = a.SendRequest(context.Background(), "user", "GET", "internal/user", nil)
+	if status, response := pkgapi.NewErrorResponse("", err); response.Code != pkgapi.CodeTokenExpired {
+		t.Fatalf("got: %d/%+v, want: error of rejected request", status, response)
+	}
+
+	if s.logins != 3 || s.responses["token2"] != 2 || s.responses["token3"] != 1 {
+		t.Fatalf("got: %d logins, %v, want: request retried once with the third token", s.logins, s.responses)
+	}
+
+	// password is changed, so the service can't log in again
+	a.ServiceConfig.InternalJWT.Password = "changed"
+
+	if _, err = a.SendRequest(context.Background(), "user", "GET", "internal/user", nil); err == nil {
+		t.Fatalf("got: nil, want: error because the token is expired and it can't be refreshed")
+	}
+}
+
+func TestSendRequestErrors(t *testing.T) {
+	requests := 0
+	status := http.StatusNotFound
+	timeout := ""
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		timeout = r.Header.Get(pkgapi.HeaderRequestTimeout)
+
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"request_id":"req1","type":"error","message":"user not found","code":"not_found"}`))
+	}))
+	defer server.Close()
+
+	a := GeneralAPI{
+		Client:    http.Client{Timeout: time.Second},
+		Endpoints: map[string]string{"user": server.URL + "/"},
+		breakers:  newBreakers(map[string]string{"user": server.URL + "/"}),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	// error of the service is reported the same way, the request is not retried
+	_, err := a.SendRequest(ctx, "user", "GET", "internal/user/test", nil)
+	if code, response := pkgapi.NewErrorResponse("", err); code != http.StatusNotFound || requests != 1 {
+		t.Fatalf("got: %d/%+v after %d requests, want: %d after 1 request", code, response, requests, status)
+	}
+
+	// deadline of ctx is earlier than timeout of the client
+	if ms, err := strconv.Atoi(timeout); err != nil || ms <= 0 || ms > 500 {
+		t.Fatalf("got: %s, want: timeout of ctx in milliseconds", timeout)
+	}
+
+	// idempotent requests are retried in case of 5xx status
+	status = http.StatusServiceUnavailable
+	requests = 0
+
+	_, err = a.SendRequest(context.Background(), "user", "GET", "internal/user/test", nil)
+	if code, _ := pkgapi.NewErrorResponse("", err); code != http.StatusInternalServerError || requests != retryAttempts {
+		t.Fatalf("got: %d after %d requests, want: %d after %d requests", code, requests, code, retryAttempts)
+	}
+
+	if ms, err := strconv.Atoi(timeout); err != nil || ms <= 500 || ms > 1000 {
+		t.Fatalf("got: %s, want: timeout of the client in milliseconds", timeout)
+	}
+
+	requests = 0
+
+	if _, err = a.SendRequest(context.Background(), "user", "POST", "internal/user", nil); err == nil || requests != 1 {
+		t.Fatalf("got: %v after %d requests, want: error after 1 request", err, requests)
+	}
+
+	// breaker of the endpoint is open after breakerThreshold consecutive failures, failure of POST to another
+	// endpoint isn't counted
+	requests = 0
+
+	_, err = a.SendRequest(context.Background(), "user", "GET", "internal/user/test", nil)
+	if !errors.Is(err, ErrCircuitOpen) || requests != breakerThreshold-retryAttempts {
+		t.Fatalf("got: %v after %d requests, want: %s", err, requests, ErrCircuitOpen)
+	}
+
+	// breaker of another endpoint is closed
+	requests = 0
+
+	_, err = a.SendRequest(context.Background(), "user", "GET", "internal/user/digest", nil)
+	if errors.Is(err, ErrCircuitOpen) || requests != retryAttempts {
+		t.Fatalf("got: %v after %d requests, want: %d requests", err, requests, retryAttempts)
+	}
+}
+
+func TestSendRequestTraceparent(t *testing.T) {
+	traceparent := ""
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("Traceparent")
+		_, _ = w.Write([]byte(`{"data":"ok"}`))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(&ServiceConfig{}, time.Second)
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	a := GeneralAPI{
+		Client:    client,
+		Endpoints: map[string]string{"user": server.URL + "/"},
+	}
+
+	tracer, err := apm.NewTracerOptions(apm.TracerOptions{Transport: transport.Discard})
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	tx := tracer.StartTransaction("request", "request")
+	defer tx.End()
+
+	ctx := apm.ContextWithTransaction(context.Background(), tx)
+	if _, err = a.SendRequest(ctx, "user", "GET", "internal/user", nil); err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	// the called service continues the trace of the request
+	traceContext, err := apmhttp.ParseTraceparentHeader(traceparent)
+	if err != nil || traceContext.Trace != tx.TraceContext().Trace {
+		t.Fatalf("got: %s, want: traceparent with trace %s", traceparent, tx.TraceContext().Trace)
+	}
+}
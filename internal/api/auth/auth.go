@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gitlab.com/slirx/newproj/internal/api"
+	pkgapi "gitlab.com/slirx/newproj/pkg/api"
+	"gitlab.com/slirx/newproj/pkg/apitoken"
+)
+
+type API interface {
+	// Availability reports whether login and email are already used by registered users.
+	Availability(ctx context.Context, login string, email string) (*Availability, error)
+	// Token returns personal API token by its hash, so API implements apitoken.Source for services which cache
+	// tokens of auth service. apitoken.ErrNotFound is returned in case token is unknown, expired or revoked.
+	Token(ctx context.Context, hash string) (*apitoken.Token, error)
+}
+
+type Availability struct {
+	LoginTaken bool `json:"login_taken"`
+	EmailTaken bool `json:"email_taken"`
+}
+
+type authAPI struct {
+	GeneralAPI api.GeneralAPI
+}
+
+type availabilityResponse struct {
+	Data Availability `json:"data"`
+}
+
+type tokenResponse struct {
+	Data apitoken.Token `json:"data"`
+}
+
+func (a authAPI) Availability(ctx context.Context, login string, email string) (*Availability, error) {
+	query := url.Values{}
+	query.Set("login", login)
+	query.Set("email", email)
+
+	body, err := a.GeneralAPI.SendRequest(ctx, "auth", "GET", "internal/auth/availability?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response := availabilityResponse{}
+	if err = json.Unmarshal(body, &response); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &response.Data, nil
+}
+
+func (a authAPI) Token(ctx context.Context, hash string) (*apitoken.Token, error) {
+	body, err := a.GeneralAPI.SendRequest(ctx, "auth", "GET", "internal/auth/api-tokens/"+url.PathEscape(hash), nil)
+	if err != nil {
+		if status, _ := pkgapi.GetErrorResponseFields(err); status == http.StatusNotFound {
+			return nil, errors.WithStack(apitoken.ErrNotFound)
+		}
+
+		return nil, err
+	}
+
+	response := tokenResponse{}
+	if err = json.Unmarshal(body, &response); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &response.Data, nil
+}
+
+func NewAPI(endpoints map[string]string, config *api.ServiceConfig) (API, error) {
+	client, err := api.NewClient(config, 3*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	a := api.GeneralAPI{
+		ServiceConfig: config,
+		Client:        client,
+		Endpoints:     endpoints,
+	}
+	if err = a.Login("auth"); err != nil {
+		return nil, err
+	}
+
+	s := authAPI{
+		GeneralAPI: a,
+	}
+
+	return s, nil
+}
@@ -0,0 +1,20 @@
+package auth
+
+import (
+	"context"
+
+	"gitlab.com/slirx/newproj/pkg/apitoken"
+)
+
+type Mock struct {
+	AvailabilityFn func(ctx context.Context, login string, email string) (*Availability, error)
+	TokenFn        func(ctx context.Context, hash string) (*apitoken.Token, error)
+}
+
+func (m Mock) Availability(ctx context.Context, login string, email string) (*Availability, error) {
+	return m.AvailabilityFn(ctx, login, email)
+}
+
+func (m Mock) Token(ctx context.Context, hash string) (*apitoken.Token, error) {
+	return m.TokenFn(ctx, hash)
+}
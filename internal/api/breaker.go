@@ -0,0 +1,150 @@
+package api
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// breakerThreshold is the number of consecutive failed requests to service after which circuit breaker is open.
+	breakerThreshold = 5
+	// breakerCooldown is the time circuit breaker stays open. After it one trial request is allowed, the breaker
+	// is closed in case it succeeds.
+	breakerCooldown = 10 * time.Second
+)
+
+// ErrCircuitOpen is returned in case requests to service are not sent, because the previous ones failed.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// breaker is circuit breaker of service. Nil breaker allows all requests.
+type breaker struct {
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	// trial is true while trial request of half-open breaker is being sent.
+	trial bool
+}
+
+// allow reports whether request can be sent at now.
+func (b *breaker) allow(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < breakerThreshold {
+		return true
+	}
+
+	if b.trial || now.Sub(b.openedAt) < breakerCooldown {
+		return false
+	}
+
+	b.trial = true
+
+	return true
+}
+
+// done records result of allowed request. Requests canceled by caller are neither failures nor successes.
+func (b *breaker) done(now time.Time, failed bool, canceled bool) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+
+	if canceled {
+		return
+	}
+
+	if !failed {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures >= breakerThreshold {
+		b.openedAt = now
+	}
+}
+
+// maxRoutes is the maximum number of routes of service which have own circuit breakers. Requests to the other routes
+// share one breaker, so paths with parameters which don't look like ones don't create breakers without limit.
+const maxRoutes = 64
+
+// otherRoutes is route of breaker shared by routes of service above maxRoutes.
+const otherRoutes = "*"
+
+// breakers keeps circuit breakers per route of services, so failures of one endpoint don't block the others.
+// Nil breakers allow all requests.
+type breakers struct {
+	mu sync.Mutex
+	// routes are breakers of routes by service name. They are created by the first request to the route.
+	routes map[string]map[string]*breaker
+}
+
+// get returns breaker of endpoint of serviceName. Nil is returned in case serviceName is unknown.
+func (bs *breakers) get(serviceName string, endpoint string) *breaker {
+	if bs == nil {
+		return nil
+	}
+
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	routes, ok := bs.routes[serviceName]
+	if !ok {
+		return nil
+	}
+
+	r := route(endpoint)
+	if b, ok := routes[r]; ok {
+		return b
+	}
+
+	if len(routes) >= maxRoutes {
+		r = otherRoutes
+		if b, ok := routes[r]; ok {
+			return b
+		}
+	}
+
+	b := &breaker{}
+	routes[r] = b
+
+	return b
+}
+
+func newBreakers(services map[string]string) *breakers {
+	bs := &breakers{routes: make(map[string]map[string]*breaker, len(services))}
+	for name := range services {
+		bs.routes[name] = make(map[string]*breaker)
+	}
+
+	return bs
+}
+
+// route returns path of endpoint without query, its segments which look like parameters are replaced by "*".
+func route(endpoint string) string {
+	if i := strings.IndexAny(endpoint, "?#"); i >= 0 {
+		endpoint = endpoint[:i]
+	}
+
+	segments := strings.Split(strings.Trim(endpoint, "/"), "/")
+	for i, segment := range segments {
+		// ids, hashes and escaped values contain digits or "%"
+		if strings.ContainsAny(segment, "0123456789%") {
+			segments[i] = "*"
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
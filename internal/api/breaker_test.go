@@ -0,0 +1,98 @@
+package api
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	b := &breaker{}
+	now := time.Date(2021, 5, 10, 8, 0, 0, 0, time.UTC)
+
+	for i := 0; i < breakerThreshold-1; i++ {
+		b.allow(now)
+		b.done(now, true, false)
+	}
+
+	// success resets number of consecutive failures
+	b.allow(now)
+	b.done(now, false, false)
+
+	for i := 0; i < breakerThreshold; i++ {
+		if !b.allow(now) {
+			t.Fatalf("got: false, want: true because breaker is closed after %d failures", i)
+		}
+
+		b.done(now, true, false)
+	}
+
+	if b.allow(now.Add(breakerCooldown - time.Second)) {
+		t.Fatalf("got: true, want: false because breaker is open")
+	}
+
+	// only one trial request is allowed after cooldown
+	now = now.Add(breakerCooldown)
+	if !b.allow(now) || b.allow(now) {
+		t.Fatalf("got: false, want: true for the first request only")
+	}
+
+	b.done(now, true, false)
+
+	if b.allow(now.Add(time.Second)) {
+		t.Fatalf("got: true, want: false because trial request failed")
+	}
+
+	now = now.Add(breakerCooldown)
+	if !b.allow(now) {
+		t.Fatalf("got: false, want: true")
+	}
+
+	// request canceled by caller doesn't close breaker
+	b.done(now, true, true)
+
+	if !b.allow(now) {
+		t.Fatalf("got: false, want: true because result of trial request is unknown")
+	}
+
+	b.done(now, false, false)
+
+	if !b.allow(now) || !b.allow(now) {
+		t.Fatalf("got: false, want: true because trial request succeeded")
+	}
+
+	var nilBreaker *breaker
+	if !nilBreaker.allow(now) {
+		t.Fatalf("got: false, want: true")
+	}
+}
+
+func TestBreakersGet(t *testing.T) {
+	bs := newBreakers(map[string]string{"user": "http://user/"})
+
+	b := bs.get("user", "internal/user/1/followers?since=10")
+	if b == nil || bs.get("user", "internal/user/2/followers") != b {
+		t.Fatalf("got: different breakers, want: the same breaker of the route")
+	}
+
+	if bs.get("user", "internal/user/digest") == b {
+		t.Fatalf("got: the same breaker, want: breaker of another route")
+	}
+
+	if bs.get("post", "internal/post/feed/1") != nil {
+		t.Fatalf("got: breaker, want: nil for unknown service")
+	}
+
+	for i := 0; i < maxRoutes; i++ {
+		bs.get("user", "internal/user/login"+strings.Repeat("a", i))
+	}
+
+	other := bs.get("user", "internal/user/jack")
+	if other == nil || bs.get("user", "internal/user/jill") != other || len(bs.routes["user"]) != maxRoutes+1 {
+		t.Fatalf("got: %d routes, want: %d routes sharing breaker above the limit", len(bs.routes["user"]), maxRoutes+1)
+	}
+
+	if route("/internal/auth/api-tokens/ab12%2F?x=1") != "internal/auth/api-tokens/*" {
+		t.Fatalf("got: %s, want: internal/auth/api-tokens/*", route("/internal/auth/api-tokens/ab12%2F?x=1"))
+	}
+}
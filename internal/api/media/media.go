@@ -3,7 +3,6 @@ package media
 import (
 	"context"
 	"encoding/json"
-	"net/http"
 	"strconv"
 	"time"
 
@@ -72,14 +71,15 @@ func (u mediaAPI) Images(ctx context.Context, serviceName string, ids []int) (ma
 }
 
 func NewAPI(endpoints map[string]string, config *api.ServiceConfig) (API, error) {
-	var err error
+	client, err := api.NewClient(config, 3*time.Second)
+	if err != nil {
+		return nil, err
+	}
 
 	a := api.GeneralAPI{
 		ServiceConfig: config,
-		Client: http.Client{
-			Timeout: 3 * time.Second,
-		},
-		Endpoints: endpoints,
+		Client:        client,
+		Endpoints:     endpoints,
 	}
 	if err = a.Login("auth"); err != nil {
 		return nil, err
@@ -0,0 +1,14 @@
+package post
+
+import (
+	"context"
+	"time"
+)
+
+type Mock struct {
+	FeedSinceFn func(ctx context.Context, uid int, since time.Time) (*Feed, error)
+}
+
+func (m Mock) FeedSince(ctx context.Context, uid int, since time.Time) (*Feed, error) {
+	return m.FeedSinceFn(ctx, uid, since)
+}
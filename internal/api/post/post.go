@@ -0,0 +1,87 @@
+package post
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gitlab.com/slirx/newproj/internal/api"
+)
+
+type API interface {
+	// FeedSince returns the latest posts which appeared in user's feed since the given time.
+	FeedSince(ctx context.Context, uid int, since time.Time) (*Feed, error)
+}
+
+// User is the author of the post.
+type User struct {
+	ID    int    `json:"id"`
+	Login string `json:"login"`
+	Name  string `json:"name"`
+}
+
+type Post struct {
+	ID        int    `json:"id"`
+	Text      string `json:"text"`
+	CreatedAt int64  `json:"created_at"`
+	User      User   `json:"user"`
+}
+
+// Feed contains total number of posts and the latest of them.
+type Feed struct {
+	Total int    `json:"total"`
+	Posts []Post `json:"posts"`
+}
+
+type postAPI struct {
+	GeneralAPI api.GeneralAPI
+}
+
+type feedResponse struct {
+	Data Feed `json:"data"`
+}
+
+func (p postAPI) FeedSince(ctx context.Context, uid int, since time.Time) (*Feed, error) {
+	body, err := p.GeneralAPI.SendRequest(
+		ctx,
+		"post",
+		"GET",
+		"internal/post/feed/"+strconv.Itoa(uid)+"?since="+strconv.FormatInt(since.Unix(), 10),
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	response := feedResponse{}
+	if err = json.Unmarshal(body, &response); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &response.Data, nil
+}
+
+func NewAPI(endpoints map[string]string, config *api.ServiceConfig) (API, error) {
+	client, err := api.NewClient(config, 3*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	a := api.GeneralAPI{
+		ServiceConfig: config,
+		Client:        client,
+		Endpoints:     endpoints,
+	}
+	if err = a.Login("auth"); err != nil {
+		return nil, err
+	}
+
+	s := postAPI{
+		GeneralAPI: a,
+	}
+
+	return s, nil
+}
@@ -2,6 +2,7 @@ package user
 
 import (
 	"context"
+	"time"
 
 	"gitlab.com/slirx/newproj/internal/graphql/graph/model"
 )
@@ -9,6 +10,9 @@ import (
 type Mock struct {
 	FollowersFn func(ctx context.Context, uid int) ([]int, error)
 	UsersFn     func(ctx context.Context, userIDs []int) ([]model.User, error)
+
+	DigestRecipientsFn func(ctx context.Context, frequency string, latestUserID int) ([]DigestRecipient, error)
+	NewFollowersFn     func(ctx context.Context, uid int, since time.Time) (*NewFollowers, error)
 }
 
 func (m Mock) Followers(ctx context.Context, uid int) ([]int, error) {
@@ -18,3 +22,11 @@ func (m Mock) Followers(ctx context.Context, uid int) ([]int, error) {
 func (m Mock) Users(ctx context.Context, userIDs []int) ([]model.User, error) {
 	return m.UsersFn(ctx, userIDs)
 }
+
+func (m Mock) DigestRecipients(ctx context.Context, frequency string, latestUserID int) ([]DigestRecipient, error) {
+	return m.DigestRecipientsFn(ctx, frequency, latestUserID)
+}
+
+func (m Mock) NewFollowers(ctx context.Context, uid int, since time.Time) (*NewFollowers, error) {
+	return m.NewFollowersFn(ctx, uid, since)
+}
@@ -3,7 +3,7 @@ package user
 import (
 	"context"
 	"encoding/json"
-	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 
@@ -16,6 +16,33 @@ import (
 type API interface {
 	Followers(ctx context.Context, uid int) ([]int, error)
 	Users(ctx context.Context, userIDs []int) ([]model.User, error)
+	// DigestRecipients returns the next page of users who receive email digest with frequency. Users are ordered
+	// by id, latestUserID is id of the last user of the previous page.
+	DigestRecipients(ctx context.Context, frequency string, latestUserID int) ([]DigestRecipient, error)
+	// NewFollowers returns users who started following the user since the given time.
+	NewFollowers(ctx context.Context, uid int, since time.Time) (*NewFollowers, error)
+}
+
+// DigestRecipient is the user who receives email digest.
+type DigestRecipient struct {
+	ID     int    `json:"id"`
+	Login  string `json:"login"`
+	Name   string `json:"name"`
+	Email  string `json:"email"`
+	Locale string `json:"locale"`
+}
+
+// Follower represents new follower of the user.
+type Follower struct {
+	UserID int    `json:"user_id"`
+	Login  string `json:"login"`
+	Name   string `json:"name"`
+}
+
+// NewFollowers contains total number of new followers and the latest of them.
+type NewFollowers struct {
+	Total     int        `json:"total"`
+	Followers []Follower `json:"followers"`
 }
 
 type userAPI struct {
@@ -84,15 +111,70 @@ func (u userAPI) Users(ctx context.Context, userIDs []int) ([]model.User, error)
 	return response.Data.Users, nil
 }
 
+type digestRecipientsResponse struct {
+	Data struct {
+		Users []DigestRecipient `json:"users"`
+	} `json:"data"`
+}
+
+type newFollowersResponse struct {
+	Data NewFollowers `json:"data"`
+}
+
+func (u userAPI) DigestRecipients(ctx context.Context, frequency string, latestUserID int) ([]DigestRecipient, error) {
+	query := url.Values{}
+	query.Set("frequency", frequency)
+	query.Set("latest_user_id", strconv.Itoa(latestUserID))
+
+	body, err := u.GeneralAPI.SendRequest(
+		ctx,
+		"user",
+		"GET",
+		"internal/user/digest?"+query.Encode(),
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	response := digestRecipientsResponse{}
+	if err = json.Unmarshal(body, &response); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return response.Data.Users, nil
+}
+
+func (u userAPI) NewFollowers(ctx context.Context, uid int, since time.Time) (*NewFollowers, error) {
+	body, err := u.GeneralAPI.SendRequest(
+		ctx,
+		"user",
+		"GET",
+		"internal/user/"+strconv.Itoa(uid)+"/followers/new?since="+strconv.FormatInt(since.Unix(), 10),
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	response := newFollowersResponse{}
+	if err = json.Unmarshal(body, &response); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &response.Data, nil
+}
+
 func NewAPI(endpoints map[string]string, config *api.ServiceConfig) (API, error) {
-	var err error
+	client, err := api.NewClient(config, 3*time.Second)
+	if err != nil {
+		return nil, err
+	}
 
 	a := api.GeneralAPI{
 		ServiceConfig: config,
-		Client: http.Client{
-			Timeout: 3 * time.Second,
-		},
-		Endpoints: endpoints,
+		Client:        client,
+		Endpoints:     endpoints,
 	}
 	if err = a.Login("auth"); err != nil {
 		return nil, err
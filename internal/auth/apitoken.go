@@ -0,0 +1,266 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+
+	"gitlab.com/slirx/newproj/pkg/api"
+	"gitlab.com/slirx/newproj/pkg/apitoken"
+	"gitlab.com/slirx/newproj/pkg/http/jwtmiddleware"
+	"gitlab.com/slirx/newproj/pkg/validation"
+)
+
+const (
+	// apiTokenNameMaxLen is the maximum length of name of API token.
+	apiTokenNameMaxLen = 100
+	// apiTokenMaxDays is the maximum lifetime of API token in days.
+	apiTokenMaxDays = 365
+	// apiTokensMaxCount is the maximum number of active API tokens of the user.
+	apiTokensMaxCount = 20
+)
+
+var errAPITokenNotFound = api.NewNotFoundError(errors.New("api token is not found"))
+
+// apiTokenSource loads API tokens from database, apitoken.Store of auth service caches them.
+type apiTokenSource struct {
+	Repository Repository
+}
+
+func (s apiTokenSource) Token(ctx context.Context, hash string) (*apitoken.Token, error) {
+	token, err := s.Repository.APITokenByHash(ctx, hash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.WithStack(apitoken.ErrNotFound)
+		}
+
+		return nil, err
+	}
+
+	return &apitoken.Token{
+		ID:        token.ID,
+		UserID:    token.UserID,
+		Scopes:    token.Scopes,
+		ExpiresAt: token.ExpiresAt,
+	}, nil
+}
+
+// Validate validates create API token request. Scopes are the same which can be granted to OAuth clients.
+func (r CreateAPITokenRequest) Validate() error {
+	fieldErrors := make([]api.FieldError, 0)
+
+	name := strings.TrimSpace(r.Name)
+	if name == "" {
+		fieldErrors = append(fieldErrors, api.NewFieldError("name", validation.Error{
+			Code:    validation.CodeRequired,
+			Message: "name should not be empty",
+		}))
+	} else if utf8.RuneCountInString(name) > apiTokenNameMaxLen {
+		fieldErrors = append(fieldErrors, api.NewFieldError("name", validation.Error{
+			Code:    validation.CodeLength,
+			Message: fmt.Sprintf("name should not be longer than %d symbols", apiTokenNameMaxLen),
+		}))
+	}
+
+	if _, err := parseScopes(strings.Join(r.Scopes, " ")); err != nil {
+		fieldErrors = append(fieldErrors, api.NewFieldError("scopes", validation.Error{
+			Code:    validation.CodeInvalid,
+			Message: err.Error(),
+		}))
+	}
+
+	if r.ExpiresInDays < 1 || r.ExpiresInDays > apiTokenMaxDays {
+		fieldErrors = append(fieldErrors, api.NewFieldError("expires_in_days", validation.Error{
+			Code:    validation.CodeInvalid,
+			Message: fmt.Sprintf("expiration should be from 1 to %d days", apiTokenMaxDays),
+		}))
+	}
+
+	if len(fieldErrors) > 0 {
+		return api.NewValidationError(fieldErrors...)
+	}
+
+	return nil
+}
+
+// CreateAPIToken creates personal API token of the current user. The token is returned only once, only its hash
+// is stored.
+func (s service) CreateAPIToken(ctx context.Context, request CreateAPITokenRequest) (*CreateAPITokenResponse, error) {
+	uid, err := jwtmiddleware.UID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = request.Validate(); err != nil {
+		return nil, err
+	}
+
+	tokens, err := s.Repository.APITokens(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) >= apiTokensMaxCount {
+		return nil, api.NewRequestError(
+			fmt.Errorf("you can't have more than %d api tokens. please, revoke unused ones", apiTokensMaxCount),
+		)
+	}
+
+	value, hash, err := apitoken.New()
+	if err != nil {
+		return nil, err
+	}
+
+	scopes, _ := parseScopes(strings.Join(request.Scopes, " "))
+
+	token := APIToken{
+		UserID:    uid,
+		Name:      strings.TrimSpace(request.Name),
+		TokenHash: hash,
+		Scopes:    scopes,
+		ExpiresAt: time.Now().AddDate(0, 0, request.ExpiresInDays).UTC(),
+	}
+
+	if token.ID, err = s.Repository.CreateAPIToken(ctx, token); err != nil {
+		return nil, err
+	}
+
+	err = s.TokenStore.Save(ctx, hash, apitoken.Token{
+		ID:        token.ID,
+		UserID:    uid,
+		Scopes:    scopes,
+		ExpiresAt: token.ExpiresAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &CreateAPITokenResponse{ID: token.ID, Token: value, ExpiresAt: token.ExpiresAt.Unix()}, nil
+}
+
+// APITokens returns active API tokens of the current user with time of their last use.
+func (s service) APITokens(ctx context.Context) (*APITokensResponse, error) {
+	uid, err := jwtmiddleware.UID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := s.Repository.APITokens(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &APITokensResponse{Tokens: make([]APITokenItem, 0, len(tokens))}
+
+	for _, token := range tokens {
+		lastUsedAt, err := s.TokenStore.LastUsed(ctx, token.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		item := APITokenItem{
+			ID:        token.ID,
+			Name:      token.Name,
+			Scopes:    token.Scopes,
+			CreatedAt: token.CreatedAt.Unix(),
+			ExpiresAt: token.ExpiresAt.Unix(),
+		}
+
+		if !lastUsedAt.IsZero() {
+			item.LastUsedAt = lastUsedAt.Unix()
+		}
+
+		response.Tokens = append(response.Tokens, item)
+	}
+
+	return response, nil
+}
+
+// RevokeAPIToken revokes API token of the current user. The token is rejected by all services right after that.
+func (s service) RevokeAPIToken(ctx context.Context, request RevokeAPITokenRequest) (string, error) {
+	uid, err := jwtmiddleware.UID(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	tokens, err := s.Repository.APITokens(ctx, uid)
+	if err != nil {
+		return "", err
+	}
+
+	var token *APIToken
+	for i := range tokens {
+		if tokens[i].ID == request.ID {
+			token = &tokens[i]
+			break
+		}
+	}
+
+	// token of another user is reported the same way as unknown one
+	if token == nil {
+		return "", errAPITokenNotFound
+	}
+
+	// token is deleted from database before the cache, otherwise it could be cached again right after deletion.
+	// sql.ErrNoRows means that the token was revoked by concurrent request
+	err = s.Repository.DeleteAPIToken(ctx, uid, token.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	if err = s.TokenStore.Delete(ctx, token.TokenHash); err != nil {
+		return "", err
+	}
+
+	return "api token has been revoked", nil
+}
+
+// InternalAPIToken returns API token by its hash. It's used by other services to load tokens which aren't cached.
+func (s service) InternalAPIToken(ctx context.Context, request InternalAPITokenRequest) (*apitoken.Token, error) {
+	if request.Hash == "" {
+		return nil, api.NewRequestError(errors.New("hash should not be empty"))
+	}
+
+	token, err := apiTokenSource{Repository: s.Repository}.Token(ctx, request.Hash)
+	if err != nil {
+		if errors.Is(err, apitoken.ErrNotFound) {
+			return nil, errAPITokenNotFound
+		}
+
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// revokeAPITokens revokes all API tokens of the user. It's used when password is changed or reset and when staff
+// revoke sessions of the user, so tokens created by somebody who took over the account stop working.
+func (s service) revokeAPITokens(ctx context.Context, uid int) error {
+	tokens, err := s.Repository.APITokens(ctx, uid)
+	if err != nil {
+		return err
+	}
+
+	if err = s.Repository.DeleteAPITokens(ctx, uid); err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		if err = s.TokenStore.Delete(ctx, token.TokenHash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// NewAPITokenSource returns source of API tokens which are cached by apitoken.Store of auth service. Other services
+// load them through internal API.
+func NewAPITokenSource(r Repository) apitoken.Source {
+	return apiTokenSource{Repository: r}
+}
@@ -0,0 +1,245 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gitlab.com/slirx/newproj/pkg/api"
+	"gitlab.com/slirx/newproj/pkg/apitoken"
+	"gitlab.com/slirx/newproj/pkg/http/jwtmiddleware"
+	"gitlab.com/slirx/newproj/pkg/logger"
+	"gitlab.com/slirx/newproj/pkg/queue/manager"
+	"gitlab.com/slirx/newproj/pkg/revocation"
+)
+
+// newAPITokenStore returns store which keeps tokens in memory.
+func newAPITokenStore() apitoken.Mock {
+	tokens := make(map[string]apitoken.Token)
+	lastUsed := make(map[int]time.Time)
+
+	return apitoken.Mock{
+		SaveFn: func(ctx context.Context, hash string, token apitoken.Token) error {
+			tokens[hash] = token
+			return nil
+		},
+		DeleteFn: func(ctx context.Context, hash string) error {
+			delete(tokens, hash)
+			return nil
+		},
+		CheckFn: func(ctx context.Context, hash string, at time.Time) (*apitoken.Token, error) {
+			token, ok := tokens[hash]
+			if !ok {
+				return nil, errors.WithStack(apitoken.ErrNotFound)
+			}
+
+			lastUsed[token.ID] = at
+
+			return &token, nil
+		},
+		LastUsedFn: func(ctx context.Context, id int) (time.Time, error) {
+			return lastUsed[id], nil
+		},
+	}
+}
+
+// authorizeAPIToken passes token through jwtmiddleware which accepts API tokens and returns status of the response
+// and user id from context of the request.
+func authorizeAPIToken(store apitoken.Store, token string, o ...jwtmiddleware.Option) (int, int) {
+	uid := 0
+
+	rr := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", token)
+
+	jwtmiddleware.Wrap(
+		func(w http.ResponseWriter, r *http.Request) {
+			uid, _ = jwtmiddleware.UID(r.Context())
+		},
+		api.NewResponseBuilder(tracerMock),
+		logger.NewNoop(),
+		[]byte(testConfig.Secret),
+		append([]jwtmiddleware.Option{jwtmiddleware.WithAPITokens(store)}, o...)...,
+	)(rr, r)
+
+	return rr.Code, uid
+}
+
+func TestServiceAPITokens(t *testing.T) {
+	tokens := make([]APIToken, 0)
+
+	rMock := repositoryMock{
+		CreateAPITokenFn: func(ctx context.Context, token APIToken) (int, error) {
+			token.ID = len(tokens) + 1
+			token.CreatedAt = time.Now()
+			tokens = append(tokens, token)
+
+			return token.ID, nil
+		},
+		APITokensFn: func(ctx context.Context, uid int) ([]APIToken, error) {
+			result := make([]APIToken, 0)
+
+			for _, token := range tokens {
+				if token.UserID == uid {
+					result = append(result, token)
+				}
+			}
+
+			return result, nil
+		},
+		DeleteAPITokenFn: func(ctx context.Context, uid int, id int) error {
+			for i, token := range tokens {
+				if token.ID == id && token.UserID == uid {
+					tokens = append(tokens[:i], tokens[i+1:]...)
+					return nil
+				}
+			}
+
+			return errors.New("token is not found")
+		},
+	}
+
+	store := newAPITokenStore()
+	s := NewService(
+		tracerMock,
+		rMock,
+		manager.Mock{},
+		generatorMock,
+		catalogMock,
+		revocation.Mock{},
+		store,
+		throttleNoop,
+		nil,
+		testConfig,
+	)
+	ctx := context.WithValue(context.Background(), jwtmiddleware.ContextKeyUserID, 3)
+
+	_, err := s.CreateAPIToken(ctx, CreateAPITokenRequest{Name: " ", Scopes: []string{"admin"}, ExpiresInDays: 400})
+
+	status, response := api.NewErrorResponse("", err)
+	if status != http.StatusBadRequest || len(response.Errors) != 3 {
+		t.Fatalf("got: %d/%+v, want: %d with errors of name, scopes and expiration", status, response, http.StatusBadRequest)
+	}
+
+	created, err := s.CreateAPIToken(ctx, CreateAPITokenRequest{
+		Name:          "poster",
+		Scopes:        []string{jwtmiddleware.ScopePostWrite},
+		ExpiresInDays: 30,
+	})
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	if !apitoken.IsToken(created.Token) || tokens[0].TokenHash != apitoken.Hash(created.Token) {
+		t.Fatalf("got: %+v, want: token which is stored hashed", created)
+	}
+
+	list, err := s.APITokens(ctx)
+	if err != nil || len(list.Tokens) != 1 || list.Tokens[0].LastUsedAt != 0 {
+		t.Fatalf("got: %+v/%v, want: unused token", list, err)
+	}
+
+	cases := []struct {
+		name    string
+		options []jwtmiddleware.Option
+		status  int
+		uid     int
+	}{
+		{
+			name:    "route with scope of the token",
+			options: []jwtmiddleware.Option{jwtmiddleware.WithScopes(jwtmiddleware.ScopePostWrite)},
+			status:  http.StatusOK,
+			uid:     3,
+		},
+		{
+			name:    "route with another scope",
+			options: []jwtmiddleware.Option{jwtmiddleware.WithScopes(jwtmiddleware.ScopeUserRead)},
+			status:  http.StatusForbidden,
+		},
+		{
+			name:   "route without scopes",
+			status: http.StatusForbidden,
+		},
+	}
+
+	for _, c := range cases {
+		if status, uid := authorizeAPIToken(store, created.Token, c.options...); status != c.status || uid != c.uid {
+			t.Fatalf("%s: got: %d/%d, want: %d/%d", c.name, status, uid, c.status, c.uid)
+		}
+	}
+
+	list, err = s.APITokens(ctx)
+	if err != nil || len(list.Tokens) != 1 || list.Tokens[0].LastUsedAt == 0 {
+		t.Fatalf("got: %+v/%v, want: token with time of the last use", list, err)
+	}
+
+	// token of another user can't be revoked
+	otherCtx := context.WithValue(context.Background(), jwtmiddleware.ContextKeyUserID, 4)
+
+	_, err = s.RevokeAPIToken(otherCtx, RevokeAPITokenRequest{ID: created.ID})
+	if status, _ := api.NewErrorResponse("", err); status != http.StatusNotFound {
+		t.Fatalf("got: %d, want: %d", status, http.StatusNotFound)
+	}
+
+	if _, err = s.RevokeAPIToken(ctx, RevokeAPITokenRequest{ID: created.ID}); err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	option := jwtmiddleware.WithScopes(jwtmiddleware.ScopePostWrite)
+	if status, _ := authorizeAPIToken(store, created.Token, option); status != http.StatusForbidden {
+		t.Fatalf("got: %d, want: %d because token is revoked", status, http.StatusForbidden)
+	}
+}
+
+func TestServiceInternalAPIToken(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour).UTC()
+
+	rMock := repositoryMock{
+		APITokenByHashFn: func(ctx context.Context, hash string) (*APIToken, error) {
+			if hash != "hash1" {
+				return nil, errors.WithStack(sql.ErrNoRows)
+			}
+
+			return &APIToken{ID: 1, UserID: 3, TokenHash: hash, Scopes: []string{"post:write"}, ExpiresAt: expiresAt}, nil
+		},
+	}
+
+	s := NewService(
+		tracerMock,
+		rMock,
+		manager.Mock{},
+		generatorMock,
+		catalogMock,
+		revocation.Mock{},
+		apitoken.Mock{},
+		throttleNoop,
+		nil,
+		testConfig,
+	)
+	ctx := context.Background()
+
+	token, err := s.InternalAPIToken(ctx, InternalAPITokenRequest{Hash: "hash1"})
+	if err != nil || token.ID != 1 || token.UserID != 3 || !token.ExpiresAt.Equal(expiresAt) {
+		t.Fatalf("got: %+v/%v, want: token 1 of user 3", token, err)
+	}
+
+	_, err = s.InternalAPIToken(ctx, InternalAPITokenRequest{Hash: "unknown"})
+	if status, _ := api.NewErrorResponse("", err); status != http.StatusNotFound {
+		t.Fatalf("got: %d, want: %d", status, http.StatusNotFound)
+	}
+
+	_, err = s.InternalAPIToken(ctx, InternalAPITokenRequest{})
+	if status, _ := api.NewErrorResponse("", err); status != http.StatusBadRequest {
+		t.Fatalf("got: %d, want: %d", status, http.StatusBadRequest)
+	}
+
+	// store of auth service loads tokens from database
+	if _, err = NewAPITokenSource(rMock).Token(ctx, "unknown"); !errors.Is(err, apitoken.ErrNotFound) {
+		t.Fatalf("got: %v, want: %s", err, apitoken.ErrNotFound)
+	}
+}
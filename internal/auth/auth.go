@@ -1,24 +1,55 @@
 package auth
 
 import (
+	"errors"
 	"time"
+
+	"gitlab.com/slirx/newproj/pkg/password"
+	"gitlab.com/slirx/newproj/pkg/rbac"
 )
 
 // LoginRequest represents fields of login request.
 type LoginRequest struct {
-	Login    string `json:"login"`
-	Password string `json:"password"`
+	Login     string `json:"login"`
+	Password  string `json:"password"`
+	Locale    string `json:"locale"` // locale of lockout email, Accept-Language header is used in case it's empty
+	IP        string `json:"-"`      // IP address of the client, it's set by handler
+	UserAgent string `json:"-"`      // User-Agent header of the client, it's saved in session
 }
 
-// LoginResponse represents fields of login response.
+// LoginResponse represents fields of login response. In case user has enabled MFA, only MFAToken is returned.
+// It should be exchanged to access token with MFA code.
 type LoginResponse struct {
-	AccessToken string `json:"access_token"`
+	AccessToken string `json:"access_token,omitempty"`
+	MFARequired bool   `json:"mfa_required,omitempty"`
+	MFAToken    string `json:"mfa_token,omitempty"`
+	// RegistrationPending is set after the first sign in through OpenID Connect provider. Account of the user
+	// is being created, so the user should sign in again a bit later.
+	RegistrationPending bool `json:"registration_pending,omitempty"`
 }
 
+// AccessTokenTTL is lifetime of access token of a user.
+const AccessTokenTTL = 24 * time.Hour
+
+// AdminAccessTokenTTL is lifetime of access token of admin panel.
+const AdminAccessTokenTTL = time.Hour
+
 // Config represents configuration fields for auth service.
 type Config struct {
 	Secret          string            // JWT secret
 	InternalSecrets map[string]string // JWT secrets for microservices (service-to-service communication)
+	// PasswordResetURL is URL of the page of frontend where user sets a new password. Reset token is added to it
+	// as "token" parameter.
+	PasswordResetURL string
+	// PasswordResetTTL is lifetime of password reset token.
+	PasswordResetTTL time.Duration
+	// MFAIssuer is the name of the service shown in authenticator apps.
+	MFAIssuer string
+	// AdminMFARequired denies login to admin panel for admins without MFA.
+	AdminMFARequired bool
+	// ReservedLogins can't be given to users registered through OpenID Connect provider. They should be the same
+	// as the ones of registration service.
+	ReservedLogins []string
 }
 
 // Auth represents fields for columns in auth table.
@@ -28,6 +59,7 @@ type Auth struct {
 	Email     string
 	Login     string
 	Password  string
+	Role      rbac.Role // it's set only by AdminAuth, AuthByUserID and AuthByEmail
 	CreatedAt time.Time
 }
 
@@ -35,11 +67,14 @@ type Auth struct {
 type InternalLoginRequest struct {
 	Login    string `json:"login"`
 	Password string `json:"password"`
+	IP       string `json:"-"`
 }
 
 // InternalLoginResponse represents fields of login response.
 type InternalLoginResponse struct {
 	AccessToken string `json:"access_token"`
+	// ExpiresAt is unix time of token expiration. Services log in again before it.
+	ExpiresAt int64 `json:"expires_at"`
 }
 
 // InternalAuth represents fields for columns in internal_auth table.
@@ -52,11 +87,456 @@ type InternalAuth struct {
 
 // AdminLoginRequest represents fields of login request.
 type AdminLoginRequest struct {
-	Login    string `json:"login"`
-	Password string `json:"password"`
+	Login     string `json:"login"`
+	Password  string `json:"password"`
+	Locale    string `json:"locale"`
+	IP        string `json:"-"`
+	UserAgent string `json:"-"`
 }
 
-// AdminLoginResponse represents fields of login response.
+// AdminLoginResponse represents fields of login response. It's the same as LoginResponse.
 type AdminLoginResponse struct {
+	AccessToken string `json:"access_token,omitempty"`
+	MFARequired bool   `json:"mfa_required,omitempty"`
+	MFAToken    string `json:"mfa_token,omitempty"`
+}
+
+// SetRoleRequest represents fields of request which grants role to the user.
+type SetRoleRequest struct {
+	Login string    `json:"login"`
+	Role  rbac.Role `json:"role"`
+}
+
+// Validate validates set role request.
+func (r SetRoleRequest) Validate() error {
+	if r.Login == "" {
+		return errors.New("login should not be empty")
+	}
+
+	if !r.Role.Valid() {
+		return errors.New("role is invalid")
+	}
+
+	return nil
+}
+
+// ForgotPasswordRequest represents fields of forgot password request.
+type ForgotPasswordRequest struct {
+	Email  string `json:"email"`
+	Locale string `json:"locale"` // locale of the email, Accept-Language header is used in case it's empty
+	IP     string `json:"-"`      // IP address of the client, it's set by handler
+}
+
+// ResetPasswordRequest represents fields of reset password request.
+type ResetPasswordRequest struct {
+	Token                string `json:"token"` // token from password reset email
+	Password             string `json:"password"`
+	PasswordConfirmation string `json:"password_confirmation"` // should be the same as password field
+	Locale               string `json:"locale"`
+}
+
+// Validate validates reset password request.
+func (r ResetPasswordRequest) Validate() error {
+	if r.Token == "" {
+		return errors.New("reset token should not be empty")
+	}
+
+	return password.Validate(r.Password, r.PasswordConfirmation)
+}
+
+// PasswordResetEmail is used for generating password reset email.
+type PasswordResetEmail struct {
+	Login            string
+	URL              string
+	ExpiresInMinutes int
+}
+
+// AccountLockedEmail is used for generating email which notifies that login is locked after failed attempts.
+type AccountLockedEmail struct {
+	Login            string
+	LockedForMinutes int
+}
+
+// PasswordChangedEmail is used for generating email which confirms that password is changed.
+type PasswordChangedEmail struct {
+	Login string
+}
+
+// ChangePasswordRequest represents fields of change password request.
+type ChangePasswordRequest struct {
+	CurrentPassword      string `json:"current_password"`
+	Password             string `json:"password"`
+	PasswordConfirmation string `json:"password_confirmation"` // should be the same as password field
+	Locale               string `json:"locale"`
+	IP                   string `json:"-"`
+	UserAgent            string `json:"-"`
+}
+
+// Validate validates change password request.
+func (r ChangePasswordRequest) Validate() error {
+	if r.CurrentPassword == "" {
+		return errors.New("current password should not be empty")
+	}
+
+	return password.Validate(r.Password, r.PasswordConfirmation)
+}
+
+// ChangePasswordResponse represents response of change password request. Tokens issued before the change are
+// revoked, so a new one is returned.
+type ChangePasswordResponse struct {
+	AccessToken string `json:"access_token"`
+}
+
+// ChangeEmailRequest represents fields of change email request.
+type ChangeEmailRequest struct {
+	Email    string `json:"email"`    // new email
+	Password string `json:"password"` // current password
+	Locale   string `json:"locale"`
+}
+
+// Validate validates change email request.
+func (r ChangeEmailRequest) Validate() error {
+	if r.Email == "" {
+		return errors.New("email should not be empty")
+	}
+
+	if r.Password == "" {
+		return errors.New("password should not be empty")
+	}
+
+	return nil
+}
+
+// ConfirmEmailChangeRequest represents fields of request which confirms the new email.
+type ConfirmEmailChangeRequest struct {
+	Code int `json:"code"` // code from email sent to the new address
+}
+
+// EmailChange represents not confirmed change of email.
+type EmailChange struct {
+	ID        int
+	AuthID    int
+	Email     string
+	CodeHash  string
+	Attempts  int
+	ExpiresAt time.Time
+}
+
+// EmailChangeConfirmation is used for generating email with confirmation code of the new email.
+type EmailChangeConfirmation struct {
+	Login string
+	Code  int
+}
+
+// AvailabilityRequest represents fields of request which checks whether login and email can be registered.
+type AvailabilityRequest struct {
+	Login string
+	Email string
+}
+
+// AvailabilityResponse reports whether login and email are already used. Both are compared case-insensitively.
+type AvailabilityResponse struct {
+	LoginTaken bool `json:"login_taken"`
+	EmailTaken bool `json:"email_taken"`
+}
+
+// MFA represents TOTP settings of auth. MFA is enabled only after the first valid code, so a secret which
+// was not added to authenticator app can't lock user out.
+type MFA struct {
+	AuthID       int
+	Secret       string
+	Enabled      bool
+	LastUsedStep int64 // step of the latest used code, codes of the same and earlier steps are rejected
+	Attempts     int   // number of invalid codes since the latest valid one
+}
+
+// MFAEnrollRequest represents fields of request which starts enrollment of MFA.
+type MFAEnrollRequest struct {
+	Password string `json:"password"` // current password
+}
+
+// MFAEnrollResponse contains secret of authenticator app. URI is usually shown as QR code.
+type MFAEnrollResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// MFACodeRequest represents request with code from authenticator app.
+type MFACodeRequest struct {
+	Code string `json:"code"`
+}
+
+// MFADisableRequest represents fields of request which disables MFA. Either code or recovery code should be set.
+type MFADisableRequest struct {
+	Password     string `json:"password"` // current password
+	Code         string `json:"code"`     // code from authenticator app
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// MFAVerifyRequest represents the second step of login. Either code or recovery code should be set.
+type MFAVerifyRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+	IP           string `json:"-"`
+	UserAgent    string `json:"-"`
+}
+
+// Validate validates MFA verification request.
+func (r MFAVerifyRequest) Validate() error {
+	if r.MFAToken == "" {
+		return errors.New("mfa token should not be empty")
+	}
+
+	if r.Code == "" && r.RecoveryCode == "" {
+		return errors.New("code or recovery code should be specified")
+	}
+
+	return nil
+}
+
+// RecoveryCodesResponse contains one-time recovery codes. They are shown only once, only their hashes are stored.
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// Session represents fields for columns in session table. Session is created on every login of user, its id is
+// "jti" claim of the access token.
+type Session struct {
+	ID        string
+	UserID    int
+	UserAgent string
+	IP        string // IP address of the client, the one set by trusted proxies in case server is behind them
+	ClientID  string // OAuth client the token is issued to, it's empty for sessions of the user
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// SessionsResponse contains active sessions of the user.
+type SessionsResponse struct {
+	Sessions []SessionItem `json:"sessions"`
+}
+
+// SessionItem represents session in the list of sessions.
+type SessionItem struct {
+	ID         string `json:"id"`
+	UserAgent  string `json:"user_agent"`
+	IP         string `json:"ip"`
+	CreatedAt  int64  `json:"created_at"`
+	LastSeenAt int64  `json:"last_seen_at"`
+	Current    bool   `json:"current"` // session of the token the request is made with
+	ClientID   string `json:"client_id,omitempty"`
+}
+
+// RevokeSessionRequest represents fields of revoke session request.
+type RevokeSessionRequest struct {
+	ID string `json:"-"` // it's taken from URL
+}
+
+// UserSessionsRequest represents fields of request of staff to sessions of the user.
+type UserSessionsRequest struct {
+	Login string `json:"-"` // it's taken from URL
+}
+
+// OAuthClient represents fields for columns in oauth_client table. It's a third-party application which acts
+// on behalf of users.
+type OAuthClient struct {
+	ID           int
+	ClientID     string
+	SecretHash   string // it's empty for public clients, for example mobile apps, which can't keep a secret
+	Name         string
+	RedirectURIs []string
+	UserID       int // developer who registered the client
+	CreatedAt    time.Time
+}
+
+// RegisterClientRequest represents fields of OAuth client registration request.
+type RegisterClientRequest struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Confidential bool     `json:"confidential"` // secret is issued only to confidential clients, e.g. web servers
+}
+
+// RegisterClientResponse contains credentials of the registered client. Secret is shown only once, only its hash
+// is stored.
+type RegisterClientResponse struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// AuthorizeRequest represents parameters of authorization request of the code flow (RFC 6749) with PKCE
+// (RFC 7636). Only "code" response type and "S256" challenge method are supported.
+type AuthorizeRequest struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"` // scopes separated by spaces
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+}
+
+// AuthorizeResponse describes authorization request for consent page.
+type AuthorizeResponse struct {
+	ClientName string   `json:"client_name"`
+	Scopes     []string `json:"scopes"`
+	Consented  bool     `json:"consented"` // the user has already granted all requested scopes to the client
+}
+
+// ConsentRequest represents decision of the user about authorization request.
+type ConsentRequest struct {
+	AuthorizeRequest
+	Approved bool `json:"approved"`
+}
+
+// ConsentResponse contains URL the user should be redirected to. It has either authorization code or error
+// for the client.
+type ConsentResponse struct {
+	RedirectURL string `json:"redirect_url"`
+}
+
+// Consent represents fields for columns in oauth_consent table.
+type Consent struct {
+	UserID     int
+	ClientID   string
+	ClientName string
+	Scopes     []string
+	UpdatedAt  time.Time
+}
+
+// ConsentsResponse contains applications the user granted access to.
+type ConsentsResponse struct {
+	Consents []ConsentItem `json:"consents"`
+}
+
+// ConsentItem represents consent in the list of consents.
+type ConsentItem struct {
+	ClientID   string   `json:"client_id"`
+	ClientName string   `json:"client_name"`
+	Scopes     []string `json:"scopes"`
+	GrantedAt  int64    `json:"granted_at"`
+}
+
+// RevokeConsentRequest represents fields of revoke consent request.
+type RevokeConsentRequest struct {
+	ClientID string `json:"-"` // it's taken from URL
+}
+
+// OAuthCode represents fields for columns in oauth_code table.
+type OAuthCode struct {
+	ClientID      string
+	UserID        int
+	RedirectURI   string
+	Scopes        []string
+	CodeChallenge string
+	ExpiresAt     time.Time
+}
+
+// TokenRequest represents fields of token request. Only "authorization_code" grant type is supported.
+type TokenRequest struct {
+	GrantType    string
+	Code         string
+	RedirectURI  string
+	ClientID     string
+	ClientSecret string // it's required for confidential clients
+	CodeVerifier string
+}
+
+// TokenResponse represents successful response of token endpoint (RFC 6749 section 5.1).
+type TokenResponse struct {
 	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	Scope       string `json:"scope"`
+}
+
+// IntrospectRequest represents fields of token introspection request (RFC 7662). Only confidential clients can
+// introspect tokens and only the ones issued to them.
+type IntrospectRequest struct {
+	Token        string
+	ClientID     string
+	ClientSecret string
+}
+
+// IntrospectResponse represents response of introspection endpoint. Only Active is set for inactive tokens.
+type IntrospectResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	UserID    int    `json:"uid,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+}
+
+// OIDCLogin represents sign in through OpenID Connect provider which waits for the user to return from it.
+type OIDCLogin struct {
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
+
+// OIDCLoginResponse contains URL of the provider the user should be redirected to.
+type OIDCLoginResponse struct {
+	AuthURL string `json:"auth_url"`
+	State   string `json:"-"` // it's kept in cookie by handler, so callback is accepted from the same browser only
+}
+
+// OIDCCallbackRequest represents parameters the provider redirected the user back with.
+type OIDCCallbackRequest struct {
+	Code        string `json:"code"`
+	State       string `json:"state"`
+	StateCookie string `json:"-"` // state of sign in started by this browser, it's set by handler
+	IP          string `json:"-"`
+	UserAgent   string `json:"-"`
+}
+
+// APIToken represents fields for columns in api_token table. It's personal token of the user for scripts and bots.
+type APIToken struct {
+	ID        int
+	UserID    int
+	Name      string
+	TokenHash string
+	Scopes    []string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// CreateAPITokenRequest represents fields of create API token request.
+type CreateAPITokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+// CreateAPITokenResponse contains the created token. Token is shown only once, only its hash is stored.
+type CreateAPITokenResponse struct {
+	ID        int    `json:"id"`
+	Token     string `json:"token"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// APITokensResponse contains active API tokens of the user.
+type APITokensResponse struct {
+	Tokens []APITokenItem `json:"tokens"`
+}
+
+// APITokenItem represents token in the list of API tokens.
+type APITokenItem struct {
+	ID         int      `json:"id"`
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  int64    `json:"created_at"`
+	ExpiresAt  int64    `json:"expires_at"`
+	LastUsedAt int64    `json:"last_used_at,omitempty"` // it's omitted in case the token wasn't used yet
+}
+
+// InternalAPITokenRequest represents fields of request of API token by other services.
+type InternalAPITokenRequest struct {
+	Hash string `json:"hash"`
+}
+
+// RevokeAPITokenRequest represents fields of revoke API token request.
+type RevokeAPITokenRequest struct {
+	ID int `json:"-"` // it's taken from URL
 }
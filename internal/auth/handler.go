@@ -37,7 +37,6 @@ func (h CreateHandler) Handle(ctx context.Context, msg amqp.Delivery) error {
 	}
 
 	tx := apm.TransactionFromContext(ctx)
-	tx.Context.SetLabel("request_id", task.RequestID)
 
 	body, err := json.Marshal(task)
 	if err != nil {
@@ -50,14 +49,24 @@ func (h CreateHandler) Handle(ctx context.Context, msg amqp.Delivery) error {
 	h.Logger.Debug(fmt.Sprintf("creating auth for %s", task.Login), apmzap.TraceContext(ctx)...)
 
 	err = h.Repository.Create(ctx, task)
+	if errors.Is(err, ErrAuthExists) {
+		// registration through OpenID Connect provider can be started again with another login
+		if task.Issuer != "" {
+			if deleteErr := h.Repository.DeleteExternalRegistration(ctx, task.Issuer, task.Subject); deleteErr != nil {
+				return deleteErr
+			}
+		}
+
+		return worker.Permanent(err)
+	}
+
 	if err != nil {
 		return err
 	}
 
 	err = h.Manager.Send(ctx, queue.JobUserCreate, queue.UserCreate{
-		RequestID: task.RequestID,
-		Login:     task.Login,
-		Email:     task.Email,
+		Login: task.Login,
+		Email: task.Email,
 	})
 	if err != nil {
 		return err
@@ -77,7 +86,6 @@ func (h UpdateUserIDHandler) Handle(ctx context.Context, msg amqp.Delivery) erro
 	}
 
 	tx := apm.TransactionFromContext(ctx)
-	tx.Context.SetLabel("request_id", task.RequestID)
 
 	body, err := json.Marshal(task)
 	if err != nil {
@@ -2,14 +2,22 @@ package auth
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
 
+	"github.com/go-chi/chi/v5"
 	"go.elastic.co/apm/module/apmzap"
 
 	"gitlab.com/slirx/newproj/pkg/api"
+	"gitlab.com/slirx/newproj/pkg/http/clientip"
 	"gitlab.com/slirx/newproj/pkg/logger"
+	"gitlab.com/slirx/newproj/pkg/template"
 )
 
+// oidcStateCookieName is name of cookie with state of sign in through OpenID Connect provider.
+const oidcStateCookieName = "oidc_state"
+
 // todo check corresponding to https://github.com/shieldfy/API-Security-Checklist
 
 // Handler represents methods for auth HTTP server.
@@ -20,12 +28,73 @@ type Handler interface {
 	InternalLogin(w http.ResponseWriter, r *http.Request)
 	// AdminLogin checks login/password and returns JWT. It's used for admin panel.
 	AdminLogin(w http.ResponseWriter, r *http.Request)
+	// SetRole grants role to the user. It's used for admin panel.
+	SetRole(w http.ResponseWriter, r *http.Request)
+	// ForgotPassword sends email with password reset link to the user.
+	ForgotPassword(w http.ResponseWriter, r *http.Request)
+	// ResetPassword sets a new password using token from password reset email.
+	ResetPassword(w http.ResponseWriter, r *http.Request)
+	// ChangePassword sets a new password of the current user and returns a new JWT.
+	ChangePassword(w http.ResponseWriter, r *http.Request)
+	// ChangeEmail sends confirmation code to the new email of the current user.
+	ChangeEmail(w http.ResponseWriter, r *http.Request)
+	// ConfirmEmailChange checks confirmation code and changes email of the current user.
+	ConfirmEmailChange(w http.ResponseWriter, r *http.Request)
+	// InternalAvailability reports whether login and email are already used.
+	InternalAvailability(w http.ResponseWriter, r *http.Request)
+	// InternalAPIToken returns API token by its hash to other services.
+	InternalAPIToken(w http.ResponseWriter, r *http.Request)
+	// EnrollMFA generates secret of authenticator app of the current user.
+	EnrollMFA(w http.ResponseWriter, r *http.Request)
+	// EnableMFA enables MFA of the current user and returns recovery codes.
+	EnableMFA(w http.ResponseWriter, r *http.Request)
+	// DisableMFA disables MFA of the current user.
+	DisableMFA(w http.ResponseWriter, r *http.Request)
+	// RegenerateRecoveryCodes replaces recovery codes of the current user.
+	RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request)
+	// VerifyMFA exchanges MFA token and code to JWT.
+	VerifyMFA(w http.ResponseWriter, r *http.Request)
+	// AdminVerifyMFA exchanges MFA token and code to JWT of admin panel.
+	AdminVerifyMFA(w http.ResponseWriter, r *http.Request)
+	// Sessions returns active sessions of the current user.
+	Sessions(w http.ResponseWriter, r *http.Request)
+	// RevokeSession revokes session of the current user.
+	RevokeSession(w http.ResponseWriter, r *http.Request)
+	// UserSessions returns active sessions of the user. It's used for admin panel.
+	UserSessions(w http.ResponseWriter, r *http.Request)
+	// RevokeUserSessions revokes all sessions of the user. It's used for admin panel.
+	RevokeUserSessions(w http.ResponseWriter, r *http.Request)
+	// CreateAPIToken creates personal API token of the current user.
+	CreateAPIToken(w http.ResponseWriter, r *http.Request)
+	// APITokens returns API tokens of the current user.
+	APITokens(w http.ResponseWriter, r *http.Request)
+	// RevokeAPIToken revokes API token of the current user.
+	RevokeAPIToken(w http.ResponseWriter, r *http.Request)
+	// RegisterClient registers OAuth client of the current user.
+	RegisterClient(w http.ResponseWriter, r *http.Request)
+	// Authorize returns information about OAuth authorization request for consent page.
+	Authorize(w http.ResponseWriter, r *http.Request)
+	// Consent grants or denies access requested by OAuth client and returns URL to redirect the user to.
+	Consent(w http.ResponseWriter, r *http.Request)
+	// Consents returns applications the current user granted access to.
+	Consents(w http.ResponseWriter, r *http.Request)
+	// RevokeConsent revokes access of the application.
+	RevokeConsent(w http.ResponseWriter, r *http.Request)
+	// Token exchanges authorization code to access token.
+	Token(w http.ResponseWriter, r *http.Request)
+	// Introspect returns state of access token.
+	Introspect(w http.ResponseWriter, r *http.Request)
+	// OIDCLogin returns URL of OpenID Connect provider to sign in at.
+	OIDCLogin(w http.ResponseWriter, r *http.Request)
+	// OIDCCallback exchanges code of OpenID Connect provider to JWT.
+	OIDCCallback(w http.ResponseWriter, r *http.Request)
 }
 
 type handler struct {
 	Service         Service
 	Logger          logger.Logger
 	ResponseBuilder api.ResponseBuilder
+	ClientIP        clientip.Resolver
 }
 
 // Login checks login/password and returns JWT.
@@ -39,6 +108,13 @@ func (h handler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if request.Locale == "" {
+		request.Locale = template.AcceptLanguage(r.Header.Get("Accept-Language"))
+	}
+
+	request.IP = h.ClientIP.IP(r)
+	request.UserAgent = r.UserAgent()
+
 	response, err := h.Service.Login(r.Context(), request)
 	if err != nil {
 		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
@@ -59,6 +135,8 @@ func (h handler) InternalLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	request.IP = h.ClientIP.IP(r)
+
 	response, err := h.Service.InternalLogin(r.Context(), request)
 	if err != nil {
 		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
@@ -80,6 +158,13 @@ func (h handler) AdminLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if request.Locale == "" {
+		request.Locale = template.AcceptLanguage(r.Header.Get("Accept-Language"))
+	}
+
+	request.IP = h.ClientIP.IP(r)
+	request.UserAgent = r.UserAgent()
+
 	response, err := h.Service.AdminLogin(r.Context(), request)
 	if err != nil {
 		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
@@ -90,7 +175,688 @@ func (h handler) AdminLogin(w http.ResponseWriter, r *http.Request) {
 	h.ResponseBuilder.DataResponse(ctx, w, response)
 }
 
-// NewHandler returns instance of implemented Handler interface.
-func NewHandler(s Service, l logger.Logger, rb api.ResponseBuilder) Handler {
-	return handler{Service: s, Logger: l, ResponseBuilder: rb}
+// SetRole grants role to the user.
+func (h handler) SetRole(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	request := SetRoleRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, api.RequestError)
+		return
+	}
+
+	msg, err := h.Service.SetRole(ctx, request)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	h.ResponseBuilder.MessageResponse(ctx, w, msg)
+}
+
+// ForgotPassword sends email with password reset link to the user.
+func (h handler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	request := ForgotPasswordRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, api.RequestError)
+		return
+	}
+
+	if request.Locale == "" {
+		request.Locale = template.AcceptLanguage(r.Header.Get("Accept-Language"))
+	}
+
+	request.IP = h.ClientIP.IP(r)
+
+	msg, err := h.Service.ForgotPassword(r.Context(), request)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	h.ResponseBuilder.MessageResponse(ctx, w, msg)
+}
+
+// ResetPassword sets a new password using token from password reset email.
+func (h handler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	request := ResetPasswordRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, api.RequestError)
+		return
+	}
+
+	if request.Locale == "" {
+		request.Locale = template.AcceptLanguage(r.Header.Get("Accept-Language"))
+	}
+
+	msg, err := h.Service.ResetPassword(r.Context(), request)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	h.ResponseBuilder.MessageResponse(ctx, w, msg)
+}
+
+// ChangePassword sets a new password of the current user and returns a new JWT.
+func (h handler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	request := ChangePasswordRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, api.RequestError)
+		return
+	}
+
+	if request.Locale == "" {
+		request.Locale = template.AcceptLanguage(r.Header.Get("Accept-Language"))
+	}
+
+	request.IP = h.ClientIP.IP(r)
+	request.UserAgent = r.UserAgent()
+
+	response, err := h.Service.ChangePassword(r.Context(), request)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	h.ResponseBuilder.DataResponse(ctx, w, response)
+}
+
+// ChangeEmail sends confirmation code to the new email of the current user.
+func (h handler) ChangeEmail(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	request := ChangeEmailRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, api.RequestError)
+		return
+	}
+
+	if request.Locale == "" {
+		request.Locale = template.AcceptLanguage(r.Header.Get("Accept-Language"))
+	}
+
+	msg, err := h.Service.ChangeEmail(r.Context(), request)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	h.ResponseBuilder.MessageResponse(ctx, w, msg)
+}
+
+// ConfirmEmailChange checks confirmation code and changes email of the current user.
+func (h handler) ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	request := ConfirmEmailChangeRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, api.RequestError)
+		return
+	}
+
+	msg, err := h.Service.ConfirmEmailChange(r.Context(), request)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	h.ResponseBuilder.MessageResponse(ctx, w, msg)
+}
+
+// InternalAvailability reports whether login and email are already used.
+func (h handler) InternalAvailability(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	request := AvailabilityRequest{
+		Login: r.URL.Query().Get("login"),
+		Email: r.URL.Query().Get("email"),
+	}
+
+	response, err := h.Service.InternalAvailability(ctx, request)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	h.ResponseBuilder.DataResponse(ctx, w, response)
+}
+
+// InternalAPIToken returns API token by its hash to other services.
+func (h handler) InternalAPIToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	request := InternalAPITokenRequest{Hash: chi.URLParam(r, "hash")}
+
+	response, err := h.Service.InternalAPIToken(ctx, request)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	h.ResponseBuilder.DataResponse(ctx, w, response)
+}
+
+// EnrollMFA generates secret of authenticator app of the current user.
+func (h handler) EnrollMFA(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	request := MFAEnrollRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, api.RequestError)
+		return
+	}
+
+	response, err := h.Service.EnrollMFA(r.Context(), request)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	h.ResponseBuilder.DataResponse(ctx, w, response)
+}
+
+// EnableMFA enables MFA of the current user and returns recovery codes.
+func (h handler) EnableMFA(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	request := MFACodeRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, api.RequestError)
+		return
+	}
+
+	response, err := h.Service.EnableMFA(r.Context(), request)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	h.ResponseBuilder.DataResponse(ctx, w, response)
+}
+
+// DisableMFA disables MFA of the current user.
+func (h handler) DisableMFA(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	request := MFADisableRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, api.RequestError)
+		return
+	}
+
+	msg, err := h.Service.DisableMFA(r.Context(), request)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	h.ResponseBuilder.MessageResponse(ctx, w, msg)
+}
+
+// RegenerateRecoveryCodes replaces recovery codes of the current user.
+func (h handler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	request := MFACodeRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, api.RequestError)
+		return
+	}
+
+	response, err := h.Service.RegenerateRecoveryCodes(r.Context(), request)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	h.ResponseBuilder.DataResponse(ctx, w, response)
+}
+
+// VerifyMFA exchanges MFA token and code to JWT.
+func (h handler) VerifyMFA(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	request := MFAVerifyRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, api.RequestError)
+		return
+	}
+
+	request.IP = h.ClientIP.IP(r)
+	request.UserAgent = r.UserAgent()
+
+	response, err := h.Service.VerifyMFA(r.Context(), request)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	h.ResponseBuilder.DataResponse(ctx, w, response)
+}
+
+// AdminVerifyMFA exchanges MFA token and code to JWT of admin panel.
+func (h handler) AdminVerifyMFA(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	request := MFAVerifyRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, api.RequestError)
+		return
+	}
+
+	request.IP = h.ClientIP.IP(r)
+	request.UserAgent = r.UserAgent()
+
+	response, err := h.Service.AdminVerifyMFA(r.Context(), request)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	h.ResponseBuilder.DataResponse(ctx, w, response)
+}
+
+// Sessions returns active sessions of the current user.
+func (h handler) Sessions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	response, err := h.Service.Sessions(ctx)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	h.ResponseBuilder.DataResponse(ctx, w, response)
+}
+
+// RevokeSession revokes session of the current user.
+func (h handler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	request := RevokeSessionRequest{ID: chi.URLParam(r, "id")}
+
+	msg, err := h.Service.RevokeSession(ctx, request)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	h.ResponseBuilder.MessageResponse(ctx, w, msg)
+}
+
+// UserSessions returns active sessions of the user.
+func (h handler) UserSessions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	request := UserSessionsRequest{Login: chi.URLParam(r, "login")}
+
+	response, err := h.Service.UserSessions(ctx, request)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	h.ResponseBuilder.DataResponse(ctx, w, response)
+}
+
+// RevokeUserSessions revokes all sessions of the user.
+func (h handler) RevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	request := UserSessionsRequest{Login: chi.URLParam(r, "login")}
+
+	msg, err := h.Service.RevokeUserSessions(ctx, request)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	h.ResponseBuilder.MessageResponse(ctx, w, msg)
+}
+
+// CreateAPIToken creates personal API token of the current user.
+func (h handler) CreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	request := CreateAPITokenRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, api.RequestError)
+		return
+	}
+
+	response, err := h.Service.CreateAPIToken(ctx, request)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	h.ResponseBuilder.DataResponse(ctx, w, response)
+}
+
+// APITokens returns API tokens of the current user.
+func (h handler) APITokens(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	response, err := h.Service.APITokens(ctx)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	h.ResponseBuilder.DataResponse(ctx, w, response)
+}
+
+// RevokeAPIToken revokes API token of the current user.
+func (h handler) RevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	request := RevokeAPITokenRequest{}
+
+	var err error
+	request.ID, err = strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, api.RequestError)
+		return
+	}
+
+	msg, err := h.Service.RevokeAPIToken(ctx, request)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	h.ResponseBuilder.MessageResponse(ctx, w, msg)
+}
+
+// RegisterClient registers OAuth client of the current user.
+func (h handler) RegisterClient(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	request := RegisterClientRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, api.RequestError)
+		return
+	}
+
+	response, err := h.Service.RegisterClient(ctx, request)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	h.ResponseBuilder.DataResponse(ctx, w, response)
+}
+
+// Authorize returns information about OAuth authorization request for consent page. The request is passed in query
+// parameters of the authorization URL.
+func (h handler) Authorize(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+	request := AuthorizeRequest{
+		ResponseType:        query.Get("response_type"),
+		ClientID:            query.Get("client_id"),
+		RedirectURI:         query.Get("redirect_uri"),
+		Scope:               query.Get("scope"),
+		State:               query.Get("state"),
+		CodeChallenge:       query.Get("code_challenge"),
+		CodeChallengeMethod: query.Get("code_challenge_method"),
+	}
+
+	response, err := h.Service.Authorize(ctx, request)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	h.ResponseBuilder.DataResponse(ctx, w, response)
+}
+
+// Consent grants or denies access requested by OAuth client and returns URL to redirect the user to.
+func (h handler) Consent(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	request := ConsentRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, api.RequestError)
+		return
+	}
+
+	response, err := h.Service.Consent(ctx, request)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	h.ResponseBuilder.DataResponse(ctx, w, response)
+}
+
+// Consents returns applications the current user granted access to.
+func (h handler) Consents(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	response, err := h.Service.Consents(ctx)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	h.ResponseBuilder.DataResponse(ctx, w, response)
+}
+
+// RevokeConsent revokes access of the application.
+func (h handler) RevokeConsent(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	request := RevokeConsentRequest{ClientID: chi.URLParam(r, "clientID")}
+
+	msg, err := h.Service.RevokeConsent(ctx, request)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	h.ResponseBuilder.MessageResponse(ctx, w, msg)
+}
+
+// Token exchanges authorization code to access token. Unlike other endpoints, it accepts form values and responds
+// in the format of RFC 6749, so standard OAuth libraries can be used by clients.
+func (h handler) Token(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if err := r.ParseForm(); err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		oauthResponse(w, OAuthError{Status: http.StatusBadRequest, Code: "invalid_request"})
+		return
+	}
+
+	clientID, clientSecret := clientCredentials(r)
+	request := TokenRequest{
+		GrantType:    r.PostForm.Get("grant_type"),
+		Code:         r.PostForm.Get("code"),
+		RedirectURI:  r.PostForm.Get("redirect_uri"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		CodeVerifier: r.PostForm.Get("code_verifier"),
+	}
+
+	response, err := h.Service.Token(ctx, request)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		oauthResponse(w, err)
+		return
+	}
+
+	oauthResponse(w, response)
+}
+
+// Introspect returns state of access token (RFC 7662). Like Token, it accepts form values.
+func (h handler) Introspect(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if err := r.ParseForm(); err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		oauthResponse(w, OAuthError{Status: http.StatusBadRequest, Code: "invalid_request"})
+		return
+	}
+
+	clientID, clientSecret := clientCredentials(r)
+	request := IntrospectRequest{
+		Token:        r.PostForm.Get("token"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+	}
+
+	response, err := h.Service.Introspect(ctx, request)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		oauthResponse(w, err)
+		return
+	}
+
+	oauthResponse(w, response)
+}
+
+// OIDCLogin returns URL of OpenID Connect provider to sign in at. State of sign in is kept in cookie, so it can be
+// finished by the same browser only.
+func (h handler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	response, err := h.Service.OIDCLogin(ctx)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	http.SetCookie(w, oidcStateCookie(response.State, int(oidcLoginTTL.Seconds())))
+
+	h.ResponseBuilder.DataResponse(ctx, w, response)
+}
+
+// OIDCCallback exchanges code of OpenID Connect provider to JWT. Frontend passes code and state the provider
+// redirected the user back with.
+func (h handler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	request := OIDCCallbackRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, api.RequestError)
+		return
+	}
+
+	if cookie, err := r.Cookie(oidcStateCookieName); err == nil {
+		request.StateCookie = cookie.Value
+	}
+
+	request.IP = h.ClientIP.IP(r)
+	request.UserAgent = r.UserAgent()
+
+	// state can be used once anyway
+	http.SetCookie(w, oidcStateCookie("", -1))
+
+	response, err := h.Service.OIDCCallback(ctx, request)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	h.ResponseBuilder.DataResponse(ctx, w, response)
+}
+
+// NewHandler returns instance of implemented Handler interface. IP address of the client is resolved by ip, so
+// addresses set by the proxy are used for throttling and sessions.
+func NewHandler(s Service, l logger.Logger, rb api.ResponseBuilder, ip clientip.Resolver) Handler {
+	return handler{Service: s, Logger: l, ResponseBuilder: rb, ClientIP: ip}
+}
+
+// oidcStateCookie returns cookie with state of sign in through OpenID Connect provider. Negative maxAge deletes it.
+func oidcStateCookie(state string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcStateCookieName,
+		Value:    state,
+		Path:     "/auth/oidc",
+		MaxAge:   maxAge,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// clientCredentials returns id and secret of OAuth client. HTTP Basic authentication is preferred by RFC 6749, but
+// credentials in form values are accepted too.
+func clientCredentials(r *http.Request) (string, string) {
+	if clientID, clientSecret, ok := r.BasicAuth(); ok {
+		return clientID, clientSecret
+	}
+
+	return r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+}
+
+// oauthResponse writes response of OAuth endpoint. data is either response or error. Errors other than OAuthError
+// are not exposed to the client.
+func oauthResponse(w http.ResponseWriter, data interface{}) {
+	status := http.StatusOK
+
+	if err, ok := data.(error); ok {
+		oauthErr := OAuthError{}
+		if !errors.As(err, &oauthErr) {
+			oauthErr = OAuthError{Status: http.StatusInternalServerError, Code: "server_error"}
+		}
+
+		if oauthErr.Status == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+
+		status, data = oauthErr.Status, oauthErr
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(data)
 }
@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gitlab.com/slirx/newproj/pkg/api"
+	"gitlab.com/slirx/newproj/pkg/http/clientip"
+	"gitlab.com/slirx/newproj/pkg/logger"
+)
+
+func TestHTTPForgotPasswordLocale(t *testing.T) {
+	var locale string
+
+	sMock := serviceMock{
+		ForgotPasswordFn: func(ctx context.Context, request ForgotPasswordRequest) (string, error) {
+			locale = request.Locale
+			return "email is sent", nil
+		},
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/auth/password/forgot", bytes.NewReader([]byte(`{"email":"a@b.c"}`)))
+	r.Header.Set("Accept-Language", "de-AT,de;q=0.9")
+	rec := httptest.NewRecorder()
+
+	h := NewHandler(sMock, logger.NewNoop(), api.NewResponseBuilder(tracerMock), clientip.Resolver{})
+	h.ForgotPassword(rec, r)
+
+	want := `{"request_id":"req1","type":"success","message":"email is sent"}
+`
+	if rec.Body.String() != want {
+		t.Fatalf("got: %s, want: %s", rec.Body.String(), want)
+	}
+
+	if locale != "de-at" {
+		t.Fatalf("got: %s, want: de-at", locale)
+	}
+}
+
+func TestHTTPResetPasswordRequestError(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/auth/password/reset", bytes.NewReader([]byte("oops")))
+	rec := httptest.NewRecorder()
+
+	h := NewHandler(serviceMock{}, logger.NewNoop(), api.NewResponseBuilder(tracerMock), clientip.Resolver{})
+	h.ResetPassword(rec, r)
+
+	want := `{"request_id":"req1","type":"error","message":"invalid request data","code":"invalid_request","errors":[]}
+`
+	if rec.Body.String() != want {
+		t.Fatalf("got: %s, want: %s", rec.Body.String(), want)
+	}
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("got: %d, want: %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+// TestHTTPLoginClientIP checks that session is created with address of the client set by envoy rather than the one of
+// envoy itself.
+func TestHTTPLoginClientIP(t *testing.T) {
+	var ip string
+
+	sMock := serviceMock{
+		LoginFn: func(ctx context.Context, request LoginRequest) (*LoginResponse, error) {
+			ip = request.IP
+			return &LoginResponse{AccessToken: "token"}, nil
+		},
+	}
+
+	resolver, err := clientip.NewResolver([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	h := NewHandler(sMock, logger.NewNoop(), api.NewResponseBuilder(tracerMock), resolver)
+
+	cases := []struct {
+		remoteAddr string
+		want       string
+	}{
+		{remoteAddr: "10.1.2.3:4000", want: "198.51.100.7"},
+		// the header is ignored in case the request doesn't come from envoy
+		{remoteAddr: "203.0.113.5:4000", want: "203.0.113.5"},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewReader([]byte(`{"login":"john"}`)))
+		r.RemoteAddr = c.remoteAddr
+		r.Header.Set(clientip.HeaderEnvoyExternalAddress, "198.51.100.7")
+
+		h.Login(httptest.NewRecorder(), r)
+
+		if ip != c.want {
+			t.Fatalf("got: %s, want: %s", ip, c.want)
+		}
+	}
+}
+
+func TestHTTPOIDCStateCookie(t *testing.T) {
+	var stateCookie string
+
+	sMock := serviceMock{
+		OIDCLoginFn: func(ctx context.Context) (*OIDCLoginResponse, error) {
+			return &OIDCLoginResponse{AuthURL: "https://idp.example.com/authorize", State: "state"}, nil
+		},
+		OIDCCallbackFn: func(ctx context.Context, request OIDCCallbackRequest) (*LoginResponse, error) {
+			stateCookie = request.StateCookie
+			return &LoginResponse{AccessToken: "token"}, nil
+		},
+	}
+
+	h := NewHandler(sMock, logger.NewNoop(), api.NewResponseBuilder(tracerMock), clientip.Resolver{})
+
+	rec := httptest.NewRecorder()
+	h.OIDCLogin(rec, httptest.NewRequest(http.MethodGet, "/auth/oidc/login", nil))
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != oidcStateCookieName || cookies[0].Value != "state" ||
+		!cookies[0].HttpOnly || !cookies[0].Secure {
+		t.Fatalf("got: %+v, want: secure http only cookie with state", cookies)
+	}
+
+	// state isn't returned to frontend, it comes back from the provider
+	if strings.Contains(rec.Body.String(), `"state"`) {
+		t.Fatalf("got: %s, want: response without state", rec.Body.String())
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/auth/oidc/callback", strings.NewReader(`{"code":"c","state":"state"}`))
+	r.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+
+	h.OIDCCallback(rec, r)
+
+	if stateCookie != "state" {
+		t.Fatalf("got: %s, want: state", stateCookie)
+	}
+
+	// cookie is deleted once it's used
+	cookies = rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != oidcStateCookieName || cookies[0].MaxAge >= 0 {
+		t.Fatalf("got: %+v, want: deleted cookie", cookies)
+	}
+}
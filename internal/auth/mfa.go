@@ -0,0 +1,434 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+
+	"gitlab.com/slirx/newproj/pkg/api"
+	"gitlab.com/slirx/newproj/pkg/http/jwtmiddleware"
+	"gitlab.com/slirx/newproj/pkg/totp"
+)
+
+const (
+	// mfaTokenTTL is lifetime of token which is exchanged to access token with MFA code.
+	mfaTokenTTL = 5 * time.Minute
+	// mfaMaxAttempts is the number of invalid codes after which only recovery codes are accepted.
+	mfaMaxAttempts = 5
+	// recoveryCodesCount is the number of recovery codes generated at once.
+	recoveryCodesCount = 10
+	// mfaAudience is audience of MFA tokens. They have no "uid" claim, so they are rejected by jwtmiddleware
+	// and can't be used instead of access tokens.
+	mfaAudience = "mfa"
+)
+
+// Kinds of login which MFA token is issued for. Token of one kind can't be exchanged to access token of another.
+const (
+	mfaLoginUser  = "user"
+	mfaLoginAdmin = "admin"
+)
+
+// CodeMFAEnrollmentRequired is returned in case admin without MFA logs in to admin panel and MFA is required.
+const CodeMFAEnrollmentRequired api.Code = "mfa_enrollment_required"
+
+var errMFATokenInvalid = api.NewAccessErrorWithCode(api.CodeInvalidToken, errors.New("mfa token is invalid or expired"))
+
+// Errors of wrong second factor. They are counted by Throttle in MFA verification.
+var (
+	errMFACodeInvalid      = api.NewRequestError(errors.New("mfa code is invalid"))
+	errMFACodeUsed         = api.NewRequestError(errors.New("mfa code is already used"))
+	errRecoveryCodeInvalid = api.NewRequestError(errors.New("recovery code is invalid"))
+)
+
+// EnrollMFA generates a new secret of authenticator app. MFA is enabled only after EnableMFA with valid code.
+func (s service) EnrollMFA(ctx context.Context, request MFAEnrollRequest) (*MFAEnrollResponse, error) {
+	data, err := s.currentAuth(ctx, request.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	mfa, err := s.Repository.MFA(ctx, data.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	if err == nil && mfa.Enabled {
+		return nil, api.NewRequestError(errors.New("mfa is already enabled"))
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.Repository.CreateMFA(ctx, data.ID, secret); err != nil {
+		return nil, err
+	}
+
+	return &MFAEnrollResponse{Secret: secret, URI: totp.URI(s.Config.MFAIssuer, data.Login, secret)}, nil
+}
+
+// EnableMFA enables MFA in case code of enrolled secret is valid and returns recovery codes.
+func (s service) EnableMFA(ctx context.Context, request MFACodeRequest) (*RecoveryCodesResponse, error) {
+	data, err := s.currentAuth(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	mfa, err := s.Repository.MFA(ctx, data.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, api.NewRequestError(errors.New("mfa is not enrolled"))
+		}
+
+		return nil, err
+	}
+
+	if mfa.Enabled {
+		return nil, api.NewRequestError(errors.New("mfa is already enabled"))
+	}
+
+	step, err := s.checkMFACode(ctx, mfa, request.Code)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes(data.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.Repository.EnableMFA(ctx, data.ID, step, hashes); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, api.NewRequestError(errors.New("mfa is already enabled"))
+		}
+
+		return nil, err
+	}
+
+	return &RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// DisableMFA disables MFA and deletes recovery codes. Password and the second factor are required.
+func (s service) DisableMFA(ctx context.Context, request MFADisableRequest) (string, error) {
+	data, err := s.currentAuth(ctx, request.Password)
+	if err != nil {
+		return "", err
+	}
+
+	mfa, err := s.enabledMFA(ctx, data.ID)
+	if err != nil {
+		return "", err
+	}
+
+	if err = s.checkSecondFactor(ctx, mfa, request.Code, request.RecoveryCode); err != nil {
+		return "", err
+	}
+
+	if err = s.Repository.DeleteMFA(ctx, data.ID); err != nil {
+		return "", err
+	}
+
+	return "mfa has been disabled", nil
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes, including unused ones, with new ones.
+func (s service) RegenerateRecoveryCodes(ctx context.Context, request MFACodeRequest) (*RecoveryCodesResponse, error) {
+	data, err := s.currentAuth(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	mfa, err := s.enabledMFA(ctx, data.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.checkSecondFactor(ctx, mfa, request.Code, ""); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes(data.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.Repository.ReplaceRecoveryCodes(ctx, data.ID, hashes); err != nil {
+		return nil, err
+	}
+
+	return &RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// VerifyMFA exchanges MFA token from Login to access token.
+func (s service) VerifyMFA(ctx context.Context, request MFAVerifyRequest) (*LoginResponse, error) {
+	data, err := s.verifyMFA(ctx, request, mfaLoginUser)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := s.accessToken(ctx, data.UserID, request.UserAgent, request.IP)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginResponse{AccessToken: accessToken}, nil
+}
+
+// AdminVerifyMFA exchanges MFA token from AdminLogin to access token of admin panel.
+func (s service) AdminVerifyMFA(ctx context.Context, request MFAVerifyRequest) (*AdminLoginResponse, error) {
+	data, err := s.verifyMFA(ctx, request, mfaLoginAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	// role could be revoked after the token was issued, it's checked by adminAccessToken
+	accessToken, err := s.adminAccessToken(ctx, data, request.UserAgent, request.IP)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AdminLoginResponse{AccessToken: accessToken}, nil
+}
+
+func (s service) verifyMFA(ctx context.Context, request MFAVerifyRequest, login string) (*Auth, error) {
+	if err := request.Validate(); err != nil {
+		return nil, api.NewRequestError(err)
+	}
+
+	uid, err := s.parseMFAToken(request.MFAToken, login)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := s.Repository.AuthByUserID(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	// MFA could be disabled after the token was issued
+	mfa, err := s.Repository.MFA(ctx, data.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errMFATokenInvalid
+		}
+
+		return nil, err
+	}
+
+	if !mfa.Enabled {
+		return nil, errMFATokenInvalid
+	}
+
+	// MFA token is valid for several minutes, so codes are throttled like passwords
+	if err = s.Throttle.Attempt(ctx, throttleScopeMFA, data.Login, request.IP); err != nil {
+		return nil, err
+	}
+
+	err = s.checkSecondFactor(ctx, mfa, request.Code, request.RecoveryCode)
+	if errors.Is(err, errMFACodeInvalid) || errors.Is(err, errMFACodeUsed) || errors.Is(err, errRecoveryCodeInvalid) {
+		lockedFor, failErr := s.Throttle.Fail(ctx, throttleScopeMFA, data.Login, request.IP)
+		if failErr != nil {
+			return nil, failErr
+		}
+
+		if lockedFor > 0 {
+			return nil, lockedError(lockedFor)
+		}
+
+		return nil, err
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err = s.Throttle.Succeed(ctx, throttleScopeMFA, data.Login); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// mfaPending returns MFA token in case MFA of auth is enabled. Empty string is returned otherwise.
+func (s service) mfaPending(ctx context.Context, data *Auth, login string) (string, error) {
+	mfa, err := s.Repository.MFA(ctx, data.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	if !mfa.Enabled {
+		return "", nil
+	}
+
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["exp"] = time.Now().Add(mfaTokenTTL).Unix()
+	claims["iat"] = time.Now().Unix()
+	claims["jti"] = s.RandGenerator.Uint64()
+	claims["aud"] = mfaAudience
+	claims["mfa_uid"] = data.UserID
+	claims["mfa_login"] = login
+
+	mfaToken, err := token.SignedString([]byte(s.Config.Secret))
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return mfaToken, nil
+}
+
+// parseMFAToken returns user ID of MFA token issued for the login kind.
+func (s service) parseMFAToken(mfaToken string, login string) (int, error) {
+	token, err := jwt.Parse(mfaToken, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errMFATokenInvalid
+		}
+
+		return []byte(s.Config.Secret), nil
+	})
+	if err != nil || !token.Valid {
+		return 0, errMFATokenInvalid
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !claims.VerifyAudience(mfaAudience, true) || claims["mfa_login"] != login {
+		return 0, errMFATokenInvalid
+	}
+
+	uid, ok := claims["mfa_uid"].(float64)
+	if !ok || uid == 0 {
+		return 0, errMFATokenInvalid
+	}
+
+	return int(uid), nil
+}
+
+// checkSecondFactor checks code from authenticator app or recovery code in case code is empty. Both are marked
+// as used, so they can't be used again.
+func (s service) checkSecondFactor(ctx context.Context, mfa *MFA, code string, recoveryCode string) error {
+	if code == "" && recoveryCode != "" {
+		err := s.Repository.UseRecoveryCode(ctx, mfa.AuthID, hashRecoveryCode(mfa.AuthID, recoveryCode))
+		if errors.Is(err, sql.ErrNoRows) {
+			return errRecoveryCodeInvalid
+		}
+
+		return err
+	}
+
+	step, err := s.checkMFACode(ctx, mfa, code)
+	if err != nil {
+		return err
+	}
+
+	if err = s.Repository.UseMFAStep(ctx, mfa.AuthID, step); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errMFACodeUsed
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// checkMFACode checks code from authenticator app and returns its step. Invalid codes are counted, TOTP is locked
+// after mfaMaxAttempts of them until recovery code is used, so codes can't be brute-forced.
+func (s service) checkMFACode(ctx context.Context, mfa *MFA, code string) (int64, error) {
+	// attempt is counted before the code is checked, so concurrent requests can't check more codes than allowed.
+	// Valid code resets the counter when its step is saved.
+	allowed, err := s.Repository.IncrMFAAttempts(ctx, mfa.AuthID, mfaMaxAttempts)
+	if err != nil {
+		return 0, err
+	}
+
+	if !allowed {
+		return 0, api.NewRequestError(errors.New("too many invalid codes. please, use a recovery code"))
+	}
+
+	step, ok := totp.Validate(mfa.Secret, strings.TrimSpace(code), time.Now())
+	if !ok {
+		return 0, errMFACodeInvalid
+	}
+
+	return step, nil
+}
+
+// enabledMFA returns MFA of auth. Request error is returned in case MFA is not enabled.
+func (s service) enabledMFA(ctx context.Context, authID int) (*MFA, error) {
+	mfa, err := s.Repository.MFA(ctx, authID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	if err != nil || !mfa.Enabled {
+		return nil, api.NewRequestError(errors.New("mfa is not enabled"))
+	}
+
+	return mfa, nil
+}
+
+// currentAuth returns auth of the current user. Password is checked in case it's required, that is not empty.
+func (s service) currentAuth(ctx context.Context, password string) (*Auth, error) {
+	uid, err := jwtmiddleware.UID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := s.Repository.AuthByUserID(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	if password == "" {
+		return data, nil
+	}
+
+	if err = bcrypt.CompareHashAndPassword([]byte(data.Password), []byte(password)); err != nil {
+		return nil, api.NewRequestError(errors.New("password is incorrect"))
+	}
+
+	return data, nil
+}
+
+// newRecoveryCodes returns recovery codes in form "xxxxx-xxxxx" and their hashes.
+func newRecoveryCodes(authID int) ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodesCount)
+	hashes := make([]string, 0, recoveryCodesCount)
+	b := make([]byte, 7)
+
+	for i := 0; i < recoveryCodesCount; i++ {
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(b))[:10]
+		code = code[:5] + "-" + code[5:]
+
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(authID, code))
+	}
+
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode returns hash of recovery code. Code is normalized, so it can be entered in any case and without
+// dash.
+func hashRecoveryCode(authID int, code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%d:%s", authID, code)))
+
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,325 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+
+	"gitlab.com/slirx/newproj/pkg/api"
+	"gitlab.com/slirx/newproj/pkg/apitoken"
+	"gitlab.com/slirx/newproj/pkg/http/jwtmiddleware"
+	"gitlab.com/slirx/newproj/pkg/logger"
+	"gitlab.com/slirx/newproj/pkg/queue/manager"
+	"gitlab.com/slirx/newproj/pkg/revocation"
+	"gitlab.com/slirx/newproj/pkg/totp"
+)
+
+// newMFARepository returns repository which keeps MFA and recovery codes of auth 7 (user 3) in memory.
+func newMFARepository(t *testing.T, mfa *MFA, recoveryCodes map[string]bool) repositoryMock {
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	data := &Auth{ID: 7, UserID: 3, Login: "john", Password: string(passwordHash)}
+
+	return repositoryMock{
+		AuthFn: func(ctx context.Context, login string) (*Auth, error) {
+			return data, nil
+		},
+		AdminAuthFn: func(ctx context.Context, login string) (*Auth, error) {
+			return data, nil
+		},
+		AuthByUserIDFn: func(ctx context.Context, uid int) (*Auth, error) {
+			return data, nil
+		},
+		MFAFn: func(ctx context.Context, authID int) (*MFA, error) {
+			if mfa.Secret == "" {
+				return nil, errors.WithStack(sql.ErrNoRows)
+			}
+
+			m := *mfa
+
+			return &m, nil
+		},
+		CreateMFAFn: func(ctx context.Context, authID int, secret string) error {
+			*mfa = MFA{AuthID: authID, Secret: secret}
+			return nil
+		},
+		EnableMFAFn: func(ctx context.Context, authID int, step int64, codeHashes []string) error {
+			mfa.Enabled, mfa.LastUsedStep, mfa.Attempts = true, step, 0
+
+			for _, h := range codeHashes {
+				recoveryCodes[h] = false
+			}
+
+			return nil
+		},
+		UseMFAStepFn: func(ctx context.Context, authID int, step int64) error {
+			if step <= mfa.LastUsedStep {
+				return errors.WithStack(sql.ErrNoRows)
+			}
+
+			mfa.LastUsedStep, mfa.Attempts = step, 0
+
+			return nil
+		},
+		IncrMFAAttemptsFn: func(ctx context.Context, authID int, maxAttempts int) (bool, error) {
+			if mfa.Attempts >= maxAttempts {
+				return false, nil
+			}
+
+			mfa.Attempts++
+
+			return true, nil
+		},
+		UseRecoveryCodeFn: func(ctx context.Context, authID int, codeHash string) error {
+			if used, ok := recoveryCodes[codeHash]; !ok || used {
+				return errors.WithStack(sql.ErrNoRows)
+			}
+
+			recoveryCodes[codeHash], mfa.Attempts = true, 0
+
+			return nil
+		},
+		DeleteMFAFn: func(ctx context.Context, authID int) error {
+			*mfa = MFA{}
+			return nil
+		},
+		CreateSessionFn: func(ctx context.Context, session Session) error {
+			return nil
+		},
+	}
+}
+
+func TestServiceMFA(t *testing.T) {
+	mfa := &MFA{}
+	recoveryCodes := make(map[string]bool)
+
+	config := testConfig
+	config.MFAIssuer = "MicroBlog"
+
+	s := NewService(
+		tracerMock,
+		newMFARepository(t, mfa, recoveryCodes),
+		manager.Mock{},
+		generatorMock,
+		catalogMock,
+		revocation.Mock{},
+		apitoken.Mock{},
+		throttleNoop,
+		nil,
+		config,
+	)
+	ctx := context.WithValue(context.Background(), jwtmiddleware.ContextKeyUserID, 3)
+
+	if _, err := s.EnrollMFA(ctx, MFAEnrollRequest{Password: "invalid"}); err == nil {
+		t.Fatalf("got: nil, want: error about incorrect password")
+	}
+
+	enrollment, err := s.EnrollMFA(ctx, MFAEnrollRequest{Password: "password"})
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	if enrollment.URI != totp.URI("MicroBlog", "john", enrollment.Secret) {
+		t.Fatalf("got: %s, want: otpauth URI of the secret", enrollment.URI)
+	}
+
+	// MFA is not enabled until the first valid code, so login doesn't require it yet
+	response, err := s.Login(context.Background(), LoginRequest{Login: "john", Password: "password"})
+	if err != nil || response.AccessToken == "" || response.MFARequired {
+		t.Fatalf("got: %v/%v, want: access token", response, err)
+	}
+
+	if _, err = s.EnableMFA(ctx, MFACodeRequest{Code: "000000"}); err == nil {
+		t.Fatalf("got: nil, want: error about invalid code")
+	}
+
+	// the previous period is used, so the current code is not used yet
+	code, _ := totp.Code(enrollment.Secret, time.Now().Add(-totp.Period))
+
+	codes, err := s.EnableMFA(ctx, MFACodeRequest{Code: code})
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	if len(codes.RecoveryCodes) != recoveryCodesCount || len(recoveryCodes) != recoveryCodesCount {
+		t.Fatalf("got: %d/%d, want: %d recovery codes", len(codes.RecoveryCodes), len(recoveryCodes), recoveryCodesCount)
+	}
+
+	response, err = s.Login(context.Background(), LoginRequest{Login: "john", Password: "password"})
+	if err != nil || response.AccessToken != "" || !response.MFARequired || response.MFAToken == "" {
+		t.Fatalf("got: %v/%v, want: mfa token only", response, err)
+	}
+
+	// MFA token can't be used as access token
+	rr := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", response.MFAToken)
+
+	jwtmiddleware.Wrap(
+		func(w http.ResponseWriter, r *http.Request) {
+			t.Fatalf("got: request is authorized, want: mfa token is rejected")
+		},
+		api.NewResponseBuilder(tracerMock),
+		logger.NewNoop(),
+		[]byte(testConfig.Secret),
+	)(rr, r)
+
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("got: %d, want: %d", rr.Code, http.StatusForbidden)
+	}
+
+	// token of user's login can't be exchanged to token of admin panel
+	if _, err = s.AdminVerifyMFA(ctx, MFAVerifyRequest{MFAToken: response.MFAToken, Code: code}); err == nil {
+		t.Fatalf("got: nil, want: error about invalid mfa token")
+	}
+
+	// the code is already used for enabling
+	_, err = s.VerifyMFA(context.Background(), MFAVerifyRequest{MFAToken: response.MFAToken, Code: code})
+	if err == nil || err.Error() != "mfa code is already used" {
+		t.Fatalf("got: %v, want: mfa code is already used", err)
+	}
+
+	code, _ = totp.Code(enrollment.Secret, time.Now())
+
+	verified, err := s.VerifyMFA(context.Background(), MFAVerifyRequest{MFAToken: response.MFAToken, Code: code})
+	if err != nil || verified.AccessToken == "" {
+		t.Fatalf("got: %v/%v, want: access token", verified, err)
+	}
+
+	// TOTP is locked after too many invalid codes, but recovery code still works only once
+	for i := 0; i < mfaMaxAttempts; i++ {
+		_, _ = s.VerifyMFA(context.Background(), MFAVerifyRequest{MFAToken: response.MFAToken, Code: "000000"})
+	}
+
+	_, err = s.VerifyMFA(context.Background(), MFAVerifyRequest{MFAToken: response.MFAToken, Code: code})
+	if err == nil || err.Error() != "too many invalid codes. please, use a recovery code" {
+		t.Fatalf("got: %v, want: too many invalid codes", err)
+	}
+
+	// recovery codes are accepted in upper case and without dash
+	recoveryCode := strings.ToUpper(strings.ReplaceAll(codes.RecoveryCodes[0], "-", ""))
+	request := MFAVerifyRequest{MFAToken: response.MFAToken, RecoveryCode: recoveryCode}
+
+	if _, err = s.VerifyMFA(context.Background(), request); err != nil || mfa.Attempts != 0 {
+		t.Fatalf("got: %v/%d, want: nil/0", err, mfa.Attempts)
+	}
+
+	if _, err = s.VerifyMFA(context.Background(), request); err == nil {
+		t.Fatalf("got: nil, want: error about used recovery code")
+	}
+
+	request = MFAVerifyRequest{MFAToken: response.MFAToken, RecoveryCode: codes.RecoveryCodes[1]}
+	disable := MFADisableRequest{Password: "password", RecoveryCode: request.RecoveryCode}
+
+	if _, err = s.DisableMFA(ctx, disable); err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	// MFA token issued before MFA is disabled is not accepted anymore
+	if _, err = s.VerifyMFA(context.Background(), request); err == nil {
+		t.Fatalf("got: nil, want: error about invalid mfa token")
+	}
+}
+
+func TestServiceVerifyMFAThrottle(t *testing.T) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	mfa := &MFA{AuthID: 7, Secret: secret, Enabled: true}
+
+	failures := 0
+	th := throttleMock{
+		AttemptFn: func(ctx context.Context, scope string, login string, ip string) error {
+			if scope == throttleScopeMFA && failures >= 2 {
+				return api.NewTooManyRequestsError(errTooManyAttempts, time.Minute)
+			}
+
+			return nil
+		},
+		FailFn: func(ctx context.Context, scope string, login string, ip string) (time.Duration, error) {
+			if scope != throttleScopeMFA || login != "john" || ip != "10.0.0.1" {
+				t.Fatalf("got: %s/%s/%s, want: mfa/john/10.0.0.1", scope, login, ip)
+			}
+
+			failures++
+
+			return 0, nil
+		},
+		SucceedFn: func(ctx context.Context, scope string, login string) error {
+			return nil
+		},
+	}
+
+	s := NewService(
+		tracerMock,
+		newMFARepository(t, mfa, make(map[string]bool)),
+		manager.Mock{},
+		generatorMock,
+		catalogMock,
+		revocation.Mock{},
+		apitoken.Mock{},
+		th,
+		nil,
+		testConfig,
+	)
+
+	response, err := s.Login(context.Background(), LoginRequest{Login: "john", Password: "password"})
+	if err != nil || response.MFAToken == "" {
+		t.Fatalf("got: %v/%v, want: mfa token", response, err)
+	}
+
+	request := MFAVerifyRequest{MFAToken: response.MFAToken, Code: "000000", IP: "10.0.0.1"}
+
+	for i := 0; i < 2; i++ {
+		if _, err = s.VerifyMFA(context.Background(), request); !errors.Is(err, errMFACodeInvalid) {
+			t.Fatalf("got: %v, want: %s", err, errMFACodeInvalid)
+		}
+	}
+
+	// valid code isn't checked while verification is throttled
+	request.Code, _ = totp.Code(secret, time.Now())
+
+	_, err = s.VerifyMFA(context.Background(), request)
+	if status, _ := api.NewErrorResponse("", err); status != http.StatusTooManyRequests {
+		t.Fatalf("got: %d, want: %d", status, http.StatusTooManyRequests)
+	}
+}
+
+func TestServiceAdminLoginMFARequired(t *testing.T) {
+	config := testConfig
+	config.AdminMFARequired = true
+
+	rMock := newMFARepository(t, &MFA{}, make(map[string]bool))
+	rs := revocation.Mock{}
+	s := NewService(
+		tracerMock,
+		rMock,
+		manager.Mock{},
+		generatorMock,
+		catalogMock,
+		rs,
+		apitoken.Mock{},
+		throttleNoop,
+		nil,
+		config,
+	)
+
+	_, err := s.AdminLogin(context.Background(), AdminLoginRequest{Login: "john", Password: "password"})
+
+	status, response := api.NewErrorResponse("req1", err)
+	if status != http.StatusForbidden || response.Code != CodeMFAEnrollmentRequired {
+		t.Fatalf("got: %d/%s, want: %d/%s", status, response.Code, http.StatusForbidden, CodeMFAEnrollmentRequired)
+	}
+}
@@ -0,0 +1,460 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/slirx/newproj/pkg/apitoken"
+	"gitlab.com/slirx/newproj/pkg/queue"
+	"gitlab.com/slirx/newproj/pkg/rbac"
+)
+
+var _ Service = (*serviceMock)(nil)
+var _ Repository = (*repositoryMock)(nil)
+var _ Throttle = (*throttleMock)(nil)
+
+type serviceMock struct {
+	LoginFn                   func(ctx context.Context, request LoginRequest) (*LoginResponse, error)
+	InternalLoginFn           func(ctx context.Context, request InternalLoginRequest) (*InternalLoginResponse, error)
+	AdminLoginFn              func(ctx context.Context, request AdminLoginRequest) (*AdminLoginResponse, error)
+	SetRoleFn                 func(ctx context.Context, request SetRoleRequest) (string, error)
+	ForgotPasswordFn          func(ctx context.Context, request ForgotPasswordRequest) (string, error)
+	ResetPasswordFn           func(ctx context.Context, request ResetPasswordRequest) (string, error)
+	ChangePasswordFn          func(ctx context.Context, request ChangePasswordRequest) (*ChangePasswordResponse, error)
+	ChangeEmailFn             func(ctx context.Context, request ChangeEmailRequest) (string, error)
+	ConfirmEmailChangeFn      func(ctx context.Context, request ConfirmEmailChangeRequest) (string, error)
+	InternalAvailabilityFn    func(ctx context.Context, request AvailabilityRequest) (*AvailabilityResponse, error)
+	EnrollMFAFn               func(ctx context.Context, request MFAEnrollRequest) (*MFAEnrollResponse, error)
+	EnableMFAFn               func(ctx context.Context, request MFACodeRequest) (*RecoveryCodesResponse, error)
+	DisableMFAFn              func(ctx context.Context, request MFADisableRequest) (string, error)
+	RegenerateRecoveryCodesFn func(ctx context.Context, request MFACodeRequest) (*RecoveryCodesResponse, error)
+	VerifyMFAFn               func(ctx context.Context, request MFAVerifyRequest) (*LoginResponse, error)
+	AdminVerifyMFAFn          func(ctx context.Context, request MFAVerifyRequest) (*AdminLoginResponse, error)
+	SessionsFn                func(ctx context.Context) (*SessionsResponse, error)
+	RevokeSessionFn           func(ctx context.Context, request RevokeSessionRequest) (string, error)
+	UserSessionsFn            func(ctx context.Context, request UserSessionsRequest) (*SessionsResponse, error)
+	RevokeUserSessionsFn      func(ctx context.Context, request UserSessionsRequest) (string, error)
+	CreateAPITokenFn          func(ctx context.Context, request CreateAPITokenRequest) (*CreateAPITokenResponse, error)
+	APITokensFn               func(ctx context.Context) (*APITokensResponse, error)
+	RevokeAPITokenFn          func(ctx context.Context, request RevokeAPITokenRequest) (string, error)
+	InternalAPITokenFn        func(ctx context.Context, request InternalAPITokenRequest) (*apitoken.Token, error)
+	RegisterClientFn          func(ctx context.Context, request RegisterClientRequest) (*RegisterClientResponse, error)
+	AuthorizeFn               func(ctx context.Context, request AuthorizeRequest) (*AuthorizeResponse, error)
+	ConsentFn                 func(ctx context.Context, request ConsentRequest) (*ConsentResponse, error)
+	ConsentsFn                func(ctx context.Context) (*ConsentsResponse, error)
+	RevokeConsentFn           func(ctx context.Context, request RevokeConsentRequest) (string, error)
+	TokenFn                   func(ctx context.Context, request TokenRequest) (*TokenResponse, error)
+	IntrospectFn              func(ctx context.Context, request IntrospectRequest) (*IntrospectResponse, error)
+	OIDCLoginFn               func(ctx context.Context) (*OIDCLoginResponse, error)
+	OIDCCallbackFn            func(ctx context.Context, request OIDCCallbackRequest) (*LoginResponse, error)
+}
+
+func (s serviceMock) SetRole(ctx context.Context, request SetRoleRequest) (string, error) {
+	return s.SetRoleFn(ctx, request)
+}
+
+func (s serviceMock) Sessions(ctx context.Context) (*SessionsResponse, error) {
+	return s.SessionsFn(ctx)
+}
+
+func (s serviceMock) RevokeSession(ctx context.Context, request RevokeSessionRequest) (string, error) {
+	return s.RevokeSessionFn(ctx, request)
+}
+
+func (s serviceMock) UserSessions(ctx context.Context, request UserSessionsRequest) (*SessionsResponse, error) {
+	return s.UserSessionsFn(ctx, request)
+}
+
+func (s serviceMock) RevokeUserSessions(ctx context.Context, request UserSessionsRequest) (string, error) {
+	return s.RevokeUserSessionsFn(ctx, request)
+}
+
+func (s serviceMock) CreateAPIToken(ctx context.Context, request CreateAPITokenRequest) (*CreateAPITokenResponse, error) {
+	return s.CreateAPITokenFn(ctx, request)
+}
+
+func (s serviceMock) APITokens(ctx context.Context) (*APITokensResponse, error) {
+	return s.APITokensFn(ctx)
+}
+
+func (s serviceMock) RevokeAPIToken(ctx context.Context, request RevokeAPITokenRequest) (string, error) {
+	return s.RevokeAPITokenFn(ctx, request)
+}
+
+func (s serviceMock) InternalAPIToken(ctx context.Context, request InternalAPITokenRequest) (*apitoken.Token, error) {
+	return s.InternalAPITokenFn(ctx, request)
+}
+
+func (s serviceMock) RegisterClient(ctx context.Context, request RegisterClientRequest) (*RegisterClientResponse, error) {
+	return s.RegisterClientFn(ctx, request)
+}
+
+func (s serviceMock) Authorize(ctx context.Context, request AuthorizeRequest) (*AuthorizeResponse, error) {
+	return s.AuthorizeFn(ctx, request)
+}
+
+func (s serviceMock) Consent(ctx context.Context, request ConsentRequest) (*ConsentResponse, error) {
+	return s.ConsentFn(ctx, request)
+}
+
+func (s serviceMock) Consents(ctx context.Context) (*ConsentsResponse, error) {
+	return s.ConsentsFn(ctx)
+}
+
+func (s serviceMock) RevokeConsent(ctx context.Context, request RevokeConsentRequest) (string, error) {
+	return s.RevokeConsentFn(ctx, request)
+}
+
+func (s serviceMock) Token(ctx context.Context, request TokenRequest) (*TokenResponse, error) {
+	return s.TokenFn(ctx, request)
+}
+
+func (s serviceMock) Introspect(ctx context.Context, request IntrospectRequest) (*IntrospectResponse, error) {
+	return s.IntrospectFn(ctx, request)
+}
+
+func (s serviceMock) OIDCLogin(ctx context.Context) (*OIDCLoginResponse, error) {
+	return s.OIDCLoginFn(ctx)
+}
+
+func (s serviceMock) OIDCCallback(ctx context.Context, request OIDCCallbackRequest) (*LoginResponse, error) {
+	return s.OIDCCallbackFn(ctx, request)
+}
+
+type repositoryMock struct {
+	CreateFn                     func(ctx context.Context, request queue.AuthCreate) error
+	UpdateUserIDFn               func(ctx context.Context, login string, id int) error
+	AuthFn                       func(ctx context.Context, login string) (*Auth, error)
+	InternalAuthFn               func(ctx context.Context, serviceName string) (*InternalAuth, error)
+	AdminAuthFn                  func(ctx context.Context, login string) (*Auth, error)
+	AuthByEmailFn                func(ctx context.Context, email string) (*Auth, error)
+	CreatePasswordResetFn        func(ctx context.Context, authID int, tokenHash string, expiresAt time.Time) error
+	ResetPasswordFn              func(ctx context.Context, tokenHash string, passwordHash string) (*Auth, error)
+	AuthByUserIDFn               func(ctx context.Context, uid int) (*Auth, error)
+	UpdatePasswordFn             func(ctx context.Context, authID int, passwordHash string) error
+	UpdateRoleFn                 func(ctx context.Context, authID int, role rbac.Role) error
+	CreateEmailChangeFn          func(ctx context.Context, authID int, email string, codeHash string, expiresAt time.Time) error
+	EmailChangeFn                func(ctx context.Context, authID int) (*EmailChange, error)
+	IncrEmailChangeAttemptsFn    func(ctx context.Context, id int, maxAttempts int) (bool, error)
+	ConfirmEmailChangeFn         func(ctx context.Context, change EmailChange, sync func() error) error
+	AvailabilityFn               func(ctx context.Context, request AvailabilityRequest) (*AvailabilityResponse, error)
+	MFAFn                        func(ctx context.Context, authID int) (*MFA, error)
+	CreateMFAFn                  func(ctx context.Context, authID int, secret string) error
+	EnableMFAFn                  func(ctx context.Context, authID int, step int64, codeHashes []string) error
+	UseMFAStepFn                 func(ctx context.Context, authID int, step int64) error
+	IncrMFAAttemptsFn            func(ctx context.Context, authID int, maxAttempts int) (bool, error)
+	UseRecoveryCodeFn            func(ctx context.Context, authID int, codeHash string) error
+	ReplaceRecoveryCodesFn       func(ctx context.Context, authID int, codeHashes []string) error
+	DeleteMFAFn                  func(ctx context.Context, authID int) error
+	CreateSessionFn              func(ctx context.Context, session Session) error
+	SessionsFn                   func(ctx context.Context, uid int) ([]Session, error)
+	RevokeSessionFn              func(ctx context.Context, uid int, id string) error
+	RevokeSessionsFn             func(ctx context.Context, uid int) error
+	CreateAPITokenFn             func(ctx context.Context, token APIToken) (int, error)
+	APITokensFn                  func(ctx context.Context, uid int) ([]APIToken, error)
+	APITokenByHashFn             func(ctx context.Context, hash string) (*APIToken, error)
+	DeleteAPITokenFn             func(ctx context.Context, uid int, id int) error
+	DeleteAPITokensFn            func(ctx context.Context, uid int) error
+	CreateClientFn               func(ctx context.Context, client OAuthClient) error
+	ClientFn                     func(ctx context.Context, clientID string) (*OAuthClient, error)
+	ConsentFn                    func(ctx context.Context, uid int, clientID string) (*Consent, error)
+	SaveConsentFn                func(ctx context.Context, uid int, clientID string, scopes []string) error
+	ConsentsFn                   func(ctx context.Context, uid int) ([]Consent, error)
+	DeleteConsentFn              func(ctx context.Context, uid int, clientID string) error
+	CreateOAuthCodeFn            func(ctx context.Context, codeHash string, code OAuthCode) error
+	UseOAuthCodeFn               func(ctx context.Context, codeHash string) (*OAuthCode, error)
+	CreateOIDCLoginFn            func(ctx context.Context, stateHash string, login OIDCLogin) error
+	UseOIDCLoginFn               func(ctx context.Context, stateHash string) (*OIDCLogin, error)
+	ExternalAuthFn               func(ctx context.Context, issuer string, subject string) (*Auth, error)
+	CreateExternalIdentityFn     func(ctx context.Context, authID int, issuer string, subject string) error
+	CreateExternalRegistrationFn func(ctx context.Context, issuer string, subject string, expiredBefore time.Time) error
+	DeleteExternalRegistrationFn func(ctx context.Context, issuer string, subject string) error
+}
+
+func (s serviceMock) Login(ctx context.Context, request LoginRequest) (*LoginResponse, error) {
+	return s.LoginFn(ctx, request)
+}
+
+func (s serviceMock) InternalLogin(ctx context.Context, request InternalLoginRequest) (*InternalLoginResponse, error) {
+	return s.InternalLoginFn(ctx, request)
+}
+
+func (s serviceMock) AdminLogin(ctx context.Context, request AdminLoginRequest) (*AdminLoginResponse, error) {
+	return s.AdminLoginFn(ctx, request)
+}
+
+func (s serviceMock) ForgotPassword(ctx context.Context, request ForgotPasswordRequest) (string, error) {
+	return s.ForgotPasswordFn(ctx, request)
+}
+
+func (s serviceMock) ResetPassword(ctx context.Context, request ResetPasswordRequest) (string, error) {
+	return s.ResetPasswordFn(ctx, request)
+}
+
+func (s serviceMock) ChangePassword(ctx context.Context, request ChangePasswordRequest) (*ChangePasswordResponse, error) {
+	return s.ChangePasswordFn(ctx, request)
+}
+
+func (s serviceMock) ChangeEmail(ctx context.Context, request ChangeEmailRequest) (string, error) {
+	return s.ChangeEmailFn(ctx, request)
+}
+
+func (s serviceMock) ConfirmEmailChange(ctx context.Context, request ConfirmEmailChangeRequest) (string, error) {
+	return s.ConfirmEmailChangeFn(ctx, request)
+}
+
+func (s serviceMock) InternalAvailability(
+	ctx context.Context,
+	request AvailabilityRequest,
+) (*AvailabilityResponse, error) {
+	return s.InternalAvailabilityFn(ctx, request)
+}
+
+func (s serviceMock) EnrollMFA(ctx context.Context, request MFAEnrollRequest) (*MFAEnrollResponse, error) {
+	return s.EnrollMFAFn(ctx, request)
+}
+
+func (s serviceMock) EnableMFA(ctx context.Context, request MFACodeRequest) (*RecoveryCodesResponse, error) {
+	return s.EnableMFAFn(ctx, request)
+}
+
+func (s serviceMock) DisableMFA(ctx context.Context, request MFADisableRequest) (string, error) {
+	return s.DisableMFAFn(ctx, request)
+}
+
+func (s serviceMock) RegenerateRecoveryCodes(
+	ctx context.Context,
+	request MFACodeRequest,
+) (*RecoveryCodesResponse, error) {
+	return s.RegenerateRecoveryCodesFn(ctx, request)
+}
+
+func (s serviceMock) VerifyMFA(ctx context.Context, request MFAVerifyRequest) (*LoginResponse, error) {
+	return s.VerifyMFAFn(ctx, request)
+}
+
+func (s serviceMock) AdminVerifyMFA(ctx context.Context, request MFAVerifyRequest) (*AdminLoginResponse, error) {
+	return s.AdminVerifyMFAFn(ctx, request)
+}
+
+func (r repositoryMock) Create(ctx context.Context, request queue.AuthCreate) error {
+	return r.CreateFn(ctx, request)
+}
+
+func (r repositoryMock) UpdateUserID(ctx context.Context, login string, id int) error {
+	return r.UpdateUserIDFn(ctx, login, id)
+}
+
+func (r repositoryMock) Auth(ctx context.Context, login string) (*Auth, error) {
+	return r.AuthFn(ctx, login)
+}
+
+func (r repositoryMock) InternalAuth(ctx context.Context, serviceName string) (*InternalAuth, error) {
+	return r.InternalAuthFn(ctx, serviceName)
+}
+
+func (r repositoryMock) AdminAuth(ctx context.Context, login string) (*Auth, error) {
+	return r.AdminAuthFn(ctx, login)
+}
+
+func (r repositoryMock) AuthByEmail(ctx context.Context, email string) (*Auth, error) {
+	return r.AuthByEmailFn(ctx, email)
+}
+
+func (r repositoryMock) CreatePasswordReset(ctx context.Context, authID int, tokenHash string, expiresAt time.Time) error {
+	return r.CreatePasswordResetFn(ctx, authID, tokenHash, expiresAt)
+}
+
+func (r repositoryMock) ResetPassword(ctx context.Context, tokenHash string, passwordHash string) (*Auth, error) {
+	return r.ResetPasswordFn(ctx, tokenHash, passwordHash)
+}
+
+func (r repositoryMock) AuthByUserID(ctx context.Context, uid int) (*Auth, error) {
+	return r.AuthByUserIDFn(ctx, uid)
+}
+
+func (r repositoryMock) UpdatePassword(ctx context.Context, authID int, passwordHash string) error {
+	return r.UpdatePasswordFn(ctx, authID, passwordHash)
+}
+
+func (r repositoryMock) UpdateRole(ctx context.Context, authID int, role rbac.Role) error {
+	return r.UpdateRoleFn(ctx, authID, role)
+}
+
+func (r repositoryMock) CreateEmailChange(
+	ctx context.Context,
+	authID int,
+	email string,
+	codeHash string,
+	expiresAt time.Time,
+) error {
+	return r.CreateEmailChangeFn(ctx, authID, email, codeHash, expiresAt)
+}
+
+func (r repositoryMock) EmailChange(ctx context.Context, authID int) (*EmailChange, error) {
+	return r.EmailChangeFn(ctx, authID)
+}
+
+func (r repositoryMock) IncrEmailChangeAttempts(ctx context.Context, id int, maxAttempts int) (bool, error) {
+	return r.IncrEmailChangeAttemptsFn(ctx, id, maxAttempts)
+}
+
+func (r repositoryMock) ConfirmEmailChange(ctx context.Context, change EmailChange, sync func() error) error {
+	return r.ConfirmEmailChangeFn(ctx, change, sync)
+}
+
+func (r repositoryMock) Availability(ctx context.Context, request AvailabilityRequest) (*AvailabilityResponse, error) {
+	return r.AvailabilityFn(ctx, request)
+}
+
+func (r repositoryMock) MFA(ctx context.Context, authID int) (*MFA, error) {
+	return r.MFAFn(ctx, authID)
+}
+
+func (r repositoryMock) CreateMFA(ctx context.Context, authID int, secret string) error {
+	return r.CreateMFAFn(ctx, authID, secret)
+}
+
+func (r repositoryMock) EnableMFA(ctx context.Context, authID int, step int64, codeHashes []string) error {
+	return r.EnableMFAFn(ctx, authID, step, codeHashes)
+}
+
+func (r repositoryMock) UseMFAStep(ctx context.Context, authID int, step int64) error {
+	return r.UseMFAStepFn(ctx, authID, step)
+}
+
+func (r repositoryMock) IncrMFAAttempts(ctx context.Context, authID int, maxAttempts int) (bool, error) {
+	return r.IncrMFAAttemptsFn(ctx, authID, maxAttempts)
+}
+
+func (r repositoryMock) UseRecoveryCode(ctx context.Context, authID int, codeHash string) error {
+	return r.UseRecoveryCodeFn(ctx, authID, codeHash)
+}
+
+func (r repositoryMock) ReplaceRecoveryCodes(ctx context.Context, authID int, codeHashes []string) error {
+	return r.ReplaceRecoveryCodesFn(ctx, authID, codeHashes)
+}
+
+func (r repositoryMock) DeleteMFA(ctx context.Context, authID int) error {
+	return r.DeleteMFAFn(ctx, authID)
+}
+
+func (r repositoryMock) CreateSession(ctx context.Context, session Session) error {
+	return r.CreateSessionFn(ctx, session)
+}
+
+func (r repositoryMock) Sessions(ctx context.Context, uid int) ([]Session, error) {
+	return r.SessionsFn(ctx, uid)
+}
+
+func (r repositoryMock) RevokeSession(ctx context.Context, uid int, id string) error {
+	return r.RevokeSessionFn(ctx, uid, id)
+}
+
+func (r repositoryMock) RevokeSessions(ctx context.Context, uid int) error {
+	return r.RevokeSessionsFn(ctx, uid)
+}
+
+func (r repositoryMock) CreateAPIToken(ctx context.Context, token APIToken) (int, error) {
+	return r.CreateAPITokenFn(ctx, token)
+}
+
+func (r repositoryMock) APITokens(ctx context.Context, uid int) ([]APIToken, error) {
+	return r.APITokensFn(ctx, uid)
+}
+
+func (r repositoryMock) APITokenByHash(ctx context.Context, hash string) (*APIToken, error) {
+	return r.APITokenByHashFn(ctx, hash)
+}
+
+func (r repositoryMock) DeleteAPIToken(ctx context.Context, uid int, id int) error {
+	return r.DeleteAPITokenFn(ctx, uid, id)
+}
+
+func (r repositoryMock) DeleteAPITokens(ctx context.Context, uid int) error {
+	return r.DeleteAPITokensFn(ctx, uid)
+}
+
+func (r repositoryMock) CreateClient(ctx context.Context, client OAuthClient) error {
+	return r.CreateClientFn(ctx, client)
+}
+
+func (r repositoryMock) Client(ctx context.Context, clientID string) (*OAuthClient, error) {
+	return r.ClientFn(ctx, clientID)
+}
+
+func (r repositoryMock) Consent(ctx context.Context, uid int, clientID string) (*Consent, error) {
+	return r.ConsentFn(ctx, uid, clientID)
+}
+
+func (r repositoryMock) SaveConsent(ctx context.Context, uid int, clientID string, scopes []string) error {
+	return r.SaveConsentFn(ctx, uid, clientID, scopes)
+}
+
+func (r repositoryMock) Consents(ctx context.Context, uid int) ([]Consent, error) {
+	return r.ConsentsFn(ctx, uid)
+}
+
+func (r repositoryMock) DeleteConsent(ctx context.Context, uid int, clientID string) error {
+	return r.DeleteConsentFn(ctx, uid, clientID)
+}
+
+func (r repositoryMock) CreateOAuthCode(ctx context.Context, codeHash string, code OAuthCode) error {
+	return r.CreateOAuthCodeFn(ctx, codeHash, code)
+}
+
+func (r repositoryMock) UseOAuthCode(ctx context.Context, codeHash string) (*OAuthCode, error) {
+	return r.UseOAuthCodeFn(ctx, codeHash)
+}
+
+func (r repositoryMock) CreateOIDCLogin(ctx context.Context, stateHash string, login OIDCLogin) error {
+	return r.CreateOIDCLoginFn(ctx, stateHash, login)
+}
+
+func (r repositoryMock) UseOIDCLogin(ctx context.Context, stateHash string) (*OIDCLogin, error) {
+	return r.UseOIDCLoginFn(ctx, stateHash)
+}
+
+func (r repositoryMock) ExternalAuth(ctx context.Context, issuer string, subject string) (*Auth, error) {
+	return r.ExternalAuthFn(ctx, issuer, subject)
+}
+
+func (r repositoryMock) CreateExternalIdentity(ctx context.Context, authID int, issuer string, subject string) error {
+	return r.CreateExternalIdentityFn(ctx, authID, issuer, subject)
+}
+
+func (r repositoryMock) CreateExternalRegistration(
+	ctx context.Context,
+	issuer string,
+	subject string,
+	expiredBefore time.Time,
+) error {
+	return r.CreateExternalRegistrationFn(ctx, issuer, subject, expiredBefore)
+}
+
+func (r repositoryMock) DeleteExternalRegistration(ctx context.Context, issuer string, subject string) error {
+	return r.DeleteExternalRegistrationFn(ctx, issuer, subject)
+}
+
+type throttleMock struct {
+	AttemptFn func(ctx context.Context, scope string, login string, ip string) error
+	FailFn    func(ctx context.Context, scope string, login string, ip string) (time.Duration, error)
+	SucceedFn func(ctx context.Context, scope string, login string) error
+	LimitFn   func(ctx context.Context, scope string, id string, limit int, window time.Duration) error
+}
+
+func (t throttleMock) Attempt(ctx context.Context, scope string, login string, ip string) error {
+	return t.AttemptFn(ctx, scope, login, ip)
+}
+
+func (t throttleMock) Fail(ctx context.Context, scope string, login string, ip string) (time.Duration, error) {
+	return t.FailFn(ctx, scope, login, ip)
+}
+
+func (t throttleMock) Succeed(ctx context.Context, scope string, login string) error {
+	return t.SucceedFn(ctx, scope, login)
+}
+
+func (t throttleMock) Limit(ctx context.Context, scope string, id string, limit int, window time.Duration) error {
+	return t.LimitFn(ctx, scope, id, limit, window)
+}
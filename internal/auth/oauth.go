@@ -0,0 +1,573 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+
+	"gitlab.com/slirx/newproj/pkg/api"
+	"gitlab.com/slirx/newproj/pkg/http/jwtmiddleware"
+	"gitlab.com/slirx/newproj/pkg/validation"
+)
+
+const (
+	// OAuthTokenTTL is lifetime of access token issued to OAuth client.
+	OAuthTokenTTL = time.Hour
+	// oauthCodeTTL is lifetime of authorization code.
+	oauthCodeTTL = 10 * time.Minute
+	// oauthClientIDLen and oauthSecretLen are lengths of client id and secret in bytes (before encoding).
+	oauthClientIDLen = 16
+	oauthSecretLen   = 32
+	// oauthClientNameMaxLen is the maximum length of name of client.
+	oauthClientNameMaxLen = 100
+	// oauthMaxRedirectURIs is the maximum number of redirect URIs of one client.
+	oauthMaxRedirectURIs = 10
+	// pkceMethod is the only supported code challenge method. "plain" method is not secure enough.
+	pkceMethod = "S256"
+)
+
+// oauthScopes are scopes which can be granted to OAuth clients.
+var oauthScopes = map[string]bool{
+	jwtmiddleware.ScopePostWrite: true,
+	jwtmiddleware.ScopeUserRead:  true,
+}
+
+// OAuthError is error of token and introspection endpoints. Their responses follow RFC 6749 instead of
+// api.ErrorResponse, so OAuth libraries of clients can handle them.
+type OAuthError struct {
+	Status      int    `json:"-"`
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e OAuthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func errInvalidClient() error {
+	return OAuthError{Status: http.StatusUnauthorized, Code: "invalid_client", Description: "client authentication failed"}
+}
+
+func errInvalidGrant() error {
+	return OAuthError{
+		Status:      http.StatusBadRequest,
+		Code:        "invalid_grant",
+		Description: "authorization code is invalid, expired or issued to another client",
+	}
+}
+
+// Validate validates client registration request. Redirect URIs should use https, only local development
+// servers can use http.
+func (r RegisterClientRequest) Validate() error {
+	fieldErrors := make([]api.FieldError, 0)
+
+	name := strings.TrimSpace(r.Name)
+	if name == "" {
+		fieldErrors = append(fieldErrors, api.NewFieldError("name", validation.Error{
+			Code:    validation.CodeRequired,
+			Message: "name should not be empty",
+		}))
+	} else if utf8.RuneCountInString(name) > oauthClientNameMaxLen {
+		fieldErrors = append(fieldErrors, api.NewFieldError("name", validation.Error{
+			Code:    validation.CodeLength,
+			Message: fmt.Sprintf("name should not be longer than %d symbols", oauthClientNameMaxLen),
+		}))
+	}
+
+	if len(r.RedirectURIs) == 0 || len(r.RedirectURIs) > oauthMaxRedirectURIs {
+		fieldErrors = append(fieldErrors, api.NewFieldError("redirect_uris", validation.Error{
+			Code:    validation.CodeLength,
+			Message: fmt.Sprintf("number of redirect uris should be from 1 to %d", oauthMaxRedirectURIs),
+		}))
+	}
+
+	for _, redirectURI := range r.RedirectURIs {
+		if err := validateRedirectURI(redirectURI); err != nil {
+			fieldErrors = append(fieldErrors, api.NewFieldError("redirect_uris", err))
+			break
+		}
+	}
+
+	if len(fieldErrors) > 0 {
+		return api.NewValidationError(fieldErrors...)
+	}
+
+	return nil
+}
+
+// RegisterClient registers OAuth client of the current user.
+func (s service) RegisterClient(ctx context.Context, request RegisterClientRequest) (*RegisterClientResponse, error) {
+	uid, err := jwtmiddleware.UID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = request.Validate(); err != nil {
+		return nil, err
+	}
+
+	clientID, err := randomHex(oauthClientIDLen)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &RegisterClientResponse{ClientID: clientID}
+	secretHash := ""
+
+	if request.Confidential {
+		if response.ClientSecret, err = randomHex(oauthSecretLen); err != nil {
+			return nil, err
+		}
+
+		secretHash = hashResetToken(response.ClientSecret)
+	}
+
+	err = s.Repository.CreateClient(ctx, OAuthClient{
+		ClientID:     clientID,
+		SecretHash:   secretHash,
+		Name:         strings.TrimSpace(request.Name),
+		RedirectURIs: request.RedirectURIs,
+		UserID:       uid,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// Authorize validates authorization request and returns information for consent page. Invalid requests are
+// reported to the user and are not redirected to the client, because redirect URI can't be trusted in this case.
+func (s service) Authorize(ctx context.Context, request AuthorizeRequest) (*AuthorizeResponse, error) {
+	uid, err := jwtmiddleware.UID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	client, scopes, err := s.authorizeRequest(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &AuthorizeResponse{ClientName: client.Name, Scopes: scopes}
+
+	consent, err := s.Repository.Consent(ctx, uid, client.ClientID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	if consent != nil {
+		response.Consented = containsAll(consent.Scopes, scopes)
+	}
+
+	return response, nil
+}
+
+// Consent saves decision of the user about authorization request. In case access is granted, authorization code
+// is added to redirect URL, "access_denied" error is added otherwise.
+func (s service) Consent(ctx context.Context, request ConsentRequest) (*ConsentResponse, error) {
+	uid, err := jwtmiddleware.UID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	client, scopes, err := s.authorizeRequest(ctx, request.AuthorizeRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	if request.State != "" {
+		params.Set("state", request.State)
+	}
+
+	if !request.Approved {
+		params.Set("error", "access_denied")
+
+		return &ConsentResponse{RedirectURL: withQuery(request.RedirectURI, params)}, nil
+	}
+
+	if err = s.Repository.SaveConsent(ctx, uid, client.ClientID, scopes); err != nil {
+		return nil, err
+	}
+
+	code, err := newResetToken()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.Repository.CreateOAuthCode(ctx, hashResetToken(code), OAuthCode{
+		ClientID:      client.ClientID,
+		UserID:        uid,
+		RedirectURI:   request.RedirectURI,
+		Scopes:        scopes,
+		CodeChallenge: request.CodeChallenge,
+		ExpiresAt:     time.Now().Add(oauthCodeTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	params.Set("code", code)
+
+	return &ConsentResponse{RedirectURL: withQuery(request.RedirectURI, params)}, nil
+}
+
+// Consents returns applications the current user granted access to.
+func (s service) Consents(ctx context.Context) (*ConsentsResponse, error) {
+	uid, err := jwtmiddleware.UID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	consents, err := s.Repository.Consents(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &ConsentsResponse{Consents: make([]ConsentItem, 0, len(consents))}
+
+	for _, c := range consents {
+		response.Consents = append(response.Consents, ConsentItem{
+			ClientID:   c.ClientID,
+			ClientName: c.ClientName,
+			Scopes:     c.Scopes,
+			GrantedAt:  c.UpdatedAt.Unix(),
+		})
+	}
+
+	return response, nil
+}
+
+// RevokeConsent deletes consent of the current user and revokes all tokens issued to the client on their behalf.
+func (s service) RevokeConsent(ctx context.Context, request RevokeConsentRequest) (string, error) {
+	uid, err := jwtmiddleware.UID(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if err = s.Repository.DeleteConsent(ctx, uid, request.ClientID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", api.NewNotFoundError(errors.New("consent is not found"))
+		}
+
+		return "", err
+	}
+
+	sessions, err := s.Repository.Sessions(ctx, uid)
+	if err != nil {
+		return "", err
+	}
+
+	for _, session := range sessions {
+		if session.ClientID != request.ClientID {
+			continue
+		}
+
+		if err = s.Revocation.RevokeSession(ctx, session.ID); err != nil {
+			return "", err
+		}
+
+		err = s.Repository.RevokeSession(ctx, uid, session.ID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return "", err
+		}
+	}
+
+	return "access of the application has been revoked", nil
+}
+
+// Token exchanges authorization code to access token. Code verifier should match code challenge of authorization
+// request.
+func (s service) Token(ctx context.Context, request TokenRequest) (*TokenResponse, error) {
+	if request.GrantType != "authorization_code" {
+		return nil, OAuthError{
+			Status:      http.StatusBadRequest,
+			Code:        "unsupported_grant_type",
+			Description: "only authorization_code grant type is supported",
+		}
+	}
+
+	client, err := s.authenticateClient(ctx, request.ClientID, request.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	code, err := s.Repository.UseOAuthCode(ctx, hashResetToken(request.Code))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errInvalidGrant()
+		}
+
+		return nil, err
+	}
+
+	if code.ClientID != client.ClientID || code.RedirectURI != request.RedirectURI {
+		return nil, errInvalidGrant()
+	}
+
+	challenge := sha256.Sum256([]byte(request.CodeVerifier))
+	if !equal(base64.RawURLEncoding.EncodeToString(challenge[:]), code.CodeChallenge) {
+		return nil, errInvalidGrant()
+	}
+
+	// consent could be revoked after the code was used, but before tokens of the client were revoked
+	if _, err = s.Repository.Consent(ctx, code.UserID, client.ClientID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errInvalidGrant()
+		}
+
+		return nil, err
+	}
+
+	scope := strings.Join(code.Scopes, " ")
+
+	accessToken, err := s.sessionToken(
+		ctx,
+		Session{UserID: code.UserID, UserAgent: client.Name, ClientID: client.ClientID},
+		OAuthTokenTTL,
+		jwt.MapClaims{"scope": scope, "client_id": client.ClientID},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(OAuthTokenTTL.Seconds()),
+		Scope:       scope,
+	}
+
+	return response, nil
+}
+
+// Introspect returns state of access token. Tokens which are invalid, expired, revoked or issued to another
+// client are reported as inactive.
+func (s service) Introspect(ctx context.Context, request IntrospectRequest) (*IntrospectResponse, error) {
+	client, err := s.authenticateClient(ctx, request.ClientID, request.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	// public clients can't keep a secret, so they are not allowed to introspect tokens
+	if client.SecretHash == "" {
+		return nil, errInvalidClient()
+	}
+
+	inactive := &IntrospectResponse{}
+
+	token, err := jwt.Parse(request.Token, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("invalid signing method")
+		}
+
+		return []byte(s.Config.Secret), nil
+	})
+	if err != nil || !token.Valid {
+		return inactive, nil
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+
+	clientID, _ := claims["client_id"].(string)
+	scope, _ := claims["scope"].(string)
+	sessionID, _ := claims["jti"].(string)
+	uid, _ := claims["uid"].(float64)
+	iat, _ := claims["iat"].(float64)
+	exp, _ := claims["exp"].(float64)
+
+	if clientID != client.ClientID || sessionID == "" || uid <= 0 {
+		return inactive, nil
+	}
+
+	revoked, err := s.Revocation.IsRevoked(ctx, int(uid), time.Unix(int64(iat), 0))
+	if err != nil {
+		return nil, err
+	}
+
+	if !revoked {
+		if revoked, err = s.Revocation.CheckSession(ctx, sessionID, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	if revoked {
+		return inactive, nil
+	}
+
+	response := &IntrospectResponse{
+		Active:    true,
+		Scope:     scope,
+		ClientID:  clientID,
+		UserID:    int(uid),
+		TokenType: "Bearer",
+		ExpiresAt: int64(exp),
+		IssuedAt:  int64(iat),
+	}
+
+	return response, nil
+}
+
+// authorizeRequest validates authorization request and returns its client and requested scopes.
+func (s service) authorizeRequest(ctx context.Context, request AuthorizeRequest) (*OAuthClient, []string, error) {
+	client, err := s.Repository.Client(ctx, request.ClientID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, api.NewRequestError(errors.New("client is not found"))
+		}
+
+		return nil, nil, err
+	}
+
+	if !contains(client.RedirectURIs, request.RedirectURI) {
+		return nil, nil, api.NewRequestError(errors.New("redirect uri is not registered for the client"))
+	}
+
+	if request.ResponseType != "code" {
+		return nil, nil, api.NewRequestError(errors.New("only code response type is supported"))
+	}
+
+	if request.CodeChallengeMethod != pkceMethod || !validCodeChallenge(request.CodeChallenge) {
+		return nil, nil, api.NewRequestError(errors.New("code challenge of S256 method is required"))
+	}
+
+	scopes, err := parseScopes(request.Scope)
+	if err != nil {
+		return nil, nil, api.NewRequestError(err)
+	}
+
+	return client, scopes, nil
+}
+
+// authenticateClient returns client in case secret is correct. Public clients have no secret, so they are
+// identified by client id only.
+func (s service) authenticateClient(ctx context.Context, clientID string, secret string) (*OAuthClient, error) {
+	if clientID == "" {
+		return nil, errInvalidClient()
+	}
+
+	client, err := s.Repository.Client(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errInvalidClient()
+		}
+
+		return nil, err
+	}
+
+	if client.SecretHash != "" && !equal(hashResetToken(secret), client.SecretHash) {
+		return nil, errInvalidClient()
+	}
+
+	return client, nil
+}
+
+// parseScopes returns unique scopes of space-separated list. All of them should be supported.
+func parseScopes(scope string) ([]string, error) {
+	scopes := make([]string, 0)
+
+	for _, s := range strings.Fields(scope) {
+		if !oauthScopes[s] {
+			return nil, fmt.Errorf("scope %q is not supported", s)
+		}
+
+		if !contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+
+	if len(scopes) == 0 {
+		return nil, errors.New("scope should not be empty")
+	}
+
+	return scopes, nil
+}
+
+// validateRedirectURI checks that redirect URI is absolute URL without fragment (RFC 6749 section 3.1.2).
+func validateRedirectURI(redirectURI string) error {
+	u, err := url.Parse(redirectURI)
+	if err != nil || u.Host == "" || u.Fragment != "" {
+		return validation.Error{Code: validation.CodeFormat, Message: "redirect uri should be absolute url"}
+	}
+
+	host := u.Hostname()
+
+	if u.Scheme != "https" && !(u.Scheme == "http" && (host == "localhost" || host == "127.0.0.1")) {
+		return validation.Error{Code: validation.CodeFormat, Message: "redirect uri should use https"}
+	}
+
+	return nil
+}
+
+// validCodeChallenge reports whether challenge is base64url encoded SHA-256 hash.
+func validCodeChallenge(challenge string) bool {
+	b, err := base64.RawURLEncoding.DecodeString(challenge)
+
+	return err == nil && len(b) == sha256.Size
+}
+
+// withQuery adds params to query of rawURL. rawURL is one of registered redirect URIs, so it's valid.
+func withQuery(rawURL string, params url.Values) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	query := u.Query()
+	for key := range params {
+		query.Set(key, params.Get(key))
+	}
+
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsAll(list []string, required []string) bool {
+	for _, s := range required {
+		if !contains(list, s) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// equal compares strings in constant time.
+func equal(a string, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// randomHex returns n random bytes encoded to hex.
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
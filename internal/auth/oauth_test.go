@@ -0,0 +1,335 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gitlab.com/slirx/newproj/pkg/api"
+	"gitlab.com/slirx/newproj/pkg/apitoken"
+	"gitlab.com/slirx/newproj/pkg/http/jwtmiddleware"
+	"gitlab.com/slirx/newproj/pkg/queue/manager"
+	"gitlab.com/slirx/newproj/pkg/revocation"
+)
+
+// newOAuthRepositoryMock returns repository mock which keeps clients, consents, codes and sessions in memory.
+func newOAuthRepositoryMock(sessions *[]Session) repositoryMock {
+	clients := make(map[string]OAuthClient)
+	consents := make(map[string]Consent)
+	codes := make(map[string]OAuthCode)
+
+	return repositoryMock{
+		CreateClientFn: func(ctx context.Context, client OAuthClient) error {
+			clients[client.ClientID] = client
+			return nil
+		},
+		ClientFn: func(ctx context.Context, clientID string) (*OAuthClient, error) {
+			client, ok := clients[clientID]
+			if !ok {
+				return nil, errors.WithStack(sql.ErrNoRows)
+			}
+
+			return &client, nil
+		},
+		ConsentFn: func(ctx context.Context, uid int, clientID string) (*Consent, error) {
+			consent, ok := consents[clientID]
+			if !ok || consent.UserID != uid {
+				return nil, errors.WithStack(sql.ErrNoRows)
+			}
+
+			return &consent, nil
+		},
+		SaveConsentFn: func(ctx context.Context, uid int, clientID string, scopes []string) error {
+			consents[clientID] = Consent{UserID: uid, ClientID: clientID, Scopes: scopes}
+			return nil
+		},
+		DeleteConsentFn: func(ctx context.Context, uid int, clientID string) error {
+			if _, ok := consents[clientID]; !ok {
+				return errors.WithStack(sql.ErrNoRows)
+			}
+
+			delete(consents, clientID)
+
+			return nil
+		},
+		CreateOAuthCodeFn: func(ctx context.Context, codeHash string, code OAuthCode) error {
+			codes[codeHash] = code
+			return nil
+		},
+		UseOAuthCodeFn: func(ctx context.Context, codeHash string) (*OAuthCode, error) {
+			code, ok := codes[codeHash]
+			if !ok || code.ExpiresAt.Before(time.Now()) {
+				return nil, errors.WithStack(sql.ErrNoRows)
+			}
+
+			delete(codes, codeHash)
+
+			return &code, nil
+		},
+		CreateSessionFn: func(ctx context.Context, session Session) error {
+			*sessions = append(*sessions, session)
+			return nil
+		},
+		SessionsFn: func(ctx context.Context, uid int) ([]Session, error) {
+			return *sessions, nil
+		},
+		RevokeSessionFn: func(ctx context.Context, uid int, id string) error {
+			return nil
+		},
+	}
+}
+
+func TestServiceOAuthFlow(t *testing.T) {
+	sessions := make([]Session, 0)
+	revoked := make(map[string]bool)
+
+	rs := revocation.Mock{
+		IsRevokedFn: func(ctx context.Context, uid int, issuedAt time.Time) (bool, error) {
+			return false, nil
+		},
+		CheckSessionFn: func(ctx context.Context, sessionID string, at time.Time) (bool, error) {
+			return revoked[sessionID], nil
+		},
+		RevokeSessionFn: func(ctx context.Context, sessionID string) error {
+			revoked[sessionID] = true
+			return nil
+		},
+	}
+
+	s := NewService(
+		tracerMock,
+		newOAuthRepositoryMock(&sessions),
+		manager.Mock{},
+		generatorMock,
+		catalogMock,
+		rs,
+		apitoken.Mock{},
+		throttleNoop,
+		nil,
+		testConfig,
+	)
+	ctx := context.WithValue(context.Background(), jwtmiddleware.ContextKeyUserID, 3)
+
+	client, err := s.RegisterClient(ctx, RegisterClientRequest{
+		Name:         "Scheduler",
+		RedirectURIs: []string{"https://app.example.com/callback?app=1"},
+		Confidential: true,
+	})
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := sha256.Sum256([]byte(verifier))
+
+	request := AuthorizeRequest{
+		ResponseType:        "code",
+		ClientID:            client.ClientID,
+		RedirectURI:         "https://app.example.com/callback?app=1",
+		Scope:               "post:write user:read post:write",
+		State:               "xyz",
+		CodeChallenge:       base64.RawURLEncoding.EncodeToString(challenge[:]),
+		CodeChallengeMethod: "S256",
+	}
+
+	authorization, err := s.Authorize(ctx, request)
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	if authorization.ClientName != "Scheduler" || len(authorization.Scopes) != 2 || authorization.Consented {
+		t.Fatalf("got: %+v, want: 2 scopes of Scheduler without consent", authorization)
+	}
+
+	consent, err := s.Consent(ctx, ConsentRequest{AuthorizeRequest: request, Approved: true})
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	redirectURL, err := url.Parse(consent.RedirectURL)
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	query := redirectURL.Query()
+	if query.Get("app") != "1" || query.Get("state") != "xyz" || query.Get("code") == "" {
+		t.Fatalf("got: %s, want: redirect uri with app, state and code", consent.RedirectURL)
+	}
+
+	if authorization, err = s.Authorize(ctx, request); err != nil || !authorization.Consented {
+		t.Fatalf("got: %+v, %v, want: consented authorization", authorization, err)
+	}
+
+	tokenRequest := TokenRequest{
+		GrantType:    "authorization_code",
+		Code:         query.Get("code"),
+		RedirectURI:  request.RedirectURI,
+		ClientID:     client.ClientID,
+		ClientSecret: client.ClientSecret,
+		CodeVerifier: "wrong-verifier",
+	}
+
+	// code is used even if verifier is wrong, so it can't be guessed
+	_, err = s.Token(ctx, tokenRequest)
+	if oauthErr := (OAuthError{}); !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_grant" {
+		t.Fatalf("got: %v, want: invalid_grant", err)
+	}
+
+	consent, err = s.Consent(ctx, ConsentRequest{AuthorizeRequest: request, Approved: true})
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	redirectURL, _ = url.Parse(consent.RedirectURL)
+	tokenRequest.Code = redirectURL.Query().Get("code")
+	tokenRequest.CodeVerifier = verifier
+
+	token, err := s.Token(ctx, tokenRequest)
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	if token.TokenType != "Bearer" || token.Scope != "post:write user:read" {
+		t.Fatalf("got: %+v, want: bearer token with post:write user:read scope", token)
+	}
+
+	if len(sessions) != 1 || sessions[0].ClientID != client.ClientID || sessions[0].UserID != 3 {
+		t.Fatalf("got: %+v, want: session of the client", sessions)
+	}
+
+	// code can't be used twice
+	_, err = s.Token(ctx, tokenRequest)
+	if oauthErr := (OAuthError{}); !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_grant" {
+		t.Fatalf("got: %v, want: invalid_grant", err)
+	}
+
+	introspectRequest := IntrospectRequest{
+		Token:        token.AccessToken,
+		ClientID:     client.ClientID,
+		ClientSecret: client.ClientSecret,
+	}
+
+	introspection, err := s.Introspect(ctx, introspectRequest)
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	if !introspection.Active || introspection.UserID != 3 || introspection.Scope != token.Scope {
+		t.Fatalf("got: %+v, want: active token of user 3", introspection)
+	}
+
+	introspectRequest.ClientSecret = "wrong"
+
+	_, err = s.Introspect(ctx, introspectRequest)
+	if oauthErr := (OAuthError{}); !errors.As(err, &oauthErr) || oauthErr.Status != http.StatusUnauthorized {
+		t.Fatalf("got: %v, want: invalid_client", err)
+	}
+
+	consent, err = s.Consent(ctx, ConsentRequest{AuthorizeRequest: request, Approved: true})
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	redirectURL, _ = url.Parse(consent.RedirectURL)
+	tokenRequest.Code = redirectURL.Query().Get("code")
+
+	// revoking consent revokes tokens of the client
+	if _, err = s.RevokeConsent(ctx, RevokeConsentRequest{ClientID: client.ClientID}); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	introspectRequest.ClientSecret = client.ClientSecret
+
+	if introspection, err = s.Introspect(ctx, introspectRequest); err != nil || introspection.Active {
+		t.Fatalf("got: %+v, %v, want: inactive token", introspection, err)
+	}
+
+	// pending code can't be exchanged after consent is revoked
+	_, err = s.Token(ctx, tokenRequest)
+	if oauthErr := (OAuthError{}); !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_grant" {
+		t.Fatalf("got: %v, want: invalid_grant", err)
+	}
+
+	_, err = s.RevokeConsent(ctx, RevokeConsentRequest{ClientID: client.ClientID})
+	if status, _ := api.NewErrorResponse("", err); status != http.StatusNotFound {
+		t.Fatalf("got: %d, want: %d", status, http.StatusNotFound)
+	}
+}
+
+func TestServiceAuthorizeInvalid(t *testing.T) {
+	sessions := make([]Session, 0)
+	rMock := newOAuthRepositoryMock(&sessions)
+	rs := revocation.Mock{}
+	s := NewService(
+		tracerMock,
+		rMock,
+		manager.Mock{},
+		generatorMock,
+		catalogMock,
+		rs,
+		apitoken.Mock{},
+		throttleNoop,
+		nil,
+		testConfig,
+	)
+	ctx := context.WithValue(context.Background(), jwtmiddleware.ContextKeyUserID, 3)
+
+	client, err := s.RegisterClient(ctx, RegisterClientRequest{
+		Name:         "Scheduler",
+		RedirectURIs: []string{"http://localhost:8080/callback"},
+	})
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	if client.ClientSecret != "" {
+		t.Fatalf("got: %s, want: no secret of public client", client.ClientSecret)
+	}
+
+	valid := AuthorizeRequest{
+		ResponseType:        "code",
+		ClientID:            client.ClientID,
+		RedirectURI:         "http://localhost:8080/callback",
+		Scope:               "user:read",
+		CodeChallenge:       "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+		CodeChallengeMethod: "S256",
+	}
+
+	if _, err = s.Authorize(ctx, valid); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	cases := map[string]func(r *AuthorizeRequest){
+		"unknown client":       func(r *AuthorizeRequest) { r.ClientID = "unknown" },
+		"another redirect uri": func(r *AuthorizeRequest) { r.RedirectURI = "http://localhost:8080/other" },
+		"token response type":  func(r *AuthorizeRequest) { r.ResponseType = "token" },
+		"unsupported scope":    func(r *AuthorizeRequest) { r.Scope = "user:read admin" },
+		"empty scope":          func(r *AuthorizeRequest) { r.Scope = " " },
+		"plain method":         func(r *AuthorizeRequest) { r.CodeChallengeMethod = "plain" },
+		"short challenge":      func(r *AuthorizeRequest) { r.CodeChallenge = "abc" },
+	}
+
+	for name, modify := range cases {
+		request := valid
+		modify(&request)
+
+		_, err = s.Authorize(ctx, request)
+		if status, _ := api.NewErrorResponse("", err); status != http.StatusBadRequest {
+			t.Fatalf("%s: got: %d, want: %d", name, status, http.StatusBadRequest)
+		}
+	}
+
+	_, err = s.RegisterClient(ctx, RegisterClientRequest{Name: "App", RedirectURIs: []string{"http://example.com/cb"}})
+
+	status, response := api.NewErrorResponse("", err)
+	if status != http.StatusBadRequest || len(response.Errors) != 1 || response.Errors[0].Field != "redirect_uris" {
+		t.Fatalf("got: %d/%+v, want: %d/error of redirect_uris", status, response.Errors, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,268 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gitlab.com/slirx/newproj/pkg/api"
+	"gitlab.com/slirx/newproj/pkg/oidc"
+	"gitlab.com/slirx/newproj/pkg/queue"
+	"gitlab.com/slirx/newproj/pkg/validation"
+)
+
+const (
+	// oidcLoginTTL is the time the user has to sign in at OpenID Connect provider.
+	oidcLoginTTL = 10 * time.Minute
+	// externalLoginAttempts is the number of logins which are tried for a new user of OpenID Connect provider.
+	externalLoginAttempts = 5
+	// externalRegistrationTTL is the time after which registration of a new user of OpenID Connect provider can be
+	// started again in case it's not finished, for example login has been taken in the meantime.
+	externalRegistrationTTL = time.Hour
+)
+
+var (
+	errOIDCNotConfigured = api.NewNotFoundError(errors.New("sign in through external provider is not configured"))
+	errOIDCExpired       = api.NewRequestError(errors.New("sign in is expired. please, try again"))
+)
+
+// OIDCLogin starts sign in through OpenID Connect provider. State, nonce and PKCE verifier are kept until the user
+// returns from the provider. State is returned to be bound to the browser, which started sign in.
+func (s service) OIDCLogin(ctx context.Context) (*OIDCLoginResponse, error) {
+	if s.OIDC == nil {
+		return nil, errOIDCNotConfigured
+	}
+
+	state, err := newResetToken()
+	if err != nil {
+		return nil, err
+	}
+
+	login := OIDCLogin{ExpiresAt: time.Now().Add(oidcLoginTTL)}
+
+	if login.Nonce, err = newResetToken(); err != nil {
+		return nil, err
+	}
+
+	if login.CodeVerifier, err = newResetToken(); err != nil {
+		return nil, err
+	}
+
+	if err = s.Repository.CreateOIDCLogin(ctx, hashResetToken(state), login); err != nil {
+		return nil, err
+	}
+
+	challenge := sha256.Sum256([]byte(login.CodeVerifier))
+
+	authURL, err := s.OIDC.AuthCodeURL(ctx, state, login.Nonce, base64.RawURLEncoding.EncodeToString(challenge[:]))
+	if err != nil {
+		return nil, err
+	}
+
+	return &OIDCLoginResponse{AuthURL: authURL, State: state}, nil
+}
+
+// OIDCCallback finishes sign in through OpenID Connect provider. The user of the provider is linked to auth with
+// the same email in case the provider confirmed it. Otherwise the user is registered through registration service
+// and auth is created through the same pipeline as for registered users, so the user can't sign in until it's done.
+// State should be the one of sign in started by the same browser, otherwise the user could be signed in to account
+// of the attacker.
+func (s service) OIDCCallback(ctx context.Context, request OIDCCallbackRequest) (*LoginResponse, error) {
+	if s.OIDC == nil {
+		return nil, errOIDCNotConfigured
+	}
+
+	if request.StateCookie == "" || subtle.ConstantTimeCompare([]byte(request.State), []byte(request.StateCookie)) != 1 {
+		return nil, errOIDCExpired
+	}
+
+	login, err := s.Repository.UseOIDCLogin(ctx, hashResetToken(request.State))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errOIDCExpired
+		}
+
+		return nil, err
+	}
+
+	claims, err := s.OIDC.Exchange(ctx, request.Code, login.CodeVerifier, login.Nonce)
+	if err != nil {
+		if errors.Is(err, oidc.ErrInvalidToken) {
+			return nil, api.NewRequestError(errors.New("identity provider returned invalid token"))
+		}
+
+		return nil, err
+	}
+
+	data, err := s.externalAuth(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if data == nil || data.UserID == 0 {
+		return &LoginResponse{RegistrationPending: true}, nil
+	}
+
+	mfaToken, err := s.mfaPending(ctx, data, mfaLoginUser)
+	if err != nil {
+		return nil, err
+	}
+
+	if mfaToken != "" {
+		return &LoginResponse{MFARequired: true, MFAToken: mfaToken}, nil
+	}
+
+	accessToken, err := s.accessToken(ctx, data.UserID, request.UserAgent, request.IP)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginResponse{AccessToken: accessToken}, nil
+}
+
+// externalAuth returns auth linked to the user of the provider. Nil is returned in case auth is being created.
+// Staff accounts and accounts with the second factor are not linked by email, because the provider would bypass
+// their protection.
+func (s service) externalAuth(ctx context.Context, claims *oidc.Claims) (*Auth, error) {
+	data, err := s.Repository.ExternalAuth(ctx, claims.Issuer, claims.Subject)
+	if err == nil || !errors.Is(err, sql.ErrNoRows) {
+		return data, err
+	}
+
+	// email is the only thing which identifies the user at both sides, so it should be confirmed by the provider
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, api.NewRequestError(errors.New("identity provider didn't confirm your email"))
+	}
+
+	data, err = s.Repository.AuthByEmail(ctx, claims.Email)
+	if err == nil {
+		if err = s.canLinkExternal(ctx, data); err != nil {
+			return nil, err
+		}
+
+		return data, s.Repository.CreateExternalIdentity(ctx, data.ID, claims.Issuer, claims.Subject)
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	// callbacks of the user are repeated until auth is created, registration is started only once
+	err = s.Repository.CreateExternalRegistration(
+		ctx,
+		claims.Issuer,
+		claims.Subject,
+		time.Now().Add(-externalRegistrationTTL),
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	login, err := s.externalLogin(ctx, claims)
+	if err == nil {
+		err = s.Manager.Send(ctx, queue.JobRegistrationExternal, queue.RegistrationExternal{
+			Login:   login,
+			Email:   claims.Email,
+			Issuer:  claims.Issuer,
+			Subject: claims.Subject,
+		})
+	}
+
+	if err != nil {
+		// registration isn't started, so the user can try again. it expires anyway in case it can't be deleted
+		_ = s.Repository.DeleteExternalRegistration(ctx, claims.Issuer, claims.Subject)
+
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// canLinkExternal returns request error in case auth can't be linked to the user of the provider automatically.
+func (s service) canLinkExternal(ctx context.Context, data *Auth) error {
+	errLink := api.NewRequestError(errors.New("account with your email can't be linked. please, log in with password"))
+
+	if data.Role.IsStaff() {
+		return errLink
+	}
+
+	mfa, err := s.Repository.MFA(ctx, data.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+
+		return err
+	}
+
+	if mfa.Enabled {
+		return errLink
+	}
+
+	return nil
+}
+
+// externalLogin returns free login for a new user of the provider. It's based on preferred username or email,
+// random suffix is added in case the login is taken.
+func (s service) externalLogin(ctx context.Context, claims *oidc.Claims) (string, error) {
+	base := loginBase(claims.PreferredUsername)
+	if validation.Login(base) != nil {
+		base = loginBase(strings.Split(claims.Email, "@")[0])
+	}
+
+	if validation.Login(base) != nil {
+		base = "user"
+	}
+
+	for i := 0; i < externalLoginAttempts; i++ {
+		login := base
+		if i > 0 || s.ReservedLogins.Contains(login) {
+			login = fmt.Sprintf("%s_%04d", base, s.RandGenerator.Intn(10000))
+		}
+
+		availability, err := s.Repository.Availability(ctx, AvailabilityRequest{Login: login})
+		if err != nil {
+			return "", err
+		}
+
+		if !availability.LoginTaken {
+			return login, nil
+		}
+	}
+
+	return "", api.NewRequestError(errors.New("can't choose login for you. please, register with login and password"))
+}
+
+// loginBase converts s to login. Separators are replaced by underscores, other symbols which can't be used in logins
+// are skipped. The result is short enough to add suffix of 5 symbols to it.
+func loginBase(s string) string {
+	var builder strings.Builder
+
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_':
+			builder.WriteRune(r)
+		case r == '.' || r == '-' || r == ' ':
+			builder.WriteRune('_')
+		default:
+			continue
+		}
+
+		if builder.Len() == validation.LoginMaxLen-5 {
+			break
+		}
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,339 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gitlab.com/slirx/newproj/pkg/api"
+	"gitlab.com/slirx/newproj/pkg/apitoken"
+	"gitlab.com/slirx/newproj/pkg/oidc"
+	"gitlab.com/slirx/newproj/pkg/queue"
+	"gitlab.com/slirx/newproj/pkg/queue/manager"
+	"gitlab.com/slirx/newproj/pkg/rbac"
+	"gitlab.com/slirx/newproj/pkg/revocation"
+)
+
+func TestServiceOIDC(t *testing.T) {
+	logins := make(map[string]OIDCLogin)
+	identities := map[string]int{"sub-john": 7}
+	registrations := make(map[string]bool)
+	auths := map[int]*Auth{
+		7:  {ID: 7, UserID: 3, Email: "john@example.com", Login: "john"},
+		8:  {ID: 8, UserID: 4, Email: "jane@example.com", Login: "jane"},
+		9:  {ID: 9, UserID: 5, Email: "admin@example.com", Login: "admin", Role: rbac.RoleAdmin},
+		10: {ID: 10, UserID: 6, Email: "mfa@example.com", Login: "mfa"},
+	}
+
+	rMock := repositoryMock{
+		CreateOIDCLoginFn: func(ctx context.Context, stateHash string, login OIDCLogin) error {
+			logins[stateHash] = login
+			return nil
+		},
+		UseOIDCLoginFn: func(ctx context.Context, stateHash string) (*OIDCLogin, error) {
+			login, ok := logins[stateHash]
+			if !ok {
+				return nil, errors.WithStack(sql.ErrNoRows)
+			}
+
+			delete(logins, stateHash)
+
+			return &login, nil
+		},
+		ExternalAuthFn: func(ctx context.Context, issuer string, subject string) (*Auth, error) {
+			authID, ok := identities[subject]
+			if !ok || issuer != "https://idp.example.com" {
+				return nil, errors.WithStack(sql.ErrNoRows)
+			}
+
+			return auths[authID], nil
+		},
+		AuthByEmailFn: func(ctx context.Context, email string) (*Auth, error) {
+			for _, a := range auths {
+				if a.Email == email {
+					return a, nil
+				}
+			}
+
+			return nil, errors.WithStack(sql.ErrNoRows)
+		},
+		CreateExternalIdentityFn: func(ctx context.Context, authID int, issuer string, subject string) error {
+			identities[subject] = authID
+			return nil
+		},
+		CreateExternalRegistrationFn: func(ctx context.Context, issuer, subject string, expiredBefore time.Time) error {
+			if registrations[subject] {
+				return errors.WithStack(sql.ErrNoRows)
+			}
+
+			registrations[subject] = true
+
+			return nil
+		},
+		DeleteExternalRegistrationFn: func(ctx context.Context, issuer string, subject string) error {
+			delete(registrations, subject)
+			return nil
+		},
+		AvailabilityFn: func(ctx context.Context, request AvailabilityRequest) (*AvailabilityResponse, error) {
+			return &AvailabilityResponse{LoginTaken: request.Login == "jack" || strings.HasPrefix(request.Login, "bob")}, nil
+		},
+		MFAFn: func(ctx context.Context, authID int) (*MFA, error) {
+			if authID == 10 {
+				return &MFA{AuthID: authID, Enabled: true}, nil
+			}
+
+			return nil, errors.WithStack(sql.ErrNoRows)
+		},
+		CreateSessionFn: func(ctx context.Context, session Session) error {
+			return nil
+		},
+	}
+
+	claims := make(map[string]*oidc.Claims)
+	provider := oidc.Mock{
+		AuthCodeURLFn: func(ctx context.Context, state string, nonce string, codeChallenge string) (string, error) {
+			query := url.Values{"state": {state}, "nonce": {nonce}, "cc": {codeChallenge}}
+
+			return "https://idp.example.com/authorize?" + query.Encode(), nil
+		},
+		ExchangeFn: func(ctx context.Context, code string, codeVerifier string, nonce string) (*oidc.Claims, error) {
+			c, ok := claims[code]
+			if !ok {
+				return nil, errors.WithStack(oidc.ErrInvalidToken)
+			}
+
+			return c, nil
+		},
+	}
+
+	jobs := make([]queue.RegistrationExternal, 0)
+	m := manager.Mock{
+		SendFn: func(ctx context.Context, routingKey string, msg interface{}) error {
+			if routingKey != queue.JobRegistrationExternal {
+				t.Fatalf("got: %s, want: %s", routingKey, queue.JobRegistrationExternal)
+			}
+
+			jobs = append(jobs, msg.(queue.RegistrationExternal))
+
+			return nil
+		},
+	}
+
+	config := testConfig
+	config.ReservedLogins = []string{"admin"}
+
+	rs := revocation.Mock{}
+	s := NewService(
+		tracerMock,
+		rMock,
+		m,
+		generatorMock,
+		catalogMock,
+		rs,
+		apitoken.Mock{},
+		throttleNoop,
+		provider,
+		config,
+	)
+	ctx := context.Background()
+
+	// login starts a new sign in and returns state which should be passed to callback
+	login := func() string {
+		response, err := s.OIDCLogin(ctx)
+		if err != nil {
+			t.Fatalf("got: %s, want: nil", err.Error())
+		}
+
+		authURL, err := url.Parse(response.AuthURL)
+		if err != nil {
+			t.Fatalf("got: %s, want: nil", err.Error())
+		}
+
+		query := authURL.Query()
+		state := query.Get("state")
+
+		l, ok := logins[hashResetToken(state)]
+		if !ok || l.Nonce != query.Get("nonce") {
+			t.Fatalf("got: %s, want: url with state and nonce of saved login", response.AuthURL)
+		}
+
+		challenge := sha256.Sum256([]byte(l.CodeVerifier))
+		if query.Get("cc") != base64.RawURLEncoding.EncodeToString(challenge[:]) {
+			t.Fatalf("got: %s, want: challenge of code verifier", query.Get("cc"))
+		}
+
+		if response.State != state {
+			t.Fatalf("got: %s, want: %s", response.State, state)
+		}
+
+		return state
+	}
+
+	// callbackRequest starts a new sign in and returns callback request of the same browser
+	callbackRequest := func(code string) OIDCCallbackRequest {
+		state := login()
+
+		return OIDCCallbackRequest{Code: code, State: state, StateCookie: state}
+	}
+
+	claims["linked"] = &oidc.Claims{Issuer: "https://idp.example.com", Subject: "sub-john"}
+
+	response, err := s.OIDCCallback(ctx, callbackRequest("linked"))
+	if err != nil || response.AccessToken == "" {
+		t.Fatalf("got: %+v, %v, want: access token", response, err)
+	}
+
+	// state can be used once
+	_, err = s.OIDCCallback(ctx, OIDCCallbackRequest{Code: "linked", State: "state", StateCookie: "state"})
+	if status, _ := api.NewErrorResponse("", err); status != http.StatusBadRequest {
+		t.Fatalf("got: %d, want: %d", status, http.StatusBadRequest)
+	}
+
+	// sign in started by another browser isn't finished, the state stays valid for that browser
+	state := login()
+
+	for _, cookie := range []string{"", "another"} {
+		_, err = s.OIDCCallback(ctx, OIDCCallbackRequest{Code: "linked", State: state, StateCookie: cookie})
+		if status, _ := api.NewErrorResponse("", err); status != http.StatusBadRequest {
+			t.Fatalf("%q: got: %d, want: %d", cookie, status, http.StatusBadRequest)
+		}
+	}
+
+	if _, ok := logins[hashResetToken(state)]; !ok {
+		t.Fatalf("got: used state, want: state of another browser")
+	}
+
+	claims["unverified"] = &oidc.Claims{
+		Issuer:  "https://idp.example.com",
+		Subject: "sub-jane",
+		Email:   "jane@example.com",
+	}
+
+	_, err = s.OIDCCallback(ctx, callbackRequest("unverified"))
+	if status, _ := api.NewErrorResponse("", err); status != http.StatusBadRequest || identities["sub-jane"] != 0 {
+		t.Fatalf("got: %d, want: %d without linking", status, http.StatusBadRequest)
+	}
+
+	claims["verified"] = &oidc.Claims{
+		Issuer:        "https://idp.example.com",
+		Subject:       "sub-jane",
+		Email:         "jane@example.com",
+		EmailVerified: true,
+	}
+
+	response, err = s.OIDCCallback(ctx, callbackRequest("verified"))
+	if err != nil || response.AccessToken == "" || identities["sub-jane"] != 8 {
+		t.Fatalf("got: %+v, %v, want: access token of linked auth 8", response, err)
+	}
+
+	// staff and accounts with the second factor are not linked by email
+	for _, email := range []string{"admin@example.com", "mfa@example.com"} {
+		claims[email] = &oidc.Claims{
+			Issuer:        "https://idp.example.com",
+			Subject:       "sub-" + email,
+			Email:         email,
+			EmailVerified: true,
+		}
+
+		_, err = s.OIDCCallback(ctx, callbackRequest(email))
+		if status, _ := api.NewErrorResponse("", err); status != http.StatusBadRequest || identities["sub-"+email] != 0 {
+			t.Fatalf("%s: got: %d, want: %d without linking", email, status, http.StatusBadRequest)
+		}
+	}
+
+	claims["new"] = &oidc.Claims{
+		Issuer:            "https://idp.example.com",
+		Subject:           "sub-jack",
+		Email:             "jack@example.com",
+		EmailVerified:     true,
+		PreferredUsername: "jack",
+	}
+
+	response, err = s.OIDCCallback(ctx, callbackRequest("new"))
+	if err != nil || !response.RegistrationPending || response.AccessToken != "" {
+		t.Fatalf("got: %+v, %v, want: pending registration", response, err)
+	}
+
+	// registration is started once while auth is being created
+	response, err = s.OIDCCallback(ctx, callbackRequest("new"))
+	if err != nil || !response.RegistrationPending {
+		t.Fatalf("got: %+v, %v, want: pending registration", response, err)
+	}
+
+	if len(jobs) != 1 {
+		t.Fatalf("got: %d jobs, want: 1", len(jobs))
+	}
+
+	// "jack" is taken, so random suffix is added
+	job := jobs[0]
+	if len(job.Login) != len("jack_0000") || job.Login[:5] != "jack_" || job.Email != "jack@example.com" ||
+		job.Subject != "sub-jack" {
+		t.Fatalf("got: %+v, want: registration of jack with login jack_NNNN", job)
+	}
+
+	// login can't be chosen, so registration isn't started and the user can try again
+	claims["taken"] = &oidc.Claims{
+		Issuer:            "https://idp.example.com",
+		Subject:           "sub-bob",
+		Email:             "bob@example.com",
+		EmailVerified:     true,
+		PreferredUsername: "bob",
+	}
+
+	_, err = s.OIDCCallback(ctx, callbackRequest("taken"))
+	if status, _ := api.NewErrorResponse("", err); status != http.StatusBadRequest || registrations["sub-bob"] {
+		t.Fatalf("got: %d, want: %d without registration", status, http.StatusBadRequest)
+	}
+
+	if len(jobs) != 1 {
+		t.Fatalf("got: %d jobs, want: 1", len(jobs))
+	}
+
+	_, err = s.OIDCCallback(ctx, callbackRequest("invalid"))
+	if status, _ := api.NewErrorResponse("", err); status != http.StatusBadRequest {
+		t.Fatalf("got: %d, want: %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestServiceOIDCNotConfigured(t *testing.T) {
+	s := NewService(
+		tracerMock,
+		repositoryMock{},
+		manager.Mock{},
+		generatorMock,
+		catalogMock,
+		revocation.Mock{},
+		apitoken.Mock{},
+		throttleNoop,
+		nil,
+		testConfig,
+	)
+
+	_, err := s.OIDCLogin(context.Background())
+	if status, _ := api.NewErrorResponse("", err); status != http.StatusNotFound {
+		t.Fatalf("got: %d, want: %d", status, http.StatusNotFound)
+	}
+}
+
+func TestLoginBase(t *testing.T) {
+	cases := map[string]string{
+		"john.doe":                               "john_doe",
+		"Jane-Doe":                               "Jane_Doe",
+		"jörg":                                   "jrg",
+		"a_very_long_preferred_username_of_user": "a_very_long_preferred_use",
+	}
+
+	for s, want := range cases {
+		if got := loginBase(s); got != want {
+			t.Fatalf("%s: got: %s, want: %s", s, got, want)
+		}
+	}
+}
@@ -3,37 +3,170 @@ package auth
 import (
 	"context"
 	"database/sql"
+	"time"
 
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 
 	"gitlab.com/slirx/newproj/pkg/queue"
+	"gitlab.com/slirx/newproj/pkg/rbac"
 )
 
+// pqUniqueViolation is code of postgres error which is returned in case unique index is violated.
+const pqUniqueViolation = "23505"
+
+// ErrAuthExists is returned in case login or email is used by another auth.
+var ErrAuthExists = errors.New("login or email is used by another auth")
+
 type Repository interface {
+	// Create creates auth. ErrAuthExists is returned in case login or email is used by another auth.
 	Create(ctx context.Context, request queue.AuthCreate) error
 	UpdateUserID(ctx context.Context, login string, id int) error
 	Auth(ctx context.Context, login string) (*Auth, error)
 	InternalAuth(ctx context.Context, serviceName string) (*InternalAuth, error)
 	AdminAuth(ctx context.Context, login string) (*Auth, error)
+	// AuthByEmail returns auth with the email. Emails are compared case-insensitively, the same way as by
+	// Availability.
+	AuthByEmail(ctx context.Context, email string) (*Auth, error)
+	// CreatePasswordReset saves hash of password reset token. Unused tokens created before are deleted, so only
+	// the latest email can be used.
+	CreatePasswordReset(ctx context.Context, authID int, tokenHash string, expiresAt time.Time) error
+	// ResetPassword marks token as used and updates password in one transaction. It returns sql.ErrNoRows in case
+	// token doesn't exist, is already used or expired.
+	ResetPassword(ctx context.Context, tokenHash string, passwordHash string) (*Auth, error)
+	AuthByUserID(ctx context.Context, uid int) (*Auth, error)
+	UpdateRole(ctx context.Context, authID int, role rbac.Role) error
+	UpdatePassword(ctx context.Context, authID int, passwordHash string) error
+	// CreateEmailChange saves change of email which waits for confirmation. The previous not confirmed change
+	// of the same auth is replaced.
+	CreateEmailChange(ctx context.Context, authID int, email string, codeHash string, expiresAt time.Time) error
+	EmailChange(ctx context.Context, authID int) (*EmailChange, error)
+	// IncrEmailChangeAttempts counts one more attempt of confirmation of the change. It returns false without
+	// counting in case maxAttempts have already been made.
+	IncrEmailChangeAttempts(ctx context.Context, id int, maxAttempts int) (bool, error)
+	// ConfirmEmailChange sets email of the change to auth and deletes the change in one transaction. sync is called
+	// before the transaction is committed, so nothing is changed in case it fails. ErrAuthExists is returned in case
+	// the email is used by another auth.
+	ConfirmEmailChange(ctx context.Context, change EmailChange, sync func() error) error
+	Availability(ctx context.Context, request AvailabilityRequest) (*AvailabilityResponse, error)
+	MFA(ctx context.Context, authID int) (*MFA, error)
+	// CreateMFA saves secret of MFA which is not enabled yet. Not enabled secret created before is replaced.
+	CreateMFA(ctx context.Context, authID int, secret string) error
+	// EnableMFA enables MFA and replaces recovery codes in one transaction. step is step of the code which
+	// confirmed the secret.
+	EnableMFA(ctx context.Context, authID int, step int64, codeHashes []string) error
+	// UseMFAStep saves step of valid code and resets invalid attempts. It returns sql.ErrNoRows in case code
+	// of the same or a later step is already used, so one code can't be used by concurrent requests.
+	UseMFAStep(ctx context.Context, authID int, step int64) error
+	// IncrMFAAttempts counts one more attempt of MFA code. It returns false without counting in case maxAttempts
+	// have already been made.
+	IncrMFAAttempts(ctx context.Context, authID int, maxAttempts int) (bool, error)
+	// UseRecoveryCode marks recovery code as used and resets invalid attempts of MFA. It returns sql.ErrNoRows
+	// in case code doesn't exist or is already used.
+	UseRecoveryCode(ctx context.Context, authID int, codeHash string) error
+	ReplaceRecoveryCodes(ctx context.Context, authID int, codeHashes []string) error
+	// DeleteMFA deletes MFA and recovery codes of auth.
+	DeleteMFA(ctx context.Context, authID int) error
+	CreateSession(ctx context.Context, session Session) error
+	// Sessions returns not revoked and not expired sessions of the user, the latest first.
+	Sessions(ctx context.Context, uid int) ([]Session, error)
+	// RevokeSession marks session of the user as revoked. It returns sql.ErrNoRows in case the user has no such
+	// active session.
+	RevokeSession(ctx context.Context, uid int, id string) error
+	// RevokeSessions marks all sessions of the user as revoked.
+	RevokeSessions(ctx context.Context, uid int) error
+	// CreateAPIToken saves API token and returns its id.
+	CreateAPIToken(ctx context.Context, token APIToken) (int, error)
+	// APITokens returns not expired API tokens of the user.
+	APITokens(ctx context.Context, uid int) ([]APIToken, error)
+	// APITokenByHash returns not expired API token with hash.
+	APITokenByHash(ctx context.Context, hash string) (*APIToken, error)
+	DeleteAPIToken(ctx context.Context, uid int, id int) error
+	DeleteAPITokens(ctx context.Context, uid int) error
+	CreateClient(ctx context.Context, client OAuthClient) error
+	Client(ctx context.Context, clientID string) (*OAuthClient, error)
+	Consent(ctx context.Context, uid int, clientID string) (*Consent, error)
+	// SaveConsent saves scopes granted by the user to the client. Scopes granted before are replaced.
+	SaveConsent(ctx context.Context, uid int, clientID string, scopes []string) error
+	Consents(ctx context.Context, uid int) ([]Consent, error)
+	// DeleteConsent deletes consent of the user and marks not used authorization codes of the client issued to
+	// the user as used in one transaction, so they can't be exchanged to tokens after that. It returns
+	// sql.ErrNoRows in case there is no such consent.
+	DeleteConsent(ctx context.Context, uid int, clientID string) error
+	CreateOAuthCode(ctx context.Context, codeHash string, code OAuthCode) error
+	// UseOAuthCode marks authorization code as used and returns it. It returns sql.ErrNoRows in case code
+	// doesn't exist, is already used or expired, so one code can't be exchanged twice.
+	UseOAuthCode(ctx context.Context, codeHash string) (*OAuthCode, error)
+	CreateOIDCLogin(ctx context.Context, stateHash string, login OIDCLogin) error
+	// UseOIDCLogin marks sign in through OpenID Connect provider as finished and returns it. It returns
+	// sql.ErrNoRows in case state doesn't exist, is already used or expired.
+	UseOIDCLogin(ctx context.Context, stateHash string) (*OIDCLogin, error)
+	// ExternalAuth returns auth linked to the user of OpenID Connect provider.
+	ExternalAuth(ctx context.Context, issuer string, subject string) (*Auth, error)
+	CreateExternalIdentity(ctx context.Context, authID int, issuer string, subject string) error
+	// CreateExternalRegistration saves registration of the user of OpenID Connect provider which is in progress.
+	// Registration of the same user created before expiredBefore is replaced. It returns sql.ErrNoRows in case
+	// the user has registration in progress, so only one registration is started at a time.
+	CreateExternalRegistration(ctx context.Context, issuer string, subject string, expiredBefore time.Time) error
+	DeleteExternalRegistration(ctx context.Context, issuer string, subject string) error
 }
 
 type repository struct {
 	db *sql.DB
 }
 
+// Create creates auth. External identity of the request is linked to it and its registration is finished in the same
+// transaction. ErrAuthExists is returned in case login or email is used by another auth.
 func (r repository) Create(ctx context.Context, request queue.AuthCreate) error {
-	_, err := r.db.ExecContext(
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer tx.Rollback()
+
+	var authID int
+
+	err = tx.QueryRowContext(
 		ctx,
-		"INSERT INTO auth(user_id, email, login, password) VALUES(0, $1, $2, $3)",
+		"INSERT INTO auth(user_id, email, login, password) VALUES(0, $1, $2, $3) RETURNING id",
 		request.Email,
 		request.Login,
 		request.Password,
-	)
+	).Scan(&authID)
 	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == pqUniqueViolation {
+			return errors.WithStack(ErrAuthExists)
+		}
+
 		return errors.WithStack(err)
 	}
 
-	return nil
+	if request.Issuer != "" {
+		_, err = tx.ExecContext(
+			ctx,
+			"INSERT INTO external_identity(auth_id, issuer, subject) VALUES($1, $2, $3)",
+			authID,
+			request.Issuer,
+			request.Subject,
+		)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		_, err = tx.ExecContext(
+			ctx,
+			"DELETE FROM external_registration WHERE issuer = $1 AND subject = $2",
+			request.Issuer,
+			request.Subject,
+		)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return errors.WithStack(tx.Commit())
 }
 
 func (r repository) UpdateUserID(ctx context.Context, login string, id int) error {
@@ -85,8 +218,831 @@ func (r repository) AdminAuth(ctx context.Context, login string) (*Auth, error)
 
 	err := r.db.QueryRowContext(
 		ctx,
-		"SELECT id, user_id, email, login, password, created_at from auth WHERE login = $1 AND role='admin'",
+		"SELECT id, user_id, email, login, password, role, created_at from auth WHERE login = $1 AND role <> 'user'",
 		login,
+	).Scan(
+		&response.ID,
+		&response.UserID,
+		&response.Email,
+		&response.Login,
+		&response.Password,
+		&response.Role,
+		&response.CreatedAt,
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return response, nil
+}
+
+func (r repository) AuthByEmail(ctx context.Context, email string) (*Auth, error) {
+	response := &Auth{}
+
+	err := r.db.QueryRowContext(
+		ctx,
+		"SELECT id, user_id, email, login, password, role, created_at from auth WHERE lower(email) = lower($1)",
+		email,
+	).Scan(
+		&response.ID,
+		&response.UserID,
+		&response.Email,
+		&response.Login,
+		&response.Password,
+		&response.Role,
+		&response.CreatedAt,
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return response, nil
+}
+
+func (r repository) CreatePasswordReset(ctx context.Context, authID int, tokenHash string, expiresAt time.Time) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM password_reset WHERE auth_id = $1 AND used_at IS NULL", authID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		"INSERT INTO password_reset(auth_id, token_hash, expires_at) VALUES($1, $2, $3)",
+		authID,
+		tokenHash,
+		expiresAt,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(tx.Commit())
+}
+
+func (r repository) ResetPassword(ctx context.Context, tokenHash string, passwordHash string) (*Auth, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	defer tx.Rollback()
+
+	response := &Auth{}
+
+	// token is marked as used by the same statement which checks it, so concurrent requests can't use it twice
+	err = tx.QueryRowContext(
+		ctx,
+		`UPDATE password_reset SET used_at = current_timestamp
+			WHERE token_hash = $1 AND used_at IS NULL AND expires_at > current_timestamp
+			RETURNING auth_id`,
+		tokenHash,
+	).Scan(&response.ID)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	err = tx.QueryRowContext(
+		ctx,
+		"UPDATE auth SET password = $1 WHERE id = $2 RETURNING user_id, email, login, created_at",
+		passwordHash,
+		response.ID,
+	).Scan(&response.UserID, &response.Email, &response.Login, &response.CreatedAt)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM password_reset WHERE auth_id = $1 AND used_at IS NULL", response.ID)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return response, nil
+}
+
+func (r repository) AuthByUserID(ctx context.Context, uid int) (*Auth, error) {
+	response := &Auth{}
+
+	err := r.db.QueryRowContext(
+		ctx,
+		"SELECT id, user_id, email, login, password, role, created_at from auth WHERE user_id = $1",
+		uid,
+	).Scan(
+		&response.ID,
+		&response.UserID,
+		&response.Email,
+		&response.Login,
+		&response.Password,
+		&response.Role,
+		&response.CreatedAt,
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return response, nil
+}
+
+func (r repository) UpdateRole(ctx context.Context, authID int, role rbac.Role) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE auth SET role = $1 WHERE id = $2", role, authID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (r repository) UpdatePassword(ctx context.Context, authID int, passwordHash string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE auth SET password = $1 WHERE id = $2", passwordHash, authID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (r repository) CreateEmailChange(
+	ctx context.Context,
+	authID int,
+	email string,
+	codeHash string,
+	expiresAt time.Time,
+) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO email_change(auth_id, email, code_hash, expires_at) VALUES($1, $2, $3, $4)
+			ON CONFLICT (auth_id) DO UPDATE
+			SET email = $2, code_hash = $3, expires_at = $4, attempts = 0, created_at = current_timestamp`,
+		authID,
+		email,
+		codeHash,
+		expiresAt,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (r repository) EmailChange(ctx context.Context, authID int) (*EmailChange, error) {
+	response := &EmailChange{}
+
+	err := r.db.QueryRowContext(
+		ctx,
+		"SELECT id, auth_id, email, code_hash, attempts, expires_at FROM email_change WHERE auth_id = $1",
+		authID,
+	).Scan(
+		&response.ID,
+		&response.AuthID,
+		&response.Email,
+		&response.CodeHash,
+		&response.Attempts,
+		&response.ExpiresAt,
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return response, nil
+}
+
+func (r repository) IncrEmailChangeAttempts(ctx context.Context, id int, maxAttempts int) (bool, error) {
+	var attempts int
+
+	err := r.db.QueryRowContext(
+		ctx,
+		"UPDATE email_change SET attempts = attempts + 1 WHERE id = $1 AND attempts < $2 RETURNING attempts",
+		id,
+		maxAttempts,
+	).Scan(&attempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return true, nil
+}
+
+func (r repository) ConfirmEmailChange(ctx context.Context, change EmailChange, sync func() error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "UPDATE auth SET email = $1 WHERE id = $2", change.Email, change.AuthID)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == pqUniqueViolation {
+			return errors.WithStack(ErrAuthExists)
+		}
+
+		return errors.WithStack(err)
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM email_change WHERE id = $1", change.ID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err = sync(); err != nil {
+		return err
+	}
+
+	return errors.WithStack(tx.Commit())
+}
+
+func (r repository) Availability(ctx context.Context, request AvailabilityRequest) (*AvailabilityResponse, error) {
+	response := &AvailabilityResponse{}
+
+	err := r.db.QueryRowContext(
+		ctx,
+		`SELECT EXISTS(SELECT 1 FROM auth WHERE lower(login) = lower($1)),
+			EXISTS(SELECT 1 FROM auth WHERE lower(email) = lower($2))`,
+		request.Login,
+		request.Email,
+	).Scan(&response.LoginTaken, &response.EmailTaken)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return response, nil
+}
+
+func (r repository) MFA(ctx context.Context, authID int) (*MFA, error) {
+	response := &MFA{}
+
+	err := r.db.QueryRowContext(
+		ctx,
+		"SELECT auth_id, secret, enabled_at IS NOT NULL, last_used_step, attempts FROM mfa WHERE auth_id = $1",
+		authID,
+	).Scan(&response.AuthID, &response.Secret, &response.Enabled, &response.LastUsedStep, &response.Attempts)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return response, nil
+}
+
+func (r repository) CreateMFA(ctx context.Context, authID int, secret string) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO mfa(auth_id, secret) VALUES($1, $2)
+			ON CONFLICT (auth_id) DO UPDATE
+			SET secret = $2, last_used_step = 0, attempts = 0, created_at = current_timestamp
+			WHERE mfa.enabled_at IS NULL`,
+		authID,
+		secret,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (r repository) EnableMFA(ctx context.Context, authID int, step int64, codeHashes []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(
+		ctx,
+		`UPDATE mfa SET enabled_at = current_timestamp, last_used_step = $1, attempts = 0
+			WHERE auth_id = $2 AND enabled_at IS NULL`,
+		step,
+		authID,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err = affected(result); err != nil {
+		return err
+	}
+
+	if err = replaceRecoveryCodes(ctx, tx, authID, codeHashes); err != nil {
+		return err
+	}
+
+	return errors.WithStack(tx.Commit())
+}
+
+func (r repository) UseMFAStep(ctx context.Context, authID int, step int64) error {
+	result, err := r.db.ExecContext(
+		ctx,
+		"UPDATE mfa SET last_used_step = $1, attempts = 0 WHERE auth_id = $2 AND last_used_step < $1",
+		step,
+		authID,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return affected(result)
+}
+
+func (r repository) IncrMFAAttempts(ctx context.Context, authID int, maxAttempts int) (bool, error) {
+	var attempts int
+
+	err := r.db.QueryRowContext(
+		ctx,
+		"UPDATE mfa SET attempts = attempts + 1 WHERE auth_id = $1 AND attempts < $2 RETURNING attempts",
+		authID,
+		maxAttempts,
+	).Scan(&attempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return true, nil
+}
+
+func (r repository) UseRecoveryCode(ctx context.Context, authID int, codeHash string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(
+		ctx,
+		`UPDATE mfa_recovery_code SET used_at = current_timestamp
+			WHERE auth_id = $1 AND code_hash = $2 AND used_at IS NULL`,
+		authID,
+		codeHash,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err = affected(result); err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, "UPDATE mfa SET attempts = 0 WHERE auth_id = $1", authID); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(tx.Commit())
+}
+
+func (r repository) ReplaceRecoveryCodes(ctx context.Context, authID int, codeHashes []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer tx.Rollback()
+
+	if err = replaceRecoveryCodes(ctx, tx, authID, codeHashes); err != nil {
+		return err
+	}
+
+	return errors.WithStack(tx.Commit())
+}
+
+func (r repository) DeleteMFA(ctx context.Context, authID int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM mfa_recovery_code WHERE auth_id = $1", authID); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM mfa WHERE auth_id = $1", authID); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(tx.Commit())
+}
+
+func (r repository) CreateSession(ctx context.Context, session Session) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"INSERT INTO session(id, user_id, user_agent, ip, client_id, expires_at) VALUES($1, $2, $3, $4, $5, $6)",
+		session.ID,
+		session.UserID,
+		session.UserAgent,
+		session.IP,
+		session.ClientID,
+		session.ExpiresAt,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (r repository) Sessions(ctx context.Context, uid int) ([]Session, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT id, user_id, user_agent, ip, client_id, expires_at, created_at
+			FROM session
+			WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > now()
+			ORDER BY created_at DESC`,
+		uid,
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	defer rows.Close()
+
+	sessions := make([]Session, 0)
+
+	var s Session
+	for rows.Next() {
+		if err = rows.Scan(&s.ID, &s.UserID, &s.UserAgent, &s.IP, &s.ClientID, &s.ExpiresAt, &s.CreatedAt); err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		sessions = append(sessions, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return sessions, nil
+}
+
+func (r repository) RevokeSession(ctx context.Context, uid int, id string) error {
+	result, err := r.db.ExecContext(
+		ctx,
+		`UPDATE session SET revoked_at = now()
+			WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > now()`,
+		id,
+		uid,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return affected(result)
+}
+
+func (r repository) RevokeSessions(ctx context.Context, uid int) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"UPDATE session SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL",
+		uid,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (r repository) CreateAPIToken(ctx context.Context, token APIToken) (int, error) {
+	var id int
+
+	err := r.db.QueryRowContext(
+		ctx,
+		`INSERT INTO api_token(user_id, name, token_hash, scopes, expires_at) VALUES($1, $2, $3, $4, $5)
+			RETURNING id`,
+		token.UserID,
+		token.Name,
+		token.TokenHash,
+		pq.Array(token.Scopes),
+		token.ExpiresAt,
+	).Scan(&id)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return id, nil
+}
+
+func (r repository) APITokens(ctx context.Context, uid int) ([]APIToken, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT id, user_id, name, token_hash, scopes, expires_at, created_at
+			FROM api_token
+			WHERE user_id = $1 AND expires_at > now()
+			ORDER BY created_at DESC`,
+		uid,
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	defer rows.Close()
+
+	tokens := make([]APIToken, 0)
+
+	for rows.Next() {
+		var t APIToken
+
+		err = rows.Scan(&t.ID, &t.UserID, &t.Name, &t.TokenHash, pq.Array(&t.Scopes), &t.ExpiresAt, &t.CreatedAt)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		tokens = append(tokens, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return tokens, nil
+}
+
+func (r repository) APITokenByHash(ctx context.Context, hash string) (*APIToken, error) {
+	t := &APIToken{}
+
+	err := r.db.QueryRowContext(
+		ctx,
+		`SELECT id, user_id, name, token_hash, scopes, expires_at, created_at
+			FROM api_token
+			WHERE token_hash = $1 AND expires_at > now()`,
+		hash,
+	).Scan(&t.ID, &t.UserID, &t.Name, &t.TokenHash, pq.Array(&t.Scopes), &t.ExpiresAt, &t.CreatedAt)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return t, nil
+}
+
+func (r repository) DeleteAPIToken(ctx context.Context, uid int, id int) error {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM api_token WHERE id = $1 AND user_id = $2", id, uid)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return affected(result)
+}
+
+func (r repository) DeleteAPITokens(ctx context.Context, uid int) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM api_token WHERE user_id = $1", uid)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (r repository) CreateClient(ctx context.Context, client OAuthClient) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"INSERT INTO oauth_client(client_id, secret_hash, name, redirect_uris, user_id) VALUES($1, $2, $3, $4, $5)",
+		client.ClientID,
+		client.SecretHash,
+		client.Name,
+		pq.Array(client.RedirectURIs),
+		client.UserID,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (r repository) Client(ctx context.Context, clientID string) (*OAuthClient, error) {
+	client := &OAuthClient{}
+
+	err := r.db.QueryRowContext(
+		ctx,
+		`SELECT id, client_id, secret_hash, name, redirect_uris, user_id, created_at
+			FROM oauth_client
+			WHERE client_id = $1`,
+		clientID,
+	).Scan(
+		&client.ID,
+		&client.ClientID,
+		&client.SecretHash,
+		&client.Name,
+		pq.Array(&client.RedirectURIs),
+		&client.UserID,
+		&client.CreatedAt,
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return client, nil
+}
+
+func (r repository) Consent(ctx context.Context, uid int, clientID string) (*Consent, error) {
+	consent := &Consent{}
+
+	err := r.db.QueryRowContext(
+		ctx,
+		`SELECT oc.user_id, oc.client_id, c.name, oc.scopes, oc.updated_at
+			FROM oauth_consent oc
+			INNER JOIN oauth_client c ON c.client_id = oc.client_id
+			WHERE oc.user_id = $1 AND oc.client_id = $2`,
+		uid,
+		clientID,
+	).Scan(&consent.UserID, &consent.ClientID, &consent.ClientName, pq.Array(&consent.Scopes), &consent.UpdatedAt)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return consent, nil
+}
+
+func (r repository) SaveConsent(ctx context.Context, uid int, clientID string, scopes []string) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO oauth_consent(user_id, client_id, scopes) VALUES($1, $2, $3)
+			ON CONFLICT (user_id, client_id) DO UPDATE SET scopes = excluded.scopes, updated_at = now()`,
+		uid,
+		clientID,
+		pq.Array(scopes),
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (r repository) Consents(ctx context.Context, uid int) ([]Consent, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT oc.user_id, oc.client_id, c.name, oc.scopes, oc.updated_at
+			FROM oauth_consent oc
+			INNER JOIN oauth_client c ON c.client_id = oc.client_id
+			WHERE oc.user_id = $1
+			ORDER BY oc.updated_at DESC`,
+		uid,
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	defer rows.Close()
+
+	consents := make([]Consent, 0)
+
+	for rows.Next() {
+		var c Consent
+		if err = rows.Scan(&c.UserID, &c.ClientID, &c.ClientName, pq.Array(&c.Scopes), &c.UpdatedAt); err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		consents = append(consents, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return consents, nil
+}
+
+func (r repository) DeleteConsent(ctx context.Context, uid int, clientID string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(
+		ctx,
+		"DELETE FROM oauth_consent WHERE user_id = $1 AND client_id = $2",
+		uid,
+		clientID,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err = affected(result); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		"UPDATE oauth_code SET used_at = now() WHERE user_id = $1 AND client_id = $2 AND used_at IS NULL",
+		uid,
+		clientID,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(tx.Commit())
+}
+
+func (r repository) CreateOAuthCode(ctx context.Context, codeHash string, code OAuthCode) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO oauth_code(code_hash, client_id, user_id, redirect_uri, scopes, code_challenge, expires_at)
+			VALUES($1, $2, $3, $4, $5, $6, $7)`,
+		codeHash,
+		code.ClientID,
+		code.UserID,
+		code.RedirectURI,
+		pq.Array(code.Scopes),
+		code.CodeChallenge,
+		code.ExpiresAt,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (r repository) UseOAuthCode(ctx context.Context, codeHash string) (*OAuthCode, error) {
+	code := &OAuthCode{}
+
+	err := r.db.QueryRowContext(
+		ctx,
+		`UPDATE oauth_code SET used_at = now()
+			WHERE code_hash = $1 AND used_at IS NULL AND expires_at > now()
+			RETURNING client_id, user_id, redirect_uri, scopes, code_challenge, expires_at`,
+		codeHash,
+	).Scan(
+		&code.ClientID,
+		&code.UserID,
+		&code.RedirectURI,
+		pq.Array(&code.Scopes),
+		&code.CodeChallenge,
+		&code.ExpiresAt,
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return code, nil
+}
+
+func (r repository) CreateOIDCLogin(ctx context.Context, stateHash string, login OIDCLogin) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"INSERT INTO oidc_login(state_hash, nonce, code_verifier, expires_at) VALUES($1, $2, $3, $4)",
+		stateHash,
+		login.Nonce,
+		login.CodeVerifier,
+		login.ExpiresAt,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (r repository) UseOIDCLogin(ctx context.Context, stateHash string) (*OIDCLogin, error) {
+	login := &OIDCLogin{}
+
+	err := r.db.QueryRowContext(
+		ctx,
+		`UPDATE oidc_login SET used_at = now()
+			WHERE state_hash = $1 AND used_at IS NULL AND expires_at > now()
+			RETURNING nonce, code_verifier, expires_at`,
+		stateHash,
+	).Scan(&login.Nonce, &login.CodeVerifier, &login.ExpiresAt)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return login, nil
+}
+
+func (r repository) ExternalAuth(ctx context.Context, issuer string, subject string) (*Auth, error) {
+	response := &Auth{}
+
+	err := r.db.QueryRowContext(
+		ctx,
+		`SELECT a.id, a.user_id, a.email, a.login, a.password, a.created_at
+			FROM external_identity ei
+			JOIN auth a ON a.id = ei.auth_id
+			WHERE ei.issuer = $1 AND ei.subject = $2`,
+		issuer,
+		subject,
 	).Scan(&response.ID, &response.UserID, &response.Email, &response.Login, &response.Password, &response.CreatedAt)
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -95,6 +1051,92 @@ func (r repository) AdminAuth(ctx context.Context, login string) (*Auth, error)
 	return response, nil
 }
 
+func (r repository) CreateExternalIdentity(ctx context.Context, authID int, issuer string, subject string) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"INSERT INTO external_identity(auth_id, issuer, subject) VALUES($1, $2, $3) ON CONFLICT DO NOTHING",
+		authID,
+		issuer,
+		subject,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (r repository) CreateExternalRegistration(
+	ctx context.Context,
+	issuer string,
+	subject string,
+	expiredBefore time.Time,
+) error {
+	result, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO external_registration(issuer, subject) VALUES($1, $2)
+			ON CONFLICT (issuer, subject) DO UPDATE SET created_at = CURRENT_TIMESTAMP
+			WHERE external_registration.created_at < $3`,
+		issuer,
+		subject,
+		expiredBefore,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return affected(result)
+}
+
+func (r repository) DeleteExternalRegistration(ctx context.Context, issuer string, subject string) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"DELETE FROM external_registration WHERE issuer = $1 AND subject = $2",
+		issuer,
+		subject,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// replaceRecoveryCodes deletes all recovery codes of auth, including used ones, and saves the new ones.
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, authID int, codeHashes []string) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM mfa_recovery_code WHERE auth_id = $1", authID); err != nil {
+		return errors.WithStack(err)
+	}
+
+	for _, codeHash := range codeHashes {
+		_, err := tx.ExecContext(
+			ctx,
+			"INSERT INTO mfa_recovery_code(auth_id, code_hash) VALUES($1, $2)",
+			authID,
+			codeHash,
+		)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
+
+// affected returns sql.ErrNoRows in case no rows are affected by the statement.
+func affected(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if n == 0 {
+		return errors.WithStack(sql.ErrNoRows)
+	}
+
+	return nil
+}
+
 func NewRepository(db *sql.DB) Repository {
 	return repository{db: db}
 }
@@ -0,0 +1,490 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"gitlab.com/slirx/newproj/pkg/queue"
+	"gitlab.com/slirx/newproj/pkg/rbac"
+)
+
+func newDatabaseMock() (*sql.DB, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	return db, mock
+}
+
+func TestRepositoryCreatePasswordResetSuccess(t *testing.T) {
+	db, mock := newDatabaseMock()
+	repo := NewRepository(db)
+
+	expiresAt := time.Now().Add(time.Hour)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM password_reset WHERE auth_id = $1 AND used_at IS NULL")).
+		WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO password_reset(auth_id, token_hash, expires_at) VALUES($1, $2, $3)")).
+		WithArgs(7, "hash", expiresAt).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	if err := repo.CreatePasswordReset(context.Background(), 7, "hash", expiresAt); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+}
+
+func TestRepositoryAuthByEmail(t *testing.T) {
+	db, mock := newDatabaseMock()
+	repo := NewRepository(db)
+
+	createdAt := time.Now()
+
+	// emails are compared case-insensitively, the same way as availability is checked
+	mock.ExpectQuery(regexp.QuoteMeta("from auth WHERE lower(email) = lower($1)")).
+		WithArgs("John@Example.com").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "user_id", "email", "login", "password", "role", "created_at"}).
+				AddRow(7, 3, "john@example.com", "john", "hash", "admin", createdAt),
+		)
+
+	data, err := repo.AuthByEmail(context.Background(), "John@Example.com")
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	if data.ID != 7 || data.Email != "john@example.com" || data.Role != rbac.RoleAdmin {
+		t.Fatalf("got: %+v, want: auth 7 of admin", data)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+}
+
+func TestRepositoryResetPasswordSuccess(t *testing.T) {
+	db, mock := newDatabaseMock()
+	repo := NewRepository(db)
+
+	createdAt := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE password_reset SET used_at = current_timestamp")).
+		WithArgs("hash").
+		WillReturnRows(sqlmock.NewRows([]string{"auth_id"}).AddRow(7))
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE auth SET password = $1 WHERE id = $2")).
+		WithArgs("password-hash", 7).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"user_id", "email", "login", "created_at"}).
+				AddRow(3, "john@example.com", "john", createdAt),
+		)
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM password_reset WHERE auth_id = $1 AND used_at IS NULL")).
+		WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	data, err := repo.ResetPassword(context.Background(), "hash", "password-hash")
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	want := Auth{ID: 7, UserID: 3, Email: "john@example.com", Login: "john", CreatedAt: createdAt}
+	if *data != want {
+		t.Fatalf("got: %+v, want: %+v", *data, want)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+}
+
+func TestRepositoryResetPasswordUsedToken(t *testing.T) {
+	db, mock := newDatabaseMock()
+	repo := NewRepository(db)
+
+	// used or expired token isn't updated, so password stays the same
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE password_reset SET used_at = current_timestamp")).
+		WithArgs("hash").
+		WillReturnRows(sqlmock.NewRows([]string{"auth_id"}))
+	mock.ExpectRollback()
+
+	_, err := repo.ResetPassword(context.Background(), "hash", "password-hash")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("got: %v, want: %s", err, sql.ErrNoRows)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+}
+
+func TestRepositoryEnableMFASuccess(t *testing.T) {
+	db, mock := newDatabaseMock()
+	repo := NewRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE mfa SET enabled_at = current_timestamp, last_used_step = $1, attempts = 0")).
+		WithArgs(int64(100), 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM mfa_recovery_code WHERE auth_id = $1")).
+		WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO mfa_recovery_code(auth_id, code_hash) VALUES($1, $2)")).
+		WithArgs(7, "hash1").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO mfa_recovery_code(auth_id, code_hash) VALUES($1, $2)")).
+		WithArgs(7, "hash2").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	if err := repo.EnableMFA(context.Background(), 7, 100, []string{"hash1", "hash2"}); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+}
+
+func TestRepositoryUseMFAStepUsed(t *testing.T) {
+	db, mock := newDatabaseMock()
+	repo := NewRepository(db)
+
+	// step is not saved in case the same or a later one is already used
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE mfa SET last_used_step = $1, attempts = 0")).
+		WithArgs(int64(100), 7).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := repo.UseMFAStep(context.Background(), 7, 100)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("got: %v, want: %s", err, sql.ErrNoRows)
+	}
+}
+
+func TestRepositoryIncrEmailChangeAttempts(t *testing.T) {
+	db, mock := newDatabaseMock()
+	repo := NewRepository(db)
+
+	query := regexp.QuoteMeta("UPDATE email_change SET attempts = attempts + 1 WHERE id = $1 AND attempts < $2")
+
+	mock.ExpectQuery(query).
+		WithArgs(1, 5).
+		WillReturnRows(sqlmock.NewRows([]string{"attempts"}).AddRow(5))
+	// the row isn't updated in case the limit is reached
+	mock.ExpectQuery(query).
+		WithArgs(1, 5).
+		WillReturnRows(sqlmock.NewRows([]string{"attempts"}))
+
+	for _, want := range []bool{true, false} {
+		allowed, err := repo.IncrEmailChangeAttempts(context.Background(), 1, 5)
+		if err != nil || allowed != want {
+			t.Fatalf("got: %t, %v, want: %t, nil", allowed, err, want)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+}
+
+func TestRepositoryIncrMFAAttempts(t *testing.T) {
+	db, mock := newDatabaseMock()
+	repo := NewRepository(db)
+
+	// the row isn't updated in case the limit is reached
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE mfa SET attempts = attempts + 1 WHERE auth_id = $1 AND attempts < $2")).
+		WithArgs(7, 5).
+		WillReturnRows(sqlmock.NewRows([]string{"attempts"}))
+
+	allowed, err := repo.IncrMFAAttempts(context.Background(), 7, 5)
+	if err != nil || allowed {
+		t.Fatalf("got: %t, %v, want: false, nil", allowed, err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+}
+
+func TestRepositorySessions(t *testing.T) {
+	db, mock := newDatabaseMock()
+	repo := NewRepository(db)
+
+	createdAt := time.Date(2021, 5, 10, 8, 0, 0, 0, time.UTC)
+	expiresAt := createdAt.Add(AccessTokenTTL)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, user_id, user_agent, ip, client_id, expires_at, created_at")).
+		WithArgs(3).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "user_id", "user_agent", "ip", "client_id", "expires_at", "created_at"}).
+				AddRow("s2", 3, "Firefox", "10.0.0.2", "", expiresAt, createdAt).
+				AddRow("s1", 3, "curl", "10.0.0.1", "c1", expiresAt, createdAt),
+		)
+
+	sessions, err := repo.Sessions(context.Background(), 3)
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	if len(sessions) != 2 || sessions[0].ID != "s2" || sessions[1].UserAgent != "curl" || sessions[1].ClientID != "c1" {
+		t.Fatalf("got: %+v, want: sessions s2 and s1 of client c1", sessions)
+	}
+
+	// session of another user is not revoked
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE session SET revoked_at = now()")).
+		WithArgs("s1", 4).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err = repo.RevokeSession(context.Background(), 4, "s1"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("got: %v, want: %s", err, sql.ErrNoRows)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+}
+
+func TestRepositoryAPITokens(t *testing.T) {
+	db, mock := newDatabaseMock()
+	repo := NewRepository(db)
+
+	createdAt := time.Date(2021, 5, 10, 8, 0, 0, 0, time.UTC)
+	expiresAt := createdAt.AddDate(0, 0, 30)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, user_id, name, token_hash, scopes, expires_at, created_at")).
+		WithArgs(3).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "user_id", "name", "token_hash", "scopes", "expires_at", "created_at"}).
+				AddRow(6, 3, "bot", "hash6", "{post:write,user:read}", expiresAt, createdAt).
+				AddRow(5, 3, "script", "hash5", "{user:read}", expiresAt, createdAt),
+		)
+
+	tokens, err := repo.APITokens(context.Background(), 3)
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	if len(tokens) != 2 || tokens[0].Name != "bot" || len(tokens[0].Scopes) != 2 || tokens[1].Scopes[0] != "user:read" {
+		t.Fatalf("got: %+v, want: tokens bot and script with their scopes", tokens)
+	}
+
+	// token of another user is not deleted
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM api_token WHERE id = $1 AND user_id = $2")).
+		WithArgs(5, 4).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err = repo.DeleteAPIToken(context.Background(), 4, 5); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("got: %v, want: %s", err, sql.ErrNoRows)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+}
+
+func TestRepositoryUseOAuthCode(t *testing.T) {
+	db, mock := newDatabaseMock()
+	repo := NewRepository(db)
+
+	expiresAt := time.Date(2021, 5, 10, 8, 10, 0, 0, time.UTC)
+
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE oauth_code SET used_at = now()")).
+		WithArgs("hash").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"client_id", "user_id", "redirect_uri", "scopes", "code_challenge", "expires_at"}).
+				AddRow("c1", 3, "https://app.example.com/cb", "{post:write,user:read}", "challenge", expiresAt),
+		)
+
+	code, err := repo.UseOAuthCode(context.Background(), "hash")
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	if code.ClientID != "c1" || code.UserID != 3 || len(code.Scopes) != 2 || code.Scopes[1] != "user:read" {
+		t.Fatalf("got: %+v, want: code of client c1 and user 3 with 2 scopes", code)
+	}
+
+	// used or expired code is not returned
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE oauth_code SET used_at = now()")).
+		WithArgs("hash").
+		WillReturnRows(sqlmock.NewRows([]string{"client_id"}))
+
+	if _, err = repo.UseOAuthCode(context.Background(), "hash"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("got: %v, want: %s", err, sql.ErrNoRows)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+}
+
+func TestRepositoryDeleteConsent(t *testing.T) {
+	db, mock := newDatabaseMock()
+	repo := NewRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM oauth_consent WHERE user_id = $1 AND client_id = $2")).
+		WithArgs(3, "app").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE oauth_code SET used_at = now() WHERE user_id = $1 AND client_id = $2")).
+		WithArgs(3, "app").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	if err := repo.DeleteConsent(context.Background(), 3, "app"); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	// pending codes are kept in case there is no consent
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM oauth_consent WHERE user_id = $1 AND client_id = $2")).
+		WithArgs(3, "app").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	if err := repo.DeleteConsent(context.Background(), 3, "app"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("got: %v, want: %s", err, sql.ErrNoRows)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+}
+
+func TestRepositoryCreateWithExternalIdentity(t *testing.T) {
+	db, mock := newDatabaseMock()
+	repo := NewRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO auth(user_id, email, login, password) VALUES(0, $1, $2, $3)")).
+		WithArgs("jack@example.com", "jack", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO external_identity(auth_id, issuer, subject) VALUES($1, $2, $3)")).
+		WithArgs(9, "https://idp.example.com", "sub-jack").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM external_registration WHERE issuer = $1 AND subject = $2")).
+		WithArgs("https://idp.example.com", "sub-jack").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := repo.Create(context.Background(), queue.AuthCreate{
+		Login:   "jack",
+		Email:   "jack@example.com",
+		Issuer:  "https://idp.example.com",
+		Subject: "sub-jack",
+	})
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+}
+
+func TestRepositoryCreateExists(t *testing.T) {
+	db, mock := newDatabaseMock()
+	repo := NewRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO auth(user_id, email, login, password) VALUES(0, $1, $2, $3)")).
+		WithArgs("jack@example.com", "jack", "").
+		WillReturnError(&pq.Error{Code: pqUniqueViolation, Constraint: "auth_login_uindex"})
+	mock.ExpectRollback()
+
+	err := repo.Create(context.Background(), queue.AuthCreate{Login: "jack", Email: "jack@example.com"})
+	if !errors.Is(err, ErrAuthExists) {
+		t.Fatalf("got: %v, want: %s", err, ErrAuthExists)
+	}
+}
+
+func TestRepositoryConfirmEmailChange(t *testing.T) {
+	db, mock := newDatabaseMock()
+	repo := NewRepository(db)
+
+	change := EmailChange{ID: 1, AuthID: 7, Email: "new@example.com"}
+	syncErr := errors.New("connection is closed")
+
+	// the change is rolled back in case user service doesn't get it
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE auth SET email = $1 WHERE id = $2")).
+		WithArgs("new@example.com", 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM email_change WHERE id = $1")).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+
+	err := repo.ConfirmEmailChange(context.Background(), change, func() error {
+		return syncErr
+	})
+	if !errors.Is(err, syncErr) {
+		t.Fatalf("got: %v, want: %s", err, syncErr)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE auth SET email = $1 WHERE id = $2")).
+		WithArgs("new@example.com", 7).
+		WillReturnError(&pq.Error{Code: pqUniqueViolation, Constraint: "auth_email_uindex"})
+	mock.ExpectRollback()
+
+	err = repo.ConfirmEmailChange(context.Background(), change, func() error {
+		t.Fatal("sync is called for failed change")
+		return nil
+	})
+	if !errors.Is(err, ErrAuthExists) {
+		t.Fatalf("got: %v, want: %s", err, ErrAuthExists)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+}
+
+func TestRepositoryCreateExternalRegistration(t *testing.T) {
+	db, mock := newDatabaseMock()
+	repo := NewRepository(db)
+
+	expiredBefore := time.Now().Add(-time.Hour)
+	exec := regexp.QuoteMeta("INSERT INTO external_registration(issuer, subject) VALUES($1, $2)")
+
+	mock.ExpectExec(exec).
+		WithArgs("https://idp.example.com", "sub-jack", expiredBefore).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	// registration of the user is in progress
+	mock.ExpectExec(exec).
+		WithArgs("https://idp.example.com", "sub-jack", expiredBefore).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	ctx := context.Background()
+
+	if err := repo.CreateExternalRegistration(ctx, "https://idp.example.com", "sub-jack", expiredBefore); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	err := repo.CreateExternalRegistration(ctx, "https://idp.example.com", "sub-jack", expiredBefore)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("got: %v, want: %s", err, sql.ErrNoRows)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+}
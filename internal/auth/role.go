@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gitlab.com/slirx/newproj/pkg/api"
+	"gitlab.com/slirx/newproj/pkg/http/jwtmiddleware"
+)
+
+// SetRole grants role to the user. All tokens of the user are revoked, so the new role is applied right away
+// instead of when access token of admin panel expires. Staff can't change their own role, so the last admin can't
+// lose access to admin panel by mistake.
+func (s service) SetRole(ctx context.Context, request SetRoleRequest) (string, error) {
+	uid, err := jwtmiddleware.UID(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if err = request.Validate(); err != nil {
+		return "", api.NewRequestError(err)
+	}
+
+	data, err := s.Repository.Auth(ctx, request.Login)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", api.NewNotFoundError(errors.New("user is not found"))
+		}
+
+		return "", err
+	}
+
+	if data.UserID == uid {
+		return "", api.NewRequestError(errors.New("you can't change your own role"))
+	}
+
+	if err = s.Repository.UpdateRole(ctx, data.ID, request.Role); err != nil {
+		return "", err
+	}
+
+	if err = s.Revocation.RevokeUser(ctx, data.UserID, time.Now()); err != nil {
+		return "", err
+	}
+
+	if err = s.Repository.RevokeSessions(ctx, data.UserID); err != nil {
+		return "", err
+	}
+
+	return "role has been changed", nil
+}
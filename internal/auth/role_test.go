@@ -0,0 +1,260 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+
+	"gitlab.com/slirx/newproj/pkg/api"
+	"gitlab.com/slirx/newproj/pkg/apitoken"
+	"gitlab.com/slirx/newproj/pkg/http/jwtmiddleware"
+	"gitlab.com/slirx/newproj/pkg/logger"
+	"gitlab.com/slirx/newproj/pkg/queue/manager"
+	"gitlab.com/slirx/newproj/pkg/rbac"
+	"gitlab.com/slirx/newproj/pkg/revocation"
+)
+
+// newRoleRepository returns repository with auths of users 3 (moderator "john") and 4 (user "jane").
+func newRoleRepository(t *testing.T, roles map[int]rbac.Role) repositoryMock {
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	auths := map[string]*Auth{
+		"john": {ID: 7, UserID: 3, Login: "john", Password: string(passwordHash)},
+		"jane": {ID: 8, UserID: 4, Login: "jane", Password: string(passwordHash)},
+	}
+
+	withRole := func(data *Auth) *Auth {
+		a := *data
+		a.Role = roles[a.ID]
+
+		return &a
+	}
+
+	return repositoryMock{
+		AuthFn: func(ctx context.Context, login string) (*Auth, error) {
+			data, ok := auths[login]
+			if !ok {
+				return nil, errors.WithStack(sql.ErrNoRows)
+			}
+
+			return data, nil
+		},
+		AdminAuthFn: func(ctx context.Context, login string) (*Auth, error) {
+			data, ok := auths[login]
+			if !ok || roles[data.ID] == rbac.RoleUser {
+				return nil, errors.WithStack(sql.ErrNoRows)
+			}
+
+			return withRole(data), nil
+		},
+		AuthByUserIDFn: func(ctx context.Context, uid int) (*Auth, error) {
+			for _, data := range auths {
+				if data.UserID == uid {
+					return withRole(data), nil
+				}
+			}
+
+			return nil, errors.WithStack(sql.ErrNoRows)
+		},
+		UpdateRoleFn: func(ctx context.Context, authID int, role rbac.Role) error {
+			roles[authID] = role
+			return nil
+		},
+		MFAFn: func(ctx context.Context, authID int) (*MFA, error) {
+			return nil, errors.WithStack(sql.ErrNoRows)
+		},
+		CreateSessionFn: func(ctx context.Context, session Session) error {
+			return nil
+		},
+		RevokeSessionsFn: func(ctx context.Context, uid int) error {
+			return nil
+		},
+	}
+}
+
+// authorize passes token through jwtmiddleware with options o and returns status of the response and role from
+// context of the request.
+func authorize(token string, o ...jwtmiddleware.Option) (int, rbac.Role) {
+	var role rbac.Role
+
+	rr := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", token)
+
+	jwtmiddleware.Wrap(
+		func(w http.ResponseWriter, r *http.Request) {
+			role = jwtmiddleware.Role(r.Context())
+		},
+		api.NewResponseBuilder(tracerMock),
+		logger.NewNoop(),
+		[]byte(testConfig.Secret),
+		o...,
+	)(rr, r)
+
+	return rr.Code, role
+}
+
+func TestServiceAdminTokenRole(t *testing.T) {
+	roles := map[int]rbac.Role{7: rbac.RoleModerator, 8: rbac.RoleUser}
+	rMock := newRoleRepository(t, roles)
+
+	sessions := make([]Session, 0)
+	rMock.CreateSessionFn = func(ctx context.Context, session Session) error {
+		sessions = append(sessions, session)
+		return nil
+	}
+
+	revokedSessions := make(map[string]bool)
+	rs := revocation.Mock{
+		IsRevokedFn: func(ctx context.Context, uid int, issuedAt time.Time) (bool, error) {
+			return false, nil
+		},
+		CheckSessionFn: func(ctx context.Context, sessionID string, at time.Time) (bool, error) {
+			return revokedSessions[sessionID], nil
+		},
+	}
+	s := NewService(
+		tracerMock,
+		rMock,
+		manager.Mock{},
+		generatorMock,
+		catalogMock,
+		rs,
+		apitoken.Mock{},
+		throttleNoop,
+		nil,
+		testConfig,
+	)
+	ctx := context.Background()
+
+	admin, err := s.AdminLogin(ctx, AdminLoginRequest{Login: "john", Password: "password", UserAgent: "Firefox"})
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	// token of admin panel has session, so it's listed and revoked the same way as sessions of users
+	if len(sessions) != 1 || sessions[0].UserID != 3 || sessions[0].UserAgent != "Firefox" ||
+		time.Until(sessions[0].ExpiresAt) > AdminAccessTokenTTL {
+		t.Fatalf("got: %+v, want: session of admin panel of user 3", sessions)
+	}
+
+	user, err := s.Login(ctx, LoginRequest{Login: "jane", Password: "password"})
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	cases := []struct {
+		name    string
+		token   string
+		options []jwtmiddleware.Option
+		status  int
+		role    rbac.Role
+	}{
+		{
+			name:    "moderator at route of moderators",
+			token:   admin.AccessToken,
+			options: []jwtmiddleware.Option{jwtmiddleware.WithPermissions(rbac.PermissionPostsModerate)},
+			status:  http.StatusOK,
+			role:    rbac.RoleModerator,
+		},
+		{
+			name:    "moderator at route of admins",
+			token:   admin.AccessToken,
+			options: []jwtmiddleware.Option{jwtmiddleware.WithPermissions(rbac.PermissionRolesManage)},
+			status:  http.StatusForbidden,
+		},
+		{
+			name:   "moderator at route of users",
+			token:  admin.AccessToken,
+			status: http.StatusForbidden,
+		},
+		{
+			name:    "user at route of staff",
+			token:   user.AccessToken,
+			options: []jwtmiddleware.Option{jwtmiddleware.WithPermissions()},
+			status:  http.StatusForbidden,
+		},
+		{
+			name:   "user at route of users",
+			token:  user.AccessToken,
+			status: http.StatusOK,
+		},
+	}
+
+	for _, c := range cases {
+		if status, role := authorize(c.token, c.options...); status != c.status || role != c.role {
+			t.Fatalf("%s: got: %d/%q, want: %d/%q", c.name, status, role, c.status, c.role)
+		}
+	}
+
+	revokedSessions[sessions[0].ID] = true
+
+	status, _ := authorize(admin.AccessToken, jwtmiddleware.WithRevocation(rs), jwtmiddleware.WithPermissions())
+	if status != http.StatusForbidden {
+		t.Fatalf("got: %d, want: %d", status, http.StatusForbidden)
+	}
+
+	if _, err = s.AdminLogin(ctx, AdminLoginRequest{Login: "jane", Password: "password"}); err == nil {
+		t.Fatalf("got: nil, want: error about incorrect login")
+	}
+}
+
+func TestServiceSetRole(t *testing.T) {
+	roles := map[int]rbac.Role{7: rbac.RoleAdmin, 8: rbac.RoleUser}
+	revoked := make(map[int]bool)
+
+	rs := revocation.Mock{
+		RevokeUserFn: func(ctx context.Context, uid int, at time.Time) error {
+			revoked[uid] = true
+			return nil
+		},
+	}
+	rMock := newRoleRepository(t, roles)
+	s := NewService(
+		tracerMock,
+		rMock,
+		manager.Mock{},
+		generatorMock,
+		catalogMock,
+		rs,
+		apitoken.Mock{},
+		throttleNoop,
+		nil,
+		testConfig,
+	)
+	ctx := context.WithValue(context.Background(), jwtmiddleware.ContextKeyUserID, 3)
+
+	cases := []struct {
+		request SetRoleRequest
+		status  int
+	}{
+		{request: SetRoleRequest{Login: "jane", Role: "root"}, status: http.StatusBadRequest},
+		{request: SetRoleRequest{Login: "jack", Role: rbac.RoleSupport}, status: http.StatusNotFound},
+		{request: SetRoleRequest{Login: "john", Role: rbac.RoleUser}, status: http.StatusBadRequest},
+	}
+
+	for _, c := range cases {
+		if _, err := s.SetRole(ctx, c.request); err == nil {
+			t.Fatalf("%+v: got: nil, want: error", c.request)
+		} else if status, _ := api.NewErrorResponse("", err); status != c.status {
+			t.Fatalf("%+v: got: %d, want: %d", c.request, status, c.status)
+		}
+	}
+
+	if _, err := s.SetRole(ctx, SetRoleRequest{Login: "jane", Role: rbac.RoleSupport}); err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	if roles[8] != rbac.RoleSupport || !revoked[4] || revoked[3] {
+		t.Fatalf("got: %q, revoked: %v, want: support with revoked tokens of user 4", roles[8], revoked)
+	}
+}
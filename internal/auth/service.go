@@ -2,8 +2,16 @@ package auth
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
-	"math/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"math/big"
+	mathrand "math/rand"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -11,47 +19,115 @@ import (
 	"golang.org/x/crypto/bcrypt"
 
 	"gitlab.com/slirx/newproj/pkg/api"
+	"gitlab.com/slirx/newproj/pkg/apitoken"
+	"gitlab.com/slirx/newproj/pkg/http/jwtmiddleware"
+	"gitlab.com/slirx/newproj/pkg/oidc"
+	"gitlab.com/slirx/newproj/pkg/queue"
+	"gitlab.com/slirx/newproj/pkg/queue/manager"
+	"gitlab.com/slirx/newproj/pkg/revocation"
+	"gitlab.com/slirx/newproj/pkg/template"
+	"gitlab.com/slirx/newproj/pkg/tracer"
+	"gitlab.com/slirx/newproj/pkg/validation"
 )
 
+// passwordResetTokenLen is length of password reset token in bytes (before encoding).
+const passwordResetTokenLen = 32
+
+const (
+	// passwordResetInterval is the minimum interval between two password reset emails requested for the same email.
+	passwordResetInterval = time.Minute
+	// passwordResetIPRequests is the maximum number of password resets requested from one IP address during
+	// passwordResetIPWindow.
+	passwordResetIPRequests = 10
+	passwordResetIPWindow   = time.Hour
+)
+
+const (
+	// emailChangeTTL is lifetime of confirmation code of the new email.
+	emailChangeTTL = time.Hour
+	// emailChangeMaxAttempts is the number of wrong codes after which the change of email is cancelled.
+	emailChangeMaxAttempts = 5
+)
+
+var errLoginIncorrect = api.NewRequestError(errors.New("login/password is incorrect"))
+
 type Service interface {
 	Login(ctx context.Context, request LoginRequest) (*LoginResponse, error)
 	InternalLogin(ctx context.Context, request InternalLoginRequest) (*InternalLoginResponse, error)
 	AdminLogin(ctx context.Context, request AdminLoginRequest) (*AdminLoginResponse, error)
+	SetRole(ctx context.Context, request SetRoleRequest) (string, error)
+	ForgotPassword(ctx context.Context, request ForgotPasswordRequest) (string, error)
+	ResetPassword(ctx context.Context, request ResetPasswordRequest) (string, error)
+	ChangePassword(ctx context.Context, request ChangePasswordRequest) (*ChangePasswordResponse, error)
+	ChangeEmail(ctx context.Context, request ChangeEmailRequest) (string, error)
+	ConfirmEmailChange(ctx context.Context, request ConfirmEmailChangeRequest) (string, error)
+	InternalAvailability(ctx context.Context, request AvailabilityRequest) (*AvailabilityResponse, error)
+	EnrollMFA(ctx context.Context, request MFAEnrollRequest) (*MFAEnrollResponse, error)
+	EnableMFA(ctx context.Context, request MFACodeRequest) (*RecoveryCodesResponse, error)
+	DisableMFA(ctx context.Context, request MFADisableRequest) (string, error)
+	RegenerateRecoveryCodes(ctx context.Context, request MFACodeRequest) (*RecoveryCodesResponse, error)
+	VerifyMFA(ctx context.Context, request MFAVerifyRequest) (*LoginResponse, error)
+	AdminVerifyMFA(ctx context.Context, request MFAVerifyRequest) (*AdminLoginResponse, error)
+	Sessions(ctx context.Context) (*SessionsResponse, error)
+	RevokeSession(ctx context.Context, request RevokeSessionRequest) (string, error)
+	UserSessions(ctx context.Context, request UserSessionsRequest) (*SessionsResponse, error)
+	RevokeUserSessions(ctx context.Context, request UserSessionsRequest) (string, error)
+	CreateAPIToken(ctx context.Context, request CreateAPITokenRequest) (*CreateAPITokenResponse, error)
+	APITokens(ctx context.Context) (*APITokensResponse, error)
+	RevokeAPIToken(ctx context.Context, request RevokeAPITokenRequest) (string, error)
+	InternalAPIToken(ctx context.Context, request InternalAPITokenRequest) (*apitoken.Token, error)
+	RegisterClient(ctx context.Context, request RegisterClientRequest) (*RegisterClientResponse, error)
+	Authorize(ctx context.Context, request AuthorizeRequest) (*AuthorizeResponse, error)
+	Consent(ctx context.Context, request ConsentRequest) (*ConsentResponse, error)
+	Consents(ctx context.Context) (*ConsentsResponse, error)
+	RevokeConsent(ctx context.Context, request RevokeConsentRequest) (string, error)
+	Token(ctx context.Context, request TokenRequest) (*TokenResponse, error)
+	Introspect(ctx context.Context, request IntrospectRequest) (*IntrospectResponse, error)
+	OIDCLogin(ctx context.Context) (*OIDCLoginResponse, error)
+	OIDCCallback(ctx context.Context, request OIDCCallbackRequest) (*LoginResponse, error)
 }
 
 type service struct {
-	Repository    Repository
-	Config        Config
-	RandGenerator *rand.Rand
+	Tracer            tracer.Tracer
+	Repository        Repository
+	Manager           manager.Manager
+	TemplateGenerator template.Generator
+	Catalog           template.Catalog
+	Revocation        revocation.Store
+	TokenStore        apitoken.Store
+	Throttle          Throttle
+	OIDC              oidc.Provider // it's nil in case sign in through OpenID Connect provider is not configured
+	Config            Config
+	ReservedLogins    validation.Reserved
+	RandGenerator     *mathrand.Rand
 }
 
 func (s service) Login(ctx context.Context, request LoginRequest) (*LoginResponse, error) {
-	data, err := s.Repository.Auth(ctx, request.Login)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, api.NewRequestError(errors.New("login/password is incorrect"))
-		}
+	if err := s.Throttle.Attempt(ctx, throttleScopeUser, request.Login, request.IP); err != nil {
+		return nil, err
+	}
 
+	data, err := s.Repository.Auth(ctx, request.Login)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		return nil, err
 	}
 
-	if data.UserID == 0 {
-		return nil, api.NewRequestError(errors.New("login/password is incorrect"))
+	if err = s.checkUserPassword(ctx, data, request.Login, request.Password, request.IP, request.Locale); err != nil {
+		return nil, err
 	}
 
-	if err = bcrypt.CompareHashAndPassword([]byte(data.Password), []byte(request.Password)); err != nil {
-		return nil, api.NewRequestError(errors.New("login/password is incorrect"))
+	mfaToken, err := s.mfaPending(ctx, data, mfaLoginUser)
+	if err != nil {
+		return nil, err
 	}
 
-	token := jwt.New(jwt.SigningMethodHS256)
-	claims := token.Claims.(jwt.MapClaims)
-	claims["exp"] = time.Now().Add(time.Hour * 24).Unix() // todo decrease time to 1 hour
-	claims["jti"] = s.RandGenerator.Uint64()
-	claims["uid"] = data.UserID
+	if mfaToken != "" {
+		return &LoginResponse{MFARequired: true, MFAToken: mfaToken}, nil
+	}
 
-	accessToken, err := token.SignedString([]byte(s.Config.Secret))
+	accessToken, err := s.accessToken(ctx, data.UserID, request.UserAgent, request.IP)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, err
 	}
 
 	// todo add refresh token
@@ -64,26 +140,30 @@ func (s service) Login(ctx context.Context, request LoginRequest) (*LoginRespons
 }
 
 func (s service) InternalLogin(ctx context.Context, request InternalLoginRequest) (*InternalLoginResponse, error) {
-	data, err := s.Repository.InternalAuth(ctx, request.Login)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, api.NewRequestError(errors.New("login/password is incorrect"))
-		}
+	if err := s.Throttle.Attempt(ctx, throttleScopeInternal, request.Login, request.IP); err != nil {
+		return nil, err
+	}
 
+	data, err := s.Repository.InternalAuth(ctx, request.Login)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		return nil, err
 	}
 
-	if data.ServiceName == "" {
-		return nil, api.NewRequestError(errors.New("login/password is incorrect"))
+	hash := ""
+	if data != nil && data.ServiceName != "" {
+		hash = data.Password
 	}
 
-	if err = bcrypt.CompareHashAndPassword([]byte(data.Password), []byte(request.Password)); err != nil {
-		return nil, api.NewRequestError(errors.New("login/password is incorrect"))
+	_, err = s.checkPassword(ctx, throttleScopeInternal, request.Login, request.IP, hash, request.Password)
+	if err != nil {
+		return nil, err
 	}
 
+	expiresAt := time.Now().Add(time.Hour * 24).Unix() // todo decrease time to 1 hour
+
 	token := jwt.New(jwt.SigningMethodHS256)
 	claims := token.Claims.(jwt.MapClaims)
-	claims["exp"] = time.Now().Add(time.Hour * 24).Unix() // todo decrease time to 1 hour
+	claims["exp"] = expiresAt
 	claims["jti"] = s.RandGenerator.Uint64()
 	claims["login"] = request.Login
 
@@ -97,60 +177,597 @@ func (s service) InternalLogin(ctx context.Context, request InternalLoginRequest
 		return nil, errors.WithStack(err)
 	}
 
-	// todo add refresh token?
-
 	response := &InternalLoginResponse{
 		AccessToken: accessToken,
+		ExpiresAt:   expiresAt,
 	}
 
 	return response, nil
 }
 
 func (s service) AdminLogin(ctx context.Context, request AdminLoginRequest) (*AdminLoginResponse, error) {
+	if err := s.Throttle.Attempt(ctx, throttleScopeUser, request.Login, request.IP); err != nil {
+		return nil, err
+	}
+
 	data, err := s.Repository.AdminAuth(ctx, request.Login)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	if err = s.checkUserPassword(ctx, data, request.Login, request.Password, request.IP, request.Locale); err != nil {
+		return nil, err
+	}
+
+	mfaToken, err := s.mfaPending(ctx, data, mfaLoginAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	if mfaToken != "" {
+		return &AdminLoginResponse{MFARequired: true, MFAToken: mfaToken}, nil
+	}
+
+	if s.Config.AdminMFARequired {
+		return nil, api.NewAccessErrorWithCode(
+			CodeMFAEnrollmentRequired,
+			errors.New("mfa is required for admins. please, enable it in settings"),
+		)
+	}
+
+	accessToken, err := s.adminAccessToken(ctx, data, request.UserAgent, request.IP)
+	if err != nil {
+		return nil, err
+	}
+
+	// todo add refresh token
+
+	response := &AdminLoginResponse{
+		AccessToken: accessToken,
+	}
+
+	return response, nil
+}
+
+// ForgotPassword sends email with password reset link. The same message is returned in case email is unknown,
+// so the endpoint can't be used to find out registered emails. Requests are limited per email and per IP address
+// before the email is looked up, so the limits don't reveal registered emails either.
+func (s service) ForgotPassword(ctx context.Context, request ForgotPasswordRequest) (string, error) {
+	msg := "in case the email is registered, you will receive a link to reset your password"
+
+	if request.Email == "" {
+		return "", api.NewRequestError(errors.New("email should not be empty"))
+	}
+
+	if request.IP != "" {
+		err := s.Throttle.Limit(ctx, throttleScopePasswordReset, request.IP, passwordResetIPRequests, passwordResetIPWindow)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if err := s.Throttle.Limit(ctx, throttleScopePasswordReset, request.Email, 1, passwordResetInterval); err != nil {
+		return "", err
+	}
+
+	data, err := s.Repository.AuthByEmail(ctx, request.Email)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, api.NewRequestError(errors.New("login/password is incorrect"))
+			return msg, nil
 		}
 
-		return nil, err
+		return "", err
 	}
 
 	if data.UserID == 0 {
-		return nil, api.NewRequestError(errors.New("login/password is incorrect"))
+		return msg, nil
+	}
+
+	token, err := newResetToken()
+	if err != nil {
+		return "", err
+	}
+
+	expiresAt := time.Now().Add(s.Config.PasswordResetTTL)
+
+	if err = s.Repository.CreatePasswordReset(ctx, data.ID, hashResetToken(token), expiresAt); err != nil {
+		return "", err
+	}
+
+	resetURL, err := url.Parse(s.Config.PasswordResetURL)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	query := resetURL.Query()
+	query.Set("token", token)
+	resetURL.RawQuery = query.Encode()
+
+	err = s.sendEmail(
+		ctx,
+		data.Email,
+		request.Locale,
+		"password_reset",
+		PasswordResetEmail{
+			Login:            data.Login,
+			URL:              resetURL.String(),
+			ExpiresInMinutes: int(s.Config.PasswordResetTTL.Minutes()),
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return msg, nil
+}
+
+// ResetPassword sets a new password in case reset token is valid. All tokens issued to the user before are revoked.
+func (s service) ResetPassword(ctx context.Context, request ResetPasswordRequest) (string, error) {
+	if err := request.Validate(); err != nil {
+		return "", api.NewRequestError(err)
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	data, err := s.Repository.ResetPassword(ctx, hashResetToken(request.Token), string(passwordHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", api.NewRequestError(errors.New("reset token is invalid or expired"))
+		}
+
+		return "", err
+	}
+
+	if err = s.Revocation.RevokeUser(ctx, data.UserID, time.Now()); err != nil {
+		return "", err
+	}
+
+	if err = s.Repository.RevokeSessions(ctx, data.UserID); err != nil {
+		return "", err
+	}
+
+	if err = s.revokeAPITokens(ctx, data.UserID); err != nil {
+		return "", err
+	}
+
+	err = s.sendEmail(ctx, data.Email, request.Locale, "password_changed", PasswordChangedEmail{Login: data.Login})
+	if err != nil {
+		return "", err
+	}
+
+	return "your password has been changed. you can log in now", nil
+}
+
+// ChangePassword sets a new password of the current user. All tokens issued before are revoked, the new one
+// is returned, so the user stays logged in on the current device only.
+func (s service) ChangePassword(ctx context.Context, request ChangePasswordRequest) (*ChangePasswordResponse, error) {
+	uid, err := jwtmiddleware.UID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = request.Validate(); err != nil {
+		return nil, api.NewRequestError(err)
+	}
+
+	data, err := s.Repository.AuthByUserID(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = bcrypt.CompareHashAndPassword([]byte(data.Password), []byte(request.CurrentPassword)); err != nil {
+		return nil, api.NewRequestError(errors.New("current password is incorrect"))
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if err = s.Repository.UpdatePassword(ctx, data.ID, string(passwordHash)); err != nil {
+		return nil, err
+	}
+
+	revokedAt := time.Now()
+	if err = s.Revocation.RevokeUser(ctx, uid, revokedAt); err != nil {
+		return nil, err
+	}
+
+	if err = s.Repository.RevokeSessions(ctx, uid); err != nil {
+		return nil, err
+	}
+
+	if err = s.revokeAPITokens(ctx, uid); err != nil {
+		return nil, err
+	}
+
+	if err = waitNextSecond(ctx, revokedAt); err != nil {
+		return nil, err
+	}
+
+	accessToken, err := s.accessToken(ctx, uid, request.UserAgent, request.IP)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.sendEmail(ctx, data.Email, request.Locale, "password_changed", PasswordChangedEmail{Login: data.Login})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ChangePasswordResponse{AccessToken: accessToken}, nil
+}
+
+// ChangeEmail sends confirmation code to the new email. Email is changed only after ConfirmEmailChange.
+func (s service) ChangeEmail(ctx context.Context, request ChangeEmailRequest) (string, error) {
+	uid, err := jwtmiddleware.UID(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if err = request.Validate(); err != nil {
+		return "", api.NewRequestError(err)
+	}
+
+	data, err := s.Repository.AuthByUserID(ctx, uid)
+	if err != nil {
+		return "", err
 	}
 
 	if err = bcrypt.CompareHashAndPassword([]byte(data.Password), []byte(request.Password)); err != nil {
-		return nil, api.NewRequestError(errors.New("login/password is incorrect"))
+		return "", api.NewRequestError(errors.New("password is incorrect"))
+	}
+
+	if err = validation.Email(request.Email); err != nil {
+		return "", api.NewValidationError(api.NewFieldError("email", err))
+	}
+
+	// emails differing only in case belong to the same mailbox
+	if strings.ToLower(request.Email) == strings.ToLower(data.Email) {
+		return "", api.NewRequestError(errors.New("email is the same as the current one"))
+	}
+
+	availability, err := s.Repository.Availability(ctx, AvailabilityRequest{Email: request.Email})
+	if err != nil {
+		return "", err
+	}
+
+	if availability.EmailTaken {
+		return "", api.NewRequestError(errors.New("email is already in use"))
+	}
+
+	code, err := newEmailChangeCode()
+	if err != nil {
+		return "", err
+	}
+
+	err = s.Repository.CreateEmailChange(
+		ctx,
+		data.ID,
+		request.Email,
+		hashEmailChangeCode(data.ID, code),
+		time.Now().Add(emailChangeTTL),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.sendEmail(ctx, request.Email, request.Locale, "email_change", EmailChangeConfirmation{
+		Login: data.Login,
+		Code:  code,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return "confirmation code has been sent to the new email", nil
+}
+
+// ConfirmEmailChange checks confirmation code and changes email of the current user. User service gets the new
+// email through the queue. The job is sent before the change is committed, so emails of both services stay the same
+// and the user can confirm the change again in case it fails.
+func (s service) ConfirmEmailChange(ctx context.Context, request ConfirmEmailChangeRequest) (string, error) {
+	uid, err := jwtmiddleware.UID(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := s.Repository.AuthByUserID(ctx, uid)
+	if err != nil {
+		return "", err
+	}
+
+	change, err := s.Repository.EmailChange(ctx, data.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", api.NewRequestError(errors.New("change of email is not requested"))
+		}
+
+		return "", err
+	}
+
+	if change.ExpiresAt.Before(time.Now()) {
+		return "", api.NewRequestError(errors.New("confirmation code is timed out"))
+	}
+
+	// attempt is counted before the code is checked, so concurrent requests can't check more codes than allowed
+	allowed, err := s.Repository.IncrEmailChangeAttempts(ctx, change.ID, emailChangeMaxAttempts)
+	if err != nil {
+		return "", err
+	}
+
+	if !allowed {
+		return "", api.NewRequestError(errors.New("too many invalid codes. please, request a new one"))
+	}
+
+	if request.Code == 0 || hashEmailChangeCode(data.ID, request.Code) != change.CodeHash {
+		return "", api.NewRequestError(errors.New("confirmation code is invalid"))
+	}
+
+	errTaken := api.NewRequestError(errors.New("email is already in use"))
+
+	// the email could be taken since the change was requested
+	availability, err := s.Repository.Availability(ctx, AvailabilityRequest{Email: change.Email})
+	if err != nil {
+		return "", err
+	}
+
+	if availability.EmailTaken {
+		return "", errTaken
+	}
+
+	err = s.Repository.ConfirmEmailChange(ctx, *change, func() error {
+		return s.Manager.Send(ctx, queue.JobUserUpdateEmail, queue.UserUpdateEmail{
+			UserID: uid,
+			Email:  change.Email,
+		})
+	})
+	if err != nil {
+		if errors.Is(err, ErrAuthExists) {
+			return "", errTaken
+		}
+
+		return "", err
+	}
+
+	return "your email has been changed", nil
+}
+
+// InternalAvailability reports whether login and email are already used. It's used by registration service.
+func (s service) InternalAvailability(ctx context.Context, request AvailabilityRequest) (*AvailabilityResponse, error) {
+	if request.Login == "" && request.Email == "" {
+		return nil, api.NewRequestError(errors.New("login or email should be specified"))
+	}
+
+	return s.Repository.Availability(ctx, request)
+}
+
+// checkPassword compares password with hash and registers result of the attempt in Throttle. Empty hash means that
+// login doesn't exist. Dummy hash is compared in this case, so response time doesn't reveal registered logins.
+// Duration of the lockout is returned in case the login has just been locked.
+func (s service) checkPassword(
+	ctx context.Context,
+	scope string,
+	login string,
+	ip string,
+	hash string,
+	password string,
+) (time.Duration, error) {
+	known := hash != ""
+	if !known {
+		hash = dummyPasswordHash
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err == nil && known {
+		return 0, s.Throttle.Succeed(ctx, scope, login)
+	}
+
+	lockedFor, err := s.Throttle.Fail(ctx, scope, login, ip)
+	if err != nil {
+		return 0, err
+	}
+
+	if lockedFor > 0 {
+		return lockedFor, lockedError(lockedFor)
+	}
+
+	return 0, errLoginIncorrect
+}
+
+// checkUserPassword checks password of user or admin. data is nil in case login doesn't exist. The user is notified
+// by email in case the login is locked.
+func (s service) checkUserPassword(
+	ctx context.Context,
+	data *Auth,
+	login string,
+	password string,
+	ip string,
+	locale string,
+) error {
+	hash := ""
+	if data != nil && data.UserID != 0 {
+		hash = data.Password
+	}
+
+	lockedFor, err := s.checkPassword(ctx, throttleScopeUser, login, ip, hash, password)
+	if lockedFor > 0 && hash != "" {
+		emailErr := s.sendEmail(ctx, data.Email, locale, "account_locked", AccountLockedEmail{
+			Login:            data.Login,
+			LockedForMinutes: int(lockedFor / time.Minute),
+		})
+		if emailErr != nil {
+			return emailErr
+		}
+	}
+
+	return err
+}
+
+// accessToken creates a new session of the user and returns signed access token of it. userAgent and ip describe
+// the device the user logs in from.
+func (s service) accessToken(ctx context.Context, uid int, userAgent string, ip string) (string, error) {
+	return s.sessionToken(ctx, Session{UserID: uid, UserAgent: userAgent, IP: ip}, AccessTokenTTL, nil)
+}
+
+// sessionToken creates session and returns signed access token of it which is valid during ttl. extraClaims are
+// added to the token.
+func (s service) sessionToken(
+	ctx context.Context,
+	session Session,
+	ttl time.Duration,
+	extraClaims jwt.MapClaims,
+) (string, error) {
+	sessionID, err := newSessionID()
+	if err != nil {
+		return "", err
+	}
+
+	if len(session.UserAgent) > maxUserAgentLen {
+		session.UserAgent = session.UserAgent[:maxUserAgentLen]
+	}
+
+	now := time.Now()
+
+	session.ID = sessionID
+	session.ExpiresAt = now.Add(ttl)
+
+	if err = s.Repository.CreateSession(ctx, session); err != nil {
+		return "", err
 	}
 
 	token := jwt.New(jwt.SigningMethodHS256)
 	claims := token.Claims.(jwt.MapClaims)
-	claims["exp"] = time.Now().Add(time.Hour * 1).Unix()
-	claims["jti"] = s.RandGenerator.Uint64()
-	claims["uid"] = data.UserID
+
+	for key, value := range extraClaims {
+		claims[key] = value
+	}
+
+	claims["exp"] = now.Add(ttl).Unix() // todo decrease time to 1 hour
+	claims["iat"] = now.Unix()
+	claims["jti"] = sessionID
+	claims["uid"] = session.UserID
 
 	accessToken, err := token.SignedString([]byte(s.Config.Secret))
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return "", errors.WithStack(err)
 	}
 
-	// todo add refresh token
+	return accessToken, nil
+}
 
-	response := &AdminLoginResponse{
-		AccessToken: accessToken,
+// adminAccessToken creates a new session of the staff and returns signed access token of admin panel. Role of
+// the staff is embedded in the token, so jwtmiddleware can check its permissions and reject it at routes of users.
+// The session is listed and revoked the same way as sessions of users.
+func (s service) adminAccessToken(ctx context.Context, data *Auth, userAgent string, ip string) (string, error) {
+	if !data.Role.IsStaff() {
+		return "", api.NewAccessError(errors.New("you have no access to admin panel"))
 	}
 
-	return response, nil
+	return s.sessionToken(
+		ctx,
+		Session{UserID: data.UserID, UserAgent: userAgent, IP: ip},
+		AdminAccessTokenTTL,
+		jwt.MapClaims{"role": string(data.Role)},
+	)
+}
+
+// sendEmail generates email from template/auth/email/<name>.html (and .txt) and sends it to email queue. Subject
+// is taken from message auth.<name>.subject.
+func (s service) sendEmail(ctx context.Context, email string, locale string, name string, data interface{}) error {
+	fileName := "template/auth/email/" + name
+
+	htmlTemplate, err := s.TemplateGenerator.Generate(template.TypeHTML, fileName+".html", locale, data)
+	if err != nil {
+		return err
+	}
+
+	textTemplate, err := s.TemplateGenerator.Generate(template.TypeText, fileName+".txt", locale, data)
+	if err != nil {
+		return err
+	}
+
+	return s.Manager.Send(ctx, queue.JobEmailSend, queue.Email{
+		RecipientEmail: email,
+		Subject:        s.Catalog.Message(locale, "auth."+name+".subject"),
+		HTML:           htmlTemplate,
+		Text:           textTemplate,
+		Locale:         template.NormalizeLocale(locale),
+	})
+}
+
+// newResetToken returns random URL-safe token. Only its hash is stored in database.
+func newResetToken() (string, error) {
+	b := make([]byte, passwordResetTokenLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// newEmailChangeCode returns random 6-digit code.
+func newEmailChangeCode() (int, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(900000))
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return 100000 + int(n.Int64()), nil
+}
+
+// hashEmailChangeCode returns hash of the code. Codes are short, so id of auth is mixed in to make the same code
+// of different users have different hashes.
+func hashEmailChangeCode(authID int, code int) string {
+	return hashResetToken(strconv.Itoa(authID) + ":" + strconv.Itoa(code))
+}
+
+// waitNextSecond waits until the second after at begins. Tokens issued during the same second as revocation at at
+// are revoked, because "iat" claim has precision of seconds.
+func waitNextSecond(ctx context.Context, at time.Time) error {
+	timer := time.NewTimer(time.Until(at.Truncate(time.Second).Add(time.Second)))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+	case <-timer.C:
+		return nil
+	}
+}
+
+func hashResetToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(hash[:])
 }
 
 func NewService(
+	tracer tracer.Tracer,
 	repository Repository,
+	m manager.Manager,
+	tg template.Generator,
+	c template.Catalog,
+	rs revocation.Store,
+	at apitoken.Store,
+	th Throttle,
+	provider oidc.Provider,
 	config Config,
 ) Service {
 	return service{
-		Repository:    repository,
-		Config:        config,
-		RandGenerator: rand.New(rand.NewSource(time.Now().UnixNano())),
+		Tracer:            tracer,
+		Repository:        repository,
+		Manager:           m,
+		TemplateGenerator: tg,
+		Catalog:           c,
+		Revocation:        rs,
+		TokenStore:        at,
+		Throttle:          th,
+		OIDC:              provider,
+		Config:            config,
+		ReservedLogins:    validation.NewReserved(config.ReservedLogins),
+		RandGenerator:     mathrand.New(mathrand.NewSource(time.Now().UnixNano())),
 	}
 }
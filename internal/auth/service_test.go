@@ -0,0 +1,663 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+
+	"gitlab.com/slirx/newproj/pkg/apitoken"
+	"gitlab.com/slirx/newproj/pkg/http/jwtmiddleware"
+	"gitlab.com/slirx/newproj/pkg/queue"
+	"gitlab.com/slirx/newproj/pkg/queue/manager"
+	"gitlab.com/slirx/newproj/pkg/revocation"
+	"gitlab.com/slirx/newproj/pkg/template"
+	"gitlab.com/slirx/newproj/pkg/tracer"
+)
+
+var tracerMock = tracer.Mock{
+	RequestIDFn: func(ctx context.Context) string {
+		return "req1"
+	},
+}
+
+// catalogMock returns message keys prefixed with locale.
+var catalogMock = template.CatalogMock{
+	MessageFn: func(locale string, key string, args ...interface{}) string {
+		return locale + ":" + key
+	},
+}
+
+// generatorMock returns name of the template and data, so tests can check what is rendered.
+var generatorMock = template.Mock{
+	GenerateFn: func(t template.GeneratorType, fileName string, locale string, data interface{}) (string, error) {
+		if e, ok := data.(PasswordResetEmail); ok {
+			return fileName + ":" + e.URL, nil
+		}
+
+		return fileName, nil
+	},
+}
+
+var testConfig = Config{
+	Secret:           "secret",
+	PasswordResetURL: "https://example.com/password/reset?utm=email",
+	PasswordResetTTL: time.Hour,
+}
+
+// throttleNoop allows all login attempts and requests.
+var throttleNoop = throttleMock{
+	AttemptFn: func(ctx context.Context, scope string, login string, ip string) error {
+		return nil
+	},
+	FailFn: func(ctx context.Context, scope string, login string, ip string) (time.Duration, error) {
+		return 0, nil
+	},
+	SucceedFn: func(ctx context.Context, scope string, login string) error {
+		return nil
+	},
+	LimitFn: func(ctx context.Context, scope string, id string, limit int, window time.Duration) error {
+		return nil
+	},
+}
+
+func TestServiceForgotPasswordSuccess(t *testing.T) {
+	var tokenHash string
+	var expiresAt time.Time
+
+	rMock := repositoryMock{
+		AuthByEmailFn: func(ctx context.Context, email string) (*Auth, error) {
+			return &Auth{ID: 7, UserID: 3, Email: email, Login: "john"}, nil
+		},
+		CreatePasswordResetFn: func(ctx context.Context, authID int, hash string, at time.Time) error {
+			if authID != 7 {
+				t.Fatalf("got: %d, want: 7", authID)
+			}
+
+			tokenHash, expiresAt = hash, at
+
+			return nil
+		},
+	}
+
+	var email queue.Email
+
+	m := manager.Mock{
+		SendFn: func(ctx context.Context, routingKey string, msg interface{}) error {
+			if routingKey != queue.JobEmailSend {
+				t.Fatalf("got: %s, want: %s", routingKey, queue.JobEmailSend)
+			}
+
+			email = msg.(queue.Email)
+
+			return nil
+		},
+	}
+
+	s := NewService(
+		tracerMock,
+		rMock,
+		m,
+		generatorMock,
+		catalogMock,
+		revocation.Mock{},
+		apitoken.Mock{},
+		throttleNoop,
+		nil,
+		testConfig,
+	)
+
+	msg, err := s.ForgotPassword(context.Background(), ForgotPasswordRequest{Email: "john@example.com", Locale: "de"})
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	if msg == "" {
+		t.Fatalf("got: empty message, want: message")
+	}
+
+	if time.Until(expiresAt) <= 59*time.Minute || time.Until(expiresAt) > time.Hour {
+		t.Fatalf("got: %s, want: in 1 hour", expiresAt)
+	}
+
+	if email.RecipientEmail != "john@example.com" || email.Subject != "de:auth.password_reset.subject" {
+		t.Fatalf("got: %s/%s, want: john@example.com/de:auth.password_reset.subject", email.RecipientEmail, email.Subject)
+	}
+
+	if email.Locale != "de" {
+		t.Fatalf("got: %s, want: de", email.Locale)
+	}
+
+	// token from the link is stored only as a hash
+	link := strings.TrimPrefix(email.Text, "template/auth/email/password_reset.txt:")
+
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	token := u.Query().Get("token")
+	if token == "" || u.Query().Get("utm") != "email" {
+		t.Fatalf("got: %s, want: link with token", link)
+	}
+
+	if tokenHash == token || tokenHash != hashResetToken(token) {
+		t.Fatalf("got: %s, want: hash of %s", tokenHash, token)
+	}
+}
+
+func TestServiceForgotPasswordUnknownEmail(t *testing.T) {
+	rMock := repositoryMock{
+		AuthByEmailFn: func(ctx context.Context, email string) (*Auth, error) {
+			return nil, errors.WithStack(sql.ErrNoRows)
+		},
+	}
+
+	// nothing should be sent, manager.Mock panics in case Send is called
+	s := NewService(
+		tracerMock,
+		rMock,
+		manager.Mock{},
+		generatorMock,
+		catalogMock,
+		revocation.Mock{},
+		apitoken.Mock{},
+		throttleNoop,
+		nil,
+		testConfig,
+	)
+
+	msg, err := s.ForgotPassword(context.Background(), ForgotPasswordRequest{Email: "unknown@example.com"})
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	want := "in case the email is registered, you will receive a link to reset your password"
+	if msg != want {
+		t.Fatalf("got: %s, want: %s", msg, want)
+	}
+}
+
+func TestServiceResetPasswordSuccess(t *testing.T) {
+	token := "reset-token"
+
+	rMock := repositoryMock{
+		ResetPasswordFn: func(ctx context.Context, tokenHash string, passwordHash string) (*Auth, error) {
+			if tokenHash != hashResetToken(token) {
+				t.Fatalf("got: %s, want: hash of token", tokenHash)
+			}
+
+			if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte("new-password")); err != nil {
+				t.Fatalf("got: %s, want: bcrypt hash of the new password", err)
+			}
+
+			return &Auth{ID: 7, UserID: 3, Email: "john@example.com", Login: "john"}, nil
+		},
+		RevokeSessionsFn: func(ctx context.Context, uid int) error {
+			return nil
+		},
+		APITokensFn: func(ctx context.Context, uid int) ([]APIToken, error) {
+			return []APIToken{{ID: 5, UserID: uid, TokenHash: "hash5"}}, nil
+		},
+		DeleteAPITokensFn: func(ctx context.Context, uid int) error {
+			return nil
+		},
+	}
+
+	revokedUser := 0
+	revokedAPIToken := ""
+
+	at := apitoken.Mock{
+		DeleteFn: func(ctx context.Context, hash string) error {
+			revokedAPIToken = hash
+			return nil
+		},
+	}
+
+	rs := revocation.Mock{
+		RevokeUserFn: func(ctx context.Context, uid int, at time.Time) error {
+			revokedUser = uid
+			return nil
+		},
+	}
+
+	var email queue.Email
+
+	m := manager.Mock{
+		SendFn: func(ctx context.Context, routingKey string, msg interface{}) error {
+			email = msg.(queue.Email)
+			return nil
+		},
+	}
+
+	s := NewService(
+		tracerMock,
+		rMock,
+		m,
+		generatorMock,
+		catalogMock,
+		rs,
+		at,
+		throttleNoop,
+		nil,
+		testConfig,
+	)
+
+	_, err := s.ResetPassword(context.Background(), ResetPasswordRequest{
+		Token:                token,
+		Password:             "new-password",
+		PasswordConfirmation: "new-password",
+		Locale:               "en",
+	})
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	if revokedUser != 3 || revokedAPIToken != "hash5" {
+		t.Fatalf("got: %d/%s, want: revoked tokens of user 3 and api token hash5", revokedUser, revokedAPIToken)
+	}
+
+	if email.RecipientEmail != "john@example.com" || email.Subject != "en:auth.password_changed.subject" {
+		t.Fatalf("got: %s/%s, want: confirmation email", email.RecipientEmail, email.Subject)
+	}
+}
+
+func TestServiceResetPasswordErrors(t *testing.T) {
+	rMock := repositoryMock{
+		ResetPasswordFn: func(ctx context.Context, tokenHash string, passwordHash string) (*Auth, error) {
+			return nil, errors.WithStack(sql.ErrNoRows)
+		},
+	}
+
+	s := NewService(
+		tracerMock,
+		rMock,
+		manager.Mock{},
+		generatorMock,
+		catalogMock,
+		revocation.Mock{},
+		apitoken.Mock{},
+		throttleNoop,
+		nil,
+		testConfig,
+	)
+
+	cases := []struct {
+		request ResetPasswordRequest
+		wantErr string
+	}{
+		{
+			request: ResetPasswordRequest{Password: "12345", PasswordConfirmation: "12345"},
+			wantErr: "reset token should not be empty",
+		},
+		{
+			request: ResetPasswordRequest{Token: "token", Password: "12345", PasswordConfirmation: "54321"},
+			wantErr: "passwords should match",
+		},
+		{
+			request: ResetPasswordRequest{Token: "used", Password: "12345", PasswordConfirmation: "12345"},
+			wantErr: "reset token is invalid or expired",
+		},
+	}
+
+	for _, c := range cases {
+		_, err := s.ResetPassword(context.Background(), c.request)
+		if err == nil {
+			t.Fatalf("got: nil, want: %s", c.wantErr)
+		}
+
+		if err.Error() != c.wantErr {
+			t.Fatalf("got: %s, want: %s", err.Error(), c.wantErr)
+		}
+	}
+}
+
+func TestServiceChangePasswordSuccess(t *testing.T) {
+	currentHash, err := bcrypt.GenerateFromPassword([]byte("current"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	updated := false
+	session := Session{}
+
+	rMock := repositoryMock{
+		AuthByUserIDFn: func(ctx context.Context, uid int) (*Auth, error) {
+			return &Auth{ID: 7, UserID: uid, Email: "john@example.com", Login: "john", Password: string(currentHash)}, nil
+		},
+		UpdatePasswordFn: func(ctx context.Context, authID int, passwordHash string) error {
+			if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte("new-password")); err != nil {
+				t.Fatalf("got: %s, want: bcrypt hash of the new password", err)
+			}
+
+			updated = true
+
+			return nil
+		},
+		RevokeSessionsFn: func(ctx context.Context, uid int) error {
+			if session.ID != "" {
+				t.Fatalf("got: sessions are revoked after the new one is created, want: before")
+			}
+
+			return nil
+		},
+		CreateSessionFn: func(ctx context.Context, s Session) error {
+			session = s
+			return nil
+		},
+		APITokensFn: func(ctx context.Context, uid int) ([]APIToken, error) {
+			return nil, nil
+		},
+		DeleteAPITokensFn: func(ctx context.Context, uid int) error {
+			return nil
+		},
+	}
+
+	var revokedAt time.Time
+
+	rs := revocation.Mock{
+		RevokeUserFn: func(ctx context.Context, uid int, at time.Time) error {
+			revokedAt = at
+			return nil
+		},
+	}
+
+	m := manager.Mock{
+		SendFn: func(ctx context.Context, routingKey string, msg interface{}) error {
+			return nil
+		},
+	}
+
+	s := NewService(
+		tracerMock,
+		rMock,
+		m,
+		generatorMock,
+		catalogMock,
+		rs,
+		apitoken.Mock{},
+		throttleNoop,
+		nil,
+		testConfig,
+	)
+	ctx := context.WithValue(context.Background(), jwtmiddleware.ContextKeyUserID, 3)
+
+	response, err := s.ChangePassword(ctx, ChangePasswordRequest{
+		CurrentPassword:      "current",
+		Password:             "new-password",
+		PasswordConfirmation: "new-password",
+		IP:                   "10.0.0.1",
+		UserAgent:            "Firefox",
+	})
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	if !updated || revokedAt.IsZero() {
+		t.Fatalf("got: %t/%s, want: password is updated and tokens are revoked", updated, revokedAt)
+	}
+
+	// the new token is issued during the second after revocation, so it stays valid
+	token, err := jwt.Parse(response.AccessToken, func(token *jwt.Token) (interface{}, error) {
+		return []byte(testConfig.Secret), nil
+	})
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	if claims["uid"].(float64) != 3 || int64(claims["iat"].(float64)) <= revokedAt.Unix() {
+		t.Fatalf("got: %v, want: token of user 3 issued after revocation", claims)
+	}
+
+	if session.ID == "" || claims["jti"] != session.ID || session.UserID != 3 || session.UserAgent != "Firefox" {
+		t.Fatalf("got: %v/%+v, want: token of the new session", claims, session)
+	}
+}
+
+func TestServiceChangePasswordIncorrectCurrent(t *testing.T) {
+	currentHash, err := bcrypt.GenerateFromPassword([]byte("current"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	rMock := repositoryMock{
+		AuthByUserIDFn: func(ctx context.Context, uid int) (*Auth, error) {
+			return &Auth{ID: 7, UserID: uid, Password: string(currentHash)}, nil
+		},
+	}
+
+	s := NewService(
+		tracerMock,
+		rMock,
+		manager.Mock{},
+		generatorMock,
+		catalogMock,
+		revocation.Mock{},
+		apitoken.Mock{},
+		throttleNoop,
+		nil,
+		testConfig,
+	)
+	ctx := context.WithValue(context.Background(), jwtmiddleware.ContextKeyUserID, 3)
+
+	_, err = s.ChangePassword(ctx, ChangePasswordRequest{
+		CurrentPassword:      "wrong",
+		Password:             "new-password",
+		PasswordConfirmation: "new-password",
+	})
+
+	wantErr := "current password is incorrect"
+	if err == nil || err.Error() != wantErr {
+		t.Fatalf("got: %v, want: %s", err, wantErr)
+	}
+}
+
+func TestServiceChangeEmail(t *testing.T) {
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	var change EmailChange
+	var confirmErr error
+	var confirmed bool
+
+	takenEmail := "used@example.com"
+
+	rMock := repositoryMock{
+		AuthByUserIDFn: func(ctx context.Context, uid int) (*Auth, error) {
+			return &Auth{ID: 7, UserID: uid, Email: "john@example.com", Login: "john", Password: string(passwordHash)}, nil
+		},
+		AvailabilityFn: func(ctx context.Context, request AvailabilityRequest) (*AvailabilityResponse, error) {
+			return &AvailabilityResponse{EmailTaken: request.Email == takenEmail}, nil
+		},
+		CreateEmailChangeFn: func(ctx context.Context, authID int, email string, codeHash string, at time.Time) error {
+			change = EmailChange{ID: 1, AuthID: authID, Email: email, CodeHash: codeHash, ExpiresAt: at}
+			return nil
+		},
+		EmailChangeFn: func(ctx context.Context, authID int) (*EmailChange, error) {
+			c := change
+			return &c, nil
+		},
+		IncrEmailChangeAttemptsFn: func(ctx context.Context, id int, maxAttempts int) (bool, error) {
+			if change.Attempts >= maxAttempts {
+				return false, nil
+			}
+
+			change.Attempts++
+
+			return true, nil
+		},
+		ConfirmEmailChangeFn: func(ctx context.Context, c EmailChange, sync func() error) error {
+			if c.Email != "new@example.com" || c.AuthID != 7 {
+				t.Fatalf("got: %+v, want: change of auth 7 to new@example.com", c)
+			}
+
+			if confirmErr != nil {
+				return confirmErr
+			}
+
+			// the change is committed only in case user service gets it
+			if err := sync(); err != nil {
+				return err
+			}
+
+			confirmed = true
+
+			return nil
+		},
+	}
+
+	var code int
+	var userUpdate queue.UserUpdateEmail
+	var syncErr error
+
+	m := manager.Mock{
+		SendFn: func(ctx context.Context, routingKey string, msg interface{}) error {
+			switch routingKey {
+			case queue.JobEmailSend:
+				if email := msg.(queue.Email); email.RecipientEmail != "new@example.com" {
+					t.Fatalf("got: %s, want: new@example.com", email.RecipientEmail)
+				}
+			case queue.JobUserUpdateEmail:
+				if syncErr != nil {
+					return syncErr
+				}
+
+				userUpdate = msg.(queue.UserUpdateEmail)
+			default:
+				t.Fatalf("unexpected job: %s", routingKey)
+			}
+
+			return nil
+		},
+	}
+
+	g := template.Mock{
+		GenerateFn: func(t template.GeneratorType, fileName string, locale string, data interface{}) (string, error) {
+			code = data.(EmailChangeConfirmation).Code
+			return fileName, nil
+		},
+	}
+
+	s := NewService(
+		tracerMock,
+		rMock,
+		m,
+		g,
+		catalogMock,
+		revocation.Mock{},
+		apitoken.Mock{},
+		throttleNoop,
+		nil,
+		testConfig,
+	)
+	ctx := context.WithValue(context.Background(), jwtmiddleware.ContextKeyUserID, 3)
+
+	_, err = s.ChangeEmail(ctx, ChangeEmailRequest{Email: "used@example.com", Password: "password"})
+	if err == nil || err.Error() != "email is already in use" {
+		t.Fatalf("got: %v, want: email is already in use", err)
+	}
+
+	_, err = s.ChangeEmail(ctx, ChangeEmailRequest{Email: "John@Example.com", Password: "password"})
+	if err == nil || err.Error() != "email is the same as the current one" {
+		t.Fatalf("got: %v, want: email is the same as the current one", err)
+	}
+
+	if _, err = s.ChangeEmail(ctx, ChangeEmailRequest{Email: "new@example.com", Password: "password"}); err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	if code < 100000 || code > 999999 || change.CodeHash == strconv.Itoa(code) {
+		t.Fatalf("got: %d/%s, want: 6-digit code stored as a hash", code, change.CodeHash)
+	}
+
+	_, err = s.ConfirmEmailChange(ctx, ConfirmEmailChangeRequest{Code: code + 1})
+	if err == nil || err.Error() != "confirmation code is invalid" || change.Attempts != 1 {
+		t.Fatalf("got: %v/%d, want: confirmation code is invalid/1", err, change.Attempts)
+	}
+
+	// the email is taken since the change was requested
+	takenEmail = "new@example.com"
+
+	_, err = s.ConfirmEmailChange(ctx, ConfirmEmailChangeRequest{Code: code})
+	if err == nil || err.Error() != "email is already in use" || confirmed {
+		t.Fatalf("got: %v/%v, want: email is already in use without change", err, confirmed)
+	}
+
+	// the email is registered by another auth concurrently
+	takenEmail, confirmErr = "", errors.WithStack(ErrAuthExists)
+
+	_, err = s.ConfirmEmailChange(ctx, ConfirmEmailChangeRequest{Code: code})
+	if err == nil || err.Error() != "email is already in use" || confirmed {
+		t.Fatalf("got: %v/%v, want: email is already in use without change", err, confirmed)
+	}
+
+	// the job isn't sent, so the change is rolled back and can be confirmed again
+	confirmErr, syncErr = nil, errors.New("connection is closed")
+
+	_, err = s.ConfirmEmailChange(ctx, ConfirmEmailChangeRequest{Code: code})
+	if !errors.Is(err, syncErr) || confirmed {
+		t.Fatalf("got: %v/%v, want: %s without change", err, confirmed, syncErr)
+	}
+
+	syncErr = nil
+
+	if _, err = s.ConfirmEmailChange(ctx, ConfirmEmailChangeRequest{Code: code}); err != nil || !confirmed {
+		t.Fatalf("got: %v/%v, want: nil/true", err, confirmed)
+	}
+
+	want := queue.UserUpdateEmail{UserID: 3, Email: "new@example.com"}
+	if userUpdate != want {
+		t.Fatalf("got: %+v, want: %+v", userUpdate, want)
+	}
+
+	// the change is blocked after too many wrong codes, a new code should be requested
+	change.Attempts = emailChangeMaxAttempts
+
+	_, err = s.ConfirmEmailChange(ctx, ConfirmEmailChangeRequest{Code: code})
+	if err == nil || err.Error() != "too many invalid codes. please, request a new one" {
+		t.Fatalf("got: %v, want: too many invalid codes", err)
+	}
+}
+
+func TestServiceInternalAvailability(t *testing.T) {
+	rMock := repositoryMock{}
+	rMock.AvailabilityFn = func(ctx context.Context, request AvailabilityRequest) (*AvailabilityResponse, error) {
+		return &AvailabilityResponse{LoginTaken: request.Login == "john"}, nil
+	}
+
+	s := NewService(
+		tracerMock,
+		rMock,
+		manager.Mock{},
+		generatorMock,
+		catalogMock,
+		revocation.Mock{},
+		apitoken.Mock{},
+		throttleNoop,
+		nil,
+		testConfig,
+	)
+
+	response, err := s.InternalAvailability(context.Background(), AvailabilityRequest{Login: "john"})
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	if !response.LoginTaken || response.EmailTaken {
+		t.Fatalf("got: %v, want: login is taken", response)
+	}
+
+	if _, err = s.InternalAvailability(context.Background(), AvailabilityRequest{}); err == nil {
+		t.Fatalf("got: nil, want: error")
+	}
+}
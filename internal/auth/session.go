@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gitlab.com/slirx/newproj/pkg/api"
+	"gitlab.com/slirx/newproj/pkg/http/jwtmiddleware"
+)
+
+const (
+	// sessionIDLen is length of session id in bytes (before encoding).
+	sessionIDLen = 16
+	// maxUserAgentLen is the maximum length of user agent saved in session. The rest is cut.
+	maxUserAgentLen = 512
+)
+
+// Sessions returns active sessions of the user. Time of the last activity is the time of the latest request made
+// with the token of session, time of creation is used in case the token wasn't used yet.
+func (s service) Sessions(ctx context.Context) (*SessionsResponse, error) {
+	uid, err := jwtmiddleware.UID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := s.Repository.Sessions(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.sessionItems(ctx, sessions, jwtmiddleware.SessionID(ctx))
+}
+
+// RevokeSession revokes session of the user. Token of the session is rejected by all services right after that.
+// Current session can be revoked too, it works as logout.
+func (s service) RevokeSession(ctx context.Context, request RevokeSessionRequest) (string, error) {
+	uid, err := jwtmiddleware.UID(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if request.ID == "" {
+		return "", api.NewRequestError(errors.New("session id should not be empty"))
+	}
+
+	sessions, err := s.Repository.Sessions(ctx, uid)
+	if err != nil {
+		return "", err
+	}
+
+	found := false
+	for _, session := range sessions {
+		if session.ID == request.ID {
+			found = true
+			break
+		}
+	}
+
+	// session of another user is reported the same way as unknown one
+	if !found {
+		return "", api.NewNotFoundError(errors.New("session is not found"))
+	}
+
+	// token is revoked before the session is marked in database, so failure between them doesn't leave
+	// the token working
+	if err = s.Revocation.RevokeSession(ctx, request.ID); err != nil {
+		return "", err
+	}
+
+	// sql.ErrNoRows means that the session was revoked by concurrent request
+	err = s.Repository.RevokeSession(ctx, uid, request.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	return "session has been revoked", nil
+}
+
+// UserSessions returns active sessions of the user to staff, including sessions of admin panel in case the user is
+// staff too.
+func (s service) UserSessions(ctx context.Context, request UserSessionsRequest) (*SessionsResponse, error) {
+	data, err := s.userAuth(ctx, request.Login)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := s.Repository.Sessions(ctx, data.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.sessionItems(ctx, sessions, "")
+}
+
+// RevokeUserSessions revokes all sessions and tokens of the user, for example in case account is compromised.
+// Personal API tokens are revoked too, because they could be created by the one who took over the account.
+func (s service) RevokeUserSessions(ctx context.Context, request UserSessionsRequest) (string, error) {
+	data, err := s.userAuth(ctx, request.Login)
+	if err != nil {
+		return "", err
+	}
+
+	if err = s.Revocation.RevokeUser(ctx, data.UserID, time.Now()); err != nil {
+		return "", err
+	}
+
+	if err = s.Repository.RevokeSessions(ctx, data.UserID); err != nil {
+		return "", err
+	}
+
+	if err = s.revokeAPITokens(ctx, data.UserID); err != nil {
+		return "", err
+	}
+
+	return "sessions have been revoked", nil
+}
+
+// userAuth returns auth of the user with login for staff requests.
+func (s service) userAuth(ctx context.Context, login string) (*Auth, error) {
+	if login == "" {
+		return nil, api.NewRequestError(errors.New("login should not be empty"))
+	}
+
+	data, err := s.Repository.Auth(ctx, login)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, api.NewNotFoundError(errors.New("user is not found"))
+		}
+
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// sessionItems converts sessions to the list of sessions. Session with currentID is marked as current.
+func (s service) sessionItems(ctx context.Context, sessions []Session, currentID string) (*SessionsResponse, error) {
+	response := &SessionsResponse{Sessions: make([]SessionItem, 0, len(sessions))}
+
+	for _, session := range sessions {
+		lastSeenAt, err := s.Revocation.LastSeen(ctx, session.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		if lastSeenAt.IsZero() {
+			lastSeenAt = session.CreatedAt
+		}
+
+		response.Sessions = append(response.Sessions, SessionItem{
+			ID:         session.ID,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt.Unix(),
+			LastSeenAt: lastSeenAt.Unix(),
+			Current:    session.ID == currentID,
+		})
+	}
+
+	return response, nil
+}
+
+// newSessionID returns random id of session. It's used as "jti" claim of access token.
+func newSessionID() (string, error) {
+	b := make([]byte, sessionIDLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
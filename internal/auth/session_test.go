@@ -0,0 +1,255 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gitlab.com/slirx/newproj/pkg/api"
+	"gitlab.com/slirx/newproj/pkg/apitoken"
+	"gitlab.com/slirx/newproj/pkg/http/jwtmiddleware"
+	"gitlab.com/slirx/newproj/pkg/queue/manager"
+	"gitlab.com/slirx/newproj/pkg/revocation"
+)
+
+func TestServiceSessions(t *testing.T) {
+	createdAt := time.Date(2021, 5, 10, 8, 0, 0, 0, time.UTC)
+	seenAt := createdAt.Add(time.Hour)
+
+	sessions := []Session{
+		{ID: "s2", UserID: 3, UserAgent: "Firefox", IP: "10.0.0.2", CreatedAt: createdAt},
+		{ID: "s1", UserID: 3, UserAgent: "curl", IP: "10.0.0.1", CreatedAt: createdAt},
+	}
+
+	revokedInDatabase := ""
+
+	rMock := repositoryMock{
+		SessionsFn: func(ctx context.Context, uid int) ([]Session, error) {
+			if uid != 3 {
+				return []Session{}, nil
+			}
+
+			return sessions, nil
+		},
+		RevokeSessionFn: func(ctx context.Context, uid int, id string) error {
+			revokedInDatabase = id
+			return nil
+		},
+	}
+
+	revoked := ""
+
+	rs := revocation.Mock{
+		LastSeenFn: func(ctx context.Context, sessionID string) (time.Time, error) {
+			if sessionID == "s1" {
+				return seenAt, nil
+			}
+
+			return time.Time{}, nil
+		},
+		RevokeSessionFn: func(ctx context.Context, sessionID string) error {
+			revoked = sessionID
+			return nil
+		},
+	}
+
+	s := NewService(
+		tracerMock,
+		rMock,
+		manager.Mock{},
+		generatorMock,
+		catalogMock,
+		rs,
+		apitoken.Mock{},
+		throttleNoop,
+		nil,
+		testConfig,
+	)
+
+	ctx := context.WithValue(context.Background(), jwtmiddleware.ContextKeyUserID, 3)
+	ctx = context.WithValue(ctx, jwtmiddleware.ContextKeySessionID, "s1")
+
+	response, err := s.Sessions(ctx)
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	want := []SessionItem{
+		{ID: "s2", UserAgent: "Firefox", IP: "10.0.0.2", CreatedAt: createdAt.Unix(), LastSeenAt: createdAt.Unix()},
+		{ID: "s1", UserAgent: "curl", IP: "10.0.0.1", CreatedAt: createdAt.Unix(), LastSeenAt: seenAt.Unix(), Current: true},
+	}
+
+	if len(response.Sessions) != len(want) {
+		t.Fatalf("got: %+v, want: %+v", response.Sessions, want)
+	}
+
+	for i := range want {
+		if response.Sessions[i] != want[i] {
+			t.Fatalf("got: %+v, want: %+v", response.Sessions[i], want[i])
+		}
+	}
+
+	if _, err = s.RevokeSession(ctx, RevokeSessionRequest{ID: "s2"}); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	if revoked != "s2" || revokedInDatabase != "s2" {
+		t.Fatalf("got: %s/%s, want: s2/s2", revoked, revokedInDatabase)
+	}
+
+	// sessions of other users can't be revoked
+	revoked = ""
+	otherCtx := context.WithValue(context.Background(), jwtmiddleware.ContextKeyUserID, 4)
+
+	_, err = s.RevokeSession(otherCtx, RevokeSessionRequest{ID: "s1"})
+	if status, _ := api.NewErrorResponse("", err); status != http.StatusNotFound || revoked != "" {
+		t.Fatalf("got: %d/%s, want: %d/nothing is revoked", status, revoked, http.StatusNotFound)
+	}
+}
+
+func TestServiceRevokeSessionConcurrently(t *testing.T) {
+	rMock := repositoryMock{
+		SessionsFn: func(ctx context.Context, uid int) ([]Session, error) {
+			return []Session{{ID: "s1", UserID: uid}}, nil
+		},
+		RevokeSessionFn: func(ctx context.Context, uid int, id string) error {
+			return errors.WithStack(sql.ErrNoRows)
+		},
+	}
+
+	rs := revocation.Mock{
+		RevokeSessionFn: func(ctx context.Context, sessionID string) error {
+			return nil
+		},
+	}
+
+	s := NewService(
+		tracerMock,
+		rMock,
+		manager.Mock{},
+		generatorMock,
+		catalogMock,
+		rs,
+		apitoken.Mock{},
+		throttleNoop,
+		nil,
+		testConfig,
+	)
+	ctx := context.WithValue(context.Background(), jwtmiddleware.ContextKeyUserID, 3)
+
+	if _, err := s.RevokeSession(ctx, RevokeSessionRequest{ID: "s1"}); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+}
+
+func TestServiceUserSessions(t *testing.T) {
+	createdAt := time.Date(2021, 5, 10, 8, 0, 0, 0, time.UTC)
+	revokedUsers := make(map[int]bool)
+	revokedInDatabase := make(map[int]bool)
+	revokedAPITokens := make(map[int]bool)
+	deletedHashes := make([]string, 0)
+
+	rMock := repositoryMock{
+		AuthFn: func(ctx context.Context, login string) (*Auth, error) {
+			if login != "john" {
+				return nil, errors.WithStack(sql.ErrNoRows)
+			}
+
+			return &Auth{ID: 7, UserID: 3, Login: "john"}, nil
+		},
+		SessionsFn: func(ctx context.Context, uid int) ([]Session, error) {
+			if uid != 3 || revokedInDatabase[uid] {
+				return []Session{}, nil
+			}
+
+			return []Session{{ID: "s1", UserID: 3, UserAgent: "curl", IP: "10.0.0.1", CreatedAt: createdAt}}, nil
+		},
+		RevokeSessionsFn: func(ctx context.Context, uid int) error {
+			revokedInDatabase[uid] = true
+			return nil
+		},
+		APITokensFn: func(ctx context.Context, uid int) ([]APIToken, error) {
+			return []APIToken{{ID: 1, UserID: uid, TokenHash: "hash1"}}, nil
+		},
+		DeleteAPITokensFn: func(ctx context.Context, uid int) error {
+			revokedAPITokens[uid] = true
+			return nil
+		},
+	}
+
+	ts := apitoken.Mock{
+		DeleteFn: func(ctx context.Context, hash string) error {
+			deletedHashes = append(deletedHashes, hash)
+			return nil
+		},
+	}
+
+	rs := revocation.Mock{
+		LastSeenFn: func(ctx context.Context, sessionID string) (time.Time, error) {
+			return time.Time{}, nil
+		},
+		RevokeUserFn: func(ctx context.Context, uid int, at time.Time) error {
+			revokedUsers[uid] = true
+			return nil
+		},
+	}
+
+	s := NewService(
+		tracerMock,
+		rMock,
+		manager.Mock{},
+		generatorMock,
+		catalogMock,
+		rs,
+		ts,
+		throttleNoop,
+		nil,
+		testConfig,
+	)
+
+	// staff request sessions of another user, so none of them is current
+	ctx := context.WithValue(context.Background(), jwtmiddleware.ContextKeyUserID, 5)
+	ctx = context.WithValue(ctx, jwtmiddleware.ContextKeySessionID, "s1")
+
+	response, err := s.UserSessions(ctx, UserSessionsRequest{Login: "john"})
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	want := SessionItem{
+		ID:         "s1",
+		UserAgent:  "curl",
+		IP:         "10.0.0.1",
+		CreatedAt:  createdAt.Unix(),
+		LastSeenAt: createdAt.Unix(),
+	}
+	if len(response.Sessions) != 1 || response.Sessions[0] != want {
+		t.Fatalf("got: %+v, want: %+v", response.Sessions, want)
+	}
+
+	if _, err = s.RevokeUserSessions(ctx, UserSessionsRequest{Login: "john"}); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	if !revokedUsers[3] || !revokedInDatabase[3] {
+		t.Fatalf("got: %t/%t, want: tokens and sessions of user 3 are revoked", revokedUsers[3], revokedInDatabase[3])
+	}
+
+	if !revokedAPITokens[3] || len(deletedHashes) != 1 || deletedHashes[0] != "hash1" {
+		t.Fatalf("got: %t/%v, want: api tokens of user 3 are revoked", revokedAPITokens[3], deletedHashes)
+	}
+
+	_, err = s.UserSessions(ctx, UserSessionsRequest{Login: "jane"})
+	if status, _ := api.NewErrorResponse("", err); status != http.StatusNotFound {
+		t.Fatalf("got: %d, want: %d", status, http.StatusNotFound)
+	}
+
+	_, err = s.RevokeUserSessions(ctx, UserSessionsRequest{})
+	if status, _ := api.NewErrorResponse("", err); status != http.StatusBadRequest {
+		t.Fatalf("got: %d, want: %d", status, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gitlab.com/slirx/newproj/pkg/api"
+	"gitlab.com/slirx/newproj/pkg/redis"
+)
+
+// Scopes of login throttling. Users and admins share the same counters, so failures of admin panel lock the user
+// out of the site too. Codes of the second factor have their own counters.
+const (
+	throttleScopeUser     = "user"
+	throttleScopeInternal = "internal"
+	throttleScopeMFA      = "mfa"
+	// throttleScopePasswordReset limits password reset emails, it's used with Limit only.
+	throttleScopePasswordReset = "password_reset"
+)
+
+// CodeAccountLocked is returned in case login is temporarily locked after too many failed attempts.
+const CodeAccountLocked api.Code = "account_locked"
+
+// dummyPasswordHash is compared with the password in case login doesn't exist. Response time of unknown login is
+// the same as of wrong password, so it can't be used to find out registered logins. It has the same cost as
+// hashes of real passwords.
+const dummyPasswordHash = "$2a$10$yEhxighkKFDkjqTRVDBo.OUK0YIp9BsUA3Y8KqJcKVf76Tg5TYj5W"
+
+var (
+	errTooManyAttempts = errors.New("too many failed login attempts. try again later")
+	errTooManyRequests = errors.New("too many requests. try again later")
+)
+
+// ThrottleConfig represents configuration of login throttling.
+type ThrottleConfig struct {
+	// FreeAttempts is the number of failed attempts of a login which are allowed without a delay.
+	FreeAttempts int
+	// BaseDelay is the delay after the first failed attempt above FreeAttempts. It doubles with every next failure.
+	BaseDelay time.Duration
+	// MaxDelay is the upper limit of the delay.
+	MaxDelay time.Duration
+	// LockoutAttempts is the number of failed attempts after which the login is locked.
+	LockoutAttempts int
+	// LockoutDuration is how long the login stays locked.
+	LockoutDuration time.Duration
+	// IPAttempts is the number of attempts from one IP address during Window, after which all logins from it are
+	// rejected. Successful attempts are counted too.
+	IPAttempts int
+	// Window is the period during which failed attempts are counted.
+	Window time.Duration
+}
+
+// DefaultThrottleConfig is used in case configuration doesn't override it.
+var DefaultThrottleConfig = ThrottleConfig{
+	FreeAttempts:    3,
+	BaseDelay:       time.Second,
+	MaxDelay:        time.Minute,
+	LockoutAttempts: 10,
+	LockoutDuration: 15 * time.Minute,
+	IPAttempts:      100,
+	Window:          15 * time.Minute,
+}
+
+// Throttle protects login endpoints from brute-force. Attempts are counted per login and per IP address.
+// Every failure above the free ones delays the next attempt, too many failures lock the login for a while.
+type Throttle interface {
+	// Attempt registers attempt of the login before the password is checked. It returns error with too many
+	// requests status in case login or ip is not allowed to try now. The attempt is counted as failed until
+	// Succeed is called, so concurrent requests can't check more passwords than the limits allow.
+	Attempt(ctx context.Context, scope string, login string, ip string) error
+	// Fail registers result of failed attempt. It returns duration of the lockout in case the login has just
+	// been locked and zero otherwise.
+	Fail(ctx context.Context, scope string, login string, ip string) (time.Duration, error)
+	// Succeed resets failed attempts of the login.
+	Succeed(ctx context.Context, scope string, login string) error
+	// Limit counts request of id, for example email or IP address, regardless of its result. It returns error with
+	// too many requests status in case id has made more than limit requests during window.
+	Limit(ctx context.Context, scope string, id string, limit int, window time.Duration) error
+}
+
+type redisThrottle struct {
+	Redis  redis.Client
+	Config ThrottleConfig
+	now    func() time.Time
+}
+
+// Attempt increments counters before they are compared with the limits, so every concurrent request gets its own
+// number and the ones above the limits are rejected. Delay between attempts is checked beforehand, it only slows
+// down sequential attempts.
+func (t redisThrottle) Attempt(ctx context.Context, scope string, login string, ip string) error {
+	now := t.now()
+
+	lockedUntil, err := t.unixTime(ctx, t.key(scope, "locked", login))
+	if err != nil {
+		return err
+	}
+
+	if lockedUntil.After(now) {
+		return lockedError(lockedUntil.Sub(now))
+	}
+
+	next, err := t.unixTime(ctx, t.key(scope, "next", login))
+	if err != nil {
+		return err
+	}
+
+	if next.After(now) {
+		return api.NewTooManyRequestsError(errTooManyAttempts, next.Sub(now))
+	}
+
+	if ip != "" {
+		attempts, err := t.Redis.Incr(ctx, t.key(scope, "ip", ip), t.Config.Window)
+		if err != nil {
+			return err
+		}
+
+		if int(attempts) > t.Config.IPAttempts {
+			return api.NewTooManyRequestsError(errTooManyAttempts, t.Config.Window)
+		}
+	}
+
+	failures, err := t.Redis.Incr(ctx, t.key(scope, "failures", login), t.Config.Window)
+	if err != nil {
+		return err
+	}
+
+	// the login is being locked by one of concurrent attempts
+	if int(failures) > t.Config.LockoutAttempts {
+		return lockedError(t.Config.LockoutDuration)
+	}
+
+	return nil
+}
+
+func (t redisThrottle) Fail(ctx context.Context, scope string, login string, ip string) (time.Duration, error) {
+	value, err := t.Redis.Get(ctx, t.key(scope, "failures", login))
+	if err != nil && !errors.Is(err, redis.ErrNoData) {
+		return 0, err
+	}
+
+	// the attempt has already been counted by Attempt
+	failures, _ := strconv.Atoi(value)
+	now := t.now()
+
+	if failures >= t.Config.LockoutAttempts {
+		until := now.Add(t.Config.LockoutDuration)
+
+		if err = t.Redis.Set(ctx, t.key(scope, "locked", login), until.Unix(), t.Config.LockoutDuration); err != nil {
+			return 0, err
+		}
+
+		// counting starts from scratch after the lockout
+		return t.Config.LockoutDuration, t.Succeed(ctx, scope, login)
+	}
+
+	delay := t.delay(failures)
+	if delay == 0 {
+		return 0, nil
+	}
+
+	return 0, t.Redis.Set(ctx, t.key(scope, "next", login), now.Add(delay).Unix(), delay)
+}
+
+func (t redisThrottle) Succeed(ctx context.Context, scope string, login string) error {
+	return t.Redis.Del(ctx, t.key(scope, "failures", login), t.key(scope, "next", login))
+}
+
+func (t redisThrottle) Limit(ctx context.Context, scope string, id string, limit int, window time.Duration) error {
+	requests, err := t.Redis.Incr(ctx, t.key(scope, "requests", id), window)
+	if err != nil {
+		return err
+	}
+
+	if int(requests) > limit {
+		return api.NewTooManyRequestsError(errTooManyRequests, window)
+	}
+
+	return nil
+}
+
+// delay returns delay after failures failed attempts in a row.
+func (t redisThrottle) delay(failures int) time.Duration {
+	n := failures - t.Config.FreeAttempts
+	if n <= 0 {
+		return 0
+	}
+
+	delay := t.Config.BaseDelay
+	for i := 1; i < n && delay < t.Config.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > t.Config.MaxDelay {
+		return t.Config.MaxDelay
+	}
+
+	return delay
+}
+
+// unixTime returns time stored at key. Zero time is returned in case key doesn't exist.
+func (t redisThrottle) unixTime(ctx context.Context, key string) (time.Time, error) {
+	value, err := t.Redis.Get(ctx, key)
+	if err != nil {
+		if errors.Is(err, redis.ErrNoData) {
+			return time.Time{}, nil
+		}
+
+		return time.Time{}, err
+	}
+
+	unix, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, errors.WithStack(err)
+	}
+
+	return time.Unix(unix, 0), nil
+}
+
+func (t redisThrottle) key(scope string, kind string, id string) string {
+	return fmt.Sprintf("auth:throttle:%s:%s:%s", scope, kind, strings.ToLower(id))
+}
+
+// lockedError returns error of locked login. retryAfter is the time left until the login is unlocked.
+func lockedError(retryAfter time.Duration) error {
+	return api.NewTooManyRequestsErrorWithCode(
+		CodeAccountLocked,
+		errors.New("account is temporarily locked because of too many failed login attempts"),
+		retryAfter,
+	)
+}
+
+// NewThrottle returns Throttle which keeps counters in redis.
+func NewThrottle(r redis.Client, config ThrottleConfig) Throttle {
+	return redisThrottle{Redis: r, Config: config, now: time.Now}
+}
@@ -0,0 +1,323 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+
+	"gitlab.com/slirx/newproj/pkg/api"
+	"gitlab.com/slirx/newproj/pkg/apitoken"
+	"gitlab.com/slirx/newproj/pkg/queue"
+	"gitlab.com/slirx/newproj/pkg/queue/manager"
+	"gitlab.com/slirx/newproj/pkg/redis"
+	"gitlab.com/slirx/newproj/pkg/revocation"
+)
+
+// newRedisMock returns redis mock which keeps data in memory. Expiration is ignored.
+func newRedisMock(data map[string]string) redis.Mock {
+	return redis.Mock{
+		SetFn: func(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+			data[key] = fmt.Sprint(value)
+
+			return nil
+		},
+		GetFn: func(ctx context.Context, key string) (string, error) {
+			value, ok := data[key]
+			if !ok {
+				return "", redis.ErrNoData
+			}
+
+			return value, nil
+		},
+		IncrFn: func(ctx context.Context, key string, expiration time.Duration) (int64, error) {
+			n, _ := strconv.ParseInt(data[key], 10, 64)
+			n++
+			data[key] = strconv.FormatInt(n, 10)
+
+			return n, nil
+		},
+		DelFn: func(ctx context.Context, keys ...string) error {
+			for _, key := range keys {
+				delete(data, key)
+			}
+
+			return nil
+		},
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	data := make(map[string]string)
+	now := time.Date(2021, 5, 10, 8, 0, 0, 0, time.UTC)
+
+	th := redisThrottle{
+		Redis: newRedisMock(data),
+		Config: ThrottleConfig{
+			FreeAttempts:    2,
+			BaseDelay:       time.Second,
+			MaxDelay:        4 * time.Second,
+			LockoutAttempts: 6,
+			LockoutDuration: 15 * time.Minute,
+			IPAttempts:      8,
+			Window:          15 * time.Minute,
+		},
+		now: func() time.Time {
+			return now
+		},
+	}
+	ctx := context.Background()
+
+	// the last failure locks the login, so there is no delay after it
+	wantDelays := []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second}
+
+	for i, want := range wantDelays {
+		if err := th.Attempt(ctx, throttleScopeUser, "John", "10.0.0.1"); err != nil {
+			t.Fatalf("%d: got: %s, want: nil", i, err.Error())
+		}
+
+		lockedFor, err := th.Fail(ctx, throttleScopeUser, "john", "10.0.0.1")
+		if err != nil || lockedFor != 0 {
+			t.Fatalf("%d: got: %s, %v, want: 0, nil", i, lockedFor, err)
+		}
+
+		if want == 0 {
+			continue
+		}
+
+		status, response := api.NewErrorResponse("", th.Attempt(ctx, throttleScopeUser, "john", "10.0.0.2"))
+		if status != http.StatusTooManyRequests || response.Code != api.CodeTooManyRequests {
+			t.Fatalf("%d: got: %d/%s, want: 429/too_many_requests", i, status, response.Code)
+		}
+
+		now = now.Add(want)
+	}
+
+	if err := th.Attempt(ctx, throttleScopeUser, "john", "10.0.0.1"); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	lockedFor, err := th.Fail(ctx, throttleScopeUser, "john", "10.0.0.1")
+	if err != nil || lockedFor != 15*time.Minute {
+		t.Fatalf("got: %s, %v, want: 15m, nil", lockedFor, err)
+	}
+
+	status, response := api.NewErrorResponse("", th.Attempt(ctx, throttleScopeUser, "john", "10.0.0.2"))
+	if status != http.StatusTooManyRequests || response.Code != CodeAccountLocked {
+		t.Fatalf("got: %d/%s, want: %d/%s", status, response.Code, http.StatusTooManyRequests, CodeAccountLocked)
+	}
+
+	// other scopes and logins are not affected
+	if err = th.Attempt(ctx, throttleScopeInternal, "john", "10.0.0.2"); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	now = now.Add(15 * time.Minute)
+
+	if err = th.Attempt(ctx, throttleScopeUser, "john", "10.0.0.2"); err != nil {
+		t.Fatalf("got: %s, want: nil after the lockout", err.Error())
+	}
+
+	// attempts of different logins from the same ip are counted together
+	for _, login := range []string{"jane", "jack"} {
+		if err = th.Attempt(ctx, throttleScopeUser, login, "10.0.0.1"); err != nil {
+			t.Fatalf("got: %s, want: nil", err.Error())
+		}
+	}
+
+	if err = th.Attempt(ctx, throttleScopeUser, "jill", "10.0.0.1"); err == nil {
+		t.Fatalf("got: nil, want: error about too many attempts from ip")
+	}
+
+	// successful login resets failures
+	if err = th.Attempt(ctx, throttleScopeUser, "jill", ""); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	if err = th.Succeed(ctx, throttleScopeUser, "Jill"); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	if _, ok := data[th.key(throttleScopeUser, "failures", "jill")]; ok {
+		t.Fatalf("got: failures of jill, want: nothing")
+	}
+}
+
+func TestThrottleConcurrentAttempts(t *testing.T) {
+	th := redisThrottle{
+		Redis:  newRedisMock(make(map[string]string)),
+		Config: DefaultThrottleConfig,
+		now:    time.Now,
+	}
+	ctx := context.Background()
+
+	// results of attempts are not known yet, but attempts above the lockout limit are rejected anyway
+	for i := 0; i < DefaultThrottleConfig.LockoutAttempts; i++ {
+		if err := th.Attempt(ctx, throttleScopeUser, "john", "10.0.0.1"); err != nil {
+			t.Fatalf("%d: got: %s, want: nil", i, err.Error())
+		}
+	}
+
+	status, response := api.NewErrorResponse("", th.Attempt(ctx, throttleScopeUser, "john", "10.0.0.1"))
+	if status != http.StatusTooManyRequests || response.Code != CodeAccountLocked {
+		t.Fatalf("got: %d/%s, want: %d/%s", status, response.Code, http.StatusTooManyRequests, CodeAccountLocked)
+	}
+}
+
+func TestServiceLoginLockout(t *testing.T) {
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	rMock := repositoryMock{
+		AuthFn: func(ctx context.Context, login string) (*Auth, error) {
+			if login != "john" {
+				return nil, errors.WithStack(sql.ErrNoRows)
+			}
+
+			return &Auth{ID: 7, UserID: 3, Email: "john@example.com", Login: login, Password: string(passwordHash)}, nil
+		},
+	}
+
+	failures := make(map[string]int)
+	th := throttleMock{
+		AttemptFn: func(ctx context.Context, scope string, login string, ip string) error {
+			if ip != "10.0.0.1" {
+				t.Fatalf("got: %s, want: 10.0.0.1", ip)
+			}
+
+			return nil
+		},
+		FailFn: func(ctx context.Context, scope string, login string, ip string) (time.Duration, error) {
+			failures[login]++
+			if failures[login] == 2 {
+				return 15 * time.Minute, nil
+			}
+
+			return 0, nil
+		},
+	}
+
+	emails := make([]queue.Email, 0)
+	m := manager.Mock{
+		SendFn: func(ctx context.Context, routingKey string, msg interface{}) error {
+			emails = append(emails, msg.(queue.Email))
+
+			return nil
+		},
+	}
+
+	s := NewService(
+		tracerMock,
+		rMock,
+		m,
+		generatorMock,
+		catalogMock,
+		revocation.Mock{},
+		apitoken.Mock{},
+		th,
+		nil,
+		testConfig,
+	)
+	ctx := context.Background()
+
+	// unknown login is counted and locked the same way as the existing one, but nobody is notified
+	cases := []struct {
+		login      string
+		wantStatus int
+	}{
+		{login: "john", wantStatus: http.StatusBadRequest},
+		{login: "jane", wantStatus: http.StatusBadRequest},
+		{login: "jane", wantStatus: http.StatusTooManyRequests},
+	}
+
+	for _, c := range cases {
+		_, err = s.Login(ctx, LoginRequest{Login: c.login, Password: "invalid", Locale: "de", IP: "10.0.0.1"})
+		if status, _ := api.NewErrorResponse("", err); status != c.wantStatus {
+			t.Fatalf("%s: got: %d, want: %d", c.login, status, c.wantStatus)
+		}
+	}
+
+	if len(emails) != 0 {
+		t.Fatalf("got: %d emails, want: 0", len(emails))
+	}
+
+	_, err = s.Login(ctx, LoginRequest{Login: "john", Password: "invalid", Locale: "de", IP: "10.0.0.1"})
+
+	status, response := api.NewErrorResponse("", err)
+	if status != http.StatusTooManyRequests || response.Code != CodeAccountLocked {
+		t.Fatalf("got: %d/%s, want: %d/%s", status, response.Code, http.StatusTooManyRequests, CodeAccountLocked)
+	}
+
+	if len(emails) != 1 {
+		t.Fatalf("got: %d emails, want: 1", len(emails))
+	}
+
+	if e := emails[0]; e.RecipientEmail != "john@example.com" || e.Subject != "de:auth.account_locked.subject" {
+		t.Fatalf("got: %s/%s, want: john@example.com/de:auth.account_locked.subject", e.RecipientEmail, e.Subject)
+	}
+}
+
+func TestServiceForgotPasswordTooManyRequests(t *testing.T) {
+	th := throttleMock{
+		LimitFn: redisThrottle{Redis: newRedisMock(make(map[string]string))}.Limit,
+	}
+
+	rMock := repositoryMock{
+		AuthByEmailFn: func(ctx context.Context, email string) (*Auth, error) {
+			return nil, errors.WithStack(sql.ErrNoRows)
+		},
+	}
+
+	s := NewService(
+		tracerMock,
+		rMock,
+		manager.Mock{},
+		generatorMock,
+		catalogMock,
+		revocation.Mock{},
+		apitoken.Mock{},
+		th,
+		nil,
+		testConfig,
+	)
+
+	request := ForgotPasswordRequest{Email: "john@example.com", IP: "10.0.0.1"}
+
+	if _, err := s.ForgotPassword(context.Background(), request); err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	// unknown emails are limited the same way as registered ones
+	_, err := s.ForgotPassword(context.Background(), request)
+
+	status, _ := api.NewErrorResponse("", err)
+	if status != http.StatusTooManyRequests {
+		t.Fatalf("got: %d, want: %d", status, http.StatusTooManyRequests)
+	}
+
+	// another email from the same address is limited after passwordResetIPRequests requests
+	for i := 0; i < passwordResetIPRequests-2; i++ {
+		request.Email = fmt.Sprintf("user%d@example.com", i)
+
+		if _, err := s.ForgotPassword(context.Background(), request); err != nil {
+			t.Fatalf("%d: got: %s, want: nil", i, err)
+		}
+	}
+
+	request.Email = "another@example.com"
+
+	_, err = s.ForgotPassword(context.Background(), request)
+
+	status, _ = api.NewErrorResponse("", err)
+	if status != http.StatusTooManyRequests {
+		t.Fatalf("got: %d, want: %d", status, http.StatusTooManyRequests)
+	}
+}
@@ -0,0 +1,241 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/module/apmzap"
+
+	"gitlab.com/slirx/newproj/internal/api/post"
+	"gitlab.com/slirx/newproj/internal/api/user"
+	"gitlab.com/slirx/newproj/pkg/logger"
+	"gitlab.com/slirx/newproj/pkg/queue"
+	"gitlab.com/slirx/newproj/pkg/queue/manager"
+	"gitlab.com/slirx/newproj/pkg/queue/worker"
+	"gitlab.com/slirx/newproj/pkg/redis"
+	"gitlab.com/slirx/newproj/pkg/template"
+	"gitlab.com/slirx/newproj/pkg/unsubscribe"
+)
+
+// Frequencies of email digest. They are the same as the ones stored in user service.
+const (
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// DigestConfig represents configuration of email digest.
+type DigestConfig struct {
+	// UnsubscribeURL is URL of unsubscribe endpoint of user service. Signed token is added to it as "token" parameter.
+	UnsubscribeURL string
+	// UnsubscribeSecret is a secret which unsubscribe tokens are signed with.
+	UnsubscribeSecret []byte
+}
+
+// DigestEmail represents data of digest templates.
+type DigestEmail struct {
+	Name           string
+	Login          string
+	Frequency      string // DigestDaily or DigestWeekly
+	Period         string // "day" or "week"
+	NewFollowers   int
+	Followers      []user.Follower // the latest new followers
+	NewPosts       int
+	Posts          []post.Post // the latest posts of followed users
+	UnsubscribeURL string
+}
+
+type digestHandler struct {
+	Logger            logger.Logger
+	Manager           manager.Manager
+	TemplateGenerator template.Generator
+	Catalog           template.Catalog
+	InternalUserAPI   user.API
+	InternalPostAPI   post.API
+	Redis             redis.Client
+	Config            DigestConfig
+}
+
+// Handle sends digest emails to all users who chose the frequency of the task. Users without any activity
+// during the period get nothing. Failure of one user's digest is logged and doesn't stop the others. Every
+// recipient is marked in redis before the digest is sent, so retry of the task, for example after failure of
+// user service in the middle of the list, skips users who have already got the digest.
+func (h digestHandler) Handle(ctx context.Context, msg amqp.Delivery) error {
+	task := queue.EmailDigest{}
+
+	if err := gob.NewDecoder(bytes.NewReader(msg.Body)).Decode(&task); err != nil {
+		return worker.Permanent(errors.WithStack(err))
+	}
+
+	tx := apm.TransactionFromContext(ctx)
+
+	body, err := json.Marshal(task)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	tx.Context.SetCustom("request_body", string(body))
+
+	var period string
+	var duration time.Duration
+
+	switch task.Frequency {
+	case DigestDaily:
+		period, duration = "day", 24*time.Hour
+	case DigestWeekly:
+		period, duration = "week", 7*24*time.Hour
+	default:
+		return worker.Permanent(errors.WithStack(fmt.Errorf("unknown digest frequency: %q", task.Frequency)))
+	}
+
+	since := time.Unix(task.ScheduledAt, 0).Add(-duration)
+	// the marker outlives all retries of the task, the next task of the frequency has another scheduled time
+	markerTTL := duration
+
+	var sent, failed int
+	latestUserID := 0
+
+	for {
+		recipients, err := h.InternalUserAPI.DigestRecipients(ctx, task.Frequency, latestUserID)
+		if err != nil {
+			return err
+		}
+
+		if len(recipients) == 0 {
+			break
+		}
+
+		for _, r := range recipients {
+			marker := fmt.Sprintf("email:digest:%s:%d:%d", task.Frequency, task.ScheduledAt, r.ID)
+
+			n, err := h.Redis.Incr(ctx, marker, markerTTL)
+			if err != nil {
+				return err
+			}
+
+			// the digest has already been handled by the previous attempt of the task
+			if n > 1 {
+				continue
+			}
+
+			ok, err := h.send(ctx, task, r, period, since)
+			if err != nil {
+				// retry of the task sends the digest again
+				if delErr := h.Redis.Del(ctx, marker); delErr != nil {
+					h.Logger.Error(delErr, apmzap.TraceContext(ctx)...)
+				}
+
+				failed++
+				h.Logger.Error(
+					errors.WithMessagef(err, "can not send %s digest to user %d", task.Frequency, r.ID),
+					apmzap.TraceContext(ctx)...,
+				)
+
+				continue
+			}
+
+			if ok {
+				sent++
+			}
+		}
+
+		latestUserID = recipients[len(recipients)-1].ID
+	}
+
+	h.Logger.Info(
+		fmt.Sprintf("%s digest: %d emails are sent, %d failed", task.Frequency, sent, failed),
+		apmzap.TraceContext(ctx)...,
+	)
+
+	tx.Result = "success"
+	tx.Outcome = "success"
+
+	return nil
+}
+
+// send builds digest of the recipient and sends it to email queue. It returns false in case there is nothing to send.
+func (h digestHandler) send(
+	ctx context.Context,
+	task queue.EmailDigest,
+	r user.DigestRecipient,
+	period string,
+	since time.Time,
+) (bool, error) {
+	followers, err := h.InternalUserAPI.NewFollowers(ctx, r.ID, since)
+	if err != nil {
+		return false, err
+	}
+
+	feed, err := h.InternalPostAPI.FeedSince(ctx, r.ID, since)
+	if err != nil {
+		return false, err
+	}
+
+	if followers.Total == 0 && feed.Total == 0 {
+		return false, nil
+	}
+
+	data := DigestEmail{
+		Name:           r.Name,
+		Login:          r.Login,
+		Frequency:      task.Frequency,
+		Period:         period,
+		NewFollowers:   followers.Total,
+		Followers:      followers.Followers,
+		NewPosts:       feed.Total,
+		Posts:          feed.Posts,
+		UnsubscribeURL: unsubscribe.URL(h.Config.UnsubscribeURL, h.Config.UnsubscribeSecret, r.ID),
+	}
+
+	htmlTemplate, err := h.TemplateGenerator.Generate(template.TypeHTML, "template/email/digest.html", r.Locale, data)
+	if err != nil {
+		return false, err
+	}
+
+	textTemplate, err := h.TemplateGenerator.Generate(template.TypeText, "template/email/digest.txt", r.Locale, data)
+	if err != nil {
+		return false, err
+	}
+
+	err = h.Manager.Send(ctx, queue.JobEmailSend, queue.Email{
+		RecipientEmail: r.Email,
+		Subject:        h.Catalog.Message(r.Locale, "email.digest.subject."+task.Frequency),
+		HTML:           htmlTemplate,
+		Text:           textTemplate,
+		UnsubscribeURL: data.UnsubscribeURL,
+		Locale:         template.NormalizeLocale(r.Locale),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func NewDigestHandler(
+	l logger.Logger,
+	m manager.Manager,
+	tg template.Generator,
+	c template.Catalog,
+	internalUserAPI user.API,
+	internalPostAPI post.API,
+	r redis.Client,
+	conf DigestConfig,
+) worker.Handler {
+	return digestHandler{
+		Logger:            l,
+		Manager:           m,
+		TemplateGenerator: tg,
+		Catalog:           c,
+		InternalUserAPI:   internalUserAPI,
+		InternalPostAPI:   internalPostAPI,
+		Redis:             r,
+		Config:            conf,
+	}
+}
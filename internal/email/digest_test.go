@@ -0,0 +1,252 @@
+package email
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gitlab.com/slirx/newproj/internal/api/post"
+	"gitlab.com/slirx/newproj/internal/api/user"
+	"gitlab.com/slirx/newproj/pkg/queue"
+	"gitlab.com/slirx/newproj/pkg/queue/manager"
+	"gitlab.com/slirx/newproj/pkg/queue/worker"
+	"gitlab.com/slirx/newproj/pkg/redis"
+	"gitlab.com/slirx/newproj/pkg/template"
+	"gitlab.com/slirx/newproj/pkg/unsubscribe"
+)
+
+func TestDigestHandlerHandle(t *testing.T) {
+	secret := []byte("secret")
+	scheduledAt := time.Date(2021, 5, 10, 8, 0, 0, 0, time.UTC)
+	wantSince := scheduledAt.Add(-24 * time.Hour)
+
+	pages := map[int][]user.DigestRecipient{
+		0: {
+			{ID: 1, Login: "john", Name: "John", Email: "john@example.com", Locale: "en"},
+			{ID: 2, Login: "quiet", Email: "quiet@example.com"},
+		},
+		2: {
+			{ID: 3, Login: "broken", Email: "broken@example.com"},
+			{ID: 4, Login: "hans", Email: "hans@example.com", Locale: "de_AT"},
+		},
+	}
+
+	userAPI := user.Mock{
+		DigestRecipientsFn: func(ctx context.Context, frequency string, latestUserID int) ([]user.DigestRecipient, error) {
+			if frequency != DigestDaily {
+				t.Fatalf("got: %s, want: %s", frequency, DigestDaily)
+			}
+
+			return pages[latestUserID], nil
+		},
+		NewFollowersFn: func(ctx context.Context, uid int, since time.Time) (*user.NewFollowers, error) {
+			if !since.Equal(wantSince) {
+				t.Fatalf("got: %s, want: %s", since, wantSince)
+			}
+
+			if uid == 1 || uid == 4 {
+				return &user.NewFollowers{Total: 1, Followers: []user.Follower{{UserID: 5, Login: "jane"}}}, nil
+			}
+
+			return &user.NewFollowers{}, nil
+		},
+	}
+
+	postAPI := post.Mock{
+		FeedSinceFn: func(ctx context.Context, uid int, since time.Time) (*post.Feed, error) {
+			switch uid {
+			case 1, 4:
+				return &post.Feed{
+					Total: 3,
+					Posts: []post.Post{{ID: 9, Text: "hello world", User: post.User{ID: 5, Login: "jane"}}},
+				}, nil
+			case 3:
+				return nil, errors.New("post service is unavailable")
+			}
+
+			return &post.Feed{}, nil
+		},
+	}
+
+	sent := make([]queue.Email, 0)
+	m := manager.Mock{
+		SendFn: func(ctx context.Context, routingKey string, msg interface{}) error {
+			if routingKey != queue.JobEmailSend {
+				t.Fatalf("got: %s, want: %s", routingKey, queue.JobEmailSend)
+			}
+
+			sent = append(sent, msg.(queue.Email))
+
+			return nil
+		},
+	}
+
+	// templates are rendered for real, paths are relative to the root of the repository
+	g := template.New()
+	tg := template.Mock{
+		GenerateFn: func(gType template.GeneratorType, fileName string, locale string, data interface{}) (string, error) {
+			return g.Generate(gType, "../../"+fileName, locale, data)
+		},
+	}
+
+	c, err := template.NewCatalog("../../template/messages")
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	markers := make(map[string]int64)
+
+	h := NewDigestHandler(loggerMock, m, tg, c, userAPI, postAPI, newMarkerMock(markers), DigestConfig{
+		UnsubscribeURL:    "https://example.com/user/unsubscribe",
+		UnsubscribeSecret: secret,
+	})
+
+	msg := newTestDelivery(t, queue.EmailDigest{Frequency: DigestDaily, ScheduledAt: scheduledAt.Unix()})
+
+	if err = h.Handle(newTestContext(t), msg); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	if len(sent) != 2 {
+		t.Fatalf("got: %d emails, want: 2", len(sent))
+	}
+
+	e := sent[0]
+	if e.RecipientEmail != "john@example.com" || e.Subject != "Your daily digest" || e.Locale != "en" {
+		t.Fatalf("got: %s/%s/%s, want: john@example.com/Your daily digest/en", e.RecipientEmail, e.Subject, e.Locale)
+	}
+
+	for _, want := range []string{"@jane", "hello world", "New followers: 1", "daily digest"} {
+		if !strings.Contains(e.HTML, want) || !strings.Contains(e.Text, want) {
+			t.Fatalf("%q is missing in email:\n%s\n%s", want, e.HTML, e.Text)
+		}
+	}
+
+	u, err := url.Parse(e.UnsubscribeURL)
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	uid, err := unsubscribe.ParseToken(secret, u.Query().Get("token"))
+	if err != nil || uid != 1 {
+		t.Fatalf("got: %d, %v, want: 1, nil", uid, err)
+	}
+
+	// german templates and subject are used for "de-at" locale
+	e = sent[1]
+	if e.Subject != "Deine tägliche Zusammenfassung" || e.Locale != "de-at" {
+		t.Fatalf("got: %s/%s, want: Deine tägliche Zusammenfassung/de-at", e.Subject, e.Locale)
+	}
+
+	if !strings.Contains(e.HTML, "Neue Follower: 1") || !strings.Contains(e.Text, "täglichen Zusammenfassung") {
+		t.Fatalf("email is not localized:\n%s\n%s", e.HTML, e.Text)
+	}
+
+	// marker of the failed recipient is removed, so retry of the task sends the digest to this user
+	if len(markers) != 3 || markers["email:digest:daily:1620633600:3"] != 0 {
+		t.Fatalf("got: %v, want: markers of users 1, 2 and 4", markers)
+	}
+}
+
+func TestDigestHandlerRetry(t *testing.T) {
+	usersFailed := true
+	userAPI := user.Mock{
+		DigestRecipientsFn: func(ctx context.Context, frequency string, latestUserID int) ([]user.DigestRecipient, error) {
+			switch latestUserID {
+			case 0:
+				return []user.DigestRecipient{{ID: 1, Email: "john@example.com"}}, nil
+			case 1:
+				if usersFailed {
+					return nil, errors.New("user service is unavailable")
+				}
+
+				return []user.DigestRecipient{{ID: 2, Email: "jane@example.com"}}, nil
+			}
+
+			return nil, nil
+		},
+		NewFollowersFn: func(ctx context.Context, uid int, since time.Time) (*user.NewFollowers, error) {
+			return &user.NewFollowers{Total: 1}, nil
+		},
+	}
+	postAPI := post.Mock{
+		FeedSinceFn: func(ctx context.Context, uid int, since time.Time) (*post.Feed, error) {
+			return &post.Feed{}, nil
+		},
+	}
+
+	sent := make([]string, 0)
+	m := manager.Mock{
+		SendFn: func(ctx context.Context, routingKey string, msg interface{}) error {
+			sent = append(sent, msg.(queue.Email).RecipientEmail)
+			return nil
+		},
+	}
+	tg := template.Mock{
+		GenerateFn: func(gType template.GeneratorType, fileName string, locale string, data interface{}) (string, error) {
+			return "digest", nil
+		},
+	}
+	c := template.CatalogMock{
+		MessageFn: func(locale string, key string, args ...interface{}) string {
+			return key
+		},
+	}
+
+	h := NewDigestHandler(loggerMock, m, tg, c, userAPI, postAPI, newMarkerMock(make(map[string]int64)), DigestConfig{})
+	task := queue.EmailDigest{Frequency: DigestDaily, ScheduledAt: 1620633600}
+
+	if err := h.Handle(newTestContext(t), newTestDelivery(t, task)); err == nil {
+		t.Fatal("got: nil, want: error")
+	}
+
+	usersFailed = false
+
+	if err := h.Handle(newTestContext(t), newTestDelivery(t, task)); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	// the first recipient gets the digest only once
+	if len(sent) != 2 || sent[0] != "john@example.com" || sent[1] != "jane@example.com" {
+		t.Fatalf("got: %v, want: [john@example.com jane@example.com]", sent)
+	}
+}
+
+// newMarkerMock returns redis mock which keeps counters in markers.
+func newMarkerMock(markers map[string]int64) redis.Mock {
+	return redis.Mock{
+		IncrFn: func(ctx context.Context, key string, expiration time.Duration) (int64, error) {
+			markers[key]++
+			return markers[key], nil
+		},
+		DelFn: func(ctx context.Context, keys ...string) error {
+			for _, key := range keys {
+				delete(markers, key)
+			}
+
+			return nil
+		},
+	}
+}
+
+func TestDigestHandlerUnknownFrequency(t *testing.T) {
+	h := NewDigestHandler(
+		loggerMock,
+		manager.Mock{},
+		template.Mock{},
+		template.CatalogMock{},
+		user.Mock{},
+		post.Mock{},
+		redis.Mock{},
+		DigestConfig{},
+	)
+
+	err := h.Handle(newTestContext(t), newTestDelivery(t, queue.EmailDigest{Frequency: "hourly"}))
+	if !worker.IsPermanent(err) {
+		t.Fatalf("got: %v, want: permanent error", err)
+	}
+}
@@ -12,25 +12,118 @@ import (
 	"go.elastic.co/apm"
 	"go.elastic.co/apm/module/apmzap"
 
-	"gitlab.com/slirx/newproj/pkg/queue"
-
 	"gitlab.com/slirx/newproj/pkg/logger"
+	"gitlab.com/slirx/newproj/pkg/queue"
 	"gitlab.com/slirx/newproj/pkg/queue/worker"
 )
 
 type Handler struct {
-	Logger logger.Logger
+	Logger     logger.Logger
+	Sender     Sender
+	Repository Repository
+	Limiter    Limiter
+}
+
+type bounceHandler struct {
+	Logger     logger.Logger
+	Repository Repository
 }
 
 func (h Handler) Handle(ctx context.Context, msg amqp.Delivery) error {
 	task := queue.Email{}
 
 	if err := gob.NewDecoder(bytes.NewReader(msg.Body)).Decode(&task); err != nil {
+		// message can't be decoded on the next attempt either
+		return worker.Permanent(errors.WithStack(err))
+	}
+
+	tx := apm.TransactionFromContext(ctx)
+
+	// bodies and unsubscribe link contain password reset codes and signed tokens, so only recipient and subject
+	// get into APM
+	body, err := json.Marshal(struct {
+		RecipientEmail string
+		Subject        string
+	}{
+		RecipientEmail: task.RecipientEmail,
+		Subject:        task.Subject,
+	})
+	if err != nil {
 		return errors.WithStack(err)
 	}
 
+	tx.Context.SetCustom("request_body", string(body))
+
+	suppressed, err := h.Repository.IsSuppressed(ctx, task.RecipientEmail)
+	if err != nil {
+		return err
+	}
+
+	if suppressed {
+		h.Logger.Info(fmt.Sprintf("email to %s is skipped: address is suppressed", task.RecipientEmail),
+			apmzap.TraceContext(ctx)...)
+
+		tx.Result = "suppressed"
+		tx.Outcome = "success"
+
+		return nil
+	}
+
+	// transactional emails have their own higher limit, so password reset codes and security alerts are not dropped
+	// because of digests, but one address still can't be flooded with them
+	allowed, err := h.Limiter.AllowRecipient(ctx, task.RecipientEmail, task.Transactional())
+	if err != nil {
+		return err
+	}
+
+	if !allowed {
+		h.Logger.Info(fmt.Sprintf("email to %s is skipped: recipient rate limit is exceeded", task.RecipientEmail),
+			apmzap.TraceContext(ctx)...)
+
+		tx.Result = "rate limited"
+		tx.Outcome = "success"
+
+		return nil
+	}
+
+	if err = h.Limiter.Wait(ctx); err != nil {
+		return err
+	}
+
+	h.Logger.Debug(fmt.Sprintf("sending email to %s", task.RecipientEmail), apmzap.TraceContext(ctx)...)
+
+	if err = h.Sender.Send(ctx, task); err != nil {
+		if errors.Is(err, ErrRecipientRejected) {
+			// hard bounce, there is no sense to send anything to this address again
+			suppressErr := h.Repository.Suppress(ctx, task.RecipientEmail, ReasonRejected, err.Error())
+			if suppressErr != nil {
+				h.Logger.Error(suppressErr, apmzap.TraceContext(ctx)...)
+			}
+		}
+
+		if errors.Is(err, ErrPermanent) {
+			return worker.Permanent(err)
+		}
+
+		return err
+	}
+
+	tx.Result = "success"
+	tx.Outcome = "success"
+
+	return nil
+}
+
+// Handle adds address to suppression list in case of hard bounce or complaint. Soft bounces are only logged,
+// because the address is still valid.
+func (h bounceHandler) Handle(ctx context.Context, msg amqp.Delivery) error {
+	task := queue.EmailBounce{}
+
+	if err := gob.NewDecoder(bytes.NewReader(msg.Body)).Decode(&task); err != nil {
+		return worker.Permanent(errors.WithStack(err))
+	}
+
 	tx := apm.TransactionFromContext(ctx)
-	tx.Context.SetLabel("request_id", task.RequestID)
 
 	body, err := json.Marshal(task)
 	if err != nil {
@@ -39,8 +132,29 @@ func (h Handler) Handle(ctx context.Context, msg amqp.Delivery) error {
 
 	tx.Context.SetCustom("request_body", string(body))
 
-	// todo do your dirty work here..
-	h.Logger.Debug(fmt.Sprintf("sending email to %s; text: %s", task.RecipientEmail, task.Text), apmzap.TraceContext(ctx)...)
+	var reason string
+
+	switch task.Type {
+	case queue.BounceHard:
+		reason = ReasonBounce
+	case queue.BounceComplaint:
+		reason = ReasonComplaint
+	case queue.BounceSoft:
+		h.Logger.Info(fmt.Sprintf("soft bounce of %s: %s", task.RecipientEmail, task.Reason), apmzap.TraceContext(ctx)...)
+
+		tx.Result = "success"
+		tx.Outcome = "success"
+
+		return nil
+	default:
+		return worker.Permanent(errors.WithStack(fmt.Errorf("unknown bounce type: %q", task.Type)))
+	}
+
+	h.Logger.Debug(fmt.Sprintf("suppressing %s: %s", task.RecipientEmail, reason), apmzap.TraceContext(ctx)...)
+
+	if err = h.Repository.Suppress(ctx, task.RecipientEmail, reason, task.Reason); err != nil {
+		return err
+	}
 
 	tx.Result = "success"
 	tx.Outcome = "success"
@@ -48,6 +162,18 @@ func (h Handler) Handle(ctx context.Context, msg amqp.Delivery) error {
 	return nil
 }
 
-func NewHandler(l logger.Logger) worker.Handler {
-	return Handler{Logger: l}
+func NewHandler(l logger.Logger, s Sender, r Repository, limiter Limiter) worker.Handler {
+	return Handler{
+		Logger:     l,
+		Sender:     s,
+		Repository: r,
+		Limiter:    limiter,
+	}
+}
+
+func NewBounceHandler(l logger.Logger, r Repository) worker.Handler {
+	return bounceHandler{
+		Logger:     l,
+		Repository: r,
+	}
 }
@@ -0,0 +1,178 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/transport"
+	"go.uber.org/zap"
+
+	"gitlab.com/slirx/newproj/pkg/logger"
+	"gitlab.com/slirx/newproj/pkg/queue"
+	"gitlab.com/slirx/newproj/pkg/queue/worker"
+)
+
+func newTestContext(t *testing.T) context.Context {
+	t.Helper()
+
+	tracer, err := apm.NewTracerOptions(apm.TracerOptions{Transport: transport.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := tracer.StartTransaction("test", "test")
+	t.Cleanup(tx.End)
+
+	return apm.ContextWithTransaction(context.Background(), tx)
+}
+
+func newTestDelivery(t *testing.T, task interface{}) amqp.Delivery {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(task); err != nil {
+		t.Fatal(err)
+	}
+
+	return amqp.Delivery{Body: buf.Bytes()}
+}
+
+var loggerMock = logger.Mock{
+	DebugFn: func(msg string, fields ...zap.Field) {},
+	InfoFn:  func(msg string, fields ...zap.Field) {},
+	ErrorFn: func(err error, fields ...zap.Field) {},
+}
+
+func TestHandlerHandle(t *testing.T) {
+	rejected := fmt.Errorf("%w: 550 no such user", ErrRecipientRejected)
+
+	cases := []struct {
+		name          string
+		transactional bool
+		suppressed    bool
+		allowed       bool
+		sendErr       error
+		wantSent      bool
+		wantSuppress  bool
+		wantErr       bool
+		wantRetry     bool
+	}{
+		{name: "sent", allowed: true, wantSent: true},
+		{name: "suppressed", suppressed: true, allowed: true},
+		{name: "rate limited", allowed: false},
+		{name: "transactional", transactional: true, allowed: true, wantSent: true},
+		{name: "transactional rate limited", transactional: true, allowed: false},
+		{name: "rejected", allowed: true, sendErr: rejected, wantSent: true, wantSuppress: true, wantErr: true},
+		{name: "transient", allowed: true, sendErr: errors.New("timeout"), wantSent: true, wantErr: true, wantRetry: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sent := false
+			suppressed := ""
+
+			r := repositoryMock{
+				IsSuppressedFn: func(ctx context.Context, email string) (bool, error) {
+					return c.suppressed, nil
+				},
+				SuppressFn: func(ctx context.Context, email string, reason string, details string) error {
+					suppressed = email + ":" + reason
+					return nil
+				},
+			}
+
+			s := senderMock{
+				SendFn: func(ctx context.Context, e queue.Email) error {
+					sent = true
+					return c.sendErr
+				},
+			}
+
+			l := limiterMock{
+				AllowRecipientFn: func(ctx context.Context, email string, transactional bool) (bool, error) {
+					if transactional != c.transactional {
+						t.Fatalf("got transactional: %v, want: %v", transactional, c.transactional)
+					}
+
+					return c.allowed, nil
+				},
+				WaitFn: func(ctx context.Context) error {
+					return nil
+				},
+			}
+
+			h := NewHandler(loggerMock, s, r, l)
+
+			e := queue.Email{RecipientEmail: "user@example.com", Text: "digest", UnsubscribeURL: "https://example.com"}
+			if c.transactional {
+				e = queue.Email{RecipientEmail: "user@example.com", Text: "code"}
+			}
+
+			msg := newTestDelivery(t, e)
+
+			err := h.Handle(newTestContext(t), msg)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("got error: %v, want error: %v", err, c.wantErr)
+			}
+
+			if err != nil && worker.IsPermanent(err) == c.wantRetry {
+				t.Fatalf("got permanent: %v, want retry: %v", worker.IsPermanent(err), c.wantRetry)
+			}
+
+			if sent != c.wantSent {
+				t.Fatalf("got sent: %v, want: %v", sent, c.wantSent)
+			}
+
+			if (suppressed != "") != c.wantSuppress {
+				t.Fatalf("got suppressed: %q, want suppress: %v", suppressed, c.wantSuppress)
+			}
+
+			if c.wantSuppress && suppressed != "user@example.com:"+ReasonRejected {
+				t.Fatalf("got suppressed: %q, want: %q", suppressed, "user@example.com:"+ReasonRejected)
+			}
+		})
+	}
+}
+
+func TestBounceHandlerHandle(t *testing.T) {
+	cases := []struct {
+		bounceType string
+		wantReason string
+		wantErr    bool
+	}{
+		{bounceType: queue.BounceHard, wantReason: ReasonBounce},
+		{bounceType: queue.BounceComplaint, wantReason: ReasonComplaint},
+		{bounceType: queue.BounceSoft},
+		{bounceType: "unknown", wantErr: true},
+	}
+
+	for _, c := range cases {
+		reason := ""
+
+		r := repositoryMock{
+			SuppressFn: func(ctx context.Context, email string, r string, details string) error {
+				reason = r
+				return nil
+			},
+		}
+
+		h := NewBounceHandler(loggerMock, r)
+
+		msg := newTestDelivery(t, queue.EmailBounce{RecipientEmail: "user@example.com", Type: c.bounceType})
+
+		err := h.Handle(newTestContext(t), msg)
+		if (err != nil) != c.wantErr {
+			t.Fatalf("%s: got error: %v, want error: %v", c.bounceType, err, c.wantErr)
+		}
+
+		if reason != c.wantReason {
+			t.Fatalf("%s: got reason: %q, want: %q", c.bounceType, reason, c.wantReason)
+		}
+	}
+}
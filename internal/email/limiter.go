@@ -0,0 +1,115 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gitlab.com/slirx/newproj/pkg/redis"
+)
+
+// RateLimit represents limits of sending. Zero limit disables the check.
+type RateLimit struct {
+	// PerRecipient is the maximum number of non-transactional emails sent to one address during PerRecipientPeriod.
+	PerRecipient       int
+	PerRecipientPeriod time.Duration
+	// TransactionalPerRecipient is the maximum number of transactional emails sent to one address during
+	// TransactionalPerRecipientPeriod. It's counted separately, so digests don't use up the limit of security emails.
+	TransactionalPerRecipient       int
+	TransactionalPerRecipientPeriod time.Duration
+	// Global is the maximum number of emails sent by all workers during GlobalPeriod.
+	Global       int
+	GlobalPeriod time.Duration
+}
+
+// Limiter limits sending rate. Counters are shared by all workers.
+type Limiter interface {
+	// AllowRecipient reports whether one more email can be sent to the address. Transactional emails have their
+	// own limit.
+	AllowRecipient(ctx context.Context, email string, transactional bool) (bool, error)
+	// Wait blocks until global limit allows sending one more email or ctx is done.
+	Wait(ctx context.Context) error
+}
+
+// redisLimiter counts emails in fixed time windows. Every window has its own counter which expires together with
+// the window.
+type redisLimiter struct {
+	Redis     redis.Client
+	RateLimit RateLimit
+	now       func() time.Time
+}
+
+func (l redisLimiter) AllowRecipient(ctx context.Context, email string, transactional bool) (bool, error) {
+	limit, period, prefix := l.RateLimit.PerRecipient, l.RateLimit.PerRecipientPeriod, "email:rate:recipient"
+	if transactional {
+		limit, period = l.RateLimit.TransactionalPerRecipient, l.RateLimit.TransactionalPerRecipientPeriod
+		prefix = "email:rate:transactional"
+	}
+
+	if limit <= 0 {
+		return true, nil
+	}
+
+	window, _ := l.window(period)
+	key := fmt.Sprintf("%s:%s:%d", prefix, normalizeEmail(email), window)
+
+	n, err := l.Redis.Incr(ctx, key, period)
+	if err != nil {
+		return false, err
+	}
+
+	return n <= int64(limit), nil
+}
+
+func (l redisLimiter) Wait(ctx context.Context) error {
+	if l.RateLimit.Global <= 0 {
+		return nil
+	}
+
+	for {
+		window, left := l.window(l.RateLimit.GlobalPeriod)
+		key := fmt.Sprintf("email:rate:global:%d", window)
+
+		n, err := l.Redis.Incr(ctx, key, l.RateLimit.GlobalPeriod)
+		if err != nil {
+			return err
+		}
+
+		if n <= int64(l.RateLimit.Global) {
+			return nil
+		}
+
+		select {
+		case <-time.After(left):
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		}
+	}
+}
+
+// window returns number of the current window and time left till the next one.
+func (l redisLimiter) window(period time.Duration) (int64, time.Duration) {
+	now := l.now().UnixNano()
+	window := now / int64(period)
+
+	return window, time.Duration((window+1)*int64(period) - now)
+}
+
+// NewLimiter returns Limiter which keeps counters in redis.
+func NewLimiter(r redis.Client, conf RateLimit) (Limiter, error) {
+	if conf.PerRecipient > 0 && conf.PerRecipientPeriod <= 0 {
+		return nil, errors.WithStack(errors.New("per recipient period should be positive"))
+	}
+
+	if conf.TransactionalPerRecipient > 0 && conf.TransactionalPerRecipientPeriod <= 0 {
+		return nil, errors.WithStack(errors.New("transactional per recipient period should be positive"))
+	}
+
+	if conf.Global > 0 && conf.GlobalPeriod <= 0 {
+		return nil, errors.WithStack(errors.New("global period should be positive"))
+	}
+
+	return redisLimiter{Redis: r, RateLimit: conf, now: time.Now}, nil
+}
@@ -0,0 +1,118 @@
+package email
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gitlab.com/slirx/newproj/pkg/redis"
+)
+
+// newRedisCounters returns redis mock which keeps counters in memory.
+func newRedisCounters() (redis.Client, map[string]int64) {
+	counters := make(map[string]int64)
+
+	return redis.Mock{
+		IncrFn: func(ctx context.Context, key string, expiration time.Duration) (int64, error) {
+			counters[key]++
+			return counters[key], nil
+		},
+	}, counters
+}
+
+func TestLimiterAllowRecipient(t *testing.T) {
+	r, _ := newRedisCounters()
+
+	now := time.Unix(600, 0)
+
+	l := redisLimiter{
+		Redis: r,
+		RateLimit: RateLimit{
+			PerRecipient:                    2,
+			PerRecipientPeriod:              time.Minute,
+			TransactionalPerRecipient:       3,
+			TransactionalPerRecipientPeriod: time.Minute,
+		},
+		now: func() time.Time {
+			return now
+		},
+	}
+
+	ctx := context.Background()
+
+	for i, want := range []bool{true, true, false, false} {
+		got, err := l.AllowRecipient(ctx, "User@Example.com", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != want {
+			t.Fatalf("email #%d: got allowed %v, want %v", i+1, got, want)
+		}
+	}
+
+	// counter is shared by all spellings of the address, but not by other addresses
+	if allowed, _ := l.AllowRecipient(ctx, "another@example.com", false); !allowed {
+		t.Fatal("another recipient is limited")
+	}
+
+	// transactional emails are counted separately with their own limit
+	for i, want := range []bool{true, true, true, false} {
+		got, err := l.AllowRecipient(ctx, "user@example.com", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != want {
+			t.Fatalf("transactional email #%d: got allowed %v, want %v", i+1, got, want)
+		}
+	}
+
+	now = now.Add(time.Minute)
+
+	if allowed, _ := l.AllowRecipient(ctx, "user@example.com", false); !allowed {
+		t.Fatal("recipient is limited in the next window")
+	}
+}
+
+func TestLimiterWait(t *testing.T) {
+	r, counters := newRedisCounters()
+
+	l, err := NewLimiter(r, RateLimit{Global: 1, GlobalPeriod: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if err = l.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// every email waited for its own window
+	windows := 0
+	for _, n := range counters {
+		if n >= 1 {
+			windows++
+		}
+	}
+
+	if windows < 3 {
+		t.Fatalf("want 3 windows at least; got %d", windows)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	l, _ = NewLimiter(redis.Mock{
+		IncrFn: func(ctx context.Context, key string, expiration time.Duration) (int64, error) {
+			return 2, nil
+		},
+	}, RateLimit{Global: 1, GlobalPeriod: time.Hour})
+
+	if err = l.Wait(ctx); err == nil {
+		t.Fatal("want error when ctx is done")
+	}
+}
@@ -0,0 +1,68 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gitlab.com/slirx/newproj/pkg/queue"
+)
+
+type maildirSender struct {
+	Dir      string
+	From     string
+	hostname string
+}
+
+// Send writes email into "new" subdirectory of the maildir. Message is written into "tmp" first and then moved, so
+// mail clients never see partially written messages.
+func (s maildirSender) Send(ctx context.Context, e queue.Email) error {
+	now := time.Now()
+
+	msg, err := buildMessage(s.From, e, now)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d.M%dP%dQ%d.%s", now.Unix(), now.Nanosecond()/1000, os.Getpid(),
+		atomic.AddUint64(&messageCounter, 1), s.hostname)
+
+	tmp := filepath.Join(s.Dir, "tmp", name)
+
+	if err = os.WriteFile(tmp, msg, 0o644); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err = os.Rename(tmp, filepath.Join(s.Dir, "new", name)); err != nil {
+		_ = os.Remove(tmp)
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// NewMaildirSender returns Sender which stores emails in maildir instead of sending them. It's used in development,
+// emails can be read by any mail client supporting maildir. Directory structure is created in case it doesn't exist.
+func NewMaildirSender(dir string, from string) (Sender, error) {
+	if _, err := parseAddress(from); err != nil {
+		return nil, err
+	}
+
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+
+	return maildirSender{Dir: dir, From: from, hostname: hostname}, nil
+}
@@ -0,0 +1,45 @@
+package email
+
+import (
+	"context"
+
+	"gitlab.com/slirx/newproj/pkg/queue"
+)
+
+var _ Repository = (*repositoryMock)(nil)
+var _ Sender = (*senderMock)(nil)
+var _ Limiter = (*limiterMock)(nil)
+
+type repositoryMock struct {
+	IsSuppressedFn func(ctx context.Context, email string) (bool, error)
+	SuppressFn     func(ctx context.Context, email string, reason string, details string) error
+}
+
+type senderMock struct {
+	SendFn func(ctx context.Context, e queue.Email) error
+}
+
+type limiterMock struct {
+	AllowRecipientFn func(ctx context.Context, email string, transactional bool) (bool, error)
+	WaitFn           func(ctx context.Context) error
+}
+
+func (r repositoryMock) IsSuppressed(ctx context.Context, email string) (bool, error) {
+	return r.IsSuppressedFn(ctx, email)
+}
+
+func (r repositoryMock) Suppress(ctx context.Context, email string, reason string, details string) error {
+	return r.SuppressFn(ctx, email, reason, details)
+}
+
+func (s senderMock) Send(ctx context.Context, e queue.Email) error {
+	return s.SendFn(ctx, e)
+}
+
+func (l limiterMock) AllowRecipient(ctx context.Context, email string, transactional bool) (bool, error) {
+	return l.AllowRecipientFn(ctx, email, transactional)
+}
+
+func (l limiterMock) Wait(ctx context.Context) error {
+	return l.WaitFn(ctx)
+}
@@ -0,0 +1,66 @@
+package email
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Reasons of suppression.
+const (
+	ReasonBounce    = "bounce"
+	ReasonComplaint = "complaint"
+	ReasonRejected  = "rejected" // recipient is rejected by smtp server
+)
+
+// Repository represents suppression list. Emails are not sent to suppressed addresses.
+type Repository interface {
+	IsSuppressed(ctx context.Context, email string) (bool, error)
+	// Suppress adds email to suppression list. Suppressing of already suppressed email keeps the first reason.
+	Suppress(ctx context.Context, email string, reason string, details string) error
+}
+
+type repository struct {
+	db *sql.DB
+}
+
+func (r repository) IsSuppressed(ctx context.Context, email string) (bool, error) {
+	var exists bool
+
+	err := r.db.QueryRowContext(
+		ctx,
+		`SELECT EXISTS(SELECT 1 FROM suppression WHERE email = $1)`,
+		normalizeEmail(email),
+	).Scan(&exists)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return exists, nil
+}
+
+func (r repository) Suppress(ctx context.Context, email string, reason string, details string) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO suppression (email, reason, details) VALUES ($1, $2, $3) ON CONFLICT (email) DO NOTHING`,
+		normalizeEmail(email),
+		reason,
+		details,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// normalizeEmail returns email in the form it's stored in suppression list and used in rate limiter keys.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func NewRepository(db *sql.DB) Repository {
+	return repository{db: db}
+}
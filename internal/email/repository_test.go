@@ -0,0 +1,63 @@
+package email
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func newDatabaseMock() (*sql.DB, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		log.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	return db, mock
+}
+
+func TestRepositoryIsSuppressed(t *testing.T) {
+	db, mock := newDatabaseMock()
+	repo := NewRepository(db)
+
+	rows := sqlmock.NewRows([]string{"exists"}).AddRow(true)
+	exec := regexp.QuoteMeta(`SELECT EXISTS(SELECT 1 FROM suppression WHERE email = $1)`)
+	mock.ExpectQuery(exec).WithArgs("user@example.com").WillReturnRows(rows)
+
+	suppressed, err := repo.IsSuppressed(context.Background(), " User@Example.com")
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	if !suppressed {
+		t.Fatal("got: false, want: true")
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRepositorySuppress(t *testing.T) {
+	db, mock := newDatabaseMock()
+	repo := NewRepository(db)
+
+	exec := regexp.QuoteMeta(
+		`INSERT INTO suppression (email, reason, details) VALUES ($1, $2, $3) ON CONFLICT (email) DO NOTHING`,
+	)
+	mock.ExpectExec(exec).
+		WithArgs("user@example.com", ReasonComplaint, "spam").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err := repo.Suppress(context.Background(), "user@example.com", ReasonComplaint, "spam")
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,160 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gitlab.com/slirx/newproj/pkg/queue"
+)
+
+// ErrPermanent is returned by Sender when email can't be delivered and retrying won't help, for example
+// when recipient address is invalid or rejected by the mail server.
+var ErrPermanent = errors.New("permanent delivery failure")
+
+// ErrRecipientRejected is returned by Sender when mail server rejects the recipient, for example because mailbox
+// doesn't exist. It's a permanent failure, so errors.Is(err, ErrPermanent) is true for it.
+var ErrRecipientRejected = fmt.Errorf("%w: recipient is rejected", ErrPermanent)
+
+// Sender delivers emails.
+type Sender interface {
+	// Send delivers email. Errors wrapping ErrPermanent mean that the email should not be retried,
+	// all other errors are transient.
+	Send(ctx context.Context, e queue.Email) error
+}
+
+// messageCounter is used for generating unique message ids.
+var messageCounter uint64
+
+// buildMessage builds RFC 5322 message from e. In case both HTML and text are set, message is multipart/alternative,
+// so mail client shows the best version it supports.
+func buildMessage(from string, e queue.Email, now time.Time) ([]byte, error) {
+	to, err := mail.ParseAddress(e.RecipientEmail)
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("%w: invalid recipient %q: %s", ErrPermanent, e.RecipientEmail, err))
+	}
+
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("invalid sender %q: %w", from, err))
+	}
+
+	var buf bytes.Buffer
+
+	header := []string{
+		"From: " + sender.String(),
+		"To: " + to.String(),
+		"Subject: " + mime.QEncoding.Encode("utf-8", e.Subject),
+		"Date: " + now.Format(time.RFC1123Z),
+		"Message-ID: " + messageID(sender.Address, now),
+		"MIME-Version: 1.0",
+	}
+
+	if e.Locale != "" {
+		header = append(header, "Content-Language: "+e.Locale)
+	}
+
+	if e.UnsubscribeURL != "" {
+		header = append(header,
+			"List-Unsubscribe: <"+e.UnsubscribeURL+">",
+			"List-Unsubscribe-Post: List-Unsubscribe=One-Click",
+		)
+	}
+
+	for _, h := range header {
+		buf.WriteString(h + "\r\n")
+	}
+
+	parts := make([]part, 0, 2)
+	if e.Text != "" {
+		parts = append(parts, part{contentType: "text/plain; charset=utf-8", body: e.Text})
+	}
+
+	if e.HTML != "" {
+		parts = append(parts, part{contentType: "text/html; charset=utf-8", body: e.HTML})
+	}
+
+	if len(parts) == 0 {
+		return nil, errors.WithStack(fmt.Errorf("%w: email has no body", ErrPermanent))
+	}
+
+	if len(parts) == 1 {
+		buf.WriteString("Content-Type: " + parts[0].contentType + "\r\n")
+		buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+		if err = writeQuotedPrintable(&buf, parts[0].body); err != nil {
+			return nil, err
+		}
+
+		return buf.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(&buf)
+	buf.WriteString("Content-Type: multipart/alternative; boundary=" + mw.Boundary() + "\r\n\r\n")
+
+	for _, p := range parts {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if err = writeQuotedPrintable(w, p.body); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = mw.Close(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+type part struct {
+	contentType string
+	body        string
+}
+
+func writeQuotedPrintable(w io.Writer, body string) error {
+	qp := quotedprintable.NewWriter(w)
+
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(qp.Close())
+}
+
+func messageID(from string, now time.Time) string {
+	domain := "localhost"
+	if i := strings.LastIndex(from, "@"); i >= 0 {
+		domain = from[i+1:]
+	}
+
+	return fmt.Sprintf("<%d.%d.%d@%s>", now.UnixNano(), os.Getpid(), atomic.AddUint64(&messageCounter, 1), domain)
+}
+
+// parseAddress returns address part of RFC 5322 address, for example "user@example.com" for "User <user@example.com>".
+func parseAddress(address string) (string, error) {
+	a, err := mail.ParseAddress(address)
+	if err != nil {
+		return "", errors.WithStack(fmt.Errorf("invalid address %q: %w", address, err))
+	}
+
+	return a.Address, nil
+}
@@ -0,0 +1,251 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/slirx/newproj/pkg/queue"
+)
+
+func TestBuildMessage(t *testing.T) {
+	e := queue.Email{
+		RecipientEmail: "user@example.com",
+		Subject:        "Подтверждение регистрации",
+		HTML:           "<p>code: 123</p>",
+		Text:           "code: 123",
+		UnsubscribeURL: "https://example.com/user/unsubscribe?token=1.abc",
+		Locale:         "ru",
+	}
+
+	raw, err := buildMessage("Microblog <no-reply@example.com>", e, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(string(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != e.Subject {
+		t.Fatalf("want subject %q; got %q (%v)", e.Subject, subject, err)
+	}
+
+	if got := msg.Header.Get("Content-Language"); got != e.Locale {
+		t.Fatalf("want Content-Language header %q; got %q", e.Locale, got)
+	}
+
+	if got := msg.Header.Get("List-Unsubscribe"); got != "<"+e.UnsubscribeURL+">" {
+		t.Fatalf("want List-Unsubscribe header %q; got %q", "<"+e.UnsubscribeURL+">", got)
+	}
+
+	if got := msg.Header.Get("List-Unsubscribe-Post"); got != "List-Unsubscribe=One-Click" {
+		t.Fatalf("want one-click List-Unsubscribe-Post header; got %q", got)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("want multipart/alternative; got %q (%v)", mediaType, err)
+	}
+
+	r := multipart.NewReader(msg.Body, params["boundary"])
+
+	var types []string
+
+	for {
+		p, err := r.NextPart()
+		if err != nil {
+			break
+		}
+
+		body, _ := ioutil.ReadAll(p) // quoted-printable is decoded by multipart reader
+		types = append(types, p.Header.Get("Content-Type")+": "+string(body))
+	}
+
+	want := []string{"text/plain; charset=utf-8: code: 123", "text/html; charset=utf-8: <p>code: 123</p>"}
+	if strings.Join(types, "|") != strings.Join(want, "|") {
+		t.Fatalf("want parts %v; got %v", want, types)
+	}
+}
+
+func TestBuildMessageInvalidRecipient(t *testing.T) {
+	_, err := buildMessage("no-reply@example.com", queue.Email{RecipientEmail: "not an address", Text: "1"}, time.Now())
+	if !errors.Is(err, ErrPermanent) {
+		t.Fatalf("want permanent error; got %v", err)
+	}
+}
+
+// smtpServer starts fake SMTP server which replies to RCPT TO with rcptReply.
+func smtpServer(t *testing.T, rcptReply string) (port int, received chan string) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+
+	received = make(chan string, 1)
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(s string) {
+			_, _ = conn.Write([]byte(s + "\r\n"))
+		}
+
+		reply("220 localhost ESMTP")
+
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "MAIL FROM"):
+				reply("250 ok")
+			case strings.HasPrefix(cmd, "RCPT TO"):
+				reply(rcptReply)
+			case cmd == "DATA":
+				reply("354 go ahead")
+
+				var data strings.Builder
+
+				for {
+					line, err = r.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+
+					data.WriteString(line)
+				}
+
+				received <- data.String()
+				reply("250 queued")
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("502 not implemented")
+			}
+		}
+	}()
+
+	return l.Addr().(*net.TCPAddr).Port, received
+}
+
+func newTestSMTPSender(t *testing.T, port int) Sender {
+	t.Helper()
+
+	s, err := NewSMTPSender(SMTPConfig{
+		Host:     "127.0.0.1",
+		Port:     port,
+		From:     "no-reply@example.com",
+		Security: SecurityNone,
+		Timeout:  time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestSMTPSenderSend(t *testing.T) {
+	port, received := smtpServer(t, "250 ok")
+	s := newTestSMTPSender(t, port)
+
+	err := s.Send(context.Background(), queue.Email{RecipientEmail: "user@example.com", Subject: "hi", Text: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data := <-received; !strings.Contains(data, "hello") {
+		t.Fatalf("unexpected message: %s", data)
+	}
+}
+
+func TestSMTPSenderErrors(t *testing.T) {
+	e := queue.Email{RecipientEmail: "user@example.com", Text: "1"}
+
+	port, _ := smtpServer(t, "550 no such user")
+
+	err := newTestSMTPSender(t, port).Send(context.Background(), e)
+	if !errors.Is(err, ErrRecipientRejected) || !errors.Is(err, ErrPermanent) {
+		t.Fatalf("want rejected recipient error on 5xx reply; got %v", err)
+	}
+
+	port, _ = smtpServer(t, "451 try again later")
+
+	err = newTestSMTPSender(t, port).Send(context.Background(), e)
+	if err == nil || errors.Is(err, ErrPermanent) {
+		t.Fatalf("want transient error on 4xx reply; got %v", err)
+	}
+
+	// nobody listens on the port of closed listener
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	port = l.Addr().(*net.TCPAddr).Port
+	_ = l.Close()
+
+	err = newTestSMTPSender(t, port).Send(context.Background(), e)
+	if err == nil || errors.Is(err, ErrPermanent) {
+		t.Fatalf("want transient error on refused connection; got %v", err)
+	}
+}
+
+func TestMaildirSenderSend(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := NewMaildirSender(dir, "no-reply@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		err = s.Send(context.Background(), queue.Email{RecipientEmail: "user@example.com", Text: "code " + strconv.Itoa(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := ioutil.ReadDir(filepath.Join(dir, "new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 2 {
+		t.Fatalf("want 2 emails in maildir; got %d", len(files))
+	}
+
+	if tmp, _ := ioutil.ReadDir(filepath.Join(dir, "tmp")); len(tmp) != 0 {
+		t.Fatalf("want empty tmp directory; got %d files", len(tmp))
+	}
+}
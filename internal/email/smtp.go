@@ -0,0 +1,188 @@
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gitlab.com/slirx/newproj/pkg/queue"
+)
+
+// Security modes of SMTP connection.
+const (
+	// SecurityNone sends emails over plain connection. It should be used only for local mail catchers.
+	SecurityNone = "none"
+	// SecuritySTARTTLS upgrades plain connection to TLS with STARTTLS command. Sending fails in case server doesn't
+	// support it.
+	SecuritySTARTTLS = "starttls"
+	// SecurityTLS uses implicit TLS (usually port 465).
+	SecurityTLS = "tls"
+)
+
+// defaultSMTPTimeout is used in case SMTPConfig.Timeout is not set.
+const defaultSMTPTimeout = 30 * time.Second
+
+// SMTPConfig represents configuration of SMTP server.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string // authentication is disabled in case username is empty
+	Password string
+	From     string // sender address, for example "Microblog <no-reply@example.com>"
+	Security string // one of SecurityNone, SecuritySTARTTLS or SecurityTLS
+	// Timeout limits duration of the whole SMTP session in case ctx has no deadline. Default value is 30 seconds.
+	Timeout time.Duration
+}
+
+type smtpSender struct {
+	Config SMTPConfig
+}
+
+func (s smtpSender) Send(ctx context.Context, e queue.Email) error {
+	msg, err := buildMessage(s.Config.From, e, time.Now())
+	if err != nil {
+		return err
+	}
+
+	timeout := s.Config.Timeout
+	if timeout <= 0 {
+		timeout = defaultSMTPTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	conn, err := s.dial(ctx)
+	if err != nil {
+		return err
+	}
+
+	deadline, _ := ctx.Deadline()
+	if err = conn.SetDeadline(deadline); err != nil {
+		_ = conn.Close()
+		return errors.WithStack(err)
+	}
+
+	c, err := smtp.NewClient(conn, s.Config.Host)
+	if err != nil {
+		_ = conn.Close()
+		return errors.WithStack(fmt.Errorf("can not start smtp session: %w", err))
+	}
+
+	defer c.Close()
+
+	if s.Config.Security == SecuritySTARTTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.WithStack(errors.New("smtp server doesn't support STARTTLS"))
+		}
+
+		if err = c.StartTLS(&tls.Config{ServerName: s.Config.Host}); err != nil {
+			return errors.WithStack(fmt.Errorf("can not start tls: %w", err))
+		}
+	}
+
+	if s.Config.Username != "" {
+		auth := smtp.PlainAuth("", s.Config.Username, s.Config.Password, s.Config.Host)
+
+		// authentication errors are problems of configuration, not of the email, so they are not permanent
+		if err = c.Auth(auth); err != nil {
+			return errors.WithStack(fmt.Errorf("can not authenticate: %w", err))
+		}
+	}
+
+	// addresses are validated by buildMessage
+	from, _ := parseAddress(s.Config.From)
+	to, _ := parseAddress(e.RecipientEmail)
+
+	if err = c.Mail(from); err != nil {
+		return classify("MAIL FROM", err)
+	}
+
+	if err = c.Rcpt(to); err != nil {
+		if isPermanentReply(err) {
+			return errors.WithStack(fmt.Errorf("%w: %s", ErrRecipientRejected, err))
+		}
+
+		return errors.WithStack(fmt.Errorf("RCPT TO: %w", err))
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return classify("DATA", err)
+	}
+
+	if _, err = w.Write(msg); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err = w.Close(); err != nil {
+		return classify("DATA", err)
+	}
+
+	// the email is accepted at this point, so failed QUIT is ignored
+	_ = c.Quit()
+
+	return nil
+}
+
+func (s smtpSender) dial(ctx context.Context) (net.Conn, error) {
+	addr := net.JoinHostPort(s.Config.Host, strconv.Itoa(s.Config.Port))
+	dialer := &net.Dialer{}
+
+	if s.Config.Security == SecurityTLS {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: s.Config.Host}}
+
+		conn, err := tlsDialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, errors.WithStack(fmt.Errorf("can not connect to smtp server: %w", err))
+		}
+
+		return conn, nil
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, errors.WithStack(fmt.Errorf("can not connect to smtp server: %w", err))
+	}
+
+	return conn, nil
+}
+
+// classify wraps err of SMTP command. Replies with 5xx code are permanent, all other errors (4xx replies, network
+// errors) are transient.
+func classify(command string, err error) error {
+	if isPermanentReply(err) {
+		return errors.WithStack(fmt.Errorf("%w: %s: %s", ErrPermanent, command, err))
+	}
+
+	return errors.WithStack(fmt.Errorf("%s: %w", command, err))
+}
+
+// isPermanentReply reports whether err is SMTP reply with 5xx code.
+func isPermanentReply(err error) bool {
+	var protoErr *textproto.Error
+
+	return errors.As(err, &protoErr) && protoErr.Code >= 500 && protoErr.Code < 600
+}
+
+// NewSMTPSender returns Sender which delivers emails via SMTP server.
+func NewSMTPSender(conf SMTPConfig) (Sender, error) {
+	switch conf.Security {
+	case SecurityNone, SecuritySTARTTLS, SecurityTLS:
+	default:
+		return nil, errors.WithStack(fmt.Errorf("unknown smtp security mode: %q", conf.Security))
+	}
+
+	if _, err := parseAddress(conf.From); err != nil {
+		return nil, err
+	}
+
+	return smtpSender{Config: conf}, nil
+}
@@ -0,0 +1,67 @@
+package email
+
+import (
+	"context"
+	"fmt"
+
+	"go.elastic.co/apm/module/apmzap"
+
+	"gitlab.com/slirx/newproj/pkg/event"
+	"gitlab.com/slirx/newproj/pkg/event/subscriber"
+	"gitlab.com/slirx/newproj/pkg/logger"
+	"gitlab.com/slirx/newproj/pkg/queue"
+	"gitlab.com/slirx/newproj/pkg/queue/manager"
+	"gitlab.com/slirx/newproj/pkg/template"
+)
+
+// WelcomeEmail represents data of welcome templates.
+type WelcomeEmail struct {
+	Login string
+}
+
+type welcomeHandler struct {
+	Logger            logger.Logger
+	Manager           manager.Manager
+	TemplateGenerator template.Generator
+	Catalog           template.Catalog
+}
+
+// Handle sends welcome email to the user who finished registration. Other events are ignored. Locale of the new
+// user is not known yet, so the email is written in default locale.
+func (h welcomeHandler) Handle(ctx context.Context, e event.Event) error {
+	registered, ok := e.(event.UserRegistered)
+	if !ok {
+		return nil
+	}
+
+	h.Logger.Debug(fmt.Sprintf("sending welcome email to user %d", registered.UserID), apmzap.TraceContext(ctx)...)
+
+	data := WelcomeEmail{Login: registered.Login}
+
+	htmlTemplate, err := h.TemplateGenerator.Generate(template.TypeHTML, "template/email/welcome.html", "", data)
+	if err != nil {
+		return err
+	}
+
+	textTemplate, err := h.TemplateGenerator.Generate(template.TypeText, "template/email/welcome.txt", "", data)
+	if err != nil {
+		return err
+	}
+
+	return h.Manager.Send(ctx, queue.JobEmailSend, queue.Email{
+		RecipientEmail: registered.Email,
+		Subject:        h.Catalog.Message(template.DefaultLocale, "email.welcome.subject"),
+		HTML:           htmlTemplate,
+		Text:           textTemplate,
+		Locale:         template.DefaultLocale,
+	})
+}
+
+func NewWelcomeHandler(l logger.Logger, m manager.Manager, tg template.Generator, c template.Catalog) subscriber.Handler {
+	return welcomeHandler{
+		Logger:            l,
+		Manager:           m,
+		TemplateGenerator: tg,
+		Catalog:           c,
+	}
+}
@@ -0,0 +1,170 @@
+package email
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/transport"
+
+	"gitlab.com/slirx/newproj/pkg/event"
+	"gitlab.com/slirx/newproj/pkg/event/publisher"
+	"gitlab.com/slirx/newproj/pkg/event/subscriber"
+	"gitlab.com/slirx/newproj/pkg/queue"
+	"gitlab.com/slirx/newproj/pkg/queue/manager"
+	"gitlab.com/slirx/newproj/pkg/queue/memory"
+	"gitlab.com/slirx/newproj/pkg/queue/worker"
+	"gitlab.com/slirx/newproj/pkg/template"
+)
+
+func TestWelcomeHandlerHandle(t *testing.T) {
+	sent := make([]queue.Email, 0)
+	m := manager.Mock{
+		SendFn: func(ctx context.Context, routingKey string, msg interface{}) error {
+			if routingKey != queue.JobEmailSend {
+				t.Fatalf("got: %s, want: %s", routingKey, queue.JobEmailSend)
+			}
+
+			sent = append(sent, msg.(queue.Email))
+
+			return nil
+		},
+	}
+
+	// templates are rendered for real, paths are relative to the root of the repository
+	g := template.New()
+	tg := template.Mock{
+		GenerateFn: func(gType template.GeneratorType, fileName string, locale string, data interface{}) (string, error) {
+			return g.Generate(gType, "../../"+fileName, locale, data)
+		},
+	}
+
+	c, err := template.NewCatalog("../../template/messages")
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	h := NewWelcomeHandler(loggerMock, m, tg, c)
+
+	events := []event.Event{
+		event.UserFollowed{UserID: 1, FollowUserID: 2},
+		event.UserRegistered{UserID: 1, Login: "john", Email: "john@example.com"},
+	}
+
+	for _, e := range events {
+		if err = h.Handle(context.Background(), e); err != nil {
+			t.Fatalf("got: %s, want: nil", err.Error())
+		}
+	}
+
+	// other events are ignored
+	if len(sent) != 1 {
+		t.Fatalf("got: %d emails, want: 1", len(sent))
+	}
+
+	e := sent[0]
+	if e.RecipientEmail != "john@example.com" || e.Subject != "Welcome to MicroBlog" || e.UnsubscribeURL != "" {
+		t.Fatalf("got: %+v, want: welcome email to john@example.com", e)
+	}
+
+	if !strings.Contains(e.HTML, "@john") || !strings.Contains(e.Text, "@john") {
+		t.Fatalf("login is missing in email:\n%s\n%s", e.HTML, e.Text)
+	}
+}
+
+// TestWelcomeEmailEndToEnd checks the whole path of welcome email through in-memory broker: the event is published,
+// welcome subscriber sends the email job and email worker delivers it.
+func TestWelcomeEmailEndToEnd(t *testing.T) {
+	b := memory.NewBroker()
+	m := manager.NewMemoryManager(b)
+
+	tracer, err := apm.NewTracerOptions(apm.TracerOptions{Transport: transport.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := template.NewCatalog("../../template/messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := template.New()
+	tg := template.Mock{
+		GenerateFn: func(gType template.GeneratorType, fileName string, locale string, data interface{}) (string, error) {
+			return g.Generate(gType, "../../"+fileName, locale, data)
+		},
+	}
+
+	sent := make(chan queue.Email, 1)
+	s := senderMock{
+		SendFn: func(ctx context.Context, e queue.Email) error {
+			sent <- e
+			return nil
+		},
+	}
+	r := repositoryMock{
+		IsSuppressedFn: func(ctx context.Context, email string) (bool, error) {
+			return false, nil
+		},
+	}
+	l := limiterMock{
+		AllowRecipientFn: func(ctx context.Context, email string, transactional bool) (bool, error) {
+			return true, nil
+		},
+		WaitFn: func(ctx context.Context) error {
+			return nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	welcome := subscriber.NewMemorySubscriber(
+		"email/welcome",
+		b,
+		"event:email/welcome",
+		loggerMock,
+		tracer,
+		NewWelcomeHandler(loggerMock, m, tg, c),
+		[]string{event.KeyUserRegistered},
+	)
+	h := NewHandler(loggerMock, s, r, l)
+	send := worker.NewMemoryWorker("email/send", b, loggerMock, tracer, h, queue.JobEmailSend)
+
+	stopped := make(chan struct{}, 2)
+
+	go func() {
+		welcome.Run(ctx)
+		stopped <- struct{}{}
+	}()
+
+	go func() {
+		send.Run(ctx)
+		stopped <- struct{}{}
+	}()
+
+	err = publisher.NewPublisher(m).Emit(ctx, event.UserRegistered{UserID: 1, Login: "john", Email: "john@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case e := <-sent:
+		if e.RecipientEmail != "john@example.com" || e.Subject != "Welcome to MicroBlog" {
+			t.Fatalf("got: %+v, want: welcome email to john@example.com", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("welcome email is not sent")
+	}
+
+	cancel()
+	<-stopped
+	<-stopped
+
+	for _, name := range []string{"event:email/welcome", queue.JobEmailSend} {
+		if ready, unacked := b.Len(name); ready != 0 || unacked != 0 {
+			t.Fatalf("got: %d ready, %d unacked messages in %s, want: empty queue", ready, unacked, name)
+		}
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"net/http"
 	"time"
 
 	"github.com/pkg/errors"
@@ -24,14 +23,15 @@ type UserService interface {
 
 func NewService(endpoints map[string]string, config *api.ServiceConfig) (*Service, error) {
 	// todo fetch token from /internal/auth/login
-	var err error
+	client, err := api.NewClient(config, 3*time.Second)
+	if err != nil {
+		return nil, err
+	}
 
 	a := api.GeneralAPI{
 		ServiceConfig: config,
-		Client: http.Client{
-			Timeout: 3 * time.Second,
-		},
-		Endpoints: endpoints,
+		Client:        client,
+		Endpoints:     endpoints,
 	}
 	if err = a.Login("auth"); err != nil {
 		return nil, err
@@ -70,8 +70,6 @@ func (a userAPI) User(ctx context.Context, login string) (*model.User, error) {
 		return nil, errors.WithStack(err)
 	}
 
-	// todo handle error. for example where there are no data field in response and we've got 500 error
-
 	return &response.Data, nil
 }
 
@@ -92,7 +90,5 @@ func (a userAPI) Users(ctx context.Context, latestUserID int) (*model.UsersRespo
 		return nil, errors.WithStack(err)
 	}
 
-	// todo handle error. for example where there are no data field in response and we've got 500 error
-
 	return &response.Data, nil
 }
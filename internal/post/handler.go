@@ -35,7 +35,6 @@ func (h followHandler) Handle(ctx context.Context, msg amqp.Delivery) error {
 	}
 
 	tx := apm.TransactionFromContext(ctx)
-	tx.Context.SetLabel("request_id", task.RequestID)
 
 	body, err := json.Marshal(task)
 	if err != nil {
@@ -68,7 +67,6 @@ func (h unfollowHandler) Handle(ctx context.Context, msg amqp.Delivery) error {
 	}
 
 	tx := apm.TransactionFromContext(ctx)
-	tx.Context.SetLabel("request_id", task.RequestID)
 
 	body, err := json.Marshal(task)
 	if err != nil {
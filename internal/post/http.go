@@ -23,6 +23,8 @@ type Handler interface {
 	Feed(w http.ResponseWriter, r *http.Request)
 	// Search searches across all posts in service.
 	Search(w http.ResponseWriter, r *http.Request)
+	// InternalFeedSince returns posts which appeared in user's feed since the given time.
+	InternalFeedSince(w http.ResponseWriter, r *http.Request)
 }
 
 type handler struct {
@@ -129,6 +131,33 @@ func (h handler) Search(w http.ResponseWriter, r *http.Request) {
 	h.ResponseBuilder.DataResponse(ctx, w, response)
 }
 
+func (h handler) InternalFeedSince(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil || userID == 0 {
+		err = api.NewRequestError(errors.New("invalid user id"))
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	since, _ := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+	request := FeedSinceRequest{
+		UserID: userID,
+		Since:  since,
+	}
+
+	response, err := h.Service.InternalFeedSince(ctx, request)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	h.ResponseBuilder.DataResponse(ctx, w, response)
+}
+
 // NewHandler returns instance of implemented Handler interface.
 func NewHandler(s Service, l logger.Logger, rb api.ResponseBuilder) Handler {
 	return handler{Service: s, Logger: l, ResponseBuilder: rb}
@@ -95,7 +95,7 @@ func TestHTTPListRequestError(t *testing.T) {
 	h.List(rec, r)
 
 	in := rec.Body.String()
-	want := `{"request_id":"req2","type":"error","message":"invalid request data"}
+	want := `{"request_id":"req2","type":"error","message":"invalid request data","code":"invalid_request","errors":[]}
 `
 
 	if in != want {
@@ -133,7 +133,7 @@ func TestHTTPListInvalidUserIDError(t *testing.T) {
 	h.List(rec, r)
 
 	in := rec.Body.String()
-	want := `{"request_id":"req1","type":"error","message":"invalid user id"}
+	want := `{"request_id":"req1","type":"error","message":"invalid user id","code":"invalid_request","errors":[]}
 `
 
 	if in != want {
@@ -173,7 +173,7 @@ func TestHTTPListServiceError(t *testing.T) {
 	h.List(rec, r)
 
 	in := rec.Body.String()
-	want := `{"request_id":"req1","type":"error","message":"oops, something went wrong"}
+	want := `{"request_id":"req1","type":"error","message":"oops, something went wrong","code":"internal_error","errors":[]}
 `
 
 	if in != want {
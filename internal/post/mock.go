@@ -14,16 +14,19 @@ type serviceMock struct {
 	CreateFn func(ctx context.Context, request CreateRequest) (*CreateResponse, error)
 	FeedFn   func(ctx context.Context, userID int, request FeedRequest) (*FeedResponse, error)
 	SearchFn func(ctx context.Context, request SearchRequest) (*SearchResponse, error)
+
+	InternalFeedSinceFn func(ctx context.Context, request FeedSinceRequest) (*FeedResponse, error)
 }
 
 type repositoryMock struct {
-	ListFn     func(ctx context.Context, request ListRequest, perPage int) (*ListResponse, error)
-	CreateFn   func(ctx context.Context, uid int, users []int, request CreateRequest) (*CreateResponse, error)
-	FeedFn     func(ctx context.Context, uid int, request FeedRequest, perPage int) (*FeedResponse, error)
-	UnfollowFn func(ctx context.Context, task queue.PostUnfollow) error
-	FollowFn   func(ctx context.Context, task queue.PostFollow) error
-	SearchFn   func(ctx context.Context, request SearchRequest, perPage int) ([]int, error)
-	PostsFn    func(ctx context.Context, postsIDs []int) ([]Post, error)
+	ListFn      func(ctx context.Context, request ListRequest, perPage int) (*ListResponse, error)
+	CreateFn    func(ctx context.Context, uid int, users []int, request CreateRequest) (*CreateResponse, error)
+	FeedFn      func(ctx context.Context, uid int, request FeedRequest, perPage int) (*FeedResponse, error)
+	FeedSinceFn func(ctx context.Context, request FeedSinceRequest, limit int) (*FeedResponse, error)
+	UnfollowFn  func(ctx context.Context, task queue.PostUnfollow) error
+	FollowFn    func(ctx context.Context, task queue.PostFollow) error
+	SearchFn    func(ctx context.Context, request SearchRequest, perPage int) ([]int, error)
+	PostsFn     func(ctx context.Context, postsIDs []int) ([]Post, error)
 }
 
 func (s serviceMock) List(ctx context.Context, request ListRequest) (*ListResponse, error) {
@@ -69,3 +72,11 @@ func (r repositoryMock) Search(ctx context.Context, request SearchRequest, perPa
 func (r repositoryMock) Posts(ctx context.Context, postsIDs []int) ([]Post, error) {
 	return r.PostsFn(ctx, postsIDs)
 }
+
+func (s serviceMock) InternalFeedSince(ctx context.Context, request FeedSinceRequest) (*FeedResponse, error) {
+	return s.InternalFeedSinceFn(ctx, request)
+}
+
+func (r repositoryMock) FeedSince(ctx context.Context, request FeedSinceRequest, limit int) (*FeedResponse, error) {
+	return r.FeedSinceFn(ctx, request, limit)
+}
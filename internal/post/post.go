@@ -42,6 +42,12 @@ type FeedResponse struct {
 	Posts []Post `json:"posts"`
 }
 
+// FeedSinceRequest represents request for posts which appeared in user's feed after Since (unix timestamp).
+type FeedSinceRequest struct {
+	UserID int
+	Since  int64
+}
+
 type SearchRequest struct {
 	Query   string // query is used for initial search. subsequent requests should use queryID
 	QueryID int
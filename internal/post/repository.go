@@ -16,6 +16,7 @@ type Repository interface {
 	List(ctx context.Context, request ListRequest, perPage int) (*ListResponse, error)
 	Create(ctx context.Context, uid int, users []int, request CreateRequest) (*CreateResponse, error)
 	Feed(ctx context.Context, uid int, request FeedRequest, perPage int) (*FeedResponse, error)
+	FeedSince(ctx context.Context, request FeedSinceRequest, limit int) (*FeedResponse, error)
 	Unfollow(ctx context.Context, task queue.PostUnfollow) error
 	Follow(ctx context.Context, task queue.PostFollow) error
 	Search(ctx context.Context, request SearchRequest, perPage int) ([]int, error)
@@ -188,6 +189,63 @@ func (r repository) Feed(ctx context.Context, uid int, request FeedRequest, perP
 	return &response, nil
 }
 
+// FeedSince returns the latest posts of followed users created after request.Since. Total is the number of all such
+// posts. User's own posts are excluded.
+func (r repository) FeedSince(ctx context.Context, request FeedSinceRequest, limit int) (*FeedResponse, error) {
+	response := FeedResponse{
+		Posts: make([]Post, 0, limit),
+	}
+
+	err := r.db.QueryRowContext(
+		ctx,
+		`SELECT COUNT(1) FROM feed
+				JOIN post ON post.id = feed.post_id
+				WHERE feed.user_id = $1 AND post.user_id <> $1 AND post.created_at >= to_timestamp($2)`,
+		request.UserID,
+		request.Since,
+	).Scan(&response.Total)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if response.Total == 0 {
+		return &response, nil
+	}
+
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT post.id, post.text, extract(epoch from post.created_at)::INT AS created_at, post.user_id FROM feed
+				JOIN post ON post.id = feed.post_id
+				WHERE feed.user_id = $1 AND post.user_id <> $1 AND post.created_at >= to_timestamp($2)
+				ORDER BY post.id DESC
+				LIMIT $3`,
+		request.UserID,
+		request.Since,
+		limit,
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	defer rows.Close()
+
+	post := Post{}
+	for rows.Next() {
+		err = rows.Scan(&post.ID, &post.Text, &post.CreatedAt, &post.User.ID)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		response.Posts = append(response.Posts, post)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &response, nil
+}
+
 func (r repository) Unfollow(ctx context.Context, task queue.PostUnfollow) error {
 	_, err := r.db.ExecContext(
 		ctx,
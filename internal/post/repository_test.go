@@ -394,3 +394,49 @@ func TestRepositoryPostsSuccess(t *testing.T) {
 		t.Fatalf("got: %d, want: %d", response[3].ID, posts[3].ID)
 	}
 }
+
+func TestRepositoryFeedSinceSuccess(t *testing.T) {
+	db, mock := newDatabaseMock()
+	repo := NewRepository(db)
+
+	wantTotal := 7
+	request := FeedSinceRequest{
+		UserID: 1,
+		Since:  10050000,
+	}
+
+	rows := sqlmock.NewRows([]string{"COUNT(1)"}).AddRow(wantTotal)
+	exec := regexp.QuoteMeta(
+		`SELECT COUNT(1) FROM feed
+			JOIN post ON post.id = feed.post_id
+			WHERE feed.user_id = $1 AND post.user_id <> $1 AND post.created_at >= to_timestamp($2)`)
+	mock.ExpectQuery(exec).WithArgs(request.UserID, request.Since).WillReturnRows(rows)
+
+	rows = sqlmock.NewRows([]string{"id", "text", "created_at", "user_id"}).
+		AddRow(9, "post 9", 10050021, 2).
+		AddRow(8, "post 8", 10050018, 3)
+	exec = regexp.QuoteMeta(
+		`SELECT post.id, post.text, extract(epoch from post.created_at)::INT AS created_at, post.user_id FROM feed
+			JOIN post ON post.id = feed.post_id
+			WHERE feed.user_id = $1 AND post.user_id <> $1 AND post.created_at >= to_timestamp($2)
+			ORDER BY post.id DESC
+			LIMIT $3`)
+	mock.ExpectQuery(exec).WithArgs(request.UserID, request.Since, 2).WillReturnRows(rows)
+
+	response, err := repo.FeedSince(context.Background(), request, 2)
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	if wantTotal != response.Total {
+		t.Fatalf("got: %d, want: %d", response.Total, wantTotal)
+	}
+
+	if len(response.Posts) != 2 || response.Posts[0].ID != 9 || response.Posts[1].User.ID != 3 {
+		t.Fatalf("got: %+v, want: posts 9 and 8", response.Posts)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+}
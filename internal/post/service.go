@@ -8,17 +8,22 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"go.elastic.co/apm/module/apmzap"
 
 	"gitlab.com/slirx/newproj/internal/api/user"
 	"gitlab.com/slirx/newproj/internal/graphql/graph/model"
 	"gitlab.com/slirx/newproj/pkg/api"
+	"gitlab.com/slirx/newproj/pkg/event"
+	"gitlab.com/slirx/newproj/pkg/event/publisher"
 	"gitlab.com/slirx/newproj/pkg/http/jwtmiddleware"
+	"gitlab.com/slirx/newproj/pkg/logger"
 	"gitlab.com/slirx/newproj/pkg/redis"
 )
 
 const (
 	perPage          int = 20
 	maxSearchPerPage int = 200
+	feedSinceLimit   int = 5
 )
 
 type Service interface {
@@ -26,12 +31,17 @@ type Service interface {
 	Create(ctx context.Context, request CreateRequest) (*CreateResponse, error)
 	Feed(ctx context.Context, userID int, request FeedRequest) (*FeedResponse, error)
 	Search(ctx context.Context, request SearchRequest) (*SearchResponse, error)
+	// InternalFeedSince returns the latest posts which appeared in user's feed since the given time. It's used
+	// for email digests.
+	InternalFeedSince(ctx context.Context, request FeedSinceRequest) (*FeedResponse, error)
 }
 
 type service struct {
 	Repository      Repository
 	InternalUserAPI user.API
 	RedisClient     redis.Client
+	Publisher       publisher.Publisher
+	Logger          logger.Logger
 }
 
 func (s service) List(ctx context.Context, request ListRequest) (*ListResponse, error) {
@@ -68,6 +78,18 @@ func (s service) Create(ctx context.Context, request CreateRequest) (*CreateResp
 		return nil, err
 	}
 
+	// the post is already saved, so failed event doesn't fail the request. otherwise the client retries it and
+	// creates duplicated post
+	err = s.Publisher.Emit(ctx, event.PostCreated{
+		PostID:    response.ID,
+		UserID:    uid,
+		Text:      response.Text,
+		CreatedAt: response.CreatedAt,
+	})
+	if err != nil {
+		s.Logger.Error(err, apmzap.TraceContext(ctx)...)
+	}
+
 	var posts []Post
 
 	posts, err = s.fetchUserInfo(ctx, []Post{Post(*response)})
@@ -211,6 +233,24 @@ func (s service) Search(ctx context.Context, request SearchRequest) (*SearchResp
 	return &response, nil
 }
 
+func (s service) InternalFeedSince(ctx context.Context, request FeedSinceRequest) (*FeedResponse, error) {
+	response, err := s.Repository.FeedSince(ctx, request, feedSinceLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response.Posts) == 0 {
+		return response, nil
+	}
+
+	response.Posts, err = s.fetchUserInfo(ctx, response.Posts)
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
 func (s service) fetchUserInfo(ctx context.Context, posts []Post) ([]Post, error) {
 	userIDs := make([]int, 0)
 	userIDsMap := make(map[int]struct{})
@@ -247,10 +287,18 @@ func (s service) fetchUserInfo(ctx context.Context, posts []Post) ([]Post, error
 	return posts, nil
 }
 
-func NewService(repository Repository, internalUserAPI user.API, redisClient redis.Client) Service {
+func NewService(
+	repository Repository,
+	internalUserAPI user.API,
+	redisClient redis.Client,
+	p publisher.Publisher,
+	l logger.Logger,
+) Service {
 	return service{
 		Repository:      repository,
 		InternalUserAPI: internalUserAPI,
 		RedisClient:     redisClient,
+		Publisher:       p,
+		Logger:          l,
 	}
 }
@@ -6,9 +6,14 @@ import (
 	"testing"
 	"time"
 
+	"go.uber.org/zap"
+
 	"gitlab.com/slirx/newproj/internal/api/user"
 	"gitlab.com/slirx/newproj/internal/graphql/graph/model"
+	"gitlab.com/slirx/newproj/pkg/event"
+	"gitlab.com/slirx/newproj/pkg/event/publisher"
 	"gitlab.com/slirx/newproj/pkg/http/jwtmiddleware"
+	"gitlab.com/slirx/newproj/pkg/logger"
 	"gitlab.com/slirx/newproj/pkg/redis"
 )
 
@@ -65,7 +70,8 @@ func TestServiceListSuccess(t *testing.T) {
 	}
 
 	redisClientMock := redis.Mock{}
-	s := NewService(rMock, internalUserAPIMock, redisClientMock)
+	publisherMock := publisher.Mock{}
+	s := NewService(rMock, internalUserAPIMock, redisClientMock, publisherMock, logger.Mock{})
 
 	request := ListRequest{}
 
@@ -129,7 +135,8 @@ func TestServiceListRepositoryError(t *testing.T) {
 	}
 
 	redisClientMock := redis.Mock{}
-	s := NewService(rMock, internalUserAPIMock, redisClientMock)
+	publisherMock := publisher.Mock{}
+	s := NewService(rMock, internalUserAPIMock, redisClientMock, publisherMock, logger.Mock{})
 
 	request := ListRequest{}
 
@@ -161,7 +168,8 @@ func TestServiceListUsersAPIError(t *testing.T) {
 	}
 
 	redisClientMock := redis.Mock{}
-	s := NewService(rMock, internalUserAPIMock, redisClientMock)
+	publisherMock := publisher.Mock{}
+	s := NewService(rMock, internalUserAPIMock, redisClientMock, publisherMock, logger.Mock{})
 
 	request := ListRequest{}
 
@@ -217,7 +225,16 @@ func TestServiceCreateSuccess(t *testing.T) {
 	}
 
 	redisClientMock := redis.Mock{}
-	s := NewService(rMock, internalUserAPIMock, redisClientMock)
+
+	var emitted event.Event
+
+	publisherMock := publisher.Mock{}
+	publisherMock.EmitFn = func(ctx context.Context, e event.Event) error {
+		emitted = e
+		return nil
+	}
+
+	s := NewService(rMock, internalUserAPIMock, redisClientMock, publisherMock, logger.Mock{})
 
 	request := CreateRequest{
 		Text: "my post #1",
@@ -257,6 +274,52 @@ func TestServiceCreateSuccess(t *testing.T) {
 	if wantCreatedAt != response.CreatedAt {
 		t.Fatalf("got: %d, want: %d", response.CreatedAt, wantCreatedAt)
 	}
+
+	wantEvent := event.PostCreated{PostID: 1, UserID: wantUserID, Text: request.Text, CreatedAt: wantCreatedAt}
+	if emitted != wantEvent {
+		t.Fatalf("got: %+v, want: %+v", emitted, wantEvent)
+	}
+}
+
+func TestServiceCreateEmitError(t *testing.T) {
+	rMock := repositoryMock{}
+	rMock.CreateFn = func(ctx context.Context, uid int, users []int, request CreateRequest) (*CreateResponse, error) {
+		return &CreateResponse{ID: 1, Text: request.Text, User: PostsUser{ID: uid}}, nil
+	}
+
+	internalUserAPIMock := user.Mock{}
+	internalUserAPIMock.UsersFn = func(ctx context.Context, userIDs []int) ([]model.User, error) {
+		return []model.User{{ID: 1, Login: "anon"}}, nil
+	}
+	internalUserAPIMock.FollowersFn = func(ctx context.Context, uid int) ([]int, error) {
+		return nil, nil
+	}
+
+	publisherMock := publisher.Mock{}
+	publisherMock.EmitFn = func(ctx context.Context, e event.Event) error {
+		return errors.New("emit error")
+	}
+
+	var logged error
+
+	loggerMock := logger.Mock{}
+	loggerMock.ErrorFn = func(err error, fields ...zap.Field) {
+		logged = err
+	}
+
+	s := NewService(rMock, internalUserAPIMock, redis.Mock{}, publisherMock, loggerMock)
+
+	ctx := context.WithValue(context.Background(), jwtmiddleware.ContextKeyUserID, 1)
+
+	// the post is saved, so the request succeeds and the client doesn't create it again
+	response, err := s.Create(ctx, CreateRequest{Text: "my post #1"})
+	if err != nil || response.ID != 1 {
+		t.Fatalf("got: %+v/%v, want: created post", response, err)
+	}
+
+	if logged == nil || logged.Error() != "emit error" {
+		t.Fatalf("got: %v, want: emit error", logged)
+	}
 }
 
 func TestServiceFeedSuccess(t *testing.T) {
@@ -309,7 +372,8 @@ func TestServiceFeedSuccess(t *testing.T) {
 	}
 
 	redisClientMock := redis.Mock{}
-	s := NewService(rMock, internalUserAPIMock, redisClientMock)
+	publisherMock := publisher.Mock{}
+	s := NewService(rMock, internalUserAPIMock, redisClientMock, publisherMock, logger.Mock{})
 
 	request := FeedRequest{
 		LatestPostID: 0,
@@ -394,6 +458,7 @@ func TestServiceSearchSuccess(t *testing.T) {
 	}
 
 	redisClientMock := redis.Mock{}
+	publisherMock := publisher.Mock{}
 	redisClientMock.HIncrByFn = func(ctx context.Context, key string, field string, incr int64) (int64, error) {
 		return 1, nil
 	}
@@ -401,7 +466,7 @@ func TestServiceSearchSuccess(t *testing.T) {
 		return nil
 	}
 
-	s := NewService(rMock, internalUserAPIMock, redisClientMock)
+	s := NewService(rMock, internalUserAPIMock, redisClientMock, publisherMock, logger.Mock{})
 
 	request := SearchRequest{
 		Query:   "my query",
@@ -485,11 +550,12 @@ func TestServiceSearchWithQueryIDSuccess(t *testing.T) {
 	}
 
 	redisClientMock := redis.Mock{}
+	publisherMock := publisher.Mock{}
 	redisClientMock.GetIntSliceFn = func(ctx context.Context, key string) ([]int, error) {
 		return []int{1, 2}, nil
 	}
 
-	s := NewService(rMock, internalUserAPIMock, redisClientMock)
+	s := NewService(rMock, internalUserAPIMock, redisClientMock, publisherMock, logger.Mock{})
 
 	request := SearchRequest{
 		Query:   "my query",
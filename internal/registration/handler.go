@@ -0,0 +1,92 @@
+package registration
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/module/apmzap"
+
+	"gitlab.com/slirx/newproj/pkg/logger"
+	"gitlab.com/slirx/newproj/pkg/queue"
+	"gitlab.com/slirx/newproj/pkg/queue/manager"
+	"gitlab.com/slirx/newproj/pkg/queue/worker"
+)
+
+type ExternalHandler struct {
+	Logger     logger.Logger
+	Repository Repository
+	Manager    manager.Manager
+}
+
+// Handle registers the user who signed in through OpenID Connect provider. Login and email are reserved the same
+// way as the ones of confirmed registration, then auth is created through the same pipeline.
+func (h ExternalHandler) Handle(ctx context.Context, msg amqp.Delivery) error {
+	task := queue.RegistrationExternal{}
+
+	if err := gob.NewDecoder(bytes.NewReader(msg.Body)).Decode(&task); err != nil {
+		return worker.Permanent(errors.WithStack(err))
+	}
+
+	tx := apm.TransactionFromContext(ctx)
+
+	body, err := json.Marshal(task)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	tx.Context.SetCustom("request_body", string(body))
+
+	if task.Login == "" || task.Email == "" || task.Issuer == "" || task.Subject == "" {
+		return worker.Permanent(errors.WithStack(fmt.Errorf("invalid task: %s", body)))
+	}
+
+	h.Logger.Debug(fmt.Sprintf("registering %s of external provider", task.Login), apmzap.TraceContext(ctx)...)
+
+	// login of another registration can differ in case only
+	loginIsUsed, err := h.Repository.LoginIsUsed(ctx, task.Login, task.Email)
+	if err != nil {
+		return err
+	}
+
+	if loginIsUsed {
+		return worker.Permanent(errors.WithStack(ErrLoginIsInUse))
+	}
+
+	err = h.Repository.RegisterExternal(ctx, task.Login, task.Email)
+	if errors.Is(err, ErrEmailIsInUse) || errors.Is(err, ErrLoginIsInUse) {
+		return worker.Permanent(err)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = h.Manager.Send(ctx, queue.JobAuthCreate, queue.AuthCreate{
+		Login:   task.Login,
+		Email:   task.Email,
+		Issuer:  task.Issuer,
+		Subject: task.Subject,
+	})
+	if err != nil {
+		return err
+	}
+
+	tx.Result = "success"
+	tx.Outcome = "success"
+
+	return nil
+}
+
+func NewExternalHandler(l logger.Logger, repository Repository, m manager.Manager) worker.Handler {
+	return ExternalHandler{
+		Logger:     l,
+		Repository: repository,
+		Manager:    m,
+	}
+}
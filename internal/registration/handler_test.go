@@ -0,0 +1,131 @@
+package registration
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/transport"
+	"go.uber.org/zap"
+
+	"gitlab.com/slirx/newproj/pkg/logger"
+	"gitlab.com/slirx/newproj/pkg/queue"
+	"gitlab.com/slirx/newproj/pkg/queue/manager"
+	"gitlab.com/slirx/newproj/pkg/queue/worker"
+)
+
+func newTestContext(t *testing.T) context.Context {
+	t.Helper()
+
+	tracer, err := apm.NewTracerOptions(apm.TracerOptions{Transport: transport.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := tracer.StartTransaction("test", "test")
+	t.Cleanup(tx.End)
+
+	return apm.ContextWithTransaction(context.Background(), tx)
+}
+
+func newTestDelivery(t *testing.T, task interface{}) amqp.Delivery {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(task); err != nil {
+		t.Fatal(err)
+	}
+
+	return amqp.Delivery{Body: buf.Bytes()}
+}
+
+var loggerMock = logger.Mock{
+	DebugFn: func(msg string, fields ...zap.Field) {},
+	InfoFn:  func(msg string, fields ...zap.Field) {},
+	ErrorFn: func(err error, fields ...zap.Field) {},
+}
+
+func TestExternalHandlerHandle(t *testing.T) {
+	task := queue.RegistrationExternal{
+		Login:   "jack_1234",
+		Email:   "jack@example.com",
+		Issuer:  "https://idp.example.com",
+		Subject: "sub-jack",
+	}
+
+	registrations := make(map[string]string)
+	rMock := repositoryMock{
+		LoginIsUsedFn: func(ctx context.Context, login string, email string) (bool, error) {
+			return login == "Jack", nil
+		},
+		RegisterExternalFn: func(ctx context.Context, login string, email string) error {
+			if l, ok := registrations[email]; ok && l != login {
+				return errors.WithStack(ErrEmailIsInUse)
+			}
+
+			registrations[email] = login
+
+			return nil
+		},
+	}
+
+	jobs := make([]queue.AuthCreate, 0)
+	m := manager.Mock{
+		SendFn: func(ctx context.Context, routingKey string, msg interface{}) error {
+			if routingKey != queue.JobAuthCreate {
+				t.Fatalf("got: %s, want: %s", routingKey, queue.JobAuthCreate)
+			}
+
+			jobs = append(jobs, msg.(queue.AuthCreate))
+
+			return nil
+		},
+	}
+
+	h := NewExternalHandler(loggerMock, rMock, m)
+
+	// redelivered job creates auth again, auth service rejects it
+	for i := 0; i < 2; i++ {
+		if err := h.Handle(newTestContext(t), newTestDelivery(t, task)); err != nil {
+			t.Fatalf("got: %s, want: nil", err.Error())
+		}
+	}
+
+	want := queue.AuthCreate{Login: task.Login, Email: task.Email, Issuer: task.Issuer, Subject: task.Subject}
+	if len(jobs) != 2 || jobs[0] != want {
+		t.Fatalf("got: %+v, want: 2 jobs %+v", jobs, want)
+	}
+
+	// email is registered with another login
+	task.Login = "jack_5678"
+
+	err := h.Handle(newTestContext(t), newTestDelivery(t, task))
+	if !worker.IsPermanent(err) || !errors.Is(err, ErrEmailIsInUse) {
+		t.Fatalf("got: %v, want: permanent %s", err, ErrEmailIsInUse)
+	}
+
+	task.Login = "Jack"
+	task.Email = "other@example.com"
+
+	err = h.Handle(newTestContext(t), newTestDelivery(t, task))
+	if !worker.IsPermanent(err) || !errors.Is(err, ErrLoginIsInUse) {
+		t.Fatalf("got: %v, want: permanent %s", err, ErrLoginIsInUse)
+	}
+
+	if len(jobs) != 2 {
+		t.Fatalf("got: %d jobs, want: 2", len(jobs))
+	}
+}
+
+func TestExternalHandlerInvalidTask(t *testing.T) {
+	h := NewExternalHandler(loggerMock, repositoryMock{}, manager.Mock{})
+
+	err := h.Handle(newTestContext(t), newTestDelivery(t, queue.RegistrationExternal{Login: "jack"}))
+	if !worker.IsPermanent(err) {
+		t.Fatalf("got: %v, want: permanent error", err)
+	}
+}
@@ -8,6 +8,7 @@ import (
 
 	"gitlab.com/slirx/newproj/pkg/api"
 	"gitlab.com/slirx/newproj/pkg/logger"
+	"gitlab.com/slirx/newproj/pkg/template"
 )
 
 // Handler represents methods for registration HTTP server.
@@ -16,6 +17,8 @@ type Handler interface {
 	Register(w http.ResponseWriter, r *http.Request)
 	// Confirm reads user's confirmation code and confirms registration in case code is correct.
 	Confirm(w http.ResponseWriter, r *http.Request)
+	// Resend sends a new confirmation code to not confirmed email.
+	Resend(w http.ResponseWriter, r *http.Request)
 }
 
 type handler struct {
@@ -35,6 +38,10 @@ func (h handler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if request.Locale == "" {
+		request.Locale = template.AcceptLanguage(r.Header.Get("Accept-Language"))
+	}
+
 	msg, err := h.Service.Register(r.Context(), request)
 	if err != nil {
 		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
@@ -66,6 +73,31 @@ func (h handler) Confirm(w http.ResponseWriter, r *http.Request) {
 	h.ResponseBuilder.MessageResponse(ctx, w, msg)
 }
 
+// Resend sends a new confirmation code to not confirmed email.
+func (h handler) Resend(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	request := ResendRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, api.RequestError)
+		return
+	}
+
+	if request.Locale == "" {
+		request.Locale = template.AcceptLanguage(r.Header.Get("Accept-Language"))
+	}
+
+	msg, err := h.Service.Resend(r.Context(), request)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	h.ResponseBuilder.MessageResponse(ctx, w, msg)
+}
+
 // NewHandler returns instance of implemented Handler interface.
 func NewHandler(s Service, l logger.Logger, rb api.ResponseBuilder) Handler {
 	return handler{Service: s, Logger: l, ResponseBuilder: rb}
@@ -72,7 +72,7 @@ func TestHTTPRegisterRequestError(t *testing.T) {
 	h.Register(rec, r)
 
 	in := rec.Body.String()
-	want := `{"request_id":"req1-err","type":"error","message":"invalid request data"}
+	want := `{"request_id":"req1-err","type":"error","message":"invalid request data","code":"invalid_request","errors":[]}
 `
 
 	if in != want {
@@ -109,7 +109,7 @@ func TestHTTPRegisterServiceError(t *testing.T) {
 	h.Register(rec, r)
 
 	in := rec.Body.String()
-	want := `{"request_id":"req1-err","type":"error","message":"oops, something went wrong"}
+	want := `{"request_id":"req1-err","type":"error","message":"oops, something went wrong","code":"internal_error","errors":[]}
 `
 
 	if in != want {
@@ -175,7 +175,7 @@ func TestHTTPConfirmRequestError(t *testing.T) {
 	h.Confirm(rec, r)
 
 	in := rec.Body.String()
-	want := `{"request_id":"req1","type":"error","message":"invalid request data"}
+	want := `{"request_id":"req1","type":"error","message":"invalid request data","code":"invalid_request","errors":[]}
 `
 
 	if in != want {
@@ -212,7 +212,7 @@ func TestHTTPConfirmServiceError(t *testing.T) {
 	h.Confirm(rec, r)
 
 	in := rec.Body.String()
-	want := `{"request_id":"req1","type":"error","message":"oops, something went wrong"}
+	want := `{"request_id":"req1","type":"error","message":"oops, something went wrong","code":"internal_error","errors":[]}
 `
 
 	if in != want {
@@ -8,12 +8,17 @@ var _ Repository = (*repositoryMock)(nil)
 type serviceMock struct {
 	RegisterFn func(context.Context, RegisterRequest) (string, error)
 	ConfirmFn  func(context.Context, ConfirmRequest) (string, error)
+	ResendFn   func(context.Context, ResendRequest) (string, error)
 }
 
 type repositoryMock struct {
-	RegisterFn         func(ctx context.Context, request RegisterRequest, code int) error
+	RegisterFn         func(ctx context.Context, request RegisterRequest, codeHash string) error
 	ConfirmationDataFn func(ctx context.Context, email string) (*ConfirmationData, error)
+	UpdateCodeFn       func(ctx context.Context, request RegisterRequest, codeHash string, resends int) error
+	IncrAttemptsFn     func(ctx context.Context, email string, maxAttempts int) (bool, error)
 	ConfirmFn          func(ctx context.Context, email string) error
+	RegisterExternalFn func(ctx context.Context, login string, email string) error
+	LoginIsUsedFn      func(ctx context.Context, login string, email string) (bool, error)
 }
 
 func (r serviceMock) Register(ctx context.Context, request RegisterRequest) (string, error) {
@@ -24,14 +29,34 @@ func (r serviceMock) Confirm(ctx context.Context, request ConfirmRequest) (strin
 	return r.ConfirmFn(ctx, request)
 }
 
-func (r repositoryMock) Register(ctx context.Context, request RegisterRequest, code int) error {
-	return r.RegisterFn(ctx, request, code)
+func (r serviceMock) Resend(ctx context.Context, request ResendRequest) (string, error) {
+	return r.ResendFn(ctx, request)
+}
+
+func (r repositoryMock) Register(ctx context.Context, request RegisterRequest, codeHash string) error {
+	return r.RegisterFn(ctx, request, codeHash)
 }
 
 func (r repositoryMock) ConfirmationData(ctx context.Context, email string) (*ConfirmationData, error) {
 	return r.ConfirmationDataFn(ctx, email)
 }
 
+func (r repositoryMock) UpdateCode(ctx context.Context, request RegisterRequest, codeHash string, resends int) error {
+	return r.UpdateCodeFn(ctx, request, codeHash, resends)
+}
+
+func (r repositoryMock) IncrAttempts(ctx context.Context, email string, maxAttempts int) (bool, error) {
+	return r.IncrAttemptsFn(ctx, email, maxAttempts)
+}
+
 func (r repositoryMock) Confirm(ctx context.Context, email string) error {
 	return r.ConfirmFn(ctx, email)
 }
+
+func (r repositoryMock) RegisterExternal(ctx context.Context, login string, email string) error {
+	return r.RegisterExternalFn(ctx, login, email)
+}
+
+func (r repositoryMock) LoginIsUsed(ctx context.Context, login string, email string) (bool, error) {
+	return r.LoginIsUsedFn(ctx, login, email)
+}
@@ -1,17 +1,26 @@
 package registration
 
 import (
-	"errors"
-	"fmt"
 	"time"
+
+	"gitlab.com/slirx/newproj/pkg/password"
 )
 
-const PasswordMinLen = 5
+const PasswordMinLen = password.MinLen
+
+// Config represents configuration of registration service.
+type Config struct {
+	// ReservedLogins are words which can't be used as logins, for example names of routes of frontend. Logins which
+	// look like them are rejected too.
+	ReservedLogins []string
+}
 
 // RegisterRequest represents fields of registration request.
 type RegisterRequest struct {
 	Login string `json:"login"`
 	Email string `json:"email"`
+	// Locale is the locale of confirmation email. Locale from Accept-Language header is used in case it's empty.
+	Locale string `json:"locale"`
 }
 
 // ConfirmRequest represents fields of confirmation request.
@@ -22,11 +31,21 @@ type ConfirmRequest struct {
 	PasswordConfirmation string `json:"password_confirmation"` // should be the same as password field
 }
 
+// ResendRequest represents fields of request which sends a new confirmation code.
+type ResendRequest struct {
+	Email  string `json:"email"`
+	Locale string `json:"locale"` // locale of the email, Accept-Language header is used in case it's empty
+}
+
 // ConfirmationData represents information about registration confirmation. It's fetched from database.
 type ConfirmationData struct {
-	Code      int
-	Login     string
-	CreatedAt time.Time
+	CodeHash   string // hash of confirmation code, the code itself is not stored
+	Attempts   int    // number of invalid codes entered since the code was sent
+	Resends    int    // number of codes sent during the current resend window
+	Login      string
+	CodeSentAt time.Time
+	CreatedAt  time.Time
+	Confirmed  bool
 }
 
 // EmailConfirmation is used for generating email message.
@@ -36,17 +55,5 @@ type EmailConfirmation struct {
 
 // Validate validates confirmation request.
 func (r ConfirmRequest) Validate() error {
-	if r.Password == "" || r.PasswordConfirmation == "" {
-		return errors.New("password should not be empty")
-	}
-
-	if r.Password != r.PasswordConfirmation {
-		return errors.New("passwords should match")
-	}
-
-	if len(r.Password) < PasswordMinLen {
-		return fmt.Errorf("passwords length should be more than %d symbols", PasswordMinLen)
-	}
-
-	return nil
+	return password.Validate(r.Password, r.PasswordConfirmation)
 }
@@ -4,30 +4,56 @@ import (
 	"context"
 	"database/sql"
 
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 )
 
+// pqUniqueViolation is code of postgres error which is returned in case unique index is violated.
+const pqUniqueViolation = "23505"
+
+var (
+	ErrEmailIsInUse = errors.New("email is already in use")
+	ErrLoginIsInUse = errors.New("login is already in use")
+)
+
 type Repository interface {
-	Register(ctx context.Context, request RegisterRequest, code int) error
+	// Register creates a new registration. ErrEmailIsInUse or ErrLoginIsInUse is returned in case email or login
+	// is used by another registration.
+	Register(ctx context.Context, request RegisterRequest, codeHash string) error
 	ConfirmationData(ctx context.Context, email string) (*ConfirmationData, error)
+	// UpdateCode replaces login and confirmation code of not confirmed registration and resets failed attempts.
+	// resends is the number of codes sent during the current resend window.
+	UpdateCode(ctx context.Context, request RegisterRequest, codeHash string, resends int) error
+	// IncrAttempts counts one more attempt of confirmation. It returns false without counting in case maxAttempts
+	// have already been made.
+	IncrAttempts(ctx context.Context, email string, maxAttempts int) (bool, error)
+	// Confirm marks registration as confirmed. It returns sql.ErrNoRows in case it's already confirmed, so one
+	// registration can't be confirmed by concurrent requests twice.
 	Confirm(ctx context.Context, email string) error
+	// RegisterExternal creates confirmed registration of the user who signed in through OpenID Connect provider.
+	// Not confirmed registration of the same email is replaced, because the provider confirmed the email. The same
+	// registration created before is kept, so redelivered job doesn't fail. ErrEmailIsInUse or ErrLoginIsInUse is
+	// returned in case email or login is used by another registration.
+	RegisterExternal(ctx context.Context, login string, email string) error
+	// LoginIsUsed reports whether login is used by registration of another email. Logins are compared
+	// case-insensitively.
+	LoginIsUsed(ctx context.Context, login string, email string) (bool, error)
 }
 
 type repository struct {
 	db *sql.DB
 }
 
-func (r repository) Register(ctx context.Context, request RegisterRequest, code int) error {
-	// todo handle case when email or login is already in use
+func (r repository) Register(ctx context.Context, request RegisterRequest, codeHash string) error {
 	_, err := r.db.ExecContext(
 		ctx,
-		"INSERT INTO registration(email, login, code) VALUES($1, $2, $3)",
+		"INSERT INTO registration(email, login, code_hash) VALUES($1, $2, $3)",
 		request.Email,
 		request.Login,
-		code,
+		codeHash,
 	)
 	if err != nil {
-		return errors.WithStack(err)
+		return uniqueViolation(err)
 	}
 
 	return nil
@@ -38,9 +64,18 @@ func (r repository) ConfirmationData(ctx context.Context, email string) (*Confir
 
 	err := r.db.QueryRowContext(
 		ctx,
-		"SELECT code, created_at, login FROM registration WHERE email = $1 LIMIT 1",
+		`SELECT code_hash, attempts, resends, code_sent_at, created_at, login, confirmed_at IS NOT NULL
+			FROM registration WHERE email = $1 LIMIT 1`,
 		email,
-	).Scan(&response.Code, &response.CreatedAt, &response.Login)
+	).Scan(
+		&response.CodeHash,
+		&response.Attempts,
+		&response.Resends,
+		&response.CodeSentAt,
+		&response.CreatedAt,
+		&response.Login,
+		&response.Confirmed,
+	)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -48,20 +83,130 @@ func (r repository) ConfirmationData(ctx context.Context, email string) (*Confir
 	return &response, nil
 }
 
+func (r repository) UpdateCode(ctx context.Context, request RegisterRequest, codeHash string, resends int) error {
+	result, err := r.db.ExecContext(
+		ctx,
+		`UPDATE registration SET login = $1, code_hash = $2, attempts = 0, resends = $3, code_sent_at = CURRENT_TIMESTAMP
+			WHERE email = $4 AND confirmed_at IS NULL`,
+		request.Login,
+		codeHash,
+		resends,
+		request.Email,
+	)
+	if err != nil {
+		return uniqueViolation(err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// registration has been confirmed in the meantime
+	if affected == 0 {
+		return errors.WithStack(ErrEmailIsInUse)
+	}
+
+	return nil
+}
+
+func (r repository) IncrAttempts(ctx context.Context, email string, maxAttempts int) (bool, error) {
+	var attempts int
+
+	err := r.db.QueryRowContext(
+		ctx,
+		"UPDATE registration SET attempts = attempts + 1 WHERE email = $1 AND attempts < $2 RETURNING attempts",
+		email,
+		maxAttempts,
+	).Scan(&attempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return true, nil
+}
+
 func (r repository) Confirm(ctx context.Context, email string) error {
-	_, err := r.db.ExecContext(
+	result, err := r.db.ExecContext(
+		ctx,
+		"UPDATE registration SET code_hash='', confirmed_at=CURRENT_TIMESTAMP WHERE email=$1 AND confirmed_at IS NULL",
+		email,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if affected == 0 {
+		return errors.WithStack(sql.ErrNoRows)
+	}
+
+	return nil
+}
+
+func (r repository) RegisterExternal(ctx context.Context, login string, email string) error {
+	result, err := r.db.ExecContext(
 		ctx,
-		"UPDATE registration SET code=$1, confirmed_at=CURRENT_TIMESTAMP WHERE email=$2",
-		0,
+		`INSERT INTO registration(email, login, code_hash, confirmed_at) VALUES($1, $2, '', CURRENT_TIMESTAMP)
+			ON CONFLICT (email) DO UPDATE SET login = EXCLUDED.login, code_hash = '', confirmed_at = CURRENT_TIMESTAMP
+			WHERE registration.confirmed_at IS NULL OR registration.login = EXCLUDED.login`,
 		email,
+		login,
 	)
+	if err != nil {
+		return uniqueViolation(err)
+	}
+
+	affected, err := result.RowsAffected()
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
+	// email is confirmed by registration of another login
+	if affected == 0 {
+		return errors.WithStack(ErrEmailIsInUse)
+	}
+
 	return nil
 }
 
+func (r repository) LoginIsUsed(ctx context.Context, login string, email string) (bool, error) {
+	var used bool
+
+	err := r.db.QueryRowContext(
+		ctx,
+		"SELECT EXISTS(SELECT 1 FROM registration WHERE lower(login) = lower($1) AND email <> $2)",
+		login,
+		email,
+	).Scan(&used)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return used, nil
+}
+
+// uniqueViolation converts violation of unique indexes of email and login to ErrEmailIsInUse and ErrLoginIsInUse.
+func uniqueViolation(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == pqUniqueViolation {
+		switch pqErr.Constraint {
+		case "registration_email_uindex":
+			return errors.WithStack(ErrEmailIsInUse)
+		case "registration_login_uindex":
+			return errors.WithStack(ErrLoginIsInUse)
+		}
+	}
+
+	return errors.WithStack(err)
+}
+
 func NewRepository(db *sql.DB) Repository {
 	return repository{db: db}
 }
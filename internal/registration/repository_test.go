@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 )
 
@@ -27,11 +28,11 @@ func TestRepositoryRegisterSuccess(t *testing.T) {
 
 	email := "test@test.com"
 	login := "test"
-	code := 1234
+	codeHash := "hash"
 
-	exec := regexp.QuoteMeta(`INSERT INTO registration(email, login, code) VALUES($1, $2, $3)`)
+	exec := regexp.QuoteMeta(`INSERT INTO registration(email, login, code_hash) VALUES($1, $2, $3)`)
 	mock.ExpectExec(exec).
-		WithArgs(email, login, code).
+		WithArgs(email, login, codeHash).
 		WillReturnResult(sqlmock.NewResult(0, 1))
 
 	ctx := context.Background()
@@ -40,7 +41,7 @@ func TestRepositoryRegisterSuccess(t *testing.T) {
 		Email: email,
 	}
 
-	err := repo.Register(ctx, request, code)
+	err := repo.Register(ctx, request, codeHash)
 	if err != nil {
 		t.Fatalf("got: %s, want: nil", err.Error())
 	}
@@ -52,12 +53,12 @@ func TestRepositoryRegisterError(t *testing.T) {
 
 	email := "test@test.com"
 	login := "test"
-	code := 1234
+	codeHash := "hash"
 	wantErr := "insert into error"
 
-	exec := regexp.QuoteMeta(`INSERT INTO registration(email, login, code) VALUES($1, $2, $3)`)
+	exec := regexp.QuoteMeta(`INSERT INTO registration(email, login, code_hash) VALUES($1, $2, $3)`)
 	mock.ExpectExec(exec).
-		WithArgs(email, login, code).
+		WithArgs(email, login, codeHash).
 		WillReturnError(errors.New(wantErr))
 
 	ctx := context.Background()
@@ -66,7 +67,7 @@ func TestRepositoryRegisterError(t *testing.T) {
 		Email: email,
 	}
 
-	err := repo.Register(ctx, request, code)
+	err := repo.Register(ctx, request, codeHash)
 	if err == nil {
 		t.Fatalf("got: nil, want: %s", wantErr)
 	}
@@ -80,14 +81,15 @@ func TestRepositoryConfirmationDataSuccess(t *testing.T) {
 	db, mock := newDatabaseMock()
 	repo := NewRepository(db)
 
-	code := 1234
+	codeHash := "hash"
 	createdAt := time.Now()
 	login := "test"
 	email := "test@test.com"
 
-	rows := sqlmock.NewRows([]string{"code", "created_at", "login"}).AddRow(code, createdAt, login)
+	rows := sqlmock.NewRows([]string{"code_hash", "attempts", "resends", "code_sent_at", "created_at", "login", "confirmed"}).
+		AddRow(codeHash, 2, 1, createdAt, createdAt, login, false)
 
-	exec := regexp.QuoteMeta(`SELECT code, created_at, login FROM registration WHERE email = $1 LIMIT 1`)
+	exec := regexp.QuoteMeta(`SELECT code_hash, attempts, resends, code_sent_at, created_at, login, confirmed_at IS NOT NULL`)
 	mock.ExpectQuery(exec).WithArgs(email).WillReturnRows(rows)
 
 	ctx := context.Background()
@@ -97,8 +99,8 @@ func TestRepositoryConfirmationDataSuccess(t *testing.T) {
 		t.Fatalf("got: %s, want: nil", err.Error())
 	}
 
-	if code != response.Code {
-		t.Fatalf("got: %d, want: %d", response.Code, code)
+	if codeHash != response.CodeHash || response.Attempts != 2 || response.Resends != 1 {
+		t.Fatalf("got: %s/%d/%d, want: %s/2/1", response.CodeHash, response.Attempts, response.Resends, codeHash)
 	}
 
 	if createdAt != response.CreatedAt {
@@ -117,7 +119,7 @@ func TestRepositoryConfirmationDataError(t *testing.T) {
 	email := "test@test.com"
 	wantErr := "select error"
 
-	exec := regexp.QuoteMeta(`SELECT code, created_at, login FROM registration WHERE email = $1 LIMIT 1`)
+	exec := regexp.QuoteMeta(`SELECT code_hash, attempts, resends, code_sent_at, created_at, login, confirmed_at IS NOT NULL`)
 	mock.ExpectQuery(exec).WithArgs(email).WillReturnError(errors.New(wantErr))
 
 	ctx := context.Background()
@@ -141,11 +143,10 @@ func TestRepositoryConfirmSuccess(t *testing.T) {
 	repo := NewRepository(db)
 
 	email := "test@test.com"
-	code := 0
 
-	exec := regexp.QuoteMeta(`UPDATE registration SET code=$1, confirmed_at=CURRENT_TIMESTAMP WHERE email=$2`)
+	exec := regexp.QuoteMeta(`UPDATE registration SET code_hash='', confirmed_at=CURRENT_TIMESTAMP WHERE email=$1`)
 	mock.ExpectExec(exec).
-		WithArgs(code, email).
+		WithArgs(email).
 		WillReturnResult(sqlmock.NewResult(0, 1))
 
 	ctx := context.Background()
@@ -161,12 +162,11 @@ func TestRepositoryConfirmError(t *testing.T) {
 	repo := NewRepository(db)
 
 	email := "test@test.com"
-	code := 0
 	wantErr := "update error"
 
-	exec := regexp.QuoteMeta(`UPDATE registration SET code=$1, confirmed_at=CURRENT_TIMESTAMP WHERE email=$2`)
+	exec := regexp.QuoteMeta(`UPDATE registration SET code_hash='', confirmed_at=CURRENT_TIMESTAMP WHERE email=$1`)
 	mock.ExpectExec(exec).
-		WithArgs(code, email).
+		WithArgs(email).
 		WillReturnError(errors.New(wantErr))
 
 	ctx := context.Background()
@@ -180,3 +180,130 @@ func TestRepositoryConfirmError(t *testing.T) {
 		t.Fatalf("got: %s, want: %s", err.Error(), wantErr)
 	}
 }
+
+func TestRepositoryConfirmAlreadyConfirmed(t *testing.T) {
+	db, mock := newDatabaseMock()
+	repo := NewRepository(db)
+
+	exec := regexp.QuoteMeta(
+		`UPDATE registration SET code_hash='', confirmed_at=CURRENT_TIMESTAMP WHERE email=$1 AND confirmed_at IS NULL`,
+	)
+	mock.ExpectExec(exec).
+		WithArgs("test@test.com").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := repo.Confirm(context.Background(), "test@test.com")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("got: %v, want: %s", err, sql.ErrNoRows)
+	}
+}
+
+func TestRepositoryIncrAttempts(t *testing.T) {
+	db, mock := newDatabaseMock()
+	repo := NewRepository(db)
+
+	query := regexp.QuoteMeta(
+		"UPDATE registration SET attempts = attempts + 1 WHERE email = $1 AND attempts < $2 RETURNING attempts",
+	)
+	mock.ExpectQuery(query).
+		WithArgs("test@test.com", 5).
+		WillReturnRows(sqlmock.NewRows([]string{"attempts"}).AddRow(5))
+
+	// the limit is reached
+	mock.ExpectQuery(query).
+		WithArgs("test@test.com", 5).
+		WillReturnError(sql.ErrNoRows)
+
+	ctx := context.Background()
+
+	if counted, err := repo.IncrAttempts(ctx, "test@test.com", 5); err != nil || !counted {
+		t.Fatalf("got: %t, %v, want: true, nil", counted, err)
+	}
+
+	if counted, err := repo.IncrAttempts(ctx, "test@test.com", 5); err != nil || counted {
+		t.Fatalf("got: %t, %v, want: false, nil", counted, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+}
+
+func TestRepositoryRegisterUsedLogin(t *testing.T) {
+	db, mock := newDatabaseMock()
+	repo := NewRepository(db)
+
+	exec := regexp.QuoteMeta(`INSERT INTO registration(email, login, code_hash) VALUES($1, $2, $3)`)
+	mock.ExpectExec(exec).
+		WithArgs("test@test.com", "test", "hash").
+		WillReturnError(&pq.Error{Code: pqUniqueViolation, Constraint: "registration_login_uindex"})
+
+	err := repo.Register(context.Background(), RegisterRequest{Login: "test", Email: "test@test.com"}, "hash")
+	if !errors.Is(err, ErrLoginIsInUse) {
+		t.Fatalf("got: %v, want: %s", err, ErrLoginIsInUse)
+	}
+}
+
+func TestRepositoryUpdateCodeConfirmed(t *testing.T) {
+	db, mock := newDatabaseMock()
+	repo := NewRepository(db)
+
+	// confirmed registration is not updated
+	exec := regexp.QuoteMeta(`UPDATE registration SET login = $1, code_hash = $2, attempts = 0, resends = $3`)
+	mock.ExpectExec(exec).
+		WithArgs("test", "hash", 2, "test@test.com").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := repo.UpdateCode(context.Background(), RegisterRequest{Login: "test", Email: "test@test.com"}, "hash", 2)
+	if !errors.Is(err, ErrEmailIsInUse) {
+		t.Fatalf("got: %v, want: %s", err, ErrEmailIsInUse)
+	}
+}
+
+func TestRepositoryRegisterExternal(t *testing.T) {
+	db, mock := newDatabaseMock()
+	repo := NewRepository(db)
+
+	exec := regexp.QuoteMeta(`INSERT INTO registration(email, login, code_hash, confirmed_at)`)
+	mock.ExpectExec(exec).
+		WithArgs("test@test.com", "test").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// email is confirmed by registration of another login
+	mock.ExpectExec(exec).
+		WithArgs("test@test.com", "test_1234").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	ctx := context.Background()
+
+	if err := repo.RegisterExternal(ctx, "test", "test@test.com"); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	if err := repo.RegisterExternal(ctx, "test_1234", "test@test.com"); !errors.Is(err, ErrEmailIsInUse) {
+		t.Fatalf("got: %v, want: %s", err, ErrEmailIsInUse)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+}
+
+func TestRepositoryLoginIsUsed(t *testing.T) {
+	db, mock := newDatabaseMock()
+	repo := NewRepository(db)
+
+	query := regexp.QuoteMeta(`SELECT EXISTS(SELECT 1 FROM registration WHERE lower(login) = lower($1) AND email <> $2)`)
+	mock.ExpectQuery(query).
+		WithArgs("Test", "test@test.com").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	used, err := repo.LoginIsUsed(context.Background(), "Test", "test@test.com")
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	if !used {
+		t.Fatalf("got: false, want: true")
+	}
+}
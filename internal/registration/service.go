@@ -2,23 +2,50 @@ package registration
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
-	"math/rand"
+	"encoding/hex"
+	"math/big"
+	"strconv"
 	"time"
 
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/bcrypt"
 
+	"gitlab.com/slirx/newproj/internal/api/auth"
 	"gitlab.com/slirx/newproj/pkg/api"
 	"gitlab.com/slirx/newproj/pkg/queue"
 	"gitlab.com/slirx/newproj/pkg/queue/manager"
 	"gitlab.com/slirx/newproj/pkg/template"
 	"gitlab.com/slirx/newproj/pkg/tracer"
+	"gitlab.com/slirx/newproj/pkg/validation"
+)
+
+const (
+	// codeTTL is lifetime of confirmation code.
+	codeTTL = 24 * time.Hour
+	// maxAttempts is the number of invalid codes after which confirmation is locked until a new code is sent.
+	maxAttempts = 5
+	// resendInterval is the minimum interval between two codes sent to the same email.
+	resendInterval = time.Minute
+	// maxResends is the number of codes which can be sent again during resendWindow.
+	maxResends = 5
+	// resendWindow is the period which maxResends is counted for. It starts from the first code of the window.
+	resendWindow = 24 * time.Hour
+)
+
+// Field errors of used login and email. Reserved logins are reported as used too.
+var (
+	loginIsInUse = api.FieldError{Field: "login", Code: api.FieldCodeTaken, Message: ErrLoginIsInUse.Error()}
+	emailIsInUse = api.FieldError{Field: "email", Code: api.FieldCodeTaken, Message: ErrEmailIsInUse.Error()}
 )
 
 type Service interface {
 	Register(ctx context.Context, request RegisterRequest) (string, error)
 	Confirm(ctx context.Context, request ConfirmRequest) (string, error)
+	Resend(ctx context.Context, request ResendRequest) (string, error)
 }
 
 type service struct {
@@ -26,61 +53,95 @@ type service struct {
 	Repository        Repository
 	Manager           manager.Manager
 	TemplateGenerator template.Generator
-	ReservedWords     map[string]struct{}
+	Catalog           template.Catalog
+	InternalAuthAPI   auth.API
+	ReservedLogins    validation.Reserved
 }
 
+// Register creates registration and sends confirmation code. Registration of the same email which is not confirmed
+// yet is replaced, so user can fix login or request a new code. The same limits as for Resend are applied to it.
 func (s service) Register(ctx context.Context, request RegisterRequest) (string, error) {
-	var err error
+	if err := s.validate(ctx, request); err != nil {
+		return "", err
+	}
 
-	// check reserved words
-	if _, ok := s.ReservedWords[request.Login]; ok {
-		return "", api.NewRequestError(errors.New("login is already in use"))
+	code, err := newCode()
+	if err != nil {
+		return "", err
 	}
 
-	// generate random code
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	code := 10000 + r.Intn(89999)
+	codeHash := hashCode(request.Email, code)
 
-	if err = s.Repository.Register(ctx, request, code); err != nil {
+	data, err := s.Repository.ConfirmationData(ctx, request.Email)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return "", err
+		}
+
+		err = s.Repository.Register(ctx, request, codeHash)
+	} else {
+		var resends int
+		if resends, err = nextResends(data); err != nil {
+			return "", err
+		}
+
+		err = s.Repository.UpdateCode(ctx, request, codeHash, resends)
+	}
+
+	if err != nil {
+		return "", registrationError(err)
+	}
+
+	if err = s.sendCode(ctx, request.Email, request.Locale, code); err != nil {
 		return "", err
 	}
 
-	var htmlTemplate string
-	var textTemplate string
+	return "you have been successfully registered. please, confirm your email", nil
+}
 
-	requestID := s.Tracer.RequestID(ctx)
-	emailConfirmation := EmailConfirmation{Code: code}
+// Resend sends a new confirmation code. The same message is returned in case email is unknown or already confirmed,
+// so the endpoint can't be used to find out registered emails.
+func (s service) Resend(ctx context.Context, request ResendRequest) (string, error) {
+	msg := "in case the email is waiting for confirmation, a new code has been sent"
 
-	htmlTemplate, err = s.TemplateGenerator.Generate(
-		template.TypeHTML,
-		"template/registration/email/confirmation.html",
-		emailConfirmation,
-	)
+	data, err := s.Repository.ConfirmationData(ctx, request.Email)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return msg, nil
+		}
+
 		return "", err
 	}
 
-	textTemplate, err = s.TemplateGenerator.Generate(
-		template.TypeText,
-		"template/registration/email/confirmation.txt",
-		emailConfirmation,
-	)
+	if data.Confirmed {
+		return msg, nil
+	}
+
+	resends, err := nextResends(data)
 	if err != nil {
 		return "", err
 	}
 
-	err = s.Manager.Send(ctx, queue.JobEmailSend, queue.Email{
-		RequestID:      requestID,
-		RecipientEmail: request.Email,
-		Subject:        "Registration Confirmation",
-		HTML:           htmlTemplate,
-		Text:           textTemplate,
-	})
+	code, err := newCode()
 	if err != nil {
 		return "", err
 	}
 
-	return "you have been successfully registered. please, confirm your email", nil
+	err = s.Repository.UpdateCode(
+		ctx,
+		RegisterRequest{Login: data.Login, Email: request.Email},
+		hashCode(request.Email, code),
+		resends,
+	)
+	if err != nil {
+		return "", registrationError(err)
+	}
+
+	if err = s.sendCode(ctx, request.Email, request.Locale, code); err != nil {
+		return "", err
+	}
+
+	return msg, nil
 }
 
 func (s service) Confirm(ctx context.Context, request ConfirmRequest) (string, error) {
@@ -97,14 +158,29 @@ func (s service) Confirm(ctx context.Context, request ConfirmRequest) (string, e
 		return "", err
 	}
 
-	if request.Code == 0 || confirmationData.Code != request.Code {
-		return "", api.NewRequestError(errors.New("confirmation code is invalid"))
+	if confirmationData.Confirmed {
+		return "", api.NewRequestError(errors.New("email is already confirmed"))
 	}
 
-	if confirmationData.CreatedAt.Before(time.Now().Add(-24 * time.Hour)) {
+	if confirmationData.CodeSentAt.Before(time.Now().Add(-codeTTL)) {
 		return "", api.NewRequestError(errors.New("confirmation code is timed out"))
 	}
 
+	// attempt is counted before the code is checked, so concurrent guesses can't exceed the limit
+	counted, err := s.Repository.IncrAttempts(ctx, request.Email, maxAttempts)
+	if err != nil {
+		return "", err
+	}
+
+	if !counted {
+		return "", api.NewRequestError(errors.New("too many invalid codes. please, request a new one"))
+	}
+
+	codeHash := hashCode(request.Email, request.Code)
+	if request.Code == 0 || subtle.ConstantTimeCompare([]byte(codeHash), []byte(confirmationData.CodeHash)) != 1 {
+		return "", api.NewRequestError(errors.New("confirmation code is invalid"))
+	}
+
 	var passwordHash []byte
 
 	passwordHash, err = bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
@@ -113,16 +189,18 @@ func (s service) Confirm(ctx context.Context, request ConfirmRequest) (string, e
 	}
 
 	if err = s.Repository.Confirm(ctx, request.Email); err != nil {
+		// registration is confirmed by concurrent request
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", api.NewRequestError(errors.New("email is already confirmed"))
+		}
+
 		return "", err
 	}
 
-	requestID := s.Tracer.RequestID(ctx)
-
 	err = s.Manager.Send(ctx, queue.JobAuthCreate, queue.AuthCreate{
-		RequestID: requestID,
-		Login:     confirmationData.Login,
-		Email:     request.Email,
-		Password:  string(passwordHash),
+		Login:    confirmationData.Login,
+		Email:    request.Email,
+		Password: string(passwordHash),
 	})
 	if err != nil {
 		return "", err
@@ -131,24 +209,153 @@ func (s service) Confirm(ctx context.Context, request ConfirmRequest) (string, e
 	return "you have successfully confirmed your email. you can log in now", nil
 }
 
+// validate checks format of login and email and that they are not used by registered users or other registrations.
+// Errors of all fields are returned at once as api.ValidationError.
+func (s service) validate(ctx context.Context, request RegisterRequest) error {
+	fieldErrors := make([]api.FieldError, 0)
+
+	if err := validation.Login(request.Login); err != nil {
+		fieldErrors = append(fieldErrors, api.NewFieldError("login", err))
+	} else if s.ReservedLogins.Contains(request.Login) {
+		fieldErrors = append(fieldErrors, loginIsInUse)
+	}
+
+	if err := validation.Email(request.Email); err != nil {
+		fieldErrors = append(fieldErrors, api.NewFieldError("email", err))
+	}
+
+	if len(fieldErrors) > 0 {
+		return api.NewValidationError(fieldErrors...)
+	}
+
+	loginIsUsed, err := s.Repository.LoginIsUsed(ctx, request.Login, request.Email)
+	if err != nil {
+		return err
+	}
+
+	// registration is removed after confirmation, so registered users are checked in auth service
+	availability, err := s.InternalAuthAPI.Availability(ctx, request.Login, request.Email)
+	if err != nil {
+		return err
+	}
+
+	if loginIsUsed || availability.LoginTaken {
+		fieldErrors = append(fieldErrors, loginIsInUse)
+	}
+
+	if availability.EmailTaken {
+		fieldErrors = append(fieldErrors, emailIsInUse)
+	}
+
+	if len(fieldErrors) > 0 {
+		return api.NewValidationError(fieldErrors...)
+	}
+
+	return nil
+}
+
+// sendCode sends email with confirmation code to email queue.
+func (s service) sendCode(ctx context.Context, email string, locale string, code int) error {
+	emailConfirmation := EmailConfirmation{Code: code}
+
+	htmlTemplate, err := s.TemplateGenerator.Generate(
+		template.TypeHTML,
+		"template/registration/email/confirmation.html",
+		locale,
+		emailConfirmation,
+	)
+	if err != nil {
+		return err
+	}
+
+	textTemplate, err := s.TemplateGenerator.Generate(
+		template.TypeText,
+		"template/registration/email/confirmation.txt",
+		locale,
+		emailConfirmation,
+	)
+	if err != nil {
+		return err
+	}
+
+	return s.Manager.Send(ctx, queue.JobEmailSend, queue.Email{
+		RecipientEmail: email,
+		Subject:        s.Catalog.Message(locale, "registration.confirmation.subject"),
+		HTML:           htmlTemplate,
+		Text:           textTemplate,
+		Locale:         template.NormalizeLocale(locale),
+	})
+}
+
+// nextResends checks whether a new code can be sent to not confirmed registration and returns the number of resends
+// including the new one.
+func nextResends(data *ConfirmationData) (int, error) {
+	if data.Confirmed {
+		return 0, api.NewValidationError(emailIsInUse)
+	}
+
+	if time.Since(data.CodeSentAt) < resendInterval {
+		return 0, api.NewRequestError(errors.New("code has been sent recently. please, wait a minute"))
+	}
+
+	// the previous window is over, so the counter starts again
+	if time.Since(data.CodeSentAt) > resendWindow {
+		return 1, nil
+	}
+
+	if data.Resends >= maxResends {
+		return 0, api.NewRequestError(errors.New("too many codes have been sent. please, try again tomorrow"))
+	}
+
+	return data.Resends + 1, nil
+}
+
+// registrationError converts errors of repository about used email or login to request errors.
+func registrationError(err error) error {
+	switch {
+	case errors.Is(err, ErrEmailIsInUse):
+		return api.NewValidationError(emailIsInUse)
+	case errors.Is(err, ErrLoginIsInUse):
+		return api.NewValidationError(loginIsInUse)
+	}
+
+	return err
+}
+
+// newCode returns random 6-digit confirmation code.
+func newCode() (int, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(900000))
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return 100000 + int(n.Int64()), nil
+}
+
+// hashCode returns hash of confirmation code. Email is mixed in, so the same code of different registrations has
+// different hashes.
+func hashCode(email string, code int) string {
+	hash := sha256.Sum256([]byte(email + ":" + strconv.Itoa(code)))
+
+	return hex.EncodeToString(hash[:])
+}
+
 func NewService(
 	tracer tracer.Tracer,
 	repository Repository,
 	m manager.Manager,
 	tg template.Generator,
+	c template.Catalog,
+	internalAuthAPI auth.API,
+	config Config,
 ) Service {
-	reserved := make(map[string]struct{})
-	reserved["admin"] = struct{}{}
-	reserved["edit"] = struct{}{}
-	reserved["settings"] = struct{}{}
-	reserved["profile"] = struct{}{}
-	reserved["user"] = struct{}{}
-
 	return service{
 		Tracer:            tracer,
 		Repository:        repository,
 		Manager:           m,
 		TemplateGenerator: tg,
-		ReservedWords:     reserved,
+		Catalog:           c,
+		InternalAuthAPI:   internalAuthAPI,
+		ReservedLogins:    validation.NewReserved(config.ReservedLogins),
 	}
 }
@@ -8,14 +8,51 @@ import (
 
 	"github.com/pkg/errors"
 
+	"gitlab.com/slirx/newproj/internal/api/auth"
+	"gitlab.com/slirx/newproj/pkg/api"
+	"gitlab.com/slirx/newproj/pkg/queue"
 	"gitlab.com/slirx/newproj/pkg/queue/manager"
 	"gitlab.com/slirx/newproj/pkg/template"
 	"gitlab.com/slirx/newproj/pkg/tracer"
 )
 
+// testTracer returns the same request id for all requests.
+var testTracer = tracer.Mock{
+	RequestIDFn: func(ctx context.Context) string {
+		return "req1"
+	},
+}
+
+// catalogMock returns message keys prefixed with locale.
+var catalogMock = template.CatalogMock{
+	MessageFn: func(locale string, key string, args ...interface{}) string {
+		return locale + ":" + key
+	},
+}
+
+// authAPIMock reports that login and email are not used by registered users.
+var authAPIMock = auth.Mock{
+	AvailabilityFn: func(ctx context.Context, login string, email string) (*auth.Availability, error) {
+		return &auth.Availability{}, nil
+	},
+}
+
+var testConfig = Config{ReservedLogins: []string{"admin", "settings"}}
+
+func loginIsNotUsed(ctx context.Context, login string, email string) (bool, error) {
+	return false, nil
+}
+
+func attemptIsCounted(ctx context.Context, email string, maxAttempts int) (bool, error) {
+	return true, nil
+}
+
 func TestServiceRegisterSuccess(t *testing.T) {
-	rMock := repositoryMock{}
-	rMock.RegisterFn = func(ctx context.Context, request RegisterRequest, code int) error {
+	rMock := repositoryMock{LoginIsUsedFn: loginIsNotUsed}
+	rMock.ConfirmationDataFn = func(ctx context.Context, email string) (*ConfirmationData, error) {
+		return nil, errors.WithStack(sql.ErrNoRows)
+	}
+	rMock.RegisterFn = func(ctx context.Context, request RegisterRequest, codeHash string) error {
 		return nil
 	}
 
@@ -24,20 +61,23 @@ func TestServiceRegisterSuccess(t *testing.T) {
 		return "req1"
 	}
 
+	var email queue.Email
+
 	m := manager.Mock{}
 	m.SendFn = func(ctx context.Context, routingKey string, msg interface{}) error {
+		email = msg.(queue.Email)
 		return nil
 	}
 
 	g := template.Mock{}
-	g.GenerateFn = func(t template.GeneratorType, fileName string, data interface{}) (string, error) {
-		return "template-content", nil
+	g.GenerateFn = func(t template.GeneratorType, fileName string, locale string, data interface{}) (string, error) {
+		return "template-content:" + locale, nil
 	}
 
-	s := NewService(tMock, rMock, m, g)
+	s := NewService(tMock, rMock, m, g, catalogMock, authAPIMock, testConfig)
 
 	ctx := context.Background()
-	request := RegisterRequest{}
+	request := RegisterRequest{Login: "john", Email: "test@test.com", Locale: "de_AT"}
 
 	msg, err := s.Register(ctx, request)
 	if err != nil {
@@ -48,11 +88,22 @@ func TestServiceRegisterSuccess(t *testing.T) {
 	if msg != want {
 		t.Fatalf("got: %s, want: %s", msg, want)
 	}
+
+	if email.Subject != "de_AT:registration.confirmation.subject" || email.HTML != "template-content:de_AT" {
+		t.Fatalf("got: %s/%s, want: localized email", email.Subject, email.HTML)
+	}
+
+	if email.Locale != "de-at" {
+		t.Fatalf("got: %s, want: de-at", email.Locale)
+	}
 }
 
 func TestServiceRegisterAlreadyUsedLoginError(t *testing.T) {
-	rMock := repositoryMock{}
-	rMock.RegisterFn = func(ctx context.Context, request RegisterRequest, code int) error {
+	rMock := repositoryMock{LoginIsUsedFn: loginIsNotUsed}
+	rMock.ConfirmationDataFn = func(ctx context.Context, email string) (*ConfirmationData, error) {
+		return nil, errors.WithStack(sql.ErrNoRows)
+	}
+	rMock.RegisterFn = func(ctx context.Context, request RegisterRequest, codeHash string) error {
 		return nil
 	}
 
@@ -67,15 +118,16 @@ func TestServiceRegisterAlreadyUsedLoginError(t *testing.T) {
 	}
 
 	g := template.Mock{}
-	g.GenerateFn = func(t template.GeneratorType, fileName string, data interface{}) (string, error) {
+	g.GenerateFn = func(t template.GeneratorType, fileName string, locale string, data interface{}) (string, error) {
 		return "template-content", nil
 	}
 
-	s := NewService(tMock, rMock, m, g)
+	s := NewService(tMock, rMock, m, g, catalogMock, authAPIMock, testConfig)
 
 	ctx := context.Background()
 	request := RegisterRequest{
 		Login: "admin",
+		Email: "test@test.com",
 	}
 
 	wantErr := "login is already in use"
@@ -98,8 +150,11 @@ func TestServiceRegisterAlreadyUsedLoginError(t *testing.T) {
 func TestServiceRegisterRepositoryError(t *testing.T) {
 	wantErr := "some error"
 
-	rMock := repositoryMock{}
-	rMock.RegisterFn = func(ctx context.Context, request RegisterRequest, code int) error {
+	rMock := repositoryMock{LoginIsUsedFn: loginIsNotUsed}
+	rMock.ConfirmationDataFn = func(ctx context.Context, email string) (*ConfirmationData, error) {
+		return nil, errors.WithStack(sql.ErrNoRows)
+	}
+	rMock.RegisterFn = func(ctx context.Context, request RegisterRequest, codeHash string) error {
 		return errors.New(wantErr)
 	}
 
@@ -114,15 +169,16 @@ func TestServiceRegisterRepositoryError(t *testing.T) {
 	}
 
 	g := template.Mock{}
-	g.GenerateFn = func(t template.GeneratorType, fileName string, data interface{}) (string, error) {
+	g.GenerateFn = func(t template.GeneratorType, fileName string, locale string, data interface{}) (string, error) {
 		return "template-content", nil
 	}
 
-	s := NewService(tMock, rMock, m, g)
+	s := NewService(tMock, rMock, m, g, catalogMock, authAPIMock, testConfig)
 
 	ctx := context.Background()
 	request := RegisterRequest{
-		Login: "test-user",
+		Login: "test_user",
+		Email: "test@test.com",
 	}
 
 	msg, err := s.Register(ctx, request)
@@ -141,8 +197,11 @@ func TestServiceRegisterRepositoryError(t *testing.T) {
 }
 
 func TestServiceRegisterHTMLTemplateGeneratorError(t *testing.T) {
-	rMock := repositoryMock{}
-	rMock.RegisterFn = func(ctx context.Context, request RegisterRequest, code int) error {
+	rMock := repositoryMock{LoginIsUsedFn: loginIsNotUsed}
+	rMock.ConfirmationDataFn = func(ctx context.Context, email string) (*ConfirmationData, error) {
+		return nil, errors.WithStack(sql.ErrNoRows)
+	}
+	rMock.RegisterFn = func(ctx context.Context, request RegisterRequest, codeHash string) error {
 		return nil
 	}
 
@@ -159,7 +218,7 @@ func TestServiceRegisterHTMLTemplateGeneratorError(t *testing.T) {
 	wantErr := "generator error"
 
 	g := template.Mock{}
-	g.GenerateFn = func(t template.GeneratorType, fileName string, data interface{}) (string, error) {
+	g.GenerateFn = func(t template.GeneratorType, fileName string, locale string, data interface{}) (string, error) {
 		if t == template.TypeHTML {
 			return "", errors.New(wantErr)
 		}
@@ -167,11 +226,12 @@ func TestServiceRegisterHTMLTemplateGeneratorError(t *testing.T) {
 		return "some-template", nil
 	}
 
-	s := NewService(tMock, rMock, m, g)
+	s := NewService(tMock, rMock, m, g, catalogMock, authAPIMock, testConfig)
 
 	ctx := context.Background()
 	request := RegisterRequest{
-		Login: "test-user",
+		Login: "test_user",
+		Email: "test@test.com",
 	}
 
 	msg, err := s.Register(ctx, request)
@@ -190,8 +250,11 @@ func TestServiceRegisterHTMLTemplateGeneratorError(t *testing.T) {
 }
 
 func TestServiceRegisterTextTemplateGeneratorError(t *testing.T) {
-	rMock := repositoryMock{}
-	rMock.RegisterFn = func(ctx context.Context, request RegisterRequest, code int) error {
+	rMock := repositoryMock{LoginIsUsedFn: loginIsNotUsed}
+	rMock.ConfirmationDataFn = func(ctx context.Context, email string) (*ConfirmationData, error) {
+		return nil, errors.WithStack(sql.ErrNoRows)
+	}
+	rMock.RegisterFn = func(ctx context.Context, request RegisterRequest, codeHash string) error {
 		return nil
 	}
 
@@ -208,7 +271,7 @@ func TestServiceRegisterTextTemplateGeneratorError(t *testing.T) {
 	wantErr := "generator error"
 
 	g := template.Mock{}
-	g.GenerateFn = func(t template.GeneratorType, fileName string, data interface{}) (string, error) {
+	g.GenerateFn = func(t template.GeneratorType, fileName string, locale string, data interface{}) (string, error) {
 		if t == template.TypeText {
 			return "", errors.New(wantErr)
 		}
@@ -216,11 +279,12 @@ func TestServiceRegisterTextTemplateGeneratorError(t *testing.T) {
 		return "some-template", nil
 	}
 
-	s := NewService(tMock, rMock, m, g)
+	s := NewService(tMock, rMock, m, g, catalogMock, authAPIMock, testConfig)
 
 	ctx := context.Background()
 	request := RegisterRequest{
-		Login: "test-user",
+		Login: "test_user",
+		Email: "test@test.com",
 	}
 
 	msg, err := s.Register(ctx, request)
@@ -239,8 +303,11 @@ func TestServiceRegisterTextTemplateGeneratorError(t *testing.T) {
 }
 
 func TestServiceRegisterManagerError(t *testing.T) {
-	rMock := repositoryMock{}
-	rMock.RegisterFn = func(ctx context.Context, request RegisterRequest, code int) error {
+	rMock := repositoryMock{LoginIsUsedFn: loginIsNotUsed}
+	rMock.ConfirmationDataFn = func(ctx context.Context, email string) (*ConfirmationData, error) {
+		return nil, errors.WithStack(sql.ErrNoRows)
+	}
+	rMock.RegisterFn = func(ctx context.Context, request RegisterRequest, codeHash string) error {
 		return nil
 	}
 
@@ -257,15 +324,16 @@ func TestServiceRegisterManagerError(t *testing.T) {
 	}
 
 	g := template.Mock{}
-	g.GenerateFn = func(t template.GeneratorType, fileName string, data interface{}) (string, error) {
+	g.GenerateFn = func(t template.GeneratorType, fileName string, locale string, data interface{}) (string, error) {
 		return "some-template", nil
 	}
 
-	s := NewService(tMock, rMock, m, g)
+	s := NewService(tMock, rMock, m, g, catalogMock, authAPIMock, testConfig)
 
 	ctx := context.Background()
 	request := RegisterRequest{
-		Login: "test-user",
+		Login: "test_user",
+		Email: "test@test.com",
 	}
 
 	msg, err := s.Register(ctx, request)
@@ -287,11 +355,13 @@ func TestServiceConfirmSuccess(t *testing.T) {
 	repositoryMock := repositoryMock{}
 	repositoryMock.ConfirmationDataFn = func(ctx context.Context, email string) (*ConfirmationData, error) {
 		return &ConfirmationData{
-			Code:      12345,
-			Login:     "john",
-			CreatedAt: time.Now(),
+			CodeHash:   hashCode("test@test.com", 12345),
+			Login:      "john",
+			CodeSentAt: time.Now(),
+			CreatedAt:  time.Now(),
 		}, nil
 	}
+	repositoryMock.IncrAttemptsFn = attemptIsCounted
 	repositoryMock.ConfirmFn = func(ctx context.Context, email string) error {
 		return nil
 	}
@@ -307,7 +377,7 @@ func TestServiceConfirmSuccess(t *testing.T) {
 	}
 
 	g := template.Mock{}
-	s := NewService(tracerMock, repositoryMock, m, g)
+	s := NewService(tracerMock, repositoryMock, m, g, catalogMock, authAPIMock, testConfig)
 
 	ctx := context.Background()
 	request := ConfirmRequest{
@@ -341,7 +411,7 @@ func TestServiceConfirmEmailIsUnregisteredError(t *testing.T) {
 
 	m := manager.Mock{}
 	g := template.Mock{}
-	s := NewService(tracerMock, repositoryMock, m, g)
+	s := NewService(tracerMock, repositoryMock, m, g, catalogMock, authAPIMock, testConfig)
 
 	ctx := context.Background()
 	request := ConfirmRequest{
@@ -371,11 +441,13 @@ func TestServiceConfirmInvalidConfirmationCodeError(t *testing.T) {
 	repositoryMock := repositoryMock{}
 	repositoryMock.ConfirmationDataFn = func(ctx context.Context, email string) (*ConfirmationData, error) {
 		return &ConfirmationData{
-			Code:      11111,
-			Login:     "john",
-			CreatedAt: time.Now(),
+			CodeHash:   hashCode("test@test.com", 11111),
+			Login:      "john",
+			CodeSentAt: time.Now(),
+			CreatedAt:  time.Now(),
 		}, nil
 	}
+	repositoryMock.IncrAttemptsFn = attemptIsCounted
 
 	tracerMock := tracer.Mock{}
 	tracerMock.RequestIDFn = func(ctx context.Context) string {
@@ -384,7 +456,7 @@ func TestServiceConfirmInvalidConfirmationCodeError(t *testing.T) {
 
 	m := manager.Mock{}
 	g := template.Mock{}
-	s := NewService(tracerMock, repositoryMock, m, g)
+	s := NewService(tracerMock, repositoryMock, m, g, catalogMock, authAPIMock, testConfig)
 
 	ctx := context.Background()
 	request := ConfirmRequest{
@@ -425,7 +497,7 @@ func TestServiceConfirmRepositoryConfirmationDataError(t *testing.T) {
 
 	m := manager.Mock{}
 	g := template.Mock{}
-	s := NewService(tracerMock, repositoryMock, m, g)
+	s := NewService(tracerMock, repositoryMock, m, g, catalogMock, authAPIMock, testConfig)
 
 	ctx := context.Background()
 	request := ConfirmRequest{
@@ -456,11 +528,13 @@ func TestServiceConfirmRepositoryConfirmError(t *testing.T) {
 	repositoryMock := repositoryMock{}
 	repositoryMock.ConfirmationDataFn = func(ctx context.Context, email string) (*ConfirmationData, error) {
 		return &ConfirmationData{
-			Code:      12345,
-			Login:     "john",
-			CreatedAt: time.Now(),
+			CodeHash:   hashCode("test@test.com", 12345),
+			Login:      "john",
+			CodeSentAt: time.Now(),
+			CreatedAt:  time.Now(),
 		}, nil
 	}
+	repositoryMock.IncrAttemptsFn = attemptIsCounted
 	repositoryMock.ConfirmFn = func(ctx context.Context, email string) error {
 		return errors.New(wantErr)
 	}
@@ -472,7 +546,7 @@ func TestServiceConfirmRepositoryConfirmError(t *testing.T) {
 
 	m := manager.Mock{}
 	g := template.Mock{}
-	s := NewService(tracerMock, repositoryMock, m, g)
+	s := NewService(tracerMock, repositoryMock, m, g, catalogMock, authAPIMock, testConfig)
 
 	ctx := context.Background()
 	request := ConfirmRequest{
@@ -503,11 +577,13 @@ func TestServiceConfirmManagerError(t *testing.T) {
 	repositoryMock := repositoryMock{}
 	repositoryMock.ConfirmationDataFn = func(ctx context.Context, email string) (*ConfirmationData, error) {
 		return &ConfirmationData{
-			Code:      12345,
-			Login:     "john",
-			CreatedAt: time.Now(),
+			CodeHash:   hashCode("test@test.com", 12345),
+			Login:      "john",
+			CodeSentAt: time.Now(),
+			CreatedAt:  time.Now(),
 		}, nil
 	}
+	repositoryMock.IncrAttemptsFn = attemptIsCounted
 	repositoryMock.ConfirmFn = func(ctx context.Context, email string) error {
 		return nil
 	}
@@ -523,7 +599,7 @@ func TestServiceConfirmManagerError(t *testing.T) {
 	}
 
 	g := template.Mock{}
-	s := NewService(tracerMock, repositoryMock, m, g)
+	s := NewService(tracerMock, repositoryMock, m, g, catalogMock, authAPIMock, testConfig)
 
 	ctx := context.Background()
 	request := ConfirmRequest{
@@ -552,9 +628,10 @@ func TestServiceConfirmTimedOutConfirmationCodeError(t *testing.T) {
 	repositoryMock := repositoryMock{}
 	repositoryMock.ConfirmationDataFn = func(ctx context.Context, email string) (*ConfirmationData, error) {
 		return &ConfirmationData{
-			Code:      12345,
-			Login:     "john",
-			CreatedAt: time.Now().Add(-25 * time.Hour),
+			CodeHash:   hashCode("test@test.com", 12345),
+			Login:      "john",
+			CodeSentAt: time.Now().Add(-25 * time.Hour),
+			CreatedAt:  time.Now().Add(-25 * time.Hour),
 		}, nil
 	}
 
@@ -565,7 +642,7 @@ func TestServiceConfirmTimedOutConfirmationCodeError(t *testing.T) {
 
 	m := manager.Mock{}
 	g := template.Mock{}
-	s := NewService(tracerMock, repositoryMock, m, g)
+	s := NewService(tracerMock, repositoryMock, m, g, catalogMock, authAPIMock, testConfig)
 
 	ctx := context.Background()
 	request := ConfirmRequest{
@@ -601,7 +678,7 @@ func TestServiceConfirmEmptyPasswordError(t *testing.T) {
 
 	m := manager.Mock{}
 	g := template.Mock{}
-	s := NewService(tracerMock, repositoryMock, m, g)
+	s := NewService(tracerMock, repositoryMock, m, g, catalogMock, authAPIMock, testConfig)
 
 	ctx := context.Background()
 	request := ConfirmRequest{
@@ -637,7 +714,7 @@ func TestServiceConfirmDifferentPasswordError(t *testing.T) {
 
 	m := manager.Mock{}
 	g := template.Mock{}
-	s := NewService(tracerMock, repositoryMock, m, g)
+	s := NewService(tracerMock, repositoryMock, m, g, catalogMock, authAPIMock, testConfig)
 
 	ctx := context.Background()
 	request := ConfirmRequest{
@@ -673,7 +750,7 @@ func TestServiceConfirmMinLenPasswordError(t *testing.T) {
 
 	m := manager.Mock{}
 	g := template.Mock{}
-	s := NewService(tracerMock, repositoryMock, m, g)
+	s := NewService(tracerMock, repositoryMock, m, g, catalogMock, authAPIMock, testConfig)
 
 	ctx := context.Background()
 	request := ConfirmRequest{
@@ -698,3 +775,291 @@ func TestServiceConfirmMinLenPasswordError(t *testing.T) {
 		t.Fatalf("got: %s, want: %s", msg, want)
 	}
 }
+
+func TestServiceRegisterTwice(t *testing.T) {
+	var codeHash string
+	var resends int
+
+	data := &ConfirmationData{Login: "john", Resends: 2, CodeSentAt: time.Now().Add(-time.Hour)}
+
+	rMock := repositoryMock{LoginIsUsedFn: loginIsNotUsed}
+	rMock.ConfirmationDataFn = func(ctx context.Context, email string) (*ConfirmationData, error) {
+		return data, nil
+	}
+	rMock.UpdateCodeFn = func(ctx context.Context, request RegisterRequest, hash string, r int) error {
+		if request.Login != "johnny" {
+			t.Fatalf("got: %s, want: johnny", request.Login)
+		}
+
+		codeHash, resends = hash, r
+
+		return nil
+	}
+
+	var code int
+
+	g := template.Mock{}
+	g.GenerateFn = func(t template.GeneratorType, fileName string, locale string, data interface{}) (string, error) {
+		code = data.(EmailConfirmation).Code
+		return "template-content", nil
+	}
+
+	m := manager.Mock{}
+	m.SendFn = func(ctx context.Context, routingKey string, msg interface{}) error {
+		return nil
+	}
+
+	s := NewService(testTracer, rMock, m, g, catalogMock, authAPIMock, testConfig)
+	request := RegisterRequest{Login: "johnny", Email: "test@test.com"}
+
+	// not confirmed registration gets a new code, only hash of the code is stored
+	if _, err := s.Register(context.Background(), request); err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	if code < 100000 || code > 999999 || codeHash != hashCode("test@test.com", code) || resends != 3 {
+		t.Fatalf("got: %d/%s/%d, want: 6-digit code, its hash and 3 resends", code, codeHash, resends)
+	}
+
+	cases := []struct {
+		data    ConfirmationData
+		wantErr string
+	}{
+		{
+			data:    ConfirmationData{Confirmed: true, CodeSentAt: time.Now().Add(-time.Hour)},
+			wantErr: "email is already in use",
+		},
+		{
+			data:    ConfirmationData{CodeSentAt: time.Now()},
+			wantErr: "code has been sent recently. please, wait a minute",
+		},
+		{
+			data:    ConfirmationData{Resends: maxResends, CodeSentAt: time.Now().Add(-time.Hour)},
+			wantErr: "too many codes have been sent. please, try again tomorrow",
+		},
+	}
+
+	for _, c := range cases {
+		*data = c.data
+
+		_, err := s.Register(context.Background(), request)
+		if err == nil || err.Error() != c.wantErr {
+			t.Fatalf("got: %v, want: %s", err, c.wantErr)
+		}
+	}
+
+	// the window is over, so resends are counted again
+	*data = ConfirmationData{Resends: maxResends, CodeSentAt: time.Now().Add(-resendWindow - time.Hour)}
+
+	if _, err := s.Register(context.Background(), request); err != nil || resends != 1 {
+		t.Fatalf("got: %v/%d, want: nil/1", err, resends)
+	}
+}
+
+func TestServiceResend(t *testing.T) {
+	registrations := map[string]*ConfirmationData{
+		"pending@test.com":   {Login: "john", CodeSentAt: time.Now().Add(-time.Hour)},
+		"confirmed@test.com": {Login: "jane", Confirmed: true},
+	}
+
+	updated := make([]string, 0)
+
+	rMock := repositoryMock{LoginIsUsedFn: loginIsNotUsed}
+	rMock.ConfirmationDataFn = func(ctx context.Context, email string) (*ConfirmationData, error) {
+		data, ok := registrations[email]
+		if !ok {
+			return nil, errors.WithStack(sql.ErrNoRows)
+		}
+
+		return data, nil
+	}
+	rMock.UpdateCodeFn = func(ctx context.Context, request RegisterRequest, codeHash string, resends int) error {
+		if request.Login != "john" || resends != 1 {
+			t.Fatalf("got: %s/%d, want: john/1", request.Login, resends)
+		}
+
+		updated = append(updated, request.Email)
+
+		return nil
+	}
+
+	sent := 0
+
+	m := manager.Mock{}
+	m.SendFn = func(ctx context.Context, routingKey string, msg interface{}) error {
+		sent++
+		return nil
+	}
+
+	g := template.Mock{}
+	g.GenerateFn = func(t template.GeneratorType, fileName string, locale string, data interface{}) (string, error) {
+		return "template-content", nil
+	}
+
+	s := NewService(testTracer, rMock, m, g, catalogMock, authAPIMock, testConfig)
+	want := "in case the email is waiting for confirmation, a new code has been sent"
+
+	// the same message is returned for all emails
+	for _, email := range []string{"pending@test.com", "confirmed@test.com", "unknown@test.com"} {
+		msg, err := s.Resend(context.Background(), ResendRequest{Email: email})
+		if err != nil {
+			t.Fatalf("%s: got: %s, want: nil", email, err)
+		}
+
+		if msg != want {
+			t.Fatalf("%s: got: %s, want: %s", email, msg, want)
+		}
+	}
+
+	if len(updated) != 1 || updated[0] != "pending@test.com" || sent != 1 {
+		t.Fatalf("got: %v/%d, want: code is sent only to pending@test.com", updated, sent)
+	}
+}
+
+func TestServiceRegisterValidationError(t *testing.T) {
+	rMock := repositoryMock{}
+	rMock.LoginIsUsedFn = func(ctx context.Context, login string, email string) (bool, error) {
+		return login == "jane", nil
+	}
+
+	authAPI := auth.Mock{
+		AvailabilityFn: func(ctx context.Context, login string, email string) (*auth.Availability, error) {
+			return &auth.Availability{LoginTaken: login == "john", EmailTaken: email == "used@test.com"}, nil
+		},
+	}
+
+	s := NewService(testTracer, rMock, manager.Mock{}, template.Mock{}, catalogMock, authAPI, testConfig)
+
+	cases := []struct {
+		request RegisterRequest
+		want    api.ValidationError
+	}{
+		{
+			request: RegisterRequest{Login: "jo", Email: "test"},
+			want: api.ValidationError{
+				{Field: "login", Code: "length", Message: "login length should be from 3 to 30 symbols"},
+				{Field: "email", Code: "invalid", Message: "email is invalid"},
+			},
+		},
+		{
+			// the first letter is cyrillic
+			request: RegisterRequest{Login: "аdmin", Email: "test@test.com"},
+			want: api.ValidationError{
+				{
+					Field:   "login",
+					Code:    "confusable",
+					Message: `login contains 'а' which looks like latin 'a'. please, use latin letters`,
+				},
+			},
+		},
+		{
+			request: RegisterRequest{Login: "SETT1NGS", Email: "test@test.com"},
+			want:    api.ValidationError{loginIsInUse},
+		},
+		{
+			request: RegisterRequest{Login: "jane", Email: "test@test.com"},
+			want:    api.ValidationError{loginIsInUse},
+		},
+		{
+			request: RegisterRequest{Login: "john", Email: "used@test.com"},
+			want: api.ValidationError{
+				loginIsInUse,
+				emailIsInUse,
+			},
+		},
+	}
+
+	for _, c := range cases {
+		_, err := s.Register(context.Background(), c.request)
+
+		var got api.ValidationError
+		if !errors.As(err, &got) {
+			t.Fatalf("%s: got: %v, want: field errors", c.request.Login, err)
+		}
+
+		if len(got) != len(c.want) {
+			t.Fatalf("%s: got: %v, want: %v", c.request.Login, got, c.want)
+		}
+
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Fatalf("%s: got: %v, want: %v", c.request.Login, got[i], c.want[i])
+			}
+		}
+	}
+}
+
+func TestServiceConfirmTooManyAttemptsError(t *testing.T) {
+	repositoryMock := repositoryMock{}
+	repositoryMock.ConfirmationDataFn = func(ctx context.Context, email string) (*ConfirmationData, error) {
+		return &ConfirmationData{
+			CodeHash:   hashCode("test@test.com", 12345),
+			Login:      "john",
+			CodeSentAt: time.Now(),
+			CreatedAt:  time.Now(),
+		}, nil
+	}
+	// attempts have been made by concurrent requests, even the valid code is rejected
+	repositoryMock.IncrAttemptsFn = func(ctx context.Context, email string, maxAttempts int) (bool, error) {
+		if maxAttempts != 5 {
+			t.Fatalf("got: %d, want: 5", maxAttempts)
+		}
+
+		return false, nil
+	}
+
+	s := NewService(testTracer, repositoryMock, manager.Mock{}, template.Mock{}, catalogMock, authAPIMock, testConfig)
+
+	request := ConfirmRequest{
+		Email:                "test@test.com",
+		Code:                 12345,
+		Password:             "test-test-123",
+		PasswordConfirmation: "test-test-123",
+	}
+
+	_, err := s.Confirm(context.Background(), request)
+
+	wantErr := "too many invalid codes. please, request a new one"
+	if err == nil || err.Error() != wantErr {
+		t.Fatalf("got: %v, want: %s", err, wantErr)
+	}
+}
+
+func TestServiceConfirmConcurrentlyError(t *testing.T) {
+	repositoryMock := repositoryMock{}
+	repositoryMock.ConfirmationDataFn = func(ctx context.Context, email string) (*ConfirmationData, error) {
+		return &ConfirmationData{
+			CodeHash:   hashCode("test@test.com", 12345),
+			Login:      "john",
+			CodeSentAt: time.Now(),
+			CreatedAt:  time.Now(),
+		}, nil
+	}
+	repositoryMock.IncrAttemptsFn = attemptIsCounted
+	// registration is confirmed by concurrent request
+	repositoryMock.ConfirmFn = func(ctx context.Context, email string) error {
+		return errors.WithStack(sql.ErrNoRows)
+	}
+
+	m := manager.Mock{}
+	m.SendFn = func(ctx context.Context, routingKey string, msg interface{}) error {
+		t.Fatalf("got: %s job, want: no jobs", routingKey)
+		return nil
+	}
+
+	s := NewService(testTracer, repositoryMock, m, template.Mock{}, catalogMock, authAPIMock, testConfig)
+
+	request := ConfirmRequest{
+		Email:                "test@test.com",
+		Code:                 12345,
+		Password:             "test-test-123",
+		PasswordConfirmation: "test-test-123",
+	}
+
+	_, err := s.Confirm(context.Background(), request)
+
+	wantErr := "email is already confirmed"
+	if err == nil || err.Error() != wantErr {
+		t.Fatalf("got: %v, want: %s", err, wantErr)
+	}
+}
@@ -12,6 +12,8 @@ import (
 	"go.elastic.co/apm"
 	"go.elastic.co/apm/module/apmzap"
 
+	"gitlab.com/slirx/newproj/pkg/event"
+	"gitlab.com/slirx/newproj/pkg/event/publisher"
 	"gitlab.com/slirx/newproj/pkg/logger"
 	"gitlab.com/slirx/newproj/pkg/queue"
 	"gitlab.com/slirx/newproj/pkg/queue/manager"
@@ -22,6 +24,7 @@ type CreateHandler struct {
 	Logger     logger.Logger
 	Repository Repository
 	Manager    manager.Manager
+	Publisher  publisher.Publisher
 }
 
 func (h CreateHandler) Handle(ctx context.Context, msg amqp.Delivery) error {
@@ -32,7 +35,6 @@ func (h CreateHandler) Handle(ctx context.Context, msg amqp.Delivery) error {
 	}
 
 	tx := apm.TransactionFromContext(ctx)
-	tx.Context.SetLabel("request_id", task.RequestID)
 
 	body, err := json.Marshal(task)
 	if err != nil {
@@ -46,15 +48,29 @@ func (h CreateHandler) Handle(ctx context.Context, msg amqp.Delivery) error {
 
 	var userID int
 
+	// every step is idempotent, so the job is retried in case of any error. redelivered job gets id of the user
+	// created by the previous attempt and sends auth update and the event again
 	userID, err = h.Repository.Create(ctx, task)
+	if errors.Is(err, ErrUserExists) {
+		return worker.Permanent(err)
+	}
+
 	if err != nil {
 		return err
 	}
 
 	err = h.Manager.Send(ctx, queue.JobAuthUpdateUserIDAuth, queue.AuthUpdateUserID{
-		RequestID: task.RequestID,
-		Login:     task.Login,
-		UserID:    userID,
+		Login:  task.Login,
+		UserID: userID,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = h.Publisher.Emit(ctx, event.UserRegistered{
+		UserID: userID,
+		Login:  task.Login,
+		Email:  task.Email,
 	})
 	if err != nil {
 		return err
@@ -66,14 +82,61 @@ func (h CreateHandler) Handle(ctx context.Context, msg amqp.Delivery) error {
 	return nil
 }
 
+type UpdateEmailHandler struct {
+	Logger     logger.Logger
+	Repository Repository
+}
+
+// Handle sets email confirmed in auth service to the user.
+func (h UpdateEmailHandler) Handle(ctx context.Context, msg amqp.Delivery) error {
+	task := queue.UserUpdateEmail{}
+
+	if err := gob.NewDecoder(bytes.NewReader(msg.Body)).Decode(&task); err != nil {
+		return worker.Permanent(errors.WithStack(err))
+	}
+
+	tx := apm.TransactionFromContext(ctx)
+
+	body, err := json.Marshal(task)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	tx.Context.SetCustom("request_body", string(body))
+
+	if task.UserID == 0 || task.Email == "" {
+		return worker.Permanent(errors.WithStack(fmt.Errorf("invalid task: %s", body)))
+	}
+
+	h.Logger.Debug(fmt.Sprintf("updating email of user %d", task.UserID), apmzap.TraceContext(ctx)...)
+
+	if err = h.Repository.UpdateEmail(ctx, task.UserID, task.Email); err != nil {
+		return err
+	}
+
+	tx.Result = "success"
+	tx.Outcome = "success"
+
+	return nil
+}
+
+func NewUpdateEmailHandler(l logger.Logger, repository Repository) worker.Handler {
+	return UpdateEmailHandler{
+		Logger:     l,
+		Repository: repository,
+	}
+}
+
 func NewCreateHandler(
 	l logger.Logger,
 	repository Repository,
 	m manager.Manager,
+	p publisher.Publisher,
 ) worker.Handler {
 	return CreateHandler{
 		Logger:     l,
 		Repository: repository,
 		Manager:    m,
+		Publisher:  p,
 	}
 }
@@ -12,8 +12,15 @@ import (
 
 	"gitlab.com/slirx/newproj/pkg/api"
 	"gitlab.com/slirx/newproj/pkg/logger"
+	"gitlab.com/slirx/newproj/pkg/template"
 )
 
+// UnsubscribePage represents data of unsubscribe page.
+type UnsubscribePage struct {
+	Token        string
+	Unsubscribed bool
+}
+
 // Handler represents methods for user HTTP server.
 type Handler interface {
 	// Update updates user's information.
@@ -27,12 +34,21 @@ type Handler interface {
 	InternalFollowers(w http.ResponseWriter, r *http.Request)
 	InternalUsers(w http.ResponseWriter, r *http.Request)
 	InternalGet(w http.ResponseWriter, r *http.Request)
+	UpdateEmailPreferences(w http.ResponseWriter, r *http.Request)
+	// UnsubscribePage renders confirmation page of unsubscribe links from emails. It doesn't change anything, so
+	// link scanners and prefetching of mail clients don't unsubscribe the user.
+	UnsubscribePage(w http.ResponseWriter, r *http.Request)
+	// Unsubscribe unsubscribes the user from emails. It's public, user is identified by signed token.
+	Unsubscribe(w http.ResponseWriter, r *http.Request)
+	InternalDigestRecipients(w http.ResponseWriter, r *http.Request)
+	InternalNewFollowers(w http.ResponseWriter, r *http.Request)
 }
 
 type handler struct {
-	Service         Service
-	Logger          logger.Logger
-	ResponseBuilder api.ResponseBuilder
+	Service           Service
+	Logger            logger.Logger
+	ResponseBuilder   api.ResponseBuilder
+	TemplateGenerator template.Generator
 }
 
 // Update updates user's information.
@@ -271,7 +287,118 @@ func (h handler) InternalGet(w http.ResponseWriter, r *http.Request) {
 	h.ResponseBuilder.DataResponse(ctx, w, response)
 }
 
+func (h handler) UpdateEmailPreferences(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	request := EmailPreferencesRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, api.RequestError)
+		return
+	}
+
+	msg, err := h.Service.UpdateEmailPreferences(ctx, request)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	h.ResponseBuilder.MessageResponse(ctx, w, msg)
+}
+
+// UnsubscribePage renders the page with a form which submits the token of the link (GET). Token is checked only
+// on submit.
+func (h handler) UnsubscribePage(w http.ResponseWriter, r *http.Request) {
+	h.renderUnsubscribePage(w, r, UnsubscribePage{Token: r.URL.Query().Get("token")})
+}
+
+// Unsubscribe handles both submit of unsubscribe page and one-click unsubscribe of mail clients (RFC 8058). In both
+// cases token is passed in query string. Page is rendered for browsers, message response is returned otherwise.
+func (h handler) Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	msg, err := h.Service.Unsubscribe(ctx, r.URL.Query().Get("token"))
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		h.renderUnsubscribePage(w, r, UnsubscribePage{Unsubscribed: true})
+		return
+	}
+
+	h.ResponseBuilder.MessageResponse(ctx, w, msg)
+}
+
+func (h handler) renderUnsubscribePage(w http.ResponseWriter, r *http.Request, data UnsubscribePage) {
+	ctx := r.Context()
+	locale := template.AcceptLanguage(r.Header.Get("Accept-Language"))
+
+	page, err := h.TemplateGenerator.Generate(template.TypeHTML, "template/user/httpd/unsubscribe.html", locale, data)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	// token is a part of the page URL, so it isn't sent to other sites and the page isn't cached
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+
+	_, _ = w.Write([]byte(page))
+}
+
+func (h handler) InternalDigestRecipients(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	latestUserID, _ := strconv.Atoi(r.URL.Query().Get("latest_user_id"))
+	request := DigestRecipientsRequest{
+		Frequency:    r.URL.Query().Get("frequency"),
+		LatestUserID: latestUserID,
+	}
+
+	response, err := h.Service.InternalDigestRecipients(ctx, request)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	h.ResponseBuilder.DataResponse(ctx, w, response)
+}
+
+func (h handler) InternalNewFollowers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	uid, err := strconv.Atoi(chi.URLParam(r, "uid"))
+	if err != nil || uid == 0 {
+		err = api.NewRequestError(errors.New("invalid user id"))
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	since, _ := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+	request := NewFollowersRequest{
+		UserID: uid,
+		Since:  since,
+	}
+
+	response, err := h.Service.InternalNewFollowers(ctx, request)
+	if err != nil {
+		h.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		h.ResponseBuilder.ErrorResponse(ctx, w, err)
+		return
+	}
+
+	h.ResponseBuilder.DataResponse(ctx, w, response)
+}
+
 // NewHandler returns instance of implemented Handler interface.
-func NewHandler(s Service, l logger.Logger, rb api.ResponseBuilder) Handler {
-	return handler{Service: s, Logger: l, ResponseBuilder: rb}
+func NewHandler(s Service, l logger.Logger, rb api.ResponseBuilder, tg template.Generator) Handler {
+	return handler{Service: s, Logger: l, ResponseBuilder: rb, TemplateGenerator: tg}
 }
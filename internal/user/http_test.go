@@ -6,12 +6,14 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/pkg/errors"
 
 	"gitlab.com/slirx/newproj/pkg/api"
 	"gitlab.com/slirx/newproj/pkg/logger"
+	"gitlab.com/slirx/newproj/pkg/template"
 	"gitlab.com/slirx/newproj/pkg/tracer"
 )
 
@@ -37,7 +39,7 @@ func TestHTTPUpdate(t *testing.T) {
 	responseBuilderMock := api.NewResponseBuilder(tracerMock)
 	rec := httptest.NewRecorder()
 
-	h := NewHandler(serviceMock, logger.NewNoop(), responseBuilderMock)
+	h := NewHandler(serviceMock, logger.NewNoop(), responseBuilderMock, template.Mock{})
 	h.Update(rec, r)
 
 	in := rec.Body.String()
@@ -66,11 +68,11 @@ func TestHTTPUpdateRequestError(t *testing.T) {
 	responseBuilderMock := api.NewResponseBuilder(tracerMock)
 	rec := httptest.NewRecorder()
 
-	h := NewHandler(serviceMock, logger.NewNoop(), responseBuilderMock)
+	h := NewHandler(serviceMock, logger.NewNoop(), responseBuilderMock, template.Mock{})
 	h.Update(rec, r)
 
 	in := rec.Body.String()
-	want := `{"request_id":"req1-err","type":"error","message":"invalid request data"}
+	want := `{"request_id":"req1-err","type":"error","message":"invalid request data","code":"invalid_request","errors":[]}
 `
 
 	if in != want {
@@ -104,11 +106,11 @@ func TestHTTPUpdateError(t *testing.T) {
 	responseBuilderMock := api.NewResponseBuilder(tracerMock)
 	rec := httptest.NewRecorder()
 
-	h := NewHandler(serviceMock, logger.NewNoop(), responseBuilderMock)
+	h := NewHandler(serviceMock, logger.NewNoop(), responseBuilderMock, template.Mock{})
 	h.Update(rec, r)
 
 	in := rec.Body.String()
-	want := `{"request_id":"req2-err","type":"error","message":"oops, something went wrong"}
+	want := `{"request_id":"req2-err","type":"error","message":"oops, something went wrong","code":"internal_error","errors":[]}
 `
 
 	if in != want {
@@ -119,3 +121,71 @@ func TestHTTPUpdateError(t *testing.T) {
 		t.Fatalf("got: %d, want: %d", rec.Code, http.StatusInternalServerError)
 	}
 }
+
+func TestHTTPUnsubscribe(t *testing.T) {
+	unsubscribed := 0
+	serviceMock := serviceMock{}
+	serviceMock.UnsubscribeFn = func(ctx context.Context, token string) (string, error) {
+		if token != "abc.def" {
+			t.Fatalf("got: %s, want: abc.def", token)
+		}
+
+		unsubscribed++
+
+		return "unsubscribed", nil
+	}
+
+	tracerMock := tracer.Mock{}
+	tracerMock.RequestIDFn = func(ctx context.Context) string {
+		return "req1"
+	}
+
+	// templates are rendered for real, paths are relative to the root of the repository
+	g := template.New()
+	tg := template.Mock{
+		GenerateFn: func(gType template.GeneratorType, fileName string, locale string, data interface{}) (string, error) {
+			return g.Generate(gType, "../../"+fileName, locale, data)
+		},
+	}
+
+	h := NewHandler(serviceMock, logger.NewNoop(), api.NewResponseBuilder(tracerMock), tg)
+
+	// opening of the link only renders the form
+	rec := httptest.NewRecorder()
+	h.UnsubscribePage(rec, httptest.NewRequest(http.MethodGet, "/user/unsubscribe?token=abc.def", nil))
+
+	if unsubscribed != 0 {
+		t.Fatalf("got: %d, want: 0", unsubscribed)
+	}
+
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `action="?token=abc.def"`) {
+		t.Fatalf("got: %d %s, want: form with token", rec.Code, rec.Body.String())
+	}
+
+	// submit of the form
+	r := httptest.NewRequest(http.MethodPost, "/user/unsubscribe?token=abc.def", nil)
+	r.Header.Set("Accept", "text/html,application/xhtml+xml")
+	r.Header.Set("Accept-Language", "de-DE,de;q=0.9")
+	rec = httptest.NewRecorder()
+	h.Unsubscribe(rec, r)
+
+	if unsubscribed != 1 {
+		t.Fatalf("got: %d, want: 1", unsubscribed)
+	}
+
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "keine Zusammenfassungen") {
+		t.Fatalf("got: %d %s, want: localized page", rec.Code, rec.Body.String())
+	}
+
+	// one-click unsubscribe of mail client
+	body := strings.NewReader("List-Unsubscribe=One-Click")
+	r = httptest.NewRequest(http.MethodPost, "/user/unsubscribe?token=abc.def", body)
+	rec = httptest.NewRecorder()
+	h.Unsubscribe(rec, r)
+
+	want := `{"request_id":"req1","type":"success","message":"unsubscribed"}
+`
+	if unsubscribed != 2 || rec.Body.String() != want {
+		t.Fatalf("got: %d %s, want: 2 %s", unsubscribed, rec.Body.String(), want)
+	}
+}
@@ -10,16 +10,20 @@ var _ Service = (*serviceMock)(nil)
 var _ Repository = (*repositoryMock)(nil)
 
 type serviceMock struct {
-	UpdateFn            func(ctx context.Context, request UpdateRequest) (string, error)
-	GetFn               func(ctx context.Context, login string) (*GetResponse, error)
-	MeFn                func(ctx context.Context) (*MeResponse, error)
-	FollowFn            func(ctx context.Context, request FollowRequest) error
-	UnfollowFn          func(ctx context.Context, request UnfollowRequest) error
-	FollowersFn         func(ctx context.Context, request FollowersRequest) (*FollowersResponse, error)
-	FollowingFn         func(ctx context.Context, request FollowingRequest) (*FollowingResponse, error)
-	InternalFollowersFn func(ctx context.Context, uid int) ([]int, error)
-	InternalUsersFn     func(ctx context.Context, request InternalUsersRequest) (*InternalUsersResponse, error)
-	InternalGetFn       func(ctx context.Context, login string) (*GetResponse, error)
+	UpdateFn                   func(ctx context.Context, request UpdateRequest) (string, error)
+	GetFn                      func(ctx context.Context, login string) (*GetResponse, error)
+	MeFn                       func(ctx context.Context) (*MeResponse, error)
+	FollowFn                   func(ctx context.Context, request FollowRequest) error
+	UnfollowFn                 func(ctx context.Context, request UnfollowRequest) error
+	FollowersFn                func(ctx context.Context, request FollowersRequest) (*FollowersResponse, error)
+	FollowingFn                func(ctx context.Context, request FollowingRequest) (*FollowingResponse, error)
+	InternalFollowersFn        func(ctx context.Context, uid int) ([]int, error)
+	InternalUsersFn            func(ctx context.Context, request InternalUsersRequest) (*InternalUsersResponse, error)
+	InternalGetFn              func(ctx context.Context, login string) (*GetResponse, error)
+	UpdateEmailPreferencesFn   func(ctx context.Context, request EmailPreferencesRequest) (string, error)
+	UnsubscribeFn              func(ctx context.Context, token string) (string, error)
+	InternalDigestRecipientsFn func(ctx context.Context, request DigestRecipientsRequest) (*DigestRecipientsResponse, error)
+	InternalNewFollowersFn     func(ctx context.Context, request NewFollowersRequest) (*NewFollowersResponse, error)
 }
 
 func (s serviceMock) Get(ctx context.Context, login string) (*GetResponse, error) {
@@ -58,17 +62,37 @@ func (s serviceMock) InternalGet(ctx context.Context, login string) (*GetRespons
 	return s.InternalGetFn(ctx, login)
 }
 
+func (s serviceMock) UpdateEmailPreferences(ctx context.Context, request EmailPreferencesRequest) (string, error) {
+	return s.UpdateEmailPreferencesFn(ctx, request)
+}
+
+func (s serviceMock) Unsubscribe(ctx context.Context, token string) (string, error) {
+	return s.UnsubscribeFn(ctx, token)
+}
+
+func (s serviceMock) InternalDigestRecipients(ctx context.Context, request DigestRecipientsRequest) (*DigestRecipientsResponse, error) {
+	return s.InternalDigestRecipientsFn(ctx, request)
+}
+
+func (s serviceMock) InternalNewFollowers(ctx context.Context, request NewFollowersRequest) (*NewFollowersResponse, error) {
+	return s.InternalNewFollowersFn(ctx, request)
+}
+
 type repositoryMock struct {
-	CreateFn       func(ctx context.Context, request queue.UserCreate) (int, error)
-	UpdateFn       func(ctx context.Context, uid int, request UpdateRequest) error
-	GetFn          func(ctx context.Context, login string, uid int) (*GetResponse, error)
-	MeFn           func(ctx context.Context, uid int) (*MeResponse, error)
-	FollowFn       func(ctx context.Context, uid int, request FollowRequest) error
-	UnfollowFn     func(ctx context.Context, uid int, request UnfollowRequest) error
-	FollowersFn    func(ctx context.Context, request FollowersRequest, perPage uint8) (*FollowersResponse, error)
-	FollowersIDsFn func(ctx context.Context, uid int) ([]int, error)
-	FollowingFn    func(ctx context.Context, request FollowingRequest, perPage uint8) (*FollowingResponse, error)
-	UsersFn        func(ctx context.Context, request InternalUsersRequest, perPage int) (*InternalUsersResponse, error)
+	CreateFn                 func(ctx context.Context, request queue.UserCreate) (int, error)
+	UpdateFn                 func(ctx context.Context, uid int, request UpdateRequest) error
+	GetFn                    func(ctx context.Context, login string, uid int) (*GetResponse, error)
+	MeFn                     func(ctx context.Context, uid int) (*MeResponse, error)
+	FollowFn                 func(ctx context.Context, uid int, request FollowRequest) error
+	UnfollowFn               func(ctx context.Context, uid int, request UnfollowRequest) error
+	FollowersFn              func(ctx context.Context, request FollowersRequest, perPage uint8) (*FollowersResponse, error)
+	FollowersIDsFn           func(ctx context.Context, uid int) ([]int, error)
+	FollowingFn              func(ctx context.Context, request FollowingRequest, perPage uint8) (*FollowingResponse, error)
+	UsersFn                  func(ctx context.Context, request InternalUsersRequest, perPage int) (*InternalUsersResponse, error)
+	UpdateEmailPreferencesFn func(ctx context.Context, uid int, digest string, locale string) error
+	DigestRecipientsFn       func(ctx context.Context, request DigestRecipientsRequest, perPage int) (*DigestRecipientsResponse, error)
+	NewFollowersFn           func(ctx context.Context, request NewFollowersRequest, limit int) (*NewFollowersResponse, error)
+	UpdateEmailFn            func(ctx context.Context, uid int, email string) error
 }
 
 func (s serviceMock) Update(ctx context.Context, request UpdateRequest) (string, error) {
@@ -114,3 +138,19 @@ func (r repositoryMock) Following(ctx context.Context, request FollowingRequest,
 func (r repositoryMock) Users(ctx context.Context, request InternalUsersRequest, perPage int) (*InternalUsersResponse, error) {
 	return r.UsersFn(ctx, request, perPage)
 }
+
+func (r repositoryMock) UpdateEmailPreferences(ctx context.Context, uid int, digest string, locale string) error {
+	return r.UpdateEmailPreferencesFn(ctx, uid, digest, locale)
+}
+
+func (r repositoryMock) DigestRecipients(ctx context.Context, request DigestRecipientsRequest, perPage int) (*DigestRecipientsResponse, error) {
+	return r.DigestRecipientsFn(ctx, request, perPage)
+}
+
+func (r repositoryMock) NewFollowers(ctx context.Context, request NewFollowersRequest, limit int) (*NewFollowersResponse, error) {
+	return r.NewFollowersFn(ctx, request, limit)
+}
+
+func (r repositoryMock) UpdateEmail(ctx context.Context, uid int, email string) error {
+	return r.UpdateEmailFn(ctx, uid, email)
+}
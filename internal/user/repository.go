@@ -11,7 +11,16 @@ import (
 	"gitlab.com/slirx/newproj/pkg/queue"
 )
 
+// pqUniqueViolation is code of postgres error which is returned in case unique index is violated.
+const pqUniqueViolation = "23505"
+
+// ErrUserExists is returned in case login or email is used by another user.
+var ErrUserExists = errors.New("login or email is used by another user")
+
 type Repository interface {
+	// Create creates user and returns its id. It's idempotent: id of existing user with the same login and email
+	// is returned, so redelivered job doesn't fail. ErrUserExists is returned in case login or email is used
+	// by another user.
 	Create(ctx context.Context, request queue.UserCreate) (int, error)
 	Update(ctx context.Context, uid int, request UpdateRequest) error
 	Get(ctx context.Context, login string, uid int) (*GetResponse, error)
@@ -22,6 +31,11 @@ type Repository interface {
 	Following(ctx context.Context, request FollowingRequest, perPage uint8) (*FollowingResponse, error)
 	FollowersIDs(ctx context.Context, uid int) ([]int, error)
 	Users(ctx context.Context, request InternalUsersRequest, perPage int) (*InternalUsersResponse, error)
+	// UpdateEmailPreferences updates digest frequency and locale of the user. Empty locale is not updated.
+	UpdateEmailPreferences(ctx context.Context, uid int, digest string, locale string) error
+	DigestRecipients(ctx context.Context, request DigestRecipientsRequest, perPage int) (*DigestRecipientsResponse, error)
+	NewFollowers(ctx context.Context, request NewFollowersRequest, limit int) (*NewFollowersResponse, error)
+	UpdateEmail(ctx context.Context, uid int, email string) error
 }
 
 type repository struct {
@@ -32,13 +46,22 @@ func (r repository) Create(ctx context.Context, request queue.UserCreate) (int,
 	var id int
 
 	// todo add name column
+	// the row is updated with the same login in case of conflict, so id of existing user is returned
 	err := r.db.QueryRowContext(
 		ctx,
-		`INSERT INTO "user" (email, login) VALUES($1, $2) RETURNING id`,
+		`INSERT INTO "user" (email, login) VALUES($1, $2)
+		ON CONFLICT (login) DO UPDATE SET login = excluded.login WHERE "user".email = excluded.email
+		RETURNING id`,
 		request.Email,
 		request.Login,
 	).Scan(&id)
 	if err != nil {
+		// nothing is returned in case login is used by user with another email
+		var pqErr *pq.Error
+		if errors.Is(err, sql.ErrNoRows) || (errors.As(err, &pqErr) && pqErr.Code == pqUniqueViolation) {
+			return 0, errors.WithStack(ErrUserExists)
+		}
+
 		return 0, errors.WithStack(err)
 	}
 
@@ -90,8 +113,20 @@ func (r repository) Get(ctx context.Context, login string, uid int) (*GetRespons
 func (r repository) Me(ctx context.Context, uid int) (*MeResponse, error) {
 	response := MeResponse{}
 
-	err := r.db.QueryRowContext(ctx, `SELECT id, login, name, bio, followers, following FROM "user" WHERE id = $1`, uid).
-		Scan(&response.ID, &response.Login, &response.Name, &response.Bio, &response.Followers, &response.Following)
+	err := r.db.QueryRowContext(
+		ctx,
+		`SELECT id, login, name, bio, followers, following, email_digest, locale FROM "user" WHERE id = $1`,
+		uid,
+	).Scan(
+		&response.ID,
+		&response.Login,
+		&response.Name,
+		&response.Bio,
+		&response.Followers,
+		&response.Following,
+		&response.EmailDigest,
+		&response.Locale,
+	)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -383,6 +418,127 @@ func (r repository) Users(ctx context.Context, request InternalUsersRequest, per
 	return response, nil
 }
 
+func (r repository) UpdateEmailPreferences(ctx context.Context, uid int, digest string, locale string) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		`UPDATE "user" SET email_digest = $1, locale = COALESCE(NULLIF($2, ''), locale) WHERE id = $3`,
+		digest,
+		locale,
+		uid,
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (r repository) UpdateEmail(ctx context.Context, uid int, email string) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE "user" SET email = $1 WHERE id = $2`, email, uid)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// DigestRecipients returns users who receive digest with requested frequency. Users are ordered by id, so all of them
+// can be fetched page by page.
+func (r repository) DigestRecipients(
+	ctx context.Context,
+	request DigestRecipientsRequest,
+	perPage int,
+) (*DigestRecipientsResponse, error) {
+	response := DigestRecipientsResponse{
+		Users: make([]DigestRecipient, 0, perPage),
+	}
+
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT id, login, name, email, locale FROM "user" WHERE email_digest = $1 AND id > $2 ORDER BY id LIMIT $3`,
+		request.Frequency,
+		request.LatestUserID,
+		perPage,
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	defer rows.Close()
+
+	var u DigestRecipient
+	for rows.Next() {
+		if err = rows.Scan(&u.ID, &u.Login, &u.Name, &u.Email, &u.Locale); err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		response.Users = append(response.Users, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &response, nil
+}
+
+// NewFollowers returns number of users who started following the user since request.Since and the latest of them.
+func (r repository) NewFollowers(
+	ctx context.Context,
+	request NewFollowersRequest,
+	limit int,
+) (*NewFollowersResponse, error) {
+	response := NewFollowersResponse{
+		Followers: make([]Follower, 0, limit),
+	}
+
+	err := r.db.QueryRowContext(
+		ctx,
+		`SELECT COUNT(1) FROM follower WHERE user_id = $1 AND created_at >= to_timestamp($2)`,
+		request.UserID,
+		request.Since,
+	).Scan(&response.Total)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if response.Total == 0 {
+		return &response, nil
+	}
+
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT follower.id AS follower_id, "user".id AS user_id, "user".login, "user".name FROM "user"
+			JOIN follower ON follower.follower_id = "user".id
+			WHERE follower.user_id = $1 AND follower.created_at >= to_timestamp($2)
+			ORDER BY follower.id DESC
+			LIMIT $3`,
+		request.UserID,
+		request.Since,
+		limit,
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	defer rows.Close()
+
+	var f Follower
+	for rows.Next() {
+		if err = rows.Scan(&f.FollowerID, &f.UserID, &f.Login, &f.Name); err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		response.Followers = append(response.Followers, f)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &response, nil
+}
+
 func NewRepository(db *sql.DB) Repository {
 	return repository{db: db}
 }
@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 
 	"gitlab.com/slirx/newproj/pkg/queue"
@@ -32,14 +33,13 @@ func TestRepositoryCreate(t *testing.T) {
 
 	rows := sqlmock.NewRows([]string{"id"}).AddRow(id)
 
-	exec := regexp.QuoteMeta(`INSERT INTO "user" (email, login) VALUES($1, $2) RETURNING id`)
+	exec := regexp.QuoteMeta(`INSERT INTO "user" (email, login) VALUES($1, $2)`)
 	mock.ExpectQuery(exec).WithArgs(email, login).WillReturnRows(rows)
 
 	ctx := context.Background()
 	request := queue.UserCreate{
-		RequestID: "",
-		Login:     login,
-		Email:     email,
+		Login: login,
+		Email: email,
 	}
 
 	uid, err := repo.Create(ctx, request)
@@ -60,14 +60,13 @@ func TestRepositoryCreateError(t *testing.T) {
 	login := "test"
 	wantErr := "create error"
 
-	exec := regexp.QuoteMeta(`INSERT INTO "user" (email, login) VALUES($1, $2) RETURNING id`)
+	exec := regexp.QuoteMeta(`INSERT INTO "user" (email, login) VALUES($1, $2)`)
 	mock.ExpectQuery(exec).WithArgs(email, login).WillReturnError(errors.New(wantErr))
 
 	ctx := context.Background()
 	request := queue.UserCreate{
-		RequestID: "",
-		Login:     login,
-		Email:     email,
+		Login: login,
+		Email: email,
 	}
 
 	uid, err := repo.Create(ctx, request)
@@ -84,6 +83,25 @@ func TestRepositoryCreateError(t *testing.T) {
 	}
 }
 
+func TestRepositoryCreateExists(t *testing.T) {
+	db, mock := newDatabaseMock()
+	repo := NewRepository(db)
+
+	exec := regexp.QuoteMeta(`INSERT INTO "user" (email, login) VALUES($1, $2)`)
+
+	// login is used by user with another email, so conflicting row is not updated
+	mock.ExpectQuery(exec).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	// email is used by user with another login
+	mock.ExpectQuery(exec).WillReturnError(&pq.Error{Code: pqUniqueViolation, Constraint: "user_email_uindex"})
+
+	for i := 0; i < 2; i++ {
+		_, err := repo.Create(context.Background(), queue.UserCreate{Login: "test", Email: "test@test.com"})
+		if !errors.Is(err, ErrUserExists) {
+			t.Fatalf("got: %v, want: %s", err, ErrUserExists)
+		}
+	}
+}
+
 func TestRepositoryUpdate(t *testing.T) {
 	db, mock := newDatabaseMock()
 	repo := NewRepository(db)
@@ -107,6 +125,18 @@ func TestRepositoryUpdate(t *testing.T) {
 	}
 }
 
+func TestRepositoryUpdateEmail(t *testing.T) {
+	db, mock := newDatabaseMock()
+	repo := NewRepository(db)
+
+	exec := regexp.QuoteMeta(`UPDATE "user" SET email = $1 WHERE id = $2`)
+	mock.ExpectExec(exec).WithArgs("new@example.com", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := repo.UpdateEmail(context.Background(), 1, "new@example.com"); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+}
+
 func TestRepositoryUpdateError(t *testing.T) {
 	db, mock := newDatabaseMock()
 	repo := NewRepository(db)
@@ -134,3 +164,31 @@ func TestRepositoryUpdateError(t *testing.T) {
 		t.Fatalf("got: %s, want: %s", err.Error(), wantErr)
 	}
 }
+
+func TestRepositoryDigestRecipients(t *testing.T) {
+	db, mock := newDatabaseMock()
+	repo := NewRepository(db)
+
+	request := DigestRecipientsRequest{
+		Frequency:    DigestWeekly,
+		LatestUserID: 10,
+	}
+
+	rows := sqlmock.NewRows([]string{"id", "login", "name", "email", "locale"}).
+		AddRow(11, "john", "John", "john@example.com", "en").
+		AddRow(15, "jane", "", "jane@example.com", "de")
+
+	exec := regexp.QuoteMeta(
+		`SELECT id, login, name, email, locale FROM "user" WHERE email_digest = $1 AND id > $2 ORDER BY id LIMIT $3`,
+	)
+	mock.ExpectQuery(exec).WithArgs(DigestWeekly, 10, 2).WillReturnRows(rows)
+
+	response, err := repo.DigestRecipients(context.Background(), request, 2)
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	if len(response.Users) != 2 || response.Users[1].ID != 15 || response.Users[1].Locale != "de" {
+		t.Fatalf("got: %+v, want: users 11 and 15", response.Users)
+	}
+}
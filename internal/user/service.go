@@ -5,17 +5,27 @@ import (
 	"database/sql"
 
 	"github.com/pkg/errors"
+	"go.elastic.co/apm/module/apmzap"
 
 	"gitlab.com/slirx/newproj/internal/api/media"
 	"gitlab.com/slirx/newproj/pkg/api"
+	"gitlab.com/slirx/newproj/pkg/event"
+	"gitlab.com/slirx/newproj/pkg/event/publisher"
 	"gitlab.com/slirx/newproj/pkg/http/jwtmiddleware"
+	"gitlab.com/slirx/newproj/pkg/logger"
 	"gitlab.com/slirx/newproj/pkg/queue"
 	"gitlab.com/slirx/newproj/pkg/queue/manager"
+	"gitlab.com/slirx/newproj/pkg/template"
 	"gitlab.com/slirx/newproj/pkg/tracer"
+	"gitlab.com/slirx/newproj/pkg/unsubscribe"
 )
 
 const (
 	usersPerPage = 20
+	// digestRecipientsPerPage is bigger than usersPerPage, because digest recipients are fetched by background job.
+	digestRecipientsPerPage = 100
+	// newFollowersLimit is the maximum number of new followers shown in email digest.
+	newFollowersLimit = 5
 )
 
 type Service interface {
@@ -29,13 +39,21 @@ type Service interface {
 	InternalFollowers(ctx context.Context, uid int) ([]int, error)
 	InternalUsers(ctx context.Context, request InternalUsersRequest) (*InternalUsersResponse, error)
 	InternalGet(ctx context.Context, login string) (*GetResponse, error)
+	UpdateEmailPreferences(ctx context.Context, request EmailPreferencesRequest) (string, error)
+	// Unsubscribe turns email digest off for the user identified by signed token from unsubscribe link.
+	Unsubscribe(ctx context.Context, token string) (string, error)
+	InternalDigestRecipients(ctx context.Context, request DigestRecipientsRequest) (*DigestRecipientsResponse, error)
+	InternalNewFollowers(ctx context.Context, request NewFollowersRequest) (*NewFollowersResponse, error)
 }
 
 type service struct {
-	Repository       Repository
-	Tracer           tracer.Tracer
-	Manager          manager.Manager
-	InternalMediaAPI media.API
+	Repository        Repository
+	Tracer            tracer.Tracer
+	Manager           manager.Manager
+	Publisher         publisher.Publisher
+	InternalMediaAPI  media.API
+	UnsubscribeSecret []byte // secret which unsubscribe links are signed with
+	Logger            logger.Logger
 }
 
 func (s service) Update(ctx context.Context, request UpdateRequest) (string, error) {
@@ -109,10 +127,7 @@ func (s service) Follow(ctx context.Context, request FollowRequest) error {
 		return err
 	}
 
-	requestID := s.Tracer.RequestID(ctx)
-
 	err = s.Manager.Send(ctx, queue.JobPostFollow, queue.PostFollow{
-		RequestID:    requestID,
 		UserID:       uid,
 		FollowUserID: request.UserID,
 	})
@@ -124,6 +139,15 @@ func (s service) Follow(ctx context.Context, request FollowRequest) error {
 		return err
 	}
 
+	// following is already saved, so failed event doesn't fail the request
+	err = s.Publisher.Emit(ctx, event.UserFollowed{
+		UserID:       uid,
+		FollowUserID: request.UserID,
+	})
+	if err != nil {
+		s.Logger.Error(err, apmzap.TraceContext(ctx)...)
+	}
+
 	return nil
 }
 
@@ -133,10 +157,7 @@ func (s service) Unfollow(ctx context.Context, request UnfollowRequest) error {
 		return err
 	}
 
-	requestID := s.Tracer.RequestID(ctx)
-
 	err = s.Manager.Send(ctx, queue.JobPostUnfollow, queue.PostUnfollow{
-		RequestID:      requestID,
 		UserID:         uid,
 		UnfollowUserID: request.UserID,
 	})
@@ -148,6 +169,15 @@ func (s service) Unfollow(ctx context.Context, request UnfollowRequest) error {
 		return err
 	}
 
+	// unfollowing is already saved, so failed event doesn't fail the request
+	err = s.Publisher.Emit(ctx, event.UserUnfollowed{
+		UserID:         uid,
+		UnfollowUserID: request.UserID,
+	})
+	if err != nil {
+		s.Logger.Error(err, apmzap.TraceContext(ctx)...)
+	}
+
 	return nil
 }
 
@@ -257,16 +287,68 @@ func (s service) InternalGet(ctx context.Context, login string) (*GetResponse, e
 	return response, nil
 }
 
+func (s service) UpdateEmailPreferences(ctx context.Context, request EmailPreferencesRequest) (string, error) {
+	if err := request.Validate(); err != nil {
+		return "", api.NewRequestError(err)
+	}
+
+	uid, err := jwtmiddleware.UID(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.Repository.UpdateEmailPreferences(ctx, uid, request.Digest, template.NormalizeLocale(request.Locale))
+	if err != nil {
+		return "", err
+	}
+
+	return "email preferences have been successfully updated", nil
+}
+
+func (s service) Unsubscribe(ctx context.Context, token string) (string, error) {
+	uid, err := unsubscribe.ParseToken(s.UnsubscribeSecret, token)
+	if err != nil {
+		return "", api.NewRequestError(err)
+	}
+
+	if err = s.Repository.UpdateEmailPreferences(ctx, uid, DigestOff, ""); err != nil {
+		return "", err
+	}
+
+	return "you have been successfully unsubscribed", nil
+}
+
+func (s service) InternalDigestRecipients(
+	ctx context.Context,
+	request DigestRecipientsRequest,
+) (*DigestRecipientsResponse, error) {
+	if request.Frequency != DigestDaily && request.Frequency != DigestWeekly {
+		return nil, api.NewRequestError(errors.New("invalid digest frequency"))
+	}
+
+	return s.Repository.DigestRecipients(ctx, request, digestRecipientsPerPage)
+}
+
+func (s service) InternalNewFollowers(ctx context.Context, request NewFollowersRequest) (*NewFollowersResponse, error) {
+	return s.Repository.NewFollowers(ctx, request, newFollowersLimit)
+}
+
 func NewService(
 	repository Repository,
 	tracer tracer.Tracer,
 	m manager.Manager,
+	p publisher.Publisher,
 	internalMediaAPI media.API,
+	unsubscribeSecret []byte,
+	l logger.Logger,
 ) Service {
 	return service{
-		Repository:       repository,
-		Tracer:           tracer,
-		Manager:          m,
-		InternalMediaAPI: internalMediaAPI,
+		Repository:        repository,
+		Tracer:            tracer,
+		Manager:           m,
+		Publisher:         p,
+		InternalMediaAPI:  internalMediaAPI,
+		UnsubscribeSecret: unsubscribeSecret,
+		Logger:            l,
 	}
 }
@@ -7,9 +7,12 @@ import (
 	"github.com/pkg/errors"
 
 	"gitlab.com/slirx/newproj/internal/api/media"
+	"gitlab.com/slirx/newproj/pkg/event/publisher"
 	"gitlab.com/slirx/newproj/pkg/http/jwtmiddleware"
+	"gitlab.com/slirx/newproj/pkg/logger"
 	"gitlab.com/slirx/newproj/pkg/queue/manager"
 	"gitlab.com/slirx/newproj/pkg/tracer"
+	"gitlab.com/slirx/newproj/pkg/unsubscribe"
 )
 
 func TestUpdate(t *testing.T) {
@@ -34,7 +37,7 @@ func TestUpdate(t *testing.T) {
 
 	internalMediaAPIMock := media.Mock{}
 
-	s := NewService(rMock, tracerMock, managerMock, internalMediaAPIMock)
+	s := NewService(rMock, tracerMock, managerMock, publisher.Mock{}, internalMediaAPIMock, nil, logger.Mock{})
 
 	ctx := context.Background()
 	ctx = context.WithValue(ctx, jwtmiddleware.ContextKeyUserID, 1)
@@ -67,7 +70,7 @@ func TestUpdateUIDError(t *testing.T) {
 
 	internalMediaAPIMock := media.Mock{}
 
-	s := NewService(rMock, tracerMock, managerMock, internalMediaAPIMock)
+	s := NewService(rMock, tracerMock, managerMock, publisher.Mock{}, internalMediaAPIMock, nil, logger.Mock{})
 
 	ctx := context.Background()
 	request := UpdateRequest{}
@@ -106,7 +109,7 @@ func TestUpdateRepositoryError(t *testing.T) {
 	// todo remove commented code?
 	internalMediaAPIMock := media.Mock{}
 
-	s := NewService(rMock, tracerMock, managerMock, internalMediaAPIMock)
+	s := NewService(rMock, tracerMock, managerMock, publisher.Mock{}, internalMediaAPIMock, nil, logger.Mock{})
 
 	ctx := context.Background()
 	ctx = context.WithValue(ctx, jwtmiddleware.ContextKeyUserID, 1)
@@ -128,3 +131,69 @@ func TestUpdateRepositoryError(t *testing.T) {
 		t.Fatalf("got: %s, want: %s", msg, want)
 	}
 }
+
+func TestUpdateEmailPreferences(t *testing.T) {
+	var gotDigest, gotLocale string
+
+	rMock := repositoryMock{}
+	rMock.UpdateEmailPreferencesFn = func(ctx context.Context, uid int, digest string, locale string) error {
+		if uid != 1 {
+			t.Fatalf("got: %d, want: 1", uid)
+		}
+
+		gotDigest = digest
+		gotLocale = locale
+
+		return nil
+	}
+
+	s := NewService(rMock, tracer.Mock{}, manager.Mock{}, publisher.Mock{}, media.Mock{}, nil, logger.Mock{})
+
+	ctx := context.WithValue(context.Background(), jwtmiddleware.ContextKeyUserID, 1)
+
+	if _, err := s.UpdateEmailPreferences(ctx, EmailPreferencesRequest{Digest: "hourly"}); err == nil {
+		t.Fatalf("got: nil, want: invalid digest frequency")
+	}
+
+	if _, err := s.UpdateEmailPreferences(ctx, EmailPreferencesRequest{Digest: DigestDaily, Locale: "../en"}); err == nil {
+		t.Fatalf("got: nil, want: invalid locale")
+	}
+
+	if _, err := s.UpdateEmailPreferences(ctx, EmailPreferencesRequest{Digest: DigestDaily, Locale: "de_AT"}); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	if gotDigest != DigestDaily || gotLocale != "de-at" {
+		t.Fatalf("got: %s/%s, want: %s/de-at", gotDigest, gotLocale, DigestDaily)
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	secret := []byte("secret")
+	var gotUID int
+	var gotDigest string
+
+	rMock := repositoryMock{}
+	rMock.UpdateEmailPreferencesFn = func(ctx context.Context, uid int, digest string, locale string) error {
+		gotUID = uid
+		gotDigest = digest
+
+		return nil
+	}
+
+	s := NewService(rMock, tracer.Mock{}, manager.Mock{}, publisher.Mock{}, media.Mock{}, secret, logger.Mock{})
+
+	ctx := context.Background()
+
+	if _, err := s.Unsubscribe(ctx, unsubscribe.NewToken([]byte("another secret"), 5)); err == nil {
+		t.Fatalf("got: nil, want: invalid unsubscribe token")
+	}
+
+	if _, err := s.Unsubscribe(ctx, unsubscribe.NewToken(secret, 5)); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	if gotUID != 5 || gotDigest != DigestOff {
+		t.Fatalf("got: %d/%s, want: 5/%s", gotUID, gotDigest, DigestOff)
+	}
+}
@@ -1,5 +1,18 @@
 package user
 
+import (
+	"github.com/pkg/errors"
+
+	"gitlab.com/slirx/newproj/pkg/template"
+)
+
+// Frequencies of email digest.
+const (
+	DigestOff    = "off"
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
 type UpdateRequest struct {
 	Name string `json:"name"`
 	Bio  string `json:"bio"`
@@ -13,6 +26,10 @@ type MeResponse struct {
 	Bio       string `json:"bio"`
 	Following uint   `json:"following"`
 	Followers uint   `json:"followers"`
+	// EmailDigest is frequency of email digest: DigestOff, DigestDaily or DigestWeekly.
+	EmailDigest string `json:"email_digest"`
+	// Locale is preferred locale of emails, for example "en" or "de".
+	Locale string `json:"locale"`
 }
 
 type GetResponse struct {
@@ -94,3 +111,65 @@ type InternalUsersResponse struct {
 	Total int    `json:"total"`
 	Users []User `json:"users"`
 }
+
+// EmailPreferencesRequest represents request for updating email preferences of the user.
+type EmailPreferencesRequest struct {
+	Digest string `json:"digest"`
+	Locale string `json:"locale"` // optional, locale is not changed in case it's empty
+}
+
+// Validate validates email preferences request.
+func (r EmailPreferencesRequest) Validate() error {
+	if !IsDigestFrequency(r.Digest) {
+		return errors.New("invalid digest frequency")
+	}
+
+	if r.Locale != "" && template.NormalizeLocale(r.Locale) == "" {
+		return errors.New("invalid locale")
+	}
+
+	return nil
+}
+
+// IsDigestFrequency reports whether frequency is one of DigestOff, DigestDaily or DigestWeekly.
+func IsDigestFrequency(frequency string) bool {
+	switch frequency {
+	case DigestOff, DigestDaily, DigestWeekly:
+		return true
+	}
+
+	return false
+}
+
+// DigestRecipientsRequest is used for request in InternalDigestRecipients service.
+type DigestRecipientsRequest struct {
+	Frequency    string
+	LatestUserID int
+}
+
+// DigestRecipient is the user who receives email digest.
+type DigestRecipient struct {
+	ID     int    `json:"id"`
+	Login  string `json:"login"`
+	Name   string `json:"name"`
+	Email  string `json:"email"`
+	Locale string `json:"locale"`
+}
+
+// DigestRecipientsResponse is used for response in InternalDigestRecipients service.
+type DigestRecipientsResponse struct {
+	Users []DigestRecipient `json:"users"`
+}
+
+// NewFollowersRequest is used for request in InternalNewFollowers service.
+type NewFollowersRequest struct {
+	UserID int
+	Since  int64 // unix timestamp
+}
+
+// NewFollowersResponse is used for response in InternalNewFollowers service. It contains total number of new
+// followers and the latest of them.
+type NewFollowersResponse struct {
+	Total     int        `json:"total"`
+	Followers []Follower `json:"followers"`
+}
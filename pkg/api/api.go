@@ -4,7 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"math"
 	"net/http"
+	"strconv"
 
 	"gitlab.com/slirx/newproj/pkg/tracer"
 )
@@ -17,44 +19,9 @@ const (
 	MessageTypeError   MessageType = "error"
 )
 
-var InternalError = errors.New("oops, something went wrong")
-var RequestError = errors.New("invalid request data")
-
-type requestError struct {
-	Err error
-}
-
-func (r requestError) Error() string {
-	return r.Err.Error()
-}
-
-type accessError struct {
-	Err error
-}
-
-func (r accessError) Error() string {
-	return r.Err.Error()
-}
-
-type notFoundError struct {
-	Err error
-}
-
-func (r notFoundError) Error() string {
-	return r.Err.Error()
-}
-
-func NewRequestError(err error) error {
-	return requestError{Err: err}
-}
-
-func NewAccessError(err error) error {
-	return accessError{Err: err}
-}
-
-func NewNotFoundError(err error) error {
-	return notFoundError{Err: err}
-}
+// HeaderRequestTimeout is header of internal requests. It contains time in milliseconds the caller waits for
+// response, so the callee doesn't handle the request after the caller gives up.
+const HeaderRequestTimeout = "X-Request-Timeout"
 
 // Response represents fields which should be in every response.
 type Response struct {
@@ -75,6 +42,16 @@ type DataResponse struct {
 	Data      interface{} `json:"data,omitempty"`
 }
 
+// ErrorResponse represents fields of error responses. Errors is always present, it's empty in case err is not
+// related to fields of the request.
+type ErrorResponse struct {
+	RequestID string       `json:"request_id"`
+	Type      MessageType  `json:"type"`
+	Message   string       `json:"message"`
+	Code      Code         `json:"code"`
+	Errors    []FieldError `json:"errors"`
+}
+
 // todo move these functions to interface, so they can be mocked
 
 // NewResponse creates new instance of Response.
@@ -91,6 +68,42 @@ func NewMessageResponse(requestID string, messageType MessageType, message strin
 	}
 }
 
+// NewErrorResponse creates new instance of ErrorResponse for err and returns HTTP status code of it. Messages of
+// unexpected errors are not exposed, InternalError is used instead.
+func NewErrorResponse(requestID string, err error) (int, ErrorResponse) {
+	status, code, message := http.StatusInternalServerError, CodeInternal, InternalError.Error()
+
+	switch t := err.(type) {
+	case requestError:
+		status, code, message = http.StatusBadRequest, t.Code, t.Error()
+	case accessError:
+		status, code, message = http.StatusForbidden, t.Code, t.Error()
+	case notFoundError:
+		status, code, message = http.StatusNotFound, t.Code, t.Error()
+	case tooManyRequestsError:
+		status, code, message = http.StatusTooManyRequests, t.Code, t.Error()
+	default:
+		if errors.Is(err, RequestError) {
+			status, code, message = http.StatusBadRequest, CodeInvalidRequest, err.Error()
+		}
+	}
+
+	fieldErrors := make([]FieldError, 0)
+
+	var validationError ValidationError
+	if status != http.StatusInternalServerError && errors.As(err, &validationError) {
+		fieldErrors = append(fieldErrors, validationError...)
+	}
+
+	return status, ErrorResponse{
+		RequestID: requestID,
+		Type:      MessageTypeError,
+		Message:   message,
+		Code:      code,
+		Errors:    fieldErrors,
+	}
+}
+
 // NewDataResponse creates new instance of DataResponse.
 func NewDataResponse(requestID string, messageType MessageType, data interface{}) DataResponse {
 	return DataResponse{
@@ -100,21 +113,11 @@ func NewDataResponse(requestID string, messageType MessageType, data interface{}
 	}
 }
 
+// GetErrorResponseFields returns HTTP status code and message of error response for err.
 func GetErrorResponseFields(err error) (int, string) {
-	if errors.Is(err, RequestError) {
-		return http.StatusBadRequest, err.Error()
-	}
+	status, response := NewErrorResponse("", err)
 
-	switch t := err.(type) {
-	case requestError:
-		return http.StatusBadRequest, t.Error()
-	case accessError:
-		return http.StatusForbidden, t.Error()
-	case notFoundError:
-		return http.StatusNotFound, t.Error()
-	default:
-		return http.StatusInternalServerError, InternalError.Error()
-	}
+	return status, response.Message
 }
 
 type ResponseBuilder interface {
@@ -130,10 +133,14 @@ type responseBuilder struct {
 func (r responseBuilder) ErrorResponse(ctx context.Context, w http.ResponseWriter, err error) {
 	w.Header().Set("Content-Type", "application/json")
 
-	responseCode, responseMessage := GetErrorResponseFields(err)
+	responseCode, response := NewErrorResponse(r.Tracer.RequestID(ctx), err)
+
+	if t, ok := err.(tooManyRequestsError); ok && t.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(t.RetryAfter.Seconds()))))
+	}
+
 	w.WriteHeader(responseCode)
 
-	response := NewMessageResponse(r.Tracer.RequestID(ctx), MessageTypeError, responseMessage)
 	_ = json.NewEncoder(w).Encode(response)
 }
 
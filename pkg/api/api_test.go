@@ -1,8 +1,17 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"testing"
+	"time"
+
+	"gitlab.com/slirx/newproj/pkg/tracer"
 )
 
 func TestCorrectResponse(t *testing.T) {
@@ -83,3 +92,120 @@ func TestNewMessageResponse(t *testing.T) {
 		t.Fatalf("got: %s, want: %s", in, want)
 	}
 }
+
+type codedError struct{}
+
+func (e codedError) Error() string {
+	return "login is too short"
+}
+
+func (e codedError) ErrorCode() string {
+	return "length"
+}
+
+func TestNewErrorResponse(t *testing.T) {
+	cases := []struct {
+		err        error
+		wantStatus int
+		want       string
+	}{
+		{
+			err:        NewRequestError(errors.New("invalid user id")),
+			wantStatus: http.StatusBadRequest,
+			want:       `{"request_id":"req1","type":"error","message":"invalid user id","code":"invalid_request","errors":[]}`,
+		},
+		{
+			err:        fmt.Errorf("can not decode request: %w", RequestError),
+			wantStatus: http.StatusBadRequest,
+			want: `{"request_id":"req1","type":"error","message":"can not decode request: invalid request data",` +
+				`"code":"invalid_request","errors":[]}`,
+		},
+		{
+			err:        NewAccessErrorWithCode(CodeTokenExpired, errors.New("token is expired")),
+			wantStatus: http.StatusForbidden,
+			want:       `{"request_id":"req1","type":"error","message":"token is expired","code":"token_expired","errors":[]}`,
+		},
+		{
+			err:        NewTooManyRequestsError(errors.New("try again later"), time.Second),
+			wantStatus: http.StatusTooManyRequests,
+			want: `{"request_id":"req1","type":"error","message":"try again later",` +
+				`"code":"too_many_requests","errors":[]}`,
+		},
+		{
+			err:        NewNotFoundError(errors.New("user not found")),
+			wantStatus: http.StatusNotFound,
+			want:       `{"request_id":"req1","type":"error","message":"user not found","code":"not_found","errors":[]}`,
+		},
+		{
+			err: NewValidationError(
+				NewFieldError("login", codedError{}),
+				NewFieldError("email", errors.New("email is invalid")),
+			),
+			wantStatus: http.StatusBadRequest,
+			want: `{"request_id":"req1","type":"error","message":"login is too short; email is invalid",` +
+				`"code":"validation_failed","errors":[` +
+				`{"field":"login","code":"length","message":"login is too short"},` +
+				`{"field":"email","code":"invalid","message":"email is invalid"}]}`,
+		},
+		{
+			// field errors of unexpected errors are not exposed
+			err:        ValidationError{{Field: "login", Code: FieldCodeTaken, Message: "login is already in use"}},
+			wantStatus: http.StatusInternalServerError,
+			want: `{"request_id":"req1","type":"error","message":"oops, something went wrong",` +
+				`"code":"internal_error","errors":[]}`,
+		},
+	}
+
+	for _, c := range cases {
+		status, response := NewErrorResponse("req1", c.err)
+		if status != c.wantStatus {
+			t.Fatalf("%s: got: %d, want: %d", c.err, status, c.wantStatus)
+		}
+
+		data, err := json.Marshal(response)
+		if err != nil {
+			t.Fatalf("got: %v, want: nil", err)
+		}
+
+		if string(data) != c.want {
+			t.Fatalf("got: %s, want: %s", data, c.want)
+		}
+	}
+}
+
+func TestResponseBuilderErrorResponseRetryAfter(t *testing.T) {
+	rb := NewResponseBuilder(tracer.Mock{
+		RequestIDFn: func(ctx context.Context) string {
+			return "req1"
+		},
+	})
+
+	w := httptest.NewRecorder()
+	err := NewTooManyRequestsError(errors.New("try again later"), 1500*time.Millisecond)
+	rb.ErrorResponse(context.Background(), w, err)
+
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "2" {
+		t.Fatalf("got: %d/%s, want: %d/2", w.Code, w.Header().Get("Retry-After"), http.StatusTooManyRequests)
+	}
+}
+
+func TestNewErrorFromResponse(t *testing.T) {
+	errs := []error{
+		NewRequestError(errors.New("invalid user id")),
+		NewValidationError(NewFieldError("login", codedError{})),
+		NewAccessErrorWithCode(CodeTokenExpired, errors.New("token is expired")),
+		NewNotFoundError(errors.New("user not found")),
+		NewTooManyRequestsError(errors.New("try again later"), time.Second),
+		errors.New("unexpected error"),
+	}
+
+	// error of another service is reported to client the same way
+	for _, err := range errs {
+		status, response := NewErrorResponse("req1", err)
+		gotStatus, got := NewErrorResponse("req1", NewErrorFromResponse(status, response, time.Second))
+
+		if gotStatus != status || !reflect.DeepEqual(got, response) {
+			t.Fatalf("%s: got: %d/%+v, want: %d/%+v", err, gotStatus, got, status, response)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Code is a machine-readable code of error. Clients should rely on codes instead of messages, which can be changed.
+type Code string
+
+// Codes of errors. Every error response contains one of them or a more specific code set by a service.
+const (
+	CodeInternal        Code = "internal_error"
+	CodeInvalidRequest  Code = "invalid_request"
+	CodeValidation      Code = "validation_failed"
+	CodeAccessDenied    Code = "access_denied"
+	CodeNotFound        Code = "not_found"
+	CodeTooManyRequests Code = "too_many_requests"
+
+	CodeInvalidToken Code = "invalid_token"
+	CodeTokenExpired Code = "token_expired"
+	CodeTokenRevoked Code = "token_revoked"
+	// CodeInsufficientScope is returned in case token of third-party application has no scope required by
+	// the endpoint.
+	CodeInsufficientScope Code = "insufficient_scope"
+	// CodeInsufficientPermissions is returned in case role of staff token has no permission required by the endpoint.
+	CodeInsufficientPermissions Code = "insufficient_permissions"
+)
+
+// Codes of field errors.
+const (
+	FieldCodeRequired Code = "required"
+	FieldCodeInvalid  Code = "invalid"
+	FieldCodeTaken    Code = "taken"
+)
+
+var InternalError = errors.New("oops, something went wrong")
+var RequestError = errors.New("invalid request data")
+
+type requestError struct {
+	Code Code
+	Err  error
+}
+
+func (r requestError) Error() string {
+	return r.Err.Error()
+}
+
+func (r requestError) Unwrap() error {
+	return r.Err
+}
+
+type accessError struct {
+	Code Code
+	Err  error
+}
+
+func (r accessError) Error() string {
+	return r.Err.Error()
+}
+
+type notFoundError struct {
+	Code Code
+	Err  error
+}
+
+func (r notFoundError) Error() string {
+	return r.Err.Error()
+}
+
+// NewRequestError returns error of invalid request. CodeValidation is used for ValidationError, CodeInvalidRequest
+// for the others.
+func NewRequestError(err error) error {
+	var validationError ValidationError
+	if errors.As(err, &validationError) {
+		return requestError{Code: CodeValidation, Err: err}
+	}
+
+	return requestError{Code: CodeInvalidRequest, Err: err}
+}
+
+// NewRequestErrorWithCode returns error of invalid request with specific code.
+func NewRequestErrorWithCode(code Code, err error) error {
+	return requestError{Code: code, Err: err}
+}
+
+func NewAccessError(err error) error {
+	return accessError{Code: CodeAccessDenied, Err: err}
+}
+
+// NewAccessErrorWithCode returns access error with specific code.
+func NewAccessErrorWithCode(code Code, err error) error {
+	return accessError{Code: code, Err: err}
+}
+
+func NewNotFoundError(err error) error {
+	return notFoundError{Code: CodeNotFound, Err: err}
+}
+
+// NewNotFoundErrorWithCode returns not found error with specific code.
+func NewNotFoundErrorWithCode(code Code, err error) error {
+	return notFoundError{Code: code, Err: err}
+}
+
+type tooManyRequestsError struct {
+	Code       Code
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (r tooManyRequestsError) Error() string {
+	return r.Err.Error()
+}
+
+// NewTooManyRequestsError returns error of rate limit. retryAfter is sent in Retry-After header in case it's
+// positive.
+func NewTooManyRequestsError(err error, retryAfter time.Duration) error {
+	return tooManyRequestsError{Code: CodeTooManyRequests, Err: err, RetryAfter: retryAfter}
+}
+
+// NewTooManyRequestsErrorWithCode returns error of rate limit with specific code.
+func NewTooManyRequestsErrorWithCode(code Code, err error, retryAfter time.Duration) error {
+	return tooManyRequestsError{Code: code, Err: err, RetryAfter: retryAfter}
+}
+
+// FieldError describes invalid field of request.
+type FieldError struct {
+	Field   string `json:"field"` // name of the field in JSON request
+	Code    Code   `json:"code"`
+	Message string `json:"message"`
+}
+
+// NewFieldError returns FieldError of field. Code of err is used in case err has method ErrorCode, for example
+// errors of validation package, FieldCodeInvalid is used otherwise.
+func NewFieldError(field string, err error) FieldError {
+	code := FieldCodeInvalid
+
+	var coder interface{ ErrorCode() string }
+	if errors.As(err, &coder) {
+		code = Code(coder.ErrorCode())
+	}
+
+	return FieldError{Field: field, Code: code, Message: err.Error()}
+}
+
+// ValidationError contains all invalid fields of request, so user can fix them at once. They are returned in
+// "errors" field of error response.
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, fieldError := range e {
+		messages = append(messages, fieldError.Message)
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// NewValidationError returns request error with fields. It's used when all fields are checked at once.
+func NewValidationError(fields ...FieldError) error {
+	return NewRequestError(ValidationError(fields))
+}
+
+// NewErrorFromResponse returns error of error response of another service, so the error is reported to client
+// the same way as the service reported it. Responses with unexpected status are returned as internal errors.
+func NewErrorFromResponse(status int, response ErrorResponse, retryAfter time.Duration) error {
+	err := errors.New(response.Message)
+	if len(response.Errors) > 0 {
+		err = ValidationError(response.Errors)
+	}
+
+	switch status {
+	case http.StatusBadRequest:
+		return requestError{Code: response.Code, Err: err}
+	case http.StatusForbidden:
+		return accessError{Code: response.Code, Err: err}
+	case http.StatusNotFound:
+		return notFoundError{Code: response.Code, Err: err}
+	case http.StatusTooManyRequests:
+		return tooManyRequestsError{Code: response.Code, Err: err, RetryAfter: retryAfter}
+	default:
+		return fmt.Errorf("unexpected response with status %d: %s (%s)", status, response.Message, response.Code)
+	}
+}
@@ -0,0 +1,211 @@
+// apitoken package contains store of personal API tokens. Users create them for scripts and bots instead of
+// storing passwords. Tokens are created by auth service and kept in its database, every service checks them by
+// jwtmiddleware through redis cache shared by all of them. Only hashes of tokens are stored, the token itself is
+// shown to the user once.
+package apitoken
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gitlab.com/slirx/newproj/pkg/redis"
+)
+
+// Prefix is prefix of every token. It tells tokens from JWTs, and helps to find tokens leaked to public code.
+const Prefix = "mbp_"
+
+// tokenLen is length of token in bytes (before encoding and prefix).
+const tokenLen = 32
+
+const (
+	// cacheTTL is the maximum time token is cached for. Token is loaded from Source again after it, so the cache
+	// doesn't keep tokens which weren't deleted from it because of failure.
+	cacheTTL = 10 * time.Minute
+	// notFoundTTL is the time unknown token is cached for, so the same unknown token isn't loaded from Source on
+	// every request.
+	notFoundTTL = time.Minute
+)
+
+// notFoundValue is cached value of unknown token.
+const notFoundValue = "-"
+
+// ErrNotFound is returned in case token is unknown, expired or revoked.
+var ErrNotFound = errors.New("api token is not found")
+
+// Token is token which is checked by jwtmiddleware.
+type Token struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"uid"`
+	Scopes    []string  `json:"scopes"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Source returns token by hash from the storage which tokens are cached from, for example database of auth service.
+// ErrNotFound is returned in case token is unknown, expired or revoked.
+type Source interface {
+	Token(ctx context.Context, hash string) (*Token, error)
+}
+
+// Store caches tokens of Source.
+type Store interface {
+	// Save caches token by hash, it's used right after the token is created.
+	Save(ctx context.Context, hash string, token Token) error
+	// Delete deletes token with hash from the cache. The token should be deleted from Source beforehand, otherwise
+	// it's loaded again by the next Check.
+	Delete(ctx context.Context, hash string) error
+	// Check returns token by hash and saves at as its last use. Token is loaded from Source in case it isn't
+	// cached. ErrNotFound is returned in case token is unknown, expired or revoked.
+	Check(ctx context.Context, hash string, at time.Time) (*Token, error)
+	// LastUsed returns time of the last use of token with id. Zero time is returned in case the token wasn't used.
+	LastUsed(ctx context.Context, id int) (time.Time, error)
+}
+
+type redisStore struct {
+	Redis  redis.Client
+	Source Source
+}
+
+func (s redisStore) Save(ctx context.Context, hash string, token Token) error {
+	ttl := time.Until(token.ExpiresAt)
+	if ttl <= 0 {
+		return errors.New("api token is already expired")
+	}
+
+	if ttl > cacheTTL {
+		ttl = cacheTTL
+	}
+
+	value, err := json.Marshal(token)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return s.Redis.Set(ctx, key(hash), string(value), ttl)
+}
+
+func (s redisStore) Delete(ctx context.Context, hash string) error {
+	return s.Redis.Del(ctx, key(hash))
+}
+
+func (s redisStore) Check(ctx context.Context, hash string, at time.Time) (*Token, error) {
+	token, err := s.token(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	// redis expires keys with delay, so expiration is checked here too
+	if !at.Before(token.ExpiresAt) {
+		return nil, errors.WithStack(ErrNotFound)
+	}
+
+	if err = s.Redis.Set(ctx, lastUsedKey(token.ID), at.Unix(), token.ExpiresAt.Sub(at)); err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func (s redisStore) LastUsed(ctx context.Context, id int) (time.Time, error) {
+	value, err := s.Redis.Get(ctx, lastUsedKey(id))
+	if err != nil {
+		if errors.Is(err, redis.ErrNoData) {
+			return time.Time{}, nil
+		}
+
+		return time.Time{}, err
+	}
+
+	usedAt, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, errors.WithStack(err)
+	}
+
+	return time.Unix(usedAt, 0), nil
+}
+
+// token returns cached token by hash. Token is loaded from Source and cached in case it isn't cached yet.
+func (s redisStore) token(ctx context.Context, hash string) (*Token, error) {
+	value, err := s.Redis.Get(ctx, key(hash))
+	if err != nil && !errors.Is(err, redis.ErrNoData) {
+		return nil, err
+	}
+
+	if err == nil {
+		if value == notFoundValue {
+			return nil, errors.WithStack(ErrNotFound)
+		}
+
+		token := &Token{}
+		if err = json.Unmarshal([]byte(value), token); err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		return token, nil
+	}
+
+	token, err := s.Source.Token(ctx, hash)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			if err = s.Redis.Set(ctx, key(hash), notFoundValue, notFoundTTL); err != nil {
+				return nil, err
+			}
+
+			return nil, errors.WithStack(ErrNotFound)
+		}
+
+		return nil, err
+	}
+
+	if err = s.Save(ctx, hash, *token); err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func key(hash string) string {
+	return "auth:apitoken:" + hash
+}
+
+func lastUsedKey(id int) string {
+	return fmt.Sprintf("auth:apitoken:used:%d", id)
+}
+
+// New returns a new random token and its hash.
+func New() (string, string, error) {
+	b := make([]byte, tokenLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", errors.WithStack(err)
+	}
+
+	token := Prefix + base64.RawURLEncoding.EncodeToString(b)
+
+	return token, Hash(token), nil
+}
+
+// Hash returns hash of token which is used as its key in Store.
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// IsToken reports whether s looks like API token rather than JWT.
+func IsToken(s string) bool {
+	return strings.HasPrefix(s, Prefix)
+}
+
+// NewStore returns Store which caches tokens of source in redis.
+func NewStore(r redis.Client, source Source) Store {
+	return redisStore{Redis: r, Source: source}
+}
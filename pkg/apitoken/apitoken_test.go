@@ -0,0 +1,138 @@
+package apitoken
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"gitlab.com/slirx/newproj/pkg/redis"
+)
+
+func TestStore(t *testing.T) {
+	data := make(map[string]string)
+
+	r := redis.Mock{
+		SetFn: func(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+			if expiration <= 0 {
+				t.Fatalf("got: %s, want: positive expiration", expiration)
+			}
+
+			data[key] = fmt.Sprint(value)
+
+			return nil
+		},
+		GetFn: func(ctx context.Context, key string) (string, error) {
+			value, ok := data[key]
+			if !ok {
+				return "", redis.ErrNoData
+			}
+
+			return value, nil
+		},
+		DelFn: func(ctx context.Context, keys ...string) error {
+			for _, key := range keys {
+				delete(data, key)
+			}
+
+			return nil
+		},
+	}
+
+	// tokens of the database, redis is only a cache of them
+	tokens := make(map[string]Token)
+	loaded := 0
+
+	source := SourceMock{
+		TokenFn: func(ctx context.Context, hash string) (*Token, error) {
+			loaded++
+
+			token, ok := tokens[hash]
+			if !ok {
+				return nil, ErrNotFound
+			}
+
+			return &token, nil
+		},
+	}
+
+	s := NewStore(r, source)
+	ctx := context.Background()
+	now := time.Now()
+
+	token, hash, err := New()
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	if !IsToken(token) || hash != Hash(token) || hash == Hash(token+"x") {
+		t.Fatalf("got: %s/%s, want: prefixed token with its hash", token, hash)
+	}
+
+	want := Token{ID: 5, UserID: 3, Scopes: []string{"post:write"}, ExpiresAt: now.Add(time.Hour).UTC()}
+	tokens[hash] = want
+
+	if err = s.Save(ctx, hash, want); err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	if usedAt, err := s.LastUsed(ctx, 5); err != nil || !usedAt.IsZero() {
+		t.Fatalf("got: %s/%v, want: zero time", usedAt, err)
+	}
+
+	got, err := s.Check(ctx, hash, now)
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	if got.ID != want.ID || got.UserID != want.UserID || got.Scopes[0] != want.Scopes[0] {
+		t.Fatalf("got: %+v, want: %+v", *got, want)
+	}
+
+	if usedAt, err := s.LastUsed(ctx, 5); err != nil || usedAt.Unix() != now.Unix() {
+		t.Fatalf("got: %s/%v, want: %s", usedAt, err, now)
+	}
+
+	if loaded != 0 {
+		t.Fatalf("got: %d, want: cached token isn't loaded", loaded)
+	}
+
+	// cache is lost, the token is loaded from the source and cached again
+	delete(data, key(hash))
+
+	for i := 0; i < 2; i++ {
+		if got, err = s.Check(ctx, hash, now); err != nil || got.ID != want.ID {
+			t.Fatalf("got: %+v/%v, want: %+v", got, err, want)
+		}
+	}
+
+	if loaded != 1 {
+		t.Fatalf("got: %d, want: token is loaded once", loaded)
+	}
+
+	if _, err = s.Check(ctx, hash, now.Add(time.Hour)); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got: %v, want: %s because token is expired", err, ErrNotFound)
+	}
+
+	delete(tokens, hash)
+
+	if err = s.Delete(ctx, hash); err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	// unknown token is cached too
+	for i := 0; i < 2; i++ {
+		if _, err = s.Check(ctx, hash, now); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("got: %v, want: %s because token is revoked", err, ErrNotFound)
+		}
+	}
+
+	if loaded != 2 {
+		t.Fatalf("got: %d, want: unknown token is loaded once", loaded)
+	}
+
+	if err = s.Save(ctx, hash, Token{ID: 6, ExpiresAt: now.Add(-time.Second)}); err == nil {
+		t.Fatalf("got: nil, want: error because token is expired")
+	}
+}
@@ -0,0 +1,40 @@
+package apitoken
+
+import (
+	"context"
+	"time"
+)
+
+var _ Store = (*Mock)(nil)
+var _ Source = (*SourceMock)(nil)
+
+type Mock struct {
+	SaveFn     func(ctx context.Context, hash string, token Token) error
+	DeleteFn   func(ctx context.Context, hash string) error
+	CheckFn    func(ctx context.Context, hash string, at time.Time) (*Token, error)
+	LastUsedFn func(ctx context.Context, id int) (time.Time, error)
+}
+
+type SourceMock struct {
+	TokenFn func(ctx context.Context, hash string) (*Token, error)
+}
+
+func (m Mock) Save(ctx context.Context, hash string, token Token) error {
+	return m.SaveFn(ctx, hash, token)
+}
+
+func (m Mock) Delete(ctx context.Context, hash string) error {
+	return m.DeleteFn(ctx, hash)
+}
+
+func (m Mock) Check(ctx context.Context, hash string, at time.Time) (*Token, error) {
+	return m.CheckFn(ctx, hash, at)
+}
+
+func (m Mock) LastUsed(ctx context.Context, id int) (time.Time, error) {
+	return m.LastUsedFn(ctx, id)
+}
+
+func (m SourceMock) Token(ctx context.Context, hash string) (*Token, error) {
+	return m.TokenFn(ctx, hash)
+}
@@ -1,11 +1,105 @@
 // event package contains structs for events between microservices. this events are used for publish/subscribe pattern.
 package event
 
-// Registration represents event when user starter registration.
-type Registration struct {
-	RequestID string // id of APM transaction
-	Login     string
-	Email     string
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// Exchange is the name of topic exchange which all domain events are published to.
+const Exchange = "microblog.events"
+
+// Routing keys of domain events. Subscribers can bind to them with wildcards, for example "user.*".
+const (
+	KeyUserRegistered = "user.registered"
+	KeyUserFollowed   = "user.followed"
+	KeyUserUnfollowed = "user.unfollowed"
+	KeyPostCreated    = "post.created"
+)
+
+// Event represents domain event.
+type Event interface {
+	// RoutingKey returns routing key which the event is published with.
+	RoutingKey() string
 }
 
-// todo add Publisher and Subscriber interfaces
+// UserRegistered represents event when user finished registration and user record is created.
+type UserRegistered struct {
+	UserID int
+	Login  string
+	Email  string
+}
+
+func (e UserRegistered) RoutingKey() string {
+	return KeyUserRegistered
+}
+
+// UserFollowed represents event when user started following another user.
+type UserFollowed struct {
+	UserID       int // follower
+	FollowUserID int // followed user
+}
+
+func (e UserFollowed) RoutingKey() string {
+	return KeyUserFollowed
+}
+
+// UserUnfollowed represents event when user stopped following another user.
+type UserUnfollowed struct {
+	UserID         int
+	UnfollowUserID int
+}
+
+func (e UserUnfollowed) RoutingKey() string {
+	return KeyUserUnfollowed
+}
+
+// PostCreated represents event when user published a new post.
+type PostCreated struct {
+	PostID    int
+	UserID    int
+	Text      string
+	CreatedAt int64
+}
+
+func (e PostCreated) RoutingKey() string {
+	return KeyPostCreated
+}
+
+// Decode decodes message body into the event of type defined by routing key.
+func Decode(routingKey string, body []byte) (Event, error) {
+	var e Event
+	var err error
+
+	decoder := gob.NewDecoder(bytes.NewReader(body))
+
+	switch routingKey {
+	case KeyUserRegistered:
+		v := UserRegistered{}
+		err = decoder.Decode(&v)
+		e = v
+	case KeyUserFollowed:
+		v := UserFollowed{}
+		err = decoder.Decode(&v)
+		e = v
+	case KeyUserUnfollowed:
+		v := UserUnfollowed{}
+		err = decoder.Decode(&v)
+		e = v
+	case KeyPostCreated:
+		v := PostCreated{}
+		err = decoder.Decode(&v)
+		e = v
+	default:
+		return nil, errors.WithStack(fmt.Errorf("unknown event: %s", routingKey))
+	}
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return e, nil
+}
@@ -0,0 +1,44 @@
+package event
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	events := []Event{
+		UserRegistered{UserID: 1, Login: "anon", Email: "anon@test.com"},
+		UserFollowed{UserID: 1, FollowUserID: 2},
+		UserUnfollowed{UserID: 1, UnfollowUserID: 2},
+		PostCreated{PostID: 1, UserID: 1, Text: "my post #1", CreatedAt: 100500123},
+	}
+
+	for _, want := range events {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(want); err != nil {
+			t.Fatalf("got: %s, want: nil", err)
+		}
+
+		got, err := Decode(want.RoutingKey(), buf.Bytes())
+		if err != nil {
+			t.Fatalf("got: %s, want: nil", err)
+		}
+
+		if got != want {
+			t.Fatalf("got: %+v, want: %+v", got, want)
+		}
+	}
+}
+
+func TestDecodeUnknownEvent(t *testing.T) {
+	_, err := Decode("user.deleted", nil)
+	if err == nil {
+		t.Fatalf("got: nil, want: unknown event: user.deleted")
+	}
+
+	want := "unknown event: user.deleted"
+	if err.Error() != want {
+		t.Fatalf("got: %s, want: %s", err.Error(), want)
+	}
+}
@@ -0,0 +1,17 @@
+package publisher
+
+import (
+	"context"
+
+	"gitlab.com/slirx/newproj/pkg/event"
+)
+
+var _ Publisher = (*Mock)(nil)
+
+type Mock struct {
+	EmitFn func(ctx context.Context, e event.Event) error
+}
+
+func (m Mock) Emit(ctx context.Context, e event.Event) error {
+	return m.EmitFn(ctx, e)
+}
@@ -2,8 +2,12 @@ package publisher
 
 import (
 	"context"
+
+	"gitlab.com/slirx/newproj/pkg/event"
 )
 
+// Publisher emits domain events to subscribers.
 type Publisher interface {
-	Emit(ctx context.Context, exchange string, msg interface{}) error
+	// Emit publishes the event with its routing key.
+	Emit(ctx context.Context, e event.Event) error
 }
@@ -2,12 +2,21 @@ package publisher
 
 import (
 	"context"
+
+	"gitlab.com/slirx/newproj/pkg/event"
+	"gitlab.com/slirx/newproj/pkg/queue/manager"
 )
 
 type rabbitmqPublisher struct {
+	Manager  manager.Manager
+	Exchange string
+}
+
+func (p rabbitmqPublisher) Emit(ctx context.Context, e event.Event) error {
+	return p.Manager.Publish(ctx, p.Exchange, e.RoutingKey(), e)
 }
 
-func (r rabbitmqPublisher) Emit(ctx context.Context, exchange string, msg interface{}) error {
-	// todo implement
-	return nil
+// NewPublisher returns Publisher which emits events to event.Exchange topic exchange.
+func NewPublisher(m manager.Manager) Publisher {
+	return rabbitmqPublisher{Manager: m, Exchange: event.Exchange}
 }
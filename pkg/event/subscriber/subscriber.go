@@ -1 +1,87 @@
 package subscriber
+
+import (
+	"context"
+
+	"github.com/streadway/amqp"
+	"go.elastic.co/apm"
+
+	"gitlab.com/slirx/newproj/pkg/event"
+	"gitlab.com/slirx/newproj/pkg/logger"
+	"gitlab.com/slirx/newproj/pkg/queue/memory"
+	"gitlab.com/slirx/newproj/pkg/queue/worker"
+	"gitlab.com/slirx/newproj/pkg/rabbitmq"
+)
+
+// Handler handles domain events received by subscriber.
+type Handler interface {
+	Handle(ctx context.Context, e event.Event) error
+}
+
+// Subscriber receives domain events from event.Exchange.
+type Subscriber interface {
+	// Run consumes events until ctx is done.
+	Run(ctx context.Context)
+}
+
+type workerSubscriber struct {
+	Worker      worker.Worker
+	RoutingKeys []string
+}
+
+func (s workerSubscriber) Run(ctx context.Context) {
+	s.Worker.EventListener(ctx, event.Exchange, s.RoutingKeys...)
+}
+
+// eventHandler decodes deliveries into events and passes them to subscriber's handler.
+type eventHandler struct {
+	Handler Handler
+}
+
+func (h eventHandler) Handle(ctx context.Context, msg amqp.Delivery) error {
+	e, err := event.Decode(msg.RoutingKey, msg.Body)
+	if err != nil {
+		return err
+	}
+
+	return h.Handler.Handle(ctx, e)
+}
+
+// NewSubscriber returns Subscriber which consumes events from durable queue named by client's queue name. The queue is
+// bound to routingKeys, so every subscriber (service) receives its own copy of the event even when it's offline.
+func NewSubscriber(
+	name string,
+	client *rabbitmq.Client,
+	l logger.Logger,
+	tracer *apm.Tracer,
+	h Handler,
+	routingKeys []string,
+	o ...worker.Option,
+) Subscriber {
+	return workerSubscriber{
+		Worker:      worker.NewWorker(name, client, l, tracer, eventHandler{Handler: h}, client.QueueName, o...),
+		RoutingKeys: routingKeys,
+	}
+}
+
+// NewMemorySubscriber returns Subscriber which consumes events from in-memory broker. The queue is bound to
+// routingKeys right away, so events published before Run are not dropped.
+func NewMemorySubscriber(
+	name string,
+	b *memory.Broker,
+	queueName string,
+	l logger.Logger,
+	tracer *apm.Tracer,
+	h Handler,
+	routingKeys []string,
+	o ...worker.Option,
+) Subscriber {
+	for _, key := range routingKeys {
+		b.Bind(queueName, event.Exchange, key)
+	}
+
+	return workerSubscriber{
+		Worker:      worker.NewMemoryWorker(name, b, l, tracer, eventHandler{Handler: h}, queueName, o...),
+		RoutingKeys: routingKeys,
+	}
+}
@@ -40,6 +40,10 @@ func MultiWriter(l io.Writer, w http.ResponseWriter) http.ResponseWriter {
 // Wrap wraps h such that it will report requests as transactions
 // to Elastic APM, using route in the transaction name.
 //
+// Transaction continues the trace from W3C traceparent and tracestate
+// headers of the request, so internal requests sent by api.GeneralAPI
+// are parts of the trace of the caller.
+//
 // By default, the returned Handle will use apm.DefaultTracer.
 // Use WithTracer to specify an alternative tracer.
 //
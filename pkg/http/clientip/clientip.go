@@ -0,0 +1,112 @@
+// clientip package finds out IP address of the client behind reverse proxies, for example envoy. Headers with
+// addresses are taken into account only in case the request comes from a trusted proxy, otherwise the client could
+// set any address and bypass limits counted per IP.
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// HeaderEnvoyExternalAddress is set by edge envoy to the address of the downstream client.
+const HeaderEnvoyExternalAddress = "X-Envoy-External-Address"
+
+// Resolver returns IP address of the client of request.
+type Resolver struct {
+	trusted []*net.IPNet
+}
+
+// IP returns IP address of the client without port. Address of the peer is returned in case it's not a trusted
+// proxy. Otherwise, X-Envoy-External-Address is used, or the right-most address of X-Forwarded-For which doesn't
+// belong to trusted proxies. Addresses on the left of it are set by the client, so they are ignored.
+func (r Resolver) IP(req *http.Request) string {
+	peer := remoteAddr(req)
+	if !r.isTrusted(peer) {
+		return peer
+	}
+
+	if ip := net.ParseIP(strings.TrimSpace(req.Header.Get(HeaderEnvoyExternalAddress))); ip != nil {
+		return ip.String()
+	}
+
+	forwarded := make([]string, 0)
+	for _, header := range req.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(header, ",")...)
+	}
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if ip == nil {
+			// the rest of the list can't be trusted
+			break
+		}
+
+		if !r.isTrusted(ip.String()) {
+			return ip.String()
+		}
+	}
+
+	return peer
+}
+
+func (r Resolver) isTrusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range r.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// remoteAddr returns address of the peer without port.
+func remoteAddr(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	return host
+}
+
+// NewResolver returns Resolver which trusts proxies with the addresses. Address is either IP or CIDR, for example
+// "10.0.0.0/8". Headers are ignored in case no proxies are trusted.
+func NewResolver(trustedProxies []string) (Resolver, error) {
+	r := Resolver{trusted: make([]*net.IPNet, 0, len(trustedProxies))}
+
+	for _, proxy := range trustedProxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return Resolver{}, errors.WithStack(fmt.Errorf("invalid address of trusted proxy: %q", proxy))
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+
+			r.trusted = append(r.trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return Resolver{}, errors.WithStack(fmt.Errorf("invalid address of trusted proxy: %q", proxy))
+		}
+
+		r.trusted = append(r.trusted, network)
+	}
+
+	return r, nil
+}
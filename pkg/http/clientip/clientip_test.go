@@ -0,0 +1,79 @@
+package clientip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolverIP(t *testing.T) {
+	r, err := NewResolver([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	cases := []struct {
+		name       string
+		remoteAddr string
+		envoy      string
+		forwarded  []string
+		want       string
+	}{
+		{
+			name:       "headers of untrusted peer are ignored",
+			remoteAddr: "203.0.113.5:4000",
+			envoy:      "198.51.100.1",
+			forwarded:  []string{"198.51.100.2"},
+			want:       "203.0.113.5",
+		},
+		{
+			name:       "envoy external address",
+			remoteAddr: "10.1.2.3:4000",
+			envoy:      "198.51.100.1",
+			forwarded:  []string{"198.51.100.2"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "right-most untrusted forwarded address",
+			remoteAddr: "192.168.1.1:4000",
+			forwarded:  []string{"1.1.1.1, 198.51.100.2", "10.0.0.7"},
+			want:       "198.51.100.2",
+		},
+		{
+			name:       "invalid forwarded address",
+			remoteAddr: "10.1.2.3:4000",
+			forwarded:  []string{"198.51.100.2, unknown"},
+			want:       "10.1.2.3",
+		},
+		{
+			name:       "no headers",
+			remoteAddr: "10.1.2.3:4000",
+			want:       "10.1.2.3",
+		},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = c.remoteAddr
+
+		if c.envoy != "" {
+			req.Header.Set(HeaderEnvoyExternalAddress, c.envoy)
+		}
+
+		for _, f := range c.forwarded {
+			req.Header.Add("X-Forwarded-For", f)
+		}
+
+		if got := r.IP(req); got != c.want {
+			t.Fatalf("%s: got: %s, want: %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestNewResolverInvalidProxy(t *testing.T) {
+	for _, proxy := range []string{"10.0.0.0/33", "localhost"} {
+		if _, err := NewResolver([]string{proxy}); err == nil {
+			t.Fatalf("got: nil, want: error of %q", proxy)
+		}
+	}
+}
@@ -4,25 +4,134 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/pkg/errors"
 	"go.elastic.co/apm/module/apmzap"
 
 	"gitlab.com/slirx/newproj/pkg/api"
+	"gitlab.com/slirx/newproj/pkg/apitoken"
 	"gitlab.com/slirx/newproj/pkg/logger"
+	"gitlab.com/slirx/newproj/pkg/mtls"
+	"gitlab.com/slirx/newproj/pkg/rbac"
 )
 
 const ContextKeyUserID = "uid"
 const ContextKeyLogin = "login"
+const ContextKeySessionID = "sid"
+const ContextKeyRole = "role"
+
+// Scopes of tokens which are issued to third-party applications by OAuth authorization server of auth service.
+const (
+	ScopePostWrite = "post:write"
+	ScopeUserRead  = "user:read"
+)
+
+// RevocationChecker checks whether user's token is revoked, for example after password reset, or its session is
+// revoked by the user.
+type RevocationChecker interface {
+	IsRevoked(ctx context.Context, uid int, issuedAt time.Time) (bool, error)
+	// CheckSession reports whether session is revoked. Otherwise, at is saved as the last activity of the session.
+	CheckSession(ctx context.Context, sessionID string, at time.Time) (bool, error)
+}
+
+// APITokenChecker returns personal API token by its hash. apitoken.ErrNotFound is returned in case the token is
+// unknown, expired or revoked.
+type APITokenChecker interface {
+	Check(ctx context.Context, hash string, at time.Time) (*apitoken.Token, error)
+}
+
+type options struct {
+	revocation  RevocationChecker
+	apiTokens   APITokenChecker
+	scopes      []string
+	staff       bool
+	permissions []rbac.Permission
+	// certificates is used by WrapInternal only.
+	certificates bool
+}
+
+// Option sets options of the middleware.
+type Option func(*options)
+
+// WithRevocation returns an Option which rejects tokens revoked according to c. Tokens without "iat" claim are
+// treated as issued at the beginning of time, so they are rejected after any revocation. Session of the token is
+// checked in case its "jti" claim is a string.
+func WithRevocation(c RevocationChecker) Option {
+	return func(o *options) {
+		o.revocation = c
+	}
+}
+
+// WithScopes returns an Option which allows tokens of third-party applications in case they have all scopes.
+// Such tokens have "scope" claim, they are rejected by routes without this option. Tokens without "scope" claim
+// are issued to users themselves, so they are allowed regardless of scopes.
+func WithScopes(scopes ...string) Option {
+	return func(o *options) {
+		o.scopes = scopes
+	}
+}
+
+// WithAPITokens returns an Option which allows personal API tokens checked by c in addition to JWTs. API tokens
+// always have scopes, so they are allowed only by routes with WithScopes the same way as tokens of third-party
+// applications.
+func WithAPITokens(c APITokenChecker) Option {
+	return func(o *options) {
+		o.apiTokens = c
+	}
+}
+
+// WithPermissions returns an Option which allows only tokens of admin panel with role having all permissions.
+// Such tokens have "role" claim, they are rejected by routes without this option. So token of staff can't be used
+// as token of user and the other way around.
+func WithPermissions(permissions ...rbac.Permission) Option {
+	return func(o *options) {
+		o.staff = true
+		o.permissions = permissions
+	}
+}
+
+// WithClientCertificates returns an Option of WrapInternal which authenticates services by verified client
+// certificates of mutual TLS. Common name of the certificate is login of the service, it should be one of known
+// services. Requests without certificates are still authenticated by internal JWT.
+func WithClientCertificates() Option {
+	return func(o *options) {
+		o.certificates = true
+	}
+}
+
+func Wrap(h http.HandlerFunc, rb api.ResponseBuilder, l logger.Logger, secret []byte, o ...Option) http.HandlerFunc {
+	opts := options{}
+	for _, option := range o {
+		option(&opts)
+	}
 
-func Wrap(h http.HandlerFunc, rb api.ResponseBuilder, l logger.Logger, secret []byte) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		header := r.Header.Get("Authorization")
 
+		if opts.apiTokens != nil && apitoken.IsToken(header) {
+			uid, err := checkAPIToken(r.Context(), header, opts)
+			if err != nil {
+				l.Error(err, apmzap.TraceContext(r.Context())...)
+				rb.ErrorResponse(r.Context(), w, err)
+				return
+			}
+
+			ctx := r.Context()
+			ctx = context.WithValue(ctx, ContextKeyUserID, uid)
+			r = r.WithContext(ctx)
+
+			h(w, r)
+
+			return
+		}
+
 		token, err := jwt.Parse(header, func(token *jwt.Token) (interface{}, error) {
 			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, api.NewAccessError(fmt.Errorf("invalid JWT"))
+				return nil, api.NewAccessErrorWithCode(api.CodeInvalidToken, fmt.Errorf("invalid JWT"))
 			}
 
 			return secret, nil
@@ -30,14 +139,16 @@ func Wrap(h http.HandlerFunc, rb api.ResponseBuilder, l logger.Logger, secret []
 		if err != nil {
 			vErr, ok := err.(*jwt.ValidationError)
 			if ok && vErr.Errors&jwt.ValidationErrorExpired == jwt.ValidationErrorExpired {
-				err = api.NewAccessError(errors.New("token is expired"))
+				err = api.NewAccessErrorWithCode(api.CodeTokenExpired, errors.New("token is expired"))
 				l.Error(err, apmzap.TraceContext(r.Context())...)
 				rb.ErrorResponse(r.Context(), w, err)
 				return
 			}
 
+			// the reason is logged, but only generic error is returned, so malformed tokens are not reported as
+			// internal errors
 			l.Error(err, apmzap.TraceContext(r.Context())...)
-			rb.ErrorResponse(r.Context(), w, err)
+			rb.ErrorResponse(r.Context(), w, api.NewAccessErrorWithCode(api.CodeInvalidToken, errors.New("invalid JWT")))
 			return
 		}
 
@@ -58,7 +169,7 @@ func Wrap(h http.HandlerFunc, rb api.ResponseBuilder, l logger.Logger, secret []
 			if !ok {
 				err = fmt.Errorf("invalid JWT")
 				l.Error(err, apmzap.TraceContext(r.Context())...)
-				rb.ErrorResponse(r.Context(), w, api.NewAccessError(err))
+				rb.ErrorResponse(r.Context(), w, api.NewAccessErrorWithCode(api.CodeInvalidToken, err))
 				return
 			}
 
@@ -66,12 +177,64 @@ func Wrap(h http.HandlerFunc, rb api.ResponseBuilder, l logger.Logger, secret []
 			if !ok {
 				err = fmt.Errorf("invalid JWT")
 				l.Error(err, apmzap.TraceContext(r.Context())...)
-				rb.ErrorResponse(r.Context(), w, api.NewAccessError(err))
+				rb.ErrorResponse(r.Context(), w, api.NewAccessErrorWithCode(api.CodeInvalidToken, err))
 				return
 			}
 
+			if scope, ok := claims["scope"]; ok && !hasScopes(scope, opts.scopes) {
+				err = api.NewAccessErrorWithCode(api.CodeInsufficientScope, errors.New("token has insufficient scope"))
+				l.Error(err, apmzap.TraceContext(r.Context())...)
+				rb.ErrorResponse(r.Context(), w, err)
+				return
+			}
+
+			role, err := checkRole(claims, opts)
+			if err != nil {
+				l.Error(err, apmzap.TraceContext(r.Context())...)
+				rb.ErrorResponse(r.Context(), w, err)
+				return
+			}
+
+			if opts.revocation != nil {
+				iat, _ := claims["iat"].(float64)
+
+				revoked, err := opts.revocation.IsRevoked(r.Context(), int(uid), time.Unix(int64(iat), 0))
+				if err != nil {
+					l.Error(err, apmzap.TraceContext(r.Context())...)
+					rb.ErrorResponse(r.Context(), w, err)
+					return
+				}
+
+				if revoked {
+					err = api.NewAccessErrorWithCode(api.CodeTokenRevoked, errors.New("token is revoked"))
+					l.Error(err, apmzap.TraceContext(r.Context())...)
+					rb.ErrorResponse(r.Context(), w, err)
+					return
+				}
+			}
+
+			sessionID, _ := claims["jti"].(string)
+
+			if opts.revocation != nil && sessionID != "" {
+				revoked, err := opts.revocation.CheckSession(r.Context(), sessionID, time.Now())
+				if err != nil {
+					l.Error(err, apmzap.TraceContext(r.Context())...)
+					rb.ErrorResponse(r.Context(), w, err)
+					return
+				}
+
+				if revoked {
+					err = api.NewAccessErrorWithCode(api.CodeTokenRevoked, errors.New("session is revoked"))
+					l.Error(err, apmzap.TraceContext(r.Context())...)
+					rb.ErrorResponse(r.Context(), w, err)
+					return
+				}
+			}
+
 			ctx := r.Context()
 			ctx = context.WithValue(ctx, ContextKeyUserID, int(uid))
+			ctx = context.WithValue(ctx, ContextKeySessionID, sessionID)
+			ctx = context.WithValue(ctx, ContextKeyRole, role)
 			r = r.WithContext(ctx)
 
 			h(w, r)
@@ -81,12 +244,49 @@ func Wrap(h http.HandlerFunc, rb api.ResponseBuilder, l logger.Logger, secret []
 
 		err = fmt.Errorf("invalid JWT")
 		l.Error(err, apmzap.TraceContext(r.Context())...)
-		rb.ErrorResponse(r.Context(), w, api.NewAccessError(err))
+		rb.ErrorResponse(r.Context(), w, api.NewAccessErrorWithCode(api.CodeInvalidToken, err))
 	}
 }
 
-func WrapInternal(h http.HandlerFunc, rb api.ResponseBuilder, l logger.Logger, secrets map[string][]byte) http.HandlerFunc {
+func WrapInternal(
+	h http.HandlerFunc,
+	rb api.ResponseBuilder,
+	l logger.Logger,
+	secrets map[string][]byte,
+	o ...Option,
+) http.HandlerFunc {
+	opts := options{}
+	for _, option := range o {
+		option(&opts)
+	}
+
 	return func(w http.ResponseWriter, r *http.Request) {
+		// the caller doesn't wait for response after its timeout, so handling of the request is canceled as well
+		timeout, err := strconv.Atoi(r.Header.Get(api.HeaderRequestTimeout))
+		if err == nil && timeout > 0 {
+			ctx, cancel := context.WithTimeout(r.Context(), time.Duration(timeout)*time.Millisecond)
+			defer cancel()
+
+			r = r.WithContext(ctx)
+		}
+
+		if login, ok := mtls.Identity(r); ok && opts.certificates {
+			if _, ok = secrets[login]; !ok {
+				err := api.NewAccessErrorWithCode(api.CodeInvalidToken, fmt.Errorf("unknown service %s", login))
+				l.Error(err, apmzap.TraceContext(r.Context())...)
+				rb.ErrorResponse(r.Context(), w, err)
+				return
+			}
+
+			ctx := r.Context()
+			ctx = context.WithValue(ctx, ContextKeyLogin, login)
+			r = r.WithContext(ctx)
+
+			h(w, r)
+
+			return
+		}
+
 		header := r.Header.Get("Authorization")
 		claims := make(map[string]interface{})
 
@@ -94,7 +294,7 @@ func WrapInternal(h http.HandlerFunc, rb api.ResponseBuilder, l logger.Logger, s
 
 		token, err := jwt.Parse(header, func(token *jwt.Token) (interface{}, error) {
 			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, api.NewAccessError(fmt.Errorf("invalid JWT"))
+				return nil, api.NewAccessErrorWithCode(api.CodeInvalidToken, fmt.Errorf("invalid JWT"))
 			}
 
 			var ok bool
@@ -102,22 +302,22 @@ func WrapInternal(h http.HandlerFunc, rb api.ResponseBuilder, l logger.Logger, s
 
 			claims, ok = token.Claims.(jwt.MapClaims)
 			if !ok {
-				return nil, api.NewAccessError(fmt.Errorf("invalid JWT (invalid claims)"))
+				return nil, api.NewAccessErrorWithCode(api.CodeInvalidToken, fmt.Errorf("invalid JWT (invalid claims)"))
 			}
 
 			tmpLogin, ok = claims["login"]
 			if !ok {
-				return nil, api.NewAccessError(fmt.Errorf("invalid JWT (no login)"))
+				return nil, api.NewAccessErrorWithCode(api.CodeInvalidToken, fmt.Errorf("invalid JWT (no login)"))
 			}
 
 			login, ok = tmpLogin.(string)
 			if !ok {
-				return nil, api.NewAccessError(fmt.Errorf("invalid JWT (invalid login)"))
+				return nil, api.NewAccessErrorWithCode(api.CodeInvalidToken, fmt.Errorf("invalid JWT (invalid login)"))
 			}
 
 			secret, ok := secrets[login]
 			if !ok {
-				return nil, api.NewAccessError(fmt.Errorf("invalid JWT (no login)"))
+				return nil, api.NewAccessErrorWithCode(api.CodeInvalidToken, fmt.Errorf("invalid JWT (no login)"))
 			}
 
 			return secret, nil
@@ -125,14 +325,16 @@ func WrapInternal(h http.HandlerFunc, rb api.ResponseBuilder, l logger.Logger, s
 		if err != nil {
 			vErr, ok := err.(*jwt.ValidationError)
 			if ok && vErr.Errors&jwt.ValidationErrorExpired == jwt.ValidationErrorExpired {
-				err = api.NewAccessError(errors.New("token is expired"))
+				err = api.NewAccessErrorWithCode(api.CodeTokenExpired, errors.New("token is expired"))
 				l.Error(err, apmzap.TraceContext(r.Context())...)
 				rb.ErrorResponse(r.Context(), w, err)
 				return
 			}
 
+			// the reason is logged, but only generic error is returned, so malformed tokens are not reported as
+			// internal errors
 			l.Error(err, apmzap.TraceContext(r.Context())...)
-			rb.ErrorResponse(r.Context(), w, err)
+			rb.ErrorResponse(r.Context(), w, api.NewAccessErrorWithCode(api.CodeInvalidToken, errors.New("invalid JWT")))
 			return
 		}
 
@@ -148,7 +350,7 @@ func WrapInternal(h http.HandlerFunc, rb api.ResponseBuilder, l logger.Logger, s
 
 		err = fmt.Errorf("invalid JWT")
 		l.Error(err, apmzap.TraceContext(r.Context())...)
-		rb.ErrorResponse(r.Context(), w, api.NewAccessError(err))
+		rb.ErrorResponse(r.Context(), w, api.NewAccessErrorWithCode(api.CodeInvalidToken, err))
 	}
 }
 
@@ -249,6 +451,89 @@ func UID(ctx context.Context) (int, error) {
 	return uid, nil
 }
 
+// hasScopes reports whether scope claim contains all required scopes. Scopes in the claim are separated by spaces.
+// Nothing is allowed in case required is empty.
+func hasScopes(claim interface{}, required []string) bool {
+	scope, ok := claim.(string)
+	if !ok || len(required) == 0 {
+		return false
+	}
+
+	granted := make(map[string]bool)
+	for _, s := range strings.Fields(scope) {
+		granted[s] = true
+	}
+
+	for _, s := range required {
+		if !granted[s] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkAPIToken returns id of the user of personal API token in case it's allowed by the route.
+func checkAPIToken(ctx context.Context, header string, opts options) (int, error) {
+	token, err := opts.apiTokens.Check(ctx, apitoken.Hash(header), time.Now())
+	if err != nil {
+		if errors.Is(err, apitoken.ErrNotFound) {
+			return 0, api.NewAccessErrorWithCode(api.CodeInvalidToken, errors.New("api token is invalid or expired"))
+		}
+
+		return 0, err
+	}
+
+	if opts.staff || !hasScopes(strings.Join(token.Scopes, " "), opts.scopes) {
+		return 0, api.NewAccessErrorWithCode(api.CodeInsufficientScope, errors.New("token has insufficient scope"))
+	}
+
+	return token.UserID, nil
+}
+
+// checkRole returns role of the token in case it's allowed by the route. Tokens of users have no role, they are
+// allowed only by routes without WithPermissions.
+func checkRole(claims jwt.MapClaims, opts options) (rbac.Role, error) {
+	claim, ok := claims["role"]
+	if !ok {
+		if opts.staff {
+			return "", api.NewAccessErrorWithCode(api.CodeInvalidToken, errors.New("token of admin panel is required"))
+		}
+
+		return "", nil
+	}
+
+	role, _ := claim.(string)
+
+	if !opts.staff || !rbac.Role(role).IsStaff() {
+		return "", api.NewAccessErrorWithCode(api.CodeInvalidToken, errors.New("token of admin panel can't be used"))
+	}
+
+	if !rbac.Role(role).Has(opts.permissions...) {
+		return "", api.NewAccessErrorWithCode(
+			api.CodeInsufficientPermissions,
+			errors.New("role has insufficient permissions"),
+		)
+	}
+
+	return rbac.Role(role), nil
+}
+
+// SessionID returns id of the session of the token from context. Empty string is returned for tokens issued
+// without session.
+func SessionID(ctx context.Context) string {
+	sessionID, _ := ctx.Value(ContextKeySessionID).(string)
+
+	return sessionID
+}
+
+// Role returns role of the token of admin panel from context. Empty role is returned for tokens of users.
+func Role(ctx context.Context) rbac.Role {
+	role, _ := ctx.Value(ContextKeyRole).(rbac.Role)
+
+	return role
+}
+
 // OptionalUID returns user id from context, without any errors in case there is no UID in the context.
 //func OptionalUID(ctx context.Context) uint {
 //	var uid uint
@@ -0,0 +1,89 @@
+// mtls package configures mutual TLS of service-to-service calls. Certificates of services are issued by internal CA,
+// common name of the certificate is login of the service, the same one which is used to obtain internal JWT.
+package mtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// Config represents certificate of the service and CA which issues certificates of services.
+type Config struct {
+	CertFile string
+	KeyFile  string
+	CAFile   string
+}
+
+// Enabled reports whether mutual TLS is configured.
+func (c Config) Enabled() bool {
+	return c.CertFile != ""
+}
+
+// ServerConfig returns TLS configuration of the server. Certificates of clients are verified in case they are
+// given, but not required, so public endpoints served by the same server stay available for browsers.
+func ServerConfig(c Config) (*tls.Config, error) {
+	certificate, pool, err := load(c)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	return config, nil
+}
+
+// ClientConfig returns TLS configuration of the client which presents certificate of the service and trusts only
+// servers with certificates issued by CA.
+func ClientConfig(c Config) (*tls.Config, error) {
+	certificate, pool, err := load(c)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	return config, nil
+}
+
+// Identity returns login of the service which made request r. It's common name of the verified client certificate.
+func Identity(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+
+	login := r.TLS.VerifiedChains[0][0].Subject.CommonName
+
+	return login, login != ""
+}
+
+func load(c Config) (tls.Certificate, *x509.CertPool, error) {
+	certificate, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return tls.Certificate{}, nil, errors.WithStack(err)
+	}
+
+	ca, err := os.ReadFile(c.CAFile)
+	if err != nil {
+		return tls.Certificate{}, nil, errors.WithStack(err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return tls.Certificate{}, nil, errors.WithStack(errors.New("invalid CA certificate"))
+	}
+
+	return certificate, pool, nil
+}
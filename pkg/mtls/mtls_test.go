@@ -0,0 +1,174 @@
+package mtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate issues certificate with common name cn signed by parent and writes it with its key to dir.
+// Self-signed CA certificate is issued in case parent is nil.
+func writeCertificate(
+	t *testing.T,
+	dir string,
+	cn string,
+	parent *x509.Certificate,
+	parentKey *ecdsa.PrivateKey,
+) (Config, *x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	c := Config{
+		CertFile: filepath.Join(dir, cn+".crt"),
+		KeyFile:  filepath.Join(dir, cn+".key"),
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err = os.WriteFile(c.CertFile, certPEM, 0600); err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err = os.WriteFile(c.KeyFile, keyPEM, 0600); err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	return c, certificate, key
+}
+
+func TestIdentity(t *testing.T) {
+	dir := t.TempDir()
+
+	caConfig, ca, caKey := writeCertificate(t, dir, "ca", nil, nil)
+	serverConfig, _, _ := writeCertificate(t, dir, "user", ca, caKey)
+	clientConfig, _, _ := writeCertificate(t, dir, "email", ca, caKey)
+	serverConfig.CAFile = caConfig.CertFile
+	clientConfig.CAFile = caConfig.CertFile
+
+	// certificate of the same service issued by another CA
+	otherCAConfig, otherCA, otherCAKey := writeCertificate(t, t.TempDir(), "ca", nil, nil)
+	strangerConfig, _, _ := writeCertificate(t, filepath.Dir(otherCAConfig.CertFile), "email", otherCA, otherCAKey)
+	strangerConfig.CAFile = caConfig.CertFile
+
+	tlsConfig, err := ServerConfig(serverConfig)
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		login, _ := Identity(r)
+		_, _ = w.Write([]byte(login))
+	}))
+	server.TLS = tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	identity := func(c Config, withCertificate bool) (string, error) {
+		tlsConfig, err := ClientConfig(c)
+		if err != nil {
+			return "", err
+		}
+
+		if !withCertificate {
+			tlsConfig.Certificates = nil
+		}
+
+		client := http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return "", err
+		}
+
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+
+		return string(body), err
+	}
+
+	login, err := identity(clientConfig, true)
+	if err != nil || login != "email" {
+		t.Fatalf("got: %q/%v, want: email", login, err)
+	}
+
+	// requests without certificates are allowed, but they have no identity
+	login, err = identity(clientConfig, false)
+	if err != nil || login != "" {
+		t.Fatalf("got: %q/%v, want: empty login", login, err)
+	}
+
+	if _, err = identity(strangerConfig, true); err == nil {
+		t.Fatalf("got: nil, want: error because certificate is issued by unknown CA")
+	}
+
+	if _, err = ClientConfig(Config{CertFile: clientConfig.CertFile, KeyFile: clientConfig.KeyFile}); err == nil {
+		t.Fatalf("got: nil, want: error because CA is not configured")
+	}
+
+	if (Config{}).Enabled() || !clientConfig.Enabled() {
+		t.Fatalf("got: %v, want: enabled only in case certificate is configured", clientConfig.Enabled())
+	}
+}
+
+// TestIdentityWithoutTLS checks that plain HTTP requests have no identity.
+func TestIdentityWithoutTLS(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	if login, ok := Identity(r); ok {
+		t.Fatalf("got: %s, want: no identity", login)
+	}
+
+	r.TLS = &tls.ConnectionState{}
+
+	if login, ok := Identity(r); ok {
+		t.Fatalf("got: %s, want: no identity", login)
+	}
+}
@@ -0,0 +1,20 @@
+package oidc
+
+import (
+	"context"
+)
+
+var _ Provider = (*Mock)(nil)
+
+type Mock struct {
+	AuthCodeURLFn func(ctx context.Context, state string, nonce string, codeChallenge string) (string, error)
+	ExchangeFn    func(ctx context.Context, code string, codeVerifier string, nonce string) (*Claims, error)
+}
+
+func (m Mock) AuthCodeURL(ctx context.Context, state string, nonce string, codeChallenge string) (string, error) {
+	return m.AuthCodeURLFn(ctx, state, nonce, codeChallenge)
+}
+
+func (m Mock) Exchange(ctx context.Context, code string, codeVerifier string, nonce string) (*Claims, error) {
+	return m.ExchangeFn(ctx, code, codeVerifier, nonce)
+}
@@ -0,0 +1,376 @@
+// oidc package implements relying party of OpenID Connect: discovery, authorization code flow with PKCE and
+// validation of ID tokens. Only RSA signed ID tokens are supported, it's the algorithm every provider has to support.
+package oidc
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// keysRefreshInterval is the minimum interval between two requests of provider keys. Keys are requested again
+// in case ID token is signed with unknown key, the interval protects provider from flood of such tokens.
+const keysRefreshInterval = time.Minute
+
+// maxResponseSize is the maximum size of responses of provider.
+const maxResponseSize = 1 << 20
+
+// ErrInvalidToken is returned in case ID token is not valid: its signature, issuer, audience, nonce or lifetime.
+var ErrInvalidToken = errors.New("id token is invalid")
+
+// Config represents configuration of the provider and the client registered at it.
+type Config struct {
+	// Issuer is URL of the provider. Discovery document is requested at Issuer + "/.well-known/openid-configuration".
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is URL of the page of frontend the provider redirects user to after authentication.
+	RedirectURL string
+	// Scopes are requested in addition to "openid".
+	Scopes []string
+}
+
+// Claims are claims of validated ID token which identify the user.
+type Claims struct {
+	Issuer            string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	Name              string
+}
+
+// Provider is OpenID Connect provider.
+type Provider interface {
+	// AuthCodeURL returns URL of authorization endpoint the user should be redirected to. codeChallenge is
+	// S256 challenge of PKCE.
+	AuthCodeURL(ctx context.Context, state string, nonce string, codeChallenge string) (string, error)
+	// Exchange exchanges authorization code to ID token and returns its claims. The token should have the same
+	// nonce which was passed to AuthCodeURL.
+	Exchange(ctx context.Context, code string, codeVerifier string, nonce string) (*Claims, error)
+}
+
+// discovery represents fields of discovery document which are used by the client.
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type jwks struct {
+	Keys []struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// provider caches discovery document and keys, they are requested on the first use.
+type provider struct {
+	Config Config
+	Client *http.Client
+
+	mu            sync.Mutex
+	discovery     *discovery
+	keys          map[string]*rsa.PublicKey
+	keysFetchedAt time.Time
+	now           func() time.Time
+}
+
+func (p *provider) AuthCodeURL(ctx context.Context, state string, nonce string, codeChallenge string) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(d.AuthorizationEndpoint)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	query := u.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.Config.ClientID)
+	query.Set("redirect_uri", p.Config.RedirectURL)
+	query.Set("scope", strings.Join(append([]string{"openid"}, p.Config.Scopes...), " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+func (p *provider) Exchange(ctx context.Context, code string, codeVerifier string, nonce string) (*Claims, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.Config.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth(url.QueryEscape(p.Config.ClientID), url.QueryEscape(p.Config.ClientSecret))
+
+	response := tokenResponse{}
+	if err = p.do(r, &response); err != nil && response.Error == "" {
+		return nil, err
+	}
+
+	if response.Error != "" {
+		return nil, errors.WithStack(fmt.Errorf("token request is failed: %s: %s", response.Error,
+			response.ErrorDescription))
+	}
+
+	return p.verify(ctx, d, response.IDToken, nonce)
+}
+
+// verify validates ID token as described in OpenID Connect Core 1.0 section 3.1.3.7.
+func (p *provider) verify(ctx context.Context, d *discovery, idToken string, nonce string) (*Claims, error) {
+	parser := jwt.Parser{SkipClaimsValidation: true}
+
+	token, err := parser.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, errors.New("invalid signing method")
+		}
+
+		kid, _ := token.Header["kid"].(string)
+
+		return p.key(ctx, d, kid)
+	})
+	if err != nil || !token.Valid {
+		return nil, errors.WithStack(ErrInvalidToken)
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	now := p.now().Unix()
+
+	iss, _ := claims["iss"].(string)
+	sub, _ := claims["sub"].(string)
+	tokenNonce, _ := claims["nonce"].(string)
+	azp, _ := claims["azp"].(string)
+	audience := audiences(claims["aud"])
+
+	switch {
+	case iss != d.Issuer || sub == "":
+	case !contains(audience, p.Config.ClientID):
+	case len(audience) > 1 && azp != p.Config.ClientID:
+	case tokenNonce == "" || tokenNonce != nonce:
+	case !claims.VerifyExpiresAt(now, true) || !claims.VerifyNotBefore(now, false):
+	default:
+		result := &Claims{Issuer: iss, Subject: sub}
+		result.Email, _ = claims["email"].(string)
+		result.EmailVerified, _ = claims["email_verified"].(bool)
+		result.PreferredUsername, _ = claims["preferred_username"].(string)
+		result.Name, _ = claims["name"].(string)
+
+		return result, nil
+	}
+
+	return nil, errors.WithStack(ErrInvalidToken)
+}
+
+// discover returns discovery document of the provider. Issuer of the document should be the configured one.
+func (p *provider) discover(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	issuer := strings.TrimSuffix(p.Config.Issuer, "/")
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	d := &discovery{}
+	if err = p.do(r, d); err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSuffix(d.Issuer, "/") != issuer {
+		return nil, errors.WithStack(fmt.Errorf("issuer of discovery document %q doesn't match %q", d.Issuer, issuer))
+	}
+
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("discovery document is incomplete")
+	}
+
+	p.discovery = d
+
+	return d, nil
+}
+
+// key returns public key by its id. Keys are requested again in case the key is unknown, because provider could
+// rotate them.
+func (p *provider) key(ctx context.Context, d *discovery, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key := p.lookupKey(kid); key != nil {
+		return key, nil
+	}
+
+	if p.keys != nil && p.now().Sub(p.keysFetchedAt) < keysRefreshInterval {
+		return nil, errors.New("unknown key")
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, d.JWKSURI, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	set := jwks{}
+	if err = p.do(r, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		key, err := rsaKey(k.N, k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		keys[k.Kid] = key
+	}
+
+	p.keys = keys
+	p.keysFetchedAt = p.now()
+
+	if key := p.lookupKey(kid); key != nil {
+		return key, nil
+	}
+
+	return nil, errors.New("unknown key")
+}
+
+// lookupKey returns cached key. Token without key id can be verified only in case provider has one key.
+func (p *provider) lookupKey(kid string) *rsa.PublicKey {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key
+		}
+	}
+
+	return p.keys[kid]
+}
+
+// do sends request and decodes JSON response into v. Error is returned for responses with status other than 200,
+// but body is decoded anyway, so error of token endpoint can be reported.
+func (p *provider) do(r *http.Request, v interface{}) error {
+	r.Header.Set("Accept", "application/json")
+
+	resp, err := p.Client.Do(r)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer resp.Body.Close()
+
+	decodeErr := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(v)
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.WithStack(fmt.Errorf("%s %s: unexpected status %d", r.Method, r.URL.Path, resp.StatusCode))
+	}
+
+	if decodeErr != nil {
+		return errors.WithStack(decodeErr)
+	}
+
+	return nil
+}
+
+// rsaKey returns public key of JWK with base64url encoded modulus n and exponent e.
+func rsaKey(n string, e string) (*rsa.PublicKey, error) {
+	nBytes, err := base64.RawURLEncoding.DecodeString(n)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	eBytes, err := base64.RawURLEncoding.DecodeString(e)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	exponent := new(big.Int).SetBytes(eBytes)
+	if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid exponent of key")
+	}
+
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(nBytes), E: int(exponent.Int64())}, nil
+}
+
+// audiences returns value of "aud" claim, which can be either string or array of strings.
+func audiences(aud interface{}) []string {
+	switch v := aud.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		result := make([]string, 0, len(v))
+
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+
+		return result
+	}
+
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// New returns Provider. Discovery document and keys are requested using client on the first use.
+func New(config Config, client *http.Client) Provider {
+	return &provider{Config: config, Client: client, now: time.Now}
+}
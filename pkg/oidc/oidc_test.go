@@ -0,0 +1,207 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// mockIdP is local OpenID Connect provider. It issues ID token with claims for code "valid" in case code verifier
+// matches the challenge passed to authorization endpoint.
+type mockIdP struct {
+	server        *httptest.Server
+	key           *rsa.PrivateKey
+	kid           string
+	claims        jwt.MapClaims
+	codeChallenge string
+	keyRequests   int
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &mockIdP{key: key, kid: "key1"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		idp.keyRequests++
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": idp.kid,
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(idp.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idp.key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		clientID, clientSecret, _ := r.BasicAuth()
+		challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+
+		if clientID != "client" || clientSecret != "secret" || r.PostFormValue("code") != "valid" ||
+			base64.RawURLEncoding.EncodeToString(challenge[:]) != idp.codeChallenge {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+
+			return
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, idp.claims)
+		token.Header["kid"] = idp.kid
+
+		idToken, err := token.SignedString(idp.key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "at", "id_token": idToken})
+	})
+
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+
+	return idp
+}
+
+func TestProvider(t *testing.T) {
+	idp := newMockIdP(t)
+	ctx := context.Background()
+
+	p := New(Config{
+		Issuer:       idp.server.URL + "/",
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "https://example.com/oidc/callback",
+		Scopes:       []string{"email", "profile"},
+	}, idp.server.Client())
+
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := sha256.Sum256([]byte(verifier))
+	idp.codeChallenge = base64.RawURLEncoding.EncodeToString(challenge[:])
+
+	authURL, err := p.AuthCodeURL(ctx, "state1", "nonce1", idp.codeChallenge)
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	u, _ := url.Parse(authURL)
+	q := u.Query()
+
+	if u.Path != "/authorize" || q.Get("scope") != "openid email profile" || q.Get("nonce") != "nonce1" {
+		t.Fatalf("got: %s, want: authorization url with scopes and nonce", authURL)
+	}
+
+	now := time.Now()
+	valid := jwt.MapClaims{
+		"iss":                idp.server.URL,
+		"sub":                "248289761001",
+		"aud":                "client",
+		"nonce":              "nonce1",
+		"exp":                now.Add(time.Hour).Unix(),
+		"iat":                now.Unix(),
+		"email":              "jane@example.com",
+		"email_verified":     true,
+		"preferred_username": "jane",
+	}
+
+	idp.claims = valid
+
+	claims, err := p.Exchange(ctx, "valid", verifier, "nonce1")
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	want := Claims{
+		Issuer:            idp.server.URL,
+		Subject:           "248289761001",
+		Email:             "jane@example.com",
+		EmailVerified:     true,
+		PreferredUsername: "jane",
+	}
+	if *claims != want {
+		t.Fatalf("got: %+v, want: %+v", *claims, want)
+	}
+
+	if _, err = p.Exchange(ctx, "valid", "wrong", "nonce1"); err == nil {
+		t.Fatalf("got: nil, want: error of token endpoint")
+	}
+
+	cases := map[string]func(c jwt.MapClaims){
+		"another issuer":   func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" },
+		"another audience": func(c jwt.MapClaims) { c["aud"] = []string{"other"} },
+		"untrusted azp":    func(c jwt.MapClaims) { c["aud"] = []string{"client", "other"} },
+		"expired":          func(c jwt.MapClaims) { c["exp"] = now.Add(-time.Minute).Unix() },
+		"without exp":      func(c jwt.MapClaims) { delete(c, "exp") },
+		"without subject":  func(c jwt.MapClaims) { delete(c, "sub") },
+	}
+
+	for name, modify := range cases {
+		idp.claims = jwt.MapClaims{}
+		for k, v := range valid {
+			idp.claims[k] = v
+		}
+
+		modify(idp.claims)
+
+		if _, err = p.Exchange(ctx, "valid", verifier, "nonce1"); !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("%s: got: %v, want: %s", name, err, ErrInvalidToken)
+		}
+	}
+
+	idp.claims = valid
+
+	if _, err = p.Exchange(ctx, "valid", verifier, "nonce2"); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("got: %v, want: %s because of nonce", err, ErrInvalidToken)
+	}
+
+	// keys are requested again for unknown key, but not more often than once a minute
+	if idp.key, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		t.Fatal(err)
+	}
+
+	idp.kid = "key2"
+
+	if _, err = p.Exchange(ctx, "valid", verifier, "nonce1"); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("got: %v, want: %s because of unknown key", err, ErrInvalidToken)
+	}
+
+	p.(*provider).now = func() time.Time {
+		return now.Add(2 * time.Minute)
+	}
+
+	if _, err = p.Exchange(ctx, "valid", verifier, "nonce1"); err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	if idp.keyRequests != 2 {
+		t.Fatalf("got: %d key requests, want: 2", idp.keyRequests)
+	}
+}
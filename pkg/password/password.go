@@ -0,0 +1,27 @@
+// password package contains rules of users' passwords. They are shared by all places where password is set.
+package password
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MinLen is the minimum length of password.
+const MinLen = 5
+
+// Validate validates new password and its confirmation.
+func Validate(password string, confirmation string) error {
+	if password == "" || confirmation == "" {
+		return errors.New("password should not be empty")
+	}
+
+	if password != confirmation {
+		return errors.New("passwords should match")
+	}
+
+	if len(password) < MinLen {
+		return fmt.Errorf("passwords length should be more than %d symbols", MinLen)
+	}
+
+	return nil
+}
@@ -0,0 +1,9 @@
+package queue
+
+// Backends of queue. RabbitMQ is used by default. In-memory backend is used for local development without RabbitMQ:
+// httpd of the service runs workers of the service in the same process, jobs of other services are kept in memory
+// and never delivered.
+const (
+	BackendRabbitMQ = "rabbitmq"
+	BackendMemory   = "memory"
+)
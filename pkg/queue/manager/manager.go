@@ -1,11 +1,55 @@
 package manager
 
 import (
+	"bytes"
 	"context"
+	"encoding/gob"
+
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+
+	"gitlab.com/slirx/newproj/pkg/queue"
+	"gitlab.com/slirx/newproj/pkg/tracer"
 )
 
 type Manager interface {
+	// Send sends msg to the queue with routingKey name. It returns only after the broker confirms the message,
+	// so the error means the message may be lost and the caller should handle it.
 	Send(ctx context.Context, routingKey string, msg interface{}) error
+	// Publish publishes msg to the topic exchange with routingKey. Message which is not routed to any queue
+	// is dropped by the broker, because events may have no subscribers.
+	Publish(ctx context.Context, exchange string, routingKey string, msg interface{}) error
 	Close() error
-	//EmitEvent(ctx context.Context, exchange string, msg interface{}) error
+}
+
+// newPublishing encodes msg into persistent message. Ordering key is set for messages implementing queue.Keyer.
+// Trace context of ctx is set in W3C Trace Context headers, so the message is handled as a part of the same trace.
+func newPublishing(ctx context.Context, msg interface{}) (amqp.Publishing, error) {
+	var buf bytes.Buffer
+	encoder := gob.NewEncoder(&buf)
+
+	if err := encoder.Encode(msg); err != nil {
+		return amqp.Publishing{}, errors.WithStack(err)
+	}
+
+	headers := amqp.Table{}
+	if k, ok := msg.(queue.Keyer); ok {
+		headers[queue.HeaderOrderingKey] = k.OrderingKey()
+	}
+
+	if traceContext, ok := tracer.TraceContext(ctx); ok {
+		traceparent, tracestate := tracer.FormatTraceparent(traceContext)
+		headers[queue.HeaderTraceparent] = traceparent
+
+		if tracestate != "" {
+			headers[queue.HeaderTracestate] = tracestate
+		}
+	}
+
+	return amqp.Publishing{
+		Headers:      headers,
+		DeliveryMode: amqp.Persistent,
+		ContentType:  "application/octet-stream",
+		Body:         buf.Bytes(),
+	}, nil
 }
@@ -0,0 +1,54 @@
+package manager
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"gitlab.com/slirx/newproj/pkg/queue/memory"
+)
+
+type memoryManager struct {
+	Broker *memory.Broker
+}
+
+// Send sends msg to the queue with routingKey name. It returns rabbitmq.ErrUnroutable in case the queue is not
+// declared by any worker.
+func (m memoryManager) Send(ctx context.Context, routingKey string, msg interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	p, err := newPublishing(ctx, msg)
+	if err != nil {
+		return err
+	}
+
+	return m.Broker.Send(routingKey, p)
+}
+
+// Publish publishes msg to the topic exchange with routingKey.
+func (m memoryManager) Publish(ctx context.Context, exchange string, routingKey string, msg interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	p, err := newPublishing(ctx, msg)
+	if err != nil {
+		return err
+	}
+
+	m.Broker.Publish(exchange, routingKey, p)
+
+	return nil
+}
+
+func (m memoryManager) Close() error {
+	return nil
+}
+
+// NewMemoryManager returns manager which sends messages to in-memory broker. It's used in tests and in development
+// mode, when workers are run in the same process.
+func NewMemoryManager(b *memory.Broker) Manager {
+	return memoryManager{Broker: b}
+}
@@ -5,14 +5,19 @@ import (
 )
 
 type Mock struct {
-	SendFn  func(ctx context.Context, routingKey string, msg interface{}) error
-	CloseFn func() error
+	SendFn    func(ctx context.Context, routingKey string, msg interface{}) error
+	PublishFn func(ctx context.Context, exchange string, routingKey string, msg interface{}) error
+	CloseFn   func() error
 }
 
 func (m Mock) Send(ctx context.Context, routingKey string, msg interface{}) error {
 	return m.SendFn(ctx, routingKey, msg)
 }
 
+func (m Mock) Publish(ctx context.Context, exchange string, routingKey string, msg interface{}) error {
+	return m.PublishFn(ctx, exchange, routingKey, msg)
+}
+
 func (m Mock) Close() error {
 	return m.CloseFn()
 }
@@ -1,38 +1,35 @@
 package manager
 
 import (
-	"bytes"
 	"context"
-	"encoding/gob"
+	"sync"
 	"time"
 
-	"github.com/pkg/errors"
 	"github.com/streadway/amqp"
+
 	"gitlab.com/slirx/newproj/pkg/logger"
 	"gitlab.com/slirx/newproj/pkg/rabbitmq"
-	"gitlab.com/slirx/newproj/pkg/utils"
 )
 
+// defaultConfirmTimeout is used in case rabbitmq.Config.ConfirmTimeout is not set.
+const defaultConfirmTimeout = 5 * time.Second
+
 type rabbitmqManager struct {
-	Client         *rabbitmq.Client
-	Error          chan error
-	Logger         logger.Logger
-	isReconnecting chan struct{}
+	Client    *rabbitmq.Client
+	Error     chan error
+	Logger    logger.Logger
+	exchanges sync.Map // names of declared exchanges
+	done      chan struct{}
 }
 
 func (m *rabbitmqManager) Close() error {
 	err := m.Client.Connection.Close()
-	if err != nil {
-		return err
-	}
 
+	// wait until connect stops sending errors
+	<-m.done
 	close(m.Error)
 
-	if !utils.IsStructChanClosed(m.isReconnecting) {
-		close(m.isReconnecting)
-	}
-
-	return nil
+	return err
 }
 
 func (m *rabbitmqManager) handleErrors() {
@@ -42,130 +39,110 @@ func (m *rabbitmqManager) handleErrors() {
 	}
 }
 
-func (m *rabbitmqManager) reconnect(ctx context.Context) {
-	m.isReconnecting = make(chan struct{})
+// connect establishes connection and listens to reconnections until connection is closed.
+func (m *rabbitmqManager) connect(ctx context.Context) {
+	defer close(m.done)
 
-	if err := m.Client.Connection.Reconnect(ctx); err != nil {
-		close(m.isReconnecting)
-		m.Error <- errors.WithStack(err)
+	if err := m.Client.Connection.Connect(ctx); err != nil {
+		m.Error <- err
 		return
 	}
 
-	close(m.isReconnecting)
+	for range m.Client.Connection.NotifyReconnect() {
+		// the broker could lose its state, so exchanges are declared again on the next publishing
+		m.exchanges.Range(func(key, value interface{}) bool {
+			m.exchanges.Delete(key)
+			return true
+		})
+	}
 
-	for {
-		amqpErr := make(chan *amqp.Error)
+	if err := m.Client.Connection.Err(); err != nil {
+		m.Error <- err
+	}
+}
 
-		select {
-		case <-m.Client.Connection.Connection.NotifyClose(amqpErr):
-			m.isReconnecting = make(chan struct{})
+// publish publishes the message via pooled channel and waits for the broker confirmation.
+func (m *rabbitmqManager) publish(
+	ctx context.Context,
+	exchange string,
+	routingKey string,
+	mandatory bool,
+	msg amqp.Publishing,
+) error {
+	pool := m.Client.Connection.Pool()
+
+	ch, err := pool.Get(ctx)
+	if err != nil {
+		return err
+	}
 
-			if err := m.Client.Connection.Reconnect(ctx); err != nil {
-				m.Error <- errors.WithStack(err)
-				return
-			}
+	defer pool.Put(ch)
 
-			close(m.isReconnecting)
-		case <-ctx.Done():
-			return
-		}
+	timeout := m.Client.Config.ConfirmTimeout
+	if timeout <= 0 {
+		timeout = defaultConfirmTimeout
 	}
+
+	return ch.Publish(ctx, exchange, routingKey, mandatory, msg, timeout)
 }
 
+// Send sends msg to the queue with routingKey name. It returns an error in case the broker doesn't confirm the message
+// or the message can not be routed to the queue.
 func (m *rabbitmqManager) Send(ctx context.Context, routingKey string, msg interface{}) error {
-	// wait in case reconnection is in progress
-	select {
-	case <-m.isReconnecting:
-	case <-time.After(m.Client.Config.ReconnectTimeoutSeconds * 2):
-		return errors.WithStack(errors.New("queue is not responding"))
-	case <-ctx.Done():
-		return nil
+	p, err := newPublishing(ctx, msg)
+	if err != nil {
+		return err
 	}
 
-	var buf bytes.Buffer
-	encoder := gob.NewEncoder(&buf)
+	return m.publish(ctx, "", routingKey, true, p)
+}
 
-	err := encoder.Encode(msg)
-	if err != nil {
-		return errors.WithStack(err)
+// Publish publishes msg to the topic exchange with routingKey. The exchange is declared on the first publishing.
+func (m *rabbitmqManager) Publish(ctx context.Context, exchange string, routingKey string, msg interface{}) error {
+	if err := m.declareExchange(ctx, exchange); err != nil {
+		return err
 	}
 
-	err = m.Client.Connection.Channel.Publish(
-		"",         // exchange
-		routingKey, // routing key
-		false,      // mandatory
-		false,      // immediate
-		amqp.Publishing{
-			DeliveryMode: amqp.Persistent,
-			ContentType:  "application/octet-stream",
-			Body:         buf.Bytes(),
-		})
+	p, err := newPublishing(ctx, msg)
 	if err != nil {
-		return errors.WithStack(err)
+		return err
 	}
 
-	return nil
+	return m.publish(ctx, exchange, routingKey, false, p)
 }
 
-// todo maybe combine with Send method internally to use one method
-func (m *rabbitmqManager) EmitEvent(ctx context.Context, exchange string, msg interface{}) error {
-	// wait in case reconnection is in progress
-	select {
-	case <-m.isReconnecting:
-	case <-time.After(m.Client.Config.ReconnectTimeoutSeconds * 2):
-		return errors.WithStack(errors.New("queue is not responding"))
-	case <-ctx.Done():
+func (m *rabbitmqManager) declareExchange(ctx context.Context, exchange string) error {
+	if _, ok := m.exchanges.Load(exchange); ok {
 		return nil
 	}
 
-	err := m.Client.Connection.Channel.ExchangeDeclare(
-		exchange, // name
-		"fanout", // type
-		true,     // durable
-		false,    // auto-deleted
-		false,    // internal
-		false,    // no-wait
-		nil,      // arguments
-	)
+	pool := m.Client.Connection.Pool()
+
+	ch, err := pool.Get(ctx)
 	if err != nil {
-		return errors.WithStack(err)
+		return err
 	}
 
-	var buf bytes.Buffer
-	encoder := gob.NewEncoder(&buf)
+	defer pool.Put(ch)
 
-	if err = encoder.Encode(msg); err != nil {
-		return errors.WithStack(err)
+	if err = ch.ExchangeDeclare(exchange, "topic"); err != nil {
+		return err
 	}
 
-	// todo I can call Channel.NotifyReturn to be notified when event is not published
-
-	err = m.Client.Connection.Channel.Publish(
-		exchange, // exchange
-		"",       // routing key
-		false,    // mandatory
-		false,    // immediate
-		amqp.Publishing{
-			DeliveryMode: amqp.Persistent, // todo do I need this for pub/sub?
-			ContentType:  "application/octet-stream",
-			Body:         buf.Bytes(),
-		})
-	if err != nil {
-		return errors.WithStack(err)
-	}
+	m.exchanges.Store(exchange, struct{}{})
 
 	return nil
 }
 
 func NewManager(ctx context.Context, l logger.Logger, conf rabbitmq.Config) Manager {
 	m := &rabbitmqManager{
-		Client:         rabbitmq.NewClient(conf, l, ""),
-		Error:          make(chan error),
-		Logger:         l,
-		isReconnecting: make(chan struct{}),
+		Client: rabbitmq.NewClient(conf, l, ""),
+		Error:  make(chan error),
+		Logger: l,
+		done:   make(chan struct{}),
 	}
 
-	go m.reconnect(ctx)
+	go m.connect(ctx)
 	go m.handleErrors()
 
 	return m
@@ -0,0 +1,337 @@
+// memory package contains in-memory message broker. It's used instead of RabbitMQ in tests and when services and
+// workers are run in one process for local development.
+package memory
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+
+	"gitlab.com/slirx/newproj/pkg/rabbitmq"
+)
+
+// Broker routes messages the same way RabbitMQ does: jobs are sent to the queue named by routing key and events
+// are published to topic exchanges and fanned out to all queues bound with matching pattern. Messages are kept
+// until they are acked, nacked messages are requeued and redelivered. It's safe for concurrent use.
+type Broker struct {
+	mu       sync.RWMutex
+	queues   map[string]*queue
+	bindings map[string][]binding // exchange name => bindings
+}
+
+type binding struct {
+	queue   *queue
+	pattern string
+}
+
+// DeclareQueue declares queue with name. Declaring of existing queue does nothing.
+func (b *Broker) DeclareQueue(name string) {
+	b.declareQueue(name)
+}
+
+// Bind binds queue to the topic exchange with routing key pattern. Pattern may contain wildcards: "*" matches
+// exactly one word and "#" matches zero or more words. Queue is declared in case it doesn't exist.
+func (b *Broker) Bind(queueName string, exchange string, pattern string) {
+	q := b.declareQueue(queueName)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, bd := range b.bindings[exchange] {
+		if bd.queue == q && bd.pattern == pattern {
+			return
+		}
+	}
+
+	b.bindings[exchange] = append(b.bindings[exchange], binding{queue: q, pattern: pattern})
+}
+
+// Send sends msg to the queue with routingKey name. It returns rabbitmq.ErrUnroutable in case the queue is not
+// declared, like the broker returns mandatory message which can't be routed.
+func (b *Broker) Send(routingKey string, msg amqp.Publishing) error {
+	b.mu.RLock()
+	q, ok := b.queues[routingKey]
+	b.mu.RUnlock()
+
+	if !ok {
+		return errors.WithStack(fmt.Errorf("%w: %s", rabbitmq.ErrUnroutable, routingKey))
+	}
+
+	q.push(newDelivery("", routingKey, msg))
+
+	return nil
+}
+
+// Publish publishes msg to the topic exchange. Every queue bound with pattern matching routingKey receives its own
+// copy of the message. Message which is not routed to any queue is dropped.
+func (b *Broker) Publish(exchange string, routingKey string, msg amqp.Publishing) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	routed := make(map[*queue]bool)
+
+	for _, bd := range b.bindings[exchange] {
+		if routed[bd.queue] || !match(bd.pattern, routingKey) {
+			continue
+		}
+
+		routed[bd.queue] = true
+		bd.queue.push(newDelivery(exchange, routingKey, msg))
+	}
+}
+
+// Consume starts delivering messages of the queue. There are at most prefetch unacked messages delivered to the
+// consumer at once, zero prefetch means no limit. Queue is declared in case it doesn't exist.
+// Deliveries channel is closed after cancel is called. Messages which are delivered but not acked yet can still be
+// acked or nacked after that.
+func (b *Broker) Consume(queueName string, prefetch int) (deliveries <-chan amqp.Delivery, cancel func()) {
+	q := b.declareQueue(queueName)
+
+	c := &consumer{
+		prefetch: prefetch,
+		out:      make(chan amqp.Delivery),
+		stop:     make(chan struct{}),
+	}
+
+	go q.deliver(c)
+
+	var once sync.Once
+
+	return c.out, func() {
+		once.Do(func() {
+			close(c.stop)
+		})
+	}
+}
+
+// Len returns number of ready and unacked messages of the queue.
+func (b *Broker) Len(queueName string) (ready int, unacked int) {
+	b.mu.RLock()
+	q, ok := b.queues[queueName]
+	b.mu.RUnlock()
+
+	if !ok {
+		return 0, 0
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.ready), len(q.unacked)
+}
+
+func (b *Broker) declareQueue(name string) *queue {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, ok := b.queues[name]
+	if !ok {
+		q = &queue{
+			name:    name,
+			unacked: make(map[uint64]unacked),
+			changed: make(chan struct{}),
+		}
+		b.queues[name] = q
+	}
+
+	return q
+}
+
+type consumer struct {
+	prefetch int
+	out      chan amqp.Delivery
+	stop     chan struct{}
+	unacked  int // guarded by queue mutex
+}
+
+type unacked struct {
+	delivery amqp.Delivery
+	consumer *consumer
+}
+
+// queue implements amqp.Acknowledger for its deliveries.
+type queue struct {
+	name string
+
+	mu      sync.Mutex // guards fields below
+	ready   []amqp.Delivery
+	unacked map[uint64]unacked
+	tag     uint64
+	changed chan struct{} // closed and replaced every time a message is pushed or acked
+}
+
+func (q *queue) push(d amqp.Delivery) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.ready = append(q.ready, d)
+	q.broadcast()
+}
+
+// deliver sends ready messages to the consumer until it's cancelled.
+func (q *queue) deliver(c *consumer) {
+	defer close(c.out)
+
+	for {
+		q.mu.Lock()
+
+		if len(q.ready) == 0 || (c.prefetch > 0 && c.unacked >= c.prefetch) {
+			changed := q.changed
+			q.mu.Unlock()
+
+			select {
+			case <-changed:
+				continue
+			case <-c.stop:
+				return
+			}
+		}
+
+		d := q.ready[0]
+		q.ready = q.ready[1:]
+		q.tag++
+		d.DeliveryTag = q.tag
+		d.Acknowledger = q
+		q.unacked[d.DeliveryTag] = unacked{delivery: d, consumer: c}
+		c.unacked++
+
+		q.mu.Unlock()
+
+		select {
+		case c.out <- d:
+		case <-c.stop:
+			// the message isn't delivered, so it's returned to the head of the queue
+			_ = q.Nack(d.DeliveryTag, false, true)
+			return
+		}
+	}
+}
+
+func (q *queue) Ack(tag uint64, multiple bool) error {
+	return q.settle(tag, multiple, false)
+}
+
+func (q *queue) Nack(tag uint64, multiple bool, requeue bool) error {
+	return q.settle(tag, multiple, requeue)
+}
+
+func (q *queue) Reject(tag uint64, requeue bool) error {
+	return q.settle(tag, false, requeue)
+}
+
+// settle removes acked or nacked messages from unacked ones. Requeued messages are put to the head of the queue
+// and marked as redelivered.
+func (q *queue) settle(tag uint64, multiple bool, requeue bool) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.unacked[tag]; !ok {
+		return errors.WithStack(fmt.Errorf("unknown delivery tag %d of queue %s", tag, q.name))
+	}
+
+	tags := []uint64{tag}
+	if multiple {
+		tags = tags[:0]
+		for t := range q.unacked {
+			if t <= tag {
+				tags = append(tags, t)
+			}
+		}
+	}
+
+	var requeued []amqp.Delivery
+
+	for _, t := range tags {
+		u := q.unacked[t]
+		delete(q.unacked, t)
+		u.consumer.unacked--
+
+		if requeue {
+			d := u.delivery
+			d.DeliveryTag = 0
+			d.Acknowledger = nil
+			d.Redelivered = true
+			requeued = append(requeued, d)
+		}
+	}
+
+	if len(requeued) > 0 {
+		q.ready = append(requeued, q.ready...)
+	}
+
+	q.broadcast()
+
+	return nil
+}
+
+// broadcast wakes up all consumers of the queue. It's called under the lock.
+func (q *queue) broadcast() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+func newDelivery(exchange string, routingKey string, msg amqp.Publishing) amqp.Delivery {
+	body := make([]byte, len(msg.Body))
+	copy(body, msg.Body)
+
+	timestamp := msg.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	return amqp.Delivery{
+		Headers:         msg.Headers,
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		DeliveryMode:    msg.DeliveryMode,
+		Priority:        msg.Priority,
+		CorrelationId:   msg.CorrelationId,
+		ReplyTo:         msg.ReplyTo,
+		Expiration:      msg.Expiration,
+		MessageId:       msg.MessageId,
+		Timestamp:       timestamp,
+		Type:            msg.Type,
+		UserId:          msg.UserId,
+		AppId:           msg.AppId,
+		Exchange:        exchange,
+		RoutingKey:      routingKey,
+		Body:            body,
+	}
+}
+
+// match reports whether routing key matches binding pattern of the topic exchange.
+func match(pattern string, routingKey string) bool {
+	return matchWords(strings.Split(pattern, "."), strings.Split(routingKey, "."))
+}
+
+func matchWords(pattern []string, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if matchWords(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+
+		return false
+	case "*":
+		return len(words) > 0 && matchWords(pattern[1:], words[1:])
+	default:
+		return len(words) > 0 && pattern[0] == words[0] && matchWords(pattern[1:], words[1:])
+	}
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		queues:   make(map[string]*queue),
+		bindings: make(map[string][]binding),
+	}
+}
@@ -0,0 +1,166 @@
+package memory
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+
+	"gitlab.com/slirx/newproj/pkg/rabbitmq"
+)
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		pattern    string
+		routingKey string
+		want       bool
+	}{
+		{"user.registered", "user.registered", true},
+		{"user.registered", "user.followed", false},
+		{"user.*", "user.followed", true},
+		{"user.*", "user", false},
+		{"user.*", "user.followed.twice", false},
+		{"*.created", "post.created", true},
+		{"#", "post.created", true},
+		{"user.#", "user", true},
+		{"user.#", "user.a.b", true},
+		{"#.created", "post.created", true},
+		{"#.created", "post.updated", false},
+	}
+
+	for _, c := range cases {
+		if got := match(c.pattern, c.routingKey); got != c.want {
+			t.Errorf("match(%q, %q) = %v; want %v", c.pattern, c.routingKey, got, c.want)
+		}
+	}
+}
+
+func receive(t *testing.T, deliveries <-chan amqp.Delivery) amqp.Delivery {
+	t.Helper()
+
+	select {
+	case d := <-deliveries:
+		return d
+	case <-time.After(time.Second):
+		t.Fatal("message is not delivered")
+	}
+
+	return amqp.Delivery{}
+}
+
+func TestSendUnroutable(t *testing.T) {
+	b := NewBroker()
+
+	err := b.Send("email.send", amqp.Publishing{Body: []byte("1")})
+	if !errors.Is(err, rabbitmq.ErrUnroutable) {
+		t.Fatalf("want ErrUnroutable; got %v", err)
+	}
+
+	b.DeclareQueue("email.send")
+
+	if err = b.Send("email.send", amqp.Publishing{Body: []byte("1")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if ready, _ := b.Len("email.send"); ready != 1 {
+		t.Fatalf("want 1 ready message; got %d", ready)
+	}
+}
+
+func TestNackRequeue(t *testing.T) {
+	b := NewBroker()
+	b.DeclareQueue("q")
+
+	for _, body := range []string{"1", "2"} {
+		if err := b.Send("q", amqp.Publishing{Body: []byte(body)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deliveries, cancel := b.Consume("q", 1)
+	defer cancel()
+
+	d := receive(t, deliveries)
+	if string(d.Body) != "1" || d.Redelivered {
+		t.Fatalf("want first message; got %q, redelivered %v", d.Body, d.Redelivered)
+	}
+
+	// prefetch is reached, the second message is not delivered until the first one is settled
+	select {
+	case d = <-deliveries:
+		t.Fatalf("message %q is delivered over prefetch", d.Body)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if err := d.Nack(false, true); err != nil {
+		t.Fatal(err)
+	}
+
+	d = receive(t, deliveries)
+	if string(d.Body) != "1" || !d.Redelivered {
+		t.Fatalf("want redelivered first message; got %q, redelivered %v", d.Body, d.Redelivered)
+	}
+
+	if err := d.Ack(false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Ack(false); err == nil {
+		t.Fatal("want error on second ack")
+	}
+
+	d = receive(t, deliveries)
+	if string(d.Body) != "2" {
+		t.Fatalf("want second message; got %q", d.Body)
+	}
+
+	if err := d.Ack(false); err != nil {
+		t.Fatal(err)
+	}
+
+	if ready, unacked := b.Len("q"); ready != 0 || unacked != 0 {
+		t.Fatalf("want empty queue; got %d ready, %d unacked", ready, unacked)
+	}
+}
+
+func TestPublishFanOut(t *testing.T) {
+	b := NewBroker()
+	b.Bind("feed", "events", "post.*")
+	b.Bind("audit", "events", "#")
+	b.Bind("audit", "events", "post.created") // message is routed to the queue once
+	b.Bind("follows", "events", "user.followed")
+
+	b.Publish("events", "post.created", amqp.Publishing{Body: []byte("1")})
+	b.Publish("other", "post.created", amqp.Publishing{Body: []byte("2")})
+
+	for queueName, want := range map[string]int{"feed": 1, "audit": 1, "follows": 0} {
+		if ready, _ := b.Len(queueName); ready != want {
+			t.Errorf("want %d messages in queue %s; got %d", want, queueName, ready)
+		}
+	}
+}
+
+func TestConsumeCancel(t *testing.T) {
+	b := NewBroker()
+
+	deliveries, cancel := b.Consume("q", 0)
+	cancel()
+
+	select {
+	case _, ok := <-deliveries:
+		if ok {
+			t.Fatal("want closed deliveries channel")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("deliveries channel is not closed")
+	}
+
+	if err := b.Send("q", amqp.Publishing{Body: []byte("1")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if ready, _ := b.Len("q"); ready != 1 {
+		t.Fatalf("want message to stay in the queue; got %d ready", ready)
+	}
+}
@@ -1,52 +1,134 @@
 // worker package contains structs for worker messages (tasks). this structs are used for work queue.
 package queue
 
+import (
+	"strconv"
+)
+
 const (
 	JobEmailSend            = "job:email/send"
+	JobEmailBounce          = "job:email/bounce"
+	JobEmailDigest          = "job:email/digest"
 	JobUserCreate           = "job:user/create"
+	JobUserUpdateEmail      = "job:user/update_email"
 	JobAuthCreate           = "job:auth/create"
 	JobPostFollow           = "job:post/follow"
 	JobPostUnfollow         = "job:post/unfollow"
 	JobAuthUpdateUserIDAuth = "job:auth/update_user_id"
+	JobRegistrationExternal = "job:registration/external"
 )
 
+// HeaderOrderingKey is the name of message header which contains ordering key of the job.
+const HeaderOrderingKey = "x-ordering-key"
+
+// Names of message headers of W3C Trace Context. Worker continues the trace of the request the job was sent from.
+const (
+	HeaderTraceparent = "traceparent"
+	HeaderTracestate  = "tracestate"
+)
+
+// Keyer is implemented by jobs which should be handled in order they were sent, relative to other jobs
+// with the same key. For example, follow/unfollow jobs of the same user.
+type Keyer interface {
+	OrderingKey() string
+}
+
 // Email represents fields which email's worker fetches from the queue to handle.
 // It sends emails specified in this struct.
 type Email struct {
-	RequestID      string
 	RecipientEmail string
 	Subject        string
 	HTML           string
 	Text           string
+	// UnsubscribeURL is one-click unsubscribe link (RFC 8058). It's empty for transactional emails.
+	UnsubscribeURL string
+	// Locale is the locale which the email is written in, for example "en" or "de-at". Subject and bodies are
+	// localized by the sender of the job, the worker only passes it to the mail client.
+	Locale string
+}
+
+// Transactional reports whether the email is sent because of user's action or for security reasons, for example
+// password reset code. Such emails have no unsubscribe link.
+func (e Email) Transactional() bool {
+	return e.UnsubscribeURL == ""
+}
+
+// Types of EmailBounce.
+const (
+	// BounceHard means that the address doesn't exist, it's suppressed right away.
+	BounceHard = "hard"
+	// BounceSoft means temporary problem, for example full mailbox. It's only logged.
+	BounceSoft = "soft"
+	// BounceComplaint means that recipient marked the email as spam, it's suppressed right away.
+	BounceComplaint = "complaint"
+)
+
+// EmailBounce represents bounce or complaint notification received from mail provider.
+type EmailBounce struct {
+	RecipientEmail string
+	Type           string // one of BounceHard, BounceSoft or BounceComplaint
+	Reason         string // diagnostic message of the provider
+}
+
+// EmailDigest represents request for sending digests of missed activity to all users with the frequency.
+type EmailDigest struct {
+	Frequency   string // "daily" or "weekly"
+	ScheduledAt int64  // unix timestamp, activity is collected for the period which ends at this time
 }
 
 type UserCreate struct {
-	RequestID string
-	Login     string
-	Email     string
+	Login string
+	Email string
+}
+
+// UserUpdateEmail represents confirmed change of user's email. Auth service sends it after the new email
+// is confirmed, so email of user service stays the same as the one of auth service.
+type UserUpdateEmail struct {
+	UserID int
+	Email  string
 }
 
 type AuthCreate struct {
-	RequestID string
-	Login     string
-	Email     string
-	Password  string
+	Login    string
+	Email    string
+	Password string // it's empty for users registered through OpenID Connect provider
+	// Issuer and Subject identify the user at OpenID Connect provider. They are set in case the user is registered
+	// by signing in through the provider, so the identity is linked to the new auth.
+	Issuer  string
+	Subject string
+}
+
+// RegistrationExternal represents registration of the user who signed in through OpenID Connect provider. Auth
+// service sends it, so login and email of the user are reserved by registration service the same way as the ones
+// of registered users before auth is created.
+type RegistrationExternal struct {
+	Login   string
+	Email   string // it's confirmed by the provider
+	Issuer  string
+	Subject string
 }
 
 type AuthUpdateUserID struct {
-	RequestID string
-	Login     string
-	UserID    int
+	Login  string
+	UserID int
 }
 
 type PostFollow struct {
-	RequestID    string
 	UserID       int // current user id
 	FollowUserID int // user id to follow
 }
 
+// OrderingKey returns id of the current user, so feed changes of one user are applied in order.
+func (p PostFollow) OrderingKey() string {
+	return strconv.Itoa(p.UserID)
+}
+
 type PostUnfollow struct {
-	RequestID      string
 	UserID         int
 	UnfollowUserID int
 }
+
+// OrderingKey returns id of the current user, so feed changes of one user are applied in order.
+func (p PostUnfollow) OrderingKey() string {
+	return strconv.Itoa(p.UserID)
+}
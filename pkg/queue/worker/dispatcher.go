@@ -0,0 +1,182 @@
+package worker
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/module/apmzap"
+
+	"gitlab.com/slirx/newproj/pkg/logger"
+	"gitlab.com/slirx/newproj/pkg/queue"
+	"gitlab.com/slirx/newproj/pkg/tracer"
+)
+
+// dispatcher hands deliveries over to the handler. It's shared by workers of all backends, so they have the same
+// concurrency, ordering, acknowledgement and shutdown semantics.
+type dispatcher struct {
+	Name      string // worker name
+	Kind      string // backend name, used in APM transaction type
+	Logger    logger.Logger
+	Tracer    *apm.Tracer
+	Handler   Handler
+	QueueName string
+	options   options
+}
+
+// wait waits until consume returns. In case handlers are not done in shutdown timeout, their context is cancelled
+// and their messages are returned to the queue.
+func (w *dispatcher) wait(consumed <-chan struct{}, cancelHandlers context.CancelFunc) {
+	select {
+	case <-consumed:
+	case <-time.After(w.options.shutdownTimeout):
+		w.Logger.Info("shutdown timeout is reached, interrupting in-flight handlers of worker " + w.Name)
+		cancelHandlers()
+		<-consumed
+	}
+
+	w.Logger.Info("worker " + w.Name + " is stopped")
+}
+
+// consume dispatches deliveries to the pool of handler goroutines until deliveries channel is closed or ctx is done.
+// Without key function any free handler takes the next delivery. With key function deliveries with the same key go
+// to the same handler, so they are handled in order.
+// Handlers are run with handlerCtx. consume returns only after all dispatched deliveries are acked or nacked.
+func (w *dispatcher) consume(ctx context.Context, handlerCtx context.Context, deliveries <-chan amqp.Delivery) {
+	var wg sync.WaitGroup
+
+	lanesCount := 1
+	if w.options.keyFunc != nil {
+		lanesCount = w.options.concurrency
+	}
+
+	lanes := make([]chan amqp.Delivery, lanesCount)
+	for i := range lanes {
+		lanes[i] = make(chan amqp.Delivery)
+	}
+
+	for i := 0; i < w.options.concurrency; i++ {
+		wg.Add(1)
+
+		go func(lane <-chan amqp.Delivery) {
+			defer wg.Done()
+
+			for d := range lane {
+				w.handleMessage(handlerCtx, d)
+			}
+		}(lanes[i%lanesCount])
+	}
+
+	defer func() {
+		for _, lane := range lanes {
+			close(lane)
+		}
+
+		wg.Wait()
+	}()
+
+	next := 0
+
+	for {
+		var d amqp.Delivery
+		var ok bool
+
+		select {
+		case <-ctx.Done():
+			w.reject(handlerCtx, deliveries)
+			return
+		case d, ok = <-deliveries:
+			if !ok {
+				return
+			}
+		}
+
+		index := 0
+		if w.options.keyFunc != nil {
+			if key := w.options.keyFunc(d); key != "" {
+				index = laneIndex(key, lanesCount)
+			} else {
+				// unordered deliveries are spread between lanes evenly
+				index = next % lanesCount
+				next++
+			}
+		}
+
+		select {
+		case lanes[index] <- d:
+		case <-ctx.Done():
+			w.nack(d)
+			w.reject(handlerCtx, deliveries)
+			return
+		}
+	}
+}
+
+// reject returns prefetched but not yet dispatched deliveries to the queue. It reads deliveries until the channel is
+// closed (after consumer is cancelled) or handlerCtx is done.
+func (w *dispatcher) reject(handlerCtx context.Context, deliveries <-chan amqp.Delivery) {
+	for {
+		select {
+		case d, ok := <-deliveries:
+			if !ok {
+				return
+			}
+
+			w.nack(d)
+		case <-handlerCtx.Done():
+			return
+		}
+	}
+}
+
+func (w *dispatcher) nack(d amqp.Delivery) {
+	if err := d.Nack(false, true); err != nil {
+		w.Logger.Error(err)
+	}
+}
+
+// laneIndex returns index of the lane for the key.
+func laneIndex(key string, lanesCount int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+
+	return int(h.Sum32() % uint32(lanesCount))
+}
+
+// handleMessage handles msg in APM transaction. The transaction continues the trace of the sender in case msg has
+// W3C Trace Context headers.
+func (w *dispatcher) handleMessage(ctx context.Context, msg amqp.Delivery) {
+	var err error
+
+	traceparent, _ := msg.Headers[queue.HeaderTraceparent].(string)
+	tracestate, _ := msg.Headers[queue.HeaderTracestate].(string)
+
+	opts := apm.TransactionOptions{
+		Start:        time.Now(),
+		TraceContext: tracer.ParseTraceparent(traceparent, tracestate),
+	}
+	tx := w.Tracer.StartTransactionOptions(w.Name, w.Kind+"_task", opts)
+	tx.Context.SetLabel(w.Kind+"_queue", w.QueueName)
+	defer tx.End()
+
+	ctx = apm.ContextWithTransaction(ctx, tx)
+
+	if err = w.Handler.Handle(ctx, msg); err != nil {
+		// todo check that error is bounded with context. so in log goes trace.id
+		w.Logger.Error(err, apmzap.TraceContext(ctx)...)
+
+		// message which failed permanently is rejected, so the broker drops it or moves it to dead letter exchange
+		if err = msg.Nack(false, !IsPermanent(err)); err != nil {
+			w.Logger.Error(err, apmzap.TraceContext(ctx)...)
+		}
+
+		return
+	}
+
+	if err = msg.Ack(false); err != nil {
+		w.Logger.Error(err, apmzap.TraceContext(ctx)...)
+	}
+}
@@ -0,0 +1,77 @@
+package worker
+
+import (
+	"context"
+
+	"github.com/streadway/amqp"
+	"go.elastic.co/apm"
+
+	"gitlab.com/slirx/newproj/pkg/logger"
+	"gitlab.com/slirx/newproj/pkg/queue/memory"
+)
+
+// NewMemoryWorker returns worker which consumes messages from in-memory broker. The queue is declared right away,
+// so jobs sent before Run are not lost. Prefetch count is equal to concurrency of the worker.
+func NewMemoryWorker(
+	workerName string,
+	b *memory.Broker,
+	l logger.Logger,
+	tracer *apm.Tracer,
+	handler Handler,
+	queueName string,
+	o ...Option,
+) Worker {
+	b.DeclareQueue(queueName)
+
+	return &memoryWorker{
+		dispatcher: dispatcher{
+			Name:      workerName,
+			Kind:      "memory",
+			Logger:    l,
+			Tracer:    tracer,
+			Handler:   handler,
+			QueueName: queueName,
+			options:   gatherOptions(o...),
+		},
+		Broker: b,
+	}
+}
+
+type memoryWorker struct {
+	dispatcher
+	Broker *memory.Broker
+}
+
+func (w *memoryWorker) Run(ctx context.Context) {
+	w.run(ctx)
+}
+
+func (w *memoryWorker) EventListener(ctx context.Context, exchangeName string, routingKeys ...string) {
+	for _, key := range routingKeys {
+		w.Broker.Bind(w.QueueName, exchangeName, key)
+	}
+
+	w.run(ctx)
+}
+
+// run consumes the queue until ctx is done and waits for in-flight handlers.
+func (w *memoryWorker) run(ctx context.Context) {
+	deliveries, cancel := w.Broker.Consume(w.QueueName, w.options.concurrency)
+
+	// handlers get their own context, so in-flight messages are not interrupted as soon as shutdown is started
+	handlerCtx, cancelHandlers := context.WithCancel(context.Background())
+	defer cancelHandlers()
+
+	consumed := make(chan struct{})
+
+	go func(deliveries <-chan amqp.Delivery) {
+		w.consume(ctx, handlerCtx, deliveries)
+		close(consumed)
+	}(deliveries)
+
+	<-ctx.Done()
+
+	w.Logger.Info("shutting down worker " + w.Name)
+	cancel()
+	w.wait(consumed, cancelHandlers)
+}
@@ -0,0 +1,267 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/transport"
+	"go.uber.org/zap"
+
+	"gitlab.com/slirx/newproj/pkg/logger"
+	"gitlab.com/slirx/newproj/pkg/queue"
+	"gitlab.com/slirx/newproj/pkg/queue/manager"
+	"gitlab.com/slirx/newproj/pkg/queue/memory"
+)
+
+func newTestMemoryWorker(b *memory.Broker, queueName string, h Handler, o ...Option) Worker {
+	l := logger.Mock{
+		InfoFn:  func(msg string, fields ...zap.Field) {},
+		ErrorFn: func(err error, fields ...zap.Field) {},
+	}
+
+	tracer, err := apm.NewTracerOptions(apm.TracerOptions{Transport: transport.Discard})
+	if err != nil {
+		panic(err)
+	}
+
+	return NewMemoryWorker("test", b, l, tracer, h, queueName, o...)
+}
+
+func TestMemoryWorkerRetry(t *testing.T) {
+	b := memory.NewBroker()
+	m := manager.NewMemoryManager(b)
+
+	handled := make(chan queue.Email, 2)
+	attempts := 0
+
+	h := handlerMock{
+		HandleFn: func(ctx context.Context, msg amqp.Delivery) error {
+			attempts++
+			if attempts == 1 {
+				return errors.New("temporary error")
+			}
+
+			var email queue.Email
+			if err := gob.NewDecoder(bytes.NewReader(msg.Body)).Decode(&email); err != nil {
+				return err
+			}
+
+			handled <- email
+
+			return nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := newTestMemoryWorker(b, queue.JobEmailSend, h)
+
+	stopped := make(chan struct{})
+
+	go func() {
+		w.Run(ctx)
+		close(stopped)
+	}()
+
+	err := m.Send(ctx, queue.JobEmailSend, queue.Email{RecipientEmail: "user@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case email := <-handled:
+		if email.RecipientEmail != "user@example.com" {
+			t.Fatalf("unexpected email: %+v", email)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("job is not handled")
+	}
+
+	cancel()
+	<-stopped
+
+	if attempts != 2 {
+		t.Fatalf("want 2 attempts; got %d", attempts)
+	}
+
+	if ready, unacked := b.Len(queue.JobEmailSend); ready != 0 || unacked != 0 {
+		t.Fatalf("want empty queue; got %d ready, %d unacked", ready, unacked)
+	}
+}
+
+func TestMemoryWorkerEvents(t *testing.T) {
+	b := memory.NewBroker()
+	m := manager.NewMemoryManager(b)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	received := make(map[string][]string)
+
+	newHandler := func(queueName string) Handler {
+		return handlerMock{
+			HandleFn: func(ctx context.Context, msg amqp.Delivery) error {
+				mu.Lock()
+				received[queueName] = append(received[queueName], msg.RoutingKey)
+				mu.Unlock()
+				wg.Done()
+
+				return nil
+			},
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	listeners := map[string][]string{
+		"feed":  {"post.created"},
+		"stats": {"user.*", "post.#"},
+	}
+
+	for queueName, keys := range listeners {
+		w := newTestMemoryWorker(b, queueName, newHandler(queueName))
+		// EventListener binds the queue asynchronously, so bindings are declared before publishing
+		for _, key := range keys {
+			b.Bind(queueName, "events", key)
+		}
+
+		go w.EventListener(ctx, "events", keys...)
+	}
+
+	wg.Add(3)
+
+	for _, key := range []string{"post.created", "user.followed", "comment.created"} {
+		if err := m.Publish(ctx, "events", key, struct{ ID int }{ID: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	handled := make(chan struct{})
+
+	go func() {
+		wg.Wait()
+		close(handled)
+	}()
+
+	select {
+	case <-handled:
+	case <-time.After(time.Second):
+		t.Fatal("events are not delivered")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(received["feed"]) != 1 || received["feed"][0] != "post.created" {
+		t.Fatalf("unexpected events of feed listener: %v", received["feed"])
+	}
+
+	if len(received["stats"]) != 2 {
+		t.Fatalf("unexpected events of stats listener: %v", received["stats"])
+	}
+}
+
+func TestMemoryWorkerPermanentError(t *testing.T) {
+	b := memory.NewBroker()
+	m := manager.NewMemoryManager(b)
+
+	handled := make(chan struct{}, 2)
+
+	h := handlerMock{
+		HandleFn: func(ctx context.Context, msg amqp.Delivery) error {
+			handled <- struct{}{}
+
+			return Permanent(errors.New("invalid recipient"))
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := newTestMemoryWorker(b, queue.JobEmailSend, h)
+
+	stopped := make(chan struct{})
+
+	go func() {
+		w.Run(ctx)
+		close(stopped)
+	}()
+
+	if err := m.Send(ctx, queue.JobEmailSend, queue.Email{RecipientEmail: "invalid"}); err != nil {
+		t.Fatal(err)
+	}
+
+	<-handled
+
+	// the message is rejected, so it's not redelivered
+	select {
+	case <-handled:
+		t.Fatal("permanently failed message is retried")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	cancel()
+	<-stopped
+
+	if ready, unacked := b.Len(queue.JobEmailSend); ready != 0 || unacked != 0 {
+		t.Fatalf("want empty queue; got %d ready, %d unacked", ready, unacked)
+	}
+}
+
+func TestMemoryWorkerTraceContext(t *testing.T) {
+	b := memory.NewBroker()
+	m := manager.NewMemoryManager(b)
+
+	traces := make(chan apm.TraceContext, 2)
+
+	h := handlerMock{
+		HandleFn: func(ctx context.Context, msg amqp.Delivery) error {
+			traces <- apm.TransactionFromContext(ctx).TraceContext()
+
+			return nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := newTestMemoryWorker(b, queue.JobEmailSend, h)
+
+	stopped := make(chan struct{})
+
+	go func() {
+		w.Run(ctx)
+		close(stopped)
+	}()
+
+	tracer, err := apm.NewTracerOptions(apm.TracerOptions{Transport: transport.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := tracer.StartTransaction("request", "request")
+	defer tx.End()
+
+	if err = m.Send(apm.ContextWithTransaction(ctx, tx), queue.JobEmailSend, queue.Email{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the job is handled as a part of the trace of the request it was sent from
+	if got := <-traces; got.Trace != tx.TraceContext().Trace || got.Span == tx.TraceContext().Span {
+		t.Fatalf("got: %s, want: trace %s", got.Trace, tx.TraceContext().Trace)
+	}
+
+	// a new trace is started for the job sent without trace
+	if err = m.Send(ctx, queue.JobEmailSend, queue.Email{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := <-traces; got.Trace == tx.TraceContext().Trace {
+		t.Fatalf("got: %s, want: a new trace", got.Trace)
+	}
+
+	cancel()
+	<-stopped
+}
@@ -2,13 +2,11 @@ package worker
 
 import (
 	"context"
-	"time"
 
 	"github.com/streadway/amqp"
 	"gitlab.com/slirx/newproj/pkg/logger"
 	"gitlab.com/slirx/newproj/pkg/rabbitmq"
 	"go.elastic.co/apm"
-	"go.elastic.co/apm/module/apmzap"
 )
 
 func NewWorker(
@@ -18,29 +16,42 @@ func NewWorker(
 	tracer *apm.Tracer,
 	handler Handler,
 	queueName string,
+	o ...Option,
 ) Worker {
 	return &rabbitmqWorker{
-		Name:      workerName,
-		Client:    client,
-		Error:     make(chan error),
-		Logger:    l,
-		Handler:   handler,
-		Tracer:    tracer,
-		QueueName: queueName,
+		dispatcher: dispatcher{
+			Name:      workerName,
+			Kind:      "rabbitmq",
+			Logger:    l,
+			Tracer:    tracer,
+			Handler:   handler,
+			QueueName: queueName,
+			options:   gatherOptions(o...),
+		},
+		Client: client,
+		Error:  make(chan error),
 	}
 }
 
 type rabbitmqWorker struct {
-	Name      string // worker name
-	Client    *rabbitmq.Client
-	Error     chan error
-	Logger    logger.Logger
-	Tracer    *apm.Tracer
-	Handler   Handler
-	QueueName string
+	dispatcher
+	Client *rabbitmq.Client
+	Error  chan error
 }
 
 func (w *rabbitmqWorker) Run(ctx context.Context) {
+	w.run(ctx, w.Client.Messages)
+}
+
+func (w rabbitmqWorker) EventListener(ctx context.Context, exchangeName string, routingKeys ...string) {
+	w.run(ctx, func() (<-chan amqp.Delivery, error) {
+		return w.Client.Events(exchangeName, routingKeys)
+	})
+}
+
+// run consumes deliveries returned by subscribe and re-subscribes after connection is re-established. When ctx is done
+// it stops consuming and waits for in-flight handlers before closing the connection.
+func (w *rabbitmqWorker) run(ctx context.Context, subscribe func() (<-chan amqp.Delivery, error)) {
 	done := make(chan struct{})
 
 	go func() {
@@ -56,8 +67,7 @@ func (w *rabbitmqWorker) Run(ctx context.Context) {
 		<-done
 	}()
 
-	err := w.Client.Connection.Reconnect(ctx)
-	if err != nil {
+	if err := w.Client.Connection.Connect(ctx); err != nil {
 		w.Error <- err
 		return
 	}
@@ -66,127 +76,59 @@ func (w *rabbitmqWorker) Run(ctx context.Context) {
 		w.Error <- w.Client.Connection.Close()
 	}()
 
+	reconnected := w.Client.Connection.NotifyReconnect()
+
+	// handlers get their own context, so in-flight messages are not interrupted as soon as shutdown is started
+	handlerCtx, cancelHandlers := context.WithCancel(context.Background())
+	defer cancelHandlers()
+
 	for {
-		messages, err := w.Client.Messages()
+		deliveries, err := subscribe()
 		if err != nil {
 			w.Error <- err
 			break
 		}
 
-		go func(ctx context.Context) {
-			for d := range messages {
-				select {
-				case <-ctx.Done():
-					return
-				default:
-				}
+		consumed := make(chan struct{})
 
-				w.handleMessage(ctx, d)
-			}
-		}(ctx)
-
-		amqpErr := make(chan *amqp.Error)
+		go func() {
+			w.consume(ctx, handlerCtx, deliveries)
+			close(consumed)
+		}()
 
 		select {
-		case <-w.Client.Connection.Connection.NotifyClose(amqpErr):
-			if err = w.Client.Connection.Reconnect(ctx); err != nil {
-				w.Error <- err
+		case _, ok := <-reconnected:
+			if !ok && ctx.Err() != nil { // connection stopped watching because ctx is done
+				w.shutdown(consumed, cancelHandlers)
 				return
 			}
 
-			continue
-		case <-ctx.Done():
-			return
-		}
-	}
-}
+			// deliveries channel is closed together with lost connection, so consume returns when handlers are done
+			<-consumed
 
-func (w rabbitmqWorker) EventListener(ctx context.Context, exchangeName string) {
-	done := make(chan struct{})
-
-	go func() {
-		for err := range w.Error {
-			w.Logger.Error(err)
-		}
-
-		done <- struct{}{}
-	}()
-
-	defer func() {
-		close(w.Error)
-		<-done
-	}()
-
-	err := w.Client.Connection.Reconnect(ctx)
-	if err != nil {
-		w.Error <- err
-		return
-	}
-
-	defer func() {
-		w.Error <- w.Client.Connection.Close()
-	}()
-
-	for {
-		events, err := w.Client.Events(exchangeName)
-		if err != nil {
-			w.Error <- err
-			break
-		}
-
-		go func(ctx context.Context) {
-			for d := range events {
-				select {
-				case <-ctx.Done():
-					return
-				default:
+			if !ok {
+				if err = w.Client.Connection.Err(); err != nil {
+					w.Error <- err
 				}
 
-				w.handleMessage(ctx, d)
-			}
-		}(ctx)
-
-		amqpErr := make(chan *amqp.Error)
-
-		select {
-		case <-w.Client.Connection.Connection.NotifyClose(amqpErr):
-			if err = w.Client.Connection.Reconnect(ctx); err != nil {
-				w.Error <- err
 				return
 			}
 
 			continue
 		case <-ctx.Done():
+			w.shutdown(consumed, cancelHandlers)
 			return
 		}
 	}
 }
 
-func (w *rabbitmqWorker) handleMessage(ctx context.Context, msg amqp.Delivery) {
-	var err error
-
-	opts := apm.TransactionOptions{
-		Start:        time.Now(),
-		TraceContext: apm.TraceContext{},
-	}
-	tx := w.Tracer.StartTransactionOptions(w.Name, "rabbitmq_task", opts)
-	tx.Context.SetLabel("rabbitmq_queue", w.QueueName)
-	defer tx.End()
-
-	ctx = apm.ContextWithTransaction(ctx, tx)
-
-	if err = w.Handler.Handle(ctx, msg); err != nil {
-		// todo check that error is bounded with context. so in log goes trace.id
-		w.Logger.Error(err, apmzap.TraceContext(ctx)...)
-
-		if err = msg.Nack(false, true); err != nil {
-			w.Logger.Error(err, apmzap.TraceContext(ctx)...)
-		}
+// shutdown stops consuming and waits until in-flight handlers are done.
+func (w *rabbitmqWorker) shutdown(consumed <-chan struct{}, cancelHandlers context.CancelFunc) {
+	w.Logger.Info("shutting down worker " + w.Name)
 
-		return
+	if err := w.Client.Cancel(); err != nil {
+		w.Error <- err
 	}
 
-	if err = msg.Ack(false); err != nil {
-		w.Logger.Error(err, apmzap.TraceContext(ctx)...)
-	}
+	w.wait(consumed, cancelHandlers)
 }
@@ -0,0 +1,278 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/transport"
+	"go.uber.org/zap"
+
+	"gitlab.com/slirx/newproj/pkg/logger"
+	"gitlab.com/slirx/newproj/pkg/queue"
+)
+
+type acknowledgerMock struct {
+	mu     sync.Mutex
+	acked  []uint64
+	nacked []uint64
+}
+
+func (a *acknowledgerMock) Ack(tag uint64, multiple bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.acked = append(a.acked, tag)
+
+	return nil
+}
+
+func (a *acknowledgerMock) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.nacked = append(a.nacked, tag)
+
+	return nil
+}
+
+func (a *acknowledgerMock) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+type handlerMock struct {
+	HandleFn func(ctx context.Context, msg amqp.Delivery) error
+}
+
+func (h handlerMock) Handle(ctx context.Context, msg amqp.Delivery) error {
+	return h.HandleFn(ctx, msg)
+}
+
+func newTestWorker(h Handler, o ...Option) *rabbitmqWorker {
+	l := logger.Mock{
+		ErrorFn: func(err error, fields ...zap.Field) {},
+	}
+
+	tracer, err := apm.NewTracerOptions(apm.TracerOptions{Transport: transport.Discard})
+	if err != nil {
+		panic(err)
+	}
+
+	return NewWorker("test", nil, l, tracer, h, "test", o...).(*rabbitmqWorker)
+}
+
+func TestConsumeOrdering(t *testing.T) {
+	keysCount := 4
+	messagesPerKey := 25
+
+	var mu sync.Mutex
+	handled := make(map[string][]int)
+
+	h := handlerMock{
+		HandleFn: func(ctx context.Context, msg amqp.Delivery) error {
+			key := OrderingKey(msg)
+			seq, _ := strconv.Atoi(string(msg.Body))
+
+			// give other handlers a chance to overtake this one
+			time.Sleep(time.Duration(seq%3) * time.Millisecond)
+
+			mu.Lock()
+			handled[key] = append(handled[key], seq)
+			mu.Unlock()
+
+			if seq == 0 {
+				return errors.New("handler error")
+			}
+
+			return nil
+		},
+	}
+
+	w := newTestWorker(h, WithConcurrency(3), WithKeyFunc(OrderingKey))
+	ack := &acknowledgerMock{}
+	deliveries := make(chan amqp.Delivery)
+
+	go func() {
+		tag := uint64(0)
+
+		for i := 0; i < messagesPerKey; i++ {
+			for k := 0; k < keysCount; k++ {
+				tag++
+				deliveries <- amqp.Delivery{
+					Acknowledger: ack,
+					DeliveryTag:  tag,
+					Headers:      amqp.Table{queue.HeaderOrderingKey: "user" + strconv.Itoa(k)},
+					Body:         []byte(strconv.Itoa(i)),
+				}
+			}
+		}
+
+		close(deliveries)
+	}()
+
+	w.consume(context.Background(), context.Background(), deliveries)
+
+	if len(handled) != keysCount {
+		t.Fatalf("want %d keys; got %d", keysCount, len(handled))
+	}
+
+	for key, seqs := range handled {
+		if len(seqs) != messagesPerKey {
+			t.Fatalf("want %d messages for key %s; got %d", messagesPerKey, key, len(seqs))
+		}
+
+		for i, seq := range seqs {
+			if seq != i {
+				t.Fatalf("messages of key %s are out of order: %v", key, seqs)
+			}
+		}
+	}
+
+	if len(ack.nacked) != keysCount {
+		t.Fatalf("want %d nacked messages; got %d", keysCount, len(ack.nacked))
+	}
+
+	if len(ack.acked) != keysCount*(messagesPerKey-1) {
+		t.Fatalf("want %d acked messages; got %d", keysCount*(messagesPerKey-1), len(ack.acked))
+	}
+}
+
+func TestConsumeConcurrency(t *testing.T) {
+	concurrency := 4
+
+	var mu sync.Mutex
+	running := 0
+	maxRunning := 0
+
+	h := handlerMock{
+		HandleFn: func(ctx context.Context, msg amqp.Delivery) error {
+			mu.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+
+			return nil
+		},
+	}
+
+	w := newTestWorker(h, WithConcurrency(concurrency))
+	ack := &acknowledgerMock{}
+	deliveries := make(chan amqp.Delivery, concurrency*4)
+
+	for i := 0; i < concurrency*4; i++ {
+		deliveries <- amqp.Delivery{Acknowledger: ack, DeliveryTag: uint64(i + 1)}
+	}
+
+	close(deliveries)
+
+	w.consume(context.Background(), context.Background(), deliveries)
+
+	if maxRunning != concurrency {
+		t.Fatalf("want %d concurrent handlers; got %d", concurrency, maxRunning)
+	}
+
+	if len(ack.acked) != concurrency*4 {
+		t.Fatalf("want %d acked messages; got %d", concurrency*4, len(ack.acked))
+	}
+}
+
+func TestConsumeShutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	h := handlerMock{
+		HandleFn: func(ctx context.Context, msg amqp.Delivery) error {
+			close(started)
+			<-release
+
+			return ctx.Err()
+		},
+	}
+
+	w := newTestWorker(h)
+	ack := &acknowledgerMock{}
+	deliveries := make(chan amqp.Delivery, 3)
+
+	for i := 0; i < 3; i++ {
+		deliveries <- amqp.Delivery{Acknowledger: ack, DeliveryTag: uint64(i + 1)}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	consumed := make(chan struct{})
+
+	go func() {
+		w.consume(ctx, context.Background(), deliveries)
+		close(consumed)
+	}()
+
+	<-started
+	cancel()
+	// consumer is cancelled, broker closes deliveries channel
+	close(deliveries)
+
+	select {
+	case <-consumed:
+		t.Fatal("consume returned before in-flight handler is done")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	<-consumed
+
+	if len(ack.acked) != 1 || ack.acked[0] != 1 {
+		t.Fatalf("want in-flight message to be acked; got acked %v", ack.acked)
+	}
+
+	if len(ack.nacked) != 2 {
+		t.Fatalf("want 2 nacked messages; got %v", ack.nacked)
+	}
+}
+
+func TestConsumeShutdownTimeout(t *testing.T) {
+	started := make(chan struct{})
+
+	h := handlerMock{
+		HandleFn: func(ctx context.Context, msg amqp.Delivery) error {
+			close(started)
+			<-ctx.Done()
+
+			return ctx.Err()
+		},
+	}
+
+	w := newTestWorker(h)
+	ack := &acknowledgerMock{}
+	deliveries := make(chan amqp.Delivery, 1)
+	deliveries <- amqp.Delivery{Acknowledger: ack, DeliveryTag: 1}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	handlerCtx, cancelHandlers := context.WithCancel(context.Background())
+	consumed := make(chan struct{})
+
+	go func() {
+		w.consume(ctx, handlerCtx, deliveries)
+		close(consumed)
+	}()
+
+	<-started
+	cancel()
+	cancelHandlers()
+	<-consumed
+
+	if len(ack.nacked) != 1 || len(ack.acked) != 0 {
+		t.Fatalf("want interrupted message to be nacked; got acked %v, nacked %v", ack.acked, ack.nacked)
+	}
+}
@@ -2,15 +2,118 @@ package worker
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/streadway/amqp"
+
+	"gitlab.com/slirx/newproj/pkg/queue"
 )
 
 type Worker interface {
 	Run(ctx context.Context)
-	EventListener(ctx context.Context, exchangeName string) // todo move to another interface?
+	// EventListener consumes events published to the topic exchange with routingKeys.
+	EventListener(ctx context.Context, exchangeName string, routingKeys ...string) // todo move to another interface?
 }
 
 type Handler interface {
 	Handle(ctx context.Context, msg amqp.Delivery) error
 }
+
+// permanentError is an error of the handler which can't be fixed by retrying.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks err returned by the handler as permanent. The message is rejected instead of being returned to
+// the queue, so it's not retried.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return permanentError{err: err}
+}
+
+// IsPermanent reports whether any error in err's chain is marked with Permanent.
+func IsPermanent(err error) bool {
+	var p permanentError
+
+	return errors.As(err, &p)
+}
+
+// KeyFunc returns ordering key of the message. Messages with the same key are handled one by one in order they were
+// delivered. Messages with an empty key are not ordered.
+type KeyFunc func(msg amqp.Delivery) string
+
+// OrderingKey returns ordering key which was set by the manager for jobs implementing queue.Keyer.
+func OrderingKey(msg amqp.Delivery) string {
+	key, _ := msg.Headers[queue.HeaderOrderingKey].(string)
+
+	return key
+}
+
+type options struct {
+	concurrency     int
+	keyFunc         KeyFunc
+	shutdownTimeout time.Duration
+}
+
+// Option sets options for worker.
+type Option func(*options)
+
+// WithConcurrency returns an Option which sets maximum number of messages handled at the same time.
+// Prefetch count of the client should be not less than n, otherwise handlers will wait for deliveries.
+func WithConcurrency(n int) Option {
+	if n <= 0 {
+		panic("n <= 0")
+	}
+
+	return func(o *options) {
+		o.concurrency = n
+	}
+}
+
+// WithKeyFunc returns an Option which enables per-key ordering of messages. Messages with the same key are always
+// handled by the same handler goroutine.
+func WithKeyFunc(f KeyFunc) Option {
+	if f == nil {
+		panic("f == nil")
+	}
+
+	return func(o *options) {
+		o.keyFunc = f
+	}
+}
+
+// WithShutdownTimeout returns an Option which sets how long worker waits for in-flight handlers after its context is
+// done. Handlers which are not finished in time are interrupted and their messages are returned to the queue.
+func WithShutdownTimeout(d time.Duration) Option {
+	if d < 0 {
+		panic("d < 0")
+	}
+
+	return func(o *options) {
+		o.shutdownTimeout = d
+	}
+}
+
+func gatherOptions(o ...Option) options {
+	opts := options{
+		concurrency:     1,
+		shutdownTimeout: 30 * time.Second,
+	}
+	for _, o := range o {
+		o(&opts)
+	}
+
+	return opts
+}
@@ -2,6 +2,7 @@ package rabbitmq
 
 import (
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/pkg/errors"
@@ -10,20 +11,35 @@ import (
 )
 
 type Config struct {
-	URI                     string
-	MaxReconnections        int
+	URI string
+	// MaxReconnections is the number of failed connection attempts in a row after which connection is given up.
+	// Counter is reset after successful connection. Zero value means unlimited attempts.
+	MaxReconnections int
+	// ReconnectTimeoutSeconds is the delay before the first reconnection attempt. Next delays grow exponentially.
 	ReconnectTimeoutSeconds time.Duration
+	// MaxReconnectTimeout is the maximum delay between reconnection attempts. Default value is 30 seconds.
+	MaxReconnectTimeout time.Duration
+	// ChannelPoolSize is the maximum number of channels used by publishers at the same time. Default value is 10.
+	ChannelPoolSize int
+	// PrefetchCount is the number of unacknowledged messages the broker delivers to a consumer at once.
+	// It should be not less than the number of concurrent handlers of the worker. Default value is 1.
+	PrefetchCount int
+	// ConfirmTimeout is how long publisher waits for the broker to confirm the message. Default value is 5 seconds.
+	ConfirmTimeout time.Duration
 }
 
 type Client struct {
-	Config     Config
-	Connection *connection
-	Logger     logger.Logger
-	QueueName  string
+	Config      Config
+	Connection  *connection
+	Logger      logger.Logger
+	QueueName   string
+	ConsumerTag string
 }
 
 func (c Client) Messages() (<-chan amqp.Delivery, error) {
-	q, err := c.Connection.Channel.QueueDeclare(
+	ch := c.Connection.Channel()
+
+	q, err := ch.QueueDeclare(
 		c.QueueName,
 		true,
 		false,
@@ -35,14 +51,19 @@ func (c Client) Messages() (<-chan amqp.Delivery, error) {
 		return nil, errors.WithStack(fmt.Errorf("can not declare queue: %w", err))
 	}
 
-	err = c.Connection.Channel.Qos(1, 0, false)
+	prefetchCount := c.Config.PrefetchCount
+	if prefetchCount <= 0 {
+		prefetchCount = 1
+	}
+
+	err = ch.Qos(prefetchCount, 0, false)
 	if err != nil {
 		return nil, errors.WithStack(fmt.Errorf("can not apply Qos: %w", err))
 	}
 
-	messages, err := c.Connection.Channel.Consume(
+	messages, err := ch.Consume(
 		q.Name,
-		"",
+		c.ConsumerTag,
 		false,
 		false,
 		false,
@@ -56,8 +77,16 @@ func (c Client) Messages() (<-chan amqp.Delivery, error) {
 	return messages, nil
 }
 
-func (c Client) Events(exchangeName string) (<-chan amqp.Delivery, error) {
-	err := c.Connection.Channel.ExchangeDeclare(
+// Events declares durable queue with client's queue name, binds it to the topic exchange with routingKeys and
+// consumes events from it. Events published while subscriber is offline wait in the queue.
+func (c Client) Events(exchangeName string, routingKeys []string) (<-chan amqp.Delivery, error) {
+	if c.QueueName == "" {
+		return nil, errors.WithStack(errors.New("queue name is required for subscribing to events"))
+	}
+
+	ch := c.Connection.Channel()
+
+	err := ch.ExchangeDeclare(
 		exchangeName,
 		"topic", // type
 		true,    // durable
@@ -70,47 +99,43 @@ func (c Client) Events(exchangeName string) (<-chan amqp.Delivery, error) {
 		return nil, errors.WithStack(err)
 	}
 
-	q, err := c.Connection.Channel.QueueDeclare(
-		"",    // name
-		false, // durable
-		false, // delete when unused
-		true,  // exclusive
-		false, // no-wait
-		nil,   // arguments
+	q, err := ch.QueueDeclare(
+		c.QueueName, // name
+		true,        // durable
+		false,       // delete when unused
+		false,       // exclusive
+		false,       // no-wait
+		nil,         // arguments
 	)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
-	/*listenedServices := []string{"registration"}
-	for _, service := range listenedServices {
-		// todo which services listen to
+	for _, routingKey := range routingKeys {
 		err = ch.QueueBind(
-			q.Name,      // queue name
-			service,     // routing key
-			"microblog", // exchange
+			q.Name,       // queue name
+			routingKey,   // routing key
+			exchangeName, // exchange
 			false,
 			nil,
 		)
 		if err != nil {
-			panic(err)
+			return nil, errors.WithStack(fmt.Errorf("can not bind queue to %s: %w", routingKey, err))
 		}
-	}*/
+	}
 
-	err = c.Connection.Channel.QueueBind(
-		q.Name,       // queue name
-		"",           // routing key
-		exchangeName, // exchange
-		false,
-		nil,
-	)
-	if err != nil {
-		return nil, errors.WithStack(err)
+	prefetchCount := c.Config.PrefetchCount
+	if prefetchCount <= 0 {
+		prefetchCount = 1
 	}
-	//c.Connection.Channel.Confirm()
-	messages, err := c.Connection.Channel.Consume(
+
+	if err = ch.Qos(prefetchCount, 0, false); err != nil {
+		return nil, errors.WithStack(fmt.Errorf("can not apply Qos: %w", err))
+	}
+
+	messages, err := ch.Consume(
 		q.Name,
-		"",
+		c.ConsumerTag,
 		false,
 		false,
 		false,
@@ -124,7 +149,26 @@ func (c Client) Events(exchangeName string) (<-chan amqp.Delivery, error) {
 	return messages, nil
 }
 
+// Cancel stops deliveries to the consumer. Deliveries channel is closed after the broker confirms cancellation.
+// Already delivered messages can still be acked or nacked.
+func (c Client) Cancel() error {
+	ch := c.Connection.Channel()
+	if ch == nil {
+		return nil
+	}
+
+	if err := ch.Cancel(c.ConsumerTag, false); err != nil {
+		return errors.WithStack(fmt.Errorf("can not cancel consumer: %w", err))
+	}
+
+	return nil
+}
+
 func NewClient(conf Config, l logger.Logger, queueName string) *Client {
 	conn := NewConnection(conf, l)
-	return &Client{Connection: conn, Logger: l, QueueName: queueName, Config: conf}
+
+	hostname, _ := os.Hostname()
+	consumerTag := fmt.Sprintf("%s@%s#%d", queueName, hostname, os.Getpid())
+
+	return &Client{Connection: conn, Logger: l, QueueName: queueName, Config: conf, ConsumerTag: consumerTag}
 }
@@ -3,7 +3,8 @@ package rabbitmq
 import (
 	"context"
 	"fmt"
-	"strconv"
+	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -13,27 +14,124 @@ import (
 	"gitlab.com/slirx/newproj/pkg/logger"
 )
 
+const (
+	// defaultMaxReconnectTimeout is used in case Config.MaxReconnectTimeout is not set.
+	defaultMaxReconnectTimeout = 30 * time.Second
+	// defaultChannelPoolSize is used in case Config.ChannelPoolSize is not set.
+	defaultChannelPoolSize = 10
+)
+
+// connection keeps connection to the broker alive. It reconnects with exponential backoff in case connection is lost
+// and notifies subscribers, so they can restore their consumers.
 type connection struct {
-	//URI                string
-	Connection *amqp.Connection
-	Channel    *amqp.Channel
-	Config     Config
-	//MaxReconnections   int
+	Config Config
+	Logger logger.Logger
+
+	mu                 sync.RWMutex // guards fields below
+	conn               *amqp.Connection
+	channel            *amqp.Channel
+	connected          chan struct{} // closed when connection is established
+	done               chan struct{} // closed when connection is closed or given up
+	err                error         // reason why connection is given up
 	reconnectionsCount int
-	Logger             logger.Logger
+	listeners          []chan struct{}
+
+	pool *ChannelPool
+}
+
+// Connect establishes connection to the broker and keeps it alive until ctx is done or Close is called.
+// It blocks until the first connection is established or reconnection attempts are exhausted.
+func (c *connection) Connect(ctx context.Context) error {
+	if err := c.dial(ctx); err != nil {
+		c.giveUp(err)
+		return err
+	}
+
+	go c.watch(ctx)
+
+	return nil
+}
+
+// Channel returns the channel which is used for consuming messages. It's recreated after reconnection.
+func (c *connection) Channel() *amqp.Channel {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.channel
+}
+
+// Pool returns pool of channels for publishing.
+func (c *connection) Pool() *ChannelPool {
+	return c.pool
+}
+
+// NotifyReconnect returns channel which receives a value every time connection is re-established. The channel is
+// closed when connection is closed or reconnection attempts are exhausted (see Err).
+func (c *connection) NotifyReconnect() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan struct{}, 1)
+
+	select {
+	case <-c.done:
+		close(ch)
+	default:
+		c.listeners = append(c.listeners, ch)
+	}
+
+	return ch
+}
+
+// Err returns the reason why connection is given up. It returns nil in case connection is alive or closed by Close.
+func (c *connection) Err() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.err
+}
+
+// Wait waits until connection is established. It returns an error in case connection isn't established in
+// reconnect timeout.
+func (c *connection) Wait(ctx context.Context) error {
+	c.mu.RLock()
+	connected := c.connected
+	done := c.done
+	c.mu.RUnlock()
+
+	select {
+	case <-connected:
+		return nil
+	case <-done:
+		if err := c.Err(); err != nil {
+			return err
+		}
+
+		return errors.WithStack(amqp.ErrClosed)
+	case <-time.After(c.Config.ReconnectTimeoutSeconds * 2):
+		return errors.WithStack(errors.New("queue is not responding"))
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+	}
 }
 
 func (c *connection) Close() error {
-	var err error
+	c.giveUp(nil)
+	c.pool.reset()
+
+	c.mu.Lock()
+	ch := c.channel
+	conn := c.conn
+	c.mu.Unlock()
 
-	if c.Channel != nil {
-		if err = c.Channel.Close(); err != nil {
+	if ch != nil {
+		if err := ch.Close(); err != nil && err != amqp.ErrClosed {
 			return errors.WithStack(err)
 		}
 	}
 
-	if c.Connection != nil {
-		if err = c.Connection.Close(); err != nil {
+	if conn != nil {
+		if err := conn.Close(); err != nil && err != amqp.ErrClosed {
 			return errors.WithStack(err)
 		}
 	}
@@ -41,36 +139,182 @@ func (c *connection) Close() error {
 	return nil
 }
 
-func (c *connection) Reconnect(ctx context.Context) error {
-	select {
-	case <-ctx.Done():
-		return errors.WithStack(ctx.Err())
-	case <-time.After(c.Config.ReconnectTimeoutSeconds):
-	}
+// dial connects to the broker. In case of failure it retries with exponential backoff until connection is
+// established, ctx is done or maximum reconnections are reached.
+func (c *connection) dial(ctx context.Context) error {
+	for {
+		err := c.open()
+		if err == nil {
+			return nil
+		}
 
-	c.reconnectionsCount++
+		c.mu.Lock()
+		c.reconnectionsCount++
+		count := c.reconnectionsCount
+		c.mu.Unlock()
 
-	if c.reconnectionsCount > c.Config.MaxReconnections {
-		return errors.WithStack(fmt.Errorf("maximum reconnections reached"))
-	}
+		if c.Config.MaxReconnections > 0 && count > c.Config.MaxReconnections {
+			return errors.WithStack(fmt.Errorf("maximum reconnections reached: %w", err))
+		}
 
-	c.Logger.Debug("reconnecting.. try #"+strconv.Itoa(c.reconnectionsCount), apmzap.TraceContext(ctx)...)
+		delay := backoff(c.Config.ReconnectTimeoutSeconds, c.maxReconnectTimeout(), count)
 
-	var err error
-	if c.Connection, err = amqp.Dial(c.Config.URI); err != nil {
-		return c.Reconnect(ctx)
+		c.Logger.Debug(
+			fmt.Sprintf("can not connect: %s; reconnecting in %s.. try #%d", err, delay, count),
+			apmzap.TraceContext(ctx)...,
+		)
+
+		select {
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		case <-c.done:
+			return errors.WithStack(amqp.ErrClosed)
+		case <-time.After(delay):
+		}
 	}
+}
 
-	c.Channel, err = c.Connection.Channel()
+// open opens connection and consumer channel.
+func (c *connection) open() error {
+	conn, err := amqp.Dial(c.Config.URI)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	c.Logger.Debug("reconnected", apmzap.TraceContext(ctx)...)
+	ch, err := conn.Channel()
+	if err != nil {
+		_ = conn.Close()
+		return errors.WithStack(err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.done: // closed while connecting
+		_ = conn.Close()
+		return errors.WithStack(amqp.ErrClosed)
+	default:
+	}
+
+	c.conn = conn
+	c.channel = ch
+	c.reconnectionsCount = 0
+	close(c.connected)
 
 	return nil
 }
 
+// watch reconnects every time connection is lost and notifies listeners.
+func (c *connection) watch(ctx context.Context) {
+	for {
+		c.mu.RLock()
+		closes := c.conn.NotifyClose(make(chan *amqp.Error, 1))
+		c.mu.RUnlock()
+
+		select {
+		case <-ctx.Done():
+			c.giveUp(nil)
+			return
+		case amqpErr, ok := <-closes:
+			if !ok || amqpErr == nil { // closed by Close
+				c.giveUp(nil)
+				return
+			}
+
+			c.Logger.Error(errors.WithStack(fmt.Errorf("connection is lost: %w", amqpErr)))
+
+			c.mu.Lock()
+			c.connected = make(chan struct{})
+			c.mu.Unlock()
+
+			// channels of the lost connection are closed
+			c.pool.reset()
+
+			if err := c.dial(ctx); err != nil {
+				c.giveUp(err)
+				return
+			}
+
+			c.Logger.Debug("reconnected", apmzap.TraceContext(ctx)...)
+			c.notify()
+		}
+	}
+}
+
+func (c *connection) notify() {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, l := range c.listeners {
+		select {
+		case l <- struct{}{}:
+		default: // listener is not handled previous notification yet
+		}
+	}
+}
+
+// giveUp stops notifying listeners. err is returned by Err.
+func (c *connection) giveUp(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+
+	c.err = err
+	close(c.done)
+
+	for _, l := range c.listeners {
+		close(l)
+	}
+
+	c.listeners = nil
+}
+
+func (c *connection) maxReconnectTimeout() time.Duration {
+	if c.Config.MaxReconnectTimeout > 0 {
+		return c.Config.MaxReconnectTimeout
+	}
+
+	return defaultMaxReconnectTimeout
+}
+
+// backoff returns delay before attempt. Delay grows exponentially from base to max. Random jitter is added, so
+// services don't reconnect at the same moment after broker restart.
+func backoff(base time.Duration, max time.Duration, attempt int) time.Duration {
+	if base <= 0 {
+		base = time.Second
+	}
+
+	delay := max
+	if attempt < 32 && base<<uint(attempt-1) > 0 && base<<uint(attempt-1) < max {
+		delay = base << uint(attempt-1)
+	}
+
+	// equal jitter: half of the delay is fixed, another half is random
+	half := delay / 2
+
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
 func NewConnection(conf Config, l logger.Logger) *connection {
-	return &connection{Config: conf, Logger: l}
+	c := &connection{
+		Config:    conf,
+		Logger:    l,
+		connected: make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	poolSize := conf.ChannelPoolSize
+	if poolSize <= 0 {
+		poolSize = defaultChannelPoolSize
+	}
+
+	c.pool = newChannelPool(c, poolSize)
+
+	return c
 }
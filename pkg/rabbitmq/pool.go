@@ -0,0 +1,208 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+)
+
+var (
+	// ErrUnroutable is returned when the broker can not route the message to any queue.
+	ErrUnroutable = errors.New("message is unroutable")
+	// ErrNacked is returned when the broker refuses to take responsibility for the message.
+	ErrNacked = errors.New("message is not acknowledged by the broker")
+	// ErrConfirmTimeout is returned when the broker doesn't confirm the message in time.
+	ErrConfirmTimeout = errors.New("message confirmation is timed out")
+	// ErrChannelClosed is returned when the channel is closed before the message is confirmed.
+	ErrChannelClosed = errors.New("channel is closed before message confirmation")
+)
+
+// PublishChannel is a channel in confirm mode. It's used by one publisher at a time, so every published message is
+// confirmed before the next one is published.
+type PublishChannel struct {
+	Channel    *amqp.Channel
+	generation int
+	confirms   chan amqp.Confirmation
+	returns    chan amqp.Return
+	broken     bool // channel is in unknown state and should not be reused
+}
+
+// Publish publishes msg and waits for the broker confirmation. In case mandatory is true and the message can't be
+// routed to any queue, ErrUnroutable is returned.
+func (c *PublishChannel) Publish(
+	ctx context.Context,
+	exchange string,
+	routingKey string,
+	mandatory bool,
+	msg amqp.Publishing,
+	timeout time.Duration,
+) error {
+	if err := c.Channel.Publish(exchange, routingKey, mandatory, false, msg); err != nil {
+		c.broken = true
+		return errors.WithStack(err)
+	}
+
+	select {
+	case confirmation, ok := <-c.confirms:
+		if !ok {
+			c.broken = true
+			return errors.WithStack(ErrChannelClosed)
+		}
+
+		if !confirmation.Ack {
+			return errors.WithStack(ErrNacked)
+		}
+
+		// the broker sends returned message before the confirmation, so it's already in the buffer
+		select {
+		case r := <-c.returns:
+			return errors.WithStack(
+				fmt.Errorf("%w: %s (%d %s)", ErrUnroutable, r.RoutingKey, r.ReplyCode, r.ReplyText),
+			)
+		default:
+		}
+
+		return nil
+	case <-time.After(timeout):
+		c.broken = true
+		return errors.WithStack(fmt.Errorf("%w: %s", ErrConfirmTimeout, routingKey))
+	case <-ctx.Done():
+		c.broken = true
+		return errors.WithStack(ctx.Err())
+	}
+}
+
+// ExchangeDeclare declares durable exchange.
+func (c *PublishChannel) ExchangeDeclare(name string, kind string) error {
+	err := c.Channel.ExchangeDeclare(
+		name,  // name
+		kind,  // type
+		true,  // durable
+		false, // auto-deleted
+		false, // internal
+		false, // no-wait
+		nil,   // arguments
+	)
+	if err != nil {
+		// the broker closes the channel on error
+		c.broken = true
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// ChannelPool is a pool of publish channels. It's safe for concurrent use.
+type ChannelPool struct {
+	conn   *connection
+	tokens chan struct{} // limits number of open channels
+
+	mu         sync.Mutex
+	idle       []*PublishChannel
+	generation int // incremented when connection is lost, so channels of the lost connection are not reused
+}
+
+// Get returns idle channel or opens a new one. It waits in case all channels are in use.
+// The channel should be returned to the pool with Put.
+func (p *ChannelPool) Get(ctx context.Context) (*PublishChannel, error) {
+	if err := p.conn.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	select {
+	case p.tokens <- struct{}{}:
+	case <-time.After(p.conn.Config.ReconnectTimeoutSeconds * 2):
+		return nil, errors.WithStack(errors.New("there are no free channels"))
+	case <-ctx.Done():
+		return nil, errors.WithStack(ctx.Err())
+	}
+
+	p.mu.Lock()
+	if n := len(p.idle); n > 0 {
+		ch := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+
+		return ch, nil
+	}
+
+	generation := p.generation
+	p.mu.Unlock()
+
+	ch, err := p.open(generation)
+	if err != nil {
+		<-p.tokens
+		return nil, err
+	}
+
+	return ch, nil
+}
+
+// Put returns the channel to the pool. Broken channels and channels of the lost connection are closed.
+func (p *ChannelPool) Put(ch *PublishChannel) {
+	defer func() {
+		<-p.tokens
+	}()
+
+	p.mu.Lock()
+	if !ch.broken && ch.generation == p.generation {
+		p.idle = append(p.idle, ch)
+		p.mu.Unlock()
+
+		return
+	}
+	p.mu.Unlock()
+
+	// closing may wait for the broker, publisher shouldn't wait for it
+	go func() {
+		_ = ch.Channel.Close()
+	}()
+}
+
+func (p *ChannelPool) open(generation int) (*PublishChannel, error) {
+	p.conn.mu.RLock()
+	conn := p.conn.conn
+	p.conn.mu.RUnlock()
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if err = ch.Confirm(false); err != nil {
+		_ = ch.Close()
+		return nil, errors.WithStack(fmt.Errorf("can not put channel into confirm mode: %w", err))
+	}
+
+	// there is only one message in flight, so one returned message and one confirmation can be buffered
+	return &PublishChannel{
+		Channel:    ch,
+		generation: generation,
+		confirms:   ch.NotifyPublish(make(chan amqp.Confirmation, 1)),
+		returns:    ch.NotifyReturn(make(chan amqp.Return, 1)),
+	}, nil
+}
+
+// reset forgets idle channels. It's called when connection is lost or closed.
+func (p *ChannelPool) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, ch := range p.idle {
+		_ = ch.Channel.Close()
+	}
+
+	p.idle = nil
+	p.generation++
+}
+
+func newChannelPool(conn *connection, size int) *ChannelPool {
+	return &ChannelPool{
+		conn:   conn,
+		tokens: make(chan struct{}, size),
+	}
+}
@@ -0,0 +1,89 @@
+// rbac package defines roles of staff and permissions granted to them. Role is embedded in access tokens of admin
+// panel, permissions of the role are checked by jwtmiddleware for every route of admin panel.
+package rbac
+
+// Role is role of the auth. Users have no role in their tokens, only staff of admin panel has.
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleAdmin     Role = "admin"
+	RoleModerator Role = "moderator"
+	RoleSupport   Role = "support"
+)
+
+// Permission allows an action in admin panel.
+type Permission string
+
+const (
+	// PermissionUsersRead allows viewing accounts of users.
+	PermissionUsersRead Permission = "users:read"
+	// PermissionUsersWrite allows changing accounts of users, for example revoking their sessions.
+	PermissionUsersWrite Permission = "users:write"
+	// PermissionPostsModerate allows hiding and deleting posts of other users.
+	PermissionPostsModerate Permission = "posts:moderate"
+	// PermissionRolesManage allows granting roles to other users.
+	PermissionRolesManage Permission = "roles:manage"
+)
+
+// permissions are permissions of staff roles. Admin has all of them.
+var permissions = map[Role][]Permission{
+	RoleAdmin: {
+		PermissionUsersRead,
+		PermissionUsersWrite,
+		PermissionPostsModerate,
+		PermissionRolesManage,
+	},
+	RoleModerator: {
+		PermissionUsersRead,
+		PermissionPostsModerate,
+	},
+	RoleSupport: {
+		PermissionUsersRead,
+		PermissionUsersWrite,
+	},
+}
+
+// IsStaff reports whether role gives access to admin panel.
+func (r Role) IsStaff() bool {
+	_, ok := permissions[r]
+
+	return ok
+}
+
+// Valid reports whether role is known, either user or one of staff roles.
+func (r Role) Valid() bool {
+	return r == RoleUser || r.IsStaff()
+}
+
+// Permissions returns permissions of role. Nil is returned for users and unknown roles.
+func (r Role) Permissions() []Permission {
+	return append([]Permission(nil), permissions[r]...)
+}
+
+// Has reports whether role has all permissions. Only staff roles have permissions, so false is returned for users
+// even in case required is empty.
+func (r Role) Has(required ...Permission) bool {
+	granted, ok := permissions[r]
+	if !ok {
+		return false
+	}
+
+	for _, p := range required {
+		if !contains(granted, p) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func contains(list []Permission, p Permission) bool {
+	for _, item := range list {
+		if item == p {
+			return true
+		}
+	}
+
+	return false
+}
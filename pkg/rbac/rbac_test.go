@@ -0,0 +1,46 @@
+package rbac
+
+import (
+	"testing"
+)
+
+func TestRoleHas(t *testing.T) {
+	cases := []struct {
+		role     Role
+		required []Permission
+		want     bool
+	}{
+		{role: RoleAdmin, required: []Permission{PermissionRolesManage, PermissionPostsModerate}, want: true},
+		{role: RoleModerator, required: []Permission{PermissionPostsModerate}, want: true},
+		{role: RoleModerator, required: []Permission{PermissionPostsModerate, PermissionUsersWrite}, want: false},
+		{role: RoleSupport, required: []Permission{PermissionUsersWrite}, want: true},
+		{role: RoleSupport, required: []Permission{PermissionRolesManage}, want: false},
+		{role: RoleSupport, required: nil, want: true},
+		{role: RoleUser, required: nil, want: false},
+		{role: RoleUser, required: []Permission{PermissionUsersRead}, want: false},
+		{role: "root", required: nil, want: false},
+	}
+
+	for _, c := range cases {
+		if got := c.role.Has(c.required...); got != c.want {
+			t.Fatalf("%s %v: got: %t, want: %t", c.role, c.required, got, c.want)
+		}
+	}
+}
+
+func TestRoleValid(t *testing.T) {
+	cases := map[Role]bool{
+		RoleUser:      true,
+		RoleAdmin:     true,
+		RoleModerator: true,
+		RoleSupport:   true,
+		"":            false,
+		"root":        false,
+	}
+
+	for role, want := range cases {
+		if got := role.Valid(); got != want {
+			t.Fatalf("%q: got: %t, want: %t", role, got, want)
+		}
+	}
+}
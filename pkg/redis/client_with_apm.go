@@ -114,6 +114,46 @@ func (c clientWithAPM) HIncrBy(ctx context.Context, key string, field string, in
 	return response, nil
 }
 
+func (c clientWithAPM) Incr(ctx context.Context, key string, expiration time.Duration) (int64, error) {
+	tx := apm.TransactionFromContext(ctx)
+	span := tx.StartSpan("redis.Incr", "redis", nil)
+	defer span.End()
+
+	span.Action = "Incr"
+	span.Outcome = "success"
+	span.Context.SetDatabase(apm.DatabaseSpanContext{
+		Statement: fmt.Sprintf("key: %s; expiration: %v", key, expiration),
+	})
+
+	response, err := c.Client.Incr(ctx, key, expiration)
+	if err != nil {
+		span.Outcome = "error"
+		return response, err
+	}
+
+	return response, nil
+}
+
+func (c clientWithAPM) Del(ctx context.Context, keys ...string) error {
+	tx := apm.TransactionFromContext(ctx)
+	span := tx.StartSpan("redis.Del", "redis", nil)
+	defer span.End()
+
+	span.Action = "Del"
+	span.Outcome = "success"
+	span.Context.SetDatabase(apm.DatabaseSpanContext{
+		Statement: fmt.Sprintf("keys: %v", keys),
+	})
+
+	err := c.Client.Del(ctx, keys...)
+	if err != nil {
+		span.Outcome = "error"
+		return err
+	}
+
+	return nil
+}
+
 func (c clientWithAPM) Close() error {
 	return c.Client.Close()
 }
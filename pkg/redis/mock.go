@@ -11,6 +11,8 @@ type Mock struct {
 	SetIntSliceFn func(ctx context.Context, key string, value []int, expiration time.Duration) error
 	GetIntSliceFn func(ctx context.Context, key string) ([]int, error)
 	HIncrByFn     func(ctx context.Context, key string, field string, incr int64) (int64, error)
+	IncrFn        func(ctx context.Context, key string, expiration time.Duration) (int64, error)
+	DelFn         func(ctx context.Context, keys ...string) error
 	CloseFn       func() error
 }
 
@@ -34,6 +36,14 @@ func (m Mock) HIncrBy(ctx context.Context, key string, field string, incr int64)
 	return m.HIncrByFn(ctx, key, field, incr)
 }
 
+func (m Mock) Incr(ctx context.Context, key string, expiration time.Duration) (int64, error) {
+	return m.IncrFn(ctx, key, expiration)
+}
+
+func (m Mock) Del(ctx context.Context, keys ...string) error {
+	return m.DelFn(ctx, keys...)
+}
+
 func (m Mock) Close() error {
 	return m.CloseFn()
 }
@@ -26,6 +26,10 @@ type Client interface {
 	SetIntSlice(ctx context.Context, key string, value []int, expiration time.Duration) error
 	GetIntSlice(ctx context.Context, key string) ([]int, error)
 	HIncrBy(ctx context.Context, key string, field string, incr int64) (int64, error)
+	// Incr increments counter stored at key and sets its expiration. It returns the value after increment.
+	Incr(ctx context.Context, key string, expiration time.Duration) (int64, error)
+	// Del deletes keys. Keys which don't exist are ignored.
+	Del(ctx context.Context, keys ...string) error
 	Close() error
 }
 
@@ -98,6 +102,30 @@ func (c client) HIncrBy(ctx context.Context, key string, field string, incr int6
 	return v, nil
 }
 
+func (c client) Incr(ctx context.Context, key string, expiration time.Duration) (int64, error) {
+	var incr *redis.IntCmd
+
+	_, err := c.RedisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, key)
+		pipe.Expire(ctx, key, expiration)
+
+		return nil
+	})
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return incr.Val(), nil
+}
+
+func (c client) Del(ctx context.Context, keys ...string) error {
+	if err := c.RedisClient.Del(ctx, keys...).Err(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
 func (c client) Close() error {
 	err := c.RedisClient.Close()
 	if err != nil {
@@ -0,0 +1,36 @@
+package revocation
+
+import (
+	"context"
+	"time"
+)
+
+var _ Store = (*Mock)(nil)
+
+type Mock struct {
+	RevokeUserFn    func(ctx context.Context, uid int, at time.Time) error
+	IsRevokedFn     func(ctx context.Context, uid int, issuedAt time.Time) (bool, error)
+	RevokeSessionFn func(ctx context.Context, sessionID string) error
+	CheckSessionFn  func(ctx context.Context, sessionID string, at time.Time) (bool, error)
+	LastSeenFn      func(ctx context.Context, sessionID string) (time.Time, error)
+}
+
+func (m Mock) RevokeUser(ctx context.Context, uid int, at time.Time) error {
+	return m.RevokeUserFn(ctx, uid, at)
+}
+
+func (m Mock) IsRevoked(ctx context.Context, uid int, issuedAt time.Time) (bool, error) {
+	return m.IsRevokedFn(ctx, uid, issuedAt)
+}
+
+func (m Mock) RevokeSession(ctx context.Context, sessionID string) error {
+	return m.RevokeSessionFn(ctx, sessionID)
+}
+
+func (m Mock) CheckSession(ctx context.Context, sessionID string, at time.Time) (bool, error) {
+	return m.CheckSessionFn(ctx, sessionID, at)
+}
+
+func (m Mock) LastSeen(ctx context.Context, sessionID string) (time.Time, error) {
+	return m.LastSeenFn(ctx, sessionID)
+}
@@ -0,0 +1,116 @@
+// revocation package contains store of revoked JWTs. Tokens are stateless, so they can't be deleted. Instead, moment
+// of revocation is saved for the user and all tokens issued before it are rejected by jwtmiddleware. Single session
+// is revoked by "jti" claim of its token.
+package revocation
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"gitlab.com/slirx/newproj/pkg/redis"
+)
+
+// Store keeps moments of revocation of users' tokens. It's shared by auth service which revokes tokens
+// and services which check them.
+type Store interface {
+	// RevokeUser revokes all tokens of the user issued before at or during the same second.
+	RevokeUser(ctx context.Context, uid int, at time.Time) error
+	// IsRevoked reports whether token of the user issued at issuedAt is revoked.
+	IsRevoked(ctx context.Context, uid int, issuedAt time.Time) (bool, error)
+	// RevokeSession revokes token with "jti" claim equal to sessionID.
+	RevokeSession(ctx context.Context, sessionID string) error
+	// CheckSession reports whether the session is revoked. Otherwise, at is saved as the last activity of the
+	// session.
+	CheckSession(ctx context.Context, sessionID string, at time.Time) (bool, error)
+	// LastSeen returns time of the last activity of the session. Zero time is returned in case the session
+	// wasn't used since it had been created.
+	LastSeen(ctx context.Context, sessionID string) (time.Time, error)
+}
+
+type redisStore struct {
+	Redis redis.Client
+	// TTL is the maximum lifetime of tokens. Moment of revocation is kept for this time, because all tokens issued
+	// before it are expired after that.
+	TTL time.Duration
+}
+
+func (s redisStore) RevokeUser(ctx context.Context, uid int, at time.Time) error {
+	return s.Redis.Set(ctx, key(uid), at.Unix(), s.TTL)
+}
+
+// IsRevoked compares time with precision of seconds, like "iat" claim has. So tokens issued during the same second
+// as the revocation are revoked too, because they could be issued before it. Token which should stay valid has to be
+// issued during the next second.
+func (s redisStore) IsRevoked(ctx context.Context, uid int, issuedAt time.Time) (bool, error) {
+	value, err := s.Redis.Get(ctx, key(uid))
+	if err != nil {
+		if errors.Is(err, redis.ErrNoData) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	revokedAt, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return issuedAt.Unix() <= revokedAt, nil
+}
+
+func (s redisStore) RevokeSession(ctx context.Context, sessionID string) error {
+	return s.Redis.Set(ctx, sessionKey(sessionID), 1, s.TTL)
+}
+
+func (s redisStore) CheckSession(ctx context.Context, sessionID string, at time.Time) (bool, error) {
+	_, err := s.Redis.Get(ctx, sessionKey(sessionID))
+	if err == nil {
+		return true, nil
+	}
+
+	if !errors.Is(err, redis.ErrNoData) {
+		return false, err
+	}
+
+	return false, s.Redis.Set(ctx, lastSeenKey(sessionID), at.Unix(), s.TTL)
+}
+
+func (s redisStore) LastSeen(ctx context.Context, sessionID string) (time.Time, error) {
+	value, err := s.Redis.Get(ctx, lastSeenKey(sessionID))
+	if err != nil {
+		if errors.Is(err, redis.ErrNoData) {
+			return time.Time{}, nil
+		}
+
+		return time.Time{}, err
+	}
+
+	seenAt, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, errors.WithStack(err)
+	}
+
+	return time.Unix(seenAt, 0), nil
+}
+
+func key(uid int) string {
+	return fmt.Sprintf("auth:revoked:user:%d", uid)
+}
+
+func sessionKey(sessionID string) string {
+	return "auth:revoked:session:" + sessionID
+}
+
+func lastSeenKey(sessionID string) string {
+	return "auth:session:seen:" + sessionID
+}
+
+// NewStore returns Store which keeps data in redis. ttl should be equal to the maximum lifetime of tokens.
+func NewStore(r redis.Client, ttl time.Duration) Store {
+	return redisStore{Redis: r, TTL: ttl}
+}
@@ -0,0 +1,123 @@
+package revocation
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"gitlab.com/slirx/newproj/pkg/redis"
+)
+
+func TestStore(t *testing.T) {
+	data := make(map[string]string)
+
+	r := redis.Mock{
+		SetFn: func(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+			if expiration != time.Hour {
+				t.Fatalf("got: %s, want: 1h", expiration)
+			}
+
+			data[key] = strconv.FormatInt(value.(int64), 10)
+
+			return nil
+		},
+		GetFn: func(ctx context.Context, key string) (string, error) {
+			value, ok := data[key]
+			if !ok {
+				return "", redis.ErrNoData
+			}
+
+			return value, nil
+		},
+	}
+
+	s := NewStore(r, time.Hour)
+	ctx := context.Background()
+	revokedAt := time.Date(2021, 5, 10, 8, 0, 0, 0, time.UTC)
+
+	if err := s.RevokeUser(ctx, 1, revokedAt); err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	cases := []struct {
+		uid      int
+		issuedAt time.Time
+		want     bool
+	}{
+		{uid: 1, issuedAt: revokedAt.Add(-time.Second), want: true},
+		{uid: 1, issuedAt: revokedAt, want: true},
+		{uid: 1, issuedAt: revokedAt.Add(999 * time.Millisecond), want: true},
+		{uid: 1, issuedAt: revokedAt.Add(time.Second), want: false},
+		{uid: 1, issuedAt: revokedAt.Add(time.Minute), want: false},
+		{uid: 2, issuedAt: revokedAt.Add(-time.Hour), want: false},
+	}
+
+	for _, c := range cases {
+		got, err := s.IsRevoked(ctx, c.uid, c.issuedAt)
+		if err != nil {
+			t.Fatalf("got: %s, want: nil", err)
+		}
+
+		if got != c.want {
+			t.Fatalf("%d/%s: got: %t, want: %t", c.uid, c.issuedAt, got, c.want)
+		}
+	}
+}
+
+func TestStoreSession(t *testing.T) {
+	data := make(map[string]string)
+
+	r := redis.Mock{
+		SetFn: func(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+			data[key] = fmt.Sprint(value)
+
+			return nil
+		},
+		GetFn: func(ctx context.Context, key string) (string, error) {
+			value, ok := data[key]
+			if !ok {
+				return "", redis.ErrNoData
+			}
+
+			return value, nil
+		},
+	}
+
+	s := NewStore(r, time.Hour)
+	ctx := context.Background()
+	seenAt := time.Date(2021, 5, 10, 8, 0, 0, 0, time.UTC)
+
+	got, err := s.LastSeen(ctx, "s1")
+	if err != nil || !got.IsZero() {
+		t.Fatalf("got: %s, %v, want: zero time, nil", got, err)
+	}
+
+	revoked, err := s.CheckSession(ctx, "s1", seenAt)
+	if err != nil || revoked {
+		t.Fatalf("got: %t, %v, want: false, nil", revoked, err)
+	}
+
+	got, err = s.LastSeen(ctx, "s1")
+	if err != nil || !got.Equal(seenAt) {
+		t.Fatalf("got: %s, %v, want: %s, nil", got, err, seenAt)
+	}
+
+	if err = s.RevokeSession(ctx, "s1"); err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	if revoked, err = s.CheckSession(ctx, "s1", seenAt.Add(time.Minute)); err != nil || !revoked {
+		t.Fatalf("got: %t, %v, want: true, nil", revoked, err)
+	}
+
+	// activity of revoked session is not saved
+	if got, _ = s.LastSeen(ctx, "s1"); !got.Equal(seenAt) {
+		t.Fatalf("got: %s, want: %s", got, seenAt)
+	}
+
+	if revoked, err = s.CheckSession(ctx, "s2", seenAt); err != nil || revoked {
+		t.Fatalf("got: %t, %v, want: false, nil", revoked, err)
+	}
+}
@@ -0,0 +1,75 @@
+package template
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Catalog contains localized messages, for example subjects of emails.
+type Catalog interface {
+	// Message returns message with key in the locale. Message is formatted with args in case they are passed.
+	// The same fallback as for templates is used, key itself is returned in case message doesn't exist at all.
+	Message(locale string, key string, args ...interface{}) string
+}
+
+type catalog struct {
+	messages map[string]map[string]string // locale => key => message
+}
+
+func (c catalog) Message(locale string, key string, args ...interface{}) string {
+	for _, l := range fallbacks(NormalizeLocale(locale)) {
+		msg, ok := c.messages[l][key]
+		if !ok {
+			continue
+		}
+
+		if len(args) > 0 {
+			return fmt.Sprintf(msg, args...)
+		}
+
+		return msg
+	}
+
+	return key
+}
+
+// NewCatalog loads messages from dir. Every file of dir is a JSON object with messages of one locale, which is the
+// name of the file, for example "de.json". Messages of DefaultLocale are required.
+func NewCatalog(dir string) (Catalog, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	c := catalog{messages: make(map[string]map[string]string)}
+
+	for _, file := range files {
+		locale := NormalizeLocale(strings.TrimSuffix(filepath.Base(file), ".json"))
+		if locale == "" {
+			return nil, errors.WithStack(fmt.Errorf("invalid locale of message file %s", file))
+		}
+
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		messages := make(map[string]string)
+		if err = json.Unmarshal(content, &messages); err != nil {
+			return nil, errors.WithStack(fmt.Errorf("invalid message file %s: %w", file, err))
+		}
+
+		c.messages[locale] = messages
+	}
+
+	if _, ok := c.messages[DefaultLocale]; !ok {
+		return nil, errors.WithStack(fmt.Errorf("messages of default locale %q are not found in %s", DefaultLocale, dir))
+	}
+
+	return c, nil
+}
@@ -1,9 +1,17 @@
 package template
 
 type Mock struct {
-	GenerateFn func(t GeneratorType, fileName string, data interface{}) (string, error)
+	GenerateFn func(t GeneratorType, fileName string, locale string, data interface{}) (string, error)
 }
 
-func (m Mock) Generate(t GeneratorType, fileName string, data interface{}) (string, error) {
-	return m.GenerateFn(t, fileName, data)
+func (m Mock) Generate(t GeneratorType, fileName string, locale string, data interface{}) (string, error) {
+	return m.GenerateFn(t, fileName, locale, data)
+}
+
+type CatalogMock struct {
+	MessageFn func(locale string, key string, args ...interface{}) string
+}
+
+func (m CatalogMock) Message(locale string, key string, args ...interface{}) string {
+	return m.MessageFn(locale, key, args...)
 }
@@ -3,8 +3,12 @@ package template
 import (
 	"fmt"
 	html "html/template"
+	"io"
+	"os"
 	"path"
+	"strconv"
 	"strings"
+	"sync"
 	text "text/template"
 
 	"github.com/pkg/errors"
@@ -17,44 +21,189 @@ const (
 	TypeText
 )
 
+// DefaultLocale is the locale of templates and messages without locale suffix.
+const DefaultLocale = "en"
+
 type Generator interface {
-	Generate(t GeneratorType, fileName string, data interface{}) (string, error)
+	// Generate executes template fileName with data. Localized version of the template is used in case it exists,
+	// for example "confirmation.de.html" for "confirmation.html" and locale "de" or "de-AT". Template without
+	// locale suffix is used as a fallback.
+	Generate(t GeneratorType, fileName string, locale string, data interface{}) (string, error)
+}
+
+type executor interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// cacheKey contains resolved file of the template instead of the requested locale, so all the locales without
+// localized version share the cache entry of the fallback.
+type cacheKey struct {
+	gType    GeneratorType
+	fileName string
 }
 
+// generator parses every template once, so files are parsed only on the first call. Changes of templates require
+// restart of the service.
 type generator struct {
+	mu    sync.RWMutex
+	cache map[cacheKey]executor
 }
 
-func (g generator) Generate(gType GeneratorType, fileName string, data interface{}) (string, error) {
+func (g *generator) Generate(gType GeneratorType, fileName string, locale string, data interface{}) (string, error) {
+	t, err := g.template(gType, fileName, NormalizeLocale(locale))
+	if err != nil {
+		return "", err
+	}
+
 	var builder strings.Builder
 
+	if err = t.Execute(&builder, data); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return builder.String(), nil
+}
+
+func (g *generator) template(gType GeneratorType, fileName string, locale string) (executor, error) {
+	key := cacheKey{gType: gType, fileName: lookup(fileName, locale)}
+
+	g.mu.RLock()
+	t, ok := g.cache[key]
+	g.mu.RUnlock()
+
+	if ok {
+		return t, nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if t, ok = g.cache[key]; ok {
+		return t, nil
+	}
+
+	t, err := parse(gType, key.fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	g.cache[key] = t
+
+	return t, nil
+}
+
+func parse(gType GeneratorType, fileName string) (executor, error) {
 	switch gType {
 	case TypeHTML:
 		t, err := html.New(path.Base(fileName)).ParseFiles(fileName)
 		if err != nil {
-			return "", errors.WithStack(err)
+			return nil, errors.WithStack(err)
 		}
 
-		if err = t.Execute(&builder, data); err != nil {
-			return "", errors.WithStack(err)
-		}
-
-		return builder.String(), nil
+		return t, nil
 	case TypeText:
 		t, err := text.New(path.Base(fileName)).ParseFiles(fileName)
 		if err != nil {
-			return "", errors.WithStack(err)
+			return nil, errors.WithStack(err)
+		}
+
+		return t, nil
+	}
+
+	return nil, errors.WithStack(fmt.Errorf("invalid type: %d", gType))
+}
+
+// lookup returns the most specific existing localized version of fileName. fileName itself is returned in case
+// there is no localized version.
+func lookup(fileName string, locale string) string {
+	ext := path.Ext(fileName)
+	base := strings.TrimSuffix(fileName, ext)
+
+	for _, l := range fallbacks(locale) {
+		if l == DefaultLocale {
+			break
+		}
+
+		localized := base + "." + l + ext
+		if _, err := os.Stat(localized); err == nil {
+			return localized
+		}
+	}
+
+	return fileName
+}
+
+// fallbacks returns locale and its parents, for example "de-at", "de" and DefaultLocale for "de-at".
+func fallbacks(locale string) []string {
+	locales := make([]string, 0, 3)
+
+	for locale != "" {
+		locales = append(locales, locale)
+
+		i := strings.LastIndex(locale, "-")
+		if i < 0 {
+			break
+		}
+
+		locale = locale[:i]
+	}
+
+	return append(locales, DefaultLocale)
+}
+
+// NormalizeLocale converts locale to lower case BCP 47 form, for example "de_AT" to "de-at". Invalid locale
+// is converted to empty string.
+func NormalizeLocale(locale string) string {
+	locale = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+
+	if locale == "" || len(locale) > 16 {
+		return ""
+	}
+
+	for _, r := range locale {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+			return ""
+		}
+	}
+
+	return locale
+}
+
+// AcceptLanguage returns locale with the highest weight from Accept-Language header, for example "de-at" for
+// "de-AT,de;q=0.9,en;q=0.8". Empty string is returned in case header has no valid locales.
+func AcceptLanguage(header string) string {
+	locale := ""
+	weight := 0.0
+
+	for _, part := range strings.Split(header, ",") {
+		tag := part
+		w := 1.0
+
+		if i := strings.Index(part, ";"); i >= 0 {
+			tag = part[:i]
+
+			q := strings.TrimSpace(part[i+1:])
+			if !strings.HasPrefix(q, "q=") {
+				continue
+			}
+
+			var err error
+			if w, err = strconv.ParseFloat(q[2:], 64); err != nil {
+				continue
+			}
 		}
 
-		if err = t.Execute(&builder, data); err != nil {
-			return "", errors.WithStack(err)
+		tag = NormalizeLocale(tag)
+		if tag == "" || w <= weight {
+			continue
 		}
 
-		return builder.String(), nil
+		locale, weight = tag, w
 	}
 
-	return "", errors.WithStack(fmt.Errorf("invalid type: %d", gType))
+	return locale
 }
 
 func New() Generator {
-	return generator{}
+	return &generator{cache: make(map[cacheKey]executor)}
 }
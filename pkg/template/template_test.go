@@ -0,0 +1,119 @@
+package template
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, name string, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGeneratorGenerate(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "confirmation.txt")
+
+	writeFile(t, fileName, "code: {{.}}")
+	writeFile(t, filepath.Join(dir, "confirmation.de.txt"), "Code: {{.}}")
+
+	g := New()
+
+	cases := []struct {
+		locale string
+		want   string
+	}{
+		{locale: "", want: "code: 1"},
+		{locale: "en", want: "code: 1"},
+		{locale: "de", want: "Code: 1"},
+		{locale: "de_AT", want: "Code: 1"},
+		{locale: "fr", want: "code: 1"},
+		{locale: "../de", want: "code: 1"},
+	}
+
+	for _, c := range cases {
+		got, err := g.Generate(TypeText, fileName, c.locale, 1)
+		if err != nil {
+			t.Fatalf("%s: got: %s, want: nil", c.locale, err.Error())
+		}
+
+		if got != c.want {
+			t.Fatalf("%s: got: %s, want: %s", c.locale, got, c.want)
+		}
+	}
+
+	// templates are parsed once, so changes of files are not visible
+	writeFile(t, fileName, "changed")
+
+	got, err := g.Generate(TypeText, fileName, "", 1)
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	if got != "code: 1" {
+		t.Fatalf("got: %s, want: code: 1", got)
+	}
+
+	// locales without localized version share the fallback
+	if n := len(g.(*generator).cache); n != 2 {
+		t.Fatalf("got: %d, want: 2", n)
+	}
+
+	if _, err = g.Generate(TypeHTML, filepath.Join(dir, "missing.html"), "de", 1); err == nil {
+		t.Fatalf("got: nil, want: error")
+	}
+}
+
+func TestCatalogMessage(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFile(t, filepath.Join(dir, "en.json"), `{"subject": "Hello, %s", "only.en": "English"}`)
+	writeFile(t, filepath.Join(dir, "de.json"), `{"subject": "Hallo, %s"}`)
+
+	catalog, err := NewCatalog(dir)
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	cases := []struct {
+		locale string
+		key    string
+		args   []interface{}
+		want   string
+	}{
+		{locale: "de-AT", key: "subject", args: []interface{}{"john"}, want: "Hallo, john"},
+		{locale: "fr", key: "subject", args: []interface{}{"john"}, want: "Hello, john"},
+		{locale: "de", key: "only.en", want: "English"},
+		{locale: "de", key: "missing", want: "missing"},
+	}
+
+	for _, c := range cases {
+		if got := catalog.Message(c.locale, c.key, c.args...); got != c.want {
+			t.Fatalf("%s/%s: got: %s, want: %s", c.locale, c.key, got, c.want)
+		}
+	}
+
+	if _, err = NewCatalog(t.TempDir()); err == nil {
+		t.Fatalf("got: nil, want: error about missing default locale")
+	}
+}
+
+func TestAcceptLanguage(t *testing.T) {
+	cases := map[string]string{
+		"":                          "",
+		"de":                        "de",
+		"de-AT,de;q=0.9,en;q=0.8":   "de-at",
+		"en;q=0.5, fr;q=0.7":        "fr",
+		"*;q=1, <script>, en;q=0.1": "en",
+	}
+
+	for header, want := range cases {
+		if got := AcceptLanguage(header); got != want {
+			t.Fatalf("%q: got: %q, want: %q", header, got, want)
+		}
+	}
+}
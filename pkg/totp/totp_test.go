@@ -0,0 +1,70 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// secret of test vectors of RFC 6238 for SHA1
+var testSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	// the last 6 digits of 8-digit codes of RFC 6238
+	cases := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+
+	for unix, want := range cases {
+		got, err := Code(testSecret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatalf("got: %s, want: nil", err.Error())
+		}
+
+		if got != want {
+			t.Fatalf("%d: got: %s, want: %s", unix, got, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+
+	previous, _ := Code(testSecret, now.Add(-Period))
+	if step, ok := Validate(testSecret, previous, now); !ok || step != Step(now)-1 {
+		t.Fatalf("got: %d/%v, want: %d/true", step, ok, Step(now)-1)
+	}
+
+	old, _ := Code(testSecret, now.Add(-2*Period))
+	if _, ok := Validate(testSecret, old, now); ok {
+		t.Fatalf("got: true, want: false")
+	}
+
+	for _, c := range []string{"", "12345", "1234567", "abcdef"} {
+		if _, ok := Validate(testSecret, c, now); ok {
+			t.Fatalf("%q: got: true, want: false", c)
+		}
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err.Error())
+	}
+
+	if len(secret) != 32 || strings.Contains(secret, "=") {
+		t.Fatalf("got: %s, want: 32 base32 symbols without padding", secret)
+	}
+
+	want := "otpauth://totp/MicroBlog:john?algorithm=SHA1&digits=6&issuer=MicroBlog&period=30&secret=" + secret
+	if got := URI("MicroBlog", "john", secret); got != want {
+		t.Fatalf("got: %s, want: %s", got, want)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 
 	"go.elastic.co/apm"
+	"go.elastic.co/apm/module/apmhttp"
 )
 
 // Tracer defines methods for performance monitoring, metrics.
@@ -25,3 +26,38 @@ func (t apmTracer) RequestID(ctx context.Context) string {
 func NewAPMTracer() Tracer {
 	return apmTracer{}
 }
+
+// TraceContext returns trace context of the current span or transaction of ctx. It's propagated to services which
+// are called while ctx is handled, so their transactions are parts of the same trace. ok is false in case ctx
+// isn't traced.
+func TraceContext(ctx context.Context) (apm.TraceContext, bool) {
+	if span := apm.SpanFromContext(ctx); span != nil {
+		return span.TraceContext(), true
+	}
+
+	if tx := apm.TransactionFromContext(ctx); tx != nil {
+		return tx.TraceContext(), true
+	}
+
+	return apm.TraceContext{}, false
+}
+
+// FormatTraceparent returns values of W3C traceparent and tracestate headers for traceContext.
+func FormatTraceparent(traceContext apm.TraceContext) (string, string) {
+	return apmhttp.FormatTraceparentHeader(traceContext), traceContext.State.String()
+}
+
+// ParseTraceparent returns trace context of W3C traceparent and tracestate headers. Zero trace context is returned
+// in case traceparent is empty or invalid, so a new trace is started by the transaction.
+func ParseTraceparent(traceparent string, tracestate string) apm.TraceContext {
+	traceContext, err := apmhttp.ParseTraceparentHeader(traceparent)
+	if err != nil {
+		return apm.TraceContext{}
+	}
+
+	if tracestate != "" {
+		traceContext.State, _ = apmhttp.ParseTracestateHeader(tracestate)
+	}
+
+	return traceContext
+}
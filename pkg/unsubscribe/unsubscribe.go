@@ -0,0 +1,64 @@
+// unsubscribe package contains signed tokens for one-click unsubscribe links. Token identifies the user without
+// authentication, so it's signed with the secret shared by services which generate and verify links.
+package unsubscribe
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidToken is returned when token is malformed or its signature doesn't match.
+var ErrInvalidToken = errors.New("invalid unsubscribe token")
+
+// NewToken returns token of the user signed with secret.
+func NewToken(secret []byte, uid int) string {
+	payload := strconv.Itoa(uid)
+
+	return payload + "." + base64.RawURLEncoding.EncodeToString(sign(secret, payload))
+}
+
+// ParseToken verifies token signature and returns id of the user.
+func ParseToken(secret []byte, token string) (int, error) {
+	i := strings.LastIndex(token, ".")
+	if i < 0 {
+		return 0, errors.WithStack(ErrInvalidToken)
+	}
+
+	payload := token[:i]
+
+	signature, err := base64.RawURLEncoding.DecodeString(token[i+1:])
+	if err != nil || !hmac.Equal(signature, sign(secret, payload)) {
+		return 0, errors.WithStack(ErrInvalidToken)
+	}
+
+	uid, err := strconv.Atoi(payload)
+	if err != nil || uid <= 0 {
+		return 0, errors.WithStack(ErrInvalidToken)
+	}
+
+	return uid, nil
+}
+
+// URL returns unsubscribe link of the user. baseURL is the address of unsubscribe endpoint.
+func URL(baseURL string, secret []byte, uid int) string {
+	separator := "?"
+	if strings.Contains(baseURL, "?") {
+		separator = "&"
+	}
+
+	return baseURL + separator + "token=" + url.QueryEscape(NewToken(secret, uid))
+}
+
+func sign(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	// domain separation, so the same secret can't be used for signing tokens of other kinds
+	mac.Write([]byte("unsubscribe:" + payload))
+
+	return mac.Sum(nil)
+}
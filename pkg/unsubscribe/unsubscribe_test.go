@@ -0,0 +1,52 @@
+package unsubscribe
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestToken(t *testing.T) {
+	secret := []byte("secret")
+
+	uid, err := ParseToken(secret, NewToken(secret, 42))
+	if err != nil {
+		t.Fatalf("got: %s, want: nil", err)
+	}
+
+	if uid != 42 {
+		t.Fatalf("got: %d, want: 42", uid)
+	}
+
+	invalid := []string{
+		"",
+		"42",
+		NewToken([]byte("another secret"), 42),
+		strings.Replace(NewToken(secret, 42), "42.", "43.", 1),
+		NewToken(secret, 0),
+	}
+
+	for _, token := range invalid {
+		if _, err = ParseToken(secret, token); !errors.Is(err, ErrInvalidToken) {
+			t.Fatalf("token %q: got: %v, want: %v", token, err, ErrInvalidToken)
+		}
+	}
+}
+
+func TestURL(t *testing.T) {
+	secret := []byte("secret")
+
+	u, err := url.Parse(URL("https://example.com/user/unsubscribe?list=digest", secret, 7))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u.Query().Get("list") != "digest" {
+		t.Fatalf("got query: %s", u.RawQuery)
+	}
+
+	if uid, err := ParseToken(secret, u.Query().Get("token")); err != nil || uid != 7 {
+		t.Fatalf("got: %d, %v, want: 7, nil", uid, err)
+	}
+}
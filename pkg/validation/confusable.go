@@ -0,0 +1,84 @@
+package validation
+
+import (
+	"strings"
+)
+
+// confusables maps letters of other alphabets and scripts to latin letters or digits they look like. It's a subset
+// of Unicode confusables (UTS #39) for letters which are used in logins.
+var confusables = map[rune]rune{
+	// cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p', 'с': 'c', 'т': 't',
+	'у': 'y', 'х': 'x', 'і': 'i', 'ј': 'j', 'ѕ': 's', 'ԁ': 'd', 'һ': 'h', 'ӏ': 'l', 'ԛ': 'q', 'ԝ': 'w',
+	'А': 'A', 'В': 'B', 'Е': 'E', 'К': 'K', 'М': 'M', 'Н': 'H', 'О': 'O', 'Р': 'P', 'С': 'C', 'Т': 'T',
+	'У': 'Y', 'Х': 'X', 'І': 'I', 'Ј': 'J', 'Ѕ': 'S',
+	// greek
+	'α': 'a', 'ο': 'o', 'ρ': 'p', 'ν': 'v', 'τ': 't', 'ι': 'i', 'κ': 'k', 'υ': 'u', 'χ': 'x',
+	'Α': 'A', 'Β': 'B', 'Ε': 'E', 'Ζ': 'Z', 'Η': 'H', 'Ι': 'I', 'Κ': 'K', 'Μ': 'M', 'Ν': 'N', 'Ο': 'O',
+	'Ρ': 'P', 'Τ': 'T', 'Υ': 'Y', 'Χ': 'X',
+	// latin lookalikes
+	'ı': 'i', 'ɡ': 'g', 'ɑ': 'a', 'ℓ': 'l',
+}
+
+// asciiConfusables contains sequences of latin letters and digits which look the same in most fonts. They are
+// replaced with the first element of each pair, in order.
+var asciiConfusables = strings.NewReplacer(
+	"rn", "m",
+	"vv", "w",
+	"0", "o",
+	"1", "l",
+	"i", "l",
+	"|", "l",
+	"5", "s",
+	"_", "",
+	"-", "",
+	".", "",
+)
+
+// Skeleton returns form of s which is the same for strings that look alike, for example "admin", "AdMln", "аdmin"
+// (with cyrillic "а") and "adm1n". Fullwidth forms are converted to ASCII. It's used only for comparison.
+func Skeleton(s string) string {
+	var b strings.Builder
+
+	for _, r := range s {
+		switch {
+		case r >= 'Ａ' && r <= 'Ｚ':
+			r = r - 'Ａ' + 'A'
+		case r >= 'ａ' && r <= 'ｚ':
+			r = r - 'ａ' + 'a'
+		case r >= '０' && r <= '９':
+			r = r - '０' + '0'
+		}
+
+		if l, ok := confusables[r]; ok {
+			r = l
+		}
+
+		b.WriteRune(r)
+	}
+
+	return asciiConfusables.Replace(strings.ToLower(b.String()))
+}
+
+// Reserved is a set of words which can't be used as logins, for example names of routes of frontend.
+type Reserved map[string]struct{}
+
+// Contains reports whether login looks like one of reserved words.
+func (r Reserved) Contains(login string) bool {
+	_, ok := r[Skeleton(login)]
+
+	return ok
+}
+
+// NewReserved returns set of reserved words. Empty words are skipped.
+func NewReserved(words []string) Reserved {
+	r := make(Reserved, len(words))
+
+	for _, w := range words {
+		if w = strings.TrimSpace(w); w != "" {
+			r[Skeleton(w)] = struct{}{}
+		}
+	}
+
+	return r
+}
@@ -0,0 +1,96 @@
+// validation package contains rules of logins and emails. They are shared by all places where login or email is set.
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	LoginMinLen = 3
+	LoginMaxLen = 30
+	// EmailMaxLen is the maximum length of email address (RFC 5321).
+	EmailMaxLen = 254
+)
+
+// Codes of validation errors.
+const (
+	CodeRequired   = "required"
+	CodeLength     = "length"
+	CodeFormat     = "format"
+	CodeConfusable = "confusable"
+	CodeInvalid    = "invalid"
+)
+
+// Error is an error of validation with machine-readable code.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e Error) Error() string {
+	return e.Message
+}
+
+// ErrorCode returns code of the error.
+func (e Error) ErrorCode() string {
+	return e.Code
+}
+
+// Login validates format and length of login. Login can contain only latin letters, digits and underscores, so
+// it's readable in URLs and can't be spoofed with letters of other alphabets.
+func Login(login string) error {
+	if login == "" {
+		return Error{Code: CodeRequired, Message: "login should not be empty"}
+	}
+
+	if n := utf8.RuneCountInString(login); n < LoginMinLen || n > LoginMaxLen {
+		return Error{
+			Code:    CodeLength,
+			Message: fmt.Sprintf("login length should be from %d to %d symbols", LoginMinLen, LoginMaxLen),
+		}
+	}
+
+	for _, r := range login {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			continue
+		}
+
+		if l, ok := confusables[r]; ok {
+			return Error{
+				Code:    CodeConfusable,
+				Message: fmt.Sprintf("login contains %q which looks like latin %q. please, use latin letters", r, l),
+			}
+		}
+
+		return Error{Code: CodeFormat, Message: "login can contain only latin letters, digits and underscores"}
+	}
+
+	return nil
+}
+
+// Email validates syntax of email address. Only bare address is accepted, without display name.
+func Email(email string) error {
+	if email == "" {
+		return Error{Code: CodeRequired, Message: "email should not be empty"}
+	}
+
+	if len(email) > EmailMaxLen {
+		return Error{Code: CodeLength, Message: fmt.Sprintf("email length should be less than %d symbols", EmailMaxLen)}
+	}
+
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || address.Name != "" {
+		return Error{Code: CodeInvalid, Message: "email is invalid"}
+	}
+
+	// addresses without top-level domain (for example "john@localhost") are valid, but can't be delivered
+	domain := email[strings.LastIndex(email, "@")+1:]
+	if !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return Error{Code: CodeInvalid, Message: "email is invalid"}
+	}
+
+	return nil
+}
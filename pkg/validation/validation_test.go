@@ -0,0 +1,66 @@
+package validation
+
+import (
+	"testing"
+)
+
+func TestLogin(t *testing.T) {
+	cases := map[string]string{
+		"john_doe":                        "",
+		"John1":                           "",
+		"":                                "login should not be empty",
+		"jo":                              "login length should be from 3 to 30 symbols",
+		"abcdefghijklmnopqrstuvwxyz12345": "login length should be from 3 to 30 symbols",
+		"john.doe":                        "login can contain only latin letters, digits and underscores",
+		"jöhn":                            "login can contain only latin letters, digits and underscores",
+		"jоhn":                            `login contains 'о' which looks like latin 'o'. please, use latin letters`,
+	}
+
+	for login, want := range cases {
+		err := Login(login)
+		if want == "" && err != nil {
+			t.Fatalf("%s: got: %s, want: nil", login, err)
+		}
+
+		if want != "" && (err == nil || err.Error() != want) {
+			t.Fatalf("%s: got: %v, want: %s", login, err, want)
+		}
+	}
+
+	if err := Login("jоhn"); err.(Error).ErrorCode() != CodeConfusable {
+		t.Fatalf("got: %s, want: %s", err.(Error).ErrorCode(), CodeConfusable)
+	}
+}
+
+func TestEmail(t *testing.T) {
+	valid := []string{"john@example.com", "john.doe+tag@mail.example.co.uk"}
+	invalid := []string{"", "john", "john@", "john@localhost", "John <john@example.com>", "john@example.", "a b@c.de"}
+
+	for _, email := range valid {
+		if err := Email(email); err != nil {
+			t.Fatalf("%s: got: %s, want: nil", email, err)
+		}
+	}
+
+	for _, email := range invalid {
+		if err := Email(email); err == nil {
+			t.Fatalf("%s: got: nil, want: error", email)
+		}
+	}
+}
+
+func TestReserved(t *testing.T) {
+	r := NewReserved([]string{"admin", " settings ", ""})
+
+	for _, login := range []string{"admin", "ADMIN", "adm1n", "AdmIn", "аdmin", "ad_min", "ａｄｍｉｎ", "settings", "setting5"} {
+		if !r.Contains(login) {
+			t.Fatalf("%s: got: false, want: true", login)
+		}
+	}
+
+	for _, login := range []string{"administrator", "john", ""} {
+		if r.Contains(login) {
+			t.Fatalf("%s: got: true, want: false", login)
+		}
+	}
+}